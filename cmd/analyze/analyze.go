@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -12,11 +14,10 @@ import (
 	"time"
 
 	cute "cute/pkg/cute"
+	"cute/pkg/cute/openingdb"
 
-	"github.com/expr-lang/expr"
 	"github.com/expr-lang/expr/vm"
 	"github.com/xitongsys/parquet-go-source/local"
-	"github.com/xitongsys/parquet-go/reader"
 )
 
 type scenario struct {
@@ -27,48 +28,206 @@ type scenario struct {
 
 type stats struct {
 	totalGames    int
+	decisiveGames int
 	crossings     int
 	wins          int
 	excludedGames int
+	// crossingPlies holds the ply of each counted crossing and
+	// crossingEvals its |eval| in cp (mate crossings have none).
+	crossingPlies []int
+	crossingEvals []int
+}
+
+// add counts a game of the player on side. Games without a crossing or a
+// decisive result are excluded; the rest count toward totalGames when the
+// player crossed first, or when countOthers is set (a crossing-side filter
+// is active) even if the opponent did. Every decisive game counts toward
+// decisiveGames, the denominator of the crossing rate.
+func (st *stats) add(side string, cross cute.Crossing, resultSide string, countOthers bool) {
+	if resultSide != "none" {
+		st.decisiveGames++
+	}
+	switch {
+	case cross.Side == "none" || resultSide == "none":
+		st.excludedGames++
+	case cross.Side == side:
+		st.totalGames++
+		st.addCrossing(cross)
+		if resultSide == side {
+			st.wins++
+		}
+	case countOthers:
+		st.totalGames++
+	}
+}
+
+// crossingRate is the share of the player's decisive games in which the
+// player crossed first.
+func (st *stats) crossingRate() float64 {
+	if st.decisiveGames == 0 {
+		return 0
+	}
+	return float64(st.crossings) / float64(st.decisiveGames)
+}
+
+// addCrossing counts a crossing by the player.
+func (st *stats) addCrossing(cross cute.Crossing) {
+	st.crossings++
+	st.crossingPlies = append(st.crossingPlies, cross.Ply)
+	if cross.Eval.ScoreType != "mate" {
+		value := int(cross.Eval.ScoreValue)
+		st.crossingEvals = append(st.crossingEvals, max(value, -value))
+	}
+}
+
+// crossingSummary returns the median crossing ply and the mean |eval| at
+// the crossing (0 without crossings).
+func (st *stats) crossingSummary() (plyMedian int, evalMean float64) {
+	plies := append([]int(nil), st.crossingPlies...)
+	sort.Ints(plies)
+	for _, v := range st.crossingEvals {
+		evalMean += float64(v)
+	}
+	if len(st.crossingEvals) > 0 {
+		evalMean /= float64(len(st.crossingEvals))
+	}
+	return percentile(plies, 0.5), evalMean
 }
 
 // main parses CLI flags and prints CSV stats for eval threshold crossings.
 func main() {
-	inputPath := flag.String("input", "output.parquet", "input parquet file")
-	thresholdsArg := flag.String("thresholds", "300,500,1000", "comma-separated eval thresholds")
+	inputPath := flag.String("input", "output.parquet", "input parquet file or glob (more may be given as arguments, e.g. the part files of a sharded graph run)")
+	thresholdsArg := flag.String("thresholds", "300,500,1000", "comma-separated eval thresholds (win percentages, e.g. 70,80,90, with -win-prob)")
+	winProb := flag.Bool("win-prob", false, "read -thresholds as the win probability in percent of the side ahead (see -win-prob-scaling) instead of cp, which compares better across ratings")
+	winProbScaling := flag.Float64("win-prob-scaling", cute.DefaultWinProbScaling, "cp scale of the logistic cp to win probability conversion of -win-prob")
 	ratingDiffMax := flag.Int("rating-diff-max", 50, "max rating difference between players")
 	ignoreFirstMoves := flag.Int("ignore-first-moves", 0, "ignore evals up to this move number (0=disabled)")
-	binSize := flag.Int("player-bin-size", 100, "player rating bucket size")
+	mateCrossing := flag.Bool("mate-crossing", true, "count a mate score as a crossing for the mating side whatever the threshold (false skips mate scores)")
+	crossingStability := flag.Int("crossing-stability", 0, "only count a crossing that holds for this many following evals")
+	binSize := flag.Int("player-bin-size", 100, "player rating bucket size of -bucket-mode fixed")
+	bucketMode := flag.String("bucket-mode", "fixed", "player rating buckets: fixed (-player-bin-size wide) or equal-count (-buckets quantile buckets of about the same number of players, boundaries printed to stderr)")
+	bucketCount := flag.Int("buckets", 5, "number of player rating buckets of -bucket-mode equal-count")
 	playerMin := flag.Int("player-min", 0, "minimum player rating (0 to auto-detect)")
 	playerMax := flag.Int("player-max", 0, "maximum player rating (0 to auto-detect)")
 	parallel := flag.Int64("parallel", 4, "parquet read parallelism")
 	openingDB := flag.String("opening-db", "", "strategy classification parquet file for opening filter")
-	filterExpr := flag.String("filter", "", `expr filter on opening DB (e.g. 'has(sente.attack, "四間飛車") && has(gote.note, "居飛車")')`)
+	filterExpr := flag.String("filter", "", `expr filter on opening tags from -opening-db, or from the tag columns embedded by graph when -opening-db is not given (e.g. 'has(sente.attack, "四間飛車") && has(gote.note, "居飛車")')`)
 	crossingSideFilter := flag.String("crossing-side-filter", "", `expr per-player filter to restrict which side's crossings to count (e.g. 'has(attack, "四間飛車")')`)
+	format := flag.String("format", "text", "output format: text (CSV block per threshold), csv, json, parquet, arrow (tidy long format)")
+	outputPath := flag.String("output", "", "output file for csv/json/parquet/arrow (default stdout; required for parquet and arrow)")
+	excludeWinReasons := flag.String("exclude-win-reasons", "", "comma-separated win_reason values whose games are left out (e.g. 切れ負け,反則負け: time-loss wins after falling behind inflate comeback rates)")
+	recordFilter := flag.String("record-filter", "", `expr filter on GameRecord fields (e.g. 'move_count > 80 && win_reason == "投了"')`)
+	mode := flag.String("mode", "crossing", "crossing (win rate after crossing the threshold first), comeback (win rate after the opponent crossed first), sweep (crossing rate, win rate and ROC point for each threshold from -sweep-from to -sweep-to, in one pass) or tags (crossing and conversion rates per opening tag with odds ratios against the players without it)")
+	sweepFrom := flag.Int("sweep-from", 100, "first threshold of -mode sweep")
+	sweepTo := flag.Int("sweep-to", 1500, "last threshold of -mode sweep")
+	sweepStep := flag.Int("sweep-step", 50, "threshold step of -mode sweep")
+	tagCategory := flag.String("tag-category", "attack", "tag category of -mode tags: attack, defense, technique or note (technique and note need -opening-db)")
+	tagMinGames := flag.Int("tag-min-games", 30, "leave out tags with fewer decisive games in -mode tags")
+	reversalBinSize := flag.Int("reversal-bin-size", 20, "ply bucket size of the reversal histogram in comeback mode")
+	groupByArg := flag.String("group-by", "", "comma-separated dimensions to aggregate by instead of the rating-bucket table: rating_bucket, side, result, win_reason, move_count_bucket")
+	moveCountBinSize := flag.Int("move-count-bin-size", 50, "move count bucket size for -group-by move_count_bucket")
+	featuresOutput := flag.String("features-output", "", "also write per-game eval trajectory features (max/min eval, eval at plies 20/40/60, sign flips, volatility) of the filtered games to this parquet file (Arrow IPC when it ends in .arrow or .feather)")
+	gameFilter := cute.AddGameFilterFlags(flag.CommandLine)
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
 	flag.Parse()
+	if _, err := cute.LoadCommandConfig("analyze", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
 
 	thresholds, err := parseIntList(*thresholdsArg)
 	if err != nil {
 		fatal(err)
 	}
+	if *mode == "sweep" {
+		if *sweepStep <= 0 || *sweepFrom <= 0 || *sweepTo < *sweepFrom {
+			fatal(fmt.Errorf("sweep needs 0 < sweep-from <= sweep-to and sweep-step > 0"))
+		}
+		thresholds = sweepThresholds(*sweepFrom, *sweepTo, *sweepStep)
+	}
 	if len(thresholds) == 0 {
 		fatal(fmt.Errorf("thresholds must be non-empty"))
 	}
+	if *winProb {
+		if *winProbScaling <= 0 {
+			fatal(fmt.Errorf("win-prob-scaling must be > 0"))
+		}
+		for _, threshold := range thresholds {
+			if threshold <= 50 || threshold >= 100 {
+				fatal(fmt.Errorf("win-prob thresholds must be between 50 and 100 (exclusive), got %d", threshold))
+			}
+		}
+	}
 	if *binSize <= 0 {
 		fatal(fmt.Errorf("player-bin-size must be > 0"))
 	}
+	switch *bucketMode {
+	case "fixed":
+	case "equal-count":
+		if *bucketCount <= 0 {
+			fatal(fmt.Errorf("buckets must be > 0"))
+		}
+	default:
+		fatal(fmt.Errorf("bucket-mode must be fixed or equal-count"))
+	}
+	if err := gameFilter.Validate(); err != nil {
+		fatal(err)
+	}
 	if *ratingDiffMax < 0 {
 		fatal(fmt.Errorf("rating-diff-max must be >= 0"))
 	}
 	if *ignoreFirstMoves < 0 {
 		fatal(fmt.Errorf("ignore-first-moves must be >= 0"))
 	}
+	switch *format {
+	case "text", "csv", "json", "parquet", "arrow":
+	default:
+		fatal(fmt.Errorf("format must be one of text, csv, json, parquet, arrow"))
+	}
+	groupBy, err := parseGroupBy(*groupByArg)
+	if err != nil {
+		fatal(err)
+	}
+	switch *mode {
+	case "crossing", "comeback", "sweep", "tags":
+	default:
+		fatal(fmt.Errorf("mode must be crossing, comeback, sweep or tags"))
+	}
+	if *mode != "crossing" && *groupByArg != "" {
+		fatal(fmt.Errorf("-group-by is not supported with -mode %s", *mode))
+	}
+	if *mode == "tags" {
+		switch *tagCategory {
+		case "attack", "defense", "technique", "note":
+		default:
+			fatal(fmt.Errorf("tag-category must be attack, defense, technique or note"))
+		}
+		if (*tagCategory == "technique" || *tagCategory == "note") && *openingDB == "" {
+			fatal(fmt.Errorf("-tag-category %s needs -opening-db: graph embeds only attack and defense tags", *tagCategory))
+		}
+		if *crossingSideFilter != "" {
+			fatal(fmt.Errorf("-crossing-side-filter is not supported with -mode tags"))
+		}
+	}
+	if *reversalBinSize <= 0 {
+		fatal(fmt.Errorf("reversal-bin-size must be > 0"))
+	}
+	if *moveCountBinSize <= 0 {
+		fatal(fmt.Errorf("move-count-bin-size must be > 0"))
+	}
+	var recordProgram *vm.Program
+	if *recordFilter != "" {
+		if recordProgram, err = cute.CompileRecordFilter(*recordFilter); err != nil {
+			fatal(err)
+		}
+	}
 
 	filter := *filterExpr
 	allowedIDs := make(map[string]bool)
 	// crossingSideMap: game_id -> which side's crossings to count.
 	// "sente", "gote", or "both". Empty map means count all sides.
 	crossingSides := make(map[string]string)
+	// taggedIDs: the games with opening tags, matching or not.
+	var taggedIDs map[string]bool
 
 	if *openingDB != "" {
 		// Build filter from shorthand flags if --filter is not set.
@@ -78,29 +237,114 @@ func main() {
 				filter = strings.Join(parts, " && ")
 			}
 		}
-		if filter == "" {
+		if filter == "" && *mode != "tags" {
 			fatal(fmt.Errorf("--opening-db requires --filter"))
 		}
-
+	}
+	if *openingDB != "" && filter != "" {
 		fmt.Fprintf(os.Stderr, "filter: %s\n", filter)
-		var err2 error
-		allowedIDs, crossingSides, err2 = loadOpeningFilter(*openingDB, filter, *crossingSideFilter, *parallel)
-		if err2 != nil {
-			fatal(fmt.Errorf("opening-db: %w", err2))
+		if *crossingSideFilter != "" {
+			fmt.Fprintf(os.Stderr, "crossing-side-filter: %s\n", *crossingSideFilter)
+		}
+		f, err := openingdb.LoadFilter(*openingDB, filter, *crossingSideFilter, *parallel)
+		if err != nil {
+			fatal(fmt.Errorf("opening-db: %w", err))
 		}
+		allowedIDs, crossingSides, taggedIDs = f.AllowedIDs, f.CrossingSides, f.Tagged
 	}
 
-	records, err := readParquet(*inputPath, *parallel)
+	// The default -input is only used when no files are given as
+	// arguments.
+	inputArgs := flag.Args()
+	inputSet := false
+	flag.Visit(func(f *flag.Flag) { inputSet = inputSet || f.Name == "input" })
+	if inputSet || len(inputArgs) == 0 {
+		inputArgs = append([]string{*inputPath}, inputArgs...)
+	}
+	inputs, err := expandInputs(inputArgs)
+	if err != nil {
+		fatal(err)
+	}
+	inputName := strings.Join(inputs, ", ")
+	records, engines, err := readInputs(inputs, *parallel)
 	if err != nil {
 		fatal(err)
 	}
+	if len(engines) > 1 {
+		fmt.Fprintf(os.Stderr, "warning: %s mixes evals from %d engine setups:\n", inputName, len(engines))
+		for _, e := range engines {
+			fmt.Fprintf(os.Stderr, "  %s (%s) movetime=%dms FV_SCALE=%s", e.Name, e.Binary, e.Policy.MoveTimeMs, e.FVScale())
+			if eval := e.EvalFile(); eval != "" {
+				fmt.Fprintf(os.Stderr, " eval=%s", eval)
+			}
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+
+	excluded := newExclusions(len(records))
+	if recordProgram != nil {
+		total := len(records)
+		if records, err = cute.FilterRecords(records, recordProgram); err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "record filter: %d/%d games match\n", len(records), total)
+		excluded.remove(excludedRecordFilter, total-len(records))
+	}
+	if reasons := openingdb.SplitTags(*excludeWinReasons); len(reasons) > 0 {
+		total := len(records)
+		filtered := records[:0]
+		for _, r := range records {
+			if !slices.Contains(reasons, r.WinReason) {
+				filtered = append(filtered, r)
+			}
+		}
+		records = filtered
+		fmt.Fprintf(os.Stderr, "exclude-win-reasons: %d/%d games left out\n", total-len(records), total)
+		excluded.remove(excludedWinReason, total-len(records))
+	}
+	if gameFilter.Active() {
+		total := len(records)
+		records = gameFilter.Filter(records)
+		fmt.Fprintf(os.Stderr, "move count/phase filter: %d/%d games match\n", len(records), total)
+		excluded.remove(excludedGameFilter, total-len(records))
+	}
+
+	// Without -opening-db, -filter uses the tags graph embedded in the
+	// eval parquet.
+	if *openingDB == "" && filter != "" {
+		fmt.Fprintf(os.Stderr, "filter (embedded opening tags): %s\n", filter)
+		if *crossingSideFilter != "" {
+			fmt.Fprintf(os.Stderr, "crossing-side-filter: %s\n", *crossingSideFilter)
+		}
+		f, err := openingdb.NewFilter(filter, *crossingSideFilter)
+		if err != nil {
+			fatal(err)
+		}
+		tagged := 0
+		for _, r := range records {
+			if r.SenteAttackTags != "" || r.SenteDefenseTags != "" || r.GoteAttackTags != "" || r.GoteDefenseTags != "" {
+				tagged++
+			}
+			f.Add(openingdb.FromGameRecord(r))
+		}
+		if tagged == 0 {
+			fmt.Fprintf(os.Stderr, "warning: %s has no opening tags; run graph with -opening-db or -classify, or pass -opening-db here\n", inputName)
+		}
+		allowedIDs, crossingSides, taggedIDs = f.AllowedIDs, f.CrossingSides, f.Tagged
+	}
 
 	// Filter by opening tags if specified.
-	if *openingDB != "" {
+	if filter != "" {
 		filtered := records[:0]
 		for _, r := range records {
-			if allowedIDs[normalizeGameID(r.GameID)] {
+			gid := openingdb.NormalizeGameID(r.GameID)
+			switch {
+			case allowedIDs[gid]:
 				filtered = append(filtered, r)
+			case taggedIDs[gid]:
+				excluded.remove(excludedOpeningFilter, 1)
+			default:
+				excluded.remove(excludedNoOpening, 1)
 			}
 		}
 		fmt.Fprintf(os.Stderr, "opening filter: %d/%d games match\n",
@@ -108,6 +352,13 @@ func main() {
 		records = filtered
 	}
 
+	if *featuresOutput != "" {
+		if err := writeFeatures(*featuresOutput, records); err != nil {
+			fatal(fmt.Errorf("features-output: %w", err))
+		}
+		fmt.Fprintf(os.Stderr, "wrote features of %d games to %s\n", len(records), *featuresOutput)
+	}
+
 	minRating, maxRating := ratingMinMax(records)
 	if *playerMin > 0 {
 		minRating = *playerMin
@@ -115,14 +366,208 @@ func main() {
 	if *playerMax > 0 {
 		maxRating = *playerMax
 	}
-	scenarios := buildScenarios(thresholds, minRating, maxRating, *binSize)
+	buckets := fixedBuckets(minRating, maxRating, *binSize)
+	if *bucketMode == "equal-count" {
+		// Quantiles of the players that can be counted: both sides of
+		// the games within -rating-diff-max, inside the rating range.
+		var ratings []int
+		for _, record := range records {
+			if int(math.Abs(float64(record.SenteRating-record.GoteRating))) > *ratingDiffMax {
+				continue
+			}
+			for _, rating := range []int{int(record.SenteRating), int(record.GoteRating)} {
+				if rating >= minRating && rating <= maxRating {
+					ratings = append(ratings, rating)
+				}
+			}
+		}
+		buckets = equalCountBuckets(ratings, *bucketCount)
+		fmt.Fprintf(os.Stderr, "rating buckets (equal-count over %d players): %s\n", len(ratings), buckets)
+	}
+	hasCrossingSideFilter := len(crossingSides) > 0
+	// A win-probability threshold is crossed exactly when its cp
+	// equivalent is, so crossings are still found on cp scores.
+	crossing := func(threshold int) cute.CrossingOptions {
+		if *winProb {
+			threshold = cute.WinProbToCP(float64(threshold)/100, *winProbScaling)
+		}
+		return cute.CrossingOptions{
+			Threshold:         threshold,
+			IgnorePlies:       *ignoreFirstMoves,
+			MateCountsAsCross: *mateCrossing,
+			RequireStability:  *crossingStability,
+		}
+	}
+	if *mode != "tags" {
+		// Sweep mode counts the games without a crossing.
+		var firstCrossings func(cute.GameRecord) []cute.Crossing
+		if *mode != "sweep" {
+			opts := crossing(thresholds[0]) // Threshold is ignored
+			cpThresholds := make([]int, len(thresholds))
+			for i, threshold := range thresholds {
+				cpThresholds[i] = crossing(threshold).Threshold
+			}
+			firstCrossings = func(record cute.GameRecord) []cute.Crossing {
+				return cute.FirstCrossings(record.MoveEvals, opts, cpThresholds)
+			}
+		}
+		excluded.addRecords(records, *ratingDiffMax, buckets, thresholds, firstCrossings)
+		excluded.print(os.Stderr)
+	}
+	if len(groupBy) > 0 {
+		g := &grouper{
+			dims:        groupBy,
+			buckets:     buckets,
+			moveBinSize: *moveCountBinSize,
+			results:     make(map[groupKey]*stats),
+		}
+		for _, record := range records {
+			ratingDiff := int(math.Abs(float64(record.SenteRating - record.GoteRating)))
+			if ratingDiff > *ratingDiffMax {
+				continue
+			}
+			countSente, countGote := true, true
+			if hasCrossingSideFilter {
+				side := crossingSides[openingdb.NormalizeGameID(record.GameID)]
+				countSente = side == "sente" || side == "both"
+				countGote = side == "gote" || side == "both"
+			}
+			resultSide := winnerSide(record.Result)
+			for _, threshold := range thresholds {
+				cross := cute.FirstCrossing(record.MoveEvals, crossing(threshold))
+				if countSente {
+					g.add(record, "sente", threshold, cross, resultSide, hasCrossingSideFilter)
+				}
+				if countGote {
+					g.add(record, "gote", threshold, cross, resultSide, hasCrossingSideFilter)
+				}
+			}
+		}
+		if err := writeGroupRows(*format, *outputPath, groupBy, g.rows()); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *mode == "sweep" {
+		opts := crossing(thresholds[0]) // Threshold is ignored
+		cpThresholds := make([]int, len(thresholds))
+		for i, threshold := range thresholds {
+			cpThresholds[i] = crossing(threshold).Threshold
+		}
+		s := newSweeper(thresholds, buckets)
+		for _, record := range records {
+			ratingDiff := int(math.Abs(float64(record.SenteRating - record.GoteRating)))
+			if ratingDiff > *ratingDiffMax {
+				continue
+			}
+			countSente, countGote := true, true
+			if hasCrossingSideFilter {
+				side := crossingSides[openingdb.NormalizeGameID(record.GameID)]
+				countSente = side == "sente" || side == "both"
+				countGote = side == "gote" || side == "both"
+			}
+			crossings := cute.FirstCrossings(record.MoveEvals, opts, cpThresholds)
+			resultSide := winnerSide(record.Result)
+			if countSente {
+				s.add("sente", int(record.SenteRating), crossings, resultSide)
+			}
+			if countGote {
+				s.add("gote", int(record.GoteRating), crossings, resultSide)
+			}
+		}
+		if err := writeSweepRows(*format, *outputPath, s.rows()); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *mode == "tags" {
+		lookupOpening := func(record cute.GameRecord) (openingdb.Game, bool) {
+			game := openingdb.FromGameRecord(record)
+			return game, !game.Sente.Empty() || !game.Gote.Empty()
+		}
+		if *openingDB != "" {
+			openings, err := openingdb.Load(*openingDB, *parallel)
+			if err != nil {
+				fatal(fmt.Errorf("opening-db: %w", err))
+			}
+			lookupOpening = func(record cute.GameRecord) (openingdb.Game, bool) {
+				game, ok := openings[openingdb.NormalizeGameID(record.GameID)]
+				return game, ok
+			}
+		}
+		opts := crossing(thresholds[0]) // Threshold is ignored
+		cpThresholds := make([]int, len(thresholds))
+		for i, threshold := range thresholds {
+			cpThresholds[i] = crossing(threshold).Threshold
+		}
+		s := newTagSplitter(thresholds, *tagCategory)
+		joined := 0
+		for _, record := range records {
+			ratingDiff := int(math.Abs(float64(record.SenteRating - record.GoteRating)))
+			if ratingDiff > *ratingDiffMax {
+				continue
+			}
+			game, ok := lookupOpening(record)
+			if !ok {
+				continue
+			}
+			joined++
+			crossings := cute.FirstCrossings(record.MoveEvals, opts, cpThresholds)
+			resultSide := winnerSide(record.Result)
+			s.add("sente", game, crossings, resultSide)
+			s.add("gote", game, crossings, resultSide)
+		}
+		fmt.Fprintf(os.Stderr, "tags: %d/%d games have opening tags\n", joined, len(records))
+		if err := writeTagRows(*format, *outputPath, s.rows(*tagMinGames)); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	scenarios := buildScenarios(thresholds, buckets)
+	if *mode == "comeback" {
+		comebacks := make(map[scenario]*comebackStats, len(scenarios))
+		for _, sc := range scenarios {
+			comebacks[sc] = &comebackStats{}
+		}
+		for _, record := range records {
+			ratingDiff := int(math.Abs(float64(record.SenteRating - record.GoteRating)))
+			if ratingDiff > *ratingDiffMax {
+				continue
+			}
+			countSente, countGote := true, true
+			if hasCrossingSideFilter {
+				side := crossingSides[openingdb.NormalizeGameID(record.GameID)]
+				countSente = side == "sente" || side == "both"
+				countGote = side == "gote" || side == "both"
+			}
+			resultSide := winnerSide(record.Result)
+			for _, sc := range scenarios {
+				cross := cute.FirstCrossing(record.MoveEvals, crossing(sc.threshold))
+				if countSente && inBucket(int(record.SenteRating), sc) {
+					comebacks[sc].add(record, "sente", cross.Side, cross.Ply, resultSide)
+				}
+				if countGote && inBucket(int(record.GoteRating), sc) {
+					comebacks[sc].add(record, "gote", cross.Side, cross.Ply, resultSide)
+				}
+			}
+		}
+		if *format == "text" {
+			printComebackText(os.Stdout, scenarios, comebacks, *reversalBinSize)
+			return
+		}
+		if err := writeComebackRows(*format, *outputPath, buildComebackRows(scenarios, comebacks)); err != nil {
+			fatal(err)
+		}
+		return
+	}
 	results := make(map[scenario]*stats, len(scenarios))
 	for _, sc := range scenarios {
 		results[sc] = &stats{}
 	}
 
-	hasCrossingSideFilter := len(crossingSides) > 0
-
 	for _, record := range records {
 		ratingDiff := int(math.Abs(float64(record.SenteRating - record.GoteRating)))
 		if ratingDiff > *ratingDiffMax {
@@ -132,47 +577,29 @@ func main() {
 		countSente := true
 		countGote := true
 		if hasCrossingSideFilter {
-			side := crossingSides[normalizeGameID(record.GameID)]
+			side := crossingSides[openingdb.NormalizeGameID(record.GameID)]
 			countSente = side == "sente" || side == "both"
 			countGote = side == "gote" || side == "both"
 		}
 		for _, sc := range scenarios {
-			crossingSide := firstCrossingSide(record.MoveEvals, sc.threshold, *ignoreFirstMoves)
+			cross := cute.FirstCrossing(record.MoveEvals, crossing(sc.threshold))
 			resultSide := winnerSide(record.Result)
 			if countSente && inBucket(int(record.SenteRating), sc) {
-				st := results[sc]
-				if crossingSide == "none" || resultSide == "none" {
-					st.excludedGames++
-				} else if crossingSide == "sente" {
-					st.totalGames++
-					st.crossings++
-					if resultSide == "sente" {
-						st.wins++
-					}
-				} else if hasCrossingSideFilter {
-					// Count games where the filtered side didn't cross first.
-					st.totalGames++
-				}
+				results[sc].add("sente", cross, resultSide, hasCrossingSideFilter)
 			}
 			if countGote && inBucket(int(record.GoteRating), sc) {
-				st := results[sc]
-				if crossingSide == "none" || resultSide == "none" {
-					st.excludedGames++
-				} else if crossingSide == "gote" {
-					st.totalGames++
-					st.crossings++
-					if resultSide == "gote" {
-						st.wins++
-					}
-				} else if hasCrossingSideFilter {
-					// Count games where the filtered side didn't cross first.
-					st.totalGames++
-				}
+				results[sc].add("gote", cross, resultSide, hasCrossingSideFilter)
 			}
 		}
 	}
 
-	printCSV(scenarios, results, hasCrossingSideFilter)
+	if *format == "text" {
+		printCSV(os.Stdout, scenarios, results, hasCrossingSideFilter)
+		return
+	}
+	if err := writeRows(*format, *outputPath, buildRows(scenarios, results)); err != nil {
+		fatal(err)
+	}
 }
 
 // readParquet loads all GameRecord rows from a parquet file.
@@ -184,7 +611,7 @@ func readParquet(path string, parallel int64) ([]cute.GameRecord, error) {
 	}
 	defer fileReader.Close()
 
-	parquetReader, err := reader.NewParquetReader(fileReader, new(cute.GameRecord), parallel)
+	parquetReader, err := cute.NewGameRecordReader(fileReader, parallel)
 	if err != nil {
 		return nil, err
 	}
@@ -231,16 +658,15 @@ func readParquet(path string, parallel int64) ([]cute.GameRecord, error) {
 }
 
 // buildScenarios creates per-bucket scenarios for each eval threshold.
-// thresholds: eval thresholds to test; minRating/maxRating: inclusive bounds for buckets; binSize: bucket width.
-func buildScenarios(thresholds []int, minRating, maxRating, binSize int) []scenario {
+// thresholds: eval thresholds to test; buckets: player rating buckets.
+func buildScenarios(thresholds []int, buckets ratingBuckets) []scenario {
 	var scenarios []scenario
-	for bucketStart := minRating; bucketStart <= maxRating; bucketStart += binSize {
-		bucketEnd := bucketStart + binSize
+	for i := 0; i+1 < len(buckets); i++ {
 		for _, threshold := range thresholds {
 			scenarios = append(scenarios, scenario{
 				threshold:  threshold,
-				bucketFrom: bucketStart,
-				bucketTo:   bucketEnd,
+				bucketFrom: buckets[i],
+				bucketTo:   buckets[i+1],
 			})
 		}
 	}
@@ -286,30 +712,6 @@ func inBucket(rating int, sc scenario) bool {
 	return rating >= sc.bucketFrom && rating < sc.bucketTo
 }
 
-// firstCrossingSide returns which side first crosses the eval threshold.
-// evals: per-move evaluations; threshold: centipawn threshold to detect.
-// ignoreFirstMoves: ignore evals up to this move number (0=disabled).
-func firstCrossingSide(evals []cute.MoveEval, threshold int, ignoreFirstMoves int) string {
-	for _, eval := range evals {
-		if ignoreFirstMoves > 0 && int(eval.Ply) <= ignoreFirstMoves {
-			continue
-		}
-		if eval.ScoreType == "mate" {
-			if eval.ScoreValue >= 0 {
-				return "sente"
-			}
-			return "gote"
-		}
-		if eval.ScoreValue >= int32(threshold) {
-			return "sente"
-		}
-		if eval.ScoreValue <= -int32(threshold) {
-			return "gote"
-		}
-	}
-	return "none"
-}
-
 // winnerSide maps result string to "sente", "gote", or "none".
 func winnerSide(result string) string {
 	switch result {
@@ -344,22 +746,23 @@ func parseIntList(raw string) ([]int, error) {
 	return values, nil
 }
 
-// printCSV writes CSV to stdout for all scenarios.
-// showCrossingRate: when true, adds total_games and crossing_rate columns.
-func printCSV(scenarios []scenario, results map[scenario]*stats, showCrossingRate bool) {
+// printCSV writes CSV to w for all scenarios.
+// showCrossingRate: when true, adds total_games, decisive_games and
+// crossing_rate columns.
+func printCSV(w io.Writer, scenarios []scenario, results map[scenario]*stats, showCrossingRate bool) {
 	currentThreshold := 0
 	first := true
 	for _, sc := range scenarios {
 		if first || sc.threshold != currentThreshold {
 			if !first {
-				fmt.Println()
+				fmt.Fprintln(w)
 			}
 			currentThreshold = sc.threshold
-			fmt.Printf("threshold=%d\n", currentThreshold)
+			fmt.Fprintf(w, "threshold=%d\n", currentThreshold)
 			if showCrossingRate {
-				fmt.Println("player_rate,total_games,crossings,crossing_rate,wins,win_rate")
+				fmt.Fprintln(w, "player_rate,total_games,decisive_games,crossings,crossing_rate,wins,win_rate,crossing_ply_median")
 			} else {
-				fmt.Println("player_rate,crossings,wins,win_rate")
+				fmt.Fprintln(w, "player_rate,crossings,wins,win_rate,crossing_ply_median")
 			}
 			first = false
 		}
@@ -368,26 +771,26 @@ func printCSV(scenarios []scenario, results map[scenario]*stats, showCrossingRat
 		if st.crossings > 0 {
 			winRate = float64(st.wins) / float64(st.crossings)
 		}
+		plyMedian, _ := st.crossingSummary()
 		playerRate := fmt.Sprintf("%d-%d", sc.bucketFrom, sc.bucketTo)
 		if showCrossingRate {
-			crossingRate := 0.0
-			if st.totalGames > 0 {
-				crossingRate = float64(st.crossings) / float64(st.totalGames)
-			}
-			fmt.Printf("%s,%d,%d,%.6f,%d,%.6f\n",
+			fmt.Fprintf(w, "%s,%d,%d,%d,%.6f,%d,%.6f,%d\n",
 				playerRate,
 				st.totalGames,
+				st.decisiveGames,
 				st.crossings,
-				crossingRate,
+				st.crossingRate(),
 				st.wins,
 				winRate,
+				plyMedian,
 			)
 		} else {
-			fmt.Printf("%s,%d,%d,%.6f\n",
+			fmt.Fprintf(w, "%s,%d,%d,%.6f,%d\n",
 				playerRate,
 				st.crossings,
 				st.wins,
 				winRate,
+				plyMedian,
 			)
 		}
 	}
@@ -398,225 +801,3 @@ func fatal(err error) {
 	fmt.Fprintln(os.Stderr, err)
 	os.Exit(1)
 }
-
-// openingRecord matches the strategy classification parquet schema from classify_kif_to_db.rb.
-// All fields are OPTIONAL because the Ruby parquet gem writes nullable columns.
-// Supports both kif_tags.parquet (11 cols) and 6_senkei.parquet (15 cols) layouts.
-type openingRecord struct {
-	GameID             *string `parquet:"name=game_id, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	GameType           *string `parquet:"name=game_type, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	SenteName          *string `parquet:"name=sente_name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	SenteRating        *int32  `parquet:"name=sente_rating, type=INT32, repetitiontype=OPTIONAL"`
-	GoteName           *string `parquet:"name=gote_name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	GoteRating         *int32  `parquet:"name=gote_rating, type=INT32, repetitiontype=OPTIONAL"`
-	TurnMax            *int32  `parquet:"name=turn_max, type=INT32, repetitiontype=OPTIONAL"`
-	SenteAttackTags    *string `parquet:"name=sente_attack_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	SenteDefenseTags   *string `parquet:"name=sente_defense_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	SenteTechniqueTags *string `parquet:"name=sente_technique_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	SenteNoteTags      *string `parquet:"name=sente_note_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	GoteAttackTags     *string `parquet:"name=gote_attack_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	GoteDefenseTags    *string `parquet:"name=gote_defense_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	GoteTechniqueTags  *string `parquet:"name=gote_technique_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	GoteNoteTags       *string `parquet:"name=gote_note_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-}
-
-// loadOpeningFilter reads the opening DB parquet and returns:
-// - allowedIDs: set of game_ids matching the filter expression
-// - crossingSides: game_id -> "sente"/"gote"/"both" for crossing-side-filter
-//
-// crossingSideExpr is evaluated per-player using playerTags env.
-// If empty, crossingSides is returned empty (meaning count all sides).
-func loadOpeningFilter(path, filterExpr, crossingSideExpr string, parallel int64) (map[string]bool, map[string]string, error) {
-	// Compile the game filter expression.
-	program, err := expr.Compile(filterExpr,
-		expr.Env(gameEnv{}),
-		expr.AsBool(),
-		expr.Function("has", hasFunc,
-			new(func([]string, string) bool),
-		),
-	)
-	if err != nil {
-		return nil, nil, fmt.Errorf("invalid filter expression: %w", err)
-	}
-
-	// Compile the per-player crossing-side filter if provided.
-	var crossingProgram *vm.Program
-	if crossingSideExpr != "" {
-		fmt.Fprintf(os.Stderr, "crossing-side-filter: %s\n", crossingSideExpr)
-		var err2 error
-		crossingProgram, err2 = expr.Compile(crossingSideExpr,
-			expr.Env(playerTags{}),
-			expr.AsBool(),
-			expr.Function("has", hasFunc,
-				new(func([]string, string) bool),
-			),
-		)
-		if err2 != nil {
-			return nil, nil, fmt.Errorf("invalid crossing-side-filter expression: %w", err2)
-		}
-	}
-
-	fileReader, err := local.NewLocalFileReader(path)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer fileReader.Close()
-
-	parquetReader, err := reader.NewParquetReader(fileReader, new(openingRecord), parallel)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer parquetReader.ReadStop()
-
-	num := int(parquetReader.GetNumRows())
-	allowedIDs := make(map[string]bool)
-	crossingSides := make(map[string]string)
-	batchSize := 1024
-	for offset := 0; offset < num; offset += batchSize {
-		remain := num - offset
-		if remain < batchSize {
-			batchSize = remain
-		}
-		batch := make([]openingRecord, batchSize)
-		if err := parquetReader.Read(&batch); err != nil {
-			return nil, nil, err
-		}
-		for _, rec := range batch {
-			env := rec.toGameEnv()
-			out, err := expr.Run(program, env)
-			if err != nil {
-				continue
-			}
-			matched, ok := out.(bool)
-			if !ok || !matched {
-				continue
-			}
-			gid := normalizeGameID(env.GameID)
-			allowedIDs[gid] = true
-
-			// Evaluate crossing-side filter per player.
-			if crossingProgram != nil {
-				senteMatch := evalPlayerFilter(crossingProgram, env.Sente)
-				goteMatch := evalPlayerFilter(crossingProgram, env.Gote)
-				switch {
-				case senteMatch && goteMatch:
-					crossingSides[gid] = "both"
-				case senteMatch:
-					crossingSides[gid] = "sente"
-				case goteMatch:
-					crossingSides[gid] = "gote"
-				default:
-					// Neither side matches crossing filter; exclude from counting.
-					delete(allowedIDs, gid)
-				}
-			}
-		}
-	}
-	return allowedIDs, crossingSides, nil
-}
-
-// evalPlayerFilter runs a compiled per-player expr against playerTags.
-func evalPlayerFilter(program *vm.Program, tags playerTags) bool {
-	out, err := expr.Run(program, tags)
-	if err != nil {
-		return false
-	}
-	matched, ok := out.(bool)
-	return ok && matched
-}
-
-// playerTags holds the parsed tag lists for one player.
-type playerTags struct {
-	Attack    []string `expr:"attack"`
-	Defense   []string `expr:"defense"`
-	Technique []string `expr:"technique"`
-	Note      []string `expr:"note"`
-}
-
-// gameEnv is the environment exposed to filter expressions.
-//
-// Available fields:
-//
-//	game_id        string
-//	sente.attack   []string    sente.defense  []string
-//	sente.technique []string   sente.note     []string
-//	gote.attack    []string    gote.defense   []string
-//	gote.technique []string    gote.note      []string
-//
-// Built-in function:
-//
-//	has(tags, "タグ名") bool  — tags にタグが含まれるか判定
-//
-// Examples:
-//
-//	has(sente.attack, "四間飛車") && has(gote.attack, "居飛車")
-//	has(sente.attack, "中飛車") || has(gote.attack, "中飛車")
-//	has(sente.defense, "美濃囲い") && !has(gote.defense, "穴熊")
-type gameEnv struct {
-	GameID string     `expr:"game_id"`
-	Sente  playerTags `expr:"sente"`
-	Gote   playerTags `expr:"gote"`
-}
-
-// hasFunc implements the has(tags, tag) function for expr.
-func hasFunc(params ...any) (any, error) {
-	tags, ok1 := params[0].([]string)
-	tag, ok2 := params[1].(string)
-	if !ok1 || !ok2 {
-		return false, fmt.Errorf("has() expects ([]string, string), got (%T, %T)", params[0], params[1])
-	}
-	for _, t := range tags {
-		if t == tag {
-			return true, nil
-		}
-	}
-	return false, nil
-}
-
-// toGameEnv converts an openingRecord into a gameEnv for expr evaluation.
-func (r *openingRecord) toGameEnv() gameEnv {
-	return gameEnv{
-		GameID: derefStr(r.GameID),
-		Sente: playerTags{
-			Attack:    splitTags(derefStr(r.SenteAttackTags)),
-			Defense:   splitTags(derefStr(r.SenteDefenseTags)),
-			Technique: splitTags(derefStr(r.SenteTechniqueTags)),
-			Note:      splitTags(derefStr(r.SenteNoteTags)),
-		},
-		Gote: playerTags{
-			Attack:    splitTags(derefStr(r.GoteAttackTags)),
-			Defense:   splitTags(derefStr(r.GoteDefenseTags)),
-			Technique: splitTags(derefStr(r.GoteTechniqueTags)),
-			Note:      splitTags(derefStr(r.GoteNoteTags)),
-		},
-	}
-}
-
-func derefStr(p *string) string {
-	if p == nil {
-		return ""
-	}
-	return *p
-}
-
-// splitTags splits a comma-separated tag string into trimmed non-empty strings.
-func splitTags(s string) []string {
-	if s == "" {
-		return nil
-	}
-	parts := strings.Split(s, ",")
-	result := make([]string, 0, len(parts))
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p != "" {
-			result = append(result, p)
-		}
-	}
-	return result
-}
-
-// normalizeGameID strips the .kif extension for consistent game_id matching
-// between the eval parquet (e.g. "35586426.kif") and the opening DB (e.g. "35586426").
-func normalizeGameID(id string) string {
-	return strings.TrimSuffix(id, ".kif")
-}
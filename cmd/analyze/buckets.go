@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ratingBuckets are the player rating buckets as ascending edges: bucket i
+// is [edges[i], edges[i+1]).
+type ratingBuckets []int
+
+// fixedBuckets returns buckets of width binSize from minRating, the last
+// one starting at or below maxRating.
+func fixedBuckets(minRating, maxRating, binSize int) ratingBuckets {
+	var edges ratingBuckets
+	for from := minRating; from <= maxRating; from += binSize {
+		edges = append(edges, from)
+	}
+	if len(edges) == 0 {
+		return nil
+	}
+	return append(edges, edges[len(edges)-1]+binSize)
+}
+
+// equalCountBuckets splits ratings into n quantile buckets holding about
+// the same number of players. Buckets are merged where many players share
+// a rating, so fewer than n may come back.
+func equalCountBuckets(ratings []int, n int) ratingBuckets {
+	if len(ratings) == 0 {
+		return nil
+	}
+	sorted := append([]int(nil), ratings...)
+	sort.Ints(sorted)
+	edges := ratingBuckets{sorted[0]}
+	for i := 1; i < n; i++ {
+		if edge := sorted[i*len(sorted)/n]; edge > edges[len(edges)-1] {
+			edges = append(edges, edge)
+		}
+	}
+	return append(edges, sorted[len(sorted)-1]+1)
+}
+
+// find returns the bucket of rating; ok is false outside all buckets.
+func (b ratingBuckets) find(rating int) (from, to int, ok bool) {
+	if len(b) < 2 || rating < b[0] || rating >= b[len(b)-1] {
+		return 0, 0, false
+	}
+	i := sort.SearchInts(b, rating+1) - 1
+	return b[i], b[i+1], true
+}
+
+func (b ratingBuckets) String() string {
+	parts := make([]string, 0, len(b))
+	for i := 0; i+1 < len(b); i++ {
+		parts = append(parts, fmt.Sprintf("%d-%d", b[i], b[i+1]))
+	}
+	return strings.Join(parts, ", ")
+}
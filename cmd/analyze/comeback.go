@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Comeback mode answers the opposite question of the crossing table: when
+// the opponent crosses the threshold first (the player falls behind), how
+// often does the player still win, and when does the eval turn around?
+
+// comebackStats aggregates one (threshold, rating bucket) scenario.
+type comebackStats struct {
+	behindGames   int
+	comebacks     int
+	excludedGames int
+	// reversalPlies holds, for each comeback, the first ply after falling
+	// behind at which the eval favours the player again.
+	reversalPlies []int
+	// winReasons counts the comebacks by win_reason: a time-loss win
+	// after falling behind is no reversal on the board.
+	winReasons map[string]int
+}
+
+// reversalPly returns the first ply after fromPly at which the eval favours
+// side (positive cp or a mate for side, through EffectiveCP), or 0.
+func reversalPly(evals []cute.MoveEval, side string, fromPly int) int {
+	for _, eval := range evals {
+		if int(eval.Ply) <= fromPly {
+			continue
+		}
+		value := eval.EffectiveCP()
+		if side == "gote" {
+			value = -value
+		}
+		if value > 0 {
+			return int(eval.Ply)
+		}
+	}
+	return 0
+}
+
+// add counts the player on side of record if the opponent crossed
+// first.
+func (st *comebackStats) add(record cute.GameRecord, side, crossingSide string, crossingPly int, resultSide string) {
+	if crossingSide == "none" || resultSide == "none" {
+		st.excludedGames++
+		return
+	}
+	if crossingSide == side {
+		return
+	}
+	st.behindGames++
+	if resultSide == side {
+		st.comebacks++
+		if st.winReasons == nil {
+			st.winReasons = make(map[string]int)
+		}
+		st.winReasons[record.WinReason]++
+		if ply := reversalPly(record.MoveEvals, side, crossingPly); ply > 0 {
+			st.reversalPlies = append(st.reversalPlies, ply)
+		}
+	}
+}
+
+// comebackRow is one row of comeback output.
+type comebackRow struct {
+	Threshold      int32   `json:"threshold" parquet:"name=threshold, type=INT32"`
+	BucketFrom     int32   `json:"bucket_from" parquet:"name=bucket_from, type=INT32"`
+	BucketTo       int32   `json:"bucket_to" parquet:"name=bucket_to, type=INT32"`
+	BehindGames    int32   `json:"behind_games" parquet:"name=behind_games, type=INT32"`
+	Comebacks      int32   `json:"comebacks" parquet:"name=comebacks, type=INT32"`
+	ComebackRate   float64 `json:"comeback_rate" parquet:"name=comeback_rate, type=DOUBLE"`
+	ReversalP25    int32   `json:"reversal_ply_p25" parquet:"name=reversal_ply_p25, type=INT32"`
+	ReversalMedian int32   `json:"reversal_ply_median" parquet:"name=reversal_ply_median, type=INT32"`
+	ReversalP75    int32   `json:"reversal_ply_p75" parquet:"name=reversal_ply_p75, type=INT32"`
+	WinReasons     string  `json:"comeback_win_reasons" parquet:"name=comeback_win_reasons, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ExcludedGames  int32   `json:"excluded_games" parquet:"name=excluded_games, type=INT32"`
+}
+
+var comebackColumns = []string{
+	"threshold", "bucket_from", "bucket_to",
+	"behind_games", "comebacks", "comeback_rate",
+	"reversal_ply_p25", "reversal_ply_median", "reversal_ply_p75",
+	"comeback_win_reasons", "excluded_games",
+}
+
+func buildComebackRows(scenarios []scenario, results map[scenario]*comebackStats) []comebackRow {
+	rows := make([]comebackRow, 0, len(scenarios))
+	for _, sc := range scenarios {
+		st := results[sc]
+		rate := 0.0
+		if st.behindGames > 0 {
+			rate = float64(st.comebacks) / float64(st.behindGames)
+		}
+		plies := append([]int(nil), st.reversalPlies...)
+		sort.Ints(plies)
+		rows = append(rows, comebackRow{
+			Threshold:      int32(sc.threshold),
+			BucketFrom:     int32(sc.bucketFrom),
+			BucketTo:       int32(sc.bucketTo),
+			BehindGames:    int32(st.behindGames),
+			Comebacks:      int32(st.comebacks),
+			ComebackRate:   rate,
+			ReversalP25:    int32(percentile(plies, 0.25)),
+			ReversalMedian: int32(percentile(plies, 0.5)),
+			ReversalP75:    int32(percentile(plies, 0.75)),
+			WinReasons:     formatWinReasons(st.winReasons),
+			ExcludedGames:  int32(st.excludedGames),
+		})
+	}
+	return rows
+}
+
+// formatWinReasons returns counts as "reason1:n1 reason2:n2 ...", most
+// frequent first.
+func formatWinReasons(counts map[string]int) string {
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if counts[reasons[i]] != counts[reasons[j]] {
+			return counts[reasons[i]] > counts[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	parts := make([]string, len(reasons))
+	for i, reason := range reasons {
+		if reason == "" {
+			reason = "unknown"
+		}
+		parts[i] = fmt.Sprintf("%s:%d", reason, counts[reasons[i]])
+	}
+	return strings.Join(parts, " ")
+}
+
+// percentile returns the nearest-rank percentile of sorted values (0 when
+// empty).
+func percentile(sorted []int, p float64) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(p*float64(len(sorted)) + 0.5)
+	if idx > 0 {
+		idx--
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// printComebackText writes one CSV block per threshold followed by the
+// histogram of reversal plies over all buckets.
+func printComebackText(out io.Writer, scenarios []scenario, results map[scenario]*comebackStats, plyBinSize int) {
+	rows := buildComebackRows(scenarios, results)
+	for i, r := range rows {
+		if i == 0 || rows[i-1].Threshold != r.Threshold {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			fmt.Fprintf(out, "threshold=%d\n", r.Threshold)
+			fmt.Fprintln(out, "player_rate,behind_games,comebacks,comeback_rate,reversal_ply_p25,reversal_ply_median,reversal_ply_p75,comeback_win_reasons")
+		}
+		fmt.Fprintf(out, "%d-%d,%d,%d,%.6f,%d,%d,%d,%s\n",
+			r.BucketFrom, r.BucketTo, r.BehindGames, r.Comebacks, r.ComebackRate,
+			r.ReversalP25, r.ReversalMedian, r.ReversalP75, r.WinReasons)
+		if i == len(rows)-1 || rows[i+1].Threshold != r.Threshold {
+			printReversalHistogram(out, scenarios, results, int(r.Threshold), plyBinSize)
+		}
+	}
+}
+
+func printReversalHistogram(out io.Writer, scenarios []scenario, results map[scenario]*comebackStats, threshold, binSize int) {
+	counts := make(map[int]int)
+	for _, sc := range scenarios {
+		if sc.threshold != threshold {
+			continue
+		}
+		for _, ply := range results[sc].reversalPlies {
+			counts[(ply-1)/binSize]++
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+	bins := make([]int, 0, len(counts))
+	for bin := range counts {
+		bins = append(bins, bin)
+	}
+	sort.Ints(bins)
+	fmt.Fprintf(out, "\nthreshold=%d reversal plies\n", threshold)
+	fmt.Fprintln(out, "ply_from,ply_to,comebacks")
+	for _, bin := range bins {
+		fmt.Fprintf(out, "%d,%d,%d\n", bin*binSize+1, (bin+1)*binSize, counts[bin])
+	}
+}
+
+// writeComebackRows emits rows as csv, json, parquet or arrow.
+func writeComebackRows(format, outputPath string, rows []comebackRow) error {
+	switch format {
+	case "parquet":
+		if outputPath == "" {
+			return fmt.Errorf("-format parquet requires -output")
+		}
+		return writeComebackParquet(outputPath, rows)
+	case "arrow":
+		if outputPath == "" {
+			return fmt.Errorf("-format arrow requires -output")
+		}
+		return writeArrowRows(outputPath, rows)
+	}
+	var out io.Writer = os.Stdout
+	if outputPath != "" {
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	w := csv.NewWriter(out)
+	if err := w.Write(comebackColumns); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		record := []string{
+			strconv.Itoa(int(r.Threshold)),
+			strconv.Itoa(int(r.BucketFrom)),
+			strconv.Itoa(int(r.BucketTo)),
+			strconv.Itoa(int(r.BehindGames)),
+			strconv.Itoa(int(r.Comebacks)),
+			strconv.FormatFloat(r.ComebackRate, 'f', 6, 64),
+			strconv.Itoa(int(r.ReversalP25)),
+			strconv.Itoa(int(r.ReversalMedian)),
+			strconv.Itoa(int(r.ReversalP75)),
+			r.WinReasons,
+			strconv.Itoa(int(r.ExcludedGames)),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeComebackParquet(path string, rows []comebackRow) error {
+	fileWriter, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return err
+	}
+	defer fileWriter.Close()
+
+	parquetWriter, err := writer.NewParquetWriter(fileWriter, new(comebackRow), 1)
+	if err != nil {
+		return err
+	}
+	parquetWriter.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, r := range rows {
+		if err := parquetWriter.Write(r); err != nil {
+			return err
+		}
+	}
+	if err := parquetWriter.WriteStop(); err != nil {
+		return err
+	}
+	return fileWriter.Close()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestReversalPly(t *testing.T) {
+	cp := func(ply, v int) cute.MoveEval {
+		return cute.MoveEval{Ply: int32(ply), ScoreType: "cp", ScoreValue: int32(v)}
+	}
+	mate := func(ply, n int) cute.MoveEval {
+		return cute.MoveEval{Ply: int32(ply), ScoreType: "mate", ScoreValue: int32(n)}
+	}
+	for _, tc := range []struct {
+		name  string
+		evals []cute.MoveEval
+		side  string
+		want  int
+	}{
+		{"cp sente", []cute.MoveEval{cp(10, -500), cp(11, -100), cp(12, 50)}, "sente", 12},
+		{"cp gote", []cute.MoveEval{cp(10, 500), cp(11, 100), cp(12, -50)}, "gote", 12},
+		{"mate +N for sente", []cute.MoveEval{cp(10, -500), mate(11, 5)}, "sente", 11},
+		{"mate +N against gote", []cute.MoveEval{cp(10, 500), mate(11, 5)}, "gote", 0},
+		{"mate -N for gote", []cute.MoveEval{cp(10, 500), mate(11, -3)}, "gote", 11},
+		{"mate -N against sente", []cute.MoveEval{cp(10, -500), mate(11, -3)}, "sente", 0},
+		// "mate 0" after an odd ply: gote to move is mated.
+		{"mate 0 for sente", []cute.MoveEval{cp(10, -500), mate(11, 0)}, "sente", 11},
+		{"mate 0 against gote", []cute.MoveEval{cp(10, 500), mate(11, 0)}, "gote", 0},
+		// After an even ply sente to move is mated.
+		{"mate 0 for gote", []cute.MoveEval{cp(10, 500), mate(12, 0)}, "gote", 12},
+		{"before fromPly", []cute.MoveEval{cp(9, 300), cp(10, -500)}, "sente", 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := reversalPly(tc.evals, tc.side, 10); got != tc.want {
+				t.Errorf("reversalPly = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
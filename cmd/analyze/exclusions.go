@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	cute "cute/pkg/cute"
+)
+
+// Reasons a game is left out of the statistics, in the order the filters
+// apply.
+const (
+	excludedRecordFilter  = "record filter"
+	excludedWinReason     = "win reason"
+	excludedGameFilter    = "move count/phase"
+	excludedNoOpening     = "missing opening"
+	excludedOpeningFilter = "opening filter"
+	excludedRatingDiff    = "rating diff"
+	excludedRatingRange   = "rating range"
+	excludedNotDecisive   = "draw/abort"
+)
+
+var exclusionReasons = []string{
+	excludedRecordFilter, excludedWinReason, excludedGameFilter, excludedNoOpening, excludedOpeningFilter,
+	excludedRatingDiff, excludedRatingRange, excludedNotDecisive,
+}
+
+// exclusions counts the games each filter removes. Every game is counted
+// under the first reason that removes it, so the counts add up to the
+// games read; the games left are then split per threshold into those
+// without a crossing and those counted.
+type exclusions struct {
+	read       int
+	games      map[string]int
+	thresholds []int
+	// noCrossing is nil in modes that count games without a crossing.
+	noCrossing []int // per threshold
+	counted    []int // per threshold
+}
+
+func newExclusions(read int) *exclusions {
+	return &exclusions{read: read, games: make(map[string]int)}
+}
+
+// remove counts n games removed for reason.
+func (e *exclusions) remove(reason string, n int) {
+	e.games[reason] += n
+}
+
+// addRecords sorts records, the games left after the record and opening
+// filters, into the later reasons. crossings, nil in modes that count
+// games without a crossing, returns the first crossing of a game for
+// each of thresholds.
+func (e *exclusions) addRecords(records []cute.GameRecord, ratingDiffMax int, buckets ratingBuckets, thresholds []int, crossings func(cute.GameRecord) []cute.Crossing) {
+	e.thresholds = thresholds
+	if crossings != nil {
+		e.noCrossing = make([]int, len(thresholds))
+	}
+	e.counted = make([]int, len(thresholds))
+	for _, record := range records {
+		if int(math.Abs(float64(record.SenteRating-record.GoteRating))) > ratingDiffMax {
+			e.games[excludedRatingDiff]++
+			continue
+		}
+		_, _, senteOK := buckets.find(int(record.SenteRating))
+		_, _, goteOK := buckets.find(int(record.GoteRating))
+		if !senteOK && !goteOK {
+			e.games[excludedRatingRange]++
+			continue
+		}
+		if winnerSide(record.Result) == "none" {
+			e.games[excludedNotDecisive]++
+			continue
+		}
+		if crossings == nil {
+			for i := range thresholds {
+				e.counted[i]++
+			}
+			continue
+		}
+		for i, cross := range crossings(record) {
+			if cross.Side == "none" {
+				e.noCrossing[i]++
+			} else {
+				e.counted[i]++
+			}
+		}
+	}
+}
+
+// print writes the summary table.
+func (e *exclusions) print(w io.Writer) {
+	fmt.Fprintf(w, "exclusions (%d games read):\n", e.read)
+	row := func(label string, n int) {
+		fmt.Fprintf(w, "  %-20s %8d  %5.1f%%\n", label, n, percent(n, e.read))
+	}
+	for _, reason := range exclusionReasons {
+		row(reason, e.games[reason])
+	}
+	for i, threshold := range e.thresholds {
+		if e.noCrossing != nil {
+			row(fmt.Sprintf("no crossing (%d)", threshold), e.noCrossing[i])
+		}
+		row(fmt.Sprintf("counted (%d)", threshold), e.counted[i])
+	}
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
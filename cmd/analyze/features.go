@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	cute "cute/pkg/cute"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// featureRow is one game of -features-output: identifying columns plus the
+// eval trajectory features of cute.EvalFeatures. EvalAt* are null when the
+// game did not reach the ply.
+type featureRow struct {
+	GameID      string  `parquet:"name=game_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SenteRating int32   `parquet:"name=sente_rating, type=INT32"`
+	GoteRating  int32   `parquet:"name=gote_rating, type=INT32"`
+	Result      string  `parquet:"name=result, type=BYTE_ARRAY, convertedtype=UTF8"`
+	WinReason   string  `parquet:"name=win_reason, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MoveCount   int32   `parquet:"name=move_count, type=INT32"`
+	Evals       int32   `parquet:"name=evals, type=INT32"`
+	MaxEval     int32   `parquet:"name=max_eval, type=INT32"`
+	MinEval     int32   `parquet:"name=min_eval, type=INT32"`
+	EvalAt20    *int32  `parquet:"name=eval_at_20, type=INT32, repetitiontype=OPTIONAL"`
+	EvalAt40    *int32  `parquet:"name=eval_at_40, type=INT32, repetitiontype=OPTIONAL"`
+	EvalAt60    *int32  `parquet:"name=eval_at_60, type=INT32, repetitiontype=OPTIONAL"`
+	SignFlips   int32   `parquet:"name=sign_flips, type=INT32"`
+	Volatility  float64 `parquet:"name=volatility, type=DOUBLE"`
+}
+
+func newFeatureRow(r cute.GameRecord) featureRow {
+	t := cute.EvalFeatures(r, cute.EvalFeatureOptions{})
+	evalAt := func(ply int) *int32 {
+		v, ok := t.EvalAt[ply]
+		if !ok {
+			return nil
+		}
+		out := int32(v)
+		return &out
+	}
+	return featureRow{
+		GameID:      r.GameID,
+		SenteRating: r.SenteRating,
+		GoteRating:  r.GoteRating,
+		Result:      r.Result,
+		WinReason:   r.WinReason,
+		MoveCount:   r.MoveCount,
+		Evals:       int32(t.Evals),
+		MaxEval:     int32(t.MaxEval),
+		MinEval:     int32(t.MinEval),
+		EvalAt20:    evalAt(20),
+		EvalAt40:    evalAt(40),
+		EvalAt60:    evalAt(60),
+		SignFlips:   int32(t.SignFlips),
+		Volatility:  t.Volatility,
+	}
+}
+
+// writeFeatures writes one featureRow per record, as an Arrow IPC file when
+// path ends in .arrow or .feather and as parquet otherwise.
+func writeFeatures(path string, records []cute.GameRecord) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".arrow", ".feather":
+		rows := make([]featureRow, len(records))
+		for i, r := range records {
+			rows[i] = newFeatureRow(r)
+		}
+		return writeArrowRows(path, rows)
+	}
+	return writeFeatureParquet(path, records)
+}
+
+// writeFeatureParquet writes one featureRow per record.
+func writeFeatureParquet(path string, records []cute.GameRecord) error {
+	fileWriter, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return err
+	}
+	defer fileWriter.Close()
+
+	parquetWriter, err := writer.NewParquetWriter(fileWriter, new(featureRow), 1)
+	if err != nil {
+		return err
+	}
+	parquetWriter.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, r := range records {
+		if err := parquetWriter.Write(newFeatureRow(r)); err != nil {
+			return err
+		}
+	}
+	if err := parquetWriter.WriteStop(); err != nil {
+		return err
+	}
+	return fileWriter.Close()
+}
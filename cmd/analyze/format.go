@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	cute "cute/pkg/cute"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// resultRow is one row of the tidy long-format output: one row per
+// (threshold, rating bucket) with all metrics as columns.
+type resultRow struct {
+	Threshold     int32   `json:"threshold" parquet:"name=threshold, type=INT32"`
+	BucketFrom    int32   `json:"bucket_from" parquet:"name=bucket_from, type=INT32"`
+	BucketTo      int32   `json:"bucket_to" parquet:"name=bucket_to, type=INT32"`
+	TotalGames    int32   `json:"total_games" parquet:"name=total_games, type=INT32"`
+	DecisiveGames int32   `json:"decisive_games" parquet:"name=decisive_games, type=INT32"`
+	Crossings     int32   `json:"crossings" parquet:"name=crossings, type=INT32"`
+	CrossingRate  float64 `json:"crossing_rate" parquet:"name=crossing_rate, type=DOUBLE"`
+	Wins          int32   `json:"wins" parquet:"name=wins, type=INT32"`
+	WinRate       float64 `json:"win_rate" parquet:"name=win_rate, type=DOUBLE"`
+	ExcludedGames int32   `json:"excluded_games" parquet:"name=excluded_games, type=INT32"`
+	// CrossingPlyMedian and CrossingEvalMean describe the crossings: the
+	// median ply and the mean |eval| (cp, mate crossings left out).
+	CrossingPlyMedian int32   `json:"crossing_ply_median" parquet:"name=crossing_ply_median, type=INT32"`
+	CrossingEvalMean  float64 `json:"crossing_eval_mean" parquet:"name=crossing_eval_mean, type=DOUBLE"`
+}
+
+var resultColumns = []string{
+	"threshold", "bucket_from", "bucket_to",
+	"total_games", "decisive_games", "crossings", "crossing_rate",
+	"wins", "win_rate", "excluded_games",
+	"crossing_ply_median", "crossing_eval_mean",
+}
+
+// buildRows flattens per-scenario stats into tidy rows in scenario order.
+func buildRows(scenarios []scenario, results map[scenario]*stats) []resultRow {
+	rows := make([]resultRow, 0, len(scenarios))
+	for _, sc := range scenarios {
+		st := results[sc]
+		winRate := 0.0
+		if st.crossings > 0 {
+			winRate = float64(st.wins) / float64(st.crossings)
+		}
+		plyMedian, evalMean := st.crossingSummary()
+		rows = append(rows, resultRow{
+			Threshold:         int32(sc.threshold),
+			BucketFrom:        int32(sc.bucketFrom),
+			BucketTo:          int32(sc.bucketTo),
+			TotalGames:        int32(st.totalGames),
+			DecisiveGames:     int32(st.decisiveGames),
+			Crossings:         int32(st.crossings),
+			CrossingRate:      st.crossingRate(),
+			Wins:              int32(st.wins),
+			WinRate:           winRate,
+			ExcludedGames:     int32(st.excludedGames),
+			CrossingPlyMedian: int32(plyMedian),
+			CrossingEvalMean:  evalMean,
+		})
+	}
+	return rows
+}
+
+// writeRows emits rows in the requested format. outputPath is required for
+// parquet and arrow; for csv/json an empty path means stdout.
+func writeRows(format, outputPath string, rows []resultRow) error {
+	switch format {
+	case "parquet":
+		if outputPath == "" {
+			return fmt.Errorf("-format parquet requires -output")
+		}
+		return writeRowsParquet(outputPath, rows)
+	case "arrow":
+		if outputPath == "" {
+			return fmt.Errorf("-format arrow requires -output")
+		}
+		return writeArrowRows(outputPath, rows)
+	}
+
+	var out io.Writer = os.Stdout
+	if outputPath != "" {
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	switch format {
+	case "csv":
+		return writeRowsCSV(out, rows)
+	case "json":
+		return writeRowsJSON(out, rows)
+	default:
+		return fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+func writeRowsCSV(out io.Writer, rows []resultRow) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(resultColumns); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		record := []string{
+			strconv.Itoa(int(r.Threshold)),
+			strconv.Itoa(int(r.BucketFrom)),
+			strconv.Itoa(int(r.BucketTo)),
+			strconv.Itoa(int(r.TotalGames)),
+			strconv.Itoa(int(r.DecisiveGames)),
+			strconv.Itoa(int(r.Crossings)),
+			strconv.FormatFloat(r.CrossingRate, 'f', 6, 64),
+			strconv.Itoa(int(r.Wins)),
+			strconv.FormatFloat(r.WinRate, 'f', 6, 64),
+			strconv.Itoa(int(r.ExcludedGames)),
+			strconv.Itoa(int(r.CrossingPlyMedian)),
+			strconv.FormatFloat(r.CrossingEvalMean, 'f', 1, 64),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeRowsJSON(out io.Writer, rows []resultRow) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
+
+// writeArrowRows writes rows of a parquet-tagged struct as an Arrow IPC
+// file with the same columns as the parquet output.
+func writeArrowRows[T any](path string, rows []T) error {
+	var zero T
+	w, err := cute.NewArrowWriter(path, zero, nil)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if err := w.Write(r); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func writeRowsParquet(path string, rows []resultRow) error {
+	fileWriter, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return err
+	}
+	defer fileWriter.Close()
+
+	parquetWriter, err := writer.NewParquetWriter(fileWriter, new(resultRow), 1)
+	if err != nil {
+		return err
+	}
+	parquetWriter.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, r := range rows {
+		if err := parquetWriter.Write(r); err != nil {
+			return err
+		}
+	}
+	if err := parquetWriter.WriteStop(); err != nil {
+		return err
+	}
+	return fileWriter.Close()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+// crossingStats counts the sente player of five games at one threshold:
+// two crossed first by sente (one won), one crossed first by gote, one
+// without a crossing and one draw.
+func crossingStats(countOthers bool) *stats {
+	cp := func(v int) cute.MoveEval { return cute.MoveEval{ScoreType: "cp", ScoreValue: int32(v)} }
+	st := &stats{}
+	for _, game := range []struct {
+		cross      cute.Crossing
+		resultSide string
+	}{
+		{cute.Crossing{Side: "sente", Ply: 30, Eval: cp(500)}, "sente"},
+		{cute.Crossing{Side: "sente", Ply: 30, Eval: cp(700)}, "gote"},
+		{cute.Crossing{Side: "gote", Ply: 40, Eval: cp(-600)}, "gote"},
+		{cute.Crossing{Side: "none"}, "sente"},
+		{cute.Crossing{Side: "sente", Ply: 50, Eval: cp(800)}, "none"},
+	} {
+		st.add("sente", game.cross, game.resultSide, countOthers)
+	}
+	return st
+}
+
+func TestWriteRowsCSVColumns(t *testing.T) {
+	sc := scenario{threshold: 500, bucketFrom: 1500, bucketTo: 1600}
+	for _, tc := range []struct {
+		name        string
+		countOthers bool
+		want        string
+	}{
+		{
+			name: "unfiltered",
+			want: "threshold,bucket_from,bucket_to,total_games,decisive_games,crossings,crossing_rate,wins,win_rate,excluded_games,crossing_ply_median,crossing_eval_mean\n" +
+				"500,1500,1600,2,4,2,0.500000,1,0.500000,2,30,600.0\n",
+		},
+		{
+			name:        "crossing side filter",
+			countOthers: true,
+			want: "threshold,bucket_from,bucket_to,total_games,decisive_games,crossings,crossing_rate,wins,win_rate,excluded_games,crossing_ply_median,crossing_eval_mean\n" +
+				"500,1500,1600,3,4,2,0.500000,1,0.500000,2,30,600.0\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			results := map[scenario]*stats{sc: crossingStats(tc.countOthers)}
+			var buf bytes.Buffer
+			if err := writeRowsCSV(&buf, buildRows([]scenario{sc}, results)); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("csv =\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPrintCSVColumns(t *testing.T) {
+	sc := scenario{threshold: 500, bucketFrom: 1500, bucketTo: 1600}
+	for _, tc := range []struct {
+		name        string
+		countOthers bool
+		want        string
+	}{
+		{
+			name: "unfiltered",
+			want: "threshold=500\n" +
+				"player_rate,crossings,wins,win_rate,crossing_ply_median\n" +
+				"1500-1600,2,1,0.500000,30\n",
+		},
+		{
+			name:        "crossing side filter",
+			countOthers: true,
+			want: "threshold=500\n" +
+				"player_rate,total_games,decisive_games,crossings,crossing_rate,wins,win_rate,crossing_ply_median\n" +
+				"1500-1600,3,4,2,0.500000,1,0.500000,30\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			results := map[scenario]*stats{sc: crossingStats(tc.countOthers)}
+			var buf bytes.Buffer
+			printCSV(&buf, []scenario{sc}, results, tc.countOthers)
+			if got := buf.String(); got != tc.want {
+				t.Errorf("text =\n%s\nwant\n%s", got, tc.want)
+			}
+		})
+	}
+}
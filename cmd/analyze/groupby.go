@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// groupDimensions are the values accepted by -group-by. Each observation
+// is one player (side) of one game, as in the default rating-bucket table.
+var groupDimensions = map[string]bool{
+	"rating_bucket":     true, // the player's rating bucket (-player-bin-size)
+	"side":              true, // sente or gote
+	"result":            true, // GameRecord.Result
+	"win_reason":        true, // GameRecord.WinReason
+	"move_count_bucket": true, // GameRecord.MoveCount bucket (-move-count-bin-size)
+}
+
+// parseGroupBy validates a comma-separated -group-by list.
+func parseGroupBy(raw string) ([]string, error) {
+	var dims []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !groupDimensions[part] {
+			names := make([]string, 0, len(groupDimensions))
+			for name := range groupDimensions {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown -group-by dimension %q (available: %s)", part, strings.Join(names, ", "))
+		}
+		if !seen[part] {
+			seen[part] = true
+			dims = append(dims, part)
+		}
+	}
+	return dims, nil
+}
+
+// groupKey identifies one output row: a threshold and the dimension values
+// joined by "\x00".
+type groupKey struct {
+	threshold int
+	values    string
+}
+
+// grouper aggregates crossing statistics along the -group-by dimensions.
+type grouper struct {
+	dims        []string
+	buckets     ratingBuckets
+	moveBinSize int
+	results     map[groupKey]*stats
+}
+
+// add counts one player (side) of record for threshold, with the same
+// rules as the default table (stats.add).
+func (g *grouper) add(record cute.GameRecord, side string, threshold int, cross cute.Crossing, resultSide string, countOthers bool) {
+	rating := int(record.SenteRating)
+	if side == "gote" {
+		rating = int(record.GoteRating)
+	}
+	from, to, ok := g.buckets.find(rating)
+	if !ok {
+		return
+	}
+	key := groupKey{threshold: threshold, values: strings.Join(g.values(record, side, from, to), "\x00")}
+	st := g.results[key]
+	if st == nil {
+		st = &stats{}
+		g.results[key] = st
+	}
+	st.add(side, cross, resultSide, countOthers)
+}
+
+// values are the dimension values of the player on side, whose rating is
+// in the bucket [from, to).
+func (g *grouper) values(record cute.GameRecord, side string, from, to int) []string {
+	values := make([]string, len(g.dims))
+	for i, dim := range g.dims {
+		switch dim {
+		case "rating_bucket":
+			values[i] = fmt.Sprintf("%d-%d", from, to)
+		case "side":
+			values[i] = side
+		case "result":
+			values[i] = record.Result
+		case "win_reason":
+			values[i] = record.WinReason
+		case "move_count_bucket":
+			from := floorDiv(int(record.MoveCount), g.moveBinSize) * g.moveBinSize
+			values[i] = fmt.Sprintf("%d-%d", from, from+g.moveBinSize)
+		}
+	}
+	return values
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// groupRow is one aggregated row of -group-by output.
+type groupRow struct {
+	Threshold int
+	Values    []string
+	Stats     stats
+}
+
+// rows returns the groups sorted by threshold and dimension values
+// (numeric buckets in numeric order).
+func (g *grouper) rows() []groupRow {
+	rows := make([]groupRow, 0, len(g.results))
+	for key, st := range g.results {
+		rows = append(rows, groupRow{Threshold: key.threshold, Values: strings.Split(key.values, "\x00"), Stats: *st})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Threshold != rows[j].Threshold {
+			return rows[i].Threshold < rows[j].Threshold
+		}
+		for k := range rows[i].Values {
+			a, b := rows[i].Values[k], rows[j].Values[k]
+			if a == b {
+				continue
+			}
+			na, errA := strconv.Atoi(strings.SplitN(a, "-", 2)[0])
+			nb, errB := strconv.Atoi(strings.SplitN(b, "-", 2)[0])
+			if errA == nil && errB == nil && na != nb {
+				return na < nb
+			}
+			return a < b
+		}
+		return false
+	})
+	return rows
+}
+
+func (r groupRow) rates() (crossingRate, winRate float64) {
+	crossingRate = r.Stats.crossingRate()
+	if r.Stats.crossings > 0 {
+		winRate = float64(r.Stats.wins) / float64(r.Stats.crossings)
+	}
+	return crossingRate, winRate
+}
+
+var groupMetricColumns = []string{
+	"total_games", "decisive_games", "crossings", "crossing_rate", "wins", "win_rate", "excluded_games",
+	"crossing_ply_median", "crossing_eval_mean",
+}
+
+// floatColumn reports whether a metric column holds DOUBLE values.
+func floatColumn(col string) bool {
+	return strings.HasSuffix(col, "_rate") || strings.HasSuffix(col, "_mean")
+}
+
+// writeGroupRows emits -group-by rows; text and csv both produce one CSV
+// table with a column per dimension.
+func writeGroupRows(format, outputPath string, dims []string, rows []groupRow) error {
+	switch format {
+	case "parquet":
+		if outputPath == "" {
+			return fmt.Errorf("-format parquet requires -output")
+		}
+		return writeGroupRowsParquet(outputPath, dims, rows)
+	case "arrow":
+		if outputPath == "" {
+			return fmt.Errorf("-format arrow requires -output")
+		}
+		return writeGroupRowsArrow(outputPath, dims, rows)
+	}
+	var out io.Writer = os.Stdout
+	if outputPath != "" {
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if format == "json" {
+		objects := make([]map[string]any, 0, len(rows))
+		for _, r := range rows {
+			objects = append(objects, groupRowObject(dims, r))
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(objects)
+	}
+
+	w := csv.NewWriter(out)
+	header := append(append([]string{"threshold"}, dims...), groupMetricColumns...)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		crossingRate, winRate := r.rates()
+		plyMedian, evalMean := r.Stats.crossingSummary()
+		record := append([]string{strconv.Itoa(r.Threshold)}, r.Values...)
+		record = append(record,
+			strconv.Itoa(r.Stats.totalGames),
+			strconv.Itoa(r.Stats.decisiveGames),
+			strconv.Itoa(r.Stats.crossings),
+			strconv.FormatFloat(crossingRate, 'f', 6, 64),
+			strconv.Itoa(r.Stats.wins),
+			strconv.FormatFloat(winRate, 'f', 6, 64),
+			strconv.Itoa(r.Stats.excludedGames),
+			strconv.Itoa(plyMedian),
+			strconv.FormatFloat(evalMean, 'f', 1, 64),
+		)
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func groupRowObject(dims []string, r groupRow) map[string]any {
+	crossingRate, winRate := r.rates()
+	plyMedian, evalMean := r.Stats.crossingSummary()
+	obj := map[string]any{
+		"threshold":           r.Threshold,
+		"total_games":         r.Stats.totalGames,
+		"decisive_games":      r.Stats.decisiveGames,
+		"crossings":           r.Stats.crossings,
+		"crossing_rate":       crossingRate,
+		"wins":                r.Stats.wins,
+		"win_rate":            winRate,
+		"excluded_games":      r.Stats.excludedGames,
+		"crossing_ply_median": plyMedian,
+		"crossing_eval_mean":  evalMean,
+	}
+	for i, dim := range dims {
+		obj[dim] = r.Values[i]
+	}
+	return obj
+}
+
+// writeGroupRowsParquet writes one string column per dimension followed by
+// the metric columns, using a schema built from dims.
+func writeGroupRowsParquet(path string, dims []string, rows []groupRow) error {
+	type field struct {
+		Tag string `json:"Tag"`
+	}
+	fields := []field{{Tag: "name=threshold, type=INT32"}}
+	for _, dim := range dims {
+		fields = append(fields, field{Tag: "name=" + dim + ", type=BYTE_ARRAY, convertedtype=UTF8"})
+	}
+	for _, col := range groupMetricColumns {
+		typ := "INT32"
+		if floatColumn(col) {
+			typ = "DOUBLE"
+		}
+		fields = append(fields, field{Tag: "name=" + col + ", type=" + typ})
+	}
+	schema, err := json.Marshal(struct {
+		Tag    string  `json:"Tag"`
+		Fields []field `json:"Fields"`
+	}{Tag: "name=parquet_go_root, repetitiontype=REQUIRED", Fields: fields})
+	if err != nil {
+		return err
+	}
+
+	fileWriter, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return err
+	}
+	defer fileWriter.Close()
+	parquetWriter, err := writer.NewJSONWriter(string(schema), fileWriter, 1)
+	if err != nil {
+		return err
+	}
+	parquetWriter.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, r := range rows {
+		data, err := json.Marshal(groupRowObject(dims, r))
+		if err != nil {
+			return err
+		}
+		if err := parquetWriter.Write(string(data)); err != nil {
+			return err
+		}
+	}
+	if err := parquetWriter.WriteStop(); err != nil {
+		return err
+	}
+	return fileWriter.Close()
+}
+
+// writeGroupRowsArrow writes the columns of writeGroupRowsParquet as an
+// Arrow IPC file, through a struct type built from dims.
+func writeGroupRowsArrow(path string, dims []string, rows []groupRow) error {
+	columns := append(append([]string{"threshold"}, dims...), groupMetricColumns...)
+	fields := make([]reflect.StructField, len(columns))
+	for i, col := range columns {
+		typ, tag := reflect.TypeOf(""), "BYTE_ARRAY, convertedtype=UTF8"
+		switch {
+		case i == 0 || i > len(dims) && !floatColumn(col):
+			typ, tag = reflect.TypeOf(int32(0)), "INT32"
+		case i > len(dims):
+			typ, tag = reflect.TypeOf(0.0), "DOUBLE"
+		}
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("Column%d", i),
+			Type: typ,
+			Tag:  reflect.StructTag(fmt.Sprintf(`parquet:"name=%s, type=%s"`, col, tag)),
+		}
+	}
+	rowType := reflect.StructOf(fields)
+	w, err := cute.NewArrowWriter(path, reflect.Zero(rowType).Interface(), nil)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		obj := groupRowObject(dims, r)
+		row := reflect.New(rowType).Elem()
+		for i, col := range columns {
+			switch v := obj[col].(type) {
+			case int:
+				row.Field(i).SetInt(int64(v))
+			case float64:
+				row.Field(i).SetFloat(v)
+			case string:
+				row.Field(i).SetString(v)
+			}
+		}
+		if err := w.Write(row.Interface()); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
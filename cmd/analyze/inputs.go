@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cute "cute/pkg/cute"
+)
+
+// expandInputs resolves the -input value and the positional arguments into
+// parquet paths. Arguments with glob metacharacters are expanded (sorted;
+// a pattern that matches nothing is an error), and repeated paths are
+// dropped.
+func expandInputs(args []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("input %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("input %q matches no files", arg)
+			}
+		}
+		for _, path := range matches {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// readInputs reads and concatenates the records of paths. A game found in
+// more than one file (e.g. a checkpoint part next to the merged output) is
+// kept once, from the first file. It also returns the engine setups of all
+// files.
+func readInputs(paths []string, parallel int64) ([]cute.GameRecord, []cute.EngineInfo, error) {
+	var records []cute.GameRecord
+	var engines []cute.EngineInfo
+	seen := make(map[string]bool)
+	duplicates := 0
+	for _, path := range paths {
+		if len(paths) > 1 {
+			fmt.Fprintf(os.Stderr, "%s\n", path)
+		}
+		batch, err := readParquet(path, parallel)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, record := range batch {
+			if seen[record.GameID] {
+				duplicates++
+				continue
+			}
+			seen[record.GameID] = true
+			records = append(records, record)
+		}
+		if meta, err := cute.ReadParquetMeta(path); err == nil {
+			if err := meta.CheckScorePerspective(); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", path, err)
+			}
+			engines = cute.MergeEngines(engines, meta.Engines)
+		}
+	}
+	if duplicates > 0 {
+		fmt.Fprintf(os.Stderr, "warning: skipped %d games found in more than one input\n", duplicates)
+	}
+	return records, engines, nil
+}
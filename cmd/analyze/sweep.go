@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	cute "cute/pkg/cute"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Sweep mode evaluates "crossed first" as a predictor of winning over a
+// range of thresholds. Every game's evals are walked once for all
+// thresholds (cute.FirstCrossings), so a dense sweep costs about as much as
+// a single threshold. Per threshold and rating bucket it reports how often
+// players cross first, how often that wins, and the ROC point: the share
+// of won games that were crossed first (tpr) against the share of lost
+// games that were (fpr).
+
+// sweepThresholds returns from, from+step, ... up to to.
+func sweepThresholds(from, to, step int) []int {
+	var thresholds []int
+	for t := from; t <= to; t += step {
+		thresholds = append(thresholds, t)
+	}
+	return thresholds
+}
+
+// sweepStats aggregates one (threshold, rating bucket) scenario over the
+// decisive games of the players in the bucket.
+type sweepStats struct {
+	games         int // decisive games
+	wonGames      int
+	crossings     int // games the player crossed first
+	wins          int // games the player crossed first and won
+	excludedGames int // games without a decisive result
+}
+
+func (st *sweepStats) add(side, crossingSide, resultSide string) {
+	if resultSide == "none" {
+		st.excludedGames++
+		return
+	}
+	st.games++
+	won := resultSide == side
+	if won {
+		st.wonGames++
+	}
+	if crossingSide == side {
+		st.crossings++
+		if won {
+			st.wins++
+		}
+	}
+}
+
+// sweeper accumulates sweep stats, finding the bucket of a rating directly
+// instead of testing every scenario.
+type sweeper struct {
+	thresholds []int
+	buckets    ratingBuckets
+	results    map[scenario]*sweepStats
+}
+
+func newSweeper(thresholds []int, buckets ratingBuckets) *sweeper {
+	s := &sweeper{thresholds: thresholds, buckets: buckets, results: make(map[scenario]*sweepStats)}
+	for _, sc := range buildScenarios(thresholds, buckets) {
+		s.results[sc] = &sweepStats{}
+	}
+	return s
+}
+
+// add counts the player on side of a game with the given crossings (one
+// per threshold).
+func (s *sweeper) add(side string, rating int, crossings []cute.Crossing, resultSide string) {
+	from, to, ok := s.buckets.find(rating)
+	if !ok {
+		return
+	}
+	for i, threshold := range s.thresholds {
+		s.results[scenario{threshold: threshold, bucketFrom: from, bucketTo: to}].add(side, crossings[i].Side, resultSide)
+	}
+}
+
+// sweepRow is one row of sweep output.
+type sweepRow struct {
+	Threshold     int32   `json:"threshold" parquet:"name=threshold, type=INT32"`
+	BucketFrom    int32   `json:"bucket_from" parquet:"name=bucket_from, type=INT32"`
+	BucketTo      int32   `json:"bucket_to" parquet:"name=bucket_to, type=INT32"`
+	Games         int32   `json:"games" parquet:"name=games, type=INT32"`
+	Crossings     int32   `json:"crossings" parquet:"name=crossings, type=INT32"`
+	CrossingRate  float64 `json:"crossing_rate" parquet:"name=crossing_rate, type=DOUBLE"`
+	Wins          int32   `json:"wins" parquet:"name=wins, type=INT32"`
+	WinRate       float64 `json:"win_rate" parquet:"name=win_rate, type=DOUBLE"`
+	TPR           float64 `json:"tpr" parquet:"name=tpr, type=DOUBLE"`
+	FPR           float64 `json:"fpr" parquet:"name=fpr, type=DOUBLE"`
+	ExcludedGames int32   `json:"excluded_games" parquet:"name=excluded_games, type=INT32"`
+}
+
+var sweepColumns = []string{
+	"threshold", "bucket_from", "bucket_to",
+	"games", "crossings", "crossing_rate",
+	"wins", "win_rate", "tpr", "fpr",
+	"excluded_games",
+}
+
+// rows returns the sweep rows sorted by threshold and bucket.
+func (s *sweeper) rows() []sweepRow {
+	scenarios := buildScenarios(s.thresholds, s.buckets)
+	rows := make([]sweepRow, 0, len(scenarios))
+	ratio := func(a, b int) float64 {
+		if b == 0 {
+			return 0
+		}
+		return float64(a) / float64(b)
+	}
+	for _, sc := range scenarios {
+		st := s.results[sc]
+		rows = append(rows, sweepRow{
+			Threshold:     int32(sc.threshold),
+			BucketFrom:    int32(sc.bucketFrom),
+			BucketTo:      int32(sc.bucketTo),
+			Games:         int32(st.games),
+			Crossings:     int32(st.crossings),
+			CrossingRate:  ratio(st.crossings, st.games),
+			Wins:          int32(st.wins),
+			WinRate:       ratio(st.wins, st.crossings),
+			TPR:           ratio(st.wins, st.wonGames),
+			FPR:           ratio(st.crossings-st.wins, st.games-st.wonGames),
+			ExcludedGames: int32(st.excludedGames),
+		})
+	}
+	return rows
+}
+
+// writeSweepRows emits rows as csv (also for text), json, parquet or
+// arrow.
+func writeSweepRows(format, outputPath string, rows []sweepRow) error {
+	switch format {
+	case "parquet":
+		if outputPath == "" {
+			return fmt.Errorf("-format parquet requires -output")
+		}
+		return writeSweepParquet(outputPath, rows)
+	case "arrow":
+		if outputPath == "" {
+			return fmt.Errorf("-format arrow requires -output")
+		}
+		return writeArrowRows(outputPath, rows)
+	}
+	var out io.Writer = os.Stdout
+	if outputPath != "" {
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	w := csv.NewWriter(out)
+	if err := w.Write(sweepColumns); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		record := []string{
+			strconv.Itoa(int(r.Threshold)),
+			strconv.Itoa(int(r.BucketFrom)),
+			strconv.Itoa(int(r.BucketTo)),
+			strconv.Itoa(int(r.Games)),
+			strconv.Itoa(int(r.Crossings)),
+			strconv.FormatFloat(r.CrossingRate, 'f', 6, 64),
+			strconv.Itoa(int(r.Wins)),
+			strconv.FormatFloat(r.WinRate, 'f', 6, 64),
+			strconv.FormatFloat(r.TPR, 'f', 6, 64),
+			strconv.FormatFloat(r.FPR, 'f', 6, 64),
+			strconv.Itoa(int(r.ExcludedGames)),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeSweepParquet(path string, rows []sweepRow) error {
+	fileWriter, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return err
+	}
+	defer fileWriter.Close()
+
+	parquetWriter, err := writer.NewParquetWriter(fileWriter, new(sweepRow), 1)
+	if err != nil {
+		return err
+	}
+	parquetWriter.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, r := range rows {
+		if err := parquetWriter.Write(r); err != nil {
+			return err
+		}
+	}
+	if err := parquetWriter.WriteStop(); err != nil {
+		return err
+	}
+	return fileWriter.Close()
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/openingdb"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Tags mode asks which strategies generate early advantages. For each
+// opening tag it compares the players who used it with the players who
+// did not (the tag's complement, among tagged players): how often they
+// cross the threshold first, and how often such a crossing is converted
+// into a win. Both are reported as rates and as odds ratios against the
+// complement, so a ratio above 1 means the tag helps.
+
+// tagCount counts the decisive games of the players on one side of a tag
+// split at one threshold.
+type tagCount struct {
+	games     int // decisive games
+	crossings int // games the player crossed first
+	wins      int // games the player crossed first and won
+	// crossingPlies holds the ply of each crossing.
+	crossingPlies []int
+}
+
+func (c *tagCount) add(crossed bool, ply int, won bool) {
+	c.games++
+	if !crossed {
+		return
+	}
+	c.crossings++
+	c.crossingPlies = append(c.crossingPlies, ply)
+	if won {
+		c.wins++
+	}
+}
+
+// tagSplitter accumulates the per-tag counts for each threshold. The
+// complement of a tag is derived as the total minus the tag, since every
+// tagged player is counted in the total exactly once.
+type tagSplitter struct {
+	thresholds []int
+	category   string
+	tags       []map[string]*tagCount // per threshold: tag -> players with it
+	total      []tagCount             // per threshold: all tagged players
+	excluded   int                    // tagged players in games without a decisive result
+}
+
+func newTagSplitter(thresholds []int, category string) *tagSplitter {
+	s := &tagSplitter{thresholds: thresholds, category: category,
+		tags: make([]map[string]*tagCount, len(thresholds)), total: make([]tagCount, len(thresholds))}
+	for i := range s.tags {
+		s.tags[i] = make(map[string]*tagCount)
+	}
+	return s
+}
+
+// categoryTags returns the tags of category ("attack", "defense",
+// "technique" or "note") of a player.
+func categoryTags(tags openingdb.Tags, category string) []string {
+	switch category {
+	case "defense":
+		return tags.Defense
+	case "technique":
+		return tags.Technique
+	case "note":
+		return tags.Note
+	}
+	return tags.Attack
+}
+
+// add counts the player on side of a game with the given crossings (one
+// per threshold). Players without a tag of the category are skipped.
+func (s *tagSplitter) add(side string, game openingdb.Game, crossings []cute.Crossing, resultSide string) {
+	tags := categoryTags(game.Side(side), s.category)
+	if len(tags) == 0 {
+		return
+	}
+	if resultSide == "none" {
+		s.excluded++
+		return
+	}
+	won := resultSide == side
+	for i, cross := range crossings {
+		crossed := cross.Side == side
+		s.total[i].add(crossed, cross.Ply, won)
+		seen := make(map[string]bool, len(tags))
+		for _, tag := range tags {
+			if seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			c := s.tags[i][tag]
+			if c == nil {
+				c = &tagCount{}
+				s.tags[i][tag] = c
+			}
+			c.add(crossed, cross.Ply, won)
+		}
+	}
+}
+
+// oddsRatio returns the odds ratio of a/(n-a) against b/(m-b) with 0.5
+// added to each cell (Haldane-Anscombe), so that it stays finite when a
+// count is zero.
+func oddsRatio(a, n, b, m int) float64 {
+	return ((float64(a) + 0.5) / (float64(n-a) + 0.5)) / ((float64(b) + 0.5) / (float64(m-b) + 0.5))
+}
+
+// tagRow is one row of tags output.
+type tagRow struct {
+	Threshold int32  `json:"threshold" parquet:"name=threshold, type=INT32"`
+	Rank      int32  `json:"rank" parquet:"name=rank, type=INT32"`
+	Tag       string `json:"tag" parquet:"name=tag, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Games     int32  `json:"games" parquet:"name=games, type=INT32"`
+	Crossings int32  `json:"crossings" parquet:"name=crossings, type=INT32"`
+	// CrossingRate is P(crossing first | tag) and WinRate P(win | tag,
+	// crossing first), the conversion rate.
+	CrossingRate      float64 `json:"crossing_rate" parquet:"name=crossing_rate, type=DOUBLE"`
+	Wins              int32   `json:"wins" parquet:"name=wins, type=INT32"`
+	WinRate           float64 `json:"win_rate" parquet:"name=win_rate, type=DOUBLE"`
+	CrossingPlyMedian int32   `json:"crossing_ply_median" parquet:"name=crossing_ply_median, type=INT32"`
+	// The same rates for the players without the tag.
+	OtherGames        int32   `json:"other_games" parquet:"name=other_games, type=INT32"`
+	OtherCrossingRate float64 `json:"other_crossing_rate" parquet:"name=other_crossing_rate, type=DOUBLE"`
+	OtherWinRate      float64 `json:"other_win_rate" parquet:"name=other_win_rate, type=DOUBLE"`
+	CrossingOddsRatio float64 `json:"crossing_odds_ratio" parquet:"name=crossing_odds_ratio, type=DOUBLE"`
+	WinOddsRatio      float64 `json:"win_odds_ratio" parquet:"name=win_odds_ratio, type=DOUBLE"`
+}
+
+var tagColumns = []string{
+	"threshold", "rank", "tag",
+	"games", "crossings", "crossing_rate", "wins", "win_rate", "crossing_ply_median",
+	"other_games", "other_crossing_rate", "other_win_rate",
+	"crossing_odds_ratio", "win_odds_ratio",
+}
+
+// rows returns the rows of the tags with at least minGames games, ranked
+// per threshold by crossing odds ratio (highest first).
+func (s *tagSplitter) rows(minGames int) []tagRow {
+	ratio := func(a, b int) float64 {
+		if b == 0 {
+			return 0
+		}
+		return float64(a) / float64(b)
+	}
+	var rows []tagRow
+	for i, threshold := range s.thresholds {
+		total := s.total[i]
+		var block []tagRow
+		for tag, c := range s.tags[i] {
+			if c.games < minGames {
+				continue
+			}
+			other := tagCount{games: total.games - c.games, crossings: total.crossings - c.crossings, wins: total.wins - c.wins}
+			plies := append([]int(nil), c.crossingPlies...)
+			sort.Ints(plies)
+			block = append(block, tagRow{
+				Threshold:         int32(threshold),
+				Tag:               tag,
+				Games:             int32(c.games),
+				Crossings:         int32(c.crossings),
+				CrossingRate:      ratio(c.crossings, c.games),
+				Wins:              int32(c.wins),
+				WinRate:           ratio(c.wins, c.crossings),
+				CrossingPlyMedian: int32(percentile(plies, 0.5)),
+				OtherGames:        int32(other.games),
+				OtherCrossingRate: ratio(other.crossings, other.games),
+				OtherWinRate:      ratio(other.wins, other.crossings),
+				CrossingOddsRatio: oddsRatio(c.crossings, c.games, other.crossings, other.games),
+				WinOddsRatio:      oddsRatio(c.wins, c.crossings, other.wins, other.crossings),
+			})
+		}
+		sort.Slice(block, func(a, b int) bool {
+			if block[a].CrossingOddsRatio != block[b].CrossingOddsRatio {
+				return block[a].CrossingOddsRatio > block[b].CrossingOddsRatio
+			}
+			return block[a].Tag < block[b].Tag
+		})
+		for j := range block {
+			block[j].Rank = int32(j + 1)
+		}
+		rows = append(rows, block...)
+	}
+	return rows
+}
+
+// writeTagRows emits rows as csv (also for text), json, parquet or arrow.
+func writeTagRows(format, outputPath string, rows []tagRow) error {
+	switch format {
+	case "parquet":
+		if outputPath == "" {
+			return fmt.Errorf("-format parquet requires -output")
+		}
+		return writeTagParquet(outputPath, rows)
+	case "arrow":
+		if outputPath == "" {
+			return fmt.Errorf("-format arrow requires -output")
+		}
+		return writeArrowRows(outputPath, rows)
+	}
+	var out io.Writer = os.Stdout
+	if outputPath != "" {
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	w := csv.NewWriter(out)
+	if err := w.Write(tagColumns); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		record := []string{
+			strconv.Itoa(int(r.Threshold)),
+			strconv.Itoa(int(r.Rank)),
+			r.Tag,
+			strconv.Itoa(int(r.Games)),
+			strconv.Itoa(int(r.Crossings)),
+			strconv.FormatFloat(r.CrossingRate, 'f', 6, 64),
+			strconv.Itoa(int(r.Wins)),
+			strconv.FormatFloat(r.WinRate, 'f', 6, 64),
+			strconv.Itoa(int(r.CrossingPlyMedian)),
+			strconv.Itoa(int(r.OtherGames)),
+			strconv.FormatFloat(r.OtherCrossingRate, 'f', 6, 64),
+			strconv.FormatFloat(r.OtherWinRate, 'f', 6, 64),
+			strconv.FormatFloat(r.CrossingOddsRatio, 'f', 4, 64),
+			strconv.FormatFloat(r.WinOddsRatio, 'f', 4, 64),
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeTagParquet(path string, rows []tagRow) error {
+	fileWriter, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return err
+	}
+	defer fileWriter.Close()
+
+	parquetWriter, err := writer.NewParquetWriter(fileWriter, new(tagRow), 1)
+	if err != nil {
+		return err
+	}
+	parquetWriter.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, r := range rows {
+		if err := parquetWriter.Write(r); err != nil {
+			return err
+		}
+	}
+	if err := parquetWriter.WriteStop(); err != nil {
+		return err
+	}
+	return fileWriter.Close()
+}
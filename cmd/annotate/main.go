@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	cute "cute/pkg/cute"
+)
+
+// annotate evaluates every KIF under -input with the configured engine and
+// writes a copy with the evals as comments under -output, keeping the
+// directory layout.
+func main() {
+	configPath := flag.String("config", "", "shared config file: engine, engine options and flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json)")
+	inputDir := flag.String("input", "test_kif", "input directory for KIF files")
+	outputDir := flag.String("output", "annotated", "directory for the annotated KIF files")
+	processNum := flag.Int("process-num", 4, "number of parallel workers")
+	millis := flag.Int("millis", 0, "search time per ply in ms (0=config millis)")
+	evalTimeoutFlag := flag.Duration("eval-timeout", 0, "watchdog for a single engine evaluation (0=auto: 10x movetime, at least 30s; negative disables)")
+	evalDir := flag.String("eval-dir", "", "engine EvalDir option: directory of the evaluation files (default: config eval_dir, else the engine's)")
+	evalFile := flag.String("eval-file", "", "engine EvalFile option: NNUE network file in -eval-dir (default: config eval_file, else the engine's)")
+	fvScale := flag.Int("fv-scale", 0, "engine FV_SCALE option (default: config fv_scale, else 36)")
+	encoding := flag.String("output-encoding", cute.KIFEncodingUTF8, "encoding of the written KIF files: utf8 or sjis")
+	overwrite := flag.Bool("overwrite", false, "annotate files whose output already exists again (default: skip them)")
+	flag.Parse()
+
+	cfgPath, repoRoot, err := resolveConfigPath(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	cfg, err := cute.LoadConfig(cfgPath)
+	if err != nil {
+		fatal(err)
+	}
+	if err := cfg.ApplyFlags("annotate", flag.CommandLine); err != nil {
+		fatal(err)
+	}
+	engineOptions := cute.EvalOptions{Dir: *evalDir, File: *evalFile, FVScale: *fvScale}.Apply(cfg.EngineOptions)
+	enginePath, err := resolveEnginePath(cfg.Engine, repoRoot)
+	if err != nil {
+		fatal(err)
+	}
+	if _, err := os.Stat(enginePath); err != nil && !cute.IsRemoteEngine(enginePath) {
+		fatal(fmt.Errorf("engine binary not found at %s: %w", enginePath, err))
+	}
+	if _, err := cute.EncodeKIF("", *encoding); err != nil {
+		fatal(err)
+	}
+	moveTimeMs := *millis
+	if moveTimeMs <= 0 {
+		moveTimeMs = cfg.Millis
+	}
+	if moveTimeMs <= 0 {
+		moveTimeMs = 1000
+	}
+	evalTimeout := *evalTimeoutFlag
+	if evalTimeout == 0 {
+		evalTimeout = max(10*time.Duration(moveTimeMs)*time.Millisecond, 30*time.Second)
+	}
+
+	var files []string
+	err = cute.WalkKIF(*inputDir, func(path string) error {
+		out, err := outputPath(*inputDir, *outputDir, path)
+		if err != nil {
+			return err
+		}
+		if !*overwrite {
+			if _, err := os.Stat(out); err == nil {
+				return nil
+			}
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		fatal(err)
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "no KIF files to annotate in %s\n", *inputDir)
+		return
+	}
+	workers := min(max(*processNum, 1), len(files))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		fmt.Fprintln(os.Stderr, "\ninterrupted; stopping")
+		cancel()
+	}()
+
+	start := time.Now()
+	jobs := make(chan string)
+	var annotated, failed atomic.Int64
+	var wg sync.WaitGroup
+	var startErr error
+	var startErrOnce sync.Once
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := startSession(ctx, enginePath, engineOptions, evalTimeout)
+			if err != nil {
+				startErrOnce.Do(func() { startErr = err })
+				cancel()
+				return
+			}
+			defer func() { session.Close() }()
+			cache := make(map[string]cute.Score)
+			for path := range jobs {
+				err := annotateFile(ctx, session, path, *inputDir, *outputDir, moveTimeMs, cache, *encoding)
+				if err != nil && ctx.Err() != nil {
+					return
+				}
+				if err != nil && (errors.Is(err, cute.ErrSearchTimeout) || isEngineFailure(err)) {
+					// Replace the engine, which may still be searching
+					// or be gone, and try the file once more.
+					_ = session.Close()
+					if session, err = startSession(ctx, enginePath, engineOptions, evalTimeout); err != nil {
+						startErrOnce.Do(func() { startErr = err })
+						cancel()
+						return
+					}
+					err = annotateFile(ctx, session, path, *inputDir, *outputDir, moveTimeMs, cache, *encoding)
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to annotate %s: %v\n", path, err)
+					failed.Add(1)
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "annotated %s\n", path)
+				annotated.Add(1)
+			}
+		}()
+	}
+send:
+	for _, path := range files {
+		select {
+		case jobs <- path:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if startErr != nil {
+		fatal(startErr)
+	}
+	fmt.Fprintf(os.Stderr, "elapsed: %s, annotated: %d, failed: %d, not done: %d\n",
+		time.Since(start).Round(time.Second), annotated.Load(), failed.Load(), int64(len(files))-annotated.Load()-failed.Load())
+	if failed.Load() > 0 {
+		os.Exit(1)
+	}
+}
+
+// annotateFile evaluates the KIF at path and writes it with the evals as
+// comments to its place under outputDir.
+func annotateFile(ctx context.Context, session *cute.Session, path, inputDir, outputDir string, moveTimeMs int, cache map[string]cute.Score, encoding string) error {
+	record, err := cute.BuildGameRecord(ctx, path, session, moveTimeMs, cache)
+	if err != nil {
+		return err
+	}
+	lines, err := cute.ReadKIFLines(path)
+	if err != nil {
+		return err
+	}
+	lines, err = cute.AnnotateKIF(lines, record)
+	if err != nil {
+		return err
+	}
+	data, err := cute.EncodeKIF(strings.Join(lines, "\n"), encoding)
+	if err != nil {
+		return err
+	}
+	out, err := outputPath(inputDir, outputDir, path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return err
+	}
+	// Write next to the target and rename so an interrupted run leaves no
+	// partial file that a later run would skip.
+	tmp := out + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, out)
+}
+
+// outputPath places the annotated copy of path at the same position under
+// outputDir as path has under inputDir.
+func outputPath(inputDir, outputDir, path string) (string, error) {
+	rel, err := filepath.Rel(inputDir, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(outputDir, rel), nil
+}
+
+func startSession(ctx context.Context, enginePath string, options map[string]string, evalTimeout time.Duration) (*cute.Session, error) {
+	session, err := cute.StartSession(ctx, enginePath)
+	if err != nil {
+		return nil, err
+	}
+	session.SetOptions(options)
+	session.SetSearchTimeout(evalTimeout)
+	if err := session.Handshake(ctx); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+func isEngineFailure(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "EOF") || strings.Contains(msg, "engine stdout closed")
+}
+
+// resolveConfigPath returns the absolute config path and its directory,
+// searching for config.json when arg is empty.
+func resolveConfigPath(arg string) (string, string, error) {
+	if arg != "" {
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			return "", "", err
+		}
+		return abs, filepath.Dir(abs), nil
+	}
+	return cute.FindConfigPath()
+}
+
+func resolveEnginePath(cfgEngine, repoRoot string) (string, error) {
+	if cfgEngine == "" {
+		return "", errors.New("engine path is required")
+	}
+	// A tcp:// or ssh:// engine is used as is.
+	if filepath.IsAbs(cfgEngine) || cute.IsRemoteEngine(cfgEngine) {
+		return cfgEngine, nil
+	}
+	return filepath.Join(repoRoot, cfgEngine), nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	cute "cute/pkg/cute"
+)
+
+// ---------------------------------------------------------------------------
+// Book writer – Apery binary format
+// ---------------------------------------------------------------------------
+
+// aperyEntry is one 16-byte record of an Apery book.
+type aperyEntry struct {
+	key       uint64
+	fromToPro uint16
+	count     uint16
+	score     int32
+}
+
+// writeAperyBook writes data as an Apery binary book: little-endian entries
+// sorted by key, with entries of the same key ordered by count descending.
+// Counts above 65535 are clamped; score is the engine eval when available.
+func writeAperyBook(path string, data map[cute.PackedPosition]*posInfo) error {
+	var entries []aperyEntry
+	for _, info := range data {
+		pos, err := cute.PositionFromSFEN(info.sfen)
+		if err != nil {
+			return fmt.Errorf("sfen %s: %w", info.sfen, err)
+		}
+		key := cute.AperyKey(pos)
+		for move, count := range info.moves {
+			encoded, err := cute.AperyMove(move)
+			if err != nil {
+				return fmt.Errorf("sfen %s: %w", info.sfen, err)
+			}
+			if count > math.MaxUint16 {
+				count = math.MaxUint16
+			}
+			entries = append(entries, aperyEntry{
+				key:       key,
+				fromToPro: encoded,
+				count:     uint16(count),
+				score:     info.evals[move].eval,
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key != entries[j].key {
+			return entries[i].key < entries[j].key
+		}
+		if entries[i].count != entries[j].count {
+			return entries[i].count > entries[j].count
+		}
+		return entries[i].fromToPro < entries[j].fromToPro
+	})
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	var buf [16]byte
+	for _, e := range entries {
+		binary.LittleEndian.PutUint64(buf[0:8], e.key)
+		binary.LittleEndian.PutUint16(buf[8:10], e.fromToPro)
+		binary.LittleEndian.PutUint16(buf[10:12], e.count)
+		binary.LittleEndian.PutUint32(buf[12:16], uint32(e.score))
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	cute "cute/pkg/cute"
+)
+
+// ---------------------------------------------------------------------------
+// Engine evaluation – fill eval/depth for every book move
+// ---------------------------------------------------------------------------
+
+// bookEval is the engine evaluation of a book move, stored in the
+// eval/depth columns of the DB2016 format.
+type bookEval struct {
+	eval  int32 // cp from the perspective of the side that plays the move
+	depth int32
+}
+
+// mateValue mirrors YaneuraOu's VALUE_MATE; mate in n is written as
+// ±(mateValue - n).
+const mateValue = 32000
+
+// evaluateBook searches the position after each book move with engines
+// set up with options and records the result in info.evals. Failed
+// evaluations are counted and left at 0.
+func evaluateBook(ctx context.Context, data map[cute.PackedPosition]*posInfo, enginePath string, options map[string]string, workers, moveTimeMs int) (int, error) {
+	type job struct {
+		info *posInfo
+		move string
+	}
+	var jobs []job
+	for _, info := range data {
+		moves := make([]string, 0, len(info.moves))
+		for m := range info.moves {
+			moves = append(moves, m)
+		}
+		sort.Strings(moves)
+		for _, m := range moves {
+			jobs = append(jobs, job{info, m})
+		}
+	}
+
+	pool, err := cute.NewEnginePoolWith(ctx, workers, enginePath, options)
+	if err != nil {
+		return 0, err
+	}
+	defer pool.Close()
+
+	var mu sync.Mutex
+	var processed, failed atomic.Int64
+	ch := make(chan job, workers*4)
+	var wg sync.WaitGroup
+	var poolErr error
+	var poolErrOnce sync.Once
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := pool.Acquire(ctx)
+			if err != nil {
+				poolErrOnce.Do(func() { poolErr = err })
+				return
+			}
+			defer func() {
+				if session != nil {
+					pool.Release(session)
+				}
+			}()
+			for j := range ch {
+				result, err := evaluateMove(ctx, session, j.info.sfen, j.move, moveTimeMs)
+				if err != nil && !errors.Is(err, errIllegalBookMove) && ctx.Err() == nil {
+					// Assume the engine died; restart and retry once.
+					session, err = pool.Restart(ctx, session)
+					if err != nil {
+						poolErrOnce.Do(func() { poolErr = err })
+						session = nil
+						return
+					}
+					result, err = evaluateMove(ctx, session, j.info.sfen, j.move, moveTimeMs)
+				}
+				if err != nil {
+					failed.Add(1)
+				} else {
+					mu.Lock()
+					if j.info.evals == nil {
+						j.info.evals = make(map[string]bookEval)
+					}
+					j.info.evals[j.move] = result
+					mu.Unlock()
+				}
+				if n := processed.Add(1); n%1000 == 0 {
+					fmt.Fprintf(os.Stderr, "\r  %d/%d", n, len(jobs))
+				}
+			}
+		}()
+	}
+	for _, j := range jobs {
+		ch <- j
+	}
+	close(ch)
+	wg.Wait()
+	fmt.Fprintf(os.Stderr, "\r  %d/%d\n", processed.Load(), len(jobs))
+	if poolErr != nil {
+		return int(failed.Load()), poolErr
+	}
+	return int(failed.Load()), nil
+}
+
+var errIllegalBookMove = errors.New("illegal book move")
+
+// evaluateMove applies move to the position and searches the resulting
+// position. The score is converted to the mover's perspective.
+func evaluateMove(ctx context.Context, session *cute.Session, sfen, move string, moveTimeMs int) (bookEval, error) {
+	pos, err := cute.PositionFromSFEN(sfen)
+	if err != nil {
+		return bookEval{}, err
+	}
+	mover := pos.Turn()
+	if err := pos.ApplyMove(move); err != nil || !pos.IsLegalPosition() {
+		return bookEval{}, errIllegalBookMove
+	}
+	result, err := session.Search(ctx, pos.ToSFEN(1), moveTimeMs)
+	if err != nil {
+		return bookEval{}, err
+	}
+	eval := scoreToBookValue(result.Score)
+	if mover == cute.White {
+		eval = -eval
+	}
+	return bookEval{eval: eval, depth: int32(result.Depth)}, nil
+}
+
+// scoreToBookValue converts a sente-perspective score into a cp value,
+// mapping mate scores to ±(mateValue - n).
+func scoreToBookValue(score cute.Score) int32 {
+	if score.Kind != "mate" {
+		return int32(score.Value)
+	}
+	n := score.Value
+	if n < 0 {
+		return -int32(mateValue + n)
+	}
+	return int32(mateValue - n)
+}
+
+// resolveConfigPath returns the absolute config path and its directory,
+// searching upward for config.json when arg is empty.
+func resolveConfigPath(arg string) (string, string, error) {
+	if arg != "" {
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			return "", "", err
+		}
+		return abs, filepath.Dir(abs), nil
+	}
+	return cute.FindConfigPath()
+}
+
+func resolveEnginePath(cfgEngine, repoRoot string) (string, error) {
+	if cfgEngine == "" {
+		return "", errors.New("engine path is required")
+	}
+	// A tcp:// or ssh:// engine is used as is.
+	if filepath.IsAbs(cfgEngine) || cute.IsRemoteEngine(cfgEngine) {
+		return cfgEngine, nil
+	}
+	return filepath.Join(repoRoot, cfgEngine), nil
+}
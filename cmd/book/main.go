@@ -2,23 +2,28 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"sort"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	cute "cute/pkg/cute"
 )
 
 // posInfo holds the SFEN string and move counts for a qualified position.
+// evals is filled only in -evaluate mode (or from merged books).
 type posInfo struct {
 	sfen  string
 	moves map[string]uint32
+	evals map[string]bookEval
 }
 
 func main() {
@@ -28,11 +33,46 @@ func main() {
 	maxPly := flag.Int("max-ply", 60, "maximum ply to process per game")
 	maxFiles := flag.Int("max-files", 0, "maximum number of files to process (0=all)")
 	workers := flag.Int("workers", 0, "number of parallel workers (0=NumCPU)")
+	minRating := flag.Int("min-rating", 0, "only count moves played by players rated at least this (0=disabled)")
+	winnerOnly := flag.Bool("winner-only", false, "only count moves played by the eventual winner")
+	resultFilter := flag.String("result", "", "only use games with this result: sente_win or gote_win (empty=all)")
+	evaluate := flag.Bool("evaluate", false, "evaluate every book move with the USI engine to fill eval/depth")
+	configPath := flag.String("config", "", "shared config file: flag defaults, and the engine for -evaluate (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json)")
+	evalMillis := flag.Int("eval-millis", 0, "search time per book move in ms (0=config millis)")
+	format := flag.String("format", "yaneuraou", "output book format: yaneuraou (DB2016 text) or apery (binary)")
+	merge := flag.String("merge", "", "comma-separated existing book files to merge into -output (skips KIF processing)")
+	singlePass := flag.Bool("single-pass", false, "read each file once, counting positions in a count-min sketch (approximate)")
+	sketchWidth := flag.Int("sketch-width", 1<<22, "counters per sketch row (used with -single-pass)")
+	sketchDepth := flag.Int("sketch-depth", 4, "number of sketch rows, 1-8 (used with -single-pass)")
 	flag.Parse()
+	if _, err := cute.LoadCommandConfig("book", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	var writeBookFn func(string, map[cute.PackedPosition]*posInfo) error
+	switch *format {
+	case "yaneuraou":
+		writeBookFn = writeBook
+	case "apery":
+		writeBookFn = writeAperyBook
+	default:
+		fatal(fmt.Errorf("format must be yaneuraou or apery"))
+	}
+
+	if *merge != "" {
+		runMerge(splitPaths(*merge), *outputPath, writeBookFn)
+		return
+	}
 
 	if *workers <= 0 {
 		*workers = runtime.NumCPU()
 	}
+	switch *resultFilter {
+	case "", "sente_win", "gote_win":
+	default:
+		fatal(fmt.Errorf("result must be sente_win or gote_win"))
+	}
+	filter := gameFilter{minRating: int32(*minRating), winnerOnly: *winnerOnly, result: *resultFilter}
 
 	start := time.Now()
 
@@ -50,12 +90,47 @@ func main() {
 	}
 	fmt.Fprintf(os.Stderr, "files: %d, workers: %d, max-ply: %d, threshold: %d\n",
 		totalFiles, *workers, *maxPly, *threshold)
+	if filter.active() {
+		fmt.Fprintf(os.Stderr, "filter: min-rating=%d winner-only=%t result=%q\n",
+			filter.minRating, filter.winnerOnly, filter.result)
+	}
+
+	var data map[cute.PackedPosition]*posInfo
+	if *singlePass {
+		data = singlePassBook(*inputDir, *maxFiles, *maxPly, filter, *threshold, *sketchWidth, *sketchDepth, *workers, totalFiles)
+	} else {
+		data = twoPassBook(*inputDir, *maxFiles, *maxPly, filter, *threshold, *workers, totalFiles)
+	}
+	if len(data) == 0 {
+		fmt.Fprintln(os.Stderr, "no positions meet the threshold; nothing to write")
+		return
+	}
+
+	// ---- Optional pass 3: engine evaluation of book moves ----
+	if *evaluate {
+		fmt.Fprintf(os.Stderr, "pass 3: evaluating book moves...\n")
+		if err := runEvaluate(data, *configPath, *evalMillis, *workers); err != nil {
+			fatal(err)
+		}
+	}
 
+	// ---- Write book file ----
+	if err := writeBookFn(*outputPath, data); err != nil {
+		fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "wrote %s (%d positions) in %v\n",
+		*outputPath, len(data), time.Since(start).Round(time.Millisecond))
+}
+
+// twoPassBook counts positions exactly in pass 1, then collects moves for
+// the positions meeting threshold in pass 2.
+func twoPassBook(inputDir string, maxFiles, maxPly int, filter gameFilter, threshold, workers, totalFiles int) map[cute.PackedPosition]*posInfo {
 	// ---- Pass 1: count position occurrences (memory-efficient) ----
-	// Only stores Packed256 -> uint32, avoiding SFEN string allocations.
+	// Only stores PackedPosition -> uint32, avoiding SFEN string allocations.
 	// Files are streamed via WalkKIF – no []string allocation.
 	fmt.Fprintf(os.Stderr, "pass 1: counting positions...\n")
-	counts, errFiles := runPass1(*inputDir, *maxFiles, *maxPly, *workers, totalFiles)
+	counts, errFiles := runPass1(inputDir, maxFiles, maxPly, filter, workers, totalFiles)
 
 	total := 0
 	for _, c := range counts {
@@ -65,9 +140,9 @@ func main() {
 		len(counts), total, errFiles)
 
 	// Filter: keep only positions meeting the threshold.
-	qual := make(map[cute.Packed256]bool)
+	qual := make(map[cute.PackedPosition]bool)
 	for k, c := range counts {
-		if c >= uint32(*threshold) {
+		if c >= uint32(threshold) {
 			qual[k] = true
 		}
 	}
@@ -76,25 +151,17 @@ func main() {
 	counts = nil
 	runtime.GC()
 
-	fmt.Fprintf(os.Stderr, "  qualified positions (>=%d): %d\n", *threshold, len(qual))
+	fmt.Fprintf(os.Stderr, "  qualified positions (>=%d): %d\n", threshold, len(qual))
 	if len(qual) == 0 {
-		fmt.Fprintln(os.Stderr, "no positions meet the threshold; nothing to write")
-		return
+		return nil
 	}
 
 	// ---- Pass 2: collect moves for qualified positions ----
 	// Re-reads files but only allocates SFEN strings for qualified positions.
 	fmt.Fprintf(os.Stderr, "pass 2: collecting moves...\n")
-	data := runPass2(*inputDir, *maxFiles, *maxPly, qual, *workers, totalFiles)
+	data := runPass2(inputDir, maxFiles, maxPly, filter, qual, workers, totalFiles)
 	fmt.Fprintf(os.Stderr, "  book entries: %d\n", len(data))
-
-	// ---- Write book file ----
-	if err := writeBook(*outputPath, data); err != nil {
-		fatal(err)
-	}
-
-	fmt.Fprintf(os.Stderr, "wrote %s (%d positions) in %v\n",
-		*outputPath, len(data), time.Since(start).Round(time.Millisecond))
+	return data
 }
 
 // ---------------------------------------------------------------------------
@@ -102,20 +169,75 @@ func main() {
 // from which a move was played.
 // ---------------------------------------------------------------------------
 
+// gameFilter restricts which games and which side's moves are counted.
+// The same filter is applied in both passes so counts stay consistent.
+type gameFilter struct {
+	minRating  int32  // minimum rating of the moving player (0=disabled)
+	winnerOnly bool   // only moves by the eventual winner
+	result     string // "sente_win", "gote_win", or "" for any
+}
+
+func (f gameFilter) active() bool {
+	return f.minRating > 0 || f.winnerOnly || f.result != ""
+}
+
+// moverAllowed returns per-color permission for counting moves in a game,
+// or ok=false if the whole game is filtered out.
+func (f gameFilter) moverAllowed(players cute.KIFPlayers, result string) (allowed [2]bool, ok bool) {
+	if f.result != "" && result != f.result {
+		return allowed, false
+	}
+	allowed = [2]bool{true, true}
+	if f.winnerOnly {
+		switch result {
+		case "sente_win":
+			allowed[cute.White] = false
+		case "gote_win":
+			allowed[cute.Black] = false
+		default:
+			return allowed, false
+		}
+	}
+	if f.minRating > 0 {
+		if players.SenteRating < f.minRating {
+			allowed[cute.Black] = false
+		}
+		if players.GoteRating < f.minRating {
+			allowed[cute.White] = false
+		}
+	}
+	return allowed, allowed[cute.Black] || allowed[cute.White]
+}
+
 // iteratePositions loads a KIF file, replays moves up to maxPly, and calls fn
-// for each position that has a following move.
+// for each position that has a following move. Positions whose mover is
+// excluded by filter are not emitted.
 //
 // Parameters passed to fn:
-//   - packed : 256-bit packed position (suitable as map key, 32 bytes)
+//   - packed : packed position (map key; PackedVar if not Packed256)
 //   - pos    : borrowed pointer to the current position – do NOT store
 //   - ply    : SFEN move number for this position
 //   - move   : USI-format move played from this position
 func iteratePositions(
 	path string,
 	maxPly int,
-	fn func(packed cute.Packed256, pos *cute.Position, ply int, move string),
+	filter gameFilter,
+	fn func(packed cute.PackedPosition, pos *cute.Position, ply int, move string),
 ) error {
-	board, err := cute.LoadBoardFromKIF(path)
+	lines, err := cute.ReadKIFLines(path)
+	if err != nil {
+		return err
+	}
+	allowed := [2]bool{true, true}
+	if filter.active() {
+		result, _ := cute.ResultFromKIFLines(lines)
+		var ok bool
+		allowed, ok = filter.moverAllowed(cute.PlayersFromKIFLines(lines), result)
+		if !ok {
+			return nil
+		}
+	}
+	board, err := cute.BoardFromKIF(lines)
 	if err != nil {
 		return err
 	}
@@ -126,8 +248,10 @@ func iteratePositions(
 	}
 
 	// Emit the initial position (ply 1) with the first move.
-	if packed, err := cute.PackPosition256(pos); err == nil {
-		fn(packed, &pos, 1, moves[0])
+	if allowed[pos.Turn()] {
+		if packed, err := cute.PackPosition(pos); err == nil {
+			fn(packed, &pos, 1, moves[0])
+		}
 	}
 
 	limit := maxPly
@@ -146,7 +270,10 @@ func iteratePositions(
 		if i+1 >= len(moves) || i+1 >= maxPly {
 			break
 		}
-		packed, err := cute.PackPosition256(pos)
+		if !allowed[pos.Turn()] {
+			continue
+		}
+		packed, err := cute.PackPosition(pos)
 		if err != nil {
 			break
 		}
@@ -174,11 +301,11 @@ func feedFiles(inputDir string, maxFiles int, ch chan<- string) {
 }
 
 // ---------------------------------------------------------------------------
-// Pass 1 – count occurrences (Packed256 → uint32)
+// Pass 1 – count occurrences (PackedPosition → uint32)
 // ---------------------------------------------------------------------------
 
-func runPass1(inputDir string, maxFiles, maxPly, workers, totalFiles int) (map[cute.Packed256]uint32, int) {
-	counts := make(map[cute.Packed256]uint32)
+func runPass1(inputDir string, maxFiles, maxPly int, filter gameFilter, workers, totalFiles int) (map[cute.PackedPosition]uint32, int) {
+	counts := make(map[cute.PackedPosition]uint32)
 	var mu sync.Mutex
 	var processed, errCount atomic.Int64
 
@@ -189,11 +316,11 @@ func runPass1(inputDir string, maxFiles, maxPly, workers, totalFiles int) (map[c
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			batch := make([]cute.Packed256, 0, 64)
+			batch := make([]cute.PackedPosition, 0, 64)
 			for path := range ch {
 				batch = batch[:0]
-				err := iteratePositions(path, maxPly,
-					func(packed cute.Packed256, _ *cute.Position, _ int, _ string) {
+				err := iteratePositions(path, maxPly, filter,
+					func(packed cute.PackedPosition, _ *cute.Position, _ int, _ string) {
 						batch = append(batch, packed)
 					})
 				if err != nil {
@@ -224,13 +351,13 @@ func runPass1(inputDir string, maxFiles, maxPly, workers, totalFiles int) (map[c
 // Pass 2 – collect moves for qualified positions
 // ---------------------------------------------------------------------------
 
-func runPass2(inputDir string, maxFiles, maxPly int, qual map[cute.Packed256]bool, workers, totalFiles int) map[cute.Packed256]*posInfo {
-	data := make(map[cute.Packed256]*posInfo)
+func runPass2(inputDir string, maxFiles, maxPly int, filter gameFilter, qual map[cute.PackedPosition]bool, workers, totalFiles int) map[cute.PackedPosition]*posInfo {
+	data := make(map[cute.PackedPosition]*posInfo)
 	var mu sync.Mutex
 	var processed atomic.Int64
 
 	type localEntry struct {
-		packed cute.Packed256
+		packed cute.PackedPosition
 		sfen   string
 		move   string
 	}
@@ -245,8 +372,8 @@ func runPass2(inputDir string, maxFiles, maxPly int, qual map[cute.Packed256]boo
 			batch := make([]localEntry, 0, 16)
 			for path := range ch {
 				batch = batch[:0]
-				_ = iteratePositions(path, maxPly,
-					func(packed cute.Packed256, pos *cute.Position, ply int, move string) {
+				_ = iteratePositions(path, maxPly, filter,
+					func(packed cute.PackedPosition, pos *cute.Position, ply int, move string) {
 						if !qual[packed] {
 							return
 						}
@@ -280,10 +407,10 @@ func runPass2(inputDir string, maxFiles, maxPly int, qual map[cute.Packed256]boo
 }
 
 // ---------------------------------------------------------------------------
-// Book writer – YaneuraOu DB format
+// Book writer – YaneuraOu DB format (Apery format: see apery.go)
 // ---------------------------------------------------------------------------
 
-func writeBook(path string, data map[cute.Packed256]*posInfo) error {
+func writeBook(path string, data map[cute.PackedPosition]*posInfo) error {
 	f, err := os.Create(path)
 	if err != nil {
 		return err
@@ -325,15 +452,73 @@ func writeBook(path string, data map[cute.Packed256]*posInfo) error {
 		})
 
 		// Format: <move> <response> <eval> <depth> <count>
-		// response=none (no tracking), eval=0, depth=0
+		// response=none (no tracking); eval/depth are 0 unless evaluated.
 		for _, m := range ms {
-			fmt.Fprintf(w, "%s none 0 0 %d\n", m.move, m.count)
+			ev := e.evals[m.move]
+			fmt.Fprintf(w, "%s none %d %d %d\n", m.move, ev.eval, ev.depth, m.count)
 		}
 	}
 
 	return w.Flush()
 }
 
+// runEvaluate loads the engine from config and fills eval/depth for data.
+func runEvaluate(data map[cute.PackedPosition]*posInfo, configPath string, evalMillis, workers int) error {
+	cfgPath, repoRoot, err := resolveConfigPath(configPath)
+	if err != nil {
+		return err
+	}
+	cfg, err := cute.LoadConfig(cfgPath)
+	if err != nil {
+		return err
+	}
+	enginePath, err := resolveEnginePath(cfg.Engine, repoRoot)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(enginePath); err != nil && !cute.IsRemoteEngine(enginePath) {
+		return fmt.Errorf("engine binary not found at %s: %w", enginePath, err)
+	}
+	moveTimeMs := evalMillis
+	if moveTimeMs <= 0 {
+		moveTimeMs = cfg.Millis
+	}
+	if moveTimeMs <= 0 {
+		moveTimeMs = 1000
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	failed, err := evaluateBook(ctx, data, enginePath, cfg.EvalOptions().Apply(cfg.EngineOptions), workers, moveTimeMs)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "  failed evaluations (written as 0): %d\n", failed)
+	}
+	return nil
+}
+
+// runMerge combines existing books into a single book at outputPath.
+func runMerge(paths []string, outputPath string, writeBookFn func(string, map[cute.PackedPosition]*posInfo) error) {
+	start := time.Now()
+	if len(paths) == 0 {
+		fatal(fmt.Errorf("-merge requires at least one book file"))
+	}
+	fmt.Fprintf(os.Stderr, "merging %d books...\n", len(paths))
+	data, skipped, err := mergeBooks(paths)
+	if err != nil {
+		fatal(err)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "  skipped positions (cannot pack): %d\n", skipped)
+	}
+	if err := writeBookFn(outputPath, data); err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s (%d positions) in %v\n",
+		outputPath, len(data), time.Since(start).Round(time.Millisecond))
+}
+
 func fatal(err error) {
 	fmt.Fprintf(os.Stderr, "error: %v\n", err)
 	os.Exit(1)
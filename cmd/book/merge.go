@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+)
+
+// ---------------------------------------------------------------------------
+// Book merge – combine existing YaneuraOu DB2016 books
+// ---------------------------------------------------------------------------
+
+// mergeBooks reads each book and sums move counts per position. Positions
+// are keyed by their packed form so the same position written with a
+// different move number (or hand order) is merged into one entry. When a
+// move carries an engine evaluation, the deepest one is kept.
+// Returns the merged data and the number of positions that could not be
+// packed (e.g. with two kings of one side) and were skipped.
+func mergeBooks(paths []string) (map[cute.PackedPosition]*posInfo, int, error) {
+	data := make(map[cute.PackedPosition]*posInfo)
+	skipped := 0
+	for _, path := range paths {
+		n, err := readBook(path, data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", path, err)
+		}
+		skipped += n
+	}
+	return data, skipped, nil
+}
+
+// readBook parses a YaneuraOu DB2016 book and adds its entries into data.
+func readBook(path string, data map[cute.PackedPosition]*posInfo) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var current *posInfo
+	skipped := 0
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if sfen, ok := strings.CutPrefix(line, "sfen "); ok {
+			current = nil
+			pos, err := cute.PositionFromSFEN(sfen)
+			if err != nil {
+				return skipped, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			packed, err := cute.PackPosition(pos)
+			if err != nil {
+				skipped++
+				continue
+			}
+			current = data[packed]
+			if current == nil {
+				current = &posInfo{sfen: normalizeBookSFEN(sfen), moves: make(map[string]uint32)}
+				data[packed] = current
+			}
+			continue
+		}
+		if current == nil {
+			// Move line of a skipped position (or before any sfen line).
+			continue
+		}
+		// Format: <move> <response> <eval> <depth> <count>
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			return skipped, fmt.Errorf("line %d: invalid move line: %q", lineNo, line)
+		}
+		count, err := strconv.ParseUint(fields[4], 10, 32)
+		if err != nil {
+			return skipped, fmt.Errorf("line %d: invalid count: %w", lineNo, err)
+		}
+		current.moves[fields[0]] += uint32(count)
+		eval, err1 := strconv.ParseInt(fields[2], 10, 32)
+		depth, err2 := strconv.ParseInt(fields[3], 10, 32)
+		if err1 != nil || err2 != nil {
+			return skipped, fmt.Errorf("line %d: invalid eval/depth: %q", lineNo, line)
+		}
+		if depth > 0 || eval != 0 {
+			if current.evals == nil {
+				current.evals = make(map[string]bookEval)
+			}
+			if prev, ok := current.evals[fields[0]]; !ok || int32(depth) > prev.depth {
+				current.evals[fields[0]] = bookEval{eval: int32(eval), depth: int32(depth)}
+			}
+		}
+	}
+	return skipped, scanner.Err()
+}
+
+// normalizeBookSFEN keeps the sfen as written but ensures it has a move
+// number, which some writers omit.
+func normalizeBookSFEN(sfen string) string {
+	if len(strings.Fields(sfen)) == 3 {
+		return sfen + " 1"
+	}
+	return sfen
+}
+
+// splitPaths parses a comma-separated path list.
+func splitPaths(raw string) []string {
+	var paths []string
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			paths = append(paths, part)
+		}
+	}
+	return paths
+}
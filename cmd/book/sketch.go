@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	cute "cute/pkg/cute"
+)
+
+// ---------------------------------------------------------------------------
+// Single-pass mode – approximate occurrence counts with a count-min sketch
+// ---------------------------------------------------------------------------
+
+// countMinSketch is a fixed-size approximate counter. Estimates never
+// undercount; they may overcount when keys collide in every row.
+type countMinSketch struct {
+	rows  [][]atomic.Uint32
+	width uint64
+}
+
+// sketchSeeds are per-row hash seeds (arbitrary odd constants).
+var sketchSeeds = []uint64{
+	0x9E3779B97F4A7C15,
+	0xC2B2AE3D27D4EB4F,
+	0x165667B19E3779F9,
+	0xD6E8FEB86659FD93,
+	0xFF51AFD7ED558CCD,
+	0xC4CEB9FE1A85EC53,
+	0x94D049BB133111EB,
+	0xBF58476D1CE4E5B9,
+}
+
+func newCountMinSketch(width, depth int) *countMinSketch {
+	rows := make([][]atomic.Uint32, depth)
+	for i := range rows {
+		rows[i] = make([]atomic.Uint32, width)
+	}
+	return &countMinSketch{rows: rows, width: uint64(width)}
+}
+
+// add increments key and returns its new estimated count.
+func (s *countMinSketch) add(key cute.PackedPosition) uint32 {
+	est := ^uint32(0)
+	for i, row := range s.rows {
+		v := row[s.index(key, i)].Add(1)
+		if v < est {
+			est = v
+		}
+	}
+	return est
+}
+
+func (s *countMinSketch) index(key cute.PackedPosition, row int) uint64 {
+	h := sketchSeeds[row]
+	mix := func(w uint64) {
+		h ^= w
+		h *= 0x100000001B3
+		h ^= h >> 29
+	}
+	switch key := key.(type) {
+	case cute.Packed256:
+		for _, w := range key.Words {
+			mix(w)
+		}
+	default:
+		// Variable-length positions (handicap games) are rare; hash
+		// their bytes in 8-byte words.
+		data, _ := key.MarshalBinary()
+		for len(data) > 0 {
+			var buf [8]byte
+			n := copy(buf[:], data)
+			data = data[n:]
+			mix(binary.LittleEndian.Uint64(buf[:]))
+		}
+	}
+	return h % s.width
+}
+
+// runSinglePass counts positions in a sketch and records moves once a
+// position's estimated count reaches threshold, reading each file once.
+//
+// Trade-off versus the two-pass mode: the threshold-1 occurrences seen before
+// a position qualifies are not attributed to moves, so move counts are lower
+// by up to threshold-1, and sketch collisions may admit a few positions whose
+// true count is below threshold.
+func runSinglePass(inputDir string, maxFiles, maxPly int, filter gameFilter, threshold uint32, sketch *countMinSketch, workers, totalFiles int) (map[cute.PackedPosition]*posInfo, int) {
+	data := make(map[cute.PackedPosition]*posInfo)
+	var mu sync.Mutex
+	var processed, errCount atomic.Int64
+
+	type localEntry struct {
+		packed cute.PackedPosition
+		sfen   string
+		move   string
+	}
+
+	ch := make(chan string, workers*4)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batch := make([]localEntry, 0, 16)
+			for path := range ch {
+				batch = batch[:0]
+				err := iteratePositions(path, maxPly, filter,
+					func(packed cute.PackedPosition, pos *cute.Position, ply int, move string) {
+						if sketch.add(packed) < threshold {
+							return
+						}
+						batch = append(batch, localEntry{packed, pos.ToSFEN(ply), move})
+					})
+				if err != nil {
+					errCount.Add(1)
+				}
+				if len(batch) > 0 {
+					mu.Lock()
+					for _, e := range batch {
+						info := data[e.packed]
+						if info == nil {
+							info = &posInfo{sfen: e.sfen, moves: make(map[string]uint32)}
+							data[e.packed] = info
+						}
+						info.moves[e.move]++
+					}
+					mu.Unlock()
+				}
+				if n := processed.Add(1); n%10000 == 0 {
+					fmt.Fprintf(os.Stderr, "\r  %d/%d", n, totalFiles)
+				}
+			}
+		}()
+	}
+
+	feedFiles(inputDir, maxFiles, ch)
+	wg.Wait()
+	fmt.Fprintf(os.Stderr, "\r  %d/%d\n", processed.Load(), totalFiles)
+
+	return data, int(errCount.Load())
+}
+
+// singlePassBook builds the book in one read of the input using a
+// count-min sketch of sketchWidth x sketchDepth counters.
+func singlePassBook(inputDir string, maxFiles, maxPly int, filter gameFilter, threshold, sketchWidth, sketchDepth, workers, totalFiles int) map[cute.PackedPosition]*posInfo {
+	if sketchWidth <= 0 || sketchDepth <= 0 || sketchDepth > len(sketchSeeds) {
+		fatal(fmt.Errorf("sketch-width must be > 0 and sketch-depth must be 1-%d", len(sketchSeeds)))
+	}
+	sketch := newCountMinSketch(sketchWidth, sketchDepth)
+	fmt.Fprintf(os.Stderr, "single pass: counting positions and collecting moves (sketch %dx%d, %d MiB)...\n",
+		sketchDepth, sketchWidth, sketchDepth*sketchWidth*4>>20)
+	data, errFiles := runSinglePass(inputDir, maxFiles, maxPly, filter, uint32(threshold), sketch, workers, totalFiles)
+	fmt.Fprintf(os.Stderr, "  book entries: %d, file errors: %d\n", len(data), errFiles)
+	return data
+}
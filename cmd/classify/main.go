@@ -0,0 +1,297 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/opening"
+	"cute/pkg/cute/openingdb"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+func main() {
+	inputDir := flag.String("input", "", "directory containing KIF files (KIF paths may also be given as arguments)")
+	outputPath := flag.String("output", "out/kif_tags.parquet", "output path")
+	format := flag.String("format", "parquet", "output format: parquet|arrow (Arrow IPC file)")
+	limit := flag.Int("limit", 0, "limit number of files processed (0=disabled)")
+	workers := flag.Int("workers", 0, "number of parallel workers (0=NumCPU)")
+	skipExisting := flag.Bool("skip-existing", true, "keep the rows of an existing parquet -output and skip their game_ids")
+	dryRun := flag.Bool("dry-run", false, "classify without writing parquet")
+	verbose := flag.Bool("verbose", false, "print per-file tags")
+	openingPlies := flag.Int("opening-plies", opening.DefaultOpeningPlies, "plies in which a rook move decides the attack tag")
+	parallel := flag.Int64("parallel", 4, "parquet read/write parallelism")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("classify", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	if *workers <= 0 {
+		*workers = runtime.NumCPU()
+	}
+	if *format != "parquet" && *format != "arrow" {
+		fatal(fmt.Errorf("format must be parquet or arrow"))
+	}
+
+	var paths []string
+	if *inputDir != "" {
+		if err := cute.WalkKIF(*inputDir, func(path string) error {
+			paths = append(paths, path)
+			return nil
+		}); err != nil {
+			fatal(err)
+		}
+	}
+	paths = append(paths, flag.Args()...)
+	if len(paths) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	sort.Strings(paths)
+
+	var existing []openingdb.Record
+	// An Arrow output is always rewritten from scratch.
+	if *skipExisting && !*dryRun && *format == "parquet" {
+		var err error
+		if existing, err = readExisting(*outputPath, *parallel); err != nil {
+			fatal(fmt.Errorf("read existing %s: %w", *outputPath, err))
+		}
+	}
+	seen := make(map[string]bool, len(existing))
+	for _, r := range existing {
+		if r.GameID != nil {
+			seen[*r.GameID] = true
+		}
+	}
+	todo := paths[:0]
+	for _, path := range paths {
+		if !seen[gameIDFromPath(path)] {
+			todo = append(todo, path)
+		}
+	}
+	skipped := len(paths) - len(todo)
+	if *limit > 0 && len(todo) > *limit {
+		todo = todo[:*limit]
+	}
+	fmt.Fprintf(os.Stderr, "files: %d (skipped existing: %d), workers: %d\n", len(todo), skipped, *workers)
+
+	opts := opening.Options{OpeningPlies: *openingPlies}
+	rows := make([]openingdb.Record, len(todo))
+	ok := make([]bool, len(todo))
+	var (
+		mu        sync.Mutex
+		processed int
+		errors    int
+		lastLog   = time.Now()
+	)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				row, err := buildRow(todo[i], opts)
+				mu.Lock()
+				processed++
+				if err != nil {
+					errors++
+					fmt.Fprintf(os.Stderr, "%s: %v\n", todo[i], err)
+				} else {
+					rows[i], ok[i] = row, true
+					if *verbose {
+						fmt.Printf("%s: sente=%s/%s/%s gote=%s/%s/%s\n", todo[i],
+							deref(row.SenteAttackTags), deref(row.SenteDefenseTags), deref(row.SenteNoteTags),
+							deref(row.GoteAttackTags), deref(row.GoteDefenseTags), deref(row.GoteNoteTags))
+					}
+				}
+				if time.Since(lastLog) >= time.Second {
+					fmt.Fprintf(os.Stderr, "progress: %d/%d errors=%d\n", processed, len(todo), errors)
+					lastLog = time.Now()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range todo {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	created := 0
+	out := existing
+	for i := range rows {
+		if ok[i] {
+			out = append(out, rows[i])
+			created++
+		}
+	}
+	if !*dryRun {
+		if err := writeRecords(*outputPath, *format, out, *parallel); err != nil {
+			fatal(err)
+		}
+	}
+	fmt.Printf("processed=%d created=%d skipped=%d errors=%d\n", processed, created, skipped, errors)
+}
+
+// buildRow classifies one KIF file.
+func buildRow(path string, opts opening.Options) (openingdb.Record, error) {
+	lines, err := cute.ReadKIFLines(path)
+	if err != nil {
+		return openingdb.Record{}, err
+	}
+	board, err := cute.BoardFromKIF(lines)
+	if err != nil {
+		return openingdb.Record{}, err
+	}
+	result, err := opening.Classify(board, opts)
+	if err != nil {
+		return openingdb.Record{}, err
+	}
+	players := cute.PlayersFromKIFLines(lines)
+	return openingdb.Record{
+		GameID:             strPtr(gameIDFromPath(path)),
+		GameType:           optStr(cute.KIFHeaderValue(lines, "棋戦")),
+		SenteName:          optStr(players.SenteName),
+		SenteRating:        optInt(players.SenteRating),
+		GoteName:           optStr(players.GoteName),
+		GoteRating:         optInt(players.GoteRating),
+		TurnMax:            int32Ptr(int32(board.MoveCount())),
+		SenteAttackTags:    joinTags(result.Sente.Attack),
+		SenteDefenseTags:   joinTags(result.Sente.Defense),
+		SenteTechniqueTags: joinTags(result.Sente.Technique),
+		SenteNoteTags:      joinTags(result.Sente.Note),
+		GoteAttackTags:     joinTags(result.Gote.Attack),
+		GoteDefenseTags:    joinTags(result.Gote.Defense),
+		GoteTechniqueTags:  joinTags(result.Gote.Technique),
+		GoteNoteTags:       joinTags(result.Gote.Note),
+	}, nil
+}
+
+// gameIDFromPath strips the directory and .kif extension, as the Ruby
+// classifier does.
+func gameIDFromPath(path string) string {
+	base := filepath.Base(path)
+	if ext := filepath.Ext(base); strings.EqualFold(ext, ".kif") {
+		base = strings.TrimSuffix(base, ext)
+	}
+	return base
+}
+
+func joinTags(tags []string) *string { return strPtr(strings.Join(tags, ", ")) }
+
+func strPtr(s string) *string { return &s }
+func int32Ptr(n int32) *int32 { return &n }
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// optStr and optInt map missing header values to null.
+func optStr(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func optInt(n int32) *int32 {
+	if n == 0 {
+		return nil
+	}
+	return &n
+}
+
+// readExisting returns the rows of path, or nil when it does not exist.
+func readExisting(path string, parallel int64) ([]openingdb.Record, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fileReader.Close()
+	parquetReader, err := reader.NewParquetReader(fileReader, new(openingdb.Record), parallel)
+	if err != nil {
+		return nil, err
+	}
+	defer parquetReader.ReadStop()
+	rows := make([]openingdb.Record, int(parquetReader.GetNumRows()))
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	if err := parquetReader.Read(&rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// writeRecords writes rows to path via a temporary file, so an existing
+// output is only replaced once the new one is complete.
+func writeRecords(path, format string, rows []openingdb.Record, parallel int64) error {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	if format == "arrow" {
+		w, err := cute.NewArrowWriter(tmp, openingdb.Record{}, nil)
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if err := w.Write(r); err != nil {
+				w.Close()
+				return err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp, path)
+	}
+	fileWriter, err := local.NewLocalFileWriter(tmp)
+	if err != nil {
+		return err
+	}
+	defer fileWriter.Close()
+	parquetWriter, err := writer.NewParquetWriter(fileWriter, new(openingdb.Record), parallel)
+	if err != nil {
+		return err
+	}
+	parquetWriter.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, r := range rows {
+		if err := parquetWriter.Write(r); err != nil {
+			return err
+		}
+	}
+	if err := parquetWriter.WriteStop(); err != nil {
+		return err
+	}
+	if err := fileWriter.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
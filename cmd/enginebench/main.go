@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	cute "cute/pkg/cute"
+)
+
+// evalClip bounds evals (and mates) when comparing searches, so a mate
+// found by one limit and a large cp eval found by another differ by a
+// finite amount.
+const evalClip = 3000
+
+// benchLimit is one search limit of the benchmark.
+type benchLimit struct {
+	cute.SearchLimit
+	label string
+}
+
+// sample is the outcome of one search.
+type sample struct {
+	ok      bool
+	eval    int
+	depth   int
+	nodes   int
+	nps     int
+	elapsed time.Duration
+}
+
+// limitSummary is the report row of one limit.
+type limitSummary struct {
+	Limit      string  `json:"limit"`
+	MoveTimeMs int     `json:"movetime_ms,omitempty"`
+	Nodes      int     `json:"nodes,omitempty"`
+	Reference  bool    `json:"reference"`
+	Searches   int     `json:"searches"`
+	Failed     int     `json:"failed"`
+	AvgTimeMs  float64 `json:"avg_time_ms"`
+	AvgDepth   float64 `json:"avg_depth"`
+	AvgNodes   float64 `json:"avg_nodes"`
+	// NPSPerThread is the mean nodes/sec of one engine; engines run with
+	// Threads=1, -process-num of them at once.
+	NPSPerThread float64 `json:"nps_per_thread"`
+	// MeanAbsDiff and MaxAbsDiff compare evals with the reference limit
+	// (mean over its repeats), in cp clipped to ±evalClip.
+	MeanAbsDiff float64 `json:"mean_abs_diff_cp"`
+	MaxAbsDiff  int     `json:"max_abs_diff_cp"`
+	// WithinTolerance is the fraction of searches within -tolerance cp
+	// of the reference.
+	WithinTolerance float64 `json:"within_tolerance"`
+	// RepeatSpread is the mean max-min eval over the repeats of a
+	// position (run-to-run noise; 0 with -repeat 1).
+	RepeatSpread float64 `json:"repeat_spread_cp"`
+}
+
+type benchReport struct {
+	Engine     cute.EngineInfo `json:"engine"`
+	Positions  int             `json:"positions"`
+	Repeat     int             `json:"repeat"`
+	ProcessNum int             `json:"process_num"`
+	Tolerance  int             `json:"tolerance_cp"`
+	Limits     []limitSummary  `json:"limits"`
+	// SuggestedMoveTimeMs is the shortest movetime whose mean difference
+	// from the reference is within the tolerance (0 if none).
+	SuggestedMoveTimeMs int `json:"suggested_movetime_ms"`
+}
+
+func main() {
+	configPath := flag.String("config", "", "shared config file: engine, engine options and flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json)")
+	suitePath := flag.String("suite", "", "file of SFEN positions, one per line (empty=built-in suite of 12 positions)")
+	moveTimes := flag.String("movetimes", "100,250,500,1000", "comma-separated movetime limits in ms")
+	nodeLimits := flag.String("nodes", "", "comma-separated node limits, searched after the movetimes (e.g. 100000,1000000)")
+	repeat := flag.Int("repeat", 2, "search each position this many times per limit to measure run-to-run variation")
+	processNum := flag.Int("process-num", 1, "engines searching at once (use graph's -process-num to measure nodes/sec under the same load)")
+	tolerance := flag.Int("tolerance", 100, "eval difference in cp from the reference that counts as stable")
+	evalTimeoutFlag := flag.Duration("eval-timeout", 0, "watchdog for a single search (0=auto: 10x movetime, at least 30s, none for node limits; negative disables)")
+	evalDir := flag.String("eval-dir", "", "engine EvalDir option: directory of the evaluation files (default: config eval_dir, else the engine's)")
+	evalFile := flag.String("eval-file", "", "engine EvalFile option: NNUE network file in -eval-dir (default: config eval_file, else the engine's)")
+	fvScale := flag.Int("fv-scale", 0, "engine FV_SCALE option (default: config fv_scale, else 36)")
+	format := flag.String("format", "text", "output format: text|json")
+	flag.Parse()
+
+	cfgPath, repoRoot, err := resolveConfigPath(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	cfg, err := cute.LoadConfig(cfgPath)
+	if err != nil {
+		fatal(err)
+	}
+	if err := cfg.ApplyFlags("enginebench", flag.CommandLine); err != nil {
+		fatal(err)
+	}
+	engineOptions := cute.EvalOptions{Dir: *evalDir, File: *evalFile, FVScale: *fvScale}.Apply(cfg.EngineOptions)
+	if *format != "text" && *format != "json" {
+		fatal(fmt.Errorf("format must be text or json"))
+	}
+	if *repeat < 1 || *processNum < 1 {
+		fatal(fmt.Errorf("-repeat and -process-num must be >= 1"))
+	}
+	var limits []benchLimit
+	ms, err := parseIntList(*moveTimes)
+	if err != nil {
+		fatal(fmt.Errorf("-movetimes: %w", err))
+	}
+	for _, v := range ms {
+		limits = append(limits, benchLimit{cute.SearchLimit{MoveTimeMs: v}, fmt.Sprintf("movetime %dms", v)})
+	}
+	nodes, err := parseIntList(*nodeLimits)
+	if err != nil {
+		fatal(fmt.Errorf("-nodes: %w", err))
+	}
+	for _, v := range nodes {
+		limits = append(limits, benchLimit{cute.SearchLimit{Nodes: v}, fmt.Sprintf("nodes %d", v)})
+	}
+	if len(limits) == 0 {
+		fatal(fmt.Errorf("no limits: pass -movetimes or -nodes"))
+	}
+
+	suite := defaultSuite
+	if *suitePath != "" {
+		if suite, err = readSuite(*suitePath); err != nil {
+			fatal(err)
+		}
+	}
+
+	enginePath, err := resolveEnginePath(cfg.Engine, repoRoot)
+	if err != nil {
+		fatal(err)
+	}
+	if _, err := os.Stat(enginePath); err != nil && !cute.IsRemoteEngine(enginePath) {
+		fatal(fmt.Errorf("engine binary not found at %s: %w", enginePath, err))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	total := len(limits) * *repeat * len(suite)
+	fmt.Fprintf(os.Stderr, "%d positions x %d limits x %d repeats = %d searches on %d engines\n",
+		len(suite), len(limits), *repeat, total, *processNum)
+	samples, info, err := runBench(ctx, enginePath, engineOptions, suite, limits, *repeat, *processNum, *evalTimeoutFlag)
+	if err != nil {
+		fatal(err)
+	}
+
+	report := benchReport{
+		Engine:     info,
+		Positions:  len(suite),
+		Repeat:     *repeat,
+		ProcessNum: *processNum,
+		Tolerance:  *tolerance,
+		Limits:     summarize(limits, samples, *tolerance),
+	}
+	for _, row := range report.Limits {
+		if row.MoveTimeMs > 0 && !row.Reference && row.Searches > 0 && row.MeanAbsDiff <= float64(*tolerance) {
+			if report.SuggestedMoveTimeMs == 0 || row.MoveTimeMs < report.SuggestedMoveTimeMs {
+				report.SuggestedMoveTimeMs = row.MoveTimeMs
+			}
+		}
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	printReport(report)
+}
+
+// runBench searches every position under every limit, repeat times, on
+// workers engines set up with options. samples[limit][repeat][position]
+// holds the results.
+func runBench(ctx context.Context, enginePath string, options map[string]string, suite []string, limits []benchLimit, repeat, workers int, evalTimeout time.Duration) ([][][]sample, cute.EngineInfo, error) {
+	type job struct{ limit, repeat, pos int }
+	samples := make([][][]sample, len(limits))
+	for i := range samples {
+		samples[i] = make([][]sample, repeat)
+		for r := range samples[i] {
+			samples[i][r] = make([]sample, len(suite))
+		}
+	}
+	total := len(limits) * repeat * len(suite)
+	jobs := make(chan job)
+	var (
+		mu       sync.Mutex
+		done     int
+		info     cute.EngineInfo
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	start := func() (*cute.Session, error) {
+		session, err := cute.StartSession(ctx, enginePath)
+		if err != nil {
+			return nil, err
+		}
+		session.SetOptions(options)
+		if err := session.Handshake(ctx); err != nil {
+			session.Close()
+			return nil, err
+		}
+		return session, nil
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := start()
+			if err != nil {
+				fail(err)
+				for range jobs {
+				}
+				return
+			}
+			defer func() { session.Close() }()
+			mu.Lock()
+			info = session.Info()
+			mu.Unlock()
+			for j := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				limit := limits[j.limit]
+				session.SetSearchTimeout(searchTimeout(limit.SearchLimit, evalTimeout))
+				begin := time.Now()
+				result, err := session.SearchWith(ctx, suite[j.pos], limit.SearchLimit)
+				elapsed := time.Since(begin)
+				if err != nil {
+					if ctx.Err() != nil {
+						continue
+					}
+					fmt.Fprintf(os.Stderr, "\nwarning: %s, position %d: %v\n", limit.label, j.pos+1, err)
+					// The engine may have crashed or still be searching.
+					session.Close()
+					if session, err = start(); err != nil {
+						fail(err)
+						for range jobs {
+						}
+						return
+					}
+				} else {
+					samples[j.limit][j.repeat][j.pos] = newSample(result, elapsed)
+				}
+				mu.Lock()
+				done++
+				fmt.Fprintf(os.Stderr, "\r%s: %d/%d", limit.label, done, total)
+				mu.Unlock()
+			}
+		}()
+	}
+	for l := range limits {
+		for r := 0; r < repeat; r++ {
+			for p := range suite {
+				jobs <- job{l, r, p}
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	fmt.Fprintln(os.Stderr)
+	if firstErr != nil {
+		return nil, info, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, info, errors.New("interrupted")
+	}
+	return samples, info, nil
+}
+
+// searchTimeout is the watchdog of one search: -eval-timeout when set,
+// otherwise 10x the movetime and at least 30s. Node-limited searches are
+// only bounded by an explicit -eval-timeout.
+func searchTimeout(limit cute.SearchLimit, evalTimeout time.Duration) time.Duration {
+	switch {
+	case evalTimeout != 0:
+		return evalTimeout
+	case limit.Nodes > 0:
+		return 0
+	}
+	return max(10*time.Duration(limit.MoveTimeMs)*time.Millisecond, 30*time.Second)
+}
+
+func newSample(result cute.SearchResult, elapsed time.Duration) sample {
+	eval := result.Score.Value
+	if result.Score.Kind == "mate" {
+		eval = evalClip
+		if result.Score.Value < 0 {
+			eval = -evalClip
+		}
+	}
+	s := sample{
+		ok:      true,
+		eval:    min(max(eval, -evalClip), evalClip),
+		depth:   result.Depth,
+		nodes:   result.Nodes,
+		nps:     result.NPS,
+		elapsed: elapsed,
+	}
+	// Engines that do not report nps: derive it from the wall time.
+	if s.nps == 0 && s.nodes > 0 && elapsed > 0 {
+		s.nps = int(float64(s.nodes) / elapsed.Seconds())
+	}
+	return s
+}
+
+// summarize reduces the samples to one row per limit. The last limit is
+// the reference: the longest search the user asked for.
+func summarize(limits []benchLimit, samples [][][]sample, tolerance int) []limitSummary {
+	refIndex := len(limits) - 1
+	positions := len(samples[refIndex][0])
+	reference := make([]float64, positions)
+	haveRef := make([]bool, positions)
+	for p := 0; p < positions; p++ {
+		sum, n := 0, 0
+		for _, run := range samples[refIndex] {
+			if run[p].ok {
+				sum += run[p].eval
+				n++
+			}
+		}
+		if n > 0 {
+			reference[p] = float64(sum) / float64(n)
+			haveRef[p] = true
+		}
+	}
+
+	rows := make([]limitSummary, len(limits))
+	for i, limit := range limits {
+		row := limitSummary{
+			Limit:      limit.label,
+			MoveTimeMs: limit.MoveTimeMs,
+			Nodes:      limit.Nodes,
+			Reference:  i == refIndex,
+		}
+		var elapsed time.Duration
+		var depth, nodes, nps, npsCount, diff, within, compared int
+		var diffSum float64
+		for _, run := range samples[i] {
+			for p, s := range run {
+				if !s.ok {
+					row.Failed++
+					continue
+				}
+				row.Searches++
+				elapsed += s.elapsed
+				depth += s.depth
+				nodes += s.nodes
+				if s.nps > 0 {
+					nps += s.nps
+					npsCount++
+				}
+				if !haveRef[p] {
+					continue
+				}
+				d := math.Abs(float64(s.eval) - reference[p])
+				diffSum += d
+				diff = max(diff, int(math.Round(d)))
+				compared++
+				if d <= float64(tolerance) {
+					within++
+				}
+			}
+		}
+		if row.Searches > 0 {
+			n := float64(row.Searches)
+			row.AvgTimeMs = round1(float64(elapsed.Milliseconds()) / n)
+			row.AvgDepth = round1(float64(depth) / n)
+			row.AvgNodes = math.Round(float64(nodes) / n)
+		}
+		if npsCount > 0 {
+			row.NPSPerThread = math.Round(float64(nps) / float64(npsCount))
+		}
+		if compared > 0 {
+			row.MeanAbsDiff = round1(diffSum / float64(compared))
+			row.MaxAbsDiff = diff
+			row.WithinTolerance = math.Round(float64(within)/float64(compared)*1000) / 1000
+		}
+		row.RepeatSpread = round1(repeatSpread(samples[i]))
+		rows[i] = row
+	}
+	return rows
+}
+
+// repeatSpread is the mean over positions of the max-min eval across
+// repeats.
+func repeatSpread(runs [][]sample) float64 {
+	if len(runs) < 2 {
+		return 0
+	}
+	sum, n := 0, 0
+	for p := range runs[0] {
+		lo, hi, count := 0, 0, 0
+		for _, run := range runs {
+			if !run[p].ok {
+				continue
+			}
+			if count == 0 || run[p].eval < lo {
+				lo = run[p].eval
+			}
+			if count == 0 || run[p].eval > hi {
+				hi = run[p].eval
+			}
+			count++
+		}
+		if count >= 2 {
+			sum += hi - lo
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return float64(sum) / float64(n)
+}
+
+func printReport(r benchReport) {
+	name := r.Engine.Name
+	if name == "" {
+		name = r.Engine.Binary
+	}
+	fmt.Printf("engine: %s, %d positions, %d repeats, %d engines x 1 thread\n", name, r.Positions, r.Repeat, r.ProcessNum)
+	fmt.Printf("%-18s %8s %6s %12s %12s %9s %8s %8s %8s\n",
+		"limit", "time_ms", "depth", "nodes", "nps/thread", "diff", "max", "within", "spread")
+	for _, row := range r.Limits {
+		label := row.Limit
+		if row.Reference {
+			label += " *"
+		}
+		fmt.Printf("%-18s %8.1f %6.1f %12.0f %12.0f %9.1f %8d %7.1f%% %8.1f\n",
+			label, row.AvgTimeMs, row.AvgDepth, row.AvgNodes, row.NPSPerThread,
+			row.MeanAbsDiff, row.MaxAbsDiff, row.WithinTolerance*100, row.RepeatSpread)
+		if row.Failed > 0 {
+			fmt.Printf("%-18s failed searches: %d\n", "", row.Failed)
+		}
+	}
+	fmt.Printf("* reference; diff/max: eval difference from it in cp, within: share of searches within %d cp, spread: run-to-run max-min\n", r.Tolerance)
+	if r.SuggestedMoveTimeMs > 0 {
+		fmt.Printf("suggested movetime: %d ms (shortest with mean diff <= %d cp)\n", r.SuggestedMoveTimeMs, r.Tolerance)
+	} else {
+		fmt.Printf("no movetime is within %d cp of the reference on average\n", r.Tolerance)
+	}
+}
+
+func parseIntList(s string) ([]int, error) {
+	var out []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		if v <= 0 {
+			return nil, fmt.Errorf("%d must be > 0", v)
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func round1(v float64) float64 { return math.Round(v*10) / 10 }
+
+func resolveConfigPath(arg string) (string, string, error) {
+	if arg != "" {
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			return "", "", err
+		}
+		return abs, filepath.Dir(abs), nil
+	}
+	return cute.FindConfigPath()
+}
+
+func resolveEnginePath(cfgEngine, repoRoot string) (string, error) {
+	if cfgEngine == "" {
+		return "", errors.New("engine path is required")
+	}
+	// A tcp:// or ssh:// engine is used as is.
+	if filepath.IsAbs(cfgEngine) || cute.IsRemoteEngine(cfgEngine) {
+		return cfgEngine, nil
+	}
+	return filepath.Join(repoRoot, cfgEngine), nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	cute "cute/pkg/cute"
+)
+
+// defaultSuite is the built-in benchmark: the initial position and
+// opening, middle-game and endgame positions taken from test_kif and
+// pkg/cute/testdata.
+var defaultSuite = []string{
+	"lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1",
+	"lnsg1g1nl/2k1r2b1/pppp1p1pp/4psp2/9/3SP2P1/PPPP1PP1P/1BK3SR1/LN1G1G1NL b - 17",
+	"ln1g3nl/1ks1g1r2/pppp1sbpp/4ppp2/7P1/2P1PPP2/PP1PS3P/1BK1GS1R1/LN1G3NL b - 25",
+	"ln2ggsnk/1r6l/2pp1pbpp/p3psp2/1p7/2PPP4/PPNS1PPPP/2GB2SK1/L3RG1NL b - 31",
+	"l2g3nl/1rs2kg2/4ppsp1/p1P3p1p/2sp1P3/PP3b2P/2SPP1PP1/2G1GR3/LN1K3NL b N2Pb 41",
+	"lns1k3l/1r2lg3/ppppN2gp/4RP1p1/3N1pps1/2P6/PP1PPS2P/6G2/L1SGK3+b w BPn2p 46",
+	"l8/1kg5l/1sngbsnp1/p1p1ppr1p/1p1p3P1/P1P1PPP1P/1P1PSSNR1/1BKGG3L/LN7 b p 61",
+	"3+R1g1nk/+R5ssl/2n2p1pp/p1p1p1p2/4b4/1G1P5/PPN1LPPPP/2+b3SK1/L4G1NL b Sg4p 71",
+	"l4gsnl/r4gk2/2+N1pp1pp/P3s1p2/9/1pPPP+b2P/5LPP1/1P2G1SK1/+r4S1NL w BN2Pg2p 76",
+	"l6nl/3+N2gk1/4ppsp1/p1P3p1p/1R2gP3/P2P4P/2K1PBPP1/9/L1r1G3L b B3SN2Pgn2p 91",
+	"l8/1kg5l/2ng3p1/p1p1p+B2p/3p1s1P1/P1P1PpP1P/1P1PSS+b2/2KSG3L/LN1N3r1 b RPgn2p 101",
+	"l4g2l/2kg5/p2pp2S1/1pps5/P8/5PS1P/4P1NP1/2+nrK4/L5G1L b R2BGSN4Pn4p 111",
+}
+
+// readSuite reads one SFEN per line; blank lines and lines starting with
+// "#" are skipped, and a leading "sfen " or "position sfen " is dropped.
+func readSuite(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var suite []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "position ")
+		line = strings.TrimPrefix(line, "sfen ")
+		if _, err := cute.PositionFromSFEN(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		suite = append(suite, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(suite) == 0 {
+		return nil, fmt.Errorf("%s: no positions", path)
+	}
+	return suite, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	cute "cute/pkg/cute"
+)
+
+// curveOptions controls how an eval curve is normalized before clustering.
+type curveOptions struct {
+	points  int     // samples per curve
+	scale   string  // "win-prob" or "cp"
+	scaling float64 // cp scale of the win-prob conversion
+	maxEval int     // cp clip of the cp scale
+	winner  bool    // flip gote wins so that the winner is positive
+}
+
+// normalizeCurve resamples the evals of a game to opts.points values at
+// evenly spaced fractions of the game (the first to the last evaluated
+// ply, interpolating linearly) on a -1..1 scale from sente's perspective,
+// or the winner's with opts.winner: 2p-1 for a win probability p, or the
+// cp eval clipped to ±maxEval and divided by it. Mates are ±1. ok is false
+// with fewer than two evals.
+func normalizeCurve(evals []cute.MoveEval, resultSide string, opts curveOptions) ([]float64, bool) {
+	if len(evals) < 2 || evals[len(evals)-1].Ply <= evals[0].Ply {
+		return nil, false
+	}
+	sign := 1.0
+	if opts.winner && resultSide == "gote" {
+		sign = -1
+	}
+	plies := make([]float64, len(evals))
+	values := make([]float64, len(evals))
+	for i, e := range evals {
+		plies[i] = float64(e.Ply)
+		values[i] = sign * scaleEval(e, opts)
+	}
+
+	curve := make([]float64, opts.points)
+	first, last := plies[0], plies[len(plies)-1]
+	j := 0
+	for i := range curve {
+		ply := first + (last-first)*float64(i)/float64(opts.points-1)
+		for j < len(plies)-2 && plies[j+1] < ply {
+			j++
+		}
+		t := (ply - plies[j]) / (plies[j+1] - plies[j])
+		curve[i] = values[j] + (values[j+1]-values[j])*math.Min(math.Max(t, 0), 1)
+	}
+	return curve, true
+}
+
+func scaleEval(e cute.MoveEval, opts curveOptions) float64 {
+	if opts.scale == "win-prob" {
+		return 2*e.WinProb(opts.scaling) - 1
+	}
+	v := float64(e.EffectiveCP()) / float64(opts.maxEval)
+	return math.Min(math.Max(v, -1), 1)
+}
+
+// clustering is the outcome of kmeans.
+type clustering struct {
+	centroids [][]float64
+	assign    []int     // cluster of each curve
+	dist      []float64 // squared distance of each curve to its centroid
+	inertia   float64   // sum of dist
+}
+
+// kmeans clusters curves into k groups with Lloyd's algorithm from a
+// k-means++ seeding, running at most iter rounds. A cluster that ends up
+// empty is reseeded with the curve farthest from its centroid.
+func kmeans(curves [][]float64, k, iter int, rng *rand.Rand) clustering {
+	c := clustering{
+		centroids: seedCentroids(curves, k, rng),
+		assign:    make([]int, len(curves)),
+		dist:      make([]float64, len(curves)),
+	}
+	for i := range c.assign {
+		c.assign[i] = -1
+	}
+	for round := 0; round < iter; round++ {
+		if !c.assignCurves(curves) {
+			break
+		}
+		c.updateCentroids(curves)
+	}
+	c.assignCurves(curves)
+	c.inertia = 0
+	for _, d := range c.dist {
+		c.inertia += d
+	}
+	return c
+}
+
+// seedCentroids picks k curves as initial centroids, each with probability
+// proportional to its squared distance to the nearest one already picked.
+func seedCentroids(curves [][]float64, k int, rng *rand.Rand) [][]float64 {
+	centroids := [][]float64{clone(curves[rng.Intn(len(curves))])}
+	nearest := make([]float64, len(curves))
+	for i, curve := range curves {
+		nearest[i] = sqDist(curve, centroids[0])
+	}
+	for len(centroids) < k {
+		total := 0.0
+		for _, d := range nearest {
+			total += d
+		}
+		pick := rng.Intn(len(curves))
+		if total > 0 {
+			r := rng.Float64() * total
+			for i, d := range nearest {
+				if r -= d; r < 0 {
+					pick = i
+					break
+				}
+			}
+		}
+		centroid := clone(curves[pick])
+		centroids = append(centroids, centroid)
+		for i, curve := range curves {
+			nearest[i] = math.Min(nearest[i], sqDist(curve, centroid))
+		}
+	}
+	return centroids
+}
+
+// assignCurves moves every curve to its nearest centroid and reports
+// whether any assignment changed.
+func (c *clustering) assignCurves(curves [][]float64) bool {
+	changed := false
+	for i, curve := range curves {
+		best, bestDist := 0, math.Inf(1)
+		for j, centroid := range c.centroids {
+			if d := sqDist(curve, centroid); d < bestDist {
+				best, bestDist = j, d
+			}
+		}
+		if c.assign[i] != best {
+			c.assign[i] = best
+			changed = true
+		}
+		c.dist[i] = bestDist
+	}
+	return changed
+}
+
+// updateCentroids sets every centroid to the mean of its curves.
+func (c *clustering) updateCentroids(curves [][]float64) {
+	sizes := make([]int, len(c.centroids))
+	for j := range c.centroids {
+		c.centroids[j] = make([]float64, len(curves[0]))
+	}
+	for i, curve := range curves {
+		j := c.assign[i]
+		sizes[j]++
+		for p, v := range curve {
+			c.centroids[j][p] += v
+		}
+	}
+	for j, centroid := range c.centroids {
+		if sizes[j] == 0 {
+			far := 0
+			for i := range curves {
+				if c.dist[i] > c.dist[far] {
+					far = i
+				}
+			}
+			c.centroids[j] = clone(curves[far])
+			c.dist[far] = 0
+			continue
+		}
+		for p := range centroid {
+			centroid[p] /= float64(sizes[j])
+		}
+	}
+}
+
+// bestKMeans runs kmeans restarts times and keeps the clustering with the
+// lowest inertia. Clusters are renumbered by size, largest first.
+func bestKMeans(curves [][]float64, k, iter, restarts int, seed int64) clustering {
+	rng := rand.New(rand.NewSource(seed))
+	var best clustering
+	for r := 0; r < restarts; r++ {
+		c := kmeans(curves, k, iter, rng)
+		if r == 0 || c.inertia < best.inertia {
+			best = c
+		}
+	}
+	sizes := make([]int, k)
+	for _, j := range best.assign {
+		sizes[j]++
+	}
+	order := make([]int, k)
+	for j := range order {
+		order[j] = j
+	}
+	sort.SliceStable(order, func(a, b int) bool { return sizes[order[a]] > sizes[order[b]] })
+	rank := make([]int, k)
+	centroids := make([][]float64, k)
+	for newID, oldID := range order {
+		rank[oldID] = newID
+		centroids[newID] = best.centroids[oldID]
+	}
+	for i, j := range best.assign {
+		best.assign[i] = rank[j]
+	}
+	best.centroids = centroids
+	return best
+}
+
+func sqDist(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
+
+func clone(v []float64) []float64 {
+	return append([]float64(nil), v...)
+}
@@ -0,0 +1,330 @@
+package main
+
+// This command clusters games by the shape of their eval curve, to find
+// archetypal games (a slow squeeze, wild swings, an early collapse) and how
+// common each is by rating.
+//
+// Every curve is resampled to -points values over the course of the game
+// and scaled to -1..1 (see normalizeCurve), so games of any length compare,
+// then grouped with k-means. Clusters are numbered by size, largest first.
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+
+	"github.com/xitongsys/parquet-go-source/local"
+)
+
+// game is a clustered game with its normalized curve.
+type game struct {
+	record     *cute.GameRecord
+	resultSide string
+	curve      []float64
+}
+
+func main() {
+	input := flag.String("input", "output.parquet", "input parquet file")
+	k := flag.Int("k", 6, "number of clusters")
+	points := flag.Int("points", 32, "values each eval curve is resampled to")
+	scale := flag.String("scale", "win-prob", "eval scale: win-prob (2p-1 of the sente win probability) or cp (clipped to -max-eval)")
+	winProbScaling := flag.Float64("win-prob-scaling", cute.DefaultWinProbScaling, "cp scale of the logistic cp to win probability conversion of -scale win-prob")
+	maxEval := flag.Int("max-eval", 2000, "cp clip of -scale cp")
+	perspective := flag.String("perspective", "winner", "curve perspective: winner (the winner's eval is positive; draws are skipped) or sente")
+	minEvals := flag.Int("min-evals", 20, "skip games with fewer evals")
+	iter := flag.Int("iter", 100, "maximum k-means iterations")
+	restarts := flag.Int("restarts", 5, "k-means runs from different seedings; the tightest clustering is kept")
+	seed := flag.Int64("seed", 1, "random seed of the k-means++ seeding")
+	binSize := flag.Int("bin-size", 200, "rating bucket size of the cluster breakdown by rating (mean rating of both players)")
+	recordFilter := flag.String("record-filter", "", `expr filter on GameRecord fields, as in analyze (e.g. 'move_count > 80 && win_reason == "投了"')`)
+	assignmentsPath := flag.String("assignments", "", "write the cluster of every game to this CSV file")
+	centroidsPath := flag.String("centroids", "", "write the centroid curves to this CSV file")
+	parallel := flag.Int64("parallel", 4, "parquet read parallelism")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("evalcluster", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	if *k <= 0 {
+		fatal(fmt.Errorf("k must be > 0"))
+	}
+	if *points < 2 {
+		fatal(fmt.Errorf("points must be >= 2"))
+	}
+	if *scale != "win-prob" && *scale != "cp" {
+		fatal(fmt.Errorf("scale must be win-prob or cp"))
+	}
+	if *winProbScaling <= 0 {
+		fatal(fmt.Errorf("win-prob-scaling must be > 0"))
+	}
+	if *maxEval <= 0 {
+		fatal(fmt.Errorf("max-eval must be > 0"))
+	}
+	if *perspective != "winner" && *perspective != "sente" {
+		fatal(fmt.Errorf("perspective must be winner or sente"))
+	}
+	if *iter <= 0 {
+		fatal(fmt.Errorf("iter must be > 0"))
+	}
+	if *restarts <= 0 {
+		fatal(fmt.Errorf("restarts must be > 0"))
+	}
+	if *binSize <= 0 {
+		fatal(fmt.Errorf("bin-size must be > 0"))
+	}
+
+	records, err := readParquet(*input, *parallel)
+	if err != nil {
+		fatal(err)
+	}
+	if *recordFilter != "" {
+		program, err := cute.CompileRecordFilter(*recordFilter)
+		if err != nil {
+			fatal(err)
+		}
+		total := len(records)
+		if records, err = cute.FilterRecords(records, program); err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "record filter: %d/%d games match\n", len(records), total)
+	}
+
+	opts := curveOptions{points: *points, scale: *scale, scaling: *winProbScaling, maxEval: *maxEval, winner: *perspective == "winner"}
+	var games []game
+	skipped := 0
+	for i := range records {
+		record := &records[i]
+		resultSide := winnerSide(record.Result)
+		if len(record.MoveEvals) < *minEvals || (opts.winner && resultSide == "none") {
+			skipped++
+			continue
+		}
+		curve, ok := normalizeCurve(record.MoveEvals, resultSide, opts)
+		if !ok {
+			skipped++
+			continue
+		}
+		games = append(games, game{record: record, resultSide: resultSide, curve: curve})
+	}
+	if len(games) < *k {
+		fatal(fmt.Errorf("%d games left after filtering (skipped=%d), fewer than k=%d", len(games), skipped, *k))
+	}
+
+	curves := make([][]float64, len(games))
+	for i, g := range games {
+		curves[i] = g.curve
+	}
+	c := bestKMeans(curves, *k, *iter, *restarts, *seed)
+
+	fmt.Printf("games: %d (skipped=%d)\n", len(games), skipped)
+	fmt.Printf("curves: %d points, scale=%s, perspective=%s\n", *points, *scale, *perspective)
+	fmt.Printf("inertia: %.4f\n", c.inertia)
+	printClusters(games, c)
+	printRatingBreakdown(games, c, *binSize)
+
+	if *assignmentsPath != "" {
+		if err := writeAssignments(*assignmentsPath, games, c); err != nil {
+			fatal(err)
+		}
+	}
+	if *centroidsPath != "" {
+		if err := writeCentroids(*centroidsPath, c); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// printClusters prints the size, mean rating and outcome of each cluster
+// with its centroid at the start, quarters and end of the game.
+func printClusters(games []game, c clustering) {
+	k := len(c.centroids)
+	sizes := make([]int, k)
+	ratingSums := make([]float64, k)
+	moveSums := make([]float64, k)
+	senteWins := make([]int, k)
+	for i, g := range games {
+		j := c.assign[i]
+		sizes[j]++
+		ratingSums[j] += gameRating(g.record)
+		moveSums[j] += float64(g.record.MoveCount)
+		if g.resultSide == "sente" {
+			senteWins[j]++
+		}
+	}
+	fmt.Println("clusters:")
+	fmt.Printf("  %-7s %6s %6s %7s %6s %9s %6s  %s\n", "cluster", "games", "share", "rating", "moves", "sente_win", "rms", "centroid (0%, 25%, 50%, 75%, 100%)")
+	for j, centroid := range c.centroids {
+		var sq float64
+		for i, a := range c.assign {
+			if a == j {
+				sq += c.dist[i]
+			}
+		}
+		n := float64(sizes[j])
+		if n == 0 {
+			n = 1
+		}
+		var marks []string
+		for q := 0; q <= 4; q++ {
+			marks = append(marks, fmt.Sprintf("%+.2f", centroid[q*(len(centroid)-1)/4]))
+		}
+		fmt.Printf("  %-7d %6d %6.3f %7.0f %6.1f %9.3f %6.3f  %s\n",
+			j, sizes[j], float64(sizes[j])/float64(len(games)),
+			ratingSums[j]/n, moveSums[j]/n, float64(senteWins[j])/n,
+			math.Sqrt(sq/n/float64(len(centroid))), strings.Join(marks, " "))
+	}
+}
+
+// printRatingBreakdown prints the share of each cluster per rating bucket.
+func printRatingBreakdown(games []game, c clustering, binSize int) {
+	k := len(c.centroids)
+	buckets := make(map[int][]int)
+	var froms []int
+	for i, g := range games {
+		from := int(math.Floor(gameRating(g.record)/float64(binSize))) * binSize
+		if buckets[from] == nil {
+			buckets[from] = make([]int, k)
+			froms = append(froms, from)
+		}
+		buckets[from][c.assign[i]]++
+	}
+	sort.Ints(froms)
+	fmt.Println("by rating:")
+	header := fmt.Sprintf("  %-11s %6s", "rating", "games")
+	for j := 0; j < k; j++ {
+		header += fmt.Sprintf(" %6s", "c"+strconv.Itoa(j))
+	}
+	fmt.Println(header)
+	for _, from := range froms {
+		counts := buckets[from]
+		total := 0
+		for _, n := range counts {
+			total += n
+		}
+		line := fmt.Sprintf("  %-11s %6d", fmt.Sprintf("%d-%d", from, from+binSize), total)
+		for _, n := range counts {
+			line += fmt.Sprintf(" %6.3f", float64(n)/float64(total))
+		}
+		fmt.Println(line)
+	}
+}
+
+// writeAssignments writes one row per game: its players, result, cluster
+// and RMS distance to the cluster centroid.
+func writeAssignments(path string, games []game, c clustering) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"game_id", "sente_name", "sente_rating", "gote_name", "gote_rating", "result", "move_count", "cluster", "distance"}); err != nil {
+		return err
+	}
+	for i, g := range games {
+		r := g.record
+		if err := w.Write([]string{
+			r.GameID,
+			r.SenteName,
+			strconv.Itoa(int(r.SenteRating)),
+			r.GoteName,
+			strconv.Itoa(int(r.GoteRating)),
+			r.Result,
+			strconv.Itoa(int(r.MoveCount)),
+			strconv.Itoa(c.assign[i]),
+			strconv.FormatFloat(math.Sqrt(c.dist[i]/float64(len(g.curve))), 'f', 6, 64),
+		}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeCentroids writes the centroid curves in long form, one row per
+// cluster and point, with progress the fraction of the game (0..1).
+func writeCentroids(path string, c clustering) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"cluster", "point", "progress", "value"}); err != nil {
+		return err
+	}
+	for j, centroid := range c.centroids {
+		for p, v := range centroid {
+			if err := w.Write([]string{
+				strconv.Itoa(j),
+				strconv.Itoa(p),
+				strconv.FormatFloat(float64(p)/float64(len(centroid)-1), 'f', 4, 64),
+				strconv.FormatFloat(v, 'f', 6, 64),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// gameRating is the mean rating of both players.
+func gameRating(r *cute.GameRecord) float64 {
+	return float64(r.SenteRating+r.GoteRating) / 2
+}
+
+func winnerSide(result string) string {
+	switch result {
+	case "sente_win":
+		return "sente"
+	case "gote_win":
+		return "gote"
+	default:
+		return "none"
+	}
+}
+
+func readParquet(path string, parallel int64) ([]cute.GameRecord, error) {
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fileReader.Close()
+	parquetReader, err := cute.NewGameRecordReader(fileReader, parallel)
+	if err != nil {
+		return nil, err
+	}
+	defer parquetReader.ReadStop()
+
+	num := int(parquetReader.GetNumRows())
+	records := make([]cute.GameRecord, 0, num)
+	const batchSize = 1024
+	for offset := 0; offset < num; offset += batchSize {
+		batch := make([]cute.GameRecord, min(batchSize, num-offset))
+		if err := parquetReader.Read(&batch); err != nil {
+			return nil, err
+		}
+		records = append(records, batch...)
+	}
+	return records, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
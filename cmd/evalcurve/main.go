@@ -0,0 +1,336 @@
+package main
+
+// This command aggregates eval curves ply by ply: for every ply, the mean,
+// median and quantiles of the eval over the selected games, from sente's
+// perspective and from the eventual winner's, as CSV for plotting the
+// "average game shape" of a rating bucket or an opening.
+//
+// Games end at different plies, so later plies aggregate fewer (and
+// longer) games; the games column shows how many.
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/openingdb"
+
+	"github.com/expr-lang/expr/vm"
+	"github.com/xitongsys/parquet-go-source/local"
+)
+
+// cellKey identifies one output row.
+type cellKey struct {
+	group       string
+	ply         int
+	perspective string
+}
+
+func main() {
+	input := flag.String("input", "output.parquet", "input parquet file")
+	output := flag.String("output", "", "output CSV file (default: stdout)")
+	groupBy := flag.String("group-by", "", "group rows by rating_bucket (mean rating of both players, -bin-size) or opening (first attack tags of sente and gote); empty = one group of all games")
+	binSize := flag.Int("bin-size", 200, "rating bucket size of -group-by rating_bucket")
+	quantilesArg := flag.String("quantiles", "0.1,0.25,0.75,0.9", "comma-separated quantiles (0-1) reported next to the median")
+	winProb := flag.Bool("win-prob", false, "aggregate win probabilities (0-1) instead of cp evals")
+	winProbScaling := flag.Float64("win-prob-scaling", cute.DefaultWinProbScaling, "cp scale of the logistic cp to win probability conversion of -win-prob")
+	maxEval := flag.Int("max-eval", 2000, "clip cp evals to ±max-eval; mates count as ±max-eval")
+	maxPly := flag.Int("max-ply", 0, "ignore evals after this ply (0=no limit)")
+	minGames := flag.Int("min-games", 10, "drop rows with fewer games")
+	openingDB := flag.String("opening-db", "", "strategy classification parquet file for -filter and -group-by opening (default: the tag columns embedded by graph)")
+	filterExpr := flag.String("filter", "", `expr filter on opening tags, as in analyze (e.g. 'has(sente.attack, "四間飛車") && has(gote.note, "居飛車")')`)
+	recordFilter := flag.String("record-filter", "", `expr filter on GameRecord fields, as in analyze (e.g. 'move_count > 80 && win_reason == "投了"')`)
+	parallel := flag.Int64("parallel", 4, "parquet read parallelism")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("evalcurve", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	if *groupBy != "" && *groupBy != "rating_bucket" && *groupBy != "opening" {
+		fatal(fmt.Errorf("group-by must be rating_bucket or opening"))
+	}
+	if *binSize <= 0 {
+		fatal(fmt.Errorf("bin-size must be > 0"))
+	}
+	if *winProbScaling <= 0 {
+		fatal(fmt.Errorf("win-prob-scaling must be > 0"))
+	}
+	if *maxEval <= 0 {
+		fatal(fmt.Errorf("max-eval must be > 0"))
+	}
+	if *maxPly < 0 {
+		fatal(fmt.Errorf("max-ply must be >= 0"))
+	}
+	if *minGames <= 0 {
+		fatal(fmt.Errorf("min-games must be > 0"))
+	}
+	quantiles, err := parseQuantiles(*quantilesArg)
+	if err != nil {
+		fatal(err)
+	}
+	var program *vm.Program
+	if *filterExpr != "" {
+		if program, err = openingdb.Compile(*filterExpr); err != nil {
+			fatal(fmt.Errorf("invalid filter expression: %w", err))
+		}
+	}
+	var openings map[string]openingdb.Game
+	if *openingDB != "" {
+		if openings, err = openingdb.Load(*openingDB, *parallel); err != nil {
+			fatal(fmt.Errorf("opening-db: %w", err))
+		}
+	}
+
+	records, err := readParquet(*input, *parallel)
+	if err != nil {
+		fatal(err)
+	}
+	if *recordFilter != "" {
+		recordProgram, err := cute.CompileRecordFilter(*recordFilter)
+		if err != nil {
+			fatal(err)
+		}
+		total := len(records)
+		if records, err = cute.FilterRecords(records, recordProgram); err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "record filter: %d/%d games match\n", len(records), total)
+	}
+
+	// tags returns the opening tags of r: from -opening-db if given, else
+	// the tags graph embedded in the record.
+	tags := func(r cute.GameRecord) openingdb.Game {
+		if openings != nil {
+			return openings[openingdb.NormalizeGameID(r.GameID)]
+		}
+		return openingdb.FromGameRecord(r)
+	}
+
+	values := make(map[cellKey][]float64)
+	used, filtered := 0, 0
+	for _, record := range records {
+		if program != nil && !openingdb.Match(program, tags(record)) {
+			filtered++
+			continue
+		}
+		group := ""
+		switch *groupBy {
+		case "rating_bucket":
+			rating := float64(record.SenteRating+record.GoteRating) / 2
+			from := int(math.Floor(rating/float64(*binSize))) * *binSize
+			group = fmt.Sprintf("%d-%d", from, from+*binSize)
+		case "opening":
+			game := tags(record)
+			group = firstTag(game.Sente.Attack) + " vs " + firstTag(game.Gote.Attack)
+		}
+		resultSide := winnerSide(record.Result)
+		used++
+		for _, eval := range record.MoveEvals {
+			ply := int(eval.Ply)
+			if *maxPly > 0 && ply > *maxPly {
+				break
+			}
+			v := evalValue(eval, *winProb, *winProbScaling, *maxEval)
+			sente := cellKey{group: group, ply: ply, perspective: "sente"}
+			values[sente] = append(values[sente], v)
+			if resultSide == "none" {
+				continue
+			}
+			if resultSide == "gote" {
+				v = flip(v, *winProb)
+			}
+			winner := cellKey{group: group, ply: ply, perspective: "winner"}
+			values[winner] = append(values[winner], v)
+		}
+	}
+	if program != nil {
+		fmt.Fprintf(os.Stderr, "opening filter: %d/%d games match\n", used, used+filtered)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := writeCurves(out, values, quantiles, *minGames); err != nil {
+		fatal(err)
+	}
+}
+
+// evalValue is the eval from sente's perspective: the cp eval clipped to
+// ±maxEval (mates are ±maxEval), or with winProb the win probability.
+func evalValue(eval cute.MoveEval, winProb bool, scaling float64, maxEval int) float64 {
+	if winProb {
+		return eval.WinProb(scaling)
+	}
+	return float64(min(max(eval.EffectiveCP(), -maxEval), maxEval))
+}
+
+// flip turns a value from sente's perspective into gote's.
+func flip(v float64, winProb bool) float64 {
+	if winProb {
+		return 1 - v
+	}
+	return -v
+}
+
+func firstTag(tags []string) string {
+	if len(tags) == 0 {
+		return "-"
+	}
+	return tags[0]
+}
+
+// writeCurves writes one CSV row per group, ply and perspective with at
+// least minGames games.
+func writeCurves(out io.Writer, values map[cellKey][]float64, quantiles []float64, minGames int) error {
+	keys := make([]cellKey, 0, len(values))
+	for key, vs := range values {
+		if len(vs) >= minGames {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.group != b.group {
+			return a.group < b.group
+		}
+		if a.perspective != b.perspective {
+			return a.perspective < b.perspective
+		}
+		return a.ply < b.ply
+	})
+
+	w := csv.NewWriter(out)
+	header := []string{"group", "perspective", "ply", "games", "mean", "stddev", "median"}
+	for _, q := range quantiles {
+		header = append(header, quantileColumn(q))
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		vs := values[key]
+		sort.Float64s(vs)
+		mean, stddev := meanStddev(vs)
+		row := []string{
+			key.group,
+			key.perspective,
+			strconv.Itoa(key.ply),
+			strconv.Itoa(len(vs)),
+			formatFloat(mean),
+			formatFloat(stddev),
+			formatFloat(quantile(vs, 0.5)),
+		}
+		for _, q := range quantiles {
+			row = append(row, formatFloat(quantile(vs, q)))
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// quantile returns the q-quantile of sorted values, interpolating linearly
+// between the closest ranks.
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := min(lo+1, len(sorted)-1)
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+func meanStddev(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+// quantileColumn names the column of quantile q, e.g. q10 for 0.1 and
+// q2.5 for 0.025.
+func quantileColumn(q float64) string {
+	return "q" + strconv.FormatFloat(q*100, 'f', -1, 64)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
+
+// parseQuantiles parses comma-separated quantiles strictly between 0 and 1.
+func parseQuantiles(raw string) ([]float64, error) {
+	var quantiles []float64
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		q, err := strconv.ParseFloat(part, 64)
+		if err != nil || q <= 0 || q >= 1 {
+			return nil, fmt.Errorf("invalid quantile %q (want 0 < q < 1)", part)
+		}
+		quantiles = append(quantiles, q)
+	}
+	return quantiles, nil
+}
+
+func winnerSide(result string) string {
+	switch result {
+	case "sente_win":
+		return "sente"
+	case "gote_win":
+		return "gote"
+	default:
+		return "none"
+	}
+}
+
+func readParquet(path string, parallel int64) ([]cute.GameRecord, error) {
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fileReader.Close()
+	parquetReader, err := cute.NewGameRecordReader(fileReader, parallel)
+	if err != nil {
+		return nil, err
+	}
+	defer parquetReader.ReadStop()
+
+	num := int(parquetReader.GetNumRows())
+	records := make([]cute.GameRecord, 0, num)
+	const batchSize = 1024
+	for offset := 0; offset < num; offset += batchSize {
+		batch := make([]cute.GameRecord, min(batchSize, num-offset))
+		if err := parquetReader.Read(&batch); err != nil {
+			return nil, err
+		}
+		records = append(records, batch...)
+	}
+	return records, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	cute "cute/pkg/cute"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/xitongsys/parquet-go-source/local"
+)
+
+// schema is the normalized layout: one row per player, game and eval.
+// Re-importing a game_id replaces the game and its evals.
+const schema = `
+CREATE TABLE IF NOT EXISTS players (
+	id   INTEGER PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+CREATE TABLE IF NOT EXISTS games (
+	game_id            TEXT PRIMARY KEY,
+	sente_id           INTEGER REFERENCES players(id),
+	gote_id            INTEGER REFERENCES players(id),
+	sente_rating       INTEGER NOT NULL,
+	gote_rating        INTEGER NOT NULL,
+	result             TEXT NOT NULL,
+	win_reason         TEXT NOT NULL,
+	move_count         INTEGER NOT NULL,
+	start_time         TEXT,
+	end_time           TEXT,
+	time_control       TEXT,
+	sente_attack_tags  TEXT,
+	sente_defense_tags TEXT,
+	gote_attack_tags   TEXT,
+	gote_defense_tags  TEXT,
+	source             TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS move_evals (
+	game_id     TEXT NOT NULL REFERENCES games(game_id) ON DELETE CASCADE,
+	ply         INTEGER NOT NULL,
+	score_type  TEXT NOT NULL,
+	score_value INTEGER NOT NULL,
+	depth       INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (game_id, ply)
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS sources (
+	file     TEXT PRIMARY KEY,
+	games    INTEGER NOT NULL,
+	metadata TEXT
+);
+CREATE INDEX IF NOT EXISTS games_sente ON games(sente_id);
+CREATE INDEX IF NOT EXISTS games_gote ON games(gote_id);
+CREATE INDEX IF NOT EXISTS games_start_time ON games(start_time);
+CREATE INDEX IF NOT EXISTS games_ratings ON games(sente_rating, gote_rating);
+CREATE INDEX IF NOT EXISTS move_evals_ply ON move_evals(ply);
+CREATE VIEW IF NOT EXISTS player_games AS
+	SELECT g.game_id, p.name AS player, 'sente' AS side, g.sente_rating AS rating,
+		o.name AS opponent, g.gote_rating AS opponent_rating,
+		CASE g.result WHEN 'sente_win' THEN 'win' WHEN 'gote_win' THEN 'loss' WHEN 'draw' THEN 'draw' ELSE g.result END AS outcome,
+		g.move_count, g.start_time, g.time_control, g.sente_attack_tags AS attack_tags, g.sente_defense_tags AS defense_tags
+	FROM games g JOIN players p ON p.id = g.sente_id LEFT JOIN players o ON o.id = g.gote_id
+	UNION ALL
+	SELECT g.game_id, p.name, 'gote', g.gote_rating,
+		o.name, g.sente_rating,
+		CASE g.result WHEN 'gote_win' THEN 'win' WHEN 'sente_win' THEN 'loss' WHEN 'draw' THEN 'draw' ELSE g.result END,
+		g.move_count, g.start_time, g.time_control, g.gote_attack_tags, g.gote_defense_tags
+	FROM games g JOIN players p ON p.id = g.gote_id LEFT JOIN players o ON o.id = g.sente_id;
+`
+
+func main() {
+	input := flag.String("input", "", "GameRecord parquet file (more may be given as arguments)")
+	outputPath := flag.String("output", "out/games.sqlite", "SQLite database (created or updated)")
+	batchSize := flag.Int("batch", 1000, "games per transaction")
+	parallel := flag.Int64("parallel", 4, "parquet read parallelism")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("export-sqlite", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	var inputs []string
+	if *input != "" {
+		inputs = append(inputs, *input)
+	}
+	inputs = append(inputs, flag.Args()...)
+	if len(inputs) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *batchSize <= 0 {
+		fatal(fmt.Errorf("batch must be > 0"))
+	}
+	if dir := filepath.Dir(*outputPath); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			fatal(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", *outputPath+"?_foreign_keys=on&_journal_mode=WAL")
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(schema); err != nil {
+		fatal(fmt.Errorf("create schema: %w", err))
+	}
+	// Databases created before move_evals.depth was added get the column.
+	if ok, err := hasColumn(db, "move_evals", "depth"); err != nil {
+		fatal(err)
+	} else if !ok {
+		if _, err := db.Exec(`ALTER TABLE move_evals ADD COLUMN depth INTEGER NOT NULL DEFAULT 0`); err != nil {
+			fatal(fmt.Errorf("add move_evals.depth: %w", err))
+		}
+	}
+
+	for _, path := range inputs {
+		n, err := importParquet(db, path, *batchSize, *parallel)
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", path, err))
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d games\n", path, n)
+	}
+	if err := db.Close(); err != nil {
+		fatal(err)
+	}
+}
+
+// importer inserts games within one transaction.
+type importer struct {
+	tx                              *sql.Tx
+	player, game, deleteEvals, eval *sql.Stmt
+	players                         map[string]int64
+}
+
+func newImporter(db *sql.DB, players map[string]int64) (*importer, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	imp := &importer{tx: tx, players: players}
+	for _, s := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&imp.player, `INSERT INTO players(name) VALUES (?) ON CONFLICT(name) DO UPDATE SET name = excluded.name RETURNING id`},
+		{&imp.game, `INSERT OR REPLACE INTO games VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&imp.deleteEvals, `DELETE FROM move_evals WHERE game_id = ?`},
+		{&imp.eval, `INSERT INTO move_evals (game_id, ply, score_type, score_value, depth) VALUES (?, ?, ?, ?, ?)`},
+	} {
+		if *s.stmt, err = tx.Prepare(s.query); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return imp, nil
+}
+
+// playerID returns the id of name, or nil for an unnamed player.
+func (imp *importer) playerID(name string) (any, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if id, ok := imp.players[name]; ok {
+		return id, nil
+	}
+	var id int64
+	if err := imp.player.QueryRow(name).Scan(&id); err != nil {
+		return nil, err
+	}
+	imp.players[name] = id
+	return id, nil
+}
+
+func (imp *importer) add(record cute.GameRecord, source string) error {
+	senteID, err := imp.playerID(record.SenteName)
+	if err != nil {
+		return err
+	}
+	goteID, err := imp.playerID(record.GoteName)
+	if err != nil {
+		return err
+	}
+	if _, err := imp.game.Exec(record.GameID, senteID, goteID,
+		record.SenteRating, record.GoteRating, record.Result, record.WinReason, record.MoveCount,
+		nullString(record.StartTime), nullString(record.EndTime), nullString(record.TimeControl),
+		nullString(record.SenteAttackTags), nullString(record.SenteDefenseTags),
+		nullString(record.GoteAttackTags), nullString(record.GoteDefenseTags),
+		source); err != nil {
+		return err
+	}
+	// INSERT OR REPLACE deletes the old game row without cascading, so
+	// the evals of a re-imported game are replaced here.
+	if _, err := imp.deleteEvals.Exec(record.GameID); err != nil {
+		return err
+	}
+	for _, eval := range record.MoveEvals {
+		if _, err := imp.eval.Exec(record.GameID, eval.Ply, eval.ScoreType, eval.ScoreValue, eval.Depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (imp *importer) commit() error {
+	return imp.tx.Commit()
+}
+
+// importParquet streams the games of path into db in transactions of
+// batchSize games and records the file in sources.
+func importParquet(db *sql.DB, path string, batchSize int, parallel int64) (int, error) {
+	meta, err := cute.ReadParquetMeta(path)
+	if err != nil {
+		return 0, err
+	}
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fileReader.Close()
+	parquetReader, err := cute.NewGameRecordReader(fileReader, parallel)
+	if err != nil {
+		return 0, err
+	}
+	defer parquetReader.ReadStop()
+
+	source := filepath.Base(path)
+	players := make(map[string]int64)
+	num := int(parquetReader.GetNumRows())
+	for offset := 0; offset < num; offset += batchSize {
+		n := min(batchSize, num-offset)
+		batch := make([]cute.GameRecord, n)
+		if err := parquetReader.Read(&batch); err != nil {
+			return offset, err
+		}
+		imp, err := newImporter(db, players)
+		if err != nil {
+			return offset, err
+		}
+		for _, record := range batch {
+			if err := imp.add(record, source); err != nil {
+				imp.tx.Rollback()
+				return offset, fmt.Errorf("game %s: %w", record.GameID, err)
+			}
+		}
+		if err := imp.commit(); err != nil {
+			return offset, err
+		}
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return num, err
+	}
+	if _, err := db.Exec(`INSERT OR REPLACE INTO sources VALUES (?, ?, ?)`, source, num, string(data)); err != nil {
+		return num, err
+	}
+	return num, nil
+}
+
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// hasColumn reports whether table has column.
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"cute/pkg/cute/regress"
+)
+
+// family is the response distribution and link of the model.
+type family interface {
+	// mean maps the linear predictor to the expected response.
+	mean(eta float64) float64
+	// variance is the variance of a response with mean mu, up to the
+	// dispersion.
+	variance(mu float64) float64
+	// deviance is the unit deviance of response y at mean mu.
+	deviance(y, mu float64) float64
+}
+
+// linear is ordinary least squares (normal response, identity link).
+type linear struct{}
+
+func (linear) mean(eta float64) float64       { return eta }
+func (linear) variance(float64) float64       { return 1 }
+func (linear) deviance(y, mu float64) float64 { return (y - mu) * (y - mu) }
+
+// poisson is a count response with a log link, so coefficients are log rate
+// ratios.
+type poisson struct{}
+
+func (poisson) mean(eta float64) float64    { return math.Exp(eta) }
+func (poisson) variance(mu float64) float64 { return mu }
+func (poisson) deviance(y, mu float64) float64 {
+	d := -(y - mu)
+	if y > 0 {
+		d += y * math.Log(y/mu)
+	}
+	return 2 * d
+}
+
+// fitOptions controls the Newton (IRLS) fit.
+type fitOptions struct {
+	iter int     // maximum number of Newton steps
+	l2   float64 // L2 penalty (lambda) per game; the intercept is not penalized
+	tol  float64 // stop when no coefficient moves by more than tol
+}
+
+// fitResult is the outcome of fitGLM.
+type fitResult struct {
+	weights    []float64
+	deviance   float64 // residual deviance (without penalty)
+	iterations int
+	converged  bool
+	// cov is the inverse of the penalized information matrix at weights,
+	// before scaling by the dispersion; nil when it is singular.
+	cov [][]float64
+}
+
+// fitGLM fits family by Newton's method, which for a canonical link is
+// iteratively reweighted least squares. It minimizes
+//
+//	D(w)/2 + (N·λ/2) * sum_{j>0} w_j^2
+//
+// where D is the deviance, the same penalty scale as logreg's average loss
+// plus (λ/2)|w|². The linear family converges in one step.
+func fitGLM(samples []sample, fam family, opts fitOptions) (fitResult, error) {
+	k := len(samples[0].x)
+	weights := make([]float64, k)
+	// Start the intercept at the link of the mean response, so the first
+	// Poisson step starts from a sensible rate.
+	var sumY float64
+	for _, s := range samples {
+		sumY += s.y
+	}
+	if _, ok := fam.(poisson); ok {
+		if sumY <= 0 {
+			return fitResult{}, fmt.Errorf("poisson: every target count is 0")
+		}
+		weights[0] = math.Log(sumY / float64(len(samples)))
+	}
+	penaltyScale := float64(len(samples)) * opts.l2
+	objective := func(w []float64) float64 {
+		obj := deviance(samples, fam, w) / 2
+		for j := 1; j < k; j++ {
+			obj += penaltyScale * w[j] * w[j] / 2
+		}
+		return obj
+	}
+
+	res := fitResult{weights: weights}
+	current := objective(weights)
+	for it := 0; it < opts.iter; it++ {
+		grad, info := scoreAndInformation(samples, fam, weights, penaltyScale)
+		inv, ok := regress.InvertMatrix(info)
+		if !ok {
+			return fitResult{}, fmt.Errorf("information matrix is singular (constant or collinear features?)")
+		}
+		step := make([]float64, k)
+		for i := range step {
+			step[i] = dot(inv[i], grad)
+		}
+		// Halve the step while it makes the fit worse; a full Newton step
+		// can overshoot when a Poisson start is far from the optimum.
+		scale := 1.0
+		next := make([]float64, k)
+		var nextObj float64
+		for halvings := 0; ; halvings++ {
+			for i := range next {
+				next[i] = weights[i] + scale*step[i]
+			}
+			nextObj = objective(next)
+			if nextObj <= current+1e-12*math.Abs(current) || halvings == 30 {
+				break
+			}
+			scale /= 2
+		}
+		moved := 0.0
+		for i := range next {
+			moved = math.Max(moved, math.Abs(next[i]-weights[i]))
+		}
+		copy(weights, next)
+		current = nextObj
+		res.iterations = it + 1
+		if moved < opts.tol {
+			res.converged = true
+			break
+		}
+	}
+	_, info := scoreAndInformation(samples, fam, weights, penaltyScale)
+	res.cov, _ = regress.InvertMatrix(info)
+	res.deviance = deviance(samples, fam, weights)
+	return res, nil
+}
+
+// scoreAndInformation returns the gradient of the penalized log-likelihood
+// and the penalized Fisher information at weights. For the canonical links
+// used here (identity, log), the gradient is X^T (y - mu) and the
+// information is X^T diag(var(mu)) X.
+func scoreAndInformation(samples []sample, fam family, weights []float64, penaltyScale float64) ([]float64, [][]float64) {
+	k := len(weights)
+	grad := make([]float64, k)
+	info := make([][]float64, k)
+	for i := range info {
+		info[i] = make([]float64, k)
+	}
+	for _, s := range samples {
+		mu := fam.mean(dot(weights, s.x))
+		v := fam.variance(mu)
+		for i := 0; i < k; i++ {
+			grad[i] += (s.y - mu) * s.x[i]
+			for j := i; j < k; j++ {
+				info[i][j] += v * s.x[i] * s.x[j]
+			}
+		}
+	}
+	for i := 0; i < k; i++ {
+		for j := 0; j < i; j++ {
+			info[i][j] = info[j][i]
+		}
+	}
+	for j := 1; j < k; j++ {
+		grad[j] -= penaltyScale * weights[j]
+		info[j][j] += penaltyScale
+	}
+	return grad, info
+}
+
+// deviance sums the unit deviances of samples at weights.
+func deviance(samples []sample, fam family, weights []float64) float64 {
+	var sum float64
+	for _, s := range samples {
+		sum += fam.deviance(s.y, fam.mean(dot(weights, s.x)))
+	}
+	return sum
+}
+
+// nullDeviance is the deviance of the intercept-only model, whose fitted
+// mean is the mean response.
+func nullDeviance(samples []sample, fam family) float64 {
+	var sumY float64
+	for _, s := range samples {
+		sumY += s.y
+	}
+	mean := sumY / float64(len(samples))
+	var sum float64
+	for _, s := range samples {
+		sum += fam.deviance(s.y, mean)
+	}
+	return sum
+}
+
+// dispersion estimates the dispersion as the Pearson statistic over the
+// residual degrees of freedom: the residual variance for the linear family,
+// and for Poisson a check of the variance = mean assumption (> 1 means
+// overdispersion).
+func dispersion(samples []sample, fam family, weights []float64) float64 {
+	var chi2 float64
+	for _, s := range samples {
+		mu := fam.mean(dot(weights, s.x))
+		chi2 += (s.y - mu) * (s.y - mu) / fam.variance(mu)
+	}
+	df := len(samples) - len(weights)
+	if df <= 0 {
+		return math.NaN()
+	}
+	return chi2 / float64(df)
+}
+
+func dot(a []float64, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
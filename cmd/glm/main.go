@@ -0,0 +1,339 @@
+package main
+
+// This command fits a generalized linear model of a per-game count or
+// quantity, e.g. how the number of blunders or the game length depends on
+// the players' ratings and the eval trajectory. It complements logreg, which
+// models the winner, and takes the same -features list.
+//
+// Families:
+//   linear  : ordinary least squares; a coefficient is the change of the
+//             target per unit of the feature
+//   poisson : log link for counts; exp(coefficient) is the rate ratio, e.g.
+//             1.10 means 10% more blunders per unit of the feature
+//
+// Targets (one sample per game):
+//   blunders       : moves of both players that lose at least -blunder cp
+//   sente_blunders : blunders of sente
+//   gote_blunders  : blunders of gote
+//   move_count     : length of the game in plies
+//
+// Blunders are counted from consecutive evals as in report: cp evals are
+// clipped to ±-max-eval and mates count as ±-max-eval. Sente is assumed to
+// move on odd plies.
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/regress"
+)
+
+type sample struct {
+	x []float64
+	y float64
+}
+
+func main() {
+	input := flag.String("input", "output.parquet", "input parquet file")
+	familyArg := flag.String("family", "poisson", "model family: linear (least squares) or poisson (log link, for counts)")
+	target := flag.String("target", "blunders", "per-game target: blunders, sente_blunders, gote_blunders or move_count")
+	blunder := flag.Int("blunder", 300, "a move that loses at least this many cp for its player is a blunder")
+	maxEval := flag.Int("max-eval", 2000, "clip evals to ±max-eval before counting blunders; mates count as ±max-eval")
+	threshold := flag.Int("threshold", 300, "eval threshold of the first_crossed, rating_x_first and crossing_ply features (a win percentage, e.g. 80, with -win-prob)")
+	winProb := flag.Bool("win-prob", false, "work in win-probability space, as in logreg: -threshold is the win probability in percent of the side ahead and eval features are sente win probabilities instead of 100cp units")
+	winProbScaling := flag.Float64("win-prob-scaling", cute.DefaultWinProbScaling, "cp scale of the logistic cp to win probability conversion of -win-prob")
+	mateCrossing := flag.Bool("mate-crossing", true, "count a mate score as a crossing for the mating side whatever the threshold (false skips mate scores)")
+	crossingStability := flag.Int("crossing-stability", 0, "only count a crossing that holds for this many following evals")
+	featuresArg := flag.String("features", "rating_diff,rating_centered", "comma-separated feature list, as in logreg (e.g. rating_diff,rating_centered,first_crossed,eval_volatility)")
+	iter := flag.Int("iter", 50, "maximum Newton (IRLS) iterations")
+	l2 := flag.Float64("l2", 0, "L2 regularization strength (lambda, per game as in logreg, 0=disabled)")
+	tol := flag.Float64("tol", 1e-8, "stop when no coefficient changes by more than this value")
+	ratingScale := flag.Float64("rating-scale", 100, "scale factor for rating diff")
+	maxAbsDiff := flag.Int("max-abs-diff", 0, "max absolute rating diff (0=disabled)")
+	recordFilter := flag.String("record-filter", "", `expr filter on GameRecord fields, as in analyze (e.g. 'move_count > 80 && win_reason == "投了"')`)
+	parallel := flag.Int64("parallel", 4, "parquet read parallelism")
+	gameFilter := cute.AddGameFilterFlags(flag.CommandLine)
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("glm", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	var fam family
+	switch *familyArg {
+	case "linear":
+		fam = linear{}
+	case "poisson":
+		fam = poisson{}
+	default:
+		fatal(fmt.Errorf("family must be linear or poisson"))
+	}
+	switch *target {
+	case "blunders", "sente_blunders", "gote_blunders", "move_count":
+	default:
+		fatal(fmt.Errorf("target must be blunders, sente_blunders, gote_blunders or move_count"))
+	}
+	if *blunder <= 0 {
+		fatal(fmt.Errorf("blunder must be > 0"))
+	}
+	if *maxEval <= 0 {
+		fatal(fmt.Errorf("max-eval must be > 0"))
+	}
+	if *iter <= 0 {
+		fatal(fmt.Errorf("iter must be > 0"))
+	}
+	if *l2 < 0 {
+		fatal(fmt.Errorf("l2 must be >= 0"))
+	}
+	if *tol < 0 {
+		fatal(fmt.Errorf("tol must be >= 0"))
+	}
+	if *ratingScale <= 0 {
+		fatal(fmt.Errorf("rating-scale must be > 0"))
+	}
+	if *threshold <= 0 {
+		fatal(fmt.Errorf("threshold must be > 0"))
+	}
+	crossingThreshold, scaling := *threshold, 0.0
+	if *winProb {
+		if *threshold <= 50 || *threshold >= 100 {
+			fatal(fmt.Errorf("with -win-prob, threshold must be between 50 and 100 (exclusive)"))
+		}
+		if *winProbScaling <= 0 {
+			fatal(fmt.Errorf("win-prob-scaling must be > 0"))
+		}
+		crossingThreshold, scaling = cute.WinProbToCP(float64(*threshold)/100, *winProbScaling), *winProbScaling
+	}
+	features, err := regress.ParseFeatures(*featuresArg)
+	if err != nil {
+		fatal(err)
+	}
+	if *target == "move_count" {
+		for _, f := range features {
+			if strings.Contains(f.Name, "move_count") {
+				fatal(fmt.Errorf("feature %s uses the target move_count", f.Name))
+			}
+		}
+	}
+
+	if err := gameFilter.Validate(); err != nil {
+		fatal(err)
+	}
+	if err := cute.CheckParquetPerspective(*input); err != nil {
+		fatal(err)
+	}
+	records, err := cute.ReadGameRecords(*input, *parallel)
+	if err != nil {
+		fatal(err)
+	}
+	if *recordFilter != "" {
+		program, err := cute.CompileRecordFilter(*recordFilter)
+		if err != nil {
+			fatal(err)
+		}
+		total := len(records)
+		if records, err = cute.FilterRecords(records, program); err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "record filter: %d/%d games match\n", len(records), total)
+	}
+	if gameFilter.Active() {
+		total := len(records)
+		records = gameFilter.Filter(records)
+		fmt.Fprintf(os.Stderr, "move count/phase filter: %d/%d games match\n", len(records), total)
+	}
+
+	samples, skipped, meanRating := buildSamples(records, features, cute.CrossingOptions{
+		Threshold:         crossingThreshold,
+		MateCountsAsCross: *mateCrossing,
+		RequireStability:  *crossingStability,
+	}, func(r cute.GameRecord) (float64, bool) {
+		return targetValue(r, *target, *blunder, *maxEval)
+	}, *ratingScale, *maxAbsDiff, scaling)
+	if len(samples) <= len(features)+1 {
+		fatal(fmt.Errorf("too few samples after filtering (samples=%d skipped=%d)", len(samples), skipped))
+	}
+
+	fit, err := fitGLM(samples, fam, fitOptions{iter: *iter, l2: *l2, tol: *tol})
+	if err != nil {
+		fatal(err)
+	}
+	phi := dispersion(samples, fam, fit.weights)
+	var meanY float64
+	for _, s := range samples {
+		meanY += s.y
+	}
+	meanY /= float64(len(samples))
+
+	fmt.Println("data:")
+	fmt.Printf("  input: %s\n", *input)
+	fmt.Printf("  target: %s (mean %.4f)\n", *target, meanY)
+	if *target != "move_count" {
+		fmt.Printf("  blunder: %d (max-eval %d)\n", *blunder, *maxEval)
+	}
+	fmt.Printf("  rating-scale: %.0f\n", *ratingScale)
+	fmt.Printf("  games: %d (skipped=%d)\n", len(samples), skipped)
+	fmt.Printf("  max-abs-diff: %d\n", *maxAbsDiff)
+	fmt.Printf("  mean-sente-rating: %.0f\n", meanRating)
+	fmt.Println("model:")
+	fmt.Printf("  family: %s\n", *familyArg)
+	fmt.Printf("  features: %s\n", strings.Join(regress.Labels(features), ", "))
+	fmt.Printf("  l2: %g\n", *l2)
+	fmt.Printf("  iterations: %d/%d (converged=%t, tol=%g)\n", fit.iterations, *iter, fit.converged, *tol)
+	nullDev := nullDeviance(samples, fam)
+	switch fam.(type) {
+	case linear:
+		fmt.Printf("  r2: %.4f\n", 1-fit.deviance/nullDev)
+		fmt.Printf("  rmse: %.4f\n", math.Sqrt(fit.deviance/float64(len(samples))))
+	case poisson:
+		fmt.Printf("  deviance: %.2f (null %.2f, pseudo-r2 %.4f)\n", fit.deviance, nullDev, 1-fit.deviance/nullDev)
+		fmt.Printf("  dispersion: %.3f (Pearson chi2/df; > 1 means overdispersion)\n", phi)
+	}
+
+	labels := regress.Labels(features)
+	printCollinearity(labels, samples)
+	fmt.Println("coefficients:")
+	for i, w := range fit.weights {
+		fmt.Printf("  %s = %.6f\n", labels[i], w)
+	}
+	if fit.cov == nil {
+		fmt.Fprintln(os.Stderr, "warning: information matrix is singular; standard errors unavailable (constant or collinear features?)")
+	} else {
+		// The linear family estimates the residual variance; Poisson fixes
+		// the dispersion at 1.
+		scale := 1.0
+		if _, ok := fam.(linear); ok {
+			scale = phi
+		}
+		fmt.Println("coefficient inference (Wald):")
+		fmt.Println("  feature,coef,std_err,z,p_value")
+		for j, w := range fit.weights {
+			se := math.Sqrt(scale * fit.cov[j][j])
+			z := w / se
+			fmt.Printf("  %s,%.6f,%.6f,%.3f,%.4g\n", labels[j], w, se, z, math.Erfc(math.Abs(z)/math.Sqrt2))
+		}
+		if _, ok := fam.(poisson); ok && phi > 1.5 {
+			fmt.Fprintf(os.Stderr, "warning: dispersion %.2f > 1.5; Poisson standard errors are too small (multiply them by %.2f)\n", phi, math.Sqrt(phi))
+		}
+	}
+	if _, ok := fam.(poisson); ok {
+		fmt.Println("rate ratios (1.0 = no change):")
+		for i := 1; i < len(fit.weights); i++ {
+			fmt.Printf("  %s = %.4f\n", labels[i], math.Exp(fit.weights[i]))
+		}
+	}
+}
+
+// buildSamples filters records and builds one sample per game whose target
+// and features are available. Crossing-based features are unavailable for a
+// game where neither side crossed the threshold.
+func buildSamples(records []cute.GameRecord, features []regress.Feature, crossing cute.CrossingOptions, target func(cute.GameRecord) (float64, bool), ratingScale float64, maxAbsDiff int, winProbScaling float64) ([]sample, int, float64) {
+	// First pass: filter games and compute mean sente rating for centering.
+	var games []int
+	var sumRating float64
+	for i, record := range records {
+		diff := int(record.SenteRating - record.GoteRating)
+		if maxAbsDiff > 0 && (diff > maxAbsDiff || -diff > maxAbsDiff) {
+			continue
+		}
+		games = append(games, i)
+		sumRating += float64(record.SenteRating)
+	}
+	meanRating := 0.0
+	if len(games) > 0 {
+		meanRating = sumRating / float64(len(games))
+	}
+	samples := make([]sample, 0, len(games))
+	for _, i := range games {
+		record := records[i]
+		y, ok := target(record)
+		if !ok {
+			continue
+		}
+		cross := cute.FirstCrossing(record.MoveEvals, crossing)
+		x, ok := regress.Vector(features, regress.NewGame(record, cross, ratingScale, meanRating, winProbScaling))
+		if !ok {
+			continue
+		}
+		samples = append(samples, sample{x: x, y: y})
+	}
+	return samples, len(records) - len(samples), meanRating
+}
+
+// targetValue returns the target of record; ok=false for a blunder target
+// when the game has fewer than two evals.
+func targetValue(record cute.GameRecord, target string, blunder, maxEval int) (float64, bool) {
+	if target == "move_count" {
+		return float64(record.MoveCount), record.MoveCount > 0
+	}
+	sente, gote, ok := countBlunders(record.MoveEvals, blunder, maxEval)
+	if !ok {
+		return 0, false
+	}
+	switch target {
+	case "sente_blunders":
+		return float64(sente), true
+	case "gote_blunders":
+		return float64(gote), true
+	}
+	return float64(sente + gote), true
+}
+
+// countBlunders counts the moves of each side that lower the eval for their
+// player by at least blunder cp between consecutive plies.
+func countBlunders(evals []cute.MoveEval, blunder, maxEval int) (int, int, bool) {
+	values := make(map[int]int, len(evals))
+	for _, eval := range evals {
+		values[int(eval.Ply)] = min(max(eval.EffectiveCP(), -maxEval), maxEval)
+	}
+	if len(values) < 2 {
+		return 0, 0, false
+	}
+	sente, gote := 0, 0
+	for ply, v := range values {
+		prev, ok := values[ply-1]
+		if !ok {
+			continue
+		}
+		if ply%2 == 1 {
+			if prev-v >= blunder {
+				sente++
+			}
+		} else if v-prev >= blunder {
+			gote++
+		}
+	}
+	return sente, gote, true
+}
+
+// printCollinearity prints the condition number and variance inflation
+// factors of the feature columns, as in logreg.
+func printCollinearity(labels []string, samples []sample) {
+	rows := make([][]float64, len(samples))
+	for i, s := range samples {
+		rows[i] = s.x
+	}
+	c := regress.Diagnose(rows)
+	fmt.Println("collinearity:")
+	fmt.Printf("  condition-number: %.2f\n", c.ConditionNumber)
+	fmt.Println("  feature,vif")
+	var unstable []string
+	for j, vif := range c.VIF {
+		fmt.Printf("  %s,%.3f\n", labels[j+1], vif)
+		if vif > 10 {
+			unstable = append(unstable, labels[j+1])
+		}
+	}
+	if c.ConditionNumber > 30 || len(unstable) > 0 {
+		fmt.Fprintf(os.Stderr, "warning: collinear features (condition number %.1f, VIF > 10: %s); their coefficients and standard errors are unstable\n", c.ConditionNumber, strings.Join(unstable, ", "))
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
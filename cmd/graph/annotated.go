@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	cute "cute/pkg/cute"
+)
+
+// writeAnnotated writes the KIF at path with the evals of record as
+// comments under dir, at the place path has under inputDir (files from
+// elsewhere, e.g. listed in -retry-failures, go to the top of dir).
+func writeAnnotated(dir, inputDir, encoding, path string, record cute.GameRecord) error {
+	lines, err := cute.ReadKIFLines(path)
+	if err != nil {
+		return err
+	}
+	lines, err = cute.AnnotateKIF(lines, record)
+	if err != nil {
+		return err
+	}
+	data, err := cute.EncodeKIF(strings.Join(lines, "\n"), encoding)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(inputDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = filepath.Base(path)
+	}
+	out := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return err
+	}
+	tmp := out + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, out)
+}
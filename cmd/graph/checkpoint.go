@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cute "cute/pkg/cute"
+)
+
+// Checkpointing writes results to part files next to the output
+// (output.part-0001.parquet, ...), each holding up to -checkpoint games. A
+// part is written under a ".tmp" name and renamed once complete, so a
+// crash loses at most the games of the part being written. The parts are
+// merged into the output when the run finishes.
+
+// partPath returns the path of checkpoint part n of output.
+func partPath(output string, n int) string {
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	return fmt.Sprintf("%s.part-%04d.parquet", base, n)
+}
+
+// listParts returns the complete checkpoint parts of output, in order.
+func listParts(output string) ([]string, error) {
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	parts, err := filepath.Glob(base + ".part-[0-9][0-9][0-9][0-9].parquet")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(parts)
+	return parts, nil
+}
+
+// removeParts deletes checkpoint parts and leftover incomplete parts.
+func removeParts(output string) error {
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	stale, err := filepath.Glob(base + ".part-[0-9][0-9][0-9][0-9].parquet*")
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCheckpointed writes records to parts of up to every games,
+// numbered from first, with the metadata returned by meta (packing the
+// evals with compact). It returns the parts it completed.
+func writeCheckpointed(output string, records <-chan cute.GameRecord, parallel int64, every, first int, meta func() cute.ParquetMeta, compact bool) ([]string, error) {
+	var parts []string
+	for record := range records {
+		part := partPath(output, first+len(parts))
+		tmp := part + ".tmp"
+		chunk := make(chan cute.GameRecord)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- cute.WriteParquetWith(tmp, chunk, cute.ParquetWriteOptions{Parallel: parallel, Meta: meta, CompactEvals: compact})
+		}()
+		send := func(r cute.GameRecord) error {
+			select {
+			case chunk <- r:
+				return nil
+			case err := <-errc:
+				if err == nil {
+					err = fmt.Errorf("writer for %s stopped early", tmp)
+				}
+				return err
+			}
+		}
+		if err := send(record); err != nil {
+			return parts, err
+		}
+		for n := 1; n < every; n++ {
+			next, ok := <-records
+			if !ok {
+				break
+			}
+			if err := send(next); err != nil {
+				return parts, err
+			}
+		}
+		close(chunk)
+		if err := <-errc; err != nil {
+			return parts, err
+		}
+		if err := os.Rename(tmp, part); err != nil {
+			return parts, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
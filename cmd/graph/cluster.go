@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	cute "cute/pkg/cute"
+)
+
+// A -coordinator run hands its input games to -worker processes over HTTP
+// and writes the records they send back, so one run spread over several
+// machines produces a single output without shard bookkeeping:
+//
+//	GET  /cluster/run     the eval policy every worker uses
+//	POST /cluster/job     the next game as {path, lease, kif}; 204 when
+//	                      none is ready yet, 410 when the run is over
+//	POST /cluster/result  the record or failure of a leased game
+//
+// A game whose worker does not report back within -lease-timeout is
+// handed to another worker; the first report of a game wins.
+
+// clusterPollWait is how long a worker's request for a game waits for one
+// before the worker is told to ask again.
+const clusterPollWait = 20 * time.Second
+
+// clusterRetryWindow is how long a worker keeps retrying a coordinator it
+// cannot reach before giving up.
+const clusterRetryWindow = time.Minute
+
+var errRunFinished = errors.New("coordinator run finished")
+
+type clusterRun struct {
+	Policy cute.EvalPolicy `json:"policy"`
+	// DrawScore is the coordinator's -draw-score, which the workers'
+	// engines use so that the metadata of the output holds.
+	DrawScore *int `json:"draw_score,omitempty"`
+}
+
+type clusterJob struct {
+	Path  string `json:"path"`
+	Lease int64  `json:"lease"`
+	KIF   []byte `json:"kif"`
+	// Partial is the stored partial record the game is completed from.
+	Partial *cute.GameRecord `json:"partial,omitempty"`
+}
+
+type clusterResult struct {
+	Path   string           `json:"path"`
+	Lease  int64            `json:"lease"`
+	Record *cute.GameRecord `json:"record,omitempty"`
+	Engine cute.EngineInfo  `json:"engine"`
+	// Stage and Error describe a failed game. Record is then nil, or the
+	// partial record of the evaluations made before the failure.
+	Stage   string  `json:"stage,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	Seconds float64 `json:"seconds"`
+	// Abandoned gives the game back unevaluated, e.g. when the worker is
+	// interrupted.
+	Abandoned bool `json:"abandoned,omitempty"`
+}
+
+// fileHooks settle the games of a run, evaluated locally or by workers.
+type fileHooks struct {
+	fail   func(path, stage string, err error, elapsed time.Duration)
+	finish func(path string, record cute.GameRecord, engine cute.EngineInfo, elapsed time.Duration)
+	// keepPartial writes the partial record of a failed game when the
+	// run keeps them (-keep-partial).
+	keepPartial func(path string, record cute.GameRecord, engine cute.EngineInfo)
+	// resumeFrom returns the stored partial record a game is completed
+	// from, or nil.
+	resumeFrom func(path string) *cute.GameRecord
+}
+
+// coordinator serves the paths sent on its jobs channel to workers and
+// settles each with the hooks of the run.
+type coordinator struct {
+	run          clusterRun
+	leaseTimeout time.Duration
+	stop         <-chan struct{}
+	next         chan string
+	hooks        fileHooks
+
+	mu        sync.Mutex
+	changed   chan struct{}    // closed and replaced when the state below changes
+	leased    map[string]int64 // path -> current lease
+	retry     []string         // games whose lease expired or was given back
+	handing   int              // games taken off jobs but not leased yet
+	lastLease int64
+	drained   bool // the jobs channel is closed
+	finished  bool
+	inflight  sync.WaitGroup // settle callbacks running
+
+	lastRequest atomic.Int64 // unix nanoseconds of the last worker request
+}
+
+func newCoordinator(jobs <-chan string, stop <-chan struct{}, run clusterRun, leaseTimeout time.Duration, hooks fileHooks) *coordinator {
+	run.Policy = run.Policy.Limits()
+	c := &coordinator{
+		run:          run,
+		leaseTimeout: leaseTimeout,
+		stop:         stop,
+		next:         make(chan string),
+		hooks:        hooks,
+		changed:      make(chan struct{}),
+		leased:       make(map[string]int64),
+	}
+	go func() {
+		for path := range jobs {
+			// Count the game until a worker leases it, so that wait does
+			// not see an empty run while it is between the two.
+			c.mu.Lock()
+			c.handing++
+			c.mu.Unlock()
+			select {
+			case c.next <- path:
+			case <-stop:
+				c.mu.Lock()
+				c.handing--
+				c.mu.Unlock()
+			}
+		}
+		c.mu.Lock()
+		c.drained = true
+		c.notify()
+		c.mu.Unlock()
+	}()
+	return c
+}
+
+// notify wakes the goroutines waiting for a change. c.mu must be held.
+func (c *coordinator) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *coordinator) serve(ln net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /cluster/run", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, c.run)
+	})
+	mux.HandleFunc("POST /cluster/job", c.handleJob)
+	mux.HandleFunc("POST /cluster/result", c.handleResult)
+	_ = http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.lastRequest.Store(time.Now().UnixNano())
+		mux.ServeHTTP(w, r)
+	}))
+}
+
+// linger keeps serving after the run until the workers stopped asking, so
+// the ones that just reported their last game are told the run is over
+// instead of finding the coordinator gone.
+func (c *coordinator) linger() {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && time.Since(time.Unix(0, c.lastRequest.Load())) < time.Second {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// wait returns once every game was settled or the run was interrupted.
+// Results that arrive later are turned away.
+func (c *coordinator) wait() {
+	for {
+		c.mu.Lock()
+		if isStopRequested(c.stop) || (c.drained && c.handing == 0 && len(c.leased) == 0 && len(c.retry) == 0) {
+			c.finished = true
+			c.notify()
+			c.mu.Unlock()
+			break
+		}
+		changed := c.changed
+		c.mu.Unlock()
+		select {
+		case <-changed:
+		case <-c.stop:
+		}
+	}
+	c.inflight.Wait()
+}
+
+// settle runs fn for a game taken off the lease table unless the run is
+// over. c.mu must be held; it is released.
+func (c *coordinator) settle(fn func()) bool {
+	if c.finished {
+		c.mu.Unlock()
+		return false
+	}
+	c.inflight.Add(1)
+	c.notify()
+	c.mu.Unlock()
+	defer c.inflight.Done()
+	fn()
+	return true
+}
+
+func (c *coordinator) handleJob(w http.ResponseWriter, r *http.Request) {
+	timeout := time.NewTimer(clusterPollWait)
+	defer timeout.Stop()
+	for {
+		c.mu.Lock()
+		if c.finished {
+			c.mu.Unlock()
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		var path string
+		if len(c.retry) > 0 {
+			path, c.retry = c.retry[0], c.retry[1:]
+		} else {
+			changed := c.changed
+			c.mu.Unlock()
+			select {
+			case path = <-c.next:
+			case <-changed:
+				continue
+			case <-timeout.C:
+				w.WriteHeader(http.StatusNoContent)
+				return
+			case <-r.Context().Done():
+				return
+			}
+			c.mu.Lock()
+			c.handing--
+			if c.finished {
+				c.mu.Unlock()
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+		}
+		// The game is leased in the same critical section that takes it,
+		// so it is never out of both the queue and the lease table.
+		c.lastLease++
+		lease := c.lastLease
+		c.leased[path] = lease
+		c.mu.Unlock()
+		time.AfterFunc(c.leaseTimeout, func() {
+			if c.release(path, lease) {
+				fmt.Fprintf(os.Stderr, "lease of %s expired; handing it to another worker\n", path)
+			}
+		})
+
+		data, err := cute.ReadKIFFile(path)
+		if err != nil {
+			c.mu.Lock()
+			if c.leased[path] != lease {
+				c.mu.Unlock()
+				continue
+			}
+			delete(c.leased, path)
+			c.settle(func() { c.hooks.fail(path, cute.StageRead, err, 0) })
+			continue
+		}
+		job := clusterJob{Path: path, Lease: lease, KIF: data, Partial: c.hooks.resumeFrom(path)}
+		if err := writeJSON(w, job); err != nil {
+			c.release(path, lease)
+		}
+		return
+	}
+}
+
+// release puts a leased game back in line for the next worker, unless it
+// was settled or leased again meanwhile.
+func (c *coordinator) release(path string, lease int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished || c.leased[path] != lease {
+		return false
+	}
+	delete(c.leased, path)
+	c.retry = append(c.retry, path)
+	c.notify()
+	return true
+}
+
+func (c *coordinator) handleResult(w http.ResponseWriter, r *http.Request) {
+	var result clusterResult
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A success without its record is rejected before the lease is
+	// touched, so the game is handed out again when the lease expires.
+	if !result.Abandoned && result.Error == "" && result.Record == nil {
+		http.Error(w, "result without error or record", http.StatusBadRequest)
+		return
+	}
+	if result.Abandoned {
+		c.release(result.Path, result.Lease)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c.mu.Lock()
+	if _, ok := c.leased[result.Path]; ok {
+		delete(c.leased, result.Path)
+	} else if i := slices.Index(c.retry, result.Path); i >= 0 {
+		c.retry = slices.Delete(c.retry, i, i+1)
+	} else {
+		// Another worker reported the game first.
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	elapsed := time.Duration(result.Seconds * float64(time.Second)).Round(time.Millisecond)
+	settled := c.settle(func() {
+		if result.Error != "" {
+			stage := result.Stage
+			if stage == "" {
+				stage = "unknown"
+			}
+			c.hooks.fail(result.Path, stage, errors.New(result.Error), elapsed)
+			if result.Record != nil {
+				c.hooks.keepPartial(result.Path, *result.Record, result.Engine)
+			}
+			return
+		}
+		c.hooks.finish(result.Path, *result.Record, result.Engine, elapsed)
+	})
+	if !settled {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v any) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(v)
+}
+
+// workerOptions are the local settings of a -worker run.
+type workerOptions struct {
+	engineOptions map[string]string
+	parallel      int
+	evalTimeout   time.Duration
+	fileTimeout   time.Duration
+	keepalive     time.Duration
+	evalCache     string
+	retry         *cute.EvalRetry
+}
+
+// runWorker evaluates games for the coordinator at coordinatorURL until the
+// coordinator's run is over or the worker is interrupted. Games being
+// evaluated when it is interrupted are given back.
+func runWorker(ctx context.Context, cancel context.CancelFunc, coordinatorURL, enginePath string, opts workerOptions) error {
+	if !strings.Contains(coordinatorURL, "://") {
+		coordinatorURL = "http://" + coordinatorURL
+	}
+	client := &clusterClient{base: strings.TrimSuffix(coordinatorURL, "/")}
+	var run clusterRun
+	if _, err := client.call(ctx, http.MethodGet, "/cluster/run", nil, &run); err != nil {
+		return err
+	}
+	policy := run.Policy
+	engineOptions := cute.EvalOptions{DrawScore: run.DrawScore}.Apply(opts.engineOptions)
+	if opts.evalCache != "" {
+		cache, err := openEvalCache(ctx, opts.evalCache, enginePath, engineOptions)
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+		policy.Cache = cache
+	}
+	evalTimeout := resolveEvalTimeout(opts.evalTimeout, policy)
+	tmpDir, err := os.MkdirTemp("", "cute-worker-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	stopCh := make(chan os.Signal, 1)
+	signal.Notify(stopCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stopCh)
+	stopRequested := make(chan struct{})
+	go func() {
+		<-stopCh
+		cancel()
+		close(stopRequested)
+	}()
+
+	fmt.Fprintf(os.Stderr, "worker: evaluating games of %s with %d engines\n", client.base, opts.parallel)
+	var restarts atomic.Int64
+	var evaluated atomic.Int64
+	errCh := make(chan error, opts.parallel)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker, err := newEngineWorker(ctx, stopRequested, errCh, &restarts, opts.retry, func() (*cute.Session, error) {
+				return startSession(ctx, enginePath, engineOptions, evalTimeout, opts.keepalive, nil)
+			})
+			if err != nil {
+				errCh <- err
+				return
+			}
+			defer worker.Close()
+			// Each worker writes its game under the original base name, so
+			// the game ID is the same as in a local run.
+			dir := filepath.Join(tmpDir, strconv.Itoa(i))
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				errCh <- err
+				return
+			}
+			for {
+				var job clusterJob
+				ok, err := client.call(ctx, http.MethodPost, "/cluster/job", struct{}{}, &job)
+				if errors.Is(err, errRunFinished) || ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					errCh <- err
+					return
+				}
+				if !ok {
+					continue
+				}
+				path := filepath.Join(dir, filepath.Base(job.Path))
+				if err := os.WriteFile(path, job.KIF, 0o644); err != nil {
+					errCh <- err
+					return
+				}
+				fileStart := time.Now()
+				record, err := worker.evaluate(path, policy, opts.fileTimeout, job.Partial)
+				_ = os.Remove(path)
+				result := clusterResult{Path: job.Path, Lease: job.Lease, Seconds: time.Since(fileStart).Seconds()}
+				if errors.Is(err, errWorkerStopped) {
+					// The run context may be canceled; give the game back
+					// on a fresh one.
+					giveBack, done := context.WithTimeout(context.Background(), 5*time.Second)
+					result.Abandoned = true
+					_, _ = client.call(giveBack, http.MethodPost, "/cluster/result", result, nil)
+					done()
+					return
+				}
+				elapsed := time.Duration(result.Seconds * float64(time.Second)).Round(time.Millisecond)
+				if err != nil {
+					result.Stage, result.Error = failureStage(err), err.Error()
+					fmt.Fprintf(os.Stderr, "failed to process %s (%s): %v\n", job.Path, elapsed, err)
+					// The coordinator decides whether to keep it.
+					if !record.EvalComplete && record.GameID != "" {
+						result.Record, result.Engine = &record, worker.session.Info()
+					}
+				} else {
+					result.Record, result.Engine = &record, worker.session.Info()
+					fmt.Fprintf(os.Stderr, "processed %s (%s)\n", job.Path, elapsed)
+				}
+				if _, err := client.call(ctx, http.MethodPost, "/cluster/result", result, nil); err != nil && !errors.Is(err, errRunFinished) {
+					if ctx.Err() == nil {
+						errCh <- err
+					}
+					return
+				}
+				evaluated.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	fmt.Fprintf(os.Stderr, "worker: evaluated %d games (engine restarts %d)\n", evaluated.Load(), restarts.Load())
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clusterClient talks to a coordinator.
+type clusterClient struct {
+	base string
+	http http.Client
+}
+
+// call sends body (when not nil) to the coordinator and decodes its answer
+// into out (when not nil), retrying for clusterRetryWindow while the
+// coordinator cannot be reached. ok is false when the coordinator had
+// nothing to send; errRunFinished means its run is over.
+func (c *clusterClient) call(ctx context.Context, method, path string, body, out any) (ok bool, err error) {
+	var payload []byte
+	if body != nil {
+		if payload, err = json.Marshal(body); err != nil {
+			return false, err
+		}
+	}
+	deadline := time.Now().Add(clusterRetryWindow)
+	for {
+		req, err := http.NewRequestWithContext(ctx, method, c.base+path, bytes.NewReader(payload))
+		if err != nil {
+			return false, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := c.http.Do(req)
+		if err == nil {
+			return readClusterResponse(resp, out)
+		}
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if time.Now().After(deadline) {
+			return false, fmt.Errorf("coordinator unreachable: %w", err)
+		}
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+func readClusterResponse(resp *http.Response, out any) (bool, error) {
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if out == nil {
+			return true, nil
+		}
+		return true, json.NewDecoder(resp.Body).Decode(out)
+	case http.StatusNoContent:
+		return false, nil
+	case http.StatusGone:
+		return false, errRunFinished
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("coordinator: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+// checkClusterFlags checks the -coordinator and -worker flags.
+func checkClusterFlags(f graphFlags) error {
+	if *f.workerURL != "" && *f.coordinatorAddr != "" {
+		return fmt.Errorf("-worker cannot be combined with -coordinator")
+	}
+	if *f.coordinatorAddr != "" && *f.leaseTimeout <= 0 {
+		return fmt.Errorf("-lease-timeout must be positive")
+	}
+	return nil
+}
+
+// runWorkerMode evaluates games for the coordinator at -worker with the
+// local engine settings of f.
+func runWorkerMode(ctx context.Context, cancel context.CancelFunc, f graphFlags, engine engineSetup) error {
+	opts := workerOptions{
+		engineOptions: engine.options,
+		parallel:      max(*f.processNum, 1),
+		evalTimeout:   *f.evalTimeout,
+		fileTimeout:   *f.fileTimeout,
+		keepalive:     *f.keepalive,
+		evalCache:     *f.evalCachePath,
+	}
+	var err error
+	if opts.retry, err = newEvalRetry(*f.evalRetries, *f.evalRetryBackoff, *f.evalRetryFresh); err != nil {
+		return err
+	}
+	return runWorker(ctx, cancel, *f.workerURL, engine.path, opts)
+}
+
+// startCoordinator hands the queued games to the workers connecting to
+// ln and settles them like local ones; wg is done once every game is.
+func (r *graphRun) startCoordinator(ln net.Listener, wg *sync.WaitGroup) *coordinator {
+	coord := newCoordinator(r.jobs, r.stop, clusterRun{Policy: r.policy, DrawScore: r.engine.drawScore}, *r.flags.leaseTimeout, fileHooks{
+		fail:        r.failFile,
+		finish:      r.finishFile,
+		keepPartial: r.keepPartial,
+		resumeFrom:  r.resumeFrom,
+	})
+	go coord.serve(ln)
+	fmt.Fprintf(os.Stderr, "coordinator: waiting for workers on http://%s\n", ln.Addr())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		coord.wait()
+	}()
+	return coord
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cute "cute/pkg/cute"
+)
+
+// compareEngine is engine B of an A/B run (-compare-config).
+type compareEngine struct {
+	path    string
+	options map[string]string
+	millis  int // 0 = the movetime of engine A
+}
+
+// loadCompareEngine reads the engine settings of the config at path:
+// engine, engine_options, eval_dir/eval_file/fv_scale and millis. Its
+// other settings are ignored.
+func loadCompareEngine(path string) (compareEngine, error) {
+	cfgPath, repoRoot, err := resolveConfigPath(path)
+	if err != nil {
+		return compareEngine{}, err
+	}
+	cfg, err := cute.LoadConfig(cfgPath)
+	if err != nil {
+		return compareEngine{}, err
+	}
+	enginePath, err := resolveEnginePath(cfg.Engine, repoRoot)
+	if err != nil {
+		return compareEngine{}, fmt.Errorf("%s: %w", cfgPath, err)
+	}
+	if _, err := os.Stat(enginePath); err != nil && !cute.IsRemoteEngine(enginePath) {
+		return compareEngine{}, fmt.Errorf("engine binary not found at %s: %w", enginePath, err)
+	}
+	return compareEngine{path: enginePath, options: cfg.EvalOptions().Apply(cfg.EngineOptions), millis: cfg.Millis}, nil
+}
+
+// compareOutputPath is the default -compare-output: out.parquet becomes
+// out-b.parquet.
+func compareOutputPath(output string) string {
+	ext := filepath.Ext(output)
+	return strings.TrimSuffix(output, ext) + "-b" + ext
+}
+
+// gameComparison sets the evals of one game by engines A and B side by
+// side.
+type gameComparison struct {
+	gameID string
+	// plies counts the plies both engines evaluated; meanAbsDiff is the
+	// mean absolute difference of their evals there, clipped like the
+	// blunder evals.
+	plies                int
+	meanAbsDiff          float64
+	crossingA, crossingB cute.Crossing
+	// blunders are the plies labeled blunders by A, by B and by both.
+	blundersA, blundersB, blundersBoth int
+}
+
+// comparison collects the games of an A/B run.
+type comparison struct {
+	crossing cute.CrossingOptions
+	blunder  int
+	maxEval  int
+
+	mu    sync.Mutex
+	games []gameComparison
+}
+
+func newComparison(threshold, blunder, maxEval int) *comparison {
+	return &comparison{
+		crossing: cute.CrossingOptions{Threshold: threshold, MateCountsAsCross: true},
+		blunder:  blunder,
+		maxEval:  maxEval,
+	}
+}
+
+// kifSenteFirst reports whether sente makes the first move of the KIF at
+// path; handicap games start with gote to move. A KIF without a board
+// counts as an even game.
+func kifSenteFirst(path string) bool {
+	board, err := cute.LoadBoardFromKIF(path)
+	if err != nil {
+		return true
+	}
+	initial := board.InitialPosition()
+	return initial.Turn() == cute.Black
+}
+
+// add compares the records of one game by engine A and engine B.
+// senteFirst tells the mover of each ply for the blunder counts.
+func (c *comparison) add(a, b cute.GameRecord, senteFirst bool) {
+	g := gameComparison{
+		gameID:    a.GameID,
+		crossingA: cute.FirstCrossing(a.MoveEvals, c.crossing),
+		crossingB: cute.FirstCrossing(b.MoveEvals, c.crossing),
+	}
+	valuesA, valuesB := c.values(a.MoveEvals), c.values(b.MoveEvals)
+	var sum int
+	for ply, va := range valuesA {
+		if vb, ok := valuesB[ply]; ok {
+			g.plies++
+			sum += max(va-vb, vb-va)
+		}
+	}
+	if g.plies > 0 {
+		g.meanAbsDiff = float64(sum) / float64(g.plies)
+	}
+	blundersA, blundersB := c.blunders(valuesA, senteFirst), c.blunders(valuesB, senteFirst)
+	g.blundersA, g.blundersB = len(blundersA), len(blundersB)
+	for ply := range blundersA {
+		if blundersB[ply] {
+			g.blundersBoth++
+		}
+	}
+	c.mu.Lock()
+	c.games = append(c.games, g)
+	c.mu.Unlock()
+}
+
+// values are the evals by ply, clipped to ±maxEval.
+func (c *comparison) values(evals []cute.MoveEval) map[int]int {
+	values := make(map[int]int, len(evals))
+	for _, eval := range evals {
+		values[int(eval.Ply)] = min(max(eval.EffectiveCP(), -c.maxEval), c.maxEval)
+	}
+	return values
+}
+
+// blunders returns the plies whose move lost at least c.blunder cp for
+// its player, as report counts them: sente moves on odd plies when
+// senteFirst, on even plies in handicap games.
+func (c *comparison) blunders(values map[int]int, senteFirst bool) map[int]bool {
+	plies := make(map[int]bool)
+	for ply, v := range values {
+		prev, ok := values[ply-1]
+		if !ok {
+			continue
+		}
+		loss := v - prev
+		if (ply%2 == 1) == senteFirst {
+			loss = -loss
+		}
+		if loss >= c.blunder {
+			plies[ply] = true
+		}
+	}
+	return plies
+}
+
+// report prints how much the engines agree over all games.
+func (c *comparison) report(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.games) == 0 {
+		fmt.Fprintln(w, "compare: no game was evaluated by both engines")
+		return
+	}
+	var plies, sameSide, bothCrossed, plyDiff, blundersA, blundersB, blundersBoth int
+	var diffSum float64
+	for _, g := range c.games {
+		plies += g.plies
+		diffSum += g.meanAbsDiff * float64(g.plies)
+		if g.crossingA.Side == g.crossingB.Side {
+			sameSide++
+			if g.crossingA.Side != "none" {
+				bothCrossed++
+				plyDiff += max(g.crossingA.Ply-g.crossingB.Ply, g.crossingB.Ply-g.crossingA.Ply)
+			}
+		}
+		blundersA += g.blundersA
+		blundersB += g.blundersB
+		blundersBoth += g.blundersBoth
+	}
+	n := len(c.games)
+	fmt.Fprintf(w, "compare: %d games, %d plies evaluated by both engines\n", n, plies)
+	if plies > 0 {
+		fmt.Fprintf(w, "  eval: mean |A-B| %.1f cp (clipped to ±%d)\n", diffSum/float64(plies), c.maxEval)
+	}
+	fmt.Fprintf(w, "  crossing ±%d: same side in %d games (%.1f%%)", c.crossing.Threshold, sameSide, 100*float64(sameSide)/float64(n))
+	if bothCrossed > 0 {
+		fmt.Fprintf(w, ", mean |ply A - ply B| %.1f over %d games both crossed", float64(plyDiff)/float64(bothCrossed), bothCrossed)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "  blunders (-%d cp): A %d, B %d, both %d", c.blunder, blundersA, blundersB, blundersBoth)
+	if union := blundersA + blundersB - blundersBoth; union > 0 {
+		fmt.Fprintf(w, " (agreement %.1f%%)", 100*float64(blundersBoth)/float64(union))
+	}
+	fmt.Fprintln(w)
+}
+
+// writeCSV writes one row per game, sorted by game ID.
+func (c *comparison) writeCSV(path string) error {
+	c.mu.Lock()
+	games := append([]gameComparison(nil), c.games...)
+	c.mu.Unlock()
+	sort.Slice(games, func(i, j int) bool { return games[i].gameID < games[j].gameID })
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"game_id", "plies", "mean_abs_diff", "crossing_a", "crossing_ply_a", "crossing_b", "crossing_ply_b", "blunders_a", "blunders_b", "blunders_both"})
+	for _, g := range games {
+		_ = w.Write([]string{
+			g.gameID,
+			strconv.Itoa(g.plies),
+			strconv.FormatFloat(g.meanAbsDiff, 'f', 1, 64),
+			g.crossingA.Side, strconv.Itoa(g.crossingA.Ply),
+			g.crossingB.Side, strconv.Itoa(g.crossingB.Ply),
+			strconv.Itoa(g.blundersA), strconv.Itoa(g.blundersB), strconv.Itoa(g.blundersBoth),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// setupCompareEngine loads engine B of an A/B run (-compare-config),
+// which scores draws like engine A, as the metadata says.
+func setupCompareEngine(f graphFlags, drawScore *int) (compareEngine, error) {
+	if *f.compareConfig == "" {
+		if *f.compareOutput != "" || *f.compareReport != "" {
+			return compareEngine{}, fmt.Errorf("-compare-output and -compare-report need -compare-config")
+		}
+		return compareEngine{}, nil
+	}
+	if *f.coordinatorAddr != "" || *f.workerURL != "" || *f.watch || *f.resume || *f.retryFailures != "" || *f.checkpoint > 0 || *f.estimateFiles > 0 || *f.keepPartial || *f.format != "parquet" {
+		return compareEngine{}, fmt.Errorf("-compare-config cannot be combined with -coordinator, -worker, -watch, -resume, -retry-failures, -checkpoint, -estimate, -keep-partial or -format arrow")
+	}
+	engine, err := loadCompareEngine(*f.compareConfig)
+	if err != nil {
+		return compareEngine{}, fmt.Errorf("compare-config: %w", err)
+	}
+	engine.options = cute.EvalOptions{DrawScore: drawScore}.Apply(engine.options)
+	return engine, nil
+}
+
+// checkCompareOutput defaults -compare-output to a file next to -output,
+// which it must not overwrite.
+func checkCompareOutput(f graphFlags) error {
+	if *f.compareConfig == "" {
+		return nil
+	}
+	if *f.compareOutput == "" {
+		*f.compareOutput = compareOutputPath(*f.outputPath)
+	}
+	if sameFile(*f.compareOutput, *f.outputPath) {
+		return fmt.Errorf("-compare-output must differ from -output")
+	}
+	return nil
+}
+
+// compareRun is the engine B side of an A/B run. It writes its own file
+// with its own metadata, searching with its millis, if set, and without
+// the eval cache, which holds engine A's evals.
+type compareRun struct {
+	engine      compareEngine
+	policy      cute.EvalPolicy
+	evalTimeout time.Duration
+	results     chan cute.GameRecord
+	writeErr    chan error
+	engines     *engineSet
+	compared    *comparison
+}
+
+// startCompare starts writing the engine B output of an A/B run.
+func (r *graphRun) startCompare() {
+	f := r.flags
+	if *f.compareConfig == "" {
+		return
+	}
+	c := &compareRun{
+		engine:   r.engine.b,
+		policy:   r.policy,
+		results:  make(chan cute.GameRecord, r.workers),
+		writeErr: make(chan error, 1),
+		engines:  &engineSet{drawScore: r.engine.drawScore},
+		compared: newComparison(*f.compareThreshold, *f.compareBlunder, 2000),
+	}
+	c.policy.Cache = nil
+	if c.engine.millis > 0 {
+		c.policy.MoveTimeMs = c.engine.millis
+	}
+	c.evalTimeout = resolveEvalTimeout(*f.evalTimeout, c.policy)
+	playerIndex := ""
+	if r.playerIndex != "" {
+		playerIndex = cute.PlayerIndexPath(*f.compareOutput)
+	}
+	go func() {
+		c.writeErr <- cute.WriteParquetWith(*f.compareOutput, c.results, cute.ParquetWriteOptions{Parallel: int64(r.workers), Meta: c.engines.meta, CompactEvals: *f.compactEvals, PlayerIndex: playerIndex})
+	}()
+	r.compare = c
+}
+
+// newCompareWorker starts engine B for a local worker.
+func (r *graphRun) newCompareWorker() (*engineWorker, error) {
+	c := r.compare
+	return newEngineWorker(r.ctx, r.stop, r.errs, &r.status.restarts, r.retry, func() (*cute.Session, error) {
+		return startSession(r.ctx, c.engine.path, c.engine.options, c.evalTimeout, *r.flags.keepalive, r.status.evals.observe)
+	})
+}
+
+// finishCompared writes the record of a game by engine B, before
+// finishFile writes engine A's.
+func (r *graphRun) finishCompared(path string, a, b cute.GameRecord, engine cute.EngineInfo) {
+	c := r.compare
+	r.applyTags(path, &b)
+	engine.Policy = c.policy.Limits()
+	c.engines.add(engine)
+	c.compared.add(a, b, kifSenteFirst(path))
+	c.results <- b
+}
+
+// finishCompare waits for the engine B output and reports how the
+// engines agree, also to -compare-report.
+func (r *graphRun) finishCompare() error {
+	c := r.compare
+	if c == nil {
+		return nil
+	}
+	close(c.results)
+	if err := <-c.writeErr; err != nil {
+		return err
+	}
+	c.compared.report(os.Stderr)
+	if *r.flags.compareReport != "" {
+		return c.compared.writeCSV(*r.flags.compareReport)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestComparisonBlunders(t *testing.T) {
+	c := newComparison(500, 300, 2000)
+	// The eval drops by 400 on ply 11 and recovers on ply 13.
+	values := map[int]int{10: 0, 11: -400, 12: -400, 13: 0}
+	for _, tc := range []struct {
+		name       string
+		senteFirst bool
+		want       map[int]bool
+	}{
+		// Sente plays ply 11 and loses 400.
+		{"even", true, map[int]bool{11: true}},
+		// Gote plays the odd plies of a handicap game and loses 400 on 13.
+		{"handicap", false, map[int]bool{13: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := c.blunders(values, tc.senteFirst); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("blunders = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestKIFSenteFirst(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, lines ...string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	even := write("even.kif",
+		"手合割：平手",
+		"手数----指手---------消費時間--",
+		"   1 ７六歩(77)   ( 0:00/00:00:00)",
+	)
+	// 二枚落ち: the handicap giver (gote) moves first.
+	handicap := write("handicap.kif",
+		"後手の持駒：なし",
+		"  ９ ８ ７ ６ ５ ４ ３ ２ １",
+		"+---------------------------+",
+		"|v香v桂v銀v金v玉v金v銀v桂v香|一",
+		"| ・ ・ ・ ・ ・ ・ ・ ・ ・|二",
+		"|v歩v歩v歩v歩v歩v歩v歩v歩v歩|三",
+		"| ・ ・ ・ ・ ・ ・ ・ ・ ・|四",
+		"| ・ ・ ・ ・ ・ ・ ・ ・ ・|五",
+		"| ・ ・ ・ ・ ・ ・ ・ ・ ・|六",
+		"| 歩 歩 歩 歩 歩 歩 歩 歩 歩|七",
+		"| ・ 角 ・ ・ ・ ・ ・ 飛 ・|八",
+		"| 香 桂 銀 金 玉 金 銀 桂 香|九",
+		"+---------------------------+",
+		"先手の持駒：なし",
+		"上手番",
+		"手数----指手---------消費時間--",
+		"   1 ６二銀(71)   ( 0:00/00:00:00)",
+	)
+	if !kifSenteFirst(even) {
+		t.Error("even game: sente should move first")
+	}
+	if kifSenteFirst(handicap) {
+		t.Error("handicap game: gote should move first")
+	}
+}
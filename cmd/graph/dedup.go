@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	cute "cute/pkg/cute"
+)
+
+// dedupSet finds KIF files holding a game (same players and moves) that
+// was already queued or is in the output under another game ID (-dedup).
+type dedupSet struct {
+	mu      sync.Mutex
+	games   map[string]string // dedup key -> game ID
+	skipped int
+}
+
+func newDedupSet() *dedupSet {
+	return &dedupSet{games: make(map[string]string)}
+}
+
+// addFile notes the games of an existing output file. Records written
+// before moves_hash existed are not matched.
+func (d *dedupSet) addFile(path string) error {
+	records, err := cute.ReadGameRecordsColumns(path, []string{"game_id", "sente_name", "gote_name", "moves_hash"})
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, record := range records {
+		if key := record.DedupKey(); key != "" {
+			if _, ok := d.games[key]; !ok {
+				d.games[key] = record.GameID
+			}
+		}
+	}
+	return nil
+}
+
+// duplicate reports whether the game of the KIF at path was seen under
+// another game ID, and notes it otherwise. A file that cannot be parsed is
+// not a duplicate: evaluating it reports the error.
+func (d *dedupSet) duplicate(path string) bool {
+	lines, err := cute.ReadKIFLines(path)
+	if err != nil {
+		return false
+	}
+	board, err := cute.BoardFromKIF(lines)
+	if err != nil {
+		return false
+	}
+	players := cute.PlayersFromKIFLines(lines)
+	key := cute.GameRecord{SenteName: players.SenteName, GoteName: players.GoteName, MovesHash: board.MovesHash()}.DedupKey()
+	id := filepath.Base(path)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if have, ok := d.games[key]; ok && have != id {
+		d.skipped++
+		fmt.Fprintf(os.Stderr, "skipping %s: same game as %s\n", path, have)
+		return true
+	}
+	d.games[key] = id
+	return false
+}
+
+// duplicates returns the number of files skipped as duplicates.
+func (d *dedupSet) duplicates() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return int64(d.skipped)
+}
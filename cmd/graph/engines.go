@@ -0,0 +1,38 @@
+package main
+
+import (
+	"sync"
+
+	cute "cute/pkg/cute"
+)
+
+// engineSet collects the engine setups whose evals go into the output,
+// for the parquet metadata.
+type engineSet struct {
+	drawScore *int // -draw-score
+
+	mu      sync.Mutex
+	engines []cute.EngineInfo
+}
+
+func (s *engineSet) add(infos ...cute.EngineInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engines = cute.MergeEngines(s.engines, infos)
+}
+
+// addFile adds the engines recorded in an existing parquet file.
+func (s *engineSet) addFile(path string) error {
+	meta, err := cute.ReadParquetMeta(path)
+	if err != nil {
+		return err
+	}
+	s.add(meta.Engines...)
+	return nil
+}
+
+func (s *engineSet) meta() cute.ParquetMeta {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return cute.ParquetMeta{Engines: append([]cute.EngineInfo(nil), s.engines...), ScorePerspective: cute.PerspectiveSente, DrawScore: s.drawScore}
+}
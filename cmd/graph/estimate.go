@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	cute "cute/pkg/cute"
+)
+
+// estimateSeed fixes the -estimate sample, so that estimates of the same
+// input are comparable.
+const estimateSeed = 1
+
+// runEstimate is the result of evaluating the -estimate sample.
+type runEstimate struct {
+	sampled, failed int
+	parse, eval     time.Duration // summed over the evaluated games
+	plies           int           // evals made for the evaluated games
+	outputBytes     int64         // parquet size of the evaluated games
+}
+
+// sampleInput picks k of the files walk feeds, uniformly and
+// reproducibly, in input order.
+func sampleInput(walk func(fn func(path string) error) error, k int) ([]string, error) {
+	rng := rand.New(rand.NewSource(estimateSeed))
+	type pick struct {
+		index int
+		path  string
+	}
+	var picks []pick
+	n := 0
+	err := walk(func(path string) error {
+		if len(picks) < k {
+			picks = append(picks, pick{n, path})
+		} else if i := rng.Intn(n + 1); i < k {
+			picks[i] = pick{n, path}
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Reservoir slots are not in input order.
+	sort.Slice(picks, func(i, j int) bool { return picks[i].index < picks[j].index })
+	paths := make([]string, len(picks))
+	for i, p := range picks {
+		paths[i] = p.path
+	}
+	return paths, nil
+}
+
+// estimate evaluates paths one after another with one engine, as a worker
+// would, and measures the time spent and the size of their records.
+// policy should not use the persistent eval cache, whose hits would make
+// the sample look cheaper than the run.
+func estimate(ctx context.Context, paths []string, session *cute.Session, policy cute.EvalPolicy, fileTimeout time.Duration, compact bool) (runEstimate, error) {
+	var est runEstimate
+	var records []cute.GameRecord
+	cache := make(map[string]cute.Score)
+	for _, path := range paths {
+		est.sampled++
+		start := time.Now()
+		if _, err := cute.LoadBoardFromKIF(path); err != nil {
+			fmt.Fprintf(os.Stderr, "estimate: %s: %v\n", path, err)
+			est.failed++
+			continue
+		}
+		parsed := time.Now()
+		record, err := buildRecord(ctx, path, session, policy, cache, fileTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return est, ctx.Err()
+			}
+			fmt.Fprintf(os.Stderr, "estimate: %s: %v\n", path, err)
+			est.failed++
+			if !session.Health().Alive {
+				return est, fmt.Errorf("engine failed while estimating: %w", err)
+			}
+			continue
+		}
+		est.parse += parsed.Sub(start)
+		est.eval += time.Since(parsed)
+		est.plies += len(record.MoveEvals)
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return est, nil
+	}
+
+	// Write the records as the run would to learn their size.
+	dir, err := os.MkdirTemp("", "cute-estimate-")
+	if err != nil {
+		return est, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sample.parquet")
+	ch := make(chan cute.GameRecord, len(records))
+	for _, record := range records {
+		ch <- record
+	}
+	close(ch)
+	if err := cute.WriteParquetWith(path, ch, cute.ParquetWriteOptions{Parallel: 1, CompactEvals: compact}); err != nil {
+		return est, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return est, err
+	}
+	est.outputBytes = info.Size()
+	return est, nil
+}
+
+// report writes the measurements and their projection onto total files
+// evaluated by workers engines.
+func (e runEstimate) report(out io.Writer, total, workers int, policy cute.EvalPolicy) {
+	games := e.sampled - e.failed
+	fmt.Fprintf(out, "estimate: sampled %d of %d files (%d failed)\n", e.sampled, total, e.failed)
+	if games == 0 {
+		fmt.Fprintln(out, "estimate: no game of the sample could be evaluated")
+		return
+	}
+	perGame := (e.parse + e.eval) / time.Duration(games)
+	fmt.Fprintf(out, "  parse: %s per file\n", (e.parse / time.Duration(games)).Round(time.Microsecond))
+	if e.plies > 0 {
+		fmt.Fprintf(out, "  evals: %.1f per game, %s each (movetime %dms)\n", float64(e.plies)/float64(games), (e.eval / time.Duration(e.plies)).Round(100*time.Microsecond), policy.MoveTimeMs)
+	}
+	fmt.Fprintf(out, "  per game: %s\n", perGame.Round(time.Millisecond))
+
+	// Failed files take little time, so the projection counts every file
+	// as an evaluated one.
+	wall := time.Duration(float64(perGame) * float64(total) / float64(workers))
+	bytesPerGame := float64(e.outputBytes) / float64(games)
+	fmt.Fprintf(out, "projected for %d files with %d engines: %s wall-clock, %s output (%s per game)\n",
+		total, workers, wall.Round(time.Second), formatSize(bytesPerGame*float64(total)), formatSize(bytesPerGame))
+}
+
+// formatSize formats a byte count with a binary unit.
+func formatSize(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for bytes >= 1024 && i < len(units)-1 {
+		bytes /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", bytes, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", bytes, units[i])
+}
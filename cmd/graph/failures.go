@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// failure is one line of the failures file (JSON Lines).
+type failure struct {
+	Path  string `json:"path"`
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+}
+
+// failureLog appends failures to a JSONL file, which is only created once
+// the first failure is recorded. It is safe for concurrent use; a
+// failureLog with an empty path discards everything.
+type failureLog struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+}
+
+// newFailureLog returns a log appending to path, or replacing its previous
+// contents when truncate is set.
+func newFailureLog(path string, truncate bool) *failureLog {
+	l := &failureLog{path: path}
+	if truncate && path != "" {
+		// Remove the old list even if nothing fails this time.
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "failed to reset %s: %v\n", path, err)
+		}
+	}
+	return l
+}
+
+func (l *failureLog) record(path, stage string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.path == "" {
+		return
+	}
+	if l.f == nil {
+		f, openErr := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if openErr != nil {
+			fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", l.path, openErr)
+			l.path = ""
+			return
+		}
+		l.f, l.enc = f, json.NewEncoder(f)
+	}
+	_ = l.enc.Encode(failure{Path: path, Stage: stage, Error: err.Error()})
+}
+
+func (l *failureLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// readFailurePaths returns the unique paths listed in a failures file, in
+// file order.
+func readFailurePaths(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec failure
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		if rec.Path == "" || seen[rec.Path] {
+			continue
+		}
+		seen[rec.Path] = true
+		paths = append(paths, rec.Path)
+	}
+	return paths, scanner.Err()
+}
+
+// sameFile reports whether a and b name the same file.
+func sameFile(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
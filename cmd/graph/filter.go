@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// inputFilter selects input KIF paths by -include-list, -exclude-list,
+// -include-glob and -exclude-glob.
+type inputFilter struct {
+	root    string
+	only    map[string]bool // paths and game IDs from -include-list
+	exclude map[string]bool // paths and game IDs from -exclude-list
+	include []string
+	skip    []string
+}
+
+func newInputFilter(root, includeList, excludeList, includeGlobs, excludeGlobs string) (*inputFilter, error) {
+	f := &inputFilter{root: root}
+	var err error
+	if f.include, err = parseGlobs(includeGlobs); err != nil {
+		return nil, fmt.Errorf("-include-glob: %w", err)
+	}
+	if f.skip, err = parseGlobs(excludeGlobs); err != nil {
+		return nil, fmt.Errorf("-exclude-glob: %w", err)
+	}
+	if includeList != "" {
+		if f.only, err = readPathList(includeList); err != nil {
+			return nil, err
+		}
+	}
+	if excludeList != "" {
+		if f.exclude, err = readPathList(excludeList); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// keep reports whether path passes the filters. Excludes win over
+// includes.
+func (f *inputFilter) keep(path string) bool {
+	if f.exclude[filepath.Clean(path)] || f.exclude[filepath.Base(path)] {
+		return false
+	}
+	if f.only != nil && !f.only[filepath.Clean(path)] && !f.only[filepath.Base(path)] {
+		return false
+	}
+	rel := path
+	if r, err := filepath.Rel(f.root, path); err == nil && !strings.HasPrefix(r, "..") {
+		rel = r
+	}
+	if matchAnyGlob(f.skip, rel) {
+		return false
+	}
+	return len(f.include) == 0 || matchAnyGlob(f.include, rel)
+}
+
+// matchAnyGlob reports whether a pattern matches rel, one of its parent
+// directories (so "2024-01" or "2024-*" selects a whole subdirectory), or
+// its base name (so "*_test.kif" works at any depth).
+func matchAnyGlob(patterns []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+			return true
+		}
+		prefix := rel
+		for {
+			if ok, _ := filepath.Match(pattern, prefix); ok {
+				return true
+			}
+			i := strings.LastIndex(prefix, "/")
+			if i < 0 {
+				break
+			}
+			prefix = prefix[:i]
+		}
+	}
+	return false
+}
+
+// parseGlobs splits a comma-separated pattern list and checks the syntax.
+func parseGlobs(s string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("%q: %w", p, err)
+		}
+		patterns = append(patterns, filepath.ToSlash(p))
+	}
+	return patterns, nil
+}
+
+// readPathList reads one path or game ID (file name) per line; blank
+// lines and lines starting with "#" are ignored.
+func readPathList(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	exclude := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		exclude[filepath.Clean(line)] = true
+	}
+	return exclude, scanner.Err()
+}
@@ -5,9 +5,12 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -17,221 +20,953 @@ import (
 	cute "cute/pkg/cute"
 
 	"github.com/xitongsys/parquet-go-source/local"
-	"github.com/xitongsys/parquet-go/reader"
 )
 
+// graphFlags are the command-line flags of graph. Config flag defaults
+// are applied to them in place by setupEngines.
+type graphFlags struct {
+	configPath       *string
+	inputDir         *string
+	outputPath       *string
+	format           *string
+	evalDir          *string
+	evalFile         *string
+	fvScale          *int
+	drawScore        *string
+	compactEvals     *bool
+	dedup            *bool
+	playerIndex      *bool
+	processNum       *int
+	resume           *bool
+	reprocessInvalid *bool
+	evalTimeout      *time.Duration
+	fileTimeout      *time.Duration
+	keepalive        *time.Duration
+	evalRetries      *int
+	evalRetryBackoff *time.Duration
+	evalRetryFresh   *bool
+	keepPartial      *bool
+	failuresPath     *string
+	remainingPath    *string
+	checkpoint       *int
+	shard            *string
+	includeList      *string
+	excludeList      *string
+	includeGlob      *string
+	excludeGlob      *string
+	openingPlies     *int
+	openingMillis    *int
+	imbalanceThresh  *int
+	imbalanceMillis  *int
+	evalStride       *int
+	evalCachePath    *string
+	retryFailures    *string
+	openingDB        *string
+	classify         *bool
+	activity         *bool
+	watch            *bool
+	watchFlush       *time.Duration
+	watchSettle      *time.Duration
+	statusAddr       *string
+	progressJSON     *string
+	progressInterval *time.Duration
+	annotatedDir     *string
+	outputEncoding   *string
+	metricsAddr      *string
+	coordinatorAddr  *string
+	workerURL        *string
+	estimateFiles    *int
+	leaseTimeout     *time.Duration
+	compareConfig    *string
+	compareOutput    *string
+	compareReport    *string
+	compareThreshold *int
+	compareBlunder   *int
+}
+
+func parseFlags() graphFlags {
+	f := graphFlags{
+		configPath:       flag.String("config", "", "shared config file: engine, engine options and flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json)"),
+		inputDir:         flag.String("input", "test_kif", "input directory for KIF files"),
+		outputPath:       flag.String("output", "output.parquet", "output parquet file"),
+		format:           flag.String("format", "parquet", "output format: parquet|arrow (Arrow IPC file)"),
+		evalDir:          flag.String("eval-dir", "", "engine EvalDir option: directory of the evaluation files (default: config eval_dir, else the engine's)"),
+		evalFile:         flag.String("eval-file", "", "engine EvalFile option: NNUE network file in -eval-dir (default: config eval_file, else the engine's)"),
+		fvScale:          flag.Int("fv-scale", 0, "engine FV_SCALE option (default: config fv_scale, else 36)"),
+		drawScore:        flag.String("draw-score", "", "what a draw (repetition) is worth in cp from sente's perspective, set as the engine's DrawValueBlack option and negated as DrawValueWhite, and recorded in the output metadata (default: the engine's own)"),
+		compactEvals:     flag.Bool("compact-evals", false, "store move_evals packed in a byte array column (much smaller; read transparently by all commands)"),
+		dedup:            flag.Bool("dedup", false, "skip KIF files holding the same game (players and moves) as one already queued or in the output under another game ID"),
+		playerIndex:      flag.Bool("player-index", false, "also write a player index (output.players.json) that lets stats and user_threshold_stats -users read only those players' games (parquet only)"),
+		processNum:       flag.Int("process-num", 20, "number of parallel workers"),
+		resume:           flag.Bool("resume", false, "resume from existing output parquet"),
+		reprocessInvalid: flag.Bool("reprocess-invalid", false, "with -resume, evaluate again the games whose stored record is malformed or was written under other engine limits (default: report and reuse them)"),
+		evalTimeout:      flag.Duration("eval-timeout", 0, "watchdog for a single engine evaluation (0=auto: 10x movetime, at least 30s; negative disables)"),
+		fileTimeout:      flag.Duration("file-timeout", 0, "maximum time to evaluate one KIF file (0=no limit)"),
+		keepalive:        flag.Duration("keepalive", time.Minute, "ping idle engines this often and restart ones that stopped answering (0=disabled)"),
+		evalRetries:      flag.Int("eval-retries", 0, "retry a failed engine evaluation up to N times before giving up on the game, restarting the engine when it died or timed out; retries are counted in the eval_retries column (0=disabled)"),
+		evalRetryBackoff: flag.Duration("eval-retry-backoff", time.Second, "wait before the first retry of an evaluation, doubled for each further one (at most 1m)"),
+		evalRetryFresh:   flag.Bool("eval-retry-fresh", false, "restart the engine before every retry, not only when it died or timed out"),
+		keepPartial:      flag.Bool("keep-partial", false, "when the evaluation of a game fails partway, still write its record with the evaluations made so far and eval_complete false; -resume completes such records"),
+		failuresPath:     flag.String("failures", "failures.jsonl", "append failed games to this JSONL file (empty=disabled)"),
+		remainingPath:    flag.String("remaining", "remaining.txt", "when interrupted, list the input files not processed in this file for -include-list (empty=disabled)"),
+		checkpoint:       flag.Int("checkpoint", 0, "save completed games to part files every N games, merged into -output at the end (0=disabled)"),
+		shard:            flag.String("shard", "", "process only shard i/N of the input (0 <= i < N) into output-shard-i.parquet"),
+		includeList:      flag.String("include-list", "", "file listing KIF paths or game IDs (file names) to process, one per line; others are skipped (e.g. the -remaining file of an interrupted run)"),
+		excludeList:      flag.String("exclude-list", "", "file listing KIF paths or game IDs (file names) to skip, one per line"),
+		includeGlob:      flag.String("include-glob", "", "comma-separated glob patterns; process only matching paths (relative to -input)"),
+		excludeGlob:      flag.String("exclude-glob", "", "comma-separated glob patterns of paths (relative to -input) to skip"),
+		openingPlies:     flag.Int("opening-plies", 0, "plies 1..N use -opening-millis instead of the configured movetime (0=disabled)"),
+		openingMillis:    flag.Int("opening-millis", 0, "movetime in ms for the opening plies"),
+		imbalanceThresh:  flag.Int("imbalance-threshold", 0, "material balance in pawn units at which -imbalance-millis is used (0=disabled)"),
+		imbalanceMillis:  flag.Int("imbalance-millis", 0, "movetime in ms once material is imbalanced"),
+		evalStride:       flag.Int("eval-stride", 1, "evaluate only every N-th ply (plus the last one)"),
+		evalCachePath:    flag.String("eval-cache", "", "persistent eval cache file shared by workers, runs and processes (empty=disabled)"),
+		retryFailures:    flag.String("retry-failures", "", "re-process only the files listed in this failures JSONL into the existing -output"),
+		openingDB:        flag.String("opening-db", "", "opening DB parquet (cmd/classify or tools/classify_kif_to_db.rb) whose attack/defense tags are embedded in the output"),
+		classify:         flag.Bool("classify", false, "tag games with the built-in opening classifier (games listed in -opening-db use its tags)"),
+		activity:         flag.Bool("activity", false, "also store per-ply piece activity (legal moves, pieces in hand and promoted pieces of each side) packed in the activity_packed column"),
+		watch:            flag.Bool("watch", false, "after the existing files, keep watching -input for new KIF files and append their games to -output until interrupted"),
+		watchFlush:       flag.Duration("watch-flush", time.Minute, "with -watch, how often newly evaluated games are merged into -output"),
+		watchSettle:      flag.Duration("watch-settle", 2*time.Second, "with -watch, read a new file once it has not changed for this long"),
+		statusAddr:       flag.String("status-addr", "", "serve progress as JSON at http://ADDR/status, e.g. localhost:8081 (empty=disabled)"),
+		progressJSON:     flag.String("progress-json", "", "append the progress as JSON lines to this file every -progress-interval and at the end (\"-\"=stdout, empty=disabled)"),
+		progressInterval: flag.Duration("progress-interval", 10*time.Second, "how often -progress-json is written"),
+		annotatedDir:     flag.String("annotated-dir", "", "also write each evaluated KIF with the evals as comments under this directory, keeping the layout of -input (empty=disabled)"),
+		outputEncoding:   flag.String("output-encoding", cute.KIFEncodingUTF8, "encoding of the -annotated-dir KIF files: utf8 or sjis"),
+		metricsAddr:      flag.String("metrics-addr", "", "serve Prometheus metrics at http://ADDR/metrics (games processed, engine restarts, eval latency histogram; empty=disabled)"),
+		coordinatorAddr:  flag.String("coordinator", "", "hand the input games to -worker processes over HTTP on ADDR (e.g. :9090) instead of evaluating them here, and write their records to -output"),
+		workerURL:        flag.String("worker", "", "evaluate games for the coordinator at URL (e.g. http://host:9090) with -process-num local engines; input and output flags are ignored"),
+		estimateFiles:    flag.Int("estimate", 0, "evaluate a sample of N input files with one engine, print the projected wall-clock time and output size of the whole run and exit without writing anything (0=disabled)"),
+		leaseTimeout:     flag.Duration("lease-timeout", 30*time.Minute, "with -coordinator, hand a game to another worker when its worker has not reported back for this long"),
+		compareConfig:    flag.String("compare-config", "", "A/B run: also evaluate every game with the engine of this config file (its engine, engine_options, eval_dir/eval_file/fv_scale and millis) and write those records to -compare-output, linked to -output by game_id"),
+		compareOutput:    flag.String("compare-output", "", "with -compare-config, output parquet of the second engine (default: -output with -b added to the name)"),
+		compareReport:    flag.String("compare-report", "", "with -compare-config, write how the engines agree on each game (eval difference, first crossing, blunders) to this CSV file"),
+		compareThreshold: flag.Int("compare-threshold", 300, "with -compare-config, eval threshold of the first crossings compared"),
+		compareBlunder:   flag.Int("compare-blunder", 300, "with -compare-config, a move losing at least this many cp for its player is a blunder (evals clipped to ±2000 as in report)"),
+	}
+	flag.Parse()
+	return f
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "merge-parquet" {
+		runMergeParquet(os.Args[2:])
+		return
+	}
 	startTime := time.Now()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	configPath := flag.String("config", "config.json", "path to config.json")
-	inputDir := flag.String("input", "test_kif", "input directory for KIF files")
-	outputPath := flag.String("output", "output.parquet", "output parquet file")
-	processNum := flag.Int("process-num", 20, "number of parallel workers")
-	resume := flag.Bool("resume", false, "resume from existing output parquet")
-	flag.Parse()
-
-	cfgPath, repoRoot, err := resolveConfigPath(*configPath)
+	f := parseFlags()
+	engine, err := setupEngines(f)
 	if err != nil {
 		fatal(err)
 	}
+	if err := checkClusterFlags(f); err != nil {
+		fatal(err)
+	}
+	if *f.workerURL != "" {
+		if err := runWorkerMode(ctx, cancel, f, engine); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	r := &graphRun{ctx: ctx, flags: f, engine: engine, started: startTime}
+	defer r.close()
+	if err := r.setupOutput(); err != nil {
+		fatal(err)
+	}
+	if ok, err := r.setupInput(); err != nil {
+		fatal(err)
+	} else if !ok {
+		return
+	}
+	if err := r.setupEval(); err != nil {
+		fatal(err)
+	}
+	if *f.estimateFiles > 0 {
+		if err := r.estimate(); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if ok, err := r.prepareOutput(); err != nil {
+		fatal(err)
+	} else if !ok {
+		return
+	}
+	if err := r.startServers(); err != nil {
+		fatal(err)
+	}
+	// The coordinator listens before the output is touched so a busy port
+	// fails the run early.
+	var coordinatorListener net.Listener
+	if *f.coordinatorAddr != "" {
+		if coordinatorListener, err = net.Listen("tcp", *f.coordinatorAddr); err != nil {
+			fatal(err)
+		}
+	}
+	r.startCompare()
+	r.startWriter()
+	if r.resumeFromExisting && *f.checkpoint <= 0 {
+		if err := r.readResumed(*f.outputPath, r.results); err != nil {
+			fatal(err)
+		}
+	}
+	if r.check != nil {
+		r.check.report(os.Stderr)
+	}
+	stopCh := make(chan os.Signal, 1)
+	signal.Notify(stopCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stopCh
+		cancel()
+		close(r.stop)
+	}()
+	defer signal.Stop(stopCh)
+	r.showProgress()
+
+	var wg sync.WaitGroup
+	var coord *coordinator
+	if coordinatorListener != nil {
+		coord = r.startCoordinator(coordinatorListener, &wg)
+	} else {
+		for i := 0; i < r.workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r.evaluateJobs()
+			}()
+		}
+	}
+	if err := r.feed(); err != nil {
+		fatal(err)
+	}
+	wg.Wait()
+	close(r.done)
+	<-r.progressDone
+	if err := r.finishOutput(); err != nil {
+		fatal(err)
+	}
+	close(r.errs)
+	for err := range r.errs {
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if coord != nil {
+		coord.linger()
+	}
+	r.summarize()
+}
+
+// engineSetup is the engine of a run and, with -compare-config, engine B
+// of the A/B run.
+type engineSetup struct {
+	path       string
+	options    map[string]string
+	moveTimeMs int  // config millis, 1000 when unset
+	drawScore  *int // -draw-score
+	b          compareEngine
+}
+
+// setupEngines loads the config, applies its flag defaults to f and
+// resolves the engines of the run. A coordinator leaves the engine to
+// its workers, so it need not exist there.
+func setupEngines(f graphFlags) (engineSetup, error) {
+	cfgPath, repoRoot, err := resolveConfigPath(*f.configPath)
+	if err != nil {
+		return engineSetup{}, err
+	}
 	cfg, err := cute.LoadConfig(cfgPath)
 	if err != nil {
-		fatal(err)
+		return engineSetup{}, err
+	}
+	if err := cfg.ApplyFlags("graph", flag.CommandLine); err != nil {
+		return engineSetup{}, err
+	}
+	var e engineSetup
+	if *f.drawScore != "" {
+		n, err := strconv.Atoi(*f.drawScore)
+		if err != nil {
+			return engineSetup{}, fmt.Errorf("-draw-score: %w", err)
+		}
+		e.drawScore = &n
+	}
+	e.options = cute.EvalOptions{Dir: *f.evalDir, File: *f.evalFile, FVScale: *f.fvScale, DrawScore: e.drawScore}.Apply(cfg.EngineOptions)
+	e.moveTimeMs = cfg.Millis
+	if e.moveTimeMs <= 0 {
+		e.moveTimeMs = 1000
+	}
+	if e.b, err = setupCompareEngine(f, e.drawScore); err != nil {
+		return engineSetup{}, err
+	}
+	e.path, err = resolveEnginePath(cfg.Engine, repoRoot)
+	if *f.coordinatorAddr != "" {
+		return e, nil
 	}
-	enginePath, err := resolveEnginePath(cfg.Engine, repoRoot)
 	if err != nil {
-		fatal(err)
+		return engineSetup{}, err
+	}
+	if _, err := os.Stat(e.path); err != nil && !cute.IsRemoteEngine(e.path) {
+		return engineSetup{}, fmt.Errorf("engine binary not found at %s: %w", e.path, err)
 	}
-	if _, err := os.Stat(enginePath); err != nil {
-		fatal(fmt.Errorf("engine binary not found at %s: %w", enginePath, err))
+	return e, nil
+}
+
+// graphRun is a run evaluating the input here or on -worker processes.
+// main sets it up step by step; the helpers share its state.
+type graphRun struct {
+	ctx     context.Context
+	flags   graphFlags
+	engine  engineSetup
+	started time.Time
+
+	// arrowPath is the -format arrow output, exported from the parquet
+	// -output at the end ("" for parquet). playerIndex is the -player-index
+	// file of -output as finally renamed into place.
+	arrowPath   string
+	playerIndex string
+
+	keep       func(path string) bool
+	walkInput  func(fn func(path string) error) error
+	totalFiles int
+	// truncateFailures rewrites the failure list being retried.
+	truncateFailures bool
+
+	policy      cute.EvalPolicy
+	evalTimeout time.Duration
+	retry       *cute.EvalRetry
+	tagger      *openingTagger
+	failures    *failureLog
+	workers     int
+
+	// outputTarget is written and then renamed to -output when the
+	// existing output or parts are merged into it.
+	outputTarget       string
+	resumeFromExisting bool
+	// check validates the records reused from the output and parts.
+	check        *resumeCheck
+	processedIDs map[string]struct{}
+	oldParts     []string
+	newParts     []string
+	engines      *engineSet
+	dedup        *dedupSet
+
+	status       *runStatus
+	progress     *progressLog
+	work         *workSet
+	jobs         chan string
+	results      chan cute.GameRecord
+	writeErr     chan error
+	writeWg      sync.WaitGroup
+	errs         chan error
+	stop         chan struct{} // closed when the run is interrupted
+	done         chan struct{} // closed when every job is settled
+	progressDone chan struct{}
+
+	compare *compareRun // nil without -compare-config
+}
+
+// close releases what the setup opened.
+func (r *graphRun) close() {
+	if r.policy.Cache != nil {
+		r.policy.Cache.Close()
 	}
-	totalFiles, err := cute.CountKIF(*inputDir)
+	if r.failures != nil {
+		r.failures.Close()
+	}
+	if r.progress != nil {
+		r.progress.Close()
+	}
+}
+
+// setupOutput checks the output flags and settles the output paths.
+func (r *graphRun) setupOutput() error {
+	f := r.flags
+	// With -format arrow the games are written to a parquet file next to
+	// the output as usual (checkpoints and the engine metadata need it)
+	// and exported at the end.
+	switch *f.format {
+	case "parquet":
+	case "arrow":
+		if *f.resume || *f.retryFailures != "" || *f.shard != "" {
+			return fmt.Errorf("-format arrow cannot be combined with -resume, -retry-failures or -shard; write parquet and export it with merge-parquet -format arrow")
+		}
+		r.arrowPath = *f.outputPath
+		*f.outputPath = r.arrowPath + ".parquet"
+	default:
+		return fmt.Errorf("format must be parquet or arrow")
+	}
+	// The index describes -output as finally renamed into place.
+	if *f.playerIndex && r.arrowPath == "" {
+		r.playerIndex = cute.PlayerIndexPath(*f.outputPath)
+	}
+	if *f.reprocessInvalid && !*f.resume && *f.retryFailures == "" {
+		return fmt.Errorf("-reprocess-invalid needs -resume or -retry-failures")
+	}
+	if err := checkWatchFlags(f, r.arrowPath != ""); err != nil {
+		return err
+	}
+	if _, err := cute.EncodeKIF("", *f.outputEncoding); err != nil {
+		return err
+	}
+	shardIndex, shardCount := 0, 0
+	if *f.shard != "" {
+		var err error
+		if shardIndex, shardCount, err = parseShard(*f.shard); err != nil {
+			return err
+		}
+		*f.outputPath = shardOutputPath(*f.outputPath, shardIndex)
+	}
+	if err := checkCompareOutput(f); err != nil {
+		return err
+	}
+	filter, err := newInputFilter(*f.inputDir, *f.includeList, *f.excludeList, *f.includeGlob, *f.excludeGlob)
 	if err != nil {
-		fatal(err)
+		return err
 	}
-	if totalFiles == 0 {
-		fatal(fmt.Errorf("no .kif files found in %s", *inputDir))
+	r.keep = func(path string) bool {
+		if !filter.keep(path) {
+			return false
+		}
+		return shardCount == 0 || inShard(path, shardIndex, shardCount)
 	}
+	return nil
+}
+
+// setupInput settles the input paths and counts them. ok is false when
+// there is nothing to do.
+func (r *graphRun) setupInput() (ok bool, err error) {
+	f := r.flags
+	filtered := *f.shard != "" || *f.includeList != "" || *f.excludeList != "" || *f.includeGlob != "" || *f.excludeGlob != ""
 
-	moveTimeMs := cfg.Millis
-	if moveTimeMs <= 0 {
-		moveTimeMs = 1000
+	// walkInput feeds input paths: the -input tree, or the failure list
+	// in -retry-failures mode.
+	r.walkInput = func(fn func(path string) error) error {
+		return cute.WalkKIF(*f.inputDir, func(path string) error {
+			if !r.keep(path) {
+				return nil
+			}
+			return fn(path)
+		})
+	}
+	if *f.retryFailures != "" {
+		retryPaths, err := readFailurePaths(*f.retryFailures)
+		if err != nil {
+			return false, err
+		}
+		retryPaths = slices.DeleteFunc(retryPaths, func(path string) bool { return !r.keep(path) })
+		if len(retryPaths) == 0 {
+			fmt.Fprintf(os.Stderr, "no failures to retry in %s\n", *f.retryFailures)
+			return false, nil
+		}
+		r.totalFiles = len(retryPaths)
+		r.walkInput = func(fn func(path string) error) error {
+			for _, path := range retryPaths {
+				if err := fn(path); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		// Games are appended to the existing output like -resume.
+		*f.resume = true
+		// Rewriting the list being retried leaves only the games that
+		// still fail.
+		r.truncateFailures = sameFile(*f.retryFailures, *f.failuresPath)
+		return true, nil
 	}
+	if filtered {
+		err = r.walkInput(func(string) error {
+			r.totalFiles++
+			return nil
+		})
+	} else {
+		r.totalFiles, err = cute.CountKIF(*f.inputDir)
+	}
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case r.totalFiles == 0 && *f.watch:
+		fmt.Fprintf(os.Stderr, "no .kif files in %s yet; watching for new ones\n", *f.inputDir)
+	case r.totalFiles == 0 && filtered:
+		fmt.Fprintf(os.Stderr, "no .kif files in %s pass the filters\n", *f.inputDir)
+		return false, nil
+	case r.totalFiles == 0:
+		return false, fmt.Errorf("no .kif files found in %s", *f.inputDir)
+	}
+	return true, nil
+}
 
-	workers := *processNum
-	if workers <= 0 {
-		workers = 1
+// setupEval sets up the eval policy, the eval cache, the opening tagger
+// and the engine watchdogs.
+func (r *graphRun) setupEval() error {
+	f := r.flags
+	r.policy = cute.EvalPolicy{
+		MoveTimeMs:          r.engine.moveTimeMs,
+		OpeningPlies:        *f.openingPlies,
+		OpeningMoveTimeMs:   *f.openingMillis,
+		ImbalanceThreshold:  *f.imbalanceThresh,
+		ImbalanceMoveTimeMs: *f.imbalanceMillis,
+		Stride:              *f.evalStride,
 	}
-	if workers > totalFiles {
-		workers = totalFiles
+	if *f.evalCachePath != "" {
+		cache, err := openEvalCache(r.ctx, *f.evalCachePath, r.engine.path, r.engine.options)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "eval cache: %d entries in %s\n", cache.Len(), *f.evalCachePath)
+		r.policy.Cache = cache
 	}
-	if workers == 0 {
-		return
+	var err error
+	if r.tagger, err = newOpeningTagger(*f.openingDB, *f.classify, max(int64(*f.processNum), 1)); err != nil {
+		return err
+	}
+	if r.tagger.db != nil {
+		fmt.Fprintf(os.Stderr, "opening db: %d games in %s\n", len(r.tagger.db), *f.openingDB)
+	}
+	r.evalTimeout = resolveEvalTimeout(*f.evalTimeout, r.policy)
+	r.retry, err = newEvalRetry(*f.evalRetries, *f.evalRetryBackoff, *f.evalRetryFresh)
+	return err
+}
+
+// estimate evaluates a sample of the input with one engine and prints
+// the projected cost of the run (-estimate).
+func (r *graphRun) estimate() error {
+	f := r.flags
+	if *f.coordinatorAddr != "" || *f.watch {
+		return fmt.Errorf("-estimate cannot be combined with -coordinator or -watch")
+	}
+	sample, err := sampleInput(r.walkInput, *f.estimateFiles)
+	if err != nil {
+		return err
+	}
+	session, err := startSession(r.ctx, r.engine.path, r.engine.options, r.evalTimeout, 0, nil)
+	if err != nil {
+		return err
 	}
-	if dir := filepath.Dir(*outputPath); dir != "." {
+	// Cache hits would make the sample look cheaper than the run.
+	est, err := estimate(r.ctx, sample, session, r.policy.Limits(), *f.fileTimeout, *f.compactEvals)
+	_ = session.Close()
+	if err != nil {
+		return err
+	}
+	est.report(os.Stderr, r.totalFiles, min(max(*f.processNum, 1), r.totalFiles), r.policy)
+	return nil
+}
+
+// prepareOutput opens the failure log and collects what the run keeps of
+// the existing output: the records to resume from, checkpoint parts, the
+// engines and the games for -dedup. ok is false when there is nothing to
+// evaluate.
+func (r *graphRun) prepareOutput() (ok bool, err error) {
+	f := r.flags
+	r.failures = newFailureLog(*f.failuresPath, r.truncateFailures)
+	r.workers = max(*f.processNum, 1)
+	if r.workers > r.totalFiles && !*f.watch {
+		r.workers = r.totalFiles
+	}
+	if r.workers == 0 {
+		return false, nil
+	}
+	if dir := filepath.Dir(*f.outputPath); dir != "." {
 		if err := os.MkdirAll(dir, 0o755); err != nil {
-			fatal(err)
+			return false, err
 		}
 	}
 
-	outputTarget := *outputPath
-	processedIDs := make(map[string]struct{})
-	resumeFromExisting := false
-	if *resume {
-		if _, err := os.Stat(*outputPath); err == nil {
-			resumeFromExisting = true
-			outputTarget = *outputPath + ".tmp"
+	r.outputTarget = *f.outputPath
+	r.processedIDs = make(map[string]struct{})
+	if *f.resume && !*f.watch {
+		r.check = newResumeCheck(r.policy, r.engine.drawScore, *f.reprocessInvalid)
+		if _, err := os.Stat(*f.outputPath); err == nil {
+			r.resumeFromExisting = true
+			r.outputTarget = *f.outputPath + ".tmp"
+		}
+	}
+	// Parts left by an interrupted checkpointed run are kept by -resume.
+	if *f.checkpoint > 0 {
+		r.outputTarget = *f.outputPath + ".tmp"
+		if *f.resume {
+			if r.oldParts, err = listParts(*f.outputPath); err != nil {
+				return false, err
+			}
+			if r.resumeFromExisting {
+				if err := r.readResumed(*f.outputPath, nil); err != nil {
+					return false, err
+				}
+			}
+			for _, part := range r.oldParts {
+				if err := r.readResumed(part, nil); err != nil {
+					return false, fmt.Errorf("%s: %w", part, err)
+				}
+			}
+		} else if err := removeParts(*f.outputPath); err != nil {
+			return false, err
 		}
 	}
 
-	jobs := make(chan string)
-	errCh := make(chan error, workers)
-	results := make(chan cute.GameRecord, workers)
-	writeErr := make(chan error, 1)
-	done := make(chan struct{})
-	var processed int64
-	var writeWg sync.WaitGroup
-	writeWg.Add(1)
-	go func() {
-		defer writeWg.Done()
-		writeErr <- cute.WriteParquet(outputTarget, results, int64(workers))
-	}()
-	if resumeFromExisting {
-		if err := readExistingRecords(*outputPath, int64(workers), processedIDs, results); err != nil {
-			fatal(err)
+	r.engines = &engineSet{drawScore: r.engine.drawScore}
+	// Without checkpoints the engines of the output are added once it is
+	// known whether any of its records are kept.
+	if r.resumeFromExisting && *f.checkpoint > 0 {
+		if err := r.engines.addFile(*f.outputPath); err != nil {
+			return false, err
 		}
 	}
-	go func(total int) {
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-done:
-				fmt.Fprintf(os.Stderr, "\rprogress: %d/%d (100%%)\n", total, total)
-				return
-			case <-ticker.C:
-				count := int(atomic.LoadInt64(&processed))
-				percent := 0
-				if total > 0 {
-					percent = int(float64(count) / float64(total) * 100)
-				}
-				fmt.Fprintf(os.Stderr, "\rprogress: %d/%d (%d%%)", count, total, percent)
+	if *f.watch {
+		if err := r.continueWatchedOutput(); err != nil {
+			return false, err
+		}
+	}
+	if *f.dedup {
+		r.dedup = newDedupSet()
+		existing := r.oldParts
+		if _, err := os.Stat(*f.outputPath); err == nil && (*f.resume || *f.watch) {
+			existing = append([]string{*f.outputPath}, existing...)
+		}
+		for _, path := range existing {
+			if err := r.dedup.addFile(path); err != nil {
+				return false, fmt.Errorf("%s: %w", path, err)
 			}
 		}
-	}(totalFiles)
+	}
+	r.jobs = make(chan string)
+	r.work = newWorkSet()
+	r.errs = make(chan error, r.workers)
+	r.results = make(chan cute.GameRecord, r.workers)
+	r.writeErr = make(chan error, 1)
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	return true, nil
+}
 
-	var wg sync.WaitGroup
-	stopCh := make(chan os.Signal, 1)
-	signal.Notify(stopCh, os.Interrupt, syscall.SIGTERM)
-	stopRequested := make(chan struct{})
+// readResumed reads the records of path that the run keeps, sending them
+// to out when not nil.
+func (r *graphRun) readResumed(path string, out chan<- cute.GameRecord) error {
+	keep, err := r.check.file(path)
+	if err != nil {
+		return err
+	}
+	return readExistingRecords(path, int64(r.workers), r.processedIDs, out, keep)
+}
+
+// startWriter starts writing the records sent on r.results to the
+// output: rolling flushes in -watch mode, part files with -checkpoint,
+// else a single file.
+func (r *graphRun) startWriter() {
+	f := r.flags
+	r.writeWg.Add(1)
 	go func() {
-		<-stopCh
-		cancel()
-		close(stopRequested)
+		defer r.writeWg.Done()
+		if *f.watch {
+			// A watch may run for days: stop at the first failed flush
+			// rather than when it is interrupted. -output is left intact.
+			if err := writeRolling(*f.outputPath, r.results, int64(r.workers), *f.watchFlush, r.engines.meta, *f.compactEvals, r.playerIndex, r.status.flushed); err != nil {
+				fatal(err)
+			}
+			r.writeErr <- nil
+			return
+		}
+		if *f.checkpoint > 0 {
+			var err error
+			r.newParts, err = writeCheckpointed(*f.outputPath, r.results, int64(r.workers), *f.checkpoint, len(r.oldParts)+1, r.engines.meta, *f.compactEvals)
+			r.writeErr <- err
+			return
+		}
+		r.writeErr <- cute.WriteParquetWith(r.outputTarget, r.results, cute.ParquetWriteOptions{Parallel: int64(r.workers), Meta: r.engines.meta, CompactEvals: *f.compactEvals, PlayerIndex: r.playerIndex})
 	}()
-	defer signal.Stop(stopCh)
+}
+
+// noteEngine records the engine of a written game with the limits it
+// runs under, so engines that evaluated nothing are not listed.
+func (r *graphRun) noteEngine(info cute.EngineInfo) {
+	info.Policy = r.policy.Limits()
+	r.engines.add(info)
+}
 
-	for i := 0; i < workers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if isStopRequested(stopRequested) {
+// failFile and finishFile settle a file evaluated here or by a -worker of
+// the coordinator; keepPartial writes the partial record of a failed one.
+func (r *graphRun) failFile(path, stage string, err error, elapsed time.Duration) {
+	fmt.Fprintf(os.Stderr, "failed to process %s (%s): %v\n", path, elapsed, err)
+	r.failures.record(path, stage, err)
+	r.status.failed.Add(1)
+	r.status.processed.Add(1)
+	r.work.finish(path)
+}
+
+func (r *graphRun) applyTags(path string, record *cute.GameRecord) {
+	if err := r.tagger.apply(path, record); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: opening tags of %s: %v\n", path, err)
+	}
+	if *r.flags.activity {
+		if err := addActivity(path, record); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: activity of %s: %v\n", path, err)
+		}
+	}
+}
+
+func (r *graphRun) finishFile(path string, record cute.GameRecord, engine cute.EngineInfo, elapsed time.Duration) {
+	f := r.flags
+	r.applyTags(path, &record)
+	if *f.annotatedDir != "" {
+		if err := writeAnnotated(*f.annotatedDir, *f.inputDir, *f.outputEncoding, path, record); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: annotated KIF of %s: %v\n", path, err)
+		}
+	}
+	r.noteEngine(engine)
+	r.results <- record
+	if r.check != nil {
+		r.check.done(record.GameID)
+	}
+	if record.EvalRetries > 0 {
+		fmt.Fprintf(os.Stderr, "processed %s (%s, %d eval retries)\n", path, elapsed, record.EvalRetries)
+		r.status.retries.Add(int64(record.EvalRetries))
+	} else {
+		fmt.Fprintf(os.Stderr, "processed %s (%s)\n", path, elapsed)
+	}
+	r.status.done(record.GameID)
+	r.work.finish(path)
+}
+
+func (r *graphRun) keepPartial(path string, record cute.GameRecord, engine cute.EngineInfo) {
+	if !*r.flags.keepPartial || record.EvalComplete || len(record.MoveEvals) == 0 {
+		return
+	}
+	r.applyTags(path, &record)
+	r.noteEngine(engine)
+	r.results <- record
+	if r.check != nil {
+		r.check.done(record.GameID)
+	}
+	last := record.MoveEvals[len(record.MoveEvals)-1].Ply
+	fmt.Fprintf(os.Stderr, "kept partial record of %s (evaluated up to ply %d of %d)\n", path, last, record.MoveCount)
+}
+
+// resumeFrom is the stored partial record a game is completed from.
+func (r *graphRun) resumeFrom(path string) *cute.GameRecord {
+	if r.check == nil {
+		return nil
+	}
+	return r.check.resumeFrom(filepath.Base(path))
+}
+
+// evaluateJobs is a local worker: it evaluates the queued files with its
+// own engine (and engine B in an A/B run) until the queue is closed.
+func (r *graphRun) evaluateJobs() {
+	f := r.flags
+	if isStopRequested(r.stop) {
+		return
+	}
+	worker, err := newEngineWorker(r.ctx, r.stop, r.errs, &r.status.restarts, r.retry, func() (*cute.Session, error) {
+		return startSession(r.ctx, r.engine.path, r.engine.options, r.evalTimeout, *f.keepalive, r.status.evals.observe)
+	})
+	if err != nil {
+		r.errs <- err
+		return
+	}
+	defer worker.Close()
+	var workerB *engineWorker
+	if r.compare != nil {
+		if workerB, err = r.newCompareWorker(); err != nil {
+			r.errs <- fmt.Errorf("compare engine: %w", err)
+			return
+		}
+		defer workerB.Close()
+	}
+	for path := range r.jobs {
+		fileStart := time.Now()
+		record, err := worker.evaluate(path, r.policy, *f.fileTimeout, r.resumeFrom(path))
+		if errors.Is(err, errWorkerStopped) {
+			return
+		}
+		elapsed := time.Since(fileStart).Round(time.Millisecond)
+		if err != nil {
+			r.failFile(path, failureStage(err), err, elapsed)
+			r.keepPartial(path, record, worker.session.Info())
+			continue
+		}
+		// Both engines must evaluate a game for either file to have it.
+		if workerB != nil {
+			recordB, err := workerB.evaluate(path, r.compare.policy, *f.fileTimeout, nil)
+			if errors.Is(err, errWorkerStopped) {
 				return
 			}
-			session, err := startSession(ctx, enginePath)
+			elapsed = time.Since(fileStart).Round(time.Millisecond)
 			if err != nil {
-				errCh <- err
-				return
+				r.failFile(path, "compare-"+failureStage(err), err, elapsed)
+				continue
 			}
-			defer session.Close()
-			evalCache := make(map[string]cute.Score)
-			for path := range jobs {
-				if isStopRequested(stopRequested) {
-					return
-				}
-				fileStart := time.Now()
-				record, err := cute.BuildGameRecord(ctx, path, session, moveTimeMs, evalCache)
-				if err != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
-					return
-				}
-				if err != nil && isEngineFailure(err) {
-					if isStopRequested(stopRequested) {
-						return
-					}
-					_ = session.Close()
-					session, err = startSession(ctx, enginePath)
-					if err != nil {
-						errCh <- err
-						return
-					}
-					if isStopRequested(stopRequested) {
-						return
-					}
-					record, err = cute.BuildGameRecord(ctx, path, session, moveTimeMs, evalCache)
-					if err != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
-						return
-					}
-				}
-				if isStopRequested(stopRequested) {
-					return
-				}
-				elapsed := time.Since(fileStart).Round(time.Millisecond)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "failed to process %s (%s): %v\n", path, elapsed, err)
-					atomic.AddInt64(&processed, 1)
-					continue
-				}
-				results <- record
-				fmt.Fprintf(os.Stderr, "processed %s (%s)\n", path, elapsed)
-				atomic.AddInt64(&processed, 1)
-			}
-		}()
+			r.finishCompared(path, record, recordB, workerB.session.Info())
+		}
+		r.finishFile(path, record, worker.session.Info(), elapsed)
+	}
+}
+
+// send queues path for the workers; it is false once the run is
+// interrupted.
+func (r *graphRun) send(path string) bool {
+	select {
+	case <-r.stop:
+		return false
+	case r.jobs <- path:
+		r.work.send(path)
+		return true
 	}
+}
 
-	_ = cute.WalkKIF(*inputDir, func(path string) error {
-		if _, ok := processedIDs[filepath.Base(path)]; ok {
-			atomic.AddInt64(&processed, 1)
+// feed queues the input files, then the new ones of a -watch, and closes
+// the queue.
+func (r *graphRun) feed() error {
+	f := r.flags
+	// The watch starts before the walk so files written meanwhile are
+	// not missed; processedIDs drops the ones the walk already queued.
+	var watcher *kifWatcher
+	if *f.watch {
+		var err error
+		if watcher, err = newKIFWatcher(*f.inputDir, *f.watchSettle); err != nil {
+			return err
+		}
+		defer watcher.Close()
+	}
+	_ = r.walkInput(func(path string) error {
+		id := filepath.Base(path)
+		if _, ok := r.processedIDs[id]; ok {
+			r.status.processed.Add(1)
+			r.status.skipped.Add(1)
+			return nil
+		}
+		if *f.watch {
+			r.processedIDs[id] = struct{}{}
+		}
+		if r.dedup != nil && r.dedup.duplicate(path) {
+			r.status.processed.Add(1)
+			r.status.skipped.Add(1)
+			r.work.finish(path)
 			return nil
 		}
-		select {
-		case <-stopRequested:
+		if !r.send(path) {
 			return filepath.SkipAll
-		case jobs <- path:
 		}
 		return nil
 	})
-	close(jobs)
-	wg.Wait()
-	close(done)
-	close(results)
-	writeWg.Wait()
-	if err := <-writeErr; err != nil {
-		fatal(err)
+	if watcher != nil {
+		r.watchNew(watcher)
 	}
-	if resumeFromExisting {
-		if err := os.Rename(outputTarget, *outputPath); err != nil {
-			fatal(err)
+	close(r.jobs)
+	return nil
+}
+
+// finishOutput writes the held records, waits for the writers and puts
+// the output in place: merged with the parts of a checkpointed run,
+// renamed over the resumed output and exported with -format arrow.
+func (r *graphRun) finishOutput() error {
+	f := r.flags
+	// Held records whose games were not evaluated again are kept as they
+	// were; with -checkpoint they are still in the merged files.
+	if r.check != nil && *f.checkpoint <= 0 {
+		leftover := r.check.leftover()
+		for _, record := range leftover {
+			r.results <- record
+		}
+		if r.resumeFromExisting && r.check.reused+len(leftover) > 0 {
+			if err := r.engines.addFile(*f.outputPath); err != nil {
+				return err
+			}
 		}
 	}
-	close(errCh)
-	for err := range errCh {
-		if err != nil {
+	close(r.results)
+	r.writeWg.Wait()
+	if err := <-r.writeErr; err != nil {
+		return err
+	}
+	if err := r.finishCompare(); err != nil {
+		return err
+	}
+	if *f.checkpoint > 0 {
+		var sources []string
+		if r.resumeFromExisting {
+			sources = append(sources, *f.outputPath)
+		}
+		sources = append(sources, r.oldParts...)
+		sources = append(sources, r.newParts...)
+		opts := cute.MergeOptions{Parallel: int64(r.workers), CompactEvals: *f.compactEvals, PlayerIndex: r.playerIndex}
+		if r.check != nil {
+			// Drop the stored copies of games evaluated again.
+			opts.Skip = r.check.skip
+		}
+		if _, err := cute.MergeParquet(r.outputTarget, sources, opts); err != nil {
+			return err
+		}
+	}
+	if r.resumeFromExisting || *f.checkpoint > 0 {
+		if err := os.Rename(r.outputTarget, *f.outputPath); err != nil {
+			return err
+		}
+	}
+	if *f.checkpoint > 0 {
+		if err := removeParts(*f.outputPath); err != nil {
+			return err
+		}
+	}
+	if r.arrowPath != "" {
+		if _, _, err := mergeParquet(r.arrowPath, []string{*f.outputPath}, int64(r.workers), "arrow", false, ""); err != nil {
+			return err
+		}
+		if err := os.Remove(*f.outputPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// summarize prints the counts of the run and, when it was interrupted,
+// lists the files not processed in -remaining.
+func (r *graphRun) summarize() {
+	f := r.flags
+	elapsed := time.Since(r.started).Round(time.Second)
+	processed, failed, skipped := r.status.processed.Load(), r.status.failed.Load(), r.status.skipped.Load()
+	if r.dedup != nil {
+		duplicates := r.dedup.duplicates()
+		fmt.Fprintf(os.Stderr, "elapsed: %s, processed: %d (evaluated %d, failed %d, skipped %d already in the output and %d duplicates)\n", elapsed, processed, processed-failed-skipped, failed, skipped-duplicates, duplicates)
+	} else {
+		fmt.Fprintf(os.Stderr, "elapsed: %s, processed: %d (evaluated %d, failed %d, skipped %d already in the output)\n", elapsed, processed, processed-failed-skipped, failed, skipped)
+	}
+	// A watch is always stopped by an interrupt and picks up where it
+	// left off.
+	if !isStopRequested(r.stop) || *f.watch {
+		return
+	}
+	var remaining []string
+	_ = r.walkInput(func(path string) error {
+		if r.work.remaining(path, r.processedIDs) {
+			remaining = append(remaining, path)
+		}
+		return nil
+	})
+	fmt.Fprintf(os.Stderr, "interrupted: %d files not processed", len(remaining))
+	if *f.remainingPath != "" && len(remaining) > 0 {
+		if err := writeRemaining(*f.remainingPath, remaining, time.Now()); err != nil {
 			fatal(err)
 		}
+		fmt.Fprintf(os.Stderr, ", listed in %s (continue with -resume -include-list %s)", *f.remainingPath, *f.remainingPath)
 	}
-	elapsed := time.Since(startTime).Round(time.Second)
-	fmt.Fprintf(os.Stderr, "elapsed: %s, processed: %d\n", elapsed, atomic.LoadInt64(&processed))
+	fmt.Fprintln(os.Stderr)
 }
 
-func readExistingRecords(path string, parallel int64, ids map[string]struct{}, out chan<- cute.GameRecord) error {
+// readExistingRecords adds the game IDs of a GameRecord parquet file to
+// ids and sends its records to out (each when not nil), leaving out the
+// records keep returns false for.
+func readExistingRecords(path string, parallel int64, ids map[string]struct{}, out chan<- cute.GameRecord, keep func(cute.GameRecord) bool) error {
 	fileReader, err := local.NewLocalFileReader(path)
 	if err != nil {
 		return err
 	}
 	defer fileReader.Close()
 
-	parquetReader, err := reader.NewParquetReader(fileReader, new(cute.GameRecord), parallel)
+	parquetReader, err := cute.NewGameRecordReader(fileReader, parallel)
 	if err != nil {
 		return err
 	}
@@ -249,25 +984,238 @@ func readExistingRecords(path string, parallel int64, ids map[string]struct{}, o
 			return err
 		}
 		for i := range batch {
-			ids[batch[i].GameID] = struct{}{}
-			out <- batch[i]
+			if keep != nil && !keep(batch[i]) {
+				continue
+			}
+			if ids != nil {
+				ids[batch[i].GameID] = struct{}{}
+			}
+			if out != nil {
+				out <- batch[i]
+			}
 		}
 	}
 	return nil
 }
 
-func startSession(ctx context.Context, enginePath string) (*cute.Session, error) {
+func progressPercent(count, total int64) int {
+	if total <= 0 {
+		return 0
+	}
+	return int(float64(count) / float64(total) * 100)
+}
+
+// resolveEvalTimeout returns the -eval-timeout watchdog, where 0 means
+// 10x the longest movetime of policy and at least 30s.
+func resolveEvalTimeout(flagValue time.Duration, policy cute.EvalPolicy) time.Duration {
+	if flagValue != 0 {
+		return flagValue
+	}
+	return max(10*time.Duration(policy.MaxMoveTime())*time.Millisecond, 30*time.Second)
+}
+
+// newEvalRetry returns the retry settings of -eval-retries, nil when
+// retrying is disabled.
+func newEvalRetry(attempts int, backoff time.Duration, fresh bool) (*cute.EvalRetry, error) {
+	if attempts < 0 || backoff < 0 {
+		return nil, errors.New("-eval-retries and -eval-retry-backoff must not be negative")
+	}
+	if attempts == 0 {
+		return nil, nil
+	}
+	return &cute.EvalRetry{Attempts: attempts, Backoff: backoff, MaxBackoff: time.Minute, Fresh: fresh}, nil
+}
+
+// keepaliveTimeout bounds a keepalive ping of an idle engine.
+const keepaliveTimeout = 30 * time.Second
+
+func startSession(ctx context.Context, enginePath string, options map[string]string, evalTimeout, keepalive time.Duration, searchHook func(time.Duration, error)) (*cute.Session, error) {
 	session, err := cute.StartSession(ctx, enginePath)
 	if err != nil {
 		return nil, err
 	}
+	session.SetOptions(options)
+	session.SetSearchTimeout(evalTimeout)
+	session.SetSearchHook(searchHook)
 	if err := session.Handshake(ctx); err != nil {
 		session.Close()
 		return nil, err
 	}
+	if keepalive > 0 {
+		session.StartKeepalive(keepalive, keepaliveTimeout)
+	}
+	return session, nil
+}
+
+// openEvalCache opens the eval cache at path for the engine at enginePath
+// run with options. The engine is started once to read its "id name", so
+// that the cache keys name the engine build as well as its settings.
+func openEvalCache(ctx context.Context, path, enginePath string, options map[string]string) (*cute.EvalCache, error) {
+	session, err := startSession(ctx, enginePath, options, 0, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("eval cache: %w", err)
+	}
+	info := session.Info()
+	_ = session.Close()
+	return cute.OpenEvalCache(path, info.CacheID())
+}
+
+// errWorkerStopped is returned by engineWorker.evaluate when the worker
+// must stop: the run was interrupted or its engine could not be replaced.
+var errWorkerStopped = errors.New("worker stopped")
+
+// engineWorker evaluates files one at a time with its own engine,
+// replacing the engine after a crash or timeout.
+type engineWorker struct {
+	ctx      context.Context
+	stop     <-chan struct{}
+	errs     chan<- error // receives the error of an engine that failed to restart
+	restarts *atomic.Int64
+	start    func() (*cute.Session, error)
+	session  *cute.Session
+	cache    map[string]cute.Score
+	retry    *cute.EvalRetry // nil without -eval-retries
+}
+
+// newEngineWorker starts the worker's engine. retry, when not nil, is
+// copied with a Restart that replaces the worker's engine.
+func newEngineWorker(ctx context.Context, stop <-chan struct{}, errs chan<- error, restarts *atomic.Int64, retry *cute.EvalRetry, start func() (*cute.Session, error)) (*engineWorker, error) {
+	session, err := start()
+	if err != nil {
+		return nil, err
+	}
+	w := &engineWorker{ctx: ctx, stop: stop, errs: errs, restarts: restarts, start: start, session: session, cache: make(map[string]cute.Score)}
+	if retry != nil {
+		r := *retry
+		r.Restart = w.replace
+		w.retry = &r
+	}
+	return w, nil
+}
+
+// replace is the EvalRetry.Restart of the worker's engine.
+func (w *engineWorker) replace(ctx context.Context, failed *cute.Session) (*cute.Session, error) {
+	_ = failed.Close()
+	w.restarts.Add(1)
+	session, err := w.start()
+	if err != nil {
+		return nil, err
+	}
+	w.session = session
 	return session, nil
 }
 
+func (w *engineWorker) Close() error {
+	return w.session.Close()
+}
+
+// restart replaces the engine. It returns false when the worker must stop.
+func (w *engineWorker) restart() bool {
+	if isStopRequested(w.stop) {
+		return false
+	}
+	_ = w.session.Close()
+	w.restarts.Add(1)
+	session, err := w.start()
+	if err != nil {
+		w.errs <- err
+		return false
+	}
+	w.session = session
+	return !isStopRequested(w.stop)
+}
+
+// evaluate builds the record of the file at path, retrying once with a new
+// engine when the engine died during the game. When partial is not nil,
+// only the plies it lacks are evaluated. A failed game may come with a
+// partial record (see cute.BuildGameRecordWith).
+func (w *engineWorker) evaluate(path string, policy cute.EvalPolicy, fileTimeout time.Duration, partial *cute.GameRecord) (cute.GameRecord, error) {
+	if isStopRequested(w.stop) {
+		return cute.GameRecord{}, errWorkerStopped
+	}
+	cache := w.cache
+	if partial != nil {
+		seeds, err := cute.ResumeCache(path, *partial)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: evaluating %s from the start: %v\n", path, err)
+		} else {
+			cache = seeds
+		}
+	}
+	// The keepalive may have found the engine dead while waiting for
+	// this file (e.g. in -watch mode).
+	if health := w.session.Health(); !health.Alive {
+		fmt.Fprintf(os.Stderr, "restarting engine: %v\n", health.Err)
+		if !w.restart() {
+			return cute.GameRecord{}, errWorkerStopped
+		}
+	}
+	policy.Retry = w.retry
+	record, err := buildRecord(w.ctx, path, w.session, policy, cache, fileTimeout)
+	if err != nil && w.ctx.Err() != nil {
+		return cute.GameRecord{}, errWorkerStopped
+	}
+	if err != nil && timeoutStage(err) == "" && isEngineFailure(err) {
+		if !w.restart() {
+			return cute.GameRecord{}, errWorkerStopped
+		}
+		record, err = buildRecord(w.ctx, path, w.session, policy, cache, fileTimeout)
+		if err != nil && w.ctx.Err() != nil {
+			return cute.GameRecord{}, errWorkerStopped
+		}
+	}
+	if timeoutStage(err) != "" {
+		// The engine may still be searching; replace it before the next
+		// file.
+		if !w.restart() {
+			return cute.GameRecord{}, errWorkerStopped
+		}
+	}
+	if isStopRequested(w.stop) {
+		return cute.GameRecord{}, errWorkerStopped
+	}
+	return record, err
+}
+
+// buildRecord evaluates one file, bounded by fileTimeout when positive.
+func buildRecord(ctx context.Context, path string, session *cute.Session, policy cute.EvalPolicy, cache map[string]cute.Score, fileTimeout time.Duration) (cute.GameRecord, error) {
+	if fileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, fileTimeout)
+		defer cancel()
+	}
+	return cute.BuildGameRecordWith(ctx, path, session, policy, cache)
+}
+
+// timeoutStage classifies watchdog errors: "eval-timeout" when a single
+// evaluation hung, "file-timeout" when the whole file exceeded
+// -file-timeout, and "" otherwise. Callers must rule out cancellation of
+// the parent context first.
+func timeoutStage(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, cute.ErrSearchTimeout):
+		return "eval-timeout"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "file-timeout"
+	default:
+		return ""
+	}
+}
+
+// failureStage returns the stage recorded in the failures file.
+func failureStage(err error) string {
+	if stage := timeoutStage(err); stage != "" {
+		return stage
+	}
+	var buildErr *cute.BuildError
+	if errors.As(err, &buildErr) {
+		return buildErr.Stage
+	}
+	return "unknown"
+}
+
 func isEngineFailure(err error) bool {
 	if err == nil {
 		return false
@@ -291,7 +1239,8 @@ func resolveEnginePath(cfgEngine, repoRoot string) (string, error) {
 	if cfgEngine == "" {
 		return "", errors.New("engine path is required")
 	}
-	if filepath.IsAbs(cfgEngine) {
+	// A tcp:// or ssh:// engine is used as is.
+	if filepath.IsAbs(cfgEngine) || cute.IsRemoteEngine(cfgEngine) {
 		return cfgEngine, nil
 	}
 	return filepath.Join(repoRoot, cfgEngine), nil
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// evalBuckets are the upper bounds in seconds of the eval latency
+// histogram, around the usual movetimes.
+var evalBuckets = [...]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// latencyHistogram is a Prometheus histogram of engine search durations.
+type latencyHistogram struct {
+	counts [len(evalBuckets) + 1]atomic.Int64 // per bucket, the last one for +Inf
+	sum    atomic.Int64                       // nanoseconds
+	errors atomic.Int64
+}
+
+func (h *latencyHistogram) observe(elapsed time.Duration, err error) {
+	if err != nil {
+		h.errors.Add(1)
+	}
+	seconds := elapsed.Seconds()
+	i := 0
+	for i < len(evalBuckets) && seconds > evalBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(elapsed))
+}
+
+func (h *latencyHistogram) count() int64 {
+	var n int64
+	for i := range h.counts {
+		n += h.counts[i].Load()
+	}
+	return n
+}
+
+// writeMetrics writes the run's metrics in the Prometheus text format.
+func writeMetrics(w io.Writer, s *runStatus) {
+	metric := func(name, kind, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+	}
+	metric("cute_graph_games", "gauge", "KIF files to process (grows with -watch).", s.total.Load())
+	metric("cute_graph_games_processed_total", "counter", "KIF files processed, including failed ones.", s.processed.Load())
+	metric("cute_graph_games_failed_total", "counter", "KIF files that failed.", s.failed.Load())
+	metric("cute_graph_games_skipped_total", "counter", "KIF files skipped as already in the output.", s.skipped.Load())
+	metric("cute_graph_games_written_total", "counter", "Games flushed to the output by -watch.", s.written.Load())
+	metric("cute_graph_engine_restarts_total", "counter", "Engines restarted after a crash, hang or timeout.", s.restarts.Load())
+	metric("cute_graph_eval_errors_total", "counter", "Engine searches that failed.", s.evals.errors.Load())
+	metric("cute_graph_eval_retries_total", "counter", "Evaluations retried by -eval-retries in the games written.", s.retries.Load())
+
+	const name = "cute_graph_eval_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Duration of engine searches (cache hits are not searched).\n# TYPE %s histogram\n", name, name)
+	var cumulative int64
+	for i := range s.evals.counts {
+		cumulative += s.evals.counts[i].Load()
+		le := "+Inf"
+		if i < len(evalBuckets) {
+			le = strconv.FormatFloat(evalBuckets[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, time.Duration(s.evals.sum.Load()).Seconds(), name, cumulative)
+}
+
+// serveMetrics serves GET /metrics on addr in the background, like
+// serveStatus.
+func serveMetrics(addr string, s *runStatus) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, s)
+	})
+	go http.Serve(ln, mux)
+	return nil
+}
+
+// progressLog appends the status as JSON lines to a file ("-" = stdout).
+type progressLog struct {
+	file *os.File // nil for stdout
+	enc  *json.Encoder
+}
+
+func newProgressLog(path string) (*progressLog, error) {
+	if path == "-" {
+		return &progressLog{enc: json.NewEncoder(os.Stdout)}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &progressLog{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (l *progressLog) write(s *runStatus) {
+	if err := l.enc.Encode(s.snapshot()); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: progress json: %v\n", err)
+	}
+}
+
+func (l *progressLog) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
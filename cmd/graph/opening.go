@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/opening"
+	"cute/pkg/cute/openingdb"
+)
+
+// openingTagger fills the opening tag columns of records from an opening
+// DB and, for games it does not list, the Go classifier. The castle
+// columns always come from the Go classifier, which the DB has no
+// equivalent for.
+type openingTagger struct {
+	db       map[string]openingdb.Record // keyed by game ID without ".kif"
+	classify bool
+}
+
+func newOpeningTagger(dbPath string, classify bool, parallel int64) (*openingTagger, error) {
+	t := &openingTagger{classify: classify}
+	if dbPath != "" {
+		db, err := loadOpeningTags(dbPath, parallel)
+		if err != nil {
+			return nil, fmt.Errorf("opening-db: %w", err)
+		}
+		t.db = db
+	}
+	return t, nil
+}
+
+func loadOpeningTags(path string, parallel int64) (map[string]openingdb.Record, error) {
+	db := make(map[string]openingdb.Record)
+	err := openingdb.Read(path, parallel, func(row openingdb.Record) error {
+		if row.GameID != nil {
+			db[openingdb.NormalizeGameID(*row.GameID)] = row
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// apply tags record, the game read from path. An error leaves the record
+// untagged.
+func (t *openingTagger) apply(path string, record *cute.GameRecord) error {
+	row, listed := t.db[openingdb.NormalizeGameID(record.GameID)]
+	if listed {
+		record.SenteAttackTags = deref(row.SenteAttackTags)
+		record.SenteDefenseTags = deref(row.SenteDefenseTags)
+		record.GoteAttackTags = deref(row.GoteAttackTags)
+		record.GoteDefenseTags = deref(row.GoteDefenseTags)
+	}
+	board, err := cute.LoadBoardFromKIF(path)
+	if err != nil {
+		return err
+	}
+	result, err := opening.Classify(board, opening.Options{})
+	if err != nil {
+		return err
+	}
+	record.SenteCastle, record.SenteCastlePly = result.Sente.Castle, int32(result.Sente.CastlePly)
+	record.GoteCastle, record.GoteCastlePly = result.Gote.Castle, int32(result.Gote.CastlePly)
+	if listed || !t.classify {
+		return nil
+	}
+	record.SenteAttackTags = strings.Join(result.Sente.Attack, ", ")
+	record.SenteDefenseTags = strings.Join(result.Sente.Defense, ", ")
+	record.GoteAttackTags = strings.Join(result.Gote.Attack, ", ")
+	record.GoteDefenseTags = strings.Join(result.Gote.Defense, ", ")
+	return nil
+}
+
+// addActivity stores the per-ply piece activity of the game read from
+// path in record.
+func addActivity(path string, record *cute.GameRecord) error {
+	board, err := cute.LoadBoardFromKIF(path)
+	if err != nil {
+		return err
+	}
+	activity, err := board.Activity()
+	if err != nil {
+		return err
+	}
+	record.ActivityPacked = string(cute.PackActivity(activity))
+	return nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// workSet tracks the input files sent to workers and the ones they
+// finished (evaluated or failed), to list what an interrupted run left.
+type workSet struct {
+	mu       sync.Mutex
+	sent     map[string]bool
+	finished map[string]bool
+}
+
+func newWorkSet() *workSet {
+	return &workSet{sent: make(map[string]bool), finished: make(map[string]bool)}
+}
+
+func (w *workSet) send(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sent[path] = true
+}
+
+func (w *workSet) finish(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.finished[path] = true
+}
+
+// remaining reports whether path is still to be done: sent but abandoned,
+// or never sent and not among the games already in the output (done).
+func (w *workSet) remaining(path string, done map[string]struct{}) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.finished[path] {
+		return false
+	}
+	_, ok := done[filepath.Base(path)]
+	return w.sent[path] || !ok
+}
+
+// writeRemaining writes the manifest of files left by a run stopped at
+// stopped, one path per line, for -include-list of a follow-up run.
+func writeRemaining(path string, files []string, stopped time.Time) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "# %d KIF files not processed by the run interrupted at %s\n", len(files), stopped.Format(time.RFC3339))
+	for _, file := range files {
+		fmt.Fprintln(w, file)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	cute "cute/pkg/cute"
+)
+
+// problemEngineLimits marks records of a file none of whose engines ran
+// with the limits of this run. The metadata is per file, so a file that
+// also lists the current limits is reused as is.
+const problemEngineLimits = "engine_limits"
+
+// resumeCheck validates the records -resume finds in existing output
+// (the parquet-check rules, except the rating range: ratings come from
+// the KIF and evaluating again would not change them). With reprocess,
+// malformed records and records written under other engine limits are
+// held back and their games evaluated again; otherwise they are only
+// reported and reused. Partial records (-keep-partial) are always held
+// back and their games completed, reusing the stored evaluations when
+// they were made under the limits of this run.
+type resumeCheck struct {
+	opts      cute.RecordCheckOptions
+	policy    cute.EvalPolicy
+	drawScore *int
+	reprocess bool
+
+	games    int
+	reused   int
+	partial  int
+	problems map[string]int
+	seen     map[string]bool
+
+	mu sync.Mutex
+	// held are the records held back for re-evaluation by game ID, with
+	// the file they came from.
+	held map[string]heldRecord
+	// redone are the held games evaluated again in this run.
+	redone map[string]bool
+}
+
+type heldRecord struct {
+	source string
+	record cute.GameRecord
+	// resume is set for a partial record whose evaluations are reused.
+	resume bool
+}
+
+func newResumeCheck(policy cute.EvalPolicy, drawScore *int, reprocess bool) *resumeCheck {
+	policy = policy.Limits()
+	return &resumeCheck{
+		opts:      cute.RecordCheckOptions{RatingMin: math.MinInt32, SparseEvals: policy.Stride > 1},
+		policy:    policy,
+		drawScore: drawScore,
+		reprocess: reprocess,
+		problems:  make(map[string]int),
+		seen:      make(map[string]bool),
+		held:      make(map[string]heldRecord),
+		redone:    make(map[string]bool),
+	}
+}
+
+// file returns the function that reports whether a record of the existing
+// file path is reused.
+func (c *resumeCheck) file(path string) (func(cute.GameRecord) bool, error) {
+	meta, err := cute.ReadParquetMeta(path)
+	if err != nil {
+		return nil, err
+	}
+	// Records oriented otherwise cannot be mixed with the ones written now.
+	if err := meta.CheckScorePerspective(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := meta.CheckDrawScore(c.drawScore); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	otherLimits := len(meta.Engines) > 0
+	for _, engine := range meta.Engines {
+		if engine.Policy == c.policy {
+			otherLimits = false
+		}
+	}
+	return func(record cute.GameRecord) bool {
+		c.games++
+		problems := cute.CheckGameRecord(record, c.opts)
+		duplicate := record.GameID != "" && c.seen[record.GameID]
+		c.seen[record.GameID] = true
+		if duplicate {
+			// keep-first, as merge-parquet.
+			c.problems["duplicate_game_id"]++
+			if c.reprocess {
+				return false
+			}
+			c.reused++
+			return true
+		}
+		// Partial records are completed whatever else is wrong with them.
+		if !record.EvalComplete && record.GameID != "" {
+			c.partial++
+			c.mu.Lock()
+			c.held[record.GameID] = heldRecord{source: path, record: record, resume: !otherLimits}
+			c.mu.Unlock()
+			return false
+		}
+		if otherLimits {
+			problems = append(problems, problemEngineLimits)
+		}
+		for _, name := range problems {
+			c.problems[name]++
+		}
+		if len(problems) == 0 || !c.reprocess {
+			c.reused++
+			return true
+		}
+		// A record without game_id matches no KIF and is dropped.
+		if record.GameID != "" {
+			c.mu.Lock()
+			c.held[record.GameID] = heldRecord{source: path, record: record}
+			c.mu.Unlock()
+		}
+		return false
+	}, nil
+}
+
+// done notes that the game id was evaluated in this run.
+func (c *resumeCheck) done(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.held[id]; ok {
+		c.redone[id] = true
+	}
+}
+
+// resumeFrom returns the partial record the game id is completed from, or
+// nil to evaluate it from the start.
+func (c *resumeCheck) resumeFrom(id string) *cute.GameRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	held, ok := c.held[id]
+	if !ok || !held.resume {
+		return nil
+	}
+	record := held.record
+	return &record
+}
+
+// leftover returns the held records whose games were not evaluated again
+// (not in the input, failed or interrupted), to be kept as they were.
+func (c *resumeCheck) leftover() []cute.GameRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var records []cute.GameRecord
+	for id, held := range c.held {
+		if !c.redone[id] {
+			records = append(records, held.record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].GameID < records[j].GameID })
+	return records
+}
+
+// skip reports whether the stored record of a game evaluated again is left
+// out when merging source.
+func (c *resumeCheck) skip(source string, record cute.GameRecord) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	held, ok := c.held[record.GameID]
+	return ok && c.redone[record.GameID] && held.source == source
+}
+
+// report writes what was found in the existing output.
+func (c *resumeCheck) report(out io.Writer) {
+	if c.partial > 0 {
+		fmt.Fprintf(out, "resume: completing %d partial records\n", c.partial)
+	}
+	if len(c.problems) == 0 {
+		return
+	}
+	names := make([]string, 0, len(c.problems))
+	for name := range c.problems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s %d", name, c.problems[name])
+	}
+	fmt.Fprintf(out, "resume: %d stored records: %s\n", c.games, strings.Join(parts, ", "))
+	if c.reprocess {
+		fmt.Fprintf(out, "resume: %d games held back for re-evaluation\n", len(c.held)-c.partial)
+	} else {
+		fmt.Fprintln(out, "resume: reusing them as is; use -reprocess-invalid to evaluate them again")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+)
+
+// parseShard parses "-shard i/N" (0 <= i < N).
+func parseShard(s string) (int, int, error) {
+	left, right, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid -shard %q: want i/N", s)
+	}
+	index, err := strconv.Atoi(strings.TrimSpace(left))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid -shard %q: %w", s, err)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(right))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid -shard %q: %w", s, err)
+	}
+	if count <= 0 || index < 0 || index >= count {
+		return 0, 0, fmt.Errorf("invalid -shard %q: need 0 <= i < N", s)
+	}
+	return index, count, nil
+}
+
+// inShard reports whether path belongs to shard index of count. Files are
+// assigned by a hash of their game ID (the base name), so the split does
+// not depend on the input layout or the machine.
+func inShard(path string, index, count int) bool {
+	h := fnv.New32a()
+	h.Write([]byte(filepath.Base(path)))
+	return int(h.Sum32()%uint32(count)) == index
+}
+
+// shardOutputPath returns output-shard-i.parquet for output.parquet.
+func shardOutputPath(output string, index int) string {
+	ext := filepath.Ext(output)
+	return fmt.Sprintf("%s-shard-%d%s", strings.TrimSuffix(output, ext), index, ext)
+}
+
+// runMergeParquet implements "graph merge-parquet -output out.parquet
+// shard.parquet...".
+func runMergeParquet(args []string) {
+	fs := flag.NewFlagSet("merge-parquet", flag.ExitOnError)
+	outputPath := fs.String("output", "output.parquet", "merged output parquet file")
+	format := fs.String("format", "parquet", "output format: parquet|arrow (Arrow IPC file)")
+	parallel := fs.Int64("parallel", 4, "parquet reader/writer parallelism")
+	compact := fs.Bool("compact-evals", false, "store move_evals packed in a byte array column (much smaller; read transparently by all commands)")
+	dedup := fs.Bool("dedup", false, "also drop games whose players and moves equal a game kept under another game_id")
+	playerIndex := fs.Bool("player-index", false, "also write a player index (output.players.json) that lets stats and user_threshold_stats -users read only those players' games (parquet only)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: graph merge-parquet [-output out.parquet] input.parquet...\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *format != "parquet" && *format != "arrow" {
+		fatal(fmt.Errorf("format must be parquet or arrow"))
+	}
+	for _, in := range inputs {
+		if sameFile(in, *outputPath) {
+			fatal(fmt.Errorf("input %s is also the output", in))
+		}
+	}
+	if dir := filepath.Dir(*outputPath); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			fatal(err)
+		}
+	}
+	index := ""
+	if *playerIndex && *format == "parquet" {
+		index = cute.PlayerIndexPath(*outputPath)
+	}
+	tmp := *outputPath + ".tmp"
+	stats, err := cute.MergeParquet(tmp, inputs, cute.MergeOptions{Format: *format, Parallel: *parallel, CompactEvals: *compact, PlayerIndex: index, Dedup: *dedup})
+	if err != nil {
+		os.Remove(tmp)
+		fatal(err)
+	}
+	if err := os.Rename(tmp, *outputPath); err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "merged %d files: %d games, %d duplicate game_id dropped", len(inputs), stats.Kept, stats.Dropped)
+	if *dedup {
+		fmt.Fprintf(os.Stderr, ", %d duplicate games removed", stats.Deduplicated)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// mergeParquet writes the games of sources to target (as parquet or arrow)
+// in order, keeping the first record of each game_id, and combines their
+// engine metadata, packing the evals with compact and writing the player
+// index to index unless it is empty. It returns the number of games
+// written and dropped.
+func mergeParquet(target string, sources []string, parallel int64, format string, compact bool, index string) (int, int, error) {
+	stats, err := cute.MergeParquet(target, sources, cute.MergeOptions{Format: format, Parallel: parallel, CompactEvals: compact, PlayerIndex: index})
+	return stats.Kept, stats.Dropped, err
+}
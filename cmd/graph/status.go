@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runStatus is the progress of a run, printed to stderr, served as JSON on
+// -status-addr, logged to -progress-json and exported on -metrics-addr.
+type runStatus struct {
+	input, output string
+	watch         bool
+	started       time.Time
+
+	// total grows in -watch mode as new files are queued.
+	total, processed, failed, written atomic.Int64
+	// skipped counts the processed files already in the output.
+	skipped  atomic.Int64
+	restarts atomic.Int64
+	// retries sums the eval_retries of the evaluated games.
+	retries atomic.Int64
+	evals   latencyHistogram
+
+	mu        sync.Mutex
+	lastGame  string
+	lastFlush time.Time
+}
+
+type statusResponse struct {
+	Input         string  `json:"input"`
+	Output        string  `json:"output"`
+	Watch         bool    `json:"watch"`
+	Time          string  `json:"time"`
+	Started       string  `json:"started"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Total         int64   `json:"total"`
+	Processed     int64   `json:"processed"`
+	Failed        int64   `json:"failed"`
+	Skipped       int64   `json:"skipped"`
+	Queued        int64   `json:"queued"`
+	Written       int64   `json:"written"`
+	Restarts      int64   `json:"engine_restarts"`
+	EvalRetries   int64   `json:"eval_retries"`
+	Evals         int64   `json:"evals"`
+	EvalSeconds   float64 `json:"eval_seconds_mean"`
+	LastGame      string  `json:"last_game,omitempty"`
+	LastFlush     string  `json:"last_flush,omitempty"`
+}
+
+func (s *runStatus) done(gameID string) {
+	s.processed.Add(1)
+	s.mu.Lock()
+	s.lastGame = gameID
+	s.mu.Unlock()
+}
+
+func (s *runStatus) flushed(games int) {
+	s.written.Add(int64(games))
+	s.mu.Lock()
+	s.lastFlush = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *runStatus) snapshot() statusResponse {
+	r := statusResponse{
+		Input:         s.input,
+		Output:        s.output,
+		Watch:         s.watch,
+		Time:          time.Now().Format(time.RFC3339),
+		Started:       s.started.Format(time.RFC3339),
+		UptimeSeconds: time.Since(s.started).Round(time.Second).Seconds(),
+		Total:         s.total.Load(),
+		Processed:     s.processed.Load(),
+		Failed:        s.failed.Load(),
+		Skipped:       s.skipped.Load(),
+		Written:       s.written.Load(),
+		Restarts:      s.restarts.Load(),
+		EvalRetries:   s.retries.Load(),
+		Evals:         s.evals.count(),
+	}
+	r.Queued = r.Total - r.Processed
+	if r.Evals > 0 {
+		r.EvalSeconds = time.Duration(s.evals.sum.Load()).Seconds() / float64(r.Evals)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.LastGame = s.lastGame
+	if !s.lastFlush.IsZero() {
+		r.LastFlush = s.lastFlush.Format(time.RFC3339)
+	}
+	return r
+}
+
+// serveStatus serves GET /status on addr in the background. Listening
+// happens before it returns so a busy port fails the run early.
+func serveStatus(addr string, s *runStatus) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(s.snapshot())
+	})
+	go http.Serve(ln, mux)
+	return nil
+}
+
+// startServers sets up the status of the run, serves it on -status-addr
+// and -metrics-addr and opens the -progress-json log.
+func (r *graphRun) startServers() error {
+	f := r.flags
+	r.status = &runStatus{input: *f.inputDir, output: *f.outputPath, watch: *f.watch, started: r.started}
+	r.status.total.Store(int64(r.totalFiles))
+	if *f.statusAddr != "" {
+		if err := serveStatus(*f.statusAddr, r.status); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "status: http://%s/status\n", *f.statusAddr)
+	}
+	if *f.metricsAddr != "" {
+		if err := serveMetrics(*f.metricsAddr, r.status); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "metrics: http://%s/metrics\n", *f.metricsAddr)
+	}
+	if *f.progressJSON != "" {
+		if *f.progressInterval <= 0 {
+			return fmt.Errorf("-progress-interval must be positive")
+		}
+		var err error
+		if r.progress, err = newProgressLog(*f.progressJSON); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// showProgress prints the progress to stderr every second and writes it
+// to the -progress-json log until r.done is closed; r.progressDone is
+// closed after the last line.
+func (r *graphRun) showProgress() {
+	r.progressDone = make(chan struct{})
+	go func() {
+		defer close(r.progressDone)
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		// progressTick is nil (never fires) without -progress-json.
+		var progressTick <-chan time.Time
+		if r.progress != nil {
+			t := time.NewTicker(*r.flags.progressInterval)
+			defer t.Stop()
+			progressTick = t.C
+		}
+		for {
+			select {
+			case <-r.done:
+				// An interrupted run stops short of the total.
+				count, total := r.status.processed.Load(), r.status.total.Load()
+				if !isStopRequested(r.stop) {
+					count = total
+				}
+				fmt.Fprintf(os.Stderr, "\rprogress: %d/%d (%d%%)\n", count, total, progressPercent(count, total))
+				if r.progress != nil {
+					r.progress.write(r.status)
+				}
+				return
+			case <-progressTick:
+				r.progress.write(r.status)
+			case <-ticker.C:
+				count, total := r.status.processed.Load(), r.status.total.Load()
+				fmt.Fprintf(os.Stderr, "\rprogress: %d/%d (%d%%)", count, total, progressPercent(count, total))
+			}
+		}
+	}()
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	cute "cute/pkg/cute"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// In -watch mode graph keeps running after the existing files: files that
+// appear under -input (e.g. from an archive sync) are evaluated as they
+// arrive, and the new games are merged into -output every -watch-flush.
+
+// kifWatcher reports files created under a directory tree once they have
+// not changed for settle, so files still being copied are not read
+// half-written.
+type kifWatcher struct {
+	root    string
+	settle  time.Duration
+	w       *fsnotify.Watcher
+	pending map[string]time.Time
+}
+
+func newKIFWatcher(root string, settle time.Duration) (*kifWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	k := &kifWatcher{root: root, settle: settle, w: w, pending: make(map[string]time.Time)}
+	if err := k.addTree(root, false); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *kifWatcher) Close() error {
+	return k.w.Close()
+}
+
+// addTree watches dir and its subdirectories. With queue, the files found
+// are queued too: a directory that appears while watching may already
+// hold files written before its watch was added.
+func (k *kifWatcher) addTree(dir string, queue bool) error {
+	now := time.Now()
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return k.w.Add(path)
+		}
+		if queue {
+			k.pending[path] = now
+		}
+		return nil
+	})
+}
+
+// run calls fn for the KIF files (including those inside archives) of
+// every settled file, until stop is closed or fn returns false. A
+// notification overflow rescans the whole tree; fn is expected to skip
+// files it has seen.
+func (k *kifWatcher) run(stop <-chan struct{}, fn func(path string) bool) {
+	tick := time.NewTicker(max(k.settle/4, 100*time.Millisecond))
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case ev, ok := <-k.w.Events:
+			if !ok {
+				return
+			}
+			switch {
+			case ev.Has(fsnotify.Create):
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					if err := k.addTree(ev.Name, true); err != nil {
+						fmt.Fprintf(os.Stderr, "warning: watch %s: %v\n", ev.Name, err)
+					}
+					continue
+				}
+				k.pending[ev.Name] = time.Now()
+			case ev.Has(fsnotify.Write):
+				k.pending[ev.Name] = time.Now()
+			case ev.Has(fsnotify.Remove), ev.Has(fsnotify.Rename):
+				delete(k.pending, ev.Name)
+			}
+		case err, ok := <-k.w.Errors:
+			if !ok {
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				fmt.Fprintf(os.Stderr, "warning: watch events overflowed; rescanning %s\n", k.root)
+				err = k.addTree(k.root, true)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: watch %s: %v\n", k.root, err)
+			}
+		case now := <-tick.C:
+			var ready []string
+			for path, changed := range k.pending {
+				if now.Sub(changed) >= k.settle {
+					ready = append(ready, path)
+				}
+			}
+			sort.Strings(ready)
+			for _, path := range ready {
+				delete(k.pending, path)
+				stopped := false
+				err := cute.WalkKIF(path, func(kif string) error {
+					if !fn(kif) {
+						stopped = true
+						return filepath.SkipAll
+					}
+					return nil
+				})
+				if err != nil && !errors.Is(err, fs.ErrNotExist) {
+					fmt.Fprintf(os.Stderr, "warning: watch %s: %v\n", path, err)
+				}
+				if stopped {
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeRolling appends records to output: every flush interval the games
+// received since the previous flush are written to a part file and merged
+// into output, which is replaced by rename. Readers can open output at any
+// time while the watch runs. flushed is called with the number of games
+// added by each flush; compact packs the evals (-compact-evals) and the
+// player index is rewritten at index unless it is empty.
+func writeRolling(output string, records <-chan cute.GameRecord, parallel int64, every time.Duration, meta func() cute.ParquetMeta, compact bool, index string, flushed func(games int)) error {
+	var batch []cute.GameRecord
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		chunk := make(chan cute.GameRecord, len(batch))
+		for _, record := range batch {
+			chunk <- record
+		}
+		close(chunk)
+		parts, err := writeCheckpointed(output, chunk, parallel, len(batch), 1, meta, compact)
+		if err != nil {
+			return err
+		}
+		sources := parts
+		if _, err := os.Stat(output); err == nil {
+			sources = append([]string{output}, parts...)
+		}
+		tmp := output + ".tmp"
+		kept, _, err := mergeParquet(tmp, sources, parallel, "parquet", compact, index)
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, output); err != nil {
+			return err
+		}
+		if err := removeParts(output); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "flushed %d games to %s (%d total)\n", len(batch), output, kept)
+		flushed(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case record, ok := <-records:
+			if !ok {
+				return flush()
+			}
+			batch = append(batch, record)
+		case <-tick.C:
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkWatchFlags checks the flags of a -watch run; arrow is whether the
+// output is exported with -format arrow.
+func checkWatchFlags(f graphFlags, arrow bool) error {
+	if !*f.watch {
+		return nil
+	}
+	if arrow || *f.retryFailures != "" || *f.checkpoint > 0 || *f.reprocessInvalid {
+		return fmt.Errorf("-watch cannot be combined with -format arrow, -retry-failures, -checkpoint or -reprocess-invalid")
+	}
+	if *f.watchFlush <= 0 {
+		return fmt.Errorf("-watch-flush must be positive")
+	}
+	return nil
+}
+
+// continueWatchedOutput takes up the games already in -output.
+//
+// A watch always continues the existing output. Games of a part left by
+// an interrupted flush are evaluated again.
+func (r *graphRun) continueWatchedOutput() error {
+	output := *r.flags.outputPath
+	if err := removeParts(output); err != nil {
+		return err
+	}
+	if _, err := os.Stat(output); err != nil {
+		return nil
+	}
+	if err := readExistingRecords(output, int64(r.workers), r.processedIDs, nil, nil); err != nil {
+		return err
+	}
+	return r.engines.addFile(output)
+}
+
+// watchNew queues the new files that watcher reports until the run is
+// interrupted.
+func (r *graphRun) watchNew(watcher *kifWatcher) {
+	fmt.Fprintf(os.Stderr, "watching %s for new KIF files (Ctrl-C to stop)\n", *r.flags.inputDir)
+	watcher.run(r.stop, func(path string) bool {
+		id := filepath.Base(path)
+		if _, ok := r.processedIDs[id]; ok || !r.keep(path) {
+			return true
+		}
+		r.processedIDs[id] = struct{}{}
+		r.status.total.Add(1)
+		if r.dedup != nil && r.dedup.duplicate(path) {
+			r.status.processed.Add(1)
+			r.status.skipped.Add(1)
+			return true
+		}
+		return r.send(path)
+	})
+}
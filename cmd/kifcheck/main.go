@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	cute "cute/pkg/cute"
+)
+
+// kifcheck classifies every KIF under -input (ok, foul-end, false-mate,
+// illegal-move, parse-error, encoding-error) without an engine, writes a
+// CSV report and optionally moves the bad files aside, to clean a corpus
+// before a long graph run.
+func main() {
+	inputDir := flag.String("input", "test_kif", "input directory for KIF files")
+	outputPath := flag.String("output", "", "CSV report file (default stdout)")
+	moveTo := flag.String("move-to", "", "move the files with a -bad status to this directory, keeping their place under -input (empty=report only)")
+	badArg := flag.String("bad", strings.Join([]string{cute.KIFEncodingError, cute.KIFParseError, cute.KIFIllegalMove}, ","), "comma-separated statuses counted as bad: encoding-error, parse-error, illegal-move, false-mate, foul-end")
+	workers := flag.Int("workers", runtime.NumCPU(), "number of files checked in parallel")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("kifcheck", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	bad := make(map[string]bool)
+	for _, status := range strings.Split(*badArg, ",") {
+		switch status = strings.TrimSpace(status); status {
+		case "":
+		case cute.KIFEncodingError, cute.KIFParseError, cute.KIFIllegalMove, cute.KIFFalseMate, cute.KIFFoulEnd:
+			bad[status] = true
+		default:
+			fatal(fmt.Errorf("unknown status %q in -bad", status))
+		}
+	}
+
+	results := checkAll(*inputDir, max(*workers, 1))
+	if len(results) == 0 {
+		fmt.Fprintf(os.Stderr, "no KIF files in %s\n", *inputDir)
+		return
+	}
+
+	counts := make(map[string]int)
+	badFiles, moved := 0, 0
+	for i := range results {
+		r := &results[i]
+		counts[r.check.Status]++
+		if !bad[r.check.Status] {
+			continue
+		}
+		badFiles++
+		if *moveTo == "" {
+			continue
+		}
+		dest, err := moveAside(*inputDir, *moveTo, r.path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot move %s: %v\n", r.path, err)
+			continue
+		}
+		r.movedTo = dest
+		moved++
+	}
+
+	out := io.Writer(os.Stdout)
+	if *outputPath != "" {
+		f, err := os.Create(*outputPath)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := writeReport(out, results); err != nil {
+		fatal(err)
+	}
+
+	statuses := []string{cute.KIFOK, cute.KIFFoulEnd, cute.KIFFalseMate, cute.KIFIllegalMove, cute.KIFParseError, cute.KIFEncodingError}
+	parts := make([]string, len(statuses))
+	for i, status := range statuses {
+		parts[i] = fmt.Sprintf("%s %d", status, counts[status])
+	}
+	fmt.Fprintf(os.Stderr, "%d files: %s\n", len(results), strings.Join(parts, ", "))
+	if *moveTo != "" {
+		fmt.Fprintf(os.Stderr, "moved %d of %d bad files to %s\n", moved, badFiles, *moveTo)
+	}
+	if badFiles > moved {
+		os.Exit(1)
+	}
+}
+
+type result struct {
+	path    string
+	check   cute.KIFCheck
+	movedTo string
+}
+
+// checkAll checks the KIF files under dir with workers goroutines and
+// returns the results sorted by path.
+func checkAll(dir string, workers int) []result {
+	paths := make(chan string)
+	var mu sync.Mutex
+	var results []result
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				r := result{path: path, check: cute.CheckKIF(path)}
+				mu.Lock()
+				results = append(results, r)
+				mu.Unlock()
+			}
+		}()
+	}
+	err := cute.WalkKIF(dir, func(path string) error {
+		paths <- path
+		return nil
+	})
+	close(paths)
+	wg.Wait()
+	if err != nil {
+		fatal(err)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].path < results[j].path })
+	return results
+}
+
+// moveAside moves path to its place under dir as it is under inputDir and
+// returns the new path. Games inside archives cannot be moved.
+func moveAside(inputDir, dir, path string) (string, error) {
+	if strings.Contains(path, "!/") {
+		return "", fmt.Errorf("inside an archive")
+	}
+	rel, err := filepath.Rel(inputDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = filepath.Base(path)
+	}
+	dest := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", err
+	}
+	return dest, os.Rename(path, dest)
+}
+
+func writeReport(out io.Writer, results []result) error {
+	w := csv.NewWriter(out)
+	if err := w.Write([]string{"path", "status", "moves", "ply", "error", "moved_to"}); err != nil {
+		return err
+	}
+	for _, r := range results {
+		errText := ""
+		if r.check.Err != nil {
+			errText = r.check.Err.Error()
+		}
+		ply := ""
+		if r.check.Ply > 0 {
+			ply = strconv.Itoa(r.check.Ply)
+		}
+		if err := w.Write([]string{r.path, r.check.Status, strconv.Itoa(r.check.Moves), ply, errText, r.movedTo}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
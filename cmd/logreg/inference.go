@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"cute/pkg/cute/regress"
+)
+
+// coefInference holds Wald statistics for one coefficient.
+type coefInference struct {
+	stdErr float64
+	z      float64
+	p      float64 // two-sided p-value
+}
+
+// waldInference computes standard errors, Wald z and two-sided p-values from
+// the observed Fisher information at weights:
+//
+//	I = sum_i p_i (1 - p_i) x_i x_i^T  (+ N·λ·scale_j² on the non-intercept diagonal)
+//	Var(w) ≈ I^-1,  se_j = sqrt((I^-1)_jj),  z_j = w_j / se_j
+//
+// Returns ok=false when the information matrix is singular (e.g. a feature
+// is constant or perfectly collinear with others). scales are the feature
+// scales the penalty applied to (nil for the original features).
+func waldInference(samples []sample, weights []float64, l2 float64, scales []float64) ([]coefInference, bool) {
+	info := fisherInformation(samples, weights, l2, scales)
+	cov, ok := regress.InvertMatrix(info)
+	if !ok {
+		return nil, false
+	}
+	out := make([]coefInference, len(weights))
+	for j, w := range weights {
+		se := math.Sqrt(cov[j][j])
+		z := w / se
+		out[j] = coefInference{stdErr: se, z: z, p: math.Erfc(math.Abs(z) / math.Sqrt2)}
+	}
+	return out, true
+}
+
+func fisherInformation(samples []sample, weights []float64, l2 float64, scales []float64) [][]float64 {
+	n := len(weights)
+	info := make([][]float64, n)
+	for i := range info {
+		info[i] = make([]float64, n)
+	}
+	for _, s := range samples {
+		p := sigmoid(dot(weights, s.x))
+		v := p * (1 - p)
+		for i := 0; i < n; i++ {
+			for j := i; j < n; j++ {
+				info[i][j] += v * s.x[i] * s.x[j]
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j < i; j++ {
+			info[i][j] = info[j][i]
+		}
+	}
+	// The fit minimizes the average loss plus (λ/2)|w|², so in sum-of-loss
+	// units the penalty contributes N·λ to the curvature. A penalty on
+	// standardized coefficients b_j = w_j·scale_j contributes N·λ·scale_j².
+	if l2 > 0 {
+		for j := 1; j < n; j++ {
+			penalty := float64(len(samples)) * l2
+			if scales != nil {
+				penalty *= scales[j] * scales[j]
+			}
+			info[j][j] += penalty
+		}
+	}
+	return info
+}
+
+func printInference(labels []string, weights []float64, inf []coefInference) {
+	fmt.Println("coefficient inference (Wald, observed Fisher information):")
+	fmt.Println("  feature,coef,std_err,z,p_value")
+	for j, w := range weights {
+		fmt.Printf("  %s,%.6f,%.6f,%.3f,%.4g\n", labels[j], w, inf[j].stdErr, inf[j].z, inf[j].p)
+	}
+}
+
+// printCollinearity prints the condition number and variance inflation
+// factors of the feature columns of samples, and warns when collinearity
+// makes some coefficients unstable (e.g. an uncentered interaction term).
+func printCollinearity(labels []string, samples []sample) {
+	rows := make([][]float64, len(samples))
+	for i, s := range samples {
+		rows[i] = s.x
+	}
+	c := regress.Diagnose(rows)
+	fmt.Println("collinearity:")
+	fmt.Printf("  condition-number: %.2f\n", c.ConditionNumber)
+	fmt.Println("  feature,vif")
+	var unstable []string
+	for j, vif := range c.VIF {
+		fmt.Printf("  %s,%.3f\n", labels[j+1], vif)
+		if vif > 10 {
+			unstable = append(unstable, labels[j+1])
+		}
+	}
+	if c.ConditionNumber > 30 || len(unstable) > 0 {
+		fmt.Fprintf(os.Stderr, "warning: collinear features (condition number %.1f, VIF > 10: %s); their coefficients and standard errors are unstable\n", c.ConditionNumber, strings.Join(unstable, ", "))
+	}
+}
@@ -12,9 +12,9 @@ package main
 //   (2) early advantage (first_crossed)
 //   (3) whether absolute skill changes the "convert advantage into wins" effect
 //
-// Features:
+// Default features (see -features and pkg/cute/regress/features.go for the full list):
 //   intercept         : baseline sente win tendency (at mean rating, no first-cross)
-//   rating_diff_scaled: (sente_rating - gote_rating) / ratingScale
+//   rating_diff       : (sente_rating - gote_rating) / ratingScale
 //   first_crossed     : 1 if sente first reached the eval threshold, 0 if gote did
 //   rating_x_first    : centered_rating * first_crossed (interaction term)
 //                        where centered_rating = (sente_rating - mean_rating) / ratingScale
@@ -30,21 +30,19 @@ import (
 	"fmt"
 	"math"
 	"os"
-	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
 
 	cute "cute/pkg/cute"
-
-	"github.com/xitongsys/parquet-go-source/local"
-	"github.com/xitongsys/parquet-go/reader"
+	"cute/pkg/cute/regress"
 )
 
 type sample struct {
-	x []float64
-	y float64
+	x      []float64
+	y      float64
+	record *cute.GameRecord // the game, for -predict-out
 }
 
 type counts struct {
@@ -53,16 +51,52 @@ type counts struct {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "predict" {
+		runPredict(os.Args[2:])
+		return
+	}
 	input := flag.String("input", "output.parquet", "input parquet file")
-	threshold := flag.Int("threshold", 300, "eval threshold for first crossing")
-	iter := flag.Int("iter", 300, "gradient descent iterations")
+	threshold := flag.Int("threshold", 300, "eval threshold for first crossing (a win percentage, e.g. 80, with -win-prob)")
+	winProb := flag.Bool("win-prob", false, "work in win-probability space: -threshold is the win probability in percent of the side ahead and eval features (eval_at_ply_N, max_eval, min_eval, eval_volatility) are sente win probabilities instead of 100cp units")
+	winProbScaling := flag.Float64("win-prob-scaling", cute.DefaultWinProbScaling, "cp scale of the logistic cp to win probability conversion of -win-prob")
+	mateCrossing := flag.Bool("mate-crossing", true, "count a mate score as a crossing for the mating side whatever the threshold (false skips mate scores)")
+	crossingStability := flag.Int("crossing-stability", 0, "only count a crossing that holds for this many following evals")
+	iter := flag.Int("iter", 300, "gradient descent iterations (epochs with -batch-size)")
 	lr := flag.Float64("lr", 0.05, "learning rate")
+	l2 := flag.Float64("l2", 0, "L2 regularization strength (lambda, 0=disabled)")
+	tol := flag.Float64("tol", 1e-6, "stop when gradient norm falls below this value (0=run all iterations)")
+	batchSize := flag.Int("batch-size", 0, "fit with shuffled mini-batches of this many games, one update per batch, for large data (0=full-batch gradient descent)")
+	optimizer := flag.String("optimizer", "adam", "mini-batch update rule with -batch-size: sgd or adam")
+	standardize := flag.Bool("standardize", true, "fit on features standardized to mean 0 and standard deviation 1, which speeds up gradient descent, and report coefficients on the original scale (-l2 then penalizes the standardized coefficients)")
 	ratingScale := flag.Float64("rating-scale", 100, "scale factor for rating diff")
 	maxAbsDiff := flag.Int("max-abs-diff", 0, "max absolute rating diff (0=disabled)")
 	parallel := flag.Int64("parallel", 4, "parquet read parallelism")
 	workers := flag.Int("workers", runtime.GOMAXPROCS(0), "number of gradient workers")
+	cvFolds := flag.Int("cv", 0, "k-fold cross-validation folds (0=disabled)")
+	testFraction := flag.Float64("test-fraction", 0, "hold out this share of the games (0-1) as a test set: the model is fit on the rest and train vs test metrics are reported (0=disabled)")
+	seed := flag.Int64("seed", 1, "random seed for the train/test split, fold assignment and -batch-size shuffling")
+	featuresArg := flag.String("features", "rating_diff,first_crossed,rating_x_first", "comma-separated feature list (e.g. rating_diff,first_crossed,crossing_ply,eval_at_ply_30)")
 	ratingsArg := flag.String("ratings", "300,600,900,1200,1500,1800,2100,2400", "comma-separated rating values")
+	predictOut := flag.String("predict-out", "", "write the predicted sente win probability of every game, with its result, to this CSV file")
+	saveModel := flag.String("save-model", "", "write the fitted model (weights, feature list, crossing and scaling settings) to this JSON file, for -load-model and logreg predict")
+	loadModelPath := flag.String("load-model", "", "start the fit from the weights of a model written by -save-model; its features, threshold, win-prob, crossing and rating-scale settings are used")
+	gameFilter := cute.AddGameFilterFlags(flag.CommandLine)
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
 	flag.Parse()
+	if _, err := cute.LoadCommandConfig("logreg", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+	var warmStart *savedModel
+	if *loadModelPath != "" {
+		model, _, err := loadModel(*loadModelPath)
+		if err != nil {
+			fatal(err)
+		}
+		if err := adoptModelFlags(flag.CommandLine, model); err != nil {
+			fatal(err)
+		}
+		warmStart = &model
+	}
 
 	// Basic validation to avoid invalid model settings.
 
@@ -72,63 +106,209 @@ func main() {
 	if *lr <= 0 {
 		fatal(fmt.Errorf("lr must be > 0"))
 	}
+	if *l2 < 0 {
+		fatal(fmt.Errorf("l2 must be >= 0"))
+	}
+	if *tol < 0 {
+		fatal(fmt.Errorf("tol must be >= 0"))
+	}
 	if *ratingScale <= 0 {
 		fatal(fmt.Errorf("rating-scale must be > 0"))
 	}
 	if *threshold <= 0 {
 		fatal(fmt.Errorf("threshold must be > 0"))
 	}
+	crossingThreshold, scaling := *threshold, 0.0
+	if *winProb {
+		if *threshold <= 50 || *threshold >= 100 {
+			fatal(fmt.Errorf("with -win-prob, threshold must be between 50 and 100 (exclusive)"))
+		}
+		if *winProbScaling <= 0 {
+			fatal(fmt.Errorf("win-prob-scaling must be > 0"))
+		}
+		crossingThreshold, scaling = cute.WinProbToCP(float64(*threshold)/100, *winProbScaling), *winProbScaling
+	}
 	if *workers <= 0 {
 		fatal(fmt.Errorf("workers must be > 0"))
 	}
+	if *batchSize < 0 {
+		fatal(fmt.Errorf("batch-size must be >= 0"))
+	}
+	if *optimizer != "sgd" && *optimizer != "adam" {
+		fatal(fmt.Errorf("optimizer must be sgd or adam"))
+	}
+	if *cvFolds < 0 || *cvFolds == 1 {
+		fatal(fmt.Errorf("cv must be 0 or >= 2"))
+	}
+	if *testFraction < 0 || *testFraction >= 1 {
+		fatal(fmt.Errorf("test-fraction must be >= 0 and < 1"))
+	}
 	ratings, err := parseIntList(*ratingsArg)
 	if err != nil {
 		fatal(err)
 	}
-	records, err := readParquet(*input, *parallel)
+	features, err := regress.ParseFeatures(*featuresArg)
 	if err != nil {
 		fatal(err)
 	}
+	if err := gameFilter.Validate(); err != nil {
+		fatal(err)
+	}
+	if err := cute.CheckParquetPerspective(*input); err != nil {
+		fatal(err)
+	}
+	records, err := cute.ReadGameRecords(*input, *parallel)
+	if err != nil {
+		fatal(err)
+	}
+	if gameFilter.Active() {
+		total := len(records)
+		records = gameFilter.Filter(records)
+		fmt.Fprintf(os.Stderr, "move count/phase filter: %d/%d games match\n", len(records), total)
+	}
 
 	// Build one sample per game (sente perspective) and fit a single model.
 	// We use batch gradient descent (simple, reliable for a small number of features).
-	samples, cts, meanRating := buildSamples(records, *threshold, *ratingScale, *maxAbsDiff)
+	samples, cts, meanRating := buildSamples(records, features, cute.CrossingOptions{
+		Threshold:         crossingThreshold,
+		MateCountsAsCross: *mateCrossing,
+		RequireStability:  *crossingStability,
+	}, *ratingScale, *maxAbsDiff, scaling)
 	if len(samples) == 0 {
 		fatal(fmt.Errorf("no samples available after filtering (total=%d skipped=%d)", cts.total, cts.skipped))
 	}
-	weights, loss := fitLogReg(samples, *iter, *lr, *workers)
+	// With a holdout, everything below (fit, inference, cross-validation)
+	// uses the training split only.
+	var test []sample
+	if *testFraction > 0 {
+		samples, test = splitSamples(samples, *testFraction, *seed)
+		if len(samples) == 0 || len(test) == 0 {
+			fatal(fmt.Errorf("test-fraction %g leaves an empty train or test split (%d games)", *testFraction, len(samples)+len(test)))
+		}
+	}
+	opts := fitOptions{iter: *iter, lr: *lr, l2: *l2, tol: *tol, workers: *workers, batchSize: *batchSize, optimizer: *optimizer, seed: *seed, standardize: *standardize}
+	// Only the main fit starts from a loaded model; cross-validation
+	// folds start from zero, so that no held-out game has been seen.
+	mainOpts := opts
+	if warmStart != nil {
+		mainOpts.init = warmStart.Weights
+	}
+	fit := fitLogReg(samples, mainOpts)
+	weights := fit.weights
 
 	fmt.Println("data:")
 	fmt.Printf("  input: %s\n", *input)
-	fmt.Printf("  threshold: %d\n", *threshold)
+	if *winProb {
+		fmt.Printf("  threshold: %d%% win probability (%dcp, scaling %g)\n", *threshold, crossingThreshold, *winProbScaling)
+	} else {
+		fmt.Printf("  threshold: %d\n", *threshold)
+	}
 	fmt.Printf("  rating-scale: %.0f\n", *ratingScale)
-	fmt.Printf("  games: %d (skipped=%d)\n", len(samples), cts.skipped)
+	if test != nil {
+		fmt.Printf("  games: %d (train=%d test=%d, skipped=%d)\n", len(samples)+len(test), len(samples), len(test), cts.skipped)
+	} else {
+		fmt.Printf("  games: %d (skipped=%d)\n", len(samples), cts.skipped)
+	}
 	fmt.Printf("  max-abs-diff: %d\n", *maxAbsDiff)
 	fmt.Printf("  mean-sente-rating: %.0f\n", meanRating)
 	fmt.Printf("  workers: %d\n", *workers)
 	fmt.Println("model:")
-	fmt.Println("  features: intercept, rating_diff_scaled, first_crossed, rating_x_first")
-	fmt.Printf("  final-loss: %.6f\n", loss)
+	fmt.Printf("  features: %s\n", strings.Join(regress.Labels(features), ", "))
+	fmt.Printf("  l2: %g\n", *l2)
+	if warmStart != nil {
+		fmt.Printf("  warm-start: %s (fit on %s, %d games, loss %.6f)\n", *loadModelPath, warmStart.Input, warmStart.Games, warmStart.Loss)
+	}
+	if opts.batchSize > 0 && opts.batchSize < len(samples) {
+		fmt.Printf("  optimizer: %s (batch-size %d, lr %g)\n", *optimizer, *batchSize, *lr)
+		fmt.Printf("  epochs: %d/%d (converged=%t, tol=%g)\n", fit.iterations, *iter, fit.converged, *tol)
+	} else {
+		fmt.Printf("  iterations: %d/%d (converged=%t, tol=%g)\n", fit.iterations, *iter, fit.converged, *tol)
+	}
+	fmt.Printf("  final-loss: %.6f\n", fit.loss)
+	printCollinearity(regress.Labels(features), samples)
 
-	printSection("all", weights, *ratingScale, meanRating, ratings)
-}
+	inference, ok := waldInference(samples, weights, *l2, fit.scales)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "warning: Fisher information is singular; standard errors unavailable (constant or collinear features?)")
+	}
+	printSection("all", features, weights, inference, *ratingScale, meanRating, ratings)
+	if test != nil {
+		printHoldout(*testFraction, predictAll(weights, samples), predictAll(weights, test))
+	}
+	if *predictOut != "" {
+		if err := writePredictions(*predictOut, weights, samples, test); err != nil {
+			fatal(err)
+		}
+	}
 
-func buildSamples(records []cute.GameRecord, threshold int, ratingScale float64, maxAbsDiff int) ([]sample, counts, float64) {
-	// First pass: filter games and compute mean sente rating for centering.
-	type accepted struct {
-		senteRating     float64
-		goteRating      float64
-		senteFirstCross bool
-		senteWin        bool
+	if *saveModel != "" {
+		model := savedModel{
+			Version:           modelVersion,
+			Labels:            regress.Labels(features),
+			Weights:           weights,
+			Threshold:         *threshold,
+			ThresholdCP:       crossingThreshold,
+			WinProbScaling:    scaling,
+			MateCrossing:      *mateCrossing,
+			CrossingStability: *crossingStability,
+			Phase:             gameFilter.Phase,
+			PhaseBy:           gameFilter.PhaseBy,
+			RatingScale:       *ratingScale,
+			MeanRating:        meanRating,
+			MaxAbsDiff:        *maxAbsDiff,
+			L2:                *l2,
+			Input:             *input,
+			Games:             len(samples),
+			Loss:              fit.loss,
+			Converged:         fit.converged,
+		}
+		for _, f := range features {
+			model.Features = append(model.Features, f.Name)
+		}
+		if fit.scaling != nil {
+			model.FeatureMean, model.FeatureScale = fit.scaling.Mean, fit.scaling.Scale
+		}
+		if err := model.save(*saveModel); err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "saved model to %s\n", *saveModel)
 	}
-	var games []accepted
+
+	if *cvFolds > 0 {
+		if *cvFolds > len(samples) {
+			fatal(fmt.Errorf("cv=%d exceeds number of samples (%d)", *cvFolds, len(samples)))
+		}
+		folds, pooled := crossValidate(samples, *cvFolds, opts, *seed)
+		printCrossValidation(*cvFolds, folds, pooled)
+	}
+}
+
+// buildSamples filters records and builds one sample per game; a
+// winProbScaling > 0 gives the eval features as win probabilities (-win-prob).
+func buildSamples(records []cute.GameRecord, features []regress.Feature, crossing cute.CrossingOptions, ratingScale float64, maxAbsDiff int, winProbScaling float64) ([]sample, counts, float64) {
+	games, cts, meanRating := acceptGames(records, crossing, maxAbsDiff)
+	return gameSamples(games, features, ratingScale, meanRating, winProbScaling, &cts), cts, meanRating
+}
+
+// acceptedGame is a game kept by acceptGames.
+type acceptedGame struct {
+	record   *cute.GameRecord
+	cross    cute.Crossing
+	senteWin bool
+}
+
+// acceptGames keeps the games with a crossing and a winner within
+// maxAbsDiff, and returns them with their mean sente rating for centering.
+func acceptGames(records []cute.GameRecord, crossing cute.CrossingOptions, maxAbsDiff int) ([]acceptedGame, counts, float64) {
+	var games []acceptedGame
 	cts := counts{total: len(records)}
 	var sumRating float64
-	for _, record := range records {
-		crossingSide := firstCrossingSide(record.MoveEvals, threshold)
+	for i := range records {
+		record := &records[i]
+		cross := cute.FirstCrossing(record.MoveEvals, crossing)
 		resultSide := winnerSide(record.Result)
 		// Skip games that do not have a clear threshold crossing or winner.
-		if crossingSide == "none" || resultSide == "none" {
+		if cross.Side == "none" || resultSide == "none" {
 			cts.skipped++
 			continue
 		}
@@ -138,11 +318,10 @@ func buildSamples(records []cute.GameRecord, threshold int, ratingScale float64,
 			cts.skipped++
 			continue
 		}
-		games = append(games, accepted{
-			senteRating:     float64(record.SenteRating),
-			goteRating:      float64(record.GoteRating),
-			senteFirstCross: crossingSide == "sente",
-			senteWin:        resultSide == "sente",
+		games = append(games, acceptedGame{
+			record:   record,
+			cross:    cross,
+			senteWin: resultSide == "sente",
 		})
 		sumRating += float64(record.SenteRating)
 	}
@@ -150,50 +329,108 @@ func buildSamples(records []cute.GameRecord, threshold int, ratingScale float64,
 	if len(games) > 0 {
 		meanRating = sumRating / float64(len(games))
 	}
-	// Second pass: build one sample per game (sente perspective) with centered rating.
+	return games, cts, meanRating
+}
+
+// gameSamples builds one sample per game (sente perspective) with ratings
+// centered on meanRating. Games for which a requested feature is
+// unavailable are skipped and counted in cts.
+func gameSamples(games []acceptedGame, features []regress.Feature, ratingScale, meanRating, winProbScaling float64, cts *counts) []sample {
 	samples := make([]sample, 0, len(games))
 	for _, g := range games {
-		samples = append(samples, makeSample(g.senteRating, g.goteRating, g.senteFirstCross, g.senteWin, ratingScale, meanRating))
+		gf := regress.NewGame(*g.record, g.cross, ratingScale, meanRating, winProbScaling)
+		x, ok := regress.Vector(features, gf)
+		if !ok {
+			cts.skipped++
+			continue
+		}
+		label := 0.0
+		if g.senteWin {
+			label = 1.0
+		}
+		samples = append(samples, sample{x: x, y: label, record: g.record})
 	}
-	return samples, cts, meanRating
+	return samples
 }
 
-func makeSample(senteRating, goteRating float64, senteFirstCross bool, senteWin bool, ratingScale float64, meanRating float64) sample {
-	first := 0.0
-	if senteFirstCross {
-		first = 1.0
-	}
-	label := 0.0
-	if senteWin {
-		label = 1.0
-	}
-	// ratingDiff: how much stronger sente is than gote.
-	ratingDiff := (senteRating - goteRating) / ratingScale
-	// Centered rating: sente's rating relative to the dataset mean.
-	// Centering makes the intercept and first_crossed coefficients
-	// represent effects at the mean rating, not at rating=0.
-	ratingCentered := (senteRating - meanRating) / ratingScale
-	// Interaction term: only active when sente first crossed the threshold.
-	// If its coefficient is positive, higher rating means better conversion of advantage.
-	ratingFirst := ratingCentered * first
-	return sample{
-		x: []float64{1.0, ratingDiff, first, ratingFirst},
-		y: label,
-	}
+// fitOptions controls the gradient descent fit.
+type fitOptions struct {
+	iter    int     // maximum number of iterations
+	lr      float64 // learning rate
+	l2      float64 // L2 penalty (lambda); the intercept is not penalized
+	tol     float64 // stop when the gradient norm falls below tol (0=disabled)
+	workers int     // number of gradient workers
+	// batchSize > 0 fits with mini-batches of this size (see fitMiniBatch);
+	// iter then counts epochs. 0, or a batch covering all samples, uses
+	// full-batch gradient descent.
+	batchSize int
+	optimizer string // mini-batch update rule: "sgd" or "adam"
+	seed      int64  // mini-batch shuffle seed
+	// standardize fits on features scaled to mean 0 and standard deviation
+	// 1 (see regress.Standardize); the weights are returned on the original
+	// scale, but l2 penalizes the standardized ones.
+	standardize bool
+	// init are the starting weights on the original scale (-load-model);
+	// nil starts from zero.
+	init []float64
+}
+
+// fitResult is the outcome of fitLogReg.
+type fitResult struct {
+	weights    []float64
+	loss       float64 // final average negative log-likelihood (without penalty)
+	iterations int     // iterations actually used
+	converged  bool    // gradient norm fell below tol
+	diverged   bool    // penalized loss increased at some iteration
+	// scales are the feature standard deviations of a standardized fit (nil
+	// otherwise), for the penalty term of the Fisher information.
+	scales []float64
+	// scaling is the standardization of a standardized fit (nil otherwise).
+	scaling *regress.Scaling
+}
+
+func fitLogReg(samples []sample, opts fitOptions) fitResult {
+	if !opts.standardize {
+		return fitUnscaled(samples, opts)
+	}
+	rows := make([][]float64, len(samples))
+	for i, s := range samples {
+		rows[i] = s.x
+	}
+	scaling := regress.Standardize(rows)
+	scaled := make([]sample, len(samples))
+	for i, s := range samples {
+		scaled[i] = sample{x: scaling.Apply(s.x), y: s.y}
+	}
+	if opts.init != nil {
+		opts.init = scaling.Rescale(opts.init)
+	}
+	res := fitUnscaled(scaled, opts)
+	res.weights = scaling.Unscale(res.weights)
+	res.scales = scaling.Scale
+	res.scaling = &scaling
+	return res
 }
 
-func fitLogReg(samples []sample, iter int, lr float64, workers int) ([]float64, float64) {
-	// Initialize weights to zero. This corresponds to 50% predicted win rate.
-	weights := make([]float64, len(samples[0].x))
+// fitUnscaled fits samples as given, with mini-batches or full-batch
+// gradient descent.
+func fitUnscaled(samples []sample, opts fitOptions) fitResult {
+	if opts.batchSize > 0 && opts.batchSize < len(samples) {
+		return fitMiniBatch(samples, opts)
+	}
+	// Initialize weights to zero (50% predicted win rate) unless warm
+	// starting.
+	weights := initialWeights(len(samples[0].x), opts)
+	workers := opts.workers
 	if workers > len(samples) {
 		workers = len(samples)
 	}
 	// Model:
 	//   p = sigmoid(w · x) = 1 / (1 + exp(-w · x))
-	// Loss (average negative log-likelihood):
-	//   L = (1/N) * sum_i [ -y_i * log(p_i) - (1 - y_i) * log(1 - p_i) ]
+	// Loss (average negative log-likelihood plus L2 penalty):
+	//   L = (1/N) * sum_i [ -y_i * log(p_i) - (1 - y_i) * log(1 - p_i) ] + (λ/2) * sum_{j>0} w_j^2
 	// Gradient:
-	//   dL/dw = (1/N) * sum_i (p_i - y_i) * x_i
+	//   dL/dw = (1/N) * sum_i (p_i - y_i) * x_i + λ * w   (λ term skipped for the intercept)
 	// We update w by gradient descent: w = w - lr * dL/dw
 	// Symbols:
 	//   x   : feature vector for one sample (intercept, rating diff, etc.)
@@ -201,78 +438,131 @@ func fitLogReg(samples []sample, iter int, lr float64, workers int) ([]float64,
 	//   p   : predicted win probability for a sample
 	//   y   : true label (win=1, lose=0)
 	//   N   : number of samples
-	for i := 0; i < iter; i++ {
-		grad := make([]float64, len(weights))
-		if workers <= 1 {
-			for _, s := range samples {
-				p := sigmoid(dot(weights, s.x))
-				err := p - s.y
-				for j := range grad {
-					grad[j] += err * s.x[j]
-				}
+	//   λ   : L2 regularization strength
+	res := fitResult{weights: weights}
+	prevLoss := math.Inf(1)
+	for i := 0; i < opts.iter; i++ {
+		grad, loss := gradientAndLoss(samples, weights, workers)
+		n := float64(len(samples))
+		penalty := 0.0
+		for j := range grad {
+			grad[j] /= n
+			if j > 0 && opts.l2 > 0 {
+				grad[j] += opts.l2 * weights[j]
+				penalty += 0.5 * opts.l2 * weights[j] * weights[j]
 			}
-		} else {
-			partials := make([][]float64, workers)
-			for w := 0; w < workers; w++ {
-				partials[w] = make([]float64, len(weights))
-			}
-			var wg sync.WaitGroup
-			chunk := (len(samples) + workers - 1) / workers
-			for w := 0; w < workers; w++ {
-				start := w * chunk
-				end := start + chunk
-				if start >= len(samples) {
-					break
-				}
-				if end > len(samples) {
-					end = len(samples)
-				}
-				wg.Add(1)
-				go func(idx, from, to int) {
-					defer wg.Done()
-					localGrad := partials[idx]
-					for _, s := range samples[from:to] {
-						p := sigmoid(dot(weights, s.x))
-						err := p - s.y
-						for j := range localGrad {
-							localGrad[j] += err * s.x[j]
-						}
-					}
-				}(w, start, end)
+		}
+		// The loss here is evaluated at the weights before this update.
+		objective := loss/n + penalty
+		if objective > prevLoss+1e-12 && !res.diverged {
+			res.diverged = true
+			fmt.Fprintf(os.Stderr, "warning: loss increased at iteration %d (%.6f -> %.6f); consider a smaller -lr\n", i+1, prevLoss, objective)
+		}
+		prevLoss = objective
+		res.iterations = i + 1
+		if opts.tol > 0 && norm(grad) < opts.tol {
+			res.converged = true
+			break
+		}
+		for j := range weights {
+			weights[j] -= opts.lr * grad[j]
+		}
+	}
+	res.loss = logLoss(samples, weights)
+	return res
+}
+
+// initialWeights returns a copy of opts.init, or k zeros.
+func initialWeights(k int, opts fitOptions) []float64 {
+	weights := make([]float64, k)
+	copy(weights, opts.init)
+	return weights
+}
+
+// gradientAndLoss returns the summed (not averaged) gradient and negative
+// log-likelihood over samples at weights, split across workers.
+func gradientAndLoss(samples []sample, weights []float64, workers int) ([]float64, float64) {
+	grad := make([]float64, len(weights))
+	if workers <= 1 {
+		var loss float64
+		for _, s := range samples {
+			p := sigmoid(dot(weights, s.x))
+			err := p - s.y
+			for j := range grad {
+				grad[j] += err * s.x[j]
 			}
-			wg.Wait()
-			for w := 0; w < workers; w++ {
-				localGrad := partials[w]
-				for j := range grad {
-					grad[j] += localGrad[j]
+			loss += sampleLoss(p, s.y)
+		}
+		return grad, loss
+	}
+	partials := make([][]float64, workers)
+	losses := make([]float64, workers)
+	for w := 0; w < workers; w++ {
+		partials[w] = make([]float64, len(weights))
+	}
+	var wg sync.WaitGroup
+	chunk := (len(samples) + workers - 1) / workers
+	for w := 0; w < workers; w++ {
+		start := w * chunk
+		end := start + chunk
+		if start >= len(samples) {
+			break
+		}
+		if end > len(samples) {
+			end = len(samples)
+		}
+		wg.Add(1)
+		go func(idx, from, to int) {
+			defer wg.Done()
+			localGrad := partials[idx]
+			for _, s := range samples[from:to] {
+				p := sigmoid(dot(weights, s.x))
+				err := p - s.y
+				for j := range localGrad {
+					localGrad[j] += err * s.x[j]
 				}
+				losses[idx] += sampleLoss(p, s.y)
 			}
+		}(w, start, end)
+	}
+	wg.Wait()
+	var loss float64
+	for w := 0; w < workers; w++ {
+		localGrad := partials[w]
+		for j := range grad {
+			grad[j] += localGrad[j]
 		}
-		// Average gradient and update weights.
-		scale := lr / float64(len(samples))
-		for j := range weights {
-			weights[j] -= grad[j] * scale
-		}
+		loss += losses[w]
 	}
-	// Compute final loss (negative log-likelihood) for convergence check.
+	return grad, loss
+}
+
+// logLoss returns the average negative log-likelihood of samples at weights.
+func logLoss(samples []sample, weights []float64) float64 {
 	var totalLoss float64
 	for _, s := range samples {
-		p := sigmoid(dot(weights, s.x))
-		// Clamp to avoid log(0).
-		if p < 1e-15 {
-			p = 1e-15
-		}
-		if p > 1-1e-15 {
-			p = 1 - 1e-15
-		}
-		totalLoss += -s.y*math.Log(p) - (1-s.y)*math.Log(1-p)
+		totalLoss += sampleLoss(sigmoid(dot(weights, s.x)), s.y)
 	}
-	finalLoss := totalLoss / float64(len(samples))
-	return weights, finalLoss
+	return totalLoss / float64(len(samples))
 }
 
-func printCoefficients(weights []float64) {
-	labels := []string{"intercept", "rating_diff_scaled", "first_crossed", "rating_x_first"}
+// sampleLoss is the negative log-likelihood of label y under probability p.
+func sampleLoss(p, y float64) float64 {
+	// Clamp to avoid log(0).
+	if p < 1e-15 {
+		p = 1e-15
+	}
+	if p > 1-1e-15 {
+		p = 1 - 1e-15
+	}
+	return -y*math.Log(p) - (1-y)*math.Log(1-p)
+}
+
+func norm(v []float64) float64 {
+	return math.Sqrt(dot(v, v))
+}
+
+func printCoefficients(labels []string, weights []float64) {
 	fmt.Println("coefficients (log-odds):")
 	// Coefficients are in log-odds units; positive values increase win probability.
 	for i, w := range weights {
@@ -280,46 +570,65 @@ func printCoefficients(weights []float64) {
 	}
 }
 
-func printOddsRatios(weights []float64) {
-	labels := []string{"rating_diff_scaled", "first_crossed", "rating_x_first"}
+func printOddsRatios(labels []string, weights []float64) {
 	fmt.Println("odds ratios (1.0 = no change):")
 	// Odds ratios are easier to read: 1.0 means no change, 1.5 means 50% higher odds.
 	for i := 1; i < len(weights); i++ {
-		fmt.Printf("  %s = %.4f\n", labels[i-1], math.Exp(weights[i]))
+		fmt.Printf("  %s = %.4f\n", labels[i], math.Exp(weights[i]))
 	}
 }
 
-func printPredictedRates(weights []float64) {
-	// Predictions at ratingDiff=0, ratingCentered=0 (mean-rated player).
+// printPredictedRates prints predictions at ratingDiff=0, ratingCentered=0
+// (mean-rated player). It is skipped when a feature cannot be computed from
+// ratings and first_crossed alone (e.g. eval_at_ply_N).
+func printPredictedRates(features []regress.Feature, weights []float64) {
+	win, ok1 := predict(features, weights, 0, 1, 0)
+	lose, ok2 := predict(features, weights, 0, 0, 0)
+	if !ok1 || !ok2 {
+		return
+	}
 	fmt.Println("predicted win rates (rating diff = 0, at mean rating):")
-	fmt.Printf("  first-cross=1: %.3f\n", predict(weights, 0, 1, 0))
-	fmt.Printf("  first-cross=0: %.3f\n", predict(weights, 0, 0, 0))
+	fmt.Printf("  first-cross=1: %.3f\n", win)
+	fmt.Printf("  first-cross=0: %.3f\n", lose)
 }
 
-func printRatingFirstCross(weights []float64, ratingScale float64, meanRating float64, ratings []int) {
+func printRatingFirstCross(features []regress.Feature, weights []float64, ratingScale float64, meanRating float64, ratings []int) {
 	if len(ratings) == 0 {
 		return
 	}
+	if _, ok := predict(features, weights, 0, 1, 0); !ok {
+		return
+	}
 	fmt.Println("expected win rates by rating (first-cross=1, rating diff = 0):")
 	for _, rating := range ratings {
 		ratingCentered := (float64(rating) - meanRating) / ratingScale
-		winRate := predict(weights, 0, 1, ratingCentered)
+		winRate, _ := predict(features, weights, 0, 1, ratingCentered)
 		fmt.Printf("  rating=%d: win_rate=%.3f\n", rating, winRate)
 	}
 }
 
-func predict(weights []float64, ratingDiff float64, firstCross float64, ratingCentered float64) float64 {
-	// ratingCentered is (playerRating - meanRating) / ratingScale; affects only the interaction.
-	x := []float64{1.0, ratingDiff, firstCross, ratingCentered * firstCross}
-	return sigmoid(dot(weights, x))
+// predict returns the predicted sente win rate for a hypothetical game.
+// ratingCentered is (playerRating - meanRating) / ratingScale.
+// ok=false when the feature set needs data beyond these inputs.
+func predict(features []regress.Feature, weights []float64, ratingDiff float64, firstCross float64, ratingCentered float64) (float64, bool) {
+	g := regress.Game{RatingDiff: ratingDiff, RatingCentered: ratingCentered, FirstCrossed: firstCross}
+	x, ok := regress.Vector(features, g)
+	if !ok {
+		return 0, false
+	}
+	return sigmoid(dot(weights, x)), true
 }
 
-func printSection(label string, weights []float64, ratingScale float64, meanRating float64, ratings []int) {
+func printSection(label string, features []regress.Feature, weights []float64, inference []coefInference, ratingScale float64, meanRating float64, ratings []int) {
+	labels := regress.Labels(features)
 	fmt.Printf("%s model:\n", label)
-	printCoefficients(weights)
-	printOddsRatios(weights)
-	printPredictedRates(weights)
-	printRatingFirstCross(weights, ratingScale, meanRating, ratings)
+	printCoefficients(labels, weights)
+	if inference != nil {
+		printInference(labels, weights, inference)
+	}
+	printOddsRatios(labels, weights)
+	printPredictedRates(features, weights)
+	printRatingFirstCross(features, weights, ratingScale, meanRating, ratings)
 }
 
 func sigmoid(z float64) float64 {
@@ -338,24 +647,6 @@ func dot(a []float64, b []float64) float64 {
 	return sum
 }
 
-func firstCrossingSide(evals []cute.MoveEval, threshold int) string {
-	for _, eval := range evals {
-		if eval.ScoreType == "mate" {
-			if eval.ScoreValue >= 0 {
-				return "sente"
-			}
-			return "gote"
-		}
-		if eval.ScoreValue >= int32(threshold) {
-			return "sente"
-		}
-		if eval.ScoreValue <= -int32(threshold) {
-			return "gote"
-		}
-	}
-	return "none"
-}
-
 func winnerSide(result string) string {
 	switch result {
 	case "sente_win":
@@ -367,42 +658,6 @@ func winnerSide(result string) string {
 	}
 }
 
-func readParquet(path string, parallel int64) ([]cute.GameRecord, error) {
-	absPath := path
-	if !filepath.IsAbs(path) {
-		if resolved, err := filepath.Abs(path); err == nil {
-			absPath = resolved
-		}
-	}
-	fileReader, err := local.NewLocalFileReader(absPath)
-	if err != nil {
-		return nil, err
-	}
-	defer fileReader.Close()
-
-	parquetReader, err := reader.NewParquetReader(fileReader, new(cute.GameRecord), parallel)
-	if err != nil {
-		return nil, err
-	}
-	defer parquetReader.ReadStop()
-
-	num := int(parquetReader.GetNumRows())
-	records := make([]cute.GameRecord, 0, num)
-	batchSize := 1024
-	for offset := 0; offset < num; offset += batchSize {
-		remain := num - offset
-		if remain < batchSize {
-			batchSize = remain
-		}
-		batch := make([]cute.GameRecord, batchSize)
-		if err := parquetReader.Read(&batch); err != nil {
-			return nil, err
-		}
-		records = append(records, batch...)
-	}
-	return records, nil
-}
-
 func absInt(v int) int {
 	if v < 0 {
 		return -v
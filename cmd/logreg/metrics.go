@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// prediction pairs a predicted probability with the observed label.
+type prediction struct {
+	p float64
+	y float64
+}
+
+// foldMetrics summarizes held-out predictions.
+type foldMetrics struct {
+	n       int
+	logLoss float64
+	auc     float64
+	brier   float64
+}
+
+// crossValidate fits the model on k-1 folds and predicts the held-out fold,
+// returning per-fold metrics and the pooled out-of-fold predictions.
+// Samples are assigned to folds after a deterministic shuffle by seed.
+func crossValidate(samples []sample, k int, opts fitOptions, seed int64) ([]foldMetrics, []prediction) {
+	idx := rand.New(rand.NewSource(seed)).Perm(len(samples))
+	folds := make([]foldMetrics, 0, k)
+	var pooled []prediction
+	for f := 0; f < k; f++ {
+		var train, test []sample
+		for i, j := range idx {
+			if i%k == f {
+				test = append(test, samples[j])
+			} else {
+				train = append(train, samples[j])
+			}
+		}
+		if len(train) == 0 || len(test) == 0 {
+			continue
+		}
+		fit := fitLogReg(train, opts)
+		preds := predictAll(fit.weights, test)
+		folds = append(folds, evaluatePredictions(preds))
+		pooled = append(pooled, preds...)
+	}
+	return folds, pooled
+}
+
+// splitSamples holds out round(fraction*n) samples as a test set, chosen
+// by a deterministic shuffle by seed.
+func splitSamples(samples []sample, fraction float64, seed int64) ([]sample, []sample) {
+	idx := rand.New(rand.NewSource(seed)).Perm(len(samples))
+	nTest := int(math.Round(fraction * float64(len(samples))))
+	train := make([]sample, 0, len(samples)-nTest)
+	test := make([]sample, 0, nTest)
+	for i, j := range idx {
+		if i < nTest {
+			test = append(test, samples[j])
+		} else {
+			train = append(train, samples[j])
+		}
+	}
+	return train, test
+}
+
+func predictAll(weights []float64, samples []sample) []prediction {
+	preds := make([]prediction, len(samples))
+	for i, s := range samples {
+		preds[i] = prediction{p: sigmoid(dot(weights, s.x)), y: s.y}
+	}
+	return preds
+}
+
+func evaluatePredictions(preds []prediction) foldMetrics {
+	m := foldMetrics{n: len(preds)}
+	if len(preds) == 0 {
+		return m
+	}
+	for _, pr := range preds {
+		m.logLoss += sampleLoss(pr.p, pr.y)
+		m.brier += (pr.p - pr.y) * (pr.p - pr.y)
+	}
+	m.logLoss /= float64(len(preds))
+	m.brier /= float64(len(preds))
+	m.auc = auc(preds)
+	return m
+}
+
+// auc computes the area under the ROC curve via the Mann-Whitney U
+// statistic, averaging ranks over tied predictions. Returns NaN when only
+// one class is present.
+func auc(preds []prediction) float64 {
+	sorted := make([]prediction, len(preds))
+	copy(sorted, preds)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].p < sorted[j].p })
+	var rankSumPos float64
+	var nPos, nNeg float64
+	for i := 0; i < len(sorted); {
+		j := i
+		for j < len(sorted) && sorted[j].p == sorted[i].p {
+			j++
+		}
+		// Ranks are 1-based; tied block [i, j) shares the average rank.
+		avgRank := float64(i+j+1) / 2
+		for t := i; t < j; t++ {
+			if sorted[t].y > 0.5 {
+				rankSumPos += avgRank
+				nPos++
+			} else {
+				nNeg++
+			}
+		}
+		i = j
+	}
+	if nPos == 0 || nNeg == 0 {
+		return math.NaN()
+	}
+	return (rankSumPos - nPos*(nPos+1)/2) / (nPos * nNeg)
+}
+
+// calibrationBin is one equal-count bin of predicted probabilities.
+type calibrationBin struct {
+	n        int
+	meanPred float64
+	observed float64
+}
+
+// calibrationTable splits predictions into bins (deciles for bins=10) by
+// predicted probability and reports mean prediction vs. observed win rate.
+func calibrationTable(preds []prediction, bins int) []calibrationBin {
+	sorted := make([]prediction, len(preds))
+	copy(sorted, preds)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].p < sorted[j].p })
+	table := make([]calibrationBin, 0, bins)
+	for b := 0; b < bins; b++ {
+		from := b * len(sorted) / bins
+		to := (b + 1) * len(sorted) / bins
+		if from >= to {
+			continue
+		}
+		var sumP, sumY float64
+		for _, pr := range sorted[from:to] {
+			sumP += pr.p
+			sumY += pr.y
+		}
+		n := to - from
+		table = append(table, calibrationBin{n: n, meanPred: sumP / float64(n), observed: sumY / float64(n)})
+	}
+	return table
+}
+
+func printCrossValidation(k int, folds []foldMetrics, pooled []prediction) {
+	fmt.Printf("cross-validation (k=%d):\n", k)
+	for i, m := range folds {
+		fmt.Printf("  fold %d: n=%d log-loss=%.6f auc=%.4f brier=%.6f\n", i+1, m.n, m.logLoss, m.auc, m.brier)
+	}
+	all := evaluatePredictions(pooled)
+	fmt.Printf("  held-out: n=%d log-loss=%.6f auc=%.4f brier=%.6f\n", all.n, all.logLoss, all.auc, all.brier)
+	fmt.Println("calibration (held-out, by predicted decile):")
+	fmt.Println("  bin,n,mean_predicted,observed_win_rate")
+	for i, bin := range calibrationTable(pooled, 10) {
+		fmt.Printf("  %d,%d,%.4f,%.4f\n", i+1, bin.n, bin.meanPred, bin.observed)
+	}
+}
+
+// printHoldout compares the in-sample metrics with those on the held-out
+// test split; a test log-loss well above the train one means the
+// coefficients describe the training games more than games in general.
+func printHoldout(fraction float64, train, test []prediction) {
+	fmt.Printf("holdout (test-fraction=%g):\n", fraction)
+	for _, split := range []struct {
+		name  string
+		preds []prediction
+	}{{"train", train}, {"test", test}} {
+		m := evaluatePredictions(split.preds)
+		fmt.Printf("  %s: n=%d log-loss=%.6f auc=%.4f brier=%.6f\n", split.name, m.n, m.logLoss, m.auc, m.brier)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/regress"
+)
+
+// modelVersion is the version of the -save-model format.
+const modelVersion = 1
+
+// savedModel is a fitted model as written by -save-model: the weights and
+// everything needed to build the same feature vectors again, so that the
+// model can warm-start a fit (-load-model), be compared with a fit on
+// another version of the data, or score new games (logreg predict).
+type savedModel struct {
+	Version int `json:"version"`
+	// Features is the -features list; Weights are in the order of
+	// Labels, "intercept" followed by the features, on the original
+	// (unstandardized) feature scale.
+	Features []string  `json:"features"`
+	Labels   []string  `json:"labels"`
+	Weights  []float64 `json:"weights"`
+	// Crossing detection: Threshold is the -threshold flag, a win
+	// percentage when WinProbScaling > 0 (-win-prob), and ThresholdCP
+	// its cp equivalent.
+	Threshold         int     `json:"threshold"`
+	ThresholdCP       int     `json:"threshold_cp"`
+	WinProbScaling    float64 `json:"win_prob_scaling,omitempty"`
+	MateCrossing      bool    `json:"mate_crossing"`
+	CrossingStability int     `json:"crossing_stability"`
+	// Phase and PhaseBy are the -phase the crossings were detected in
+	// (see cute.GameFilter), "" for the whole game.
+	Phase   string `json:"phase,omitempty"`
+	PhaseBy string `json:"phase_by,omitempty"`
+	// Rating features are (rating - MeanRating) / RatingScale; MeanRating
+	// is the mean sente rating of the training games.
+	RatingScale float64 `json:"rating_scale"`
+	MeanRating  float64 `json:"mean_rating"`
+	MaxAbsDiff  int     `json:"max_abs_diff"`
+	// FeatureMean and FeatureScale are the standardization of a
+	// -standardize fit (see regress.Scaling), absent otherwise.
+	FeatureMean  []float64 `json:"feature_mean,omitempty"`
+	FeatureScale []float64 `json:"feature_scale,omitempty"`
+	L2           float64   `json:"l2"`
+	// The training data and the fit.
+	Input     string  `json:"input"`
+	Games     int     `json:"games"`
+	Loss      float64 `json:"loss"`
+	Converged bool    `json:"converged"`
+}
+
+// phase returns the game filter that restricts the evals to the phase the
+// model was fit on.
+func (m savedModel) phase() cute.GameFilter {
+	return cute.GameFilter{Phase: m.Phase, PhaseBy: m.PhaseBy}
+}
+
+// featureSpec returns the -features value of the model.
+func (m savedModel) featureSpec() string {
+	return strings.Join(m.Features, ",")
+}
+
+// crossing returns the crossing options the model was fit with.
+func (m savedModel) crossing() cute.CrossingOptions {
+	return cute.CrossingOptions{
+		Threshold:         m.ThresholdCP,
+		MateCountsAsCross: m.MateCrossing,
+		RequireStability:  m.CrossingStability,
+	}
+}
+
+func (m savedModel) save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// loadModel reads and checks a -save-model file, returning it with its
+// compiled features.
+func loadModel(path string) (savedModel, []regress.Feature, error) {
+	var m savedModel
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, nil, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if m.Version != modelVersion {
+		return m, nil, fmt.Errorf("%s: model version %d, want %d", path, m.Version, modelVersion)
+	}
+	features, err := regress.ParseFeatures(m.featureSpec())
+	if err != nil {
+		return m, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	labels := regress.Labels(features)
+	if len(m.Weights) != len(labels) || strings.Join(m.Labels, ",") != strings.Join(labels, ",") {
+		return m, nil, fmt.Errorf("%s: weights do not match the features %s", path, strings.Join(labels, ", "))
+	}
+	if m.RatingScale <= 0 || m.ThresholdCP <= 0 {
+		return m, nil, fmt.Errorf("%s: missing rating_scale or threshold_cp", path)
+	}
+	return m, features, nil
+}
+
+// adoptModelFlags sets the flags that define the feature vectors to the
+// values m was fit with, so that its weights fit the new samples. A flag
+// given on the command line with another value is an error.
+func adoptModelFlags(fs *flag.FlagSet, m savedModel) error {
+	values := map[string]string{
+		"features":           m.featureSpec(),
+		"threshold":          strconv.Itoa(m.Threshold),
+		"win-prob":           strconv.FormatBool(m.WinProbScaling > 0),
+		"mate-crossing":      strconv.FormatBool(m.MateCrossing),
+		"crossing-stability": strconv.Itoa(m.CrossingStability),
+		"rating-scale":       strconv.FormatFloat(m.RatingScale, 'g', -1, 64),
+		"phase":              m.Phase,
+	}
+	if m.Phase != "" {
+		values["phase-by"] = m.PhaseBy
+	}
+	if m.WinProbScaling > 0 {
+		values["win-prob-scaling"] = strconv.FormatFloat(m.WinProbScaling, 'g', -1, 64)
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name, want := range values {
+		f := fs.Lookup(name)
+		got := f.Value.String()
+		if name == "features" {
+			got = strings.ReplaceAll(got, " ", "")
+		}
+		if set[name] && got != want {
+			return fmt.Errorf("-%s %s conflicts with the loaded model (%s)", name, f.Value, want)
+		}
+		if err := fs.Set(name, want); err != nil {
+			return err
+		}
+	}
+	return nil
+}
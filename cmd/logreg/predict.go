@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	cute "cute/pkg/cute"
+)
+
+// runPredict implements "logreg predict -model model.json -input
+// games.parquet": it scores games with a model saved by -save-model,
+// without fitting, and reports how well it predicts them.
+func runPredict(args []string) {
+	fs := flag.NewFlagSet("predict", flag.ExitOnError)
+	modelPath := fs.String("model", "", "model file written by -save-model (required)")
+	input := fs.String("input", "output.parquet", "input parquet file")
+	output := fs.String("output", "predictions.csv", "write the predicted sente win probability of every game, with its result, to this CSV file (same columns as -predict-out)")
+	parallel := fs.Int64("parallel", 4, "parquet read parallelism")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: logreg predict -model model.json [-input games.parquet] [-output predictions.csv]\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if *modelPath == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	model, features, err := loadModel(*modelPath)
+	if err != nil {
+		fatal(err)
+	}
+	if err := cute.CheckParquetPerspective(*input); err != nil {
+		fatal(err)
+	}
+	records, err := cute.ReadGameRecords(*input, *parallel)
+	if err != nil {
+		fatal(err)
+	}
+	// The games are filtered and centered as the training games were.
+	records = model.phase().Filter(records)
+	games, cts, _ := acceptGames(records, model.crossing(), model.MaxAbsDiff)
+	samples := gameSamples(games, features, model.RatingScale, model.MeanRating, model.WinProbScaling, &cts)
+	if len(samples) == 0 {
+		fatal(fmt.Errorf("no samples available after filtering (total=%d skipped=%d)", cts.total, cts.skipped))
+	}
+	m := evaluatePredictions(predictAll(model.Weights, samples))
+	fmt.Printf("model: %s (fit on %s, %d games)\n", *modelPath, model.Input, model.Games)
+	fmt.Printf("games: %d (skipped=%d)\n", len(samples), cts.skipped)
+	fmt.Printf("predicted: n=%d log-loss=%.6f auc=%.4f brier=%.6f\n", m.n, m.logLoss, m.auc, m.brier)
+	if err := writePredictions(*output, model.Weights, samples, nil); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+)
+
+// writePredictions writes one row per game with the predicted sente win
+// probability of the fitted model next to the actual result, so that
+// residuals can be inspected and joined back to the opening DB by game_id.
+// split is "train" or "test" with -test-fraction, else "all".
+func writePredictions(path string, weights []float64, train, test []sample) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"game_id", "split", "sente_rating", "gote_rating", "result", "sente_win", "predicted", "residual"}); err != nil {
+		return err
+	}
+	trainSplit := "all"
+	if test != nil {
+		trainSplit = "train"
+	}
+	for _, part := range []struct {
+		split   string
+		samples []sample
+	}{{trainSplit, train}, {"test", test}} {
+		for _, s := range part.samples {
+			p := sigmoid(dot(weights, s.x))
+			if err := w.Write([]string{
+				s.record.GameID,
+				part.split,
+				strconv.Itoa(int(s.record.SenteRating)),
+				strconv.Itoa(int(s.record.GoteRating)),
+				s.record.Result,
+				strconv.Itoa(int(s.y)),
+				strconv.FormatFloat(p, 'f', 6, 64),
+				strconv.FormatFloat(s.y-p, 'f', 6, 64),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
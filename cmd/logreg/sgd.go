@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+)
+
+// Adam hyperparameters (Kingma & Ba); only the learning rate is a flag.
+const (
+	adamBeta1   = 0.9
+	adamBeta2   = 0.999
+	adamEpsilon = 1e-8
+)
+
+// minBatchPerWorker is the smallest share of a mini-batch worth handing to a
+// gradient worker; smaller batches are summed on one goroutine.
+const minBatchPerWorker = 1024
+
+// divergeMargin is the relative loss increase over the best epoch that
+// fitMiniBatch reports as divergence.
+const divergeMargin = 0.05
+
+// fitMiniBatch fits the same penalized log-loss as the full-batch path with
+// mini-batch updates: every epoch visits the samples in a fresh shuffled
+// order (by opts.seed) in batches of opts.batchSize, and each batch takes
+// one SGD or Adam step on its average gradient. opts.iter counts epochs.
+//
+// After every epoch the full gradient and loss are computed once (in
+// parallel), for the -tol stopping rule and the divergence warning. The
+// loss of mini-batch updates jitters from epoch to epoch, so only a loss
+// clearly above the best epoch so far (divergeMargin) counts as diverging.
+func fitMiniBatch(samples []sample, opts fitOptions) fitResult {
+	k := len(samples[0].x)
+	weights := initialWeights(k, opts)
+	m := make([]float64, k) // Adam first moment
+	v := make([]float64, k) // Adam second moment
+	rng := rand.New(rand.NewSource(opts.seed))
+	order := make([]int, len(samples))
+	for i := range order {
+		order[i] = i
+	}
+	batch := make([]sample, 0, opts.batchSize)
+	batchWorkers := max(1, min(opts.workers, opts.batchSize/minBatchPerWorker))
+	fullWorkers := min(opts.workers, len(samples))
+
+	res := fitResult{weights: weights}
+	bestLoss := math.Inf(1)
+	step := 0
+	for epoch := 0; epoch < opts.iter; epoch++ {
+		rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		for from := 0; from < len(order); from += opts.batchSize {
+			batch = batch[:0]
+			for _, idx := range order[from:min(from+opts.batchSize, len(order))] {
+				batch = append(batch, samples[idx])
+			}
+			grad, _ := gradientAndLoss(batch, weights, batchWorkers)
+			n := float64(len(batch))
+			for j := range grad {
+				grad[j] /= n
+				if j > 0 && opts.l2 > 0 {
+					grad[j] += opts.l2 * weights[j]
+				}
+			}
+			step++
+			if opts.optimizer == "sgd" {
+				for j := range weights {
+					weights[j] -= opts.lr * grad[j]
+				}
+				continue
+			}
+			// Adam: per-coefficient steps from bias-corrected moment
+			// estimates, which copes with features on different scales.
+			c1 := 1 - math.Pow(adamBeta1, float64(step))
+			c2 := 1 - math.Pow(adamBeta2, float64(step))
+			for j := range weights {
+				m[j] = adamBeta1*m[j] + (1-adamBeta1)*grad[j]
+				v[j] = adamBeta2*v[j] + (1-adamBeta2)*grad[j]*grad[j]
+				weights[j] -= opts.lr * (m[j] / c1) / (math.Sqrt(v[j]/c2) + adamEpsilon)
+			}
+		}
+
+		grad, loss := gradientAndLoss(samples, weights, fullWorkers)
+		n := float64(len(samples))
+		penalty := 0.0
+		for j := range grad {
+			grad[j] /= n
+			if j > 0 && opts.l2 > 0 {
+				grad[j] += opts.l2 * weights[j]
+				penalty += 0.5 * opts.l2 * weights[j] * weights[j]
+			}
+		}
+		objective := loss/n + penalty
+		if objective > bestLoss*(1+divergeMargin) && !res.diverged {
+			res.diverged = true
+			fmt.Fprintf(os.Stderr, "warning: loss increased at epoch %d (best %.6f -> %.6f); consider a smaller -lr or a larger -batch-size\n", epoch+1, bestLoss, objective)
+		}
+		bestLoss = math.Min(bestLoss, objective)
+		res.iterations = epoch + 1
+		if opts.tol > 0 && norm(grad) < opts.tol {
+			res.converged = true
+			break
+		}
+	}
+	res.loss = logLoss(samples, weights)
+	return res
+}
@@ -0,0 +1,321 @@
+package main
+
+// This command maps every played move to the piece it moves and whether it
+// is a drop, promotion or capture, and reports how often each kind of move
+// is a blunder, e.g. whether drops go wrong more often at low ratings.
+//
+// The moves come from the KIFs the parquet was built from (-kif-dir); the
+// loss of a move is how much it lowers the eval for its player, from the
+// eval after the previous ply to the eval after the move, as in report.
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+
+	"github.com/xitongsys/parquet-go-source/local"
+)
+
+// dimensions are the values accepted by -group-by.
+var dimensions = map[string]bool{
+	"rating_bucket": true, // the mover's rating bucket (-bin-size)
+	"side":          true, // sente or gote
+	"piece":         true, // moved piece, "+" for promoted ones (e.g. P, +R)
+	"move_type":     true, // drop, promote, capture or move
+}
+
+// stats aggregates the moves of one row.
+type stats struct {
+	moves    int
+	blunders int
+	lossSum  float64 // sum of the positive losses
+}
+
+func main() {
+	input := flag.String("input", "output.parquet", "input parquet file")
+	kifDir := flag.String("kif-dir", "", "KIF directory the parquet was built from (required)")
+	output := flag.String("output", "", "output CSV file (default: stdout)")
+	groupByArg := flag.String("group-by", "rating_bucket,move_type", "comma-separated row dimensions: rating_bucket, side, piece, move_type")
+	blunder := flag.Int("blunder", 300, "a move that loses at least this many cp for its player is a blunder")
+	maxEval := flag.Int("max-eval", 2000, "clip evals to ±max-eval before taking differences; mates count as ±max-eval")
+	binSize := flag.Int("bin-size", 200, "rating bucket size of -group-by rating_bucket")
+	minMoves := flag.Int("min-moves", 1, "drop rows with fewer moves")
+	recordFilter := flag.String("record-filter", "", `expr filter on GameRecord fields, as in analyze (e.g. 'move_count > 80 && win_reason == "投了"')`)
+	parallel := flag.Int64("parallel", 4, "parquet read parallelism")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("movequality", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	if *kifDir == "" {
+		fatal(fmt.Errorf("-kif-dir is required"))
+	}
+	if *blunder <= 0 {
+		fatal(fmt.Errorf("blunder must be > 0"))
+	}
+	if *maxEval <= 0 {
+		fatal(fmt.Errorf("max-eval must be > 0"))
+	}
+	if *binSize <= 0 {
+		fatal(fmt.Errorf("bin-size must be > 0"))
+	}
+	if *minMoves <= 0 {
+		fatal(fmt.Errorf("min-moves must be > 0"))
+	}
+	dims, err := parseGroupBy(*groupByArg)
+	if err != nil {
+		fatal(err)
+	}
+
+	records, err := readParquet(*input, *parallel)
+	if err != nil {
+		fatal(err)
+	}
+	if *recordFilter != "" {
+		program, err := cute.CompileRecordFilter(*recordFilter)
+		if err != nil {
+			fatal(err)
+		}
+		total := len(records)
+		if records, err = cute.FilterRecords(records, program); err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "record filter: %d/%d games match\n", len(records), total)
+	}
+
+	kifPaths := make(map[string]string)
+	if err := cute.WalkKIF(*kifDir, func(path string) error {
+		kifPaths[filepath.Base(path)] = path
+		return nil
+	}); err != nil {
+		fatal(err)
+	}
+
+	results := make(map[string]*stats)
+	games, missing, broken := 0, 0, 0
+	for _, record := range records {
+		path, ok := kifPaths[record.GameID]
+		if !ok {
+			missing++
+			continue
+		}
+		board, err := cute.LoadBoardFromKIF(path)
+		if err == nil {
+			err = addGame(results, record, board, dims, *blunder, *maxEval, *binSize)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			broken++
+			continue
+		}
+		games++
+	}
+	fmt.Fprintf(os.Stderr, "games: %d (no KIF=%d, unreadable=%d)\n", games, missing, broken)
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := writeRows(out, dims, results, *minMoves); err != nil {
+		fatal(err)
+	}
+}
+
+// addGame counts the moves of record that have an eval before and after
+// them.
+func addGame(results map[string]*stats, record cute.GameRecord, board *cute.Board, dims []string, blunder, maxEval, binSize int) error {
+	values := make(map[int]int, len(record.MoveEvals))
+	for _, eval := range record.MoveEvals {
+		values[int(eval.Ply)] = min(max(eval.EffectiveCP(), -maxEval), maxEval)
+	}
+	return board.ForEachPly(func(ply int, pos *cute.Position, move string) error {
+		if move == "" {
+			return nil
+		}
+		before, ok1 := values[ply]
+		after, ok2 := values[ply+1]
+		if !ok1 || !ok2 {
+			return nil
+		}
+		info, err := pos.DescribeMove(move)
+		if err != nil {
+			return fmt.Errorf("move %d: %w", ply+1, err)
+		}
+		side, rating, loss := "sente", int(record.SenteRating), before-after
+		if pos.Turn() == cute.White {
+			side, rating, loss = "gote", int(record.GoteRating), after-before
+		}
+		key := rowKey(dims, side, rating, info, binSize)
+		st := results[key]
+		if st == nil {
+			st = &stats{}
+			results[key] = st
+		}
+		st.moves++
+		if loss > 0 {
+			st.lossSum += float64(loss)
+		}
+		if loss >= blunder {
+			st.blunders++
+		}
+		return nil
+	})
+}
+
+// rowKey joins the dimension values of a move with "\x00".
+func rowKey(dims []string, side string, rating int, info cute.MoveInfo, binSize int) string {
+	values := make([]string, len(dims))
+	for i, dim := range dims {
+		switch dim {
+		case "rating_bucket":
+			from := int(math.Floor(float64(rating)/float64(binSize))) * binSize
+			values[i] = fmt.Sprintf("%d-%d", from, from+binSize)
+		case "side":
+			values[i] = side
+		case "piece":
+			values[i] = info.Piece
+			if info.Promoted {
+				values[i] = "+" + info.Piece
+			}
+		case "move_type":
+			values[i] = moveType(info)
+		}
+	}
+	return strings.Join(values, "\x00")
+}
+
+// moveType classifies a move; a promotion that captures is a promotion.
+func moveType(info cute.MoveInfo) string {
+	switch {
+	case info.Drop:
+		return "drop"
+	case info.Promote:
+		return "promote"
+	case info.Captured != "":
+		return "capture"
+	}
+	return "move"
+}
+
+// parseGroupBy validates a comma-separated -group-by list.
+func parseGroupBy(raw string) ([]string, error) {
+	var dims []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !dimensions[part] {
+			names := make([]string, 0, len(dimensions))
+			for name := range dimensions {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown -group-by dimension %q (available: %s)", part, strings.Join(names, ", "))
+		}
+		if !seen[part] {
+			seen[part] = true
+			dims = append(dims, part)
+		}
+	}
+	return dims, nil
+}
+
+// writeRows writes one CSV row per group with at least minMoves moves,
+// sorted by the dimension values (rating buckets numerically).
+func writeRows(out io.Writer, dims []string, results map[string]*stats, minMoves int) error {
+	keys := make([]string, 0, len(results))
+	for key, st := range results {
+		if st.moves >= minMoves {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := strings.Split(keys[i], "\x00"), strings.Split(keys[j], "\x00")
+		for d, dim := range dims {
+			if a[d] == b[d] {
+				continue
+			}
+			if dim == "rating_bucket" {
+				return bucketFrom(a[d]) < bucketFrom(b[d])
+			}
+			return a[d] < b[d]
+		}
+		return false
+	})
+
+	w := csv.NewWriter(out)
+	header := append(append([]string(nil), dims...), "moves", "blunders", "blunder_rate", "avg_loss")
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		st := results[key]
+		var row []string
+		if len(dims) > 0 {
+			row = strings.Split(key, "\x00")
+		}
+		row = append(row,
+			strconv.Itoa(st.moves),
+			strconv.Itoa(st.blunders),
+			strconv.FormatFloat(float64(st.blunders)/float64(st.moves), 'f', 6, 64),
+			strconv.FormatFloat(st.lossSum/float64(st.moves), 'f', 2, 64),
+		)
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func bucketFrom(bucket string) int {
+	from, _ := strconv.Atoi(bucket[:strings.LastIndex(bucket, "-")])
+	return from
+}
+
+func readParquet(path string, parallel int64) ([]cute.GameRecord, error) {
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fileReader.Close()
+	parquetReader, err := cute.NewGameRecordReader(fileReader, parallel)
+	if err != nil {
+		return nil, err
+	}
+	defer parquetReader.ReadStop()
+
+	num := int(parquetReader.GetNumRows())
+	records := make([]cute.GameRecord, 0, num)
+	const batchSize = 1024
+	for offset := 0; offset < num; offset += batchSize {
+		batch := make([]cute.GameRecord, min(batchSize, num-offset))
+		if err := parquetReader.Read(&batch); err != nil {
+			return nil, err
+		}
+		records = append(records, batch...)
+	}
+	return records, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
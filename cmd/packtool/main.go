@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	cute "cute/pkg/cute"
+)
+
+// packedSize is the size of one record in the packed binary format
+// (Packed256.MarshalBinary).
+const packedSize = 32
+
+// stats counts what happened to the input records.
+type stats struct {
+	read       int
+	written    int
+	duplicates int
+	invalid    int
+}
+
+func main() {
+	mode := flag.String("mode", "pack", "conversion mode: pack (SFEN lines -> binary), unpack (binary -> SFEN lines) or show (SFEN lines -> KIF board diagrams)")
+	inputPath := flag.String("input", "-", "input file (- for stdin)")
+	outputPath := flag.String("output", "-", "output file (- for stdout)")
+	dedup := flag.Bool("dedup", false, "drop duplicate positions (compared by packed form)")
+	validate := flag.Bool("validate", true, "check that every position survives a pack/unpack round trip")
+	skipInvalid := flag.Bool("skip-invalid", false, "skip invalid records instead of failing")
+	moveNumber := flag.Int("move-number", 1, "move number written in unpacked SFEN (packed positions do not store it)")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("packtool", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	in, err := openInput(*inputPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer in.Close()
+	out, err := createOutput(*outputPath)
+	if err != nil {
+		fatal(err)
+	}
+	w := bufio.NewWriter(out)
+
+	var st stats
+	switch *mode {
+	case "pack":
+		err = pack(in, w, *dedup, *validate, *skipInvalid, &st)
+	case "unpack":
+		err = unpack(in, w, *dedup, *skipInvalid, *moveNumber, &st)
+	case "show":
+		err = show(in, w, *skipInvalid, &st)
+	default:
+		err = fmt.Errorf("mode must be pack, unpack or show")
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "read: %d, written: %d, duplicates: %d, invalid: %d\n",
+		st.read, st.written, st.duplicates, st.invalid)
+}
+
+// pack reads newline-delimited SFEN (an optional "sfen " prefix is
+// accepted; blank lines and lines starting with # are ignored) and writes
+// 32-byte packed records.
+func pack(r io.Reader, w io.Writer, dedup, validate, skipInvalid bool, st *stats) error {
+	seen := make(map[cute.Packed256]bool)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "sfen ")
+		st.read++
+
+		packed, err := packSFEN(line, validate)
+		if err != nil {
+			if skipInvalid {
+				st.invalid++
+				fmt.Fprintf(os.Stderr, "line %d: %v\n", lineNo, err)
+				continue
+			}
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if dedup {
+			if seen[packed] {
+				st.duplicates++
+				continue
+			}
+			seen[packed] = true
+		}
+		buf, _ := packed.MarshalBinary()
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		st.written++
+	}
+	return scanner.Err()
+}
+
+// packSFEN packs one SFEN. With validate, the packed form is unpacked again
+// and compared with the canonical SFEN of the parsed position.
+func packSFEN(sfen string, validate bool) (cute.Packed256, error) {
+	pos, err := cute.PositionFromSFEN(sfen)
+	if err != nil {
+		return cute.Packed256{}, err
+	}
+	packed, err := cute.PackPosition256(pos)
+	if err != nil {
+		return cute.Packed256{}, err
+	}
+	if validate {
+		unpacked, err := cute.UnpackPosition256(packed)
+		if err != nil {
+			return cute.Packed256{}, fmt.Errorf("unpack: %w", err)
+		}
+		if got, want := unpacked.ToSFEN(1), pos.ToSFEN(1); got != want {
+			return cute.Packed256{}, fmt.Errorf("round-trip mismatch: got %s want %s", got, want)
+		}
+	}
+	return packed, nil
+}
+
+// unpack reads 32-byte packed records and writes one SFEN per line.
+func unpack(r io.Reader, w io.Writer, dedup, skipInvalid bool, moveNumber int, st *stats) error {
+	seen := make(map[cute.Packed256]bool)
+	br := bufio.NewReader(r)
+	buf := make([]byte, packedSize)
+	for {
+		if _, err := io.ReadFull(br, buf); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return fmt.Errorf("record %d: truncated input (size is not a multiple of %d)", st.read+1, packedSize)
+			}
+			return err
+		}
+		st.read++
+
+		var packed cute.Packed256
+		if err := packed.UnmarshalBinary(buf); err != nil {
+			return err
+		}
+		pos, err := cute.UnpackPosition256(packed)
+		if err != nil {
+			if skipInvalid {
+				st.invalid++
+				fmt.Fprintf(os.Stderr, "record %d: %v\n", st.read, err)
+				continue
+			}
+			return fmt.Errorf("record %d: %w", st.read, err)
+		}
+		if dedup {
+			if seen[packed] {
+				st.duplicates++
+				continue
+			}
+			seen[packed] = true
+		}
+		if _, err := fmt.Fprintln(w, pos.ToSFEN(moveNumber)); err != nil {
+			return err
+		}
+		st.written++
+	}
+}
+
+// show reads SFEN lines like pack and writes each position as a KIF
+// board diagram, preceded by its SFEN and followed by a blank line.
+func show(r io.Reader, w io.Writer, skipInvalid bool, st *stats) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "sfen ")
+		st.read++
+
+		pos, err := cute.PositionFromSFEN(line)
+		if err != nil {
+			if skipInvalid {
+				st.invalid++
+				fmt.Fprintf(os.Stderr, "line %d: %v\n", lineNo, err)
+				continue
+			}
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if _, err := fmt.Fprintf(w, "# %s\n%s\n", line, pos.RenderText()); err != nil {
+			return err
+		}
+		st.written++
+	}
+	return scanner.Err()
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(path)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	cute "cute/pkg/cute"
+)
+
+// checkNames lists the checks in report order.
+var checkNames = []string{
+	cute.ProblemEmptyGameID,
+	problemDuplicateGameID,
+	cute.ProblemUnknownResult,
+	cute.ProblemRatingOutOfRange,
+	cute.ProblemEvalCountMismatch,
+	cute.ProblemEvalIncomplete,
+	cute.ProblemEvalPlyOrder,
+	cute.ProblemUnknownScoreType,
+}
+
+// problemDuplicateGameID marks every record of a game_id after the first.
+const problemDuplicateGameID = "duplicate_game_id"
+
+// checker validates records one at a time and keeps the summary.
+type checker struct {
+	opts     cute.RecordCheckOptions
+	examples int
+
+	games    int
+	bad      int
+	problems map[string]int
+	samples  map[string][]string
+	seen     map[string]bool
+	results  map[string]int
+	// Ratings of 0 mean the KIF had none and are only counted.
+	unrated          int
+	rated            int
+	minSeen, maxSeen int
+}
+
+func newChecker(ratingMin, ratingMax int, sparseEvals bool, examples int) *checker {
+	return &checker{
+		opts:     cute.RecordCheckOptions{RatingMin: ratingMin, RatingMax: ratingMax, SparseEvals: sparseEvals},
+		examples: examples,
+		problems: make(map[string]int),
+		samples:  make(map[string][]string),
+		seen:     make(map[string]bool),
+		results:  make(map[string]int),
+	}
+}
+
+// check records the problems of record and reports whether it has none.
+// Of several records with the same game_id only the first can be clean.
+func (c *checker) check(record cute.GameRecord) bool {
+	c.games++
+	c.results[record.Result]++
+	found := cute.CheckGameRecord(record, c.opts)
+	if record.GameID != "" && c.seen[record.GameID] {
+		found = append(found, problemDuplicateGameID)
+	}
+	c.seen[record.GameID] = true
+	for _, rating := range []int{int(record.SenteRating), int(record.GoteRating)} {
+		if rating == 0 {
+			c.unrated++
+			continue
+		}
+		if c.rated == 0 {
+			c.minSeen, c.maxSeen = rating, rating
+		}
+		c.rated++
+		c.minSeen, c.maxSeen = min(c.minSeen, rating), max(c.maxSeen, rating)
+	}
+
+	for _, name := range found {
+		c.problems[name]++
+		if len(c.samples[name]) < c.examples {
+			c.samples[name] = append(c.samples[name], fmt.Sprintf("%q", record.GameID))
+		}
+	}
+	if len(found) > 0 {
+		c.bad++
+	}
+	return len(found) == 0
+}
+
+// report writes the summary.
+func (c *checker) report(out io.Writer, path string) {
+	fmt.Fprintf(out, "%s: %d games, %d with problems\n", path, c.games, c.bad)
+	for _, name := range checkNames {
+		n := c.problems[name]
+		if n == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "  %-20s %d", name, n)
+		if len(c.samples[name]) > 0 {
+			fmt.Fprintf(out, "  e.g. %s", strings.Join(c.samples[name], ", "))
+		}
+		fmt.Fprintln(out)
+	}
+	if c.rated > 0 {
+		fmt.Fprintf(out, "ratings: %d..%d", c.minSeen, c.maxSeen)
+	} else {
+		fmt.Fprint(out, "ratings: none")
+	}
+	fmt.Fprintf(out, " (%d players without rating)\n", c.unrated)
+
+	results := make([]string, 0, len(c.results))
+	for result := range c.results {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if c.results[results[i]] != c.results[results[j]] {
+			return c.results[results[i]] > c.results[results[j]]
+		}
+		return results[i] < results[j]
+	})
+	parts := make([]string, len(results))
+	for i, result := range results {
+		parts[i] = fmt.Sprintf("%q %d", result, c.results[result])
+	}
+	fmt.Fprintf(out, "results: %s\n", strings.Join(parts, ", "))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	cute "cute/pkg/cute"
+
+	"github.com/xitongsys/parquet-go-source/local"
+)
+
+func main() {
+	input := flag.String("input", "output.parquet", "GameRecord parquet file to check")
+	outputPath := flag.String("output", "", "also write the games without problems to this parquet file (of duplicate game_ids the first is kept)")
+	ratingMin := flag.Int("rating-min", 1, "lowest plausible rating (0 = no rating is always accepted)")
+	ratingMax := flag.Int("rating-max", 4000, "highest plausible rating")
+	sparseEvals := flag.Bool("sparse-evals", false, "accept fewer move_evals than move_count (files written with graph -eval-stride)")
+	examples := flag.Int("examples", 5, "game_ids to show per kind of problem")
+	parallel := flag.Int64("parallel", 4, "parquet reader/writer parallelism")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("parquet-check", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	if *outputPath != "" && sameFile(*input, *outputPath) {
+		fatal(fmt.Errorf("-output must differ from -input"))
+	}
+	// Opening the file checks that it has the GameRecord columns.
+	fileReader, err := local.NewLocalFileReader(*input)
+	if err != nil {
+		fatal(err)
+	}
+	defer fileReader.Close()
+	parquetReader, err := cute.NewGameRecordReader(fileReader, *parallel)
+	if err != nil {
+		fatal(fmt.Errorf("%s: %w", *input, err))
+	}
+	defer parquetReader.ReadStop()
+
+	meta, err := cute.ReadParquetMeta(*input)
+	if err != nil {
+		fatal(err)
+	}
+	c := newChecker(*ratingMin, *ratingMax, *sparseEvals, *examples)
+	var clean chan cute.GameRecord
+	writeErr := make(chan error, 1)
+	if *outputPath != "" {
+		if dir := filepath.Dir(*outputPath); dir != "." {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				fatal(err)
+			}
+		}
+		clean = make(chan cute.GameRecord, *parallel)
+		go func() {
+			err := cute.WriteParquetMeta(*outputPath, clean, *parallel, func() cute.ParquetMeta { return meta })
+			for range clean {
+			}
+			writeErr <- err
+		}()
+	}
+
+	num := int(parquetReader.GetNumRows())
+	batchSize := 1024
+	kept := 0
+	for offset := 0; offset < num; offset += batchSize {
+		batch := make([]cute.GameRecord, min(batchSize, num-offset))
+		if err := parquetReader.Read(&batch); err != nil {
+			fatal(err)
+		}
+		for _, record := range batch {
+			if c.check(record) && clean != nil {
+				clean <- record
+				kept++
+			}
+		}
+	}
+	c.report(os.Stdout, *input)
+	// The analysis commands take the evals for sente's.
+	perspectiveErr := meta.CheckScorePerspective()
+	if perspectiveErr != nil {
+		fmt.Fprintf(os.Stdout, "%s: %v\n", *input, perspectiveErr)
+	}
+
+	if clean != nil {
+		close(clean)
+		if err := <-writeErr; err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "wrote %d games to %s\n", kept, *outputPath)
+		return
+	}
+	if c.bad > 0 || perspectiveErr != nil {
+		os.Exit(1)
+	}
+}
+
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,88 @@
+// Command parquet-merge merges GameRecord parquet files (e.g. the shards
+// or part files of graph runs) into one, keeping one record per game_id.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	cute "cute/pkg/cute"
+)
+
+func main() {
+	outputPath := flag.String("output", "merged.parquet", "merged output file")
+	onConflict := flag.String("on-conflict", string(cute.MergeKeepFirst), "which record of a game_id found in several inputs to keep: keep-first (in argument order) or keep-most-moves (highest move_count, then most evals)")
+	format := flag.String("format", "parquet", "output format: parquet|arrow (Arrow IPC file)")
+	parallel := flag.Int64("parallel", 4, "parquet reader/writer parallelism")
+	compact := flag.Bool("compact-evals", false, "store move_evals packed in a byte array column (much smaller; read transparently by all commands)")
+	dedup := flag.Bool("dedup", false, "also drop games whose players and moves equal a game kept under another game_id (files written before moves_hash existed are not matched)")
+	playerIndex := flag.Bool("player-index", false, "also write a player index (output.players.json) that lets stats and user_threshold_stats -users read only those players' games (parquet only)")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: parquet-merge [-output merged.parquet] [-on-conflict keep-first|keep-most-moves] input.parquet...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("parquet-merge", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	inputs := flag.Args()
+	if len(inputs) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	policy, err := cute.ParseMergePolicy(*onConflict)
+	if err != nil {
+		fatal(err)
+	}
+	if *format != "parquet" && *format != "arrow" {
+		fatal(fmt.Errorf("format must be parquet or arrow"))
+	}
+	for _, in := range inputs {
+		if sameFile(in, *outputPath) {
+			fatal(fmt.Errorf("input %s is also the output", in))
+		}
+	}
+	if dir := filepath.Dir(*outputPath); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			fatal(err)
+		}
+	}
+
+	// Write next to the output and rename, so a failed merge leaves an
+	// existing output untouched.
+	tmp := *outputPath + ".tmp"
+	opts := cute.MergeOptions{Policy: policy, Format: *format, Parallel: *parallel, CompactEvals: *compact, Dedup: *dedup}
+	if *playerIndex && *format == "parquet" {
+		// The index describes the output after the rename, which keeps
+		// the size and modification time it records.
+		opts.PlayerIndex = cute.PlayerIndexPath(*outputPath)
+	}
+	stats, err := cute.MergeParquet(tmp, inputs, opts)
+	if err != nil {
+		os.Remove(tmp)
+		fatal(err)
+	}
+	if err := os.Rename(tmp, *outputPath); err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "merged %d files (%s): %d games, %d duplicate records dropped", len(inputs), policy, stats.Kept, stats.Dropped)
+	if *dedup {
+		fmt.Fprintf(os.Stderr, ", %d duplicate games removed", stats.Deduplicated)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
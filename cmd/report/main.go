@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/plot"
+
+	"github.com/xitongsys/parquet-go-source/local"
+)
+
+// main renders an HTML page per game of a GameRecord parquet (eval graph,
+// move list with blunders highlighted) and an index page linking them.
+func main() {
+	inputPath := flag.String("input", "output.parquet", "input parquet file")
+	kifDir := flag.String("kif-dir", "", "KIF directory the parquet was built from; games whose KIF is found get a move list")
+	outputDir := flag.String("output", "out/report", "output directory (index.html and games/)")
+	threshold := flag.Int("blunder", 300, "highlight moves that lose at least this many cp for their player (0=disabled)")
+	matePlies := flag.Int("mate-plies", 0, "mark moves that miss a forced mate of at most N plies, found by a mate search on the KIF (needs -kif-dir, 0=disabled; keep it small, e.g. 3 or 5)")
+	graphMax := flag.Int("graph-max", 2000, "eval range of the graph in cp; larger evals and mates are clipped to it")
+	thresholdsArg := flag.String("thresholds", "300,500,1000", "comma-separated eval thresholds drawn as lines on the graph")
+	recordFilter := flag.String("record-filter", "", `expr filter on GameRecord fields, as in analyze (e.g. 'move_count > 80 && win_reason == "投了"')`)
+	limit := flag.Int("limit", 0, "render at most N games (0=all)")
+	parallel := flag.Int64("parallel", 4, "parquet read parallelism")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("report", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	if *graphMax <= 0 {
+		fatal(fmt.Errorf("graph-max must be > 0"))
+	}
+	if *threshold < 0 {
+		fatal(fmt.Errorf("blunder must be >= 0"))
+	}
+	thresholds, err := parseIntList(*thresholdsArg)
+	if err != nil {
+		fatal(fmt.Errorf("thresholds: %w", err))
+	}
+	records, err := readParquet(*inputPath, *parallel)
+	if err != nil {
+		fatal(err)
+	}
+	if *recordFilter != "" {
+		program, err := cute.CompileRecordFilter(*recordFilter)
+		if err != nil {
+			fatal(err)
+		}
+		total := len(records)
+		if records, err = cute.FilterRecords(records, program); err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "record filter: %d/%d games match\n", len(records), total)
+	}
+	if *limit > 0 && len(records) > *limit {
+		records = records[:*limit]
+	}
+
+	kifPaths := make(map[string]string)
+	if *kifDir != "" {
+		if err := cute.WalkKIF(*kifDir, func(path string) error {
+			kifPaths[filepath.Base(path)] = path
+			return nil
+		}); err != nil {
+			fatal(err)
+		}
+	}
+
+	gamesDir := filepath.Join(*outputDir, "games")
+	if err := os.MkdirAll(gamesDir, 0o755); err != nil {
+		fatal(err)
+	}
+	pages := make([]gamePage, 0, len(records))
+	for _, record := range records {
+		board, moves := loadMoves(kifPaths[record.GameID])
+		page, err := buildPage(record, board, moves, *threshold, *matePlies, plot.Options{MaxEval: *graphMax, Thresholds: thresholds})
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", record.GameID, err))
+		}
+		if err := writeTemplate(filepath.Join(gamesDir, page.Page), gameTemplate, page); err != nil {
+			fatal(err)
+		}
+		// The index needs only the summary.
+		page.Moves, page.Graph = nil, ""
+		pages = append(pages, page)
+	}
+	index := struct {
+		Title     string
+		Threshold int
+		Games     []gamePage
+	}{filepath.Base(*inputPath), *threshold, pages}
+	if err := writeTemplate(filepath.Join(*outputDir, "index.html"), indexTemplate, index); err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d game pages to %s\n", len(pages), *outputDir)
+}
+
+// loadMoves returns the board of the KIF at path and its move texts. A
+// missing or unreadable KIF yields nil.
+func loadMoves(path string) (*cute.Board, []string) {
+	if path == "" {
+		return nil, nil
+	}
+	board, err := cute.LoadBoardFromKIF(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return nil, nil
+	}
+	moves, err := board.MoveTexts()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return nil, nil
+	}
+	return board, moves
+}
+
+func writeTemplate(path string, t *template.Template, data any) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := t.Execute(f, data); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// readParquet loads all GameRecord rows from a parquet file.
+func readParquet(path string, parallel int64) ([]cute.GameRecord, error) {
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fileReader.Close()
+	parquetReader, err := cute.NewGameRecordReader(fileReader, parallel)
+	if err != nil {
+		return nil, err
+	}
+	defer parquetReader.ReadStop()
+
+	num := int(parquetReader.GetNumRows())
+	records := make([]cute.GameRecord, 0, num)
+	const batchSize = 1024
+	for offset := 0; offset < num; offset += batchSize {
+		batch := make([]cute.GameRecord, min(batchSize, num-offset))
+		if err := parquetReader.Read(&batch); err != nil {
+			return nil, err
+		}
+		records = append(records, batch...)
+	}
+	return records, nil
+}
+
+// parseIntList parses comma-separated integers; blank input yields nil.
+func parseIntList(raw string) ([]int, error) {
+	var values []int
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/plot"
+)
+
+// gamePage is the data of one game page.
+type gamePage struct {
+	Record   cute.GameRecord
+	Page     string
+	Graph    template.HTML // SVG eval curve
+	Moves    []moveRow
+	HasMoves bool
+	// Blunders counts the highlighted moves of each side.
+	SenteBlunders, GoteBlunders int
+	Threshold                   int
+	// MissedMates counts the moves that let a forced mate of at most
+	// MatePlies plies go (0 when the search is disabled).
+	MissedMates, MatePlies int
+}
+
+// moveRow is one line of the move list. Score and Delta (the change from
+// the previous ply) are from sente's perspective.
+type moveRow struct {
+	Ply     int
+	Mark    string // ▲ or △
+	Move    string
+	Score   string
+	Delta   string
+	Blunder bool
+	// Board is the position after a blunder (SVG), when the KIF is known.
+	Board template.HTML
+	// MissedMate is the mating line the move missed, in USI.
+	MissedMate string
+}
+
+// buildPage computes the move list and eval graph of record. board and
+// moves (its KIF move texts) are nil when the KIF was not found. With
+// matePlies > 0 and the KIF known, every move that had a forced mate of at
+// most matePlies plies and played something that does not keep one is
+// marked as a missed mate.
+func buildPage(record cute.GameRecord, board *cute.Board, moves []string, threshold, matePlies int, opts plot.Options) (gamePage, error) {
+	p := gamePage{
+		Record:    record,
+		Page:      pageName(record.GameID),
+		HasMoves:  moves != nil,
+		Threshold: threshold,
+	}
+	if board != nil {
+		p.MatePlies = matePlies
+	}
+	// Handicap games start with gote to move.
+	senteFirst := true
+	if board != nil {
+		initial := board.InitialPosition()
+		senteFirst = initial.Turn() == cute.Black
+	}
+	plies := max(int(record.MoveCount), len(moves))
+	values := make(map[int]int, len(record.MoveEvals))
+	scores := make(map[int]string, len(record.MoveEvals))
+	for _, eval := range record.MoveEvals {
+		ply := int(eval.Ply)
+		plies = max(plies, ply)
+		if eval.ScoreType == "mate" {
+			scores[ply] = fmt.Sprintf("詰%+d", eval.ScoreValue)
+		} else {
+			scores[ply] = fmt.Sprintf("%+d", eval.ScoreValue)
+		}
+		values[ply] = min(max(eval.EffectiveCP(), -opts.MaxEval), opts.MaxEval)
+	}
+
+	opts.Markers = nil
+	for ply := 1; ply <= plies; ply++ {
+		senteMoved := (ply%2 == 1) == senteFirst
+		row := moveRow{Ply: ply, Mark: "△", Score: "-"}
+		if senteMoved {
+			row.Mark = "▲"
+		}
+		if ply <= len(moves) {
+			row.Move = moves[ply-1]
+		}
+		if v, ok := values[ply]; ok {
+			row.Score = scores[ply]
+			if prev, ok := values[ply-1]; ok {
+				row.Delta = fmt.Sprintf("%+d", v-prev)
+				loss := v - prev
+				if senteMoved {
+					loss = -loss
+				}
+				if threshold > 0 && loss >= threshold {
+					row.Blunder = true
+					if senteMoved {
+						p.SenteBlunders++
+					} else {
+						p.GoteBlunders++
+					}
+					opts.Markers = append(opts.Markers, plot.Marker{
+						Ply:   ply,
+						Label: fmt.Sprintf("%d %s%s (%s)", ply, row.Mark, row.Move, row.Delta),
+					})
+				}
+			}
+		}
+		p.Moves = append(p.Moves, row)
+	}
+	if board != nil && (len(opts.Markers) > 0 || p.MatePlies > 0) {
+		usi := board.Moves()
+		if err := board.ForEachPly(func(ply int, pos *cute.Position, move string) error {
+			if ply > 0 && p.Moves[ply-1].Blunder {
+				p.Moves[ply-1].Board = template.HTML(pos.RenderSVGWith(cute.BoardSVGOptions{LastMove: usi[ply-1], Cell: 32}))
+			}
+			if move != "" && p.MatePlies > 0 {
+				if line := missedMate(*pos, move, p.MatePlies); line != nil {
+					p.Moves[ply].MissedMate = strings.Join(line, " ")
+					p.MissedMates++
+				}
+			}
+			return nil
+		}); err != nil {
+			return gamePage{}, err
+		}
+	}
+	svg, err := plot.RenderEvalCurve(record, opts)
+	if err != nil {
+		return gamePage{}, err
+	}
+	p.Graph = template.HTML(svg)
+	return p, nil
+}
+
+// missedMate returns the mate of at most maxPly plies that pos had when
+// move was played instead, or nil when there was none or move keeps a
+// forced mate within the remaining plies.
+func missedMate(pos cute.Position, move string, maxPly int) []string {
+	line, ok := cute.FindMate(pos, maxPly)
+	if !ok || line[0] == move {
+		return nil
+	}
+	after := pos.Clone()
+	if err := after.ApplyMove(move); err != nil || cute.IsForcedMate(after, maxPly-1) {
+		return nil
+	}
+	return line
+}
+
+// pageName is the file name of the page of gameID.
+func pageName(gameID string) string {
+	id := strings.TrimSuffix(gameID, ".kif")
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '?', '#', '%':
+			return '_'
+		}
+		return r
+	}, id) + ".html"
+}
@@ -0,0 +1,77 @@
+package main
+
+import "html/template"
+
+const style = `
+body { font-family: sans-serif; margin: 1.5em; color: #222; }
+table { border-collapse: collapse; }
+th, td { padding: 2px 8px; border-bottom: 1px solid #ddd; text-align: left; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+tr.blunder td { background: #fde2e2; }
+tr.missed-mate td { background: #fff3c4; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 2px 12px; }
+dt { color: #666; }
+`
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>` + style + `</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{len .Games}} games. Blunders: moves losing at least {{.Threshold}} cp for their player.</p>
+<table>
+<tr><th>game</th><th>start</th><th>先手</th><th>後手</th><th>result</th><th>reason</th><th>moves</th><th>blunders ▲/△</th><th>戦型</th></tr>
+{{range .Games}}<tr>
+<td><a href="games/{{.Page}}">{{.Record.GameID}}</a></td>
+<td>{{.Record.StartTime}}</td>
+<td>{{.Record.SenteName}} ({{.Record.SenteRating}})</td>
+<td>{{.Record.GoteName}} ({{.Record.GoteRating}})</td>
+<td>{{.Record.Result}}</td>
+<td>{{.Record.WinReason}}</td>
+<td class="num">{{.Record.MoveCount}}</td>
+<td class="num">{{.SenteBlunders}}/{{.GoteBlunders}}</td>
+<td>{{.Record.SenteAttackTags}}{{if .Record.GoteAttackTags}} vs {{.Record.GoteAttackTags}}{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var gameTemplate = template.Must(template.New("game").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<title>{{.Record.GameID}}</title>
+<style>` + style + `</style>
+</head>
+<body>
+<p><a href="../index.html">index</a></p>
+<h1>{{.Record.GameID}}</h1>
+<dl>
+<dt>先手</dt><dd>{{.Record.SenteName}} ({{.Record.SenteRating}}){{if .Record.SenteAttackTags}} {{.Record.SenteAttackTags}}{{end}}{{if .Record.SenteDefenseTags}} / {{.Record.SenteDefenseTags}}{{end}}</dd>
+<dt>後手</dt><dd>{{.Record.GoteName}} ({{.Record.GoteRating}}){{if .Record.GoteAttackTags}} {{.Record.GoteAttackTags}}{{end}}{{if .Record.GoteDefenseTags}} / {{.Record.GoteDefenseTags}}{{end}}</dd>
+<dt>結果</dt><dd>{{.Record.Result}} ({{.Record.WinReason}}, {{.Record.MoveCount}}手)</dd>
+{{if .Record.StartTime}}<dt>開始日時</dt><dd>{{.Record.StartTime}}</dd>{{end}}
+{{if .Record.TimeControl}}<dt>持ち時間</dt><dd>{{.Record.TimeControl}}</dd>{{end}}
+<dt>悪手</dt><dd>▲{{.SenteBlunders}} △{{.GoteBlunders}} (-{{.Threshold}} cp 以上)</dd>
+{{if .MatePlies}}<dt>詰み逃し</dt><dd>{{.MissedMates}} ({{.MatePlies}}手以内の詰み)</dd>{{end}}
+</dl>
+{{.Graph}}
+<table>
+<tr><th>手数</th><th>指し手</th><th>評価値</th><th>変化</th><th></th></tr>
+{{range .Moves}}<tr{{if .Blunder}} class="blunder"{{else if .MissedMate}} class="missed-mate"{{end}}>
+<td class="num">{{.Ply}}</td>
+<td>{{.Mark}}{{.Move}}{{if .MissedMate}} <small>詰み逃し: {{.MissedMate}}</small>{{end}}</td>
+<td class="num">{{.Score}}</td>
+<td class="num">{{.Delta}}</td>
+<td>{{if .Board}}<details><summary>局面</summary>{{.Board}}</details>{{end}}</td>
+</tr>
+{{end}}</table>
+{{if not .HasMoves}}<p>KIF not found: moves are not listed (pass -kif-dir).</p>{{end}}
+</body>
+</html>
+`))
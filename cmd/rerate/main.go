@@ -0,0 +1,256 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/rating"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// historyRow is one player's side of a rated game.
+type historyRow struct {
+	GameID               string  `parquet:"name=game_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	StartTime            string  `parquet:"name=start_time, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Seq                  int64   `parquet:"name=seq, type=INT64"`
+	Player               string  `parquet:"name=player, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Side                 string  `parquet:"name=side, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Opponent             string  `parquet:"name=opponent, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Score                float64 `parquet:"name=score, type=DOUBLE"`
+	SiteRating           int32   `parquet:"name=site_rating, type=INT32"`
+	RatingBefore         float64 `parquet:"name=rating_before, type=DOUBLE"`
+	RatingAfter          float64 `parquet:"name=rating_after, type=DOUBLE"`
+	DeviationAfter       float64 `parquet:"name=deviation_after, type=DOUBLE"`
+	VolatilityAfter      float64 `parquet:"name=volatility_after, type=DOUBLE"`
+	OpponentRatingBefore float64 `parquet:"name=opponent_rating_before, type=DOUBLE"`
+	PlayerGames          int32   `parquet:"name=player_games, type=INT32"`
+}
+
+func main() {
+	input := flag.String("input", "output.parquet", "input parquet file")
+	outputPath := flag.String("output", "out/ratings.parquet", "rating history output path")
+	format := flag.String("format", "parquet", "output format: parquet|arrow (Arrow IPC file)")
+	system := flag.String("system", "glicko2", "rating system: elo|glicko2")
+	initial := flag.Float64("initial", rating.DefaultInitial, "initial rating")
+	k := flag.Float64("k", rating.DefaultK, "elo: K-factor")
+	deviation := flag.Float64("deviation", rating.DefaultDeviation, "glicko2: initial rating deviation")
+	volatility := flag.Float64("volatility", rating.DefaultVolatility, "glicko2: initial volatility")
+	tau := flag.Float64("tau", rating.DefaultTau, "glicko2: volatility constraint")
+	top := flag.Int("top", 20, "print the N highest rated players (0=none)")
+	minGames := flag.Int("min-games", 10, "minimum games for a player to be printed")
+	parallel := flag.Int64("parallel", 4, "parquet read/write parallelism")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("rerate", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	var rater rating.System
+	switch *system {
+	case "elo":
+		if *k <= 0 {
+			fatal(fmt.Errorf("k must be > 0"))
+		}
+		rater = rating.NewElo(rating.EloOptions{K: *k, Initial: *initial})
+	case "glicko2":
+		if *deviation <= 0 || *volatility <= 0 || *tau <= 0 {
+			fatal(fmt.Errorf("deviation, volatility and tau must be > 0"))
+		}
+		rater = rating.NewGlicko2(rating.Glicko2Options{Initial: *initial, Deviation: *deviation, Volatility: *volatility, Tau: *tau})
+	default:
+		fatal(fmt.Errorf("system must be elo or glicko2"))
+	}
+	if *format != "parquet" && *format != "arrow" {
+		fatal(fmt.Errorf("format must be parquet or arrow"))
+	}
+
+	games, siteRatings, skipped, err := readGames(*input, *parallel)
+	if err != nil {
+		fatal(err)
+	}
+	rating.SortGames(games)
+	fmt.Fprintf(os.Stderr, "rated games: %d (skipped without result or players: %d)\n", len(games), skipped)
+
+	if dir := filepath.Dir(*outputPath); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			fatal(err)
+		}
+	}
+	out, err := newRowWriter(*format, *outputPath, *parallel)
+	if err != nil {
+		fatal(err)
+	}
+
+	var writeErr error
+	seq := int64(0)
+	rating.Replay(games, rater, func(game rating.Game, before, after [2]rating.Rating) {
+		if writeErr != nil {
+			return
+		}
+		seq++
+		site := siteRatings[game.ID]
+		for i, side := range []struct {
+			name, opponent, side string
+			score                float64
+		}{
+			{game.Sente, game.Gote, "sente", game.Score},
+			{game.Gote, game.Sente, "gote", 1 - game.Score},
+		} {
+			row := historyRow{
+				GameID:               game.ID,
+				StartTime:            game.StartTime,
+				Seq:                  seq,
+				Player:               side.name,
+				Side:                 side.side,
+				Opponent:             side.opponent,
+				Score:                side.score,
+				SiteRating:           site[i],
+				RatingBefore:         before[i].Rating,
+				RatingAfter:          after[i].Rating,
+				DeviationAfter:       after[i].Deviation,
+				VolatilityAfter:      after[i].Volatility,
+				OpponentRatingBefore: before[1-i].Rating,
+				PlayerGames:          int32(after[i].Games),
+			}
+			if err := out.Write(row); err != nil {
+				writeErr = err
+				return
+			}
+		}
+	})
+	if writeErr != nil {
+		fatal(writeErr)
+	}
+	if err := out.Close(); err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d rows to %s\n", seq*2, *outputPath)
+
+	if *top > 0 {
+		printTop(games, rater, *top, *minGames)
+	}
+}
+
+// rowWriter writes historyRows as parquet or as an Arrow IPC file.
+type rowWriter interface {
+	Write(row any) error
+	Close() error
+}
+
+type parquetRowWriter struct {
+	file source.ParquetFile
+	pw   *writer.ParquetWriter
+}
+
+func (p *parquetRowWriter) Write(row any) error { return p.pw.Write(row) }
+
+func (p *parquetRowWriter) Close() error {
+	if err := p.pw.WriteStop(); err != nil {
+		p.file.Close()
+		return err
+	}
+	return p.file.Close()
+}
+
+func newRowWriter(format, path string, parallel int64) (rowWriter, error) {
+	if format == "arrow" {
+		return cute.NewArrowWriter(path, historyRow{}, nil)
+	}
+	file, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := writer.NewParquetWriter(file, new(historyRow), parallel)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetRowWriter{file: file, pw: pw}, nil
+}
+
+// readGames returns the rated games of path and the site ratings of both
+// players by game ID.
+func readGames(path string, parallel int64) ([]rating.Game, map[string][2]int32, int, error) {
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer fileReader.Close()
+	parquetReader, err := cute.NewGameRecordReader(fileReader, parallel)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer parquetReader.ReadStop()
+
+	num := int(parquetReader.GetNumRows())
+	games := make([]rating.Game, 0, num)
+	siteRatings := make(map[string][2]int32, num)
+	skipped := 0
+	batchSize := 1024
+	for offset := 0; offset < num; offset += batchSize {
+		remain := num - offset
+		if remain < batchSize {
+			batchSize = remain
+		}
+		batch := make([]cute.GameRecord, batchSize)
+		if err := parquetReader.Read(&batch); err != nil {
+			return nil, nil, 0, err
+		}
+		for _, record := range batch {
+			game, ok := rating.GameFromRecord(record)
+			if !ok {
+				skipped++
+				continue
+			}
+			games = append(games, game)
+			siteRatings[game.ID] = [2]int32{record.SenteRating, record.GoteRating}
+		}
+	}
+	return games, siteRatings, skipped, nil
+}
+
+// printTop prints the final ratings of the n highest rated players with at
+// least minGames games as CSV.
+func printTop(games []rating.Game, rater rating.System, n, minGames int) {
+	seen := make(map[string]bool)
+	var players []string
+	for _, game := range games {
+		for _, name := range []string{game.Sente, game.Gote} {
+			if !seen[name] {
+				seen[name] = true
+				if rater.Rating(name).Games >= minGames {
+					players = append(players, name)
+				}
+			}
+		}
+	}
+	sort.Slice(players, func(i, j int) bool {
+		ri, rj := rater.Rating(players[i]).Rating, rater.Rating(players[j]).Rating
+		if ri != rj {
+			return ri > rj
+		}
+		return players[i] < players[j]
+	})
+	if len(players) > n {
+		players = players[:n]
+	}
+	fmt.Println("player,rating,deviation,games")
+	for _, name := range players {
+		r := rater.Rating(name)
+		fmt.Printf("%s,%.1f,%.1f,%d\n", name, r.Rating, r.Deviation, r.Games)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
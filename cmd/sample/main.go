@@ -0,0 +1,209 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/openingdb"
+
+	"github.com/xitongsys/parquet-go-source/local"
+)
+
+// strataDimensions are the values accepted by -strata.
+var strataDimensions = map[string]bool{
+	"rating_bucket": true, // mean rating of both players (-bin-size)
+	"result":        true, // GameRecord.Result
+	"opening":       true, // first attack tags of sente and gote
+}
+
+// main draws a stratified random subset of a GameRecord parquet, for quick
+// experiments and reproducible logreg runs on a smaller file.
+func main() {
+	input := flag.String("input", "output.parquet", "input parquet file")
+	output := flag.String("output", "sample.parquet", "output file")
+	format := flag.String("format", "parquet", "output format: parquet|arrow (Arrow IPC file)")
+	n := flag.Int("n", 0, "draw this many games in total, allocated to the strata in proportion to their sizes")
+	fraction := flag.Float64("fraction", 0, "draw this share (0-1] of every stratum")
+	perStratum := flag.Int("per-stratum", 0, "draw this many games from every stratum (all of a smaller one), balancing the strata")
+	strataArg := flag.String("strata", "rating_bucket,result", "comma-separated strata dimensions: rating_bucket, result, opening (empty = no strata)")
+	binSize := flag.Int("bin-size", 200, "rating bucket size of -strata rating_bucket")
+	seed := flag.Int64("seed", 1, "random seed; the same input and flags always give the same sample")
+	openingDB := flag.String("opening-db", "", "strategy classification parquet file for -strata opening (default: the tag columns embedded by graph)")
+	recordFilter := flag.String("record-filter", "", `expr filter on GameRecord fields applied before sampling, as in analyze (e.g. 'move_count > 80 && win_reason == "投了"')`)
+	parallel := flag.Int64("parallel", 4, "parquet read parallelism")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("sample", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	if *format != "parquet" && *format != "arrow" {
+		fatal(fmt.Errorf("format must be parquet or arrow"))
+	}
+	if *binSize <= 0 {
+		fatal(fmt.Errorf("bin-size must be > 0"))
+	}
+	dims, err := parseStrata(*strataArg)
+	if err != nil {
+		fatal(err)
+	}
+	if sameFile(*input, *output) {
+		fatal(fmt.Errorf("input %s is also the output", *input))
+	}
+
+	records, err := readParquet(*input, *parallel)
+	if err != nil {
+		fatal(err)
+	}
+	if *recordFilter != "" {
+		program, err := cute.CompileRecordFilter(*recordFilter)
+		if err != nil {
+			fatal(err)
+		}
+		total := len(records)
+		if records, err = cute.FilterRecords(records, program); err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "record filter: %d/%d games match\n", len(records), total)
+	}
+	var openings map[string]openingdb.Game
+	if *openingDB != "" {
+		if openings, err = openingdb.Load(*openingDB, *parallel); err != nil {
+			fatal(fmt.Errorf("opening-db: %w", err))
+		}
+	}
+
+	stratum := func(r cute.GameRecord) string {
+		values := make([]string, len(dims))
+		for i, dim := range dims {
+			switch dim {
+			case "rating_bucket":
+				rating := float64(r.SenteRating+r.GoteRating) / 2
+				from := int(math.Floor(rating/float64(*binSize))) * *binSize
+				values[i] = fmt.Sprintf("%d-%d", from, from+*binSize)
+			case "result":
+				values[i] = r.Result
+			case "opening":
+				game := openingdb.FromGameRecord(r)
+				if openings != nil {
+					game = openings[openingdb.NormalizeGameID(r.GameID)]
+				}
+				values[i] = firstTag(game.Sente.Attack) + " vs " + firstTag(game.Gote.Attack)
+			}
+		}
+		return strings.Join(values, ",")
+	}
+	sample, strata, err := cute.SampleRecords(records, cute.SampleOptions{
+		Stratum:    stratum,
+		N:          *n,
+		Fraction:   *fraction,
+		PerStratum: *perStratum,
+		Seed:       *seed,
+	})
+	if err != nil {
+		fatal(fmt.Errorf("set exactly one of -n, -fraction (0-1] and -per-stratum"))
+	}
+	if len(dims) > 0 {
+		sort.SliceStable(strata, func(i, j int) bool { return strata[i].Available > strata[j].Available })
+		fmt.Fprintf(os.Stderr, "%-30s %9s %7s\n", strings.Join(dims, ","), "available", "drawn")
+		for _, st := range strata {
+			fmt.Fprintf(os.Stderr, "%-30s %9d %7d\n", st.Key, st.Available, st.Drawn)
+		}
+	}
+
+	// Keep the engine setups of the input, so the sample says where its
+	// evals come from.
+	meta, err := cute.ReadParquetMeta(*input)
+	if err != nil {
+		fatal(err)
+	}
+	ch := make(chan cute.GameRecord)
+	go func() {
+		defer close(ch)
+		for _, r := range sample {
+			ch <- r
+		}
+	}()
+	metaFn := func() cute.ParquetMeta { return meta }
+	if *format == "arrow" {
+		err = cute.WriteArrowIPCMeta(*output, ch, metaFn)
+	} else {
+		err = cute.WriteParquetMeta(*output, ch, *parallel, metaFn)
+	}
+	if err != nil {
+		// Drain the channel so the sender goroutine can finish.
+		for range ch {
+		}
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "sampled %d/%d games (seed %d) into %s\n", len(sample), len(records), *seed, *output)
+}
+
+// parseStrata validates a comma-separated -strata list.
+func parseStrata(raw string) ([]string, error) {
+	var dims []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strataDimensions[part] {
+			return nil, fmt.Errorf("unknown -strata dimension %q (available: opening, rating_bucket, result)", part)
+		}
+		if !seen[part] {
+			seen[part] = true
+			dims = append(dims, part)
+		}
+	}
+	return dims, nil
+}
+
+func firstTag(tags []string) string {
+	if len(tags) == 0 {
+		return "-"
+	}
+	return tags[0]
+}
+
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+func readParquet(path string, parallel int64) ([]cute.GameRecord, error) {
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fileReader.Close()
+	parquetReader, err := cute.NewGameRecordReader(fileReader, parallel)
+	if err != nil {
+		return nil, err
+	}
+	defer parquetReader.ReadStop()
+
+	num := int(parquetReader.GetNumRows())
+	records := make([]cute.GameRecord, 0, num)
+	const batchSize = 1024
+	for offset := 0; offset < num; offset += batchSize {
+		batch := make([]cute.GameRecord, min(batchSize, num-offset))
+		if err := parquetReader.Read(&batch); err != nil {
+			return nil, err
+		}
+		records = append(records, batch...)
+	}
+	return records, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
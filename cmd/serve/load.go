@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+
+	cute "cute/pkg/cute"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/xitongsys/parquet-go-source/local"
+)
+
+// readParquet loads all GameRecord rows from a parquet file.
+func readParquet(path string, parallel int64) ([]cute.GameRecord, error) {
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fileReader.Close()
+	parquetReader, err := cute.NewGameRecordReader(fileReader, parallel)
+	if err != nil {
+		return nil, err
+	}
+	defer parquetReader.ReadStop()
+
+	num := int(parquetReader.GetNumRows())
+	records := make([]cute.GameRecord, 0, num)
+	const batchSize = 1024
+	for offset := 0; offset < num; offset += batchSize {
+		batch := make([]cute.GameRecord, min(batchSize, num-offset))
+		if err := parquetReader.Read(&batch); err != nil {
+			return nil, err
+		}
+		records = append(records, batch...)
+	}
+	return records, nil
+}
+
+// readSQLite loads the games of a database written by export-sqlite.
+func readSQLite(path string) ([]cute.GameRecord, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT g.game_id, coalesce(s.name, ''), g.sente_rating, coalesce(o.name, ''), g.gote_rating,
+			g.result, g.win_reason, g.move_count,
+			coalesce(g.sente_attack_tags, ''), coalesce(g.sente_defense_tags, ''),
+			coalesce(g.gote_attack_tags, ''), coalesce(g.gote_defense_tags, ''),
+			coalesce(g.start_time, ''), coalesce(g.end_time, ''), coalesce(g.time_control, '')
+		FROM games g LEFT JOIN players s ON s.id = g.sente_id LEFT JOIN players o ON o.id = g.gote_id
+		ORDER BY g.rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []cute.GameRecord
+	index := make(map[string]int)
+	for rows.Next() {
+		var r cute.GameRecord
+		if err := rows.Scan(&r.GameID, &r.SenteName, &r.SenteRating, &r.GoteName, &r.GoteRating,
+			&r.Result, &r.WinReason, &r.MoveCount,
+			&r.SenteAttackTags, &r.SenteDefenseTags, &r.GoteAttackTags, &r.GoteDefenseTags,
+			&r.StartTime, &r.EndTime, &r.TimeControl); err != nil {
+			return nil, err
+		}
+		index[r.GameID] = len(records)
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Databases exported before move_evals.depth was added lack it.
+	depth := "0"
+	if ok, err := hasColumn(db, "move_evals", "depth"); err != nil {
+		return nil, err
+	} else if ok {
+		depth = "depth"
+	}
+	evals, err := db.Query(`SELECT game_id, ply, score_type, score_value, ` + depth + ` FROM move_evals ORDER BY game_id, ply`)
+	if err != nil {
+		return nil, err
+	}
+	defer evals.Close()
+	for evals.Next() {
+		var id string
+		var eval cute.MoveEval
+		if err := evals.Scan(&id, &eval.Ply, &eval.ScoreType, &eval.ScoreValue, &eval.Depth); err != nil {
+			return nil, err
+		}
+		if i, ok := index[id]; ok {
+			records[i].MoveEvals = append(records[i].MoveEvals, eval)
+		}
+	}
+	return records, evals.Err()
+}
+
+// hasColumn reports whether table has column.
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cute "cute/pkg/cute"
+)
+
+// main loads a GameRecord dataset into memory and serves it over HTTP:
+//
+//	GET /                       dataset summary
+//	GET /games/{id}             one game with its evals
+//	GET /players/{name}/stats   per-player results and crossing stats
+//	GET /analyze                crossing win rates per threshold and rating bucket
+func main() {
+	inputPath := flag.String("input", "output.parquet", "GameRecord parquet file, or SQLite database written by export-sqlite (.sqlite, .sqlite3 or .db)")
+	addr := flag.String("addr", "localhost:8080", "listen address")
+	parallel := flag.Int64("parallel", 4, "parquet read parallelism")
+	ignoreFirstMoves := flag.Int("ignore-first-moves", 0, "default of the ignore_first_moves query parameter")
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
+	flag.Parse()
+	if _, err := cute.LoadCommandConfig("serve", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
+
+	start := time.Now()
+	var (
+		records []cute.GameRecord
+		err     error
+	)
+	if isSQLite(*inputPath) {
+		records, err = readSQLite(*inputPath)
+	} else {
+		records, err = readParquet(*inputPath, *parallel)
+	}
+	if err != nil {
+		fatal(fmt.Errorf("%s: %w", *inputPath, err))
+	}
+	s := newServer(*inputPath, records, *ignoreFirstMoves)
+	fmt.Fprintf(os.Stderr, "loaded %d games, %d players in %s\n", len(s.records), len(s.players), time.Since(start).Round(time.Millisecond))
+
+	fmt.Fprintf(os.Stderr, "listening on http://%s\n", *addr)
+	if err := http.ListenAndServe(*addr, s.handler()); err != nil {
+		fatal(err)
+	}
+}
+
+func isSQLite(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".sqlite", ".sqlite3", ".db":
+		return true
+	}
+	return false
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/plot"
+)
+
+// server holds the dataset in memory; it is read-only after newServer, so
+// requests are served concurrently without locking.
+type server struct {
+	source  string
+	records []cute.GameRecord
+	// games maps normalized game_id to the index in records.
+	games map[string]int
+	// players maps a player name to the indexes of their games.
+	players          map[string][]int
+	ignoreFirstMoves int
+}
+
+func newServer(source string, records []cute.GameRecord, ignoreFirstMoves int) *server {
+	s := &server{
+		source:           source,
+		records:          records,
+		games:            make(map[string]int, len(records)),
+		players:          make(map[string][]int),
+		ignoreFirstMoves: ignoreFirstMoves,
+	}
+	for i, r := range records {
+		s.games[normalizeGameID(r.GameID)] = i
+		if r.SenteName != "" {
+			s.players[r.SenteName] = append(s.players[r.SenteName], i)
+		}
+		if r.GoteName != "" && r.GoteName != r.SenteName {
+			s.players[r.GoteName] = append(s.players[r.GoteName], i)
+		}
+	}
+	return s
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.handleSummary)
+	mux.HandleFunc("GET /games/{id}", s.handleGame)
+	mux.HandleFunc("GET /games/{id}/eval.svg", s.handleEvalCurve(plot.FormatSVG))
+	mux.HandleFunc("GET /games/{id}/eval.png", s.handleEvalCurve(plot.FormatPNG))
+	mux.HandleFunc("GET /players/{name}/stats", s.handlePlayerStats)
+	mux.HandleFunc("GET /analyze", s.handleAnalyze)
+	return mux
+}
+
+func (s *server) handleSummary(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"source":  s.source,
+		"games":   len(s.records),
+		"players": len(s.players),
+	})
+}
+
+// gameResponse is a GameRecord with the parquet column names.
+type gameResponse struct {
+	GameID           string         `json:"game_id"`
+	SenteName        string         `json:"sente_name"`
+	SenteRating      int32          `json:"sente_rating"`
+	GoteName         string         `json:"gote_name"`
+	GoteRating       int32          `json:"gote_rating"`
+	Result           string         `json:"result"`
+	WinReason        string         `json:"win_reason"`
+	MoveCount        int32          `json:"move_count"`
+	StartTime        string         `json:"start_time,omitempty"`
+	EndTime          string         `json:"end_time,omitempty"`
+	TimeControl      string         `json:"time_control,omitempty"`
+	SenteAttackTags  string         `json:"sente_attack_tags,omitempty"`
+	SenteDefenseTags string         `json:"sente_defense_tags,omitempty"`
+	GoteAttackTags   string         `json:"gote_attack_tags,omitempty"`
+	GoteDefenseTags  string         `json:"gote_defense_tags,omitempty"`
+	MoveEvals        []evalResponse `json:"move_evals"`
+}
+
+type evalResponse struct {
+	Ply        int32  `json:"ply"`
+	ScoreType  string `json:"score_type"`
+	ScoreValue int32  `json:"score_value"`
+	Depth      int32  `json:"depth"`
+}
+
+func (s *server) handleGame(w http.ResponseWriter, r *http.Request) {
+	i, ok := s.games[normalizeGameID(r.PathValue("id"))]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("game %q not found", r.PathValue("id")))
+		return
+	}
+	g := s.records[i]
+	resp := gameResponse{
+		GameID:           g.GameID,
+		SenteName:        g.SenteName,
+		SenteRating:      g.SenteRating,
+		GoteName:         g.GoteName,
+		GoteRating:       g.GoteRating,
+		Result:           g.Result,
+		WinReason:        g.WinReason,
+		MoveCount:        g.MoveCount,
+		StartTime:        g.StartTime,
+		EndTime:          g.EndTime,
+		TimeControl:      g.TimeControl,
+		SenteAttackTags:  g.SenteAttackTags,
+		SenteDefenseTags: g.SenteDefenseTags,
+		GoteAttackTags:   g.GoteAttackTags,
+		GoteDefenseTags:  g.GoteDefenseTags,
+		MoveEvals:        make([]evalResponse, len(g.MoveEvals)),
+	}
+	for j, e := range g.MoveEvals {
+		resp.MoveEvals[j] = evalResponse{Ply: e.Ply, ScoreType: e.ScoreType, ScoreValue: e.ScoreValue, Depth: e.Depth}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleEvalCurve renders the eval curve of a game. Query parameters:
+// threshold (comma-separated lines), max_eval, width and height.
+func (s *server) handleEvalCurve(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		i, ok := s.games[normalizeGameID(r.PathValue("id"))]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("game %q not found", r.PathValue("id")))
+			return
+		}
+		opts := plot.Options{Format: format}
+		var err error
+		if opts.Thresholds, err = parseIntList(r.URL.Query().Get("threshold")); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("threshold: %w", err))
+			return
+		}
+		for name, dst := range map[string]*int{"max_eval": &opts.MaxEval, "width": &opts.Width, "height": &opts.Height} {
+			if *dst, err = intParam(r, name, 0); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		out, err := plot.RenderEvalCurve(s.records[i], opts)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if format == plot.FormatPNG {
+			w.Header().Set("Content-Type", "image/png")
+		} else {
+			w.Header().Set("Content-Type", "image/svg+xml")
+		}
+		w.Write(out)
+	}
+}
+
+// playerStats is the response of /players/{name}/stats. Crossings count
+// the games in which the player's side crossed the threshold first;
+// comebacks the wins after the opponent did.
+type playerStats struct {
+	Player            string     `json:"player"`
+	Games             int        `json:"games"`
+	Wins              int        `json:"wins"`
+	Losses            int        `json:"losses"`
+	Draws             int        `json:"draws"`
+	WinRate           float64    `json:"win_rate"`
+	SenteGames        int        `json:"sente_games"`
+	GoteGames         int        `json:"gote_games"`
+	AvgRating         float64    `json:"avg_rating"`
+	LastRating        int32      `json:"last_rating"`
+	FirstGame         string     `json:"first_game,omitempty"`
+	LastGame          string     `json:"last_game,omitempty"`
+	Threshold         int        `json:"threshold"`
+	Crossings         int        `json:"crossings"`
+	CrossingWins      int        `json:"crossing_wins"`
+	CrossingWinRate   float64    `json:"crossing_win_rate"`
+	OpponentCrossings int        `json:"opponent_crossings"`
+	Comebacks         int        `json:"comebacks"`
+	ComebackRate      float64    `json:"comeback_rate"`
+	AttackTags        []tagCount `json:"attack_tags"`
+}
+
+type tagCount struct {
+	Tag   string `json:"tag"`
+	Games int    `json:"games"`
+}
+
+func (s *server) handlePlayerStats(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	games, ok := s.players[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("player %q not found", name))
+		return
+	}
+	threshold, err := intParam(r, "threshold", 500)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ignoreFirstMoves, err := intParam(r, "ignore_first_moves", s.ignoreFirstMoves)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	st := playerStats{Player: name, Threshold: threshold, AttackTags: []tagCount{}}
+	tags := make(map[string]int)
+	var ratingSum int64
+	var ratingCount int
+	lastTime := ""
+	for _, i := range games {
+		record := s.records[i]
+		side, rating, attack := "sente", record.SenteRating, record.SenteAttackTags
+		if record.SenteName != name {
+			side, rating, attack = "gote", record.GoteRating, record.GoteAttackTags
+		}
+		st.Games++
+		if side == "sente" {
+			st.SenteGames++
+		} else {
+			st.GoteGames++
+		}
+		resultSide := winnerSide(record.Result)
+		switch {
+		case resultSide == side:
+			st.Wins++
+		case resultSide != "none":
+			st.Losses++
+		case record.Result == "draw":
+			st.Draws++
+		}
+		if rating > 0 {
+			ratingSum += int64(rating)
+			ratingCount++
+			// Games without a start time keep file order.
+			if record.StartTime >= lastTime {
+				st.LastRating, lastTime = rating, record.StartTime
+			}
+		}
+		if record.StartTime != "" {
+			if st.FirstGame == "" || record.StartTime < st.FirstGame {
+				st.FirstGame = record.StartTime
+			}
+			if record.StartTime > st.LastGame {
+				st.LastGame = record.StartTime
+			}
+		}
+		for _, tag := range splitTags(attack) {
+			tags[tag]++
+		}
+
+		crossingSide := cute.FirstCrossing(record.MoveEvals, cute.CrossingOptions{
+			Threshold:         threshold,
+			IgnorePlies:       ignoreFirstMoves,
+			MateCountsAsCross: true,
+		}).Side
+		if crossingSide == "none" || resultSide == "none" {
+			continue
+		}
+		if crossingSide == side {
+			st.Crossings++
+			if resultSide == side {
+				st.CrossingWins++
+			}
+		} else {
+			st.OpponentCrossings++
+			if resultSide == side {
+				st.Comebacks++
+			}
+		}
+	}
+	st.WinRate = rate(st.Wins, st.Wins+st.Losses)
+	st.CrossingWinRate = rate(st.CrossingWins, st.Crossings)
+	st.ComebackRate = rate(st.Comebacks, st.OpponentCrossings)
+	if ratingCount > 0 {
+		st.AvgRating = float64(ratingSum) / float64(ratingCount)
+	}
+	for tag, n := range tags {
+		st.AttackTags = append(st.AttackTags, tagCount{Tag: tag, Games: n})
+	}
+	sort.Slice(st.AttackTags, func(i, j int) bool {
+		if st.AttackTags[i].Games != st.AttackTags[j].Games {
+			return st.AttackTags[i].Games > st.AttackTags[j].Games
+		}
+		return st.AttackTags[i].Tag < st.AttackTags[j].Tag
+	})
+	writeJSON(w, http.StatusOK, st)
+}
+
+// analyzeRow matches the json rows of analyze -format json.
+type analyzeRow struct {
+	Threshold     int     `json:"threshold"`
+	BucketFrom    int     `json:"bucket_from"`
+	BucketTo      int     `json:"bucket_to"`
+	TotalGames    int     `json:"total_games"`
+	DecisiveGames int     `json:"decisive_games"`
+	Crossings     int     `json:"crossings"`
+	CrossingRate  float64 `json:"crossing_rate"`
+	Wins          int     `json:"wins"`
+	WinRate       float64 `json:"win_rate"`
+	ExcludedGames int     `json:"excluded_games"`
+}
+
+type analyzeResponse struct {
+	Games int          `json:"games"`
+	Rows  []analyzeRow `json:"rows"`
+}
+
+// handleAnalyze computes the crossing table of analyze (-mode crossing,
+// no opening filter) over the games matching the filter parameter, a
+// -record-filter expression.
+func (s *server) handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	thresholds, err := parseIntList(q.Get("threshold"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("threshold: %w", err))
+		return
+	}
+	if len(thresholds) == 0 {
+		thresholds = []int{300, 500, 1000}
+	}
+	var ratingDiffMax, binSize, ignoreFirstMoves, playerMin, playerMax int
+	for name, p := range map[string]struct {
+		dst *int
+		def int
+	}{
+		"rating_diff_max":    {&ratingDiffMax, 50},
+		"bin_size":           {&binSize, 100},
+		"ignore_first_moves": {&ignoreFirstMoves, s.ignoreFirstMoves},
+		"player_min":         {&playerMin, 0},
+		"player_max":         {&playerMax, 0},
+	} {
+		if *p.dst, err = intParam(r, name, p.def); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if binSize <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bin_size must be > 0"))
+		return
+	}
+
+	records := s.records
+	if filter := q.Get("filter"); filter != "" {
+		program, err := cute.CompileRecordFilter(filter)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		// FilterRecords reuses the backing array, which is shared.
+		if records, err = cute.FilterRecords(append([]cute.GameRecord(nil), s.records...), program); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	minRating, maxRating := ratingMinMax(records)
+	if playerMin > 0 {
+		minRating = playerMin
+	}
+	if playerMax > 0 {
+		maxRating = playerMax
+	}
+	var rows []analyzeRow
+	for _, threshold := range thresholds {
+		for from := minRating; from <= maxRating; from += binSize {
+			rows = append(rows, analyzeRow{Threshold: threshold, BucketFrom: from, BucketTo: from + binSize})
+		}
+	}
+	for _, record := range records {
+		if int(math.Abs(float64(record.SenteRating-record.GoteRating))) > ratingDiffMax {
+			continue
+		}
+		resultSide := winnerSide(record.Result)
+		crossings := make(map[int]string, len(thresholds))
+		for _, threshold := range thresholds {
+			crossings[threshold] = cute.FirstCrossing(record.MoveEvals, cute.CrossingOptions{
+				Threshold:         threshold,
+				IgnorePlies:       ignoreFirstMoves,
+				MateCountsAsCross: true,
+			}).Side
+		}
+		for i := range rows {
+			row := &rows[i]
+			crossingSide := crossings[row.Threshold]
+			for _, p := range []struct {
+				side   string
+				rating int
+			}{{"sente", int(record.SenteRating)}, {"gote", int(record.GoteRating)}} {
+				if p.rating < row.BucketFrom || p.rating >= row.BucketTo {
+					continue
+				}
+				// Every decisive game counts toward the crossing rate.
+				if resultSide != "none" {
+					row.DecisiveGames++
+				}
+				if crossingSide == "none" || resultSide == "none" {
+					row.ExcludedGames++
+				} else if crossingSide == p.side {
+					row.TotalGames++
+					row.Crossings++
+					if resultSide == p.side {
+						row.Wins++
+					}
+				}
+			}
+		}
+	}
+	for i := range rows {
+		rows[i].WinRate = rate(rows[i].Wins, rows[i].Crossings)
+		rows[i].CrossingRate = rate(rows[i].Crossings, rows[i].DecisiveGames)
+	}
+	writeJSON(w, http.StatusOK, analyzeResponse{Games: len(records), Rows: rows})
+}
+
+// winnerSide maps result string to "sente", "gote", or "none".
+func winnerSide(result string) string {
+	switch result {
+	case "sente_win":
+		return "sente"
+	case "gote_win":
+		return "gote"
+	default:
+		return "none"
+	}
+}
+
+// ratingMinMax returns the minimum and maximum player rating in records.
+func ratingMinMax(records []cute.GameRecord) (int, int) {
+	if len(records) == 0 {
+		return 0, 0
+	}
+	lo, hi := math.MaxInt, math.MinInt
+	for _, record := range records {
+		for _, v := range []int{int(record.SenteRating), int(record.GoteRating)} {
+			lo, hi = min(lo, v), max(hi, v)
+		}
+	}
+	return lo, hi
+}
+
+func rate(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// intParam returns the integer query parameter name, or def when absent.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return v, nil
+}
+
+// parseIntList parses comma-separated integers; blank input yields nil.
+func parseIntList(raw string) ([]int, error) {
+	var values []int
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// splitTags splits a comma-separated tag string into trimmed non-empty strings.
+func splitTags(s string) []string {
+	var tags []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			tags = append(tags, p)
+		}
+	}
+	return tags
+}
+
+// normalizeGameID strips the .kif extension, so /games/123 and
+// /games/123.kif find the same game.
+func normalizeGameID(id string) string {
+	return strings.TrimSuffix(id, ".kif")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
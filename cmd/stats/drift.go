@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/openingdb"
+)
+
+// driftWindow maps a game's start time to the time window it is counted
+// in; the keys sort chronologically as strings.
+type driftWindow func(time.Time) string
+
+// parseDriftWindow validates -drift-window.
+func parseDriftWindow(name string) (driftWindow, error) {
+	switch name {
+	case "month":
+		return func(t time.Time) string { return t.Format("2006-01") }, nil
+	case "quarter":
+		return func(t time.Time) string { return fmt.Sprintf("%d-Q%d", t.Year(), (int(t.Month())+2)/3) }, nil
+	case "year":
+		return func(t time.Time) string { return strconv.Itoa(t.Year()) }, nil
+	}
+	return nil, fmt.Errorf("drift-window must be month, quarter or year")
+}
+
+// tagCounts counts the games of one player in one window by tag.
+type tagCounts map[string]int
+
+// distance is the total variation distance between the tag distributions
+// of a and b: 0 when the tags were played in the same proportions, 1 when
+// no tag is shared.
+func (a tagCounts) distance(b tagCounts) float64 {
+	sumA, sumB := 0, 0
+	for _, n := range a {
+		sumA += n
+	}
+	for _, n := range b {
+		sumB += n
+	}
+	if sumA == 0 || sumB == 0 {
+		return 0
+	}
+	d := 0.0
+	for tag, n := range a {
+		d += math.Abs(float64(n)/float64(sumA) - float64(b[tag])/float64(sumB))
+	}
+	for tag, n := range b {
+		if _, ok := a[tag]; !ok {
+			d += float64(n) / float64(sumB)
+		}
+	}
+	return d / 2
+}
+
+// format returns the top tags by games with their share of the window's
+// games, as "tag1(games1:share1%) tag2(games2:share2%) ...".
+func (a tagCounts) format(games, top int) string {
+	tags := make([]string, 0, len(a))
+	for tag := range a {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if a[tags[i]] != a[tags[j]] {
+			return a[tags[i]] > a[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+	if len(tags) > top {
+		tags = tags[:top]
+	}
+	parts := make([]string, len(tags))
+	for i, tag := range tags {
+		parts[i] = fmt.Sprintf("%s(%d:%.0f%%)", tag, a[tag], 100*ratio(a[tag], games))
+	}
+	return strings.Join(parts, " ")
+}
+
+// driftCell is one player's tagged games in one window.
+type driftCell struct {
+	games   int
+	attack  tagCounts
+	defense tagCounts
+}
+
+// driftStats aggregates each player's attack and defense tags per time
+// window.
+type driftStats struct {
+	window  driftWindow
+	players map[string]map[string]*driftCell // name -> window -> cell
+	games   int                              // games counted
+	undated int                              // tagged games without a start time
+}
+
+func newDriftStats(window driftWindow) *driftStats {
+	return &driftStats{window: window, players: make(map[string]map[string]*driftCell)}
+}
+
+// add counts the tags of both players of one game. Games without a start
+// time or without tags are skipped.
+func (d *driftStats) add(record cute.GameRecord, game openingdb.Game) {
+	if game.Sente.Empty() && game.Gote.Empty() {
+		return
+	}
+	start, err := time.Parse(cute.RecordTimeLayout, record.StartTime)
+	if err != nil {
+		d.undated++
+		return
+	}
+	d.games++
+	key := d.window(start)
+	for _, p := range []struct {
+		name string
+		tags openingdb.Tags
+	}{
+		{record.SenteName, game.Sente},
+		{record.GoteName, game.Gote},
+	} {
+		if p.name == "" || len(p.tags.Attack)+len(p.tags.Defense) == 0 {
+			continue
+		}
+		windows := d.players[p.name]
+		if windows == nil {
+			windows = make(map[string]*driftCell)
+			d.players[p.name] = windows
+		}
+		cell := windows[key]
+		if cell == nil {
+			cell = &driftCell{attack: tagCounts{}, defense: tagCounts{}}
+			windows[key] = cell
+		}
+		cell.games++
+		for _, tag := range p.tags.Attack {
+			cell.attack[tag]++
+		}
+		for _, tag := range p.tags.Defense {
+			cell.defense[tag]++
+		}
+	}
+}
+
+// driftRow is one output row of the drift mode: a player's repertoire in
+// one window and how far it moved from the player's previous window.
+type driftRow struct {
+	Name         string  `json:"name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Window       string  `json:"window" parquet:"name=window, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Games        int32   `json:"games" parquet:"name=games, type=INT32"`
+	TopAttacks   string  `json:"top_attacks" parquet:"name=top_attacks, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TopDefenses  string  `json:"top_defenses" parquet:"name=top_defenses, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AttackShift  float64 `json:"attack_shift" parquet:"name=attack_shift, type=DOUBLE"`
+	DefenseShift float64 `json:"defense_shift" parquet:"name=defense_shift, type=DOUBLE"`
+	Switched     bool    `json:"switched" parquet:"name=switched, type=BOOLEAN"`
+}
+
+var driftColumns = []string{
+	"name", "window", "games", "top_attacks", "top_defenses",
+	"attack_shift", "defense_shift", "switched",
+}
+
+// driftOptions are the flags of the drift mode.
+type driftOptions struct {
+	minGames       int     // games a player needs over all windows
+	minWindowGames int     // games a window needs to be listed
+	threshold      float64 // shift at which a window counts as a switch
+	top            topTags
+	users          []string
+}
+
+// rows returns the rows of the players with enough games, ordered by name
+// and window, and the number of players with a switch. Windows with fewer
+// than minWindowGames games are dropped; shifts compare each window with
+// the player's previous listed one, so the first is always 0.
+func (d *driftStats) rows(opts driftOptions) ([]driftRow, int) {
+	listed := make(map[string]bool, len(opts.users))
+	for _, name := range opts.users {
+		listed[name] = true
+	}
+	names := make([]string, 0, len(d.players))
+	for name, windows := range d.players {
+		if len(listed) > 0 && !listed[name] {
+			continue
+		}
+		games := 0
+		for _, cell := range windows {
+			games += cell.games
+		}
+		if games >= opts.minGames {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var rows []driftRow
+	switched := 0
+	for _, name := range names {
+		windows := d.players[name]
+		keys := make([]string, 0, len(windows))
+		for key, cell := range windows {
+			if cell.games >= opts.minWindowGames {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		var prev *driftCell
+		anySwitch := false
+		for _, key := range keys {
+			cell := windows[key]
+			row := driftRow{
+				Name:        name,
+				Window:      key,
+				Games:       int32(cell.games),
+				TopAttacks:  cell.attack.format(cell.games, opts.top.attacks),
+				TopDefenses: cell.defense.format(cell.games, opts.top.defenses),
+			}
+			if prev != nil {
+				row.AttackShift = cell.attack.distance(prev.attack)
+				row.DefenseShift = cell.defense.distance(prev.defense)
+				row.Switched = row.AttackShift >= opts.threshold || row.DefenseShift >= opts.threshold
+				anySwitch = anySwitch || row.Switched
+			}
+			rows = append(rows, row)
+			prev = cell
+		}
+		if anySwitch {
+			switched++
+		}
+	}
+	return rows, switched
+}
+
+func newDriftRowWriter(format, path string) (rowWriter[driftRow], error) {
+	return newRowWriter(format, path, driftColumns, func(r driftRow) []string {
+		return []string{
+			r.Name,
+			r.Window,
+			strconv.Itoa(int(r.Games)),
+			r.TopAttacks,
+			r.TopDefenses,
+			strconv.FormatFloat(r.AttackShift, 'f', 4, 64),
+			strconv.FormatFloat(r.DefenseShift, 'f', 4, 64),
+			strconv.FormatBool(r.Switched),
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestApplyLossStatsMate(t *testing.T) {
+	cp := func(ply, v int) cute.MoveEval {
+		return cute.MoveEval{Ply: int32(ply), ScoreType: "cp", ScoreValue: int32(v)}
+	}
+	mate := func(ply, n int) cute.MoveEval {
+		return cute.MoveEval{Ply: int32(ply), ScoreType: "mate", ScoreValue: int32(n)}
+	}
+	for _, tc := range []struct {
+		name       string
+		before     cute.MoveEval
+		after      cute.MoveEval
+		maxAbsEval int
+		user       string
+		wantSum    int64
+		wantCount  int
+	}{
+		{"sente walks into mate -N", cp(20, 100), mate(21, -5), 600, "sente", 700, 1},
+		{"sente finds mate +N", cp(20, 100), mate(21, 5), 600, "sente", 0, 0},
+		// "mate 0" after an odd ply: gote to move is mated.
+		{"sente mates", cp(20, 100), mate(21, 0), 600, "sente", 0, 0},
+		{"gote walks into mate +N", cp(21, -100), mate(22, 3), 600, "gote", 700, 1},
+		{"gote finds mate -N", cp(21, -100), mate(22, -3), 600, "gote", 0, 0},
+		// After an even ply sente to move is mated.
+		{"gote mates", cp(21, -100), mate(22, 0), 600, "gote", 0, 0},
+		{"mate before the move is beyond the limit", mate(20, 3), cp(21, 0), 600, "sente", 0, 0},
+		{"no limit", cp(20, 100), mate(21, -5), 0, "sente", 100 + cute.MateCP - 5, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			users := map[string]*userStats{}
+			record := cute.GameRecord{
+				SenteName: "sente",
+				GoteName:  "gote",
+				MoveEvals: []cute.MoveEval{tc.before, tc.after},
+			}
+			applyLossStats(users, record, tc.maxAbsEval, 0)
+			var sum int64
+			var count int
+			if u := users[tc.user]; u != nil {
+				sum, count = u.lossSum, u.lossCount
+			}
+			if sum != tc.wantSum || count != tc.wantCount {
+				t.Errorf("loss = %d over %d, want %d over %d", sum, count, tc.wantSum, tc.wantCount)
+			}
+		})
+	}
+}
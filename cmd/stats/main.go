@@ -4,98 +4,269 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"slices"
 	"sort"
 	"strings"
 
 	cute "cute/pkg/cute"
+	"cute/pkg/cute/openingdb"
 
 	"github.com/xitongsys/parquet-go-source/local"
-	"github.com/xitongsys/parquet-go/reader"
 )
 
 // userStats aggregates per-user crossing and strategy statistics.
 type userStats struct {
-	parquetGames int            // total games in eval parquet (used for min-games filter)
-	totalWins    int            // total wins regardless of crossing
-	totalGames   int            // games included in crossing analysis (excludes draws/none)
-	crossings    int            // times the user's side crossed first
-	wins         int            // wins when user crossed first
-	nonCrossings int            // times the opponent crossed first
-	nonWins      int            // wins when opponent crossed first
-	lossSum      int64          // sum of per-move loss (cp)
-	lossCount    int            // number of positions used for loss
-	attackCounts map[string]int // attack tag → number of games
-	ratingSum    int64
-	ratingCount  int
+	parquetGames int // total games in eval parquet (used for min-games filter)
+	totalWins    int // total wins regardless of crossing
+	totalGames   int // games included in crossing analysis (excludes draws/none)
+	crossings    int // times the user's side crossed first
+	wins         int // wins when user crossed first
+	nonCrossings int // times the opponent crossed first
+	nonWins      int // wins when opponent crossed first
+	// nonWinReasons counts the wins after the opponent crossed first by
+	// win_reason, since a time-loss win is no comeback on the board.
+	nonWinReasons map[string]int
+	lossSum       int64 // sum of per-move loss (cp)
+	lossCount     int   // number of positions used for loss
+	ratingSum     int64
+	ratingCount   int
+	// Opening tags of the user's side → games and wins with the tag.
+	attackTags    map[string]*tagStats
+	defenseTags   map[string]*tagStats
+	techniqueTags map[string]*tagStats
 }
 
-// openingRecord matches the strategy classification parquet schema.
-// All fields are OPTIONAL because the Ruby parquet gem writes nullable columns.
-type openingRecord struct {
-	GameID             *string `parquet:"name=game_id, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	GameType           *string `parquet:"name=game_type, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	SenteName          *string `parquet:"name=sente_name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	SenteRating        *int32  `parquet:"name=sente_rating, type=INT32, repetitiontype=OPTIONAL"`
-	GoteName           *string `parquet:"name=gote_name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	GoteRating         *int32  `parquet:"name=gote_rating, type=INT32, repetitiontype=OPTIONAL"`
-	TurnMax            *int32  `parquet:"name=turn_max, type=INT32, repetitiontype=OPTIONAL"`
-	SenteAttackTags    *string `parquet:"name=sente_attack_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	SenteDefenseTags   *string `parquet:"name=sente_defense_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	SenteTechniqueTags *string `parquet:"name=sente_technique_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	SenteNoteTags      *string `parquet:"name=sente_note_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	GoteAttackTags     *string `parquet:"name=gote_attack_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	GoteDefenseTags    *string `parquet:"name=gote_defense_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	GoteTechniqueTags  *string `parquet:"name=gote_technique_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
-	GoteNoteTags       *string `parquet:"name=gote_note_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+// tagStats counts the games a user played with a tag and won.
+type tagStats struct {
+	games int
+	wins  int
 }
 
-// openingInfo stores per-game opening information indexed by game_id.
-type openingInfo struct {
-	senteAttackTags []string
-	goteAttackTags  []string
+// addTags counts the tags of the user's side in one game.
+func (u *userStats) addTags(tags openingdb.Tags, won bool) {
+	for _, c := range []struct {
+		counts map[string]*tagStats
+		tags   []string
+	}{
+		{u.attackTags, tags.Attack},
+		{u.defenseTags, tags.Defense},
+		{u.techniqueTags, tags.Technique},
+	} {
+		for _, tag := range c.tags {
+			st := c.counts[tag]
+			if st == nil {
+				st = &tagStats{}
+				c.counts[tag] = st
+			}
+			st.games++
+			if won {
+				st.wins++
+			}
+		}
+	}
+}
+
+// embeddedOpening returns the tags graph embedded in record
+// (-opening-db/-classify); ok is false when it has none.
+func embeddedOpening(record cute.GameRecord) (openingdb.Game, bool) {
+	game := openingdb.FromGameRecord(record)
+	return game, !game.Sente.Empty() || !game.Gote.Empty()
 }
 
 func main() {
 	parquetPath := flag.String("parquet", "", "input eval parquet file")
-	openingDBPath := flag.String("opening-db", "", "strategy classification parquet file")
+	openingDBPath := flag.String("opening-db", "", "strategy classification parquet file (default: the tags embedded by graph -opening-db/-classify)")
 	threshold := flag.Int("threshold", 500, "eval threshold for crossing detection")
 	minGames := flag.Int("min-games", 20, "minimum games per user (in opening DB)")
 	ignoreFirstMoves := flag.Int("ignore-first-moves", 0, "ignore evals up to this move number")
-	lossMaxEval := flag.Int("loss-max-eval", 600, "only count loss when |eval| <= X (0 = no limit)")
+	mateCrossing := flag.Bool("mate-crossing", true, "count a mate score as a crossing for the mating side whatever the threshold (false skips mate scores)")
+	crossingStability := flag.Int("crossing-stability", 0, "only count a crossing that holds for this many following evals")
+	lossMaxEval := flag.Int("loss-max-eval", 600, "only count loss when |eval| <= X before the move, clipping the eval after it to ±X (0 = no limit)")
 	lossIgnoreMoves := flag.Int("loss-ignore-moves", 20, "ignore first N moves when calculating loss")
 	topN := flag.Int("top-attacks", 3, "number of top attack strategies to show per user")
+	topDefenses := flag.Int("top-defenses", 3, "number of top defense tags (castles) to show per user")
+	topTechniques := flag.Int("top-techniques", 3, "number of top technique tags to show per user (only the opening DB has them)")
 	sortBy := flag.String("sort", "crossing_rate", "sort column: crossing_rate, win_rate, total_games, avg_rating")
+	excludeWinReasons := flag.String("exclude-win-reasons", "", "comma-separated win_reason values whose games are left out (e.g. 切れ負け,反則負け)")
+	usersArg := flag.String("users", "", "comma-separated player names: list only these users (matchups: count only their games); reads only their games when the parquet has a player index (graph -player-index)")
+	mode := flag.String("mode", "users", "users (per-user table), matchups (win rate matrix of tag matchups) or drift (per-user attack/defense tags by time window)")
+	matchupTags := flag.String("matchup-tags", "attack", "comma-separated tag categories forming the matchup axes: attack, defense, note")
+	matchupTop := flag.Int("matchup-top", 20, "keep only the N most frequent tags on each axis (0=all)")
+	matchupMinGames := flag.Int("matchup-min-games", 1, "leave matchup cells with fewer games empty")
+	ratingMin := flag.Int("rating-min", 0, "matchups: only games where both players are rated at least this (0=disabled)")
+	ratingMax := flag.Int("rating-max", 0, "matchups: only games where both players are rated below this (0=disabled)")
+	driftWindowName := flag.String("drift-window", "quarter", "drift: time window of start_time: month, quarter or year")
+	driftMinWindowGames := flag.Int("drift-min-window-games", 5, "drift: leave out windows where a user has fewer tagged games")
+	driftThreshold := flag.Float64("drift-threshold", 0.5, "drift: flag a window as a switch when its attack or defense tag distribution is at least this far (total variation distance, 0-1) from the user's previous window")
+	format := flag.String("format", "csv", "output format: csv, tsv, json (array of objects), parquet or arrow (Arrow IPC file; both require -output)")
+	outputPath := flag.String("output", "", "output file (default stdout)")
+	flag.StringVar(outputPath, "o", "", "shorthand for -output")
+	gameFilter := cute.AddGameFilterFlags(flag.CommandLine)
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
 	flag.Parse()
+	if _, err := cute.LoadCommandConfig("stats", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
 
-	if *parquetPath == "" || *openingDBPath == "" {
-		fatal(fmt.Errorf("both -parquet and -opening-db are required"))
+	if *parquetPath == "" {
+		fatal(fmt.Errorf("-parquet is required"))
+	}
+	if err := checkFormat(*format, *outputPath); err != nil {
+		fatal(err)
+	}
+	if err := gameFilter.Validate(); err != nil {
+		fatal(err)
+	}
+	var categories []string
+	var window driftWindow
+	switch *mode {
+	case "users":
+	case "matchups":
+		var err error
+		if categories, err = parseTagCategories(*matchupTags); err != nil {
+			fatal(err)
+		}
+	case "drift":
+		var err error
+		if window, err = parseDriftWindow(*driftWindowName); err != nil {
+			fatal(err)
+		}
+	default:
+		fatal(fmt.Errorf("mode must be users, matchups or drift"))
+	}
+	onlyUsers := openingdb.SplitTags(*usersArg)
+	excludedReasons := openingdb.SplitTags(*excludeWinReasons)
+	excludedGames := 0
+	filteredGames := 0
+	// filter applies -exclude-win-reasons and the move count/phase
+	// filter; ok is false when record is left out.
+	filter := func(record cute.GameRecord) (cute.GameRecord, bool) {
+		if slices.Contains(excludedReasons, record.WinReason) {
+			excludedGames++
+			return record, false
+		}
+		record, ok := gameFilter.Apply(record)
+		if !ok {
+			filteredGames++
+		}
+		return record, ok
+	}
+	reportExcluded := func() {
+		if len(excludedReasons) > 0 {
+			fmt.Fprintf(os.Stderr, "exclude-win-reasons: %d games left out\n", excludedGames)
+		}
+		if gameFilter.Active() {
+			fmt.Fprintf(os.Stderr, "move count/phase filter: %d games left out\n", filteredGames)
+		}
 	}
 
 	// 1. Load opening DB.
-	fmt.Fprintf(os.Stderr, "loading opening DB: %s\n", *openingDBPath)
-	openings, err := loadOpeningDB(*openingDBPath, 4)
-	if err != nil {
-		fatal(fmt.Errorf("opening-db: %w", err))
+	var openings map[string]openingdb.Game
+	if *openingDBPath != "" {
+		fmt.Fprintf(os.Stderr, "loading opening DB: %s\n", *openingDBPath)
+		var err error
+		openings, err = openingdb.Load(*openingDBPath, 4)
+		if err != nil {
+			fatal(fmt.Errorf("opening-db: %w", err))
+		}
+		fmt.Fprintf(os.Stderr, "opening DB: %d games\n", len(openings))
+	}
+	lookupOpening := func(record cute.GameRecord) (openingdb.Game, bool) {
+		if openings == nil {
+			return embeddedOpening(record)
+		}
+		info, ok := openings[openingdb.NormalizeGameID(record.GameID)]
+		return info, ok
 	}
-	fmt.Fprintf(os.Stderr, "opening DB: %d games\n", len(openings))
 
-	// 2. Load eval parquet.
-	fmt.Fprintf(os.Stderr, "loading eval parquet: %s\n", *parquetPath)
-	records, err := readEvalParquet(*parquetPath, 4)
-	if err != nil {
-		fatal(err)
+	// 2. Stream the eval parquet.
+	fmt.Fprintf(os.Stderr, "reading eval parquet: %s\n", *parquetPath)
+
+	if *mode == "matchups" {
+		m := newMatchupStats(categories)
+		band := ratingBand{min: *ratingMin, max: *ratingMax}
+		// Matchups need no evals; skipping move_evals makes the read
+		// several times faster.
+		cols := []string{"game_id", "sente_rating", "gote_rating", "result", "win_reason", "move_count", "activity_packed",
+			"sente_attack_tags", "sente_defense_tags", "gote_attack_tags", "gote_defense_tags"}
+		n, err := streamEvalParquet(*parquetPath, 4, cols, onlyUsers, func(record cute.GameRecord) {
+			record, ok := filter(record)
+			if !ok || !band.contains(record) {
+				return
+			}
+			if info, ok := lookupOpening(record); ok {
+				m.add(info, winnerSide(record.Result))
+			}
+		})
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "eval parquet: %d games, matchup games: %d\n", n, m.games)
+		reportExcluded()
+		if err := m.write(*format, *outputPath, *matchupTop, *matchupMinGames); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *mode == "drift" {
+		d := newDriftStats(window)
+		cols := []string{"game_id", "start_time", "sente_name", "gote_name", "win_reason", "move_count", "activity_packed",
+			"sente_attack_tags", "sente_defense_tags", "gote_attack_tags", "gote_defense_tags"}
+		n, err := streamEvalParquet(*parquetPath, 4, cols, onlyUsers, func(record cute.GameRecord) {
+			record, ok := filter(record)
+			if !ok {
+				return
+			}
+			if info, ok := lookupOpening(record); ok {
+				d.add(record, info)
+			}
+		})
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Fprintf(os.Stderr, "eval parquet: %d games, dated tagged games: %d (%d tagged games without start_time skipped)\n", n, d.games, d.undated)
+		reportExcluded()
+		rows, switched := d.rows(driftOptions{
+			minGames:       *minGames,
+			minWindowGames: *driftMinWindowGames,
+			threshold:      *driftThreshold,
+			top:            topTags{attacks: *topN, defenses: *topDefenses},
+			users:          onlyUsers,
+		})
+		fmt.Fprintf(os.Stderr, "drift rows: %d, users who switched repertoire: %d (threshold=%.2f)\n", len(rows), switched, *driftThreshold)
+		w, err := newDriftRowWriter(*format, *outputPath)
+		if err != nil {
+			fatal(err)
+		}
+		for _, row := range rows {
+			if err := w.write(row); err != nil {
+				fatal(err)
+			}
+		}
+		if err := w.close(); err != nil {
+			fatal(err)
+		}
+		return
 	}
-	fmt.Fprintf(os.Stderr, "eval parquet: %d games\n", len(records))
 
-	// 3. Build per-user stats from eval parquet, joining with opening DB for attack tags.
+	// 3. Build per-user stats from eval parquet, joining with opening DB for tags.
 	users := make(map[string]*userStats)
 	joined := 0
 
-	for _, record := range records {
-		gid := normalizeGameID(record.GameID)
-		opening, hasOpening := openings[gid]
-
-		crossingSide := firstCrossingSide(record.MoveEvals, *threshold, *ignoreFirstMoves)
+	n, err := streamEvalParquet(*parquetPath, 4, nil, onlyUsers, func(record cute.GameRecord) {
+		record, ok := filter(record)
+		if !ok {
+			return
+		}
+		opening, hasOpening := lookupOpening(record)
+
+		crossingSide := cute.FirstCrossing(record.MoveEvals, cute.CrossingOptions{
+			Threshold:         *threshold,
+			IgnorePlies:       *ignoreFirstMoves,
+			MateCountsAsCross: *mateCrossing,
+			RequireStability:  *crossingStability,
+		}).Side
 		resultSide := winnerSide(record.Result)
 
 		if hasOpening {
@@ -117,9 +288,7 @@ func main() {
 				u.ratingCount++
 			}
 			if hasOpening {
-				for _, tag := range opening.senteAttackTags {
-					u.attackCounts[tag]++
-				}
+				u.addTags(opening.Sente, resultSide == "sente")
 			}
 			if crossingSide != "none" && resultSide != "none" {
 				u.totalGames++
@@ -132,6 +301,7 @@ func main() {
 					u.nonCrossings++
 					if resultSide == "sente" {
 						u.nonWins++
+						u.nonWinReasons[record.WinReason]++
 					}
 				}
 			}
@@ -149,9 +319,7 @@ func main() {
 				u.ratingCount++
 			}
 			if hasOpening {
-				for _, tag := range opening.goteAttackTags {
-					u.attackCounts[tag]++
-				}
+				u.addTags(opening.Gote, resultSide == "gote")
 			}
 			if crossingSide != "none" && resultSide != "none" {
 				u.totalGames++
@@ -164,126 +332,60 @@ func main() {
 					u.nonCrossings++
 					if resultSide == "gote" {
 						u.nonWins++
+						u.nonWinReasons[record.WinReason]++
 					}
 				}
 			}
 		}
-	}
-
-	fmt.Fprintf(os.Stderr, "joined games: %d\n", joined)
+	})
 
-	// 4. Filter by min-games, compute rates, sort.
-	type userResult struct {
-		name           string
-		avgRating      float64
-		parquetGames   int
-		overallWinRate float64
-		totalGames     int
-		crossings      int
-		crossingRate   float64
-		wins           int
-		winRate        float64
-		nonCrossings   int
-		nonWinRate     float64
-		avgLoss        float64
-		lossPositions  int
-		topAttacks     string
+	if err != nil {
+		fatal(err)
 	}
-
-	var results []userResult
+	fmt.Fprintf(os.Stderr, "eval parquet: %d games, joined games: %d\n", n, joined)
+	reportExcluded()
+
+	// 4. Filter by min-games and sort. Rows are built and written one at
+	// a time, so only the sort keys are held for all users.
+	listed := make(map[string]bool, len(onlyUsers))
+	for _, name := range onlyUsers {
+		listed[name] = true
+	}
+	var keys []userSortKey
 	for name, u := range users {
-		if u.parquetGames < *minGames {
+		if u.parquetGames < *minGames || (len(listed) > 0 && !listed[name]) {
 			continue
 		}
-		avgRating := 0.0
-		if u.ratingCount > 0 {
-			avgRating = float64(u.ratingSum) / float64(u.ratingCount)
-		}
-		crossingRate := 0.0
-		if u.totalGames > 0 {
-			crossingRate = float64(u.crossings) / float64(u.totalGames)
-		}
-		winRate := 0.0
-		if u.crossings > 0 {
-			winRate = float64(u.wins) / float64(u.crossings)
-		}
-		nonWinRate := 0.0
-		if u.nonCrossings > 0 {
-			nonWinRate = float64(u.nonWins) / float64(u.nonCrossings)
-		}
-		overallWinRate := 0.0
-		if u.parquetGames > 0 {
-			overallWinRate = float64(u.totalWins) / float64(u.parquetGames)
-		}
-		avgLoss := 0.0
-		if u.lossCount > 0 {
-			avgLoss = float64(u.lossSum) / float64(u.lossCount)
-		}
-		results = append(results, userResult{
-			name:           name,
-			avgRating:      avgRating,
-			parquetGames:   u.parquetGames,
-			overallWinRate: overallWinRate,
-			totalGames:     u.totalGames,
-			crossings:      u.crossings,
-			crossingRate:   crossingRate,
-			wins:           u.wins,
-			winRate:        winRate,
-			nonCrossings:   u.nonCrossings,
-			nonWinRate:     nonWinRate,
-			avgLoss:        avgLoss,
-			lossPositions:  u.lossCount,
-			topAttacks:     formatTopAttacks(u.attackCounts, *topN),
-		})
+		keys = append(keys, newUserSortKey(name, u))
 	}
+	sortUsers(keys, *sortBy)
 
-	sort.Slice(results, func(i, j int) bool {
-		switch *sortBy {
-		case "win_rate":
-			if results[i].winRate != results[j].winRate {
-				return results[i].winRate > results[j].winRate
-			}
-			return results[i].totalGames > results[j].totalGames
-		case "total_games":
-			return results[i].totalGames > results[j].totalGames
-		case "avg_rating":
-			return results[i].avgRating > results[j].avgRating
-		default: // crossing_rate
-			if results[i].crossingRate != results[j].crossingRate {
-				return results[i].crossingRate > results[j].crossingRate
-			}
-			return results[i].totalGames > results[j].totalGames
-		}
-	})
-
-	// 5. Print CSV.
+	// 5. Write rows.
 	fmt.Fprintf(os.Stderr, "users with >= %d games: %d (threshold=%d)\n",
-		*minGames, len(results), *threshold)
-	fmt.Println("name,avg_rating,games,overall_win_rate,eval_games,crossings,crossing_rate,wins,win_rate,non_crossings,non_crossing_win_rate,avg_loss,loss_positions,top_attacks")
-	for _, r := range results {
-		fmt.Printf("%s,%.0f,%d,%.4f,%d,%d,%.4f,%d,%.4f,%d,%.4f,%.2f,%d,%s\n",
-			r.name,
-			r.avgRating,
-			r.parquetGames,
-			r.overallWinRate,
-			r.totalGames,
-			r.crossings,
-			r.crossingRate,
-			r.wins,
-			r.winRate,
-			r.nonCrossings,
-			r.nonWinRate,
-			r.avgLoss,
-			r.lossPositions,
-			r.topAttacks,
-		)
+		*minGames, len(keys), *threshold)
+	w, err := newUserRowWriter(*format, *outputPath)
+	if err != nil {
+		fatal(err)
+	}
+	for _, key := range keys {
+		if err := w.write(newUserRow(key.name, users[key.name], topTags{*topN, *topDefenses, *topTechniques})); err != nil {
+			fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		fatal(err)
 	}
 }
 
 func getOrCreateUser(users map[string]*userStats, name string) *userStats {
 	u, ok := users[name]
 	if !ok {
-		u = &userStats{attackCounts: make(map[string]int)}
+		u = &userStats{
+			attackTags:    make(map[string]*tagStats),
+			defenseTags:   make(map[string]*tagStats),
+			techniqueTags: make(map[string]*tagStats),
+			nonWinReasons: make(map[string]int),
+		}
 		users[name] = u
 	}
 	return u
@@ -296,21 +398,29 @@ func applyLossStats(users map[string]*userStats, record cute.GameRecord, maxAbsE
 	for i := 1; i < len(record.MoveEvals); i++ {
 		before := record.MoveEvals[i-1]
 		after := record.MoveEvals[i]
-		if ignoreMoves > 0 && int(after.Ply) <= ignoreMoves {
+		if after.Ply != before.Ply+1 {
+			// Plies skipped by -eval-stride.
 			continue
 		}
-		if before.ScoreType != "cp" || after.ScoreType != "cp" {
+		if ignoreMoves > 0 && int(after.Ply) <= ignoreMoves {
 			continue
 		}
-		if maxAbsEval > 0 && absInt32(before.ScoreValue) > int32(maxAbsEval) {
-			continue
+		// Mates rank as ±(MateCP - n); the eval after the move is clipped
+		// to the limit, so walking into a mate costs at most the way from
+		// before to -maxAbsEval.
+		beforeCP, afterCP := before.EffectiveCP(), after.EffectiveCP()
+		if maxAbsEval > 0 {
+			if max(beforeCP, -beforeCP) > maxAbsEval {
+				continue
+			}
+			afterCP = min(max(afterCP, -maxAbsEval), maxAbsEval)
 		}
 		ply := int(after.Ply)
 		mover := "sente"
 		if ply%2 == 0 {
 			mover = "gote"
 		}
-		loss := perMoveLoss(before.ScoreValue, after.ScoreValue, mover)
+		loss := perMoveLoss(beforeCP, afterCP, mover)
 		if loss <= 0 {
 			continue
 		}
@@ -333,8 +443,8 @@ func applyLossStats(users map[string]*userStats, record cute.GameRecord, maxAbsE
 	}
 }
 
-func perMoveLoss(before, after int32, mover string) int32 {
-	var loss int32
+func perMoveLoss(before, after int, mover string) int {
+	var loss int
 	switch mover {
 	case "sente":
 		loss = before - after
@@ -349,59 +459,59 @@ func perMoveLoss(before, after int32, mover string) int32 {
 	return loss
 }
 
-func absInt32(v int32) int32 {
-	if v < 0 {
-		return -v
-	}
-	return v
+// topTags is the number of tags of each category listed per user.
+type topTags struct {
+	attacks, defenses, techniques int
 }
 
-// formatTopAttacks returns the top-N attack tags as "tag1(count1) tag2(count2) ...".
-func formatTopAttacks(counts map[string]int, top int) string {
+// formatTopTags returns the top-N tags by games with the win rate of the
+// user's games with each, as "tag1(games1:win1%) tag2(games2:win2%) ...".
+func formatTopTags(counts map[string]*tagStats, top int) string {
 	type kv struct {
-		tag   string
-		count int
+		tag string
+		st  *tagStats
 	}
 	var pairs []kv
-	for tag, count := range counts {
-		pairs = append(pairs, kv{tag, count})
+	for tag, st := range counts {
+		pairs = append(pairs, kv{tag, st})
 	}
 	sort.Slice(pairs, func(i, j int) bool {
-		if pairs[i].count == pairs[j].count {
+		if pairs[i].st.games == pairs[j].st.games {
 			return pairs[i].tag < pairs[j].tag
 		}
-		return pairs[i].count > pairs[j].count
+		return pairs[i].st.games > pairs[j].st.games
 	})
 	if len(pairs) > top {
 		pairs = pairs[:top]
 	}
 	var parts []string
 	for _, p := range pairs {
-		parts = append(parts, fmt.Sprintf("%s(%d)", p.tag, p.count))
+		parts = append(parts, fmt.Sprintf("%s(%d:%.0f%%)", p.tag, p.st.games, 100*ratio(p.st.wins, p.st.games)))
 	}
 	return strings.Join(parts, " ")
 }
 
-// firstCrossingSide returns which side first crosses the eval threshold.
-func firstCrossingSide(evals []cute.MoveEval, threshold int, ignoreFirstMoves int) string {
-	for _, eval := range evals {
-		if ignoreFirstMoves > 0 && int(eval.Ply) <= ignoreFirstMoves {
-			continue
-		}
-		if eval.ScoreType == "mate" {
-			if eval.ScoreValue >= 0 {
-				return "sente"
-			}
-			return "gote"
-		}
-		if eval.ScoreValue >= int32(threshold) {
-			return "sente"
+// formatWinReasons returns counts as "reason1:n1 reason2:n2 ...", most
+// frequent first.
+func formatWinReasons(counts map[string]int) string {
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if counts[reasons[i]] != counts[reasons[j]] {
+			return counts[reasons[i]] > counts[reasons[j]]
 		}
-		if eval.ScoreValue <= -int32(threshold) {
-			return "gote"
+		return reasons[i] < reasons[j]
+	})
+	parts := make([]string, len(reasons))
+	for i, reason := range reasons {
+		if reason == "" {
+			reason = "unknown"
 		}
+		parts[i] = fmt.Sprintf("%s:%d", reason, counts[reasons[i]])
 	}
-	return "none"
+	return strings.Join(parts, " ")
 }
 
 // winnerSide maps result string to "sente", "gote", or "none".
@@ -416,59 +526,40 @@ func winnerSide(result string) string {
 	}
 }
 
-// loadOpeningDB reads the strategy classification parquet into a map keyed by game_id.
-func loadOpeningDB(path string, parallel int64) (map[string]openingInfo, error) {
-	fileReader, err := local.NewLocalFileReader(path)
-	if err != nil {
-		return nil, err
-	}
-	defer fileReader.Close()
-
-	parquetReader, err := reader.NewParquetReader(fileReader, new(openingRecord), parallel)
-	if err != nil {
-		return nil, err
+// streamEvalParquet calls fn for each GameRecord row of a parquet file
+// without holding more than one batch in memory, and returns the number of
+// rows. Only cols are read (nil = all; see NewGameRecordReaderColumns).
+// With users only the games of those players are passed on (see
+// cute.ReadPlayerGames).
+func streamEvalParquet(path string, parallel int64, cols, users []string, fn func(cute.GameRecord)) (int, error) {
+	if err := cute.CheckParquetPerspective(path); err != nil {
+		return 0, err
 	}
-	defer parquetReader.ReadStop()
-
-	num := int(parquetReader.GetNumRows())
-	result := make(map[string]openingInfo, num)
-	batchSize := 1024
-	for offset := 0; offset < num; offset += batchSize {
-		remain := num - offset
-		if remain < batchSize {
-			batchSize = remain
-		}
-		batch := make([]openingRecord, batchSize)
-		if err := parquetReader.Read(&batch); err != nil {
-			return nil, err
-		}
-		for _, rec := range batch {
-			gid := normalizeGameID(derefStr(rec.GameID))
-			result[gid] = openingInfo{
-				senteAttackTags: splitTags(derefStr(rec.SenteAttackTags)),
-				goteAttackTags:  splitTags(derefStr(rec.GoteAttackTags)),
-			}
+	if len(users) > 0 {
+		n := 0
+		indexed, err := cute.ReadPlayerGames(path, parallel, users, cols, func(record cute.GameRecord) error {
+			n++
+			fn(record)
+			return nil
+		})
+		if err == nil && !indexed {
+			fmt.Fprintf(os.Stderr, "no current player index for %s; scanned the whole file\n", path)
 		}
+		return n, err
 	}
-	return result, nil
-}
-
-// readEvalParquet loads all GameRecord rows from a parquet file.
-func readEvalParquet(path string, parallel int64) ([]cute.GameRecord, error) {
 	fileReader, err := local.NewLocalFileReader(path)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer fileReader.Close()
 
-	parquetReader, err := reader.NewParquetReader(fileReader, new(cute.GameRecord), parallel)
+	parquetReader, err := cute.NewGameRecordReaderColumns(fileReader, parallel, cols)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 	defer parquetReader.ReadStop()
 
 	num := int(parquetReader.GetNumRows())
-	records := make([]cute.GameRecord, 0, num)
 	batchSize := 1024
 	for offset := 0; offset < num; offset += batchSize {
 		remain := num - offset
@@ -477,39 +568,13 @@ func readEvalParquet(path string, parallel int64) ([]cute.GameRecord, error) {
 		}
 		batch := make([]cute.GameRecord, batchSize)
 		if err := parquetReader.Read(&batch); err != nil {
-			return nil, err
+			return 0, err
 		}
-		records = append(records, batch...)
-	}
-	return records, nil
-}
-
-func derefStr(p *string) string {
-	if p == nil {
-		return ""
-	}
-	return *p
-}
-
-// splitTags splits a comma-separated tag string into trimmed non-empty strings.
-func splitTags(s string) []string {
-	if s == "" {
-		return nil
-	}
-	parts := strings.Split(s, ",")
-	result := make([]string, 0, len(parts))
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p != "" {
-			result = append(result, p)
+		for _, record := range batch {
+			fn(record)
 		}
 	}
-	return result
-}
-
-// normalizeGameID strips the .kif extension for consistent game_id matching.
-func normalizeGameID(id string) string {
-	return strings.TrimSuffix(id, ".kif")
+	return num, nil
 }
 
 func fatal(err error) {
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/openingdb"
+)
+
+// parseTagCategories validates -matchup-tags.
+func parseTagCategories(raw string) ([]string, error) {
+	var categories []string
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		switch part {
+		case "":
+			continue
+		case "attack", "defense", "note":
+			categories = append(categories, part)
+		default:
+			return nil, fmt.Errorf("unknown tag category %q (available: attack, defense, note)", part)
+		}
+	}
+	if len(categories) == 0 {
+		return nil, fmt.Errorf("matchup-tags must be non-empty")
+	}
+	return categories, nil
+}
+
+// ratingBand restricts matchups to games where both players are rated in
+// [min, max). Zero bounds are open.
+type ratingBand struct {
+	min, max int
+}
+
+func (b ratingBand) contains(record cute.GameRecord) bool {
+	for _, rating := range []int{int(record.SenteRating), int(record.GoteRating)} {
+		if b.min > 0 && rating < b.min {
+			return false
+		}
+		if b.max > 0 && rating >= b.max {
+			return false
+		}
+	}
+	return true
+}
+
+// matchupCell counts the decisive games of one (player tag, opponent tag)
+// pair from the player's side.
+type matchupCell struct {
+	games int
+	wins  int
+}
+
+// matchupStats aggregates win rates of tag matchups. Each decisive game
+// counts once from each side, so cell (A, B) is the win rate of players
+// tagged A against opponents tagged B.
+type matchupStats struct {
+	categories []string
+	cells      map[[2]string]*matchupCell
+	tagGames   map[string]int // tag -> games it appears in (per player)
+	games      int
+}
+
+func newMatchupStats(categories []string) *matchupStats {
+	return &matchupStats{
+		categories: categories,
+		cells:      make(map[[2]string]*matchupCell),
+		tagGames:   make(map[string]int),
+	}
+}
+
+func (m *matchupStats) tags(game openingdb.Game, side string) []string {
+	player := game.Side(side)
+	var tags []string
+	for _, category := range m.categories {
+		switch category {
+		case "attack":
+			tags = append(tags, player.Attack...)
+		case "defense":
+			tags = append(tags, player.Defense...)
+		case "note":
+			tags = append(tags, player.Note...)
+		}
+	}
+	return tags
+}
+
+// add counts one game; draws and unfinished games are skipped.
+func (m *matchupStats) add(info openingdb.Game, resultSide string) {
+	if resultSide == "none" {
+		return
+	}
+	sente, gote := m.tags(info, "sente"), m.tags(info, "gote")
+	if len(sente) == 0 || len(gote) == 0 {
+		return
+	}
+	m.games++
+	m.count(sente, gote, resultSide == "sente")
+	m.count(gote, sente, resultSide == "gote")
+}
+
+func (m *matchupStats) count(own, opponent []string, won bool) {
+	for _, a := range own {
+		m.tagGames[a]++
+		for _, b := range opponent {
+			cell := m.cells[[2]string{a, b}]
+			if cell == nil {
+				cell = &matchupCell{}
+				m.cells[[2]string{a, b}] = cell
+			}
+			cell.games++
+			if won {
+				cell.wins++
+			}
+		}
+	}
+}
+
+// axis returns the top most frequent tags (all when top <= 0).
+func (m *matchupStats) axis(top int) []string {
+	tags := make([]string, 0, len(m.tagGames))
+	for tag := range m.tagGames {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if m.tagGames[tags[i]] != m.tagGames[tags[j]] {
+			return m.tagGames[tags[i]] > m.tagGames[tags[j]]
+		}
+		return tags[i] < tags[j]
+	})
+	if top > 0 && len(tags) > top {
+		tags = tags[:top]
+	}
+	return tags
+}
+
+// print writes the win rate matrix (rows: player tag, columns: opponent
+// tag) followed by the matrix of game counts, as delimited blocks. Cells
+// with fewer than minGames games are left empty in the win rate matrix.
+func (m *matchupStats) print(out io.Writer, comma rune, top, minGames int) error {
+	tags := m.axis(top)
+	w := csv.NewWriter(out)
+	w.Comma = comma
+	header := append([]string{"tag"}, tags...)
+
+	w.Write([]string{"win_rate (row tag vs column tag)"})
+	w.Write(header)
+	for _, a := range tags {
+		row := []string{a}
+		for _, b := range tags {
+			cell := m.cells[[2]string{a, b}]
+			if cell == nil || cell.games < minGames {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, fmt.Sprintf("%.4f", float64(cell.wins)/float64(cell.games)))
+		}
+		w.Write(row)
+	}
+
+	w.Write(nil)
+	w.Write([]string{"games"})
+	w.Write(header)
+	for _, a := range tags {
+		row := []string{a}
+		for _, b := range tags {
+			games := 0
+			if cell := m.cells[[2]string{a, b}]; cell != nil {
+				games = cell.games
+			}
+			row = append(row, fmt.Sprint(games))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	cute "cute/pkg/cute"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// userRow is one output row of the users mode.
+type userRow struct {
+	Name                  string  `json:"name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AvgRating             float64 `json:"avg_rating" parquet:"name=avg_rating, type=DOUBLE"`
+	Games                 int32   `json:"games" parquet:"name=games, type=INT32"`
+	OverallWinRate        float64 `json:"overall_win_rate" parquet:"name=overall_win_rate, type=DOUBLE"`
+	EvalGames             int32   `json:"eval_games" parquet:"name=eval_games, type=INT32"`
+	Crossings             int32   `json:"crossings" parquet:"name=crossings, type=INT32"`
+	CrossingRate          float64 `json:"crossing_rate" parquet:"name=crossing_rate, type=DOUBLE"`
+	Wins                  int32   `json:"wins" parquet:"name=wins, type=INT32"`
+	WinRate               float64 `json:"win_rate" parquet:"name=win_rate, type=DOUBLE"`
+	NonCrossings          int32   `json:"non_crossings" parquet:"name=non_crossings, type=INT32"`
+	NonCrossingWinRate    float64 `json:"non_crossing_win_rate" parquet:"name=non_crossing_win_rate, type=DOUBLE"`
+	NonCrossingWinReasons string  `json:"non_crossing_win_reasons" parquet:"name=non_crossing_win_reasons, type=BYTE_ARRAY, convertedtype=UTF8"`
+	AvgLoss               float64 `json:"avg_loss" parquet:"name=avg_loss, type=DOUBLE"`
+	LossPositions         int32   `json:"loss_positions" parquet:"name=loss_positions, type=INT32"`
+	TopAttacks            string  `json:"top_attacks" parquet:"name=top_attacks, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TopDefenses           string  `json:"top_defenses" parquet:"name=top_defenses, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TopTechniques         string  `json:"top_techniques" parquet:"name=top_techniques, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+var userColumns = []string{
+	"name", "avg_rating", "games", "overall_win_rate", "eval_games",
+	"crossings", "crossing_rate", "wins", "win_rate",
+	"non_crossings", "non_crossing_win_rate", "non_crossing_win_reasons", "avg_loss", "loss_positions",
+	"top_attacks", "top_defenses", "top_techniques",
+}
+
+func ratio(num, den int) float64 {
+	if den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+func newUserRow(name string, u *userStats, top topTags) userRow {
+	avgRating := 0.0
+	if u.ratingCount > 0 {
+		avgRating = float64(u.ratingSum) / float64(u.ratingCount)
+	}
+	avgLoss := 0.0
+	if u.lossCount > 0 {
+		avgLoss = float64(u.lossSum) / float64(u.lossCount)
+	}
+	return userRow{
+		Name:                  name,
+		AvgRating:             avgRating,
+		Games:                 int32(u.parquetGames),
+		OverallWinRate:        ratio(u.totalWins, u.parquetGames),
+		EvalGames:             int32(u.totalGames),
+		Crossings:             int32(u.crossings),
+		CrossingRate:          ratio(u.crossings, u.totalGames),
+		Wins:                  int32(u.wins),
+		WinRate:               ratio(u.wins, u.crossings),
+		NonCrossings:          int32(u.nonCrossings),
+		NonCrossingWinRate:    ratio(u.nonWins, u.nonCrossings),
+		NonCrossingWinReasons: formatWinReasons(u.nonWinReasons),
+		AvgLoss:               avgLoss,
+		LossPositions:         int32(u.lossCount),
+		TopAttacks:            formatTopTags(u.attackTags, top.attacks),
+		TopDefenses:           formatTopTags(u.defenseTags, top.defenses),
+		TopTechniques:         formatTopTags(u.techniqueTags, top.techniques),
+	}
+}
+
+// userSortKey holds the columns -sort can use.
+type userSortKey struct {
+	name         string
+	avgRating    float64
+	totalGames   int
+	crossingRate float64
+	winRate      float64
+}
+
+func newUserSortKey(name string, u *userStats) userSortKey {
+	key := userSortKey{
+		name:         name,
+		totalGames:   u.totalGames,
+		crossingRate: ratio(u.crossings, u.totalGames),
+		winRate:      ratio(u.wins, u.crossings),
+	}
+	if u.ratingCount > 0 {
+		key.avgRating = float64(u.ratingSum) / float64(u.ratingCount)
+	}
+	return key
+}
+
+func sortUsers(keys []userSortKey, sortBy string) {
+	sort.Slice(keys, func(i, j int) bool {
+		switch sortBy {
+		case "win_rate":
+			if keys[i].winRate != keys[j].winRate {
+				return keys[i].winRate > keys[j].winRate
+			}
+			return keys[i].totalGames > keys[j].totalGames
+		case "total_games":
+			return keys[i].totalGames > keys[j].totalGames
+		case "avg_rating":
+			return keys[i].avgRating > keys[j].avgRating
+		default: // crossing_rate
+			if keys[i].crossingRate != keys[j].crossingRate {
+				return keys[i].crossingRate > keys[j].crossingRate
+			}
+			return keys[i].totalGames > keys[j].totalGames
+		}
+	})
+}
+
+// rowWriter streams output rows of one format.
+type rowWriter[T any] interface {
+	write(row T) error
+	close() error
+}
+
+// openOutput returns stdout or a created file.
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// delimitedWriter writes CSV or TSV with a header row.
+type delimitedWriter[T any] struct {
+	out    io.WriteCloser
+	w      *csv.Writer
+	record func(T) []string
+}
+
+func newDelimitedWriter[T any](path string, comma rune, header []string, record func(T) []string) (*delimitedWriter[T], error) {
+	out, err := openOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(out)
+	w.Comma = comma
+	if err := w.Write(header); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return &delimitedWriter[T]{out: out, w: w, record: record}, nil
+}
+
+func (d *delimitedWriter[T]) write(row T) error {
+	return d.w.Write(d.record(row))
+}
+
+func (d *delimitedWriter[T]) close() error {
+	d.w.Flush()
+	if err := d.w.Error(); err != nil {
+		d.out.Close()
+		return err
+	}
+	return d.out.Close()
+}
+
+// jsonWriter streams a JSON array, one object per line.
+type jsonWriter[T any] struct {
+	out io.WriteCloser
+	w   *bufio.Writer
+	n   int
+}
+
+func newJSONWriter[T any](path string) (*jsonWriter[T], error) {
+	out, err := openOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(out)
+	w.WriteString("[")
+	return &jsonWriter[T]{out: out, w: w}, nil
+}
+
+func (j *jsonWriter[T]) write(row T) error {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if j.n > 0 {
+		j.w.WriteString(",")
+	}
+	j.n++
+	j.w.WriteString("\n  ")
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter[T]) close() error {
+	if j.n > 0 {
+		j.w.WriteString("\n")
+	}
+	j.w.WriteString("]\n")
+	if err := j.w.Flush(); err != nil {
+		j.out.Close()
+		return err
+	}
+	return j.out.Close()
+}
+
+// parquetRowWriter writes rows of a parquet-tagged struct.
+type parquetRowWriter[T any] struct {
+	file source.ParquetFile
+	pw   *writer.ParquetWriter
+}
+
+func newParquetRowWriter[T any](path string) (*parquetRowWriter[T], error) {
+	file, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := writer.NewParquetWriter(file, new(T), 1)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetRowWriter[T]{file: file, pw: pw}, nil
+}
+
+func (p *parquetRowWriter[T]) write(row T) error {
+	return p.pw.Write(row)
+}
+
+func (p *parquetRowWriter[T]) close() error {
+	if err := p.pw.WriteStop(); err != nil {
+		p.file.Close()
+		return err
+	}
+	return p.file.Close()
+}
+
+// arrowRowWriter writes rows as an Arrow IPC file.
+type arrowRowWriter[T any] struct {
+	w *cute.ArrowWriter
+}
+
+func newArrowRowWriter[T any](path string) (*arrowRowWriter[T], error) {
+	var zero T
+	w, err := cute.NewArrowWriter(path, zero, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &arrowRowWriter[T]{w: w}, nil
+}
+
+func (a *arrowRowWriter[T]) write(row T) error {
+	return a.w.Write(row)
+}
+
+func (a *arrowRowWriter[T]) close() error {
+	return a.w.Close()
+}
+
+// newRowWriter returns a writer for format; record formats a row for
+// csv/tsv.
+func newRowWriter[T any](format, path string, header []string, record func(T) []string) (rowWriter[T], error) {
+	switch format {
+	case "tsv":
+		return newDelimitedWriter(path, '\t', header, record)
+	case "json":
+		return newJSONWriter[T](path)
+	case "parquet":
+		return newParquetRowWriter[T](path)
+	case "arrow":
+		return newArrowRowWriter[T](path)
+	default:
+		return newDelimitedWriter(path, ',', header, record)
+	}
+}
+
+func newUserRowWriter(format, path string) (rowWriter[userRow], error) {
+	return newRowWriter(format, path, userColumns, func(r userRow) []string {
+		return []string{
+			r.Name,
+			strconv.FormatFloat(r.AvgRating, 'f', 0, 64),
+			strconv.Itoa(int(r.Games)),
+			strconv.FormatFloat(r.OverallWinRate, 'f', 4, 64),
+			strconv.Itoa(int(r.EvalGames)),
+			strconv.Itoa(int(r.Crossings)),
+			strconv.FormatFloat(r.CrossingRate, 'f', 4, 64),
+			strconv.Itoa(int(r.Wins)),
+			strconv.FormatFloat(r.WinRate, 'f', 4, 64),
+			strconv.Itoa(int(r.NonCrossings)),
+			strconv.FormatFloat(r.NonCrossingWinRate, 'f', 4, 64),
+			r.NonCrossingWinReasons,
+			strconv.FormatFloat(r.AvgLoss, 'f', 2, 64),
+			strconv.Itoa(int(r.LossPositions)),
+			r.TopAttacks,
+			r.TopDefenses,
+			r.TopTechniques,
+		}
+	})
+}
+
+// matchupRow is one cell of the matchups mode in long format (json,
+// parquet and arrow).
+type matchupRow struct {
+	Tag         string  `json:"tag" parquet:"name=tag, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OpponentTag string  `json:"opponent_tag" parquet:"name=opponent_tag, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Games       int32   `json:"games" parquet:"name=games, type=INT32"`
+	Wins        int32   `json:"wins" parquet:"name=wins, type=INT32"`
+	WinRate     float64 `json:"win_rate" parquet:"name=win_rate, type=DOUBLE"`
+}
+
+// write emits the matchups: csv/tsv as the win rate and games matrices,
+// json/parquet/arrow as one row per non-empty cell of at least minGames games.
+func (m *matchupStats) write(format, path string, top, minGames int) error {
+	if format == "csv" || format == "tsv" {
+		out, err := openOutput(path)
+		if err != nil {
+			return err
+		}
+		comma := ','
+		if format == "tsv" {
+			comma = '\t'
+		}
+		if err := m.print(out, comma, top, minGames); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+	w, err := newRowWriter[matchupRow](format, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	tags := m.axis(top)
+	for _, a := range tags {
+		for _, b := range tags {
+			cell := m.cells[[2]string{a, b}]
+			if cell == nil || cell.games < minGames {
+				continue
+			}
+			row := matchupRow{Tag: a, OpponentTag: b, Games: int32(cell.games), Wins: int32(cell.wins),
+				WinRate: ratio(cell.wins, cell.games)}
+			if err := w.write(row); err != nil {
+				w.close()
+				return err
+			}
+		}
+	}
+	return w.close()
+}
+
+// checkFormat is shared by the flag validation of both modes.
+func checkFormat(format, outputPath string) error {
+	switch format {
+	case "csv", "tsv", "json":
+		return nil
+	case "parquet", "arrow":
+		if outputPath == "" {
+			return fmt.Errorf("-format %s requires -output", format)
+		}
+		return nil
+	default:
+		return fmt.Errorf("format must be csv, tsv, json, parquet or arrow")
+	}
+}
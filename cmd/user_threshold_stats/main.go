@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
@@ -12,13 +13,12 @@ import (
 	cute "cute/pkg/cute"
 
 	"github.com/xitongsys/parquet-go-source/local"
-	"github.com/xitongsys/parquet-go/reader"
 )
 
 type stats struct {
-	totalGames int
-	crossings  int
-	wins       int
+	totalGames int // games of the user
+	crossings  int // games the user crossed the threshold first
+	wins       int // wins after crossing
 }
 
 type userStats struct {
@@ -30,14 +30,35 @@ type userStats struct {
 
 func main() {
 	input := flag.String("input", "output.parquet", "input parquet file")
+	mode := flag.String("mode", "thresholds", "output mode: thresholds|trend")
+	buckets := flag.Int("buckets", 4, "trend mode: number of chronological buckets per user")
 	thresholdsArg := flag.String("thresholds", "300,500,1000", "comma-separated eval thresholds")
 	minGames := flag.Int("min-games", 10, "minimum games per user")
+	mateCrossing := flag.Bool("mate-crossing", true, "count a mate score as a crossing for the mating side whatever the threshold (false skips mate scores)")
+	crossingStability := flag.Int("crossing-stability", 0, "only count a crossing that holds for this many following evals")
+	minCrossings := flag.Int("min-crossings", 0, "drop users with fewer crossings at any threshold")
+	z := flag.Float64("z", 1.96, "z score of the Wilson confidence interval of win_rate (1.96 = 95%)")
+	usersArg := flag.String("users", "", "comma-separated player names: report only these users, reading only their games when the input has a player index (graph -player-index)")
 	parallel := flag.Int64("parallel", 4, "parquet read parallelism")
+	gameFilter := cute.AddGameFilterFlags(flag.CommandLine)
+	configPath := flag.String("config", "", "shared config file with flag defaults (default: $CUTE_CONFIG, config.json in the working directory or a parent, or $XDG_CONFIG_HOME/cute/config.json, if any)")
 	flag.Parse()
+	if _, err := cute.LoadCommandConfig("user_threshold_stats", flag.CommandLine, *configPath); err != nil {
+		fatal(err)
+	}
 
 	if *minGames <= 0 {
 		fatal(fmt.Errorf("min-games must be > 0"))
 	}
+	if *z <= 0 {
+		fatal(fmt.Errorf("z must be > 0"))
+	}
+	if *mode != "thresholds" && *mode != "trend" {
+		fatal(fmt.Errorf("mode must be thresholds or trend"))
+	}
+	if *buckets <= 0 {
+		fatal(fmt.Errorf("buckets must be > 0"))
+	}
 	thresholds, err := parseIntList(*thresholdsArg)
 	if err != nil {
 		fatal(err)
@@ -46,11 +67,28 @@ func main() {
 		fatal(fmt.Errorf("thresholds must be non-empty"))
 	}
 	sort.Ints(thresholds)
+	crossing := cute.CrossingOptions{MateCountsAsCross: *mateCrossing, RequireStability: *crossingStability}
 
-	records, err := readParquet(*input, *parallel)
+	onlyUsers := parseNameList(*usersArg)
+	if err := gameFilter.Validate(); err != nil {
+		fatal(err)
+	}
+	if err := cute.CheckParquetPerspective(*input); err != nil {
+		fatal(err)
+	}
+	records, err := readParquet(*input, *parallel, onlyUsers)
 	if err != nil {
 		fatal(err)
 	}
+	if gameFilter.Active() {
+		total := len(records)
+		records = gameFilter.Filter(records)
+		fmt.Fprintf(os.Stderr, "move count/phase filter: %d/%d games match\n", len(records), total)
+	}
+	listed := make(map[string]bool, len(onlyUsers))
+	for _, name := range onlyUsers {
+		listed[name] = true
+	}
 
 	userCounts := make(map[string]int)
 	for _, record := range records {
@@ -63,7 +101,7 @@ func main() {
 	}
 	eligible := make(map[string]struct{})
 	for name, count := range userCounts {
-		if count >= *minGames {
+		if count >= *minGames && (len(listed) == 0 || listed[name]) {
 			eligible[name] = struct{}{}
 		}
 	}
@@ -78,7 +116,7 @@ func main() {
 	}
 
 	for _, record := range records {
-		crossingSide := firstCrossingSide(record.MoveEvals, thresholds)
+		crossingSide := firstCrossingSide(record.MoveEvals, thresholds, crossing)
 		resultSide := winnerSide(record.Result)
 
 		if record.SenteName != "" {
@@ -88,8 +126,8 @@ func main() {
 				user.ratingCount++
 				for _, th := range thresholds {
 					st := user.byThreshold[th]
+					st.totalGames++
 					if crossingSide[th] == "sente" {
-						st.totalGames++
 						st.crossings++
 						if resultSide == "sente" {
 							st.wins++
@@ -106,8 +144,8 @@ func main() {
 				user.ratingCount++
 				for _, th := range thresholds {
 					st := user.byThreshold[th]
+					st.totalGames++
 					if crossingSide[th] == "gote" {
-						st.totalGames++
 						st.crossings++
 						if resultSide == "gote" {
 							st.wins++
@@ -118,15 +156,11 @@ func main() {
 		}
 	}
 
-	headers := []string{"user", "avg_rating"}
-	for _, th := range thresholds {
-		headers = append(headers, fmt.Sprintf("win_rate_%d", th))
-	}
-	fmt.Println(strings.Join(headers, ","))
-
 	userOrder := make([]string, 0, len(users))
-	for name := range users {
-		userOrder = append(userOrder, name)
+	for name, user := range users {
+		if enoughCrossings(user, thresholds, *minCrossings) {
+			userOrder = append(userOrder, name)
+		}
 	}
 	sort.Slice(userOrder, func(i, j int) bool {
 		left := users[userOrder[i]]
@@ -144,6 +178,24 @@ func main() {
 		}
 		return leftAvg > rightAvg
 	})
+
+	if *mode == "trend" {
+		printTrend(records, userOrder, thresholds, crossing, *buckets, *z)
+		return
+	}
+
+	headers := []string{"user", "avg_rating"}
+	for _, th := range thresholds {
+		headers = append(headers,
+			fmt.Sprintf("total_games_%d", th),
+			fmt.Sprintf("crossings_%d", th),
+			fmt.Sprintf("crossing_rate_%d", th),
+			fmt.Sprintf("win_rate_%d", th),
+			fmt.Sprintf("win_rate_ci_low_%d", th),
+			fmt.Sprintf("win_rate_ci_high_%d", th),
+		)
+	}
+	fmt.Println(strings.Join(headers, ","))
 	for _, name := range userOrder {
 		user := users[name]
 		avgRating := 0.0
@@ -153,30 +205,81 @@ func main() {
 		row := []string{name, fmt.Sprintf("%.1f", avgRating)}
 		for _, th := range thresholds {
 			st := user.byThreshold[th]
+			crossingRate := 0.0
+			if st.totalGames > 0 {
+				crossingRate = float64(st.crossings) / float64(st.totalGames)
+			}
 			winRate := 0.0
 			if st.crossings > 0 {
 				winRate = float64(st.wins) / float64(st.crossings)
 			}
-			row = append(row, fmt.Sprintf("%.6f", winRate))
+			low, high := wilsonInterval(st.wins, st.crossings, *z)
+			row = append(row,
+				strconv.Itoa(st.totalGames),
+				strconv.Itoa(st.crossings),
+				fmt.Sprintf("%.6f", crossingRate),
+				fmt.Sprintf("%.6f", winRate),
+				fmt.Sprintf("%.6f", low),
+				fmt.Sprintf("%.6f", high),
+			)
 		}
 		fmt.Println(strings.Join(row, ","))
 	}
 }
 
-func readParquet(path string, parallel int64) ([]cute.GameRecord, error) {
+// enoughCrossings reports whether the user crossed every threshold at least
+// min times.
+func enoughCrossings(user *userStats, thresholds []int, min int) bool {
+	for _, th := range thresholds {
+		if user.byThreshold[th].crossings < min {
+			return false
+		}
+	}
+	return true
+}
+
+// wilsonInterval returns the Wilson score interval of wins/n for the given
+// z score. With n == 0 it is the uninformative [0, 1].
+func wilsonInterval(wins, n int, z float64) (float64, float64) {
+	if n == 0 {
+		return 0, 1
+	}
+	p := float64(wins) / float64(n)
+	nf := float64(n)
+	z2 := z * z
+	denom := 1 + z2/nf
+	center := (p + z2/(2*nf)) / denom
+	margin := z * math.Sqrt(p*(1-p)/nf+z2/(4*nf*nf)) / denom
+	return math.Max(0, center-margin), math.Min(1, center+margin)
+}
+
+// readParquet reads every record of path, or with users only the games of
+// those players.
+func readParquet(path string, parallel int64, users []string) ([]cute.GameRecord, error) {
 	absPath := path
 	if !filepath.IsAbs(path) {
 		if resolved, err := filepath.Abs(path); err == nil {
 			absPath = resolved
 		}
 	}
+	if len(users) > 0 {
+		var records []cute.GameRecord
+		indexed, err := cute.ReadPlayerGames(absPath, parallel, users, nil, func(record cute.GameRecord) error {
+			records = append(records, record)
+			return nil
+		})
+		if err == nil && !indexed {
+			fmt.Fprintf(os.Stderr, "no current player index for %s; scanned the whole file\n", path)
+		}
+		return records, err
+	}
 	fileReader, err := local.NewLocalFileReader(absPath)
 	if err != nil {
 		return nil, err
 	}
 	defer fileReader.Close()
 
-	parquetReader, err := reader.NewParquetReader(fileReader, new(cute.GameRecord), parallel)
+	parquetReader, err := cute.NewGameRecordReader(fileReader, parallel)
 	if err != nil {
 		return nil, err
 	}
@@ -199,6 +302,17 @@ func readParquet(path string, parallel int64) ([]cute.GameRecord, error) {
 	return records, nil
 }
 
+// parseNameList splits a comma-separated list of player names.
+func parseNameList(raw string) []string {
+	var names []string
+	for _, part := range strings.Split(raw, ",") {
+		if name := strings.TrimSpace(part); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 func parseIntList(raw string) ([]int, error) {
 	trimmed := strings.TrimSpace(raw)
 	if trimmed == "" {
@@ -220,37 +334,13 @@ func parseIntList(raw string) ([]int, error) {
 	return values, nil
 }
 
-func firstCrossingSide(evals []cute.MoveEval, thresholds []int) map[int]string {
+// firstCrossingSide returns the first crossing side for each threshold;
+// opts.Threshold is ignored.
+func firstCrossingSide(evals []cute.MoveEval, thresholds []int, opts cute.CrossingOptions) map[int]string {
 	result := make(map[int]string, len(thresholds))
-	remaining := make(map[int]struct{}, len(thresholds))
 	for _, th := range thresholds {
-		remaining[th] = struct{}{}
-		result[th] = "none"
-	}
-	for _, eval := range evals {
-		if len(remaining) == 0 {
-			break
-		}
-		for th := range remaining {
-			if eval.ScoreType == "mate" {
-				if eval.ScoreValue >= 0 {
-					result[th] = "sente"
-				} else {
-					result[th] = "gote"
-				}
-				delete(remaining, th)
-				continue
-			}
-			if eval.ScoreValue >= int32(th) {
-				result[th] = "sente"
-				delete(remaining, th)
-				continue
-			}
-			if eval.ScoreValue <= -int32(th) {
-				result[th] = "gote"
-				delete(remaining, th)
-			}
-		}
+		opts.Threshold = th
+		result[th] = cute.FirstCrossing(evals, opts).Side
 	}
 	return result
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+)
+
+// trendGame is one game of a user in trend mode.
+type trendGame struct {
+	start   string // StartTime, "" when the KIF had no 開始日時
+	gameID  string
+	crossed []bool // per threshold: the user crossed it first
+	won     bool
+}
+
+// gameNumber returns the numeric part of a game ID such as "36940457.kif".
+func gameNumber(gameID string) (int64, bool) {
+	n, err := strconv.ParseInt(strings.TrimSuffix(gameID, filepath.Ext(gameID)), 10, 64)
+	return n, err == nil
+}
+
+// sortGames orders games chronologically: by start time, then by game ID
+// (numerically when both are numbers). Games without a start time come
+// first, in game ID order.
+func sortGames(games []trendGame) {
+	sort.SliceStable(games, func(i, j int) bool {
+		a, b := games[i], games[j]
+		if a.start != b.start {
+			return a.start < b.start
+		}
+		an, aok := gameNumber(a.gameID)
+		bn, bok := gameNumber(b.gameID)
+		if aok && bok {
+			return an < bn
+		}
+		return a.gameID < b.gameID
+	})
+}
+
+// printTrend splits each user's games into buckets of consecutive games
+// and prints the crossing and win rates of every bucket, one row per user
+// and bucket, users in userOrder.
+func printTrend(records []cute.GameRecord, userOrder []string, thresholds []int, crossing cute.CrossingOptions, buckets int, z float64) {
+	games := make(map[string][]trendGame, len(userOrder))
+	for _, name := range userOrder {
+		games[name] = nil
+	}
+	for _, record := range records {
+		crossingSide := firstCrossingSide(record.MoveEvals, thresholds, crossing)
+		resultSide := winnerSide(record.Result)
+		for _, side := range []struct {
+			name string
+			side string
+		}{{record.SenteName, "sente"}, {record.GoteName, "gote"}} {
+			if _, ok := games[side.name]; !ok || side.name == "" {
+				continue
+			}
+			game := trendGame{
+				start:   record.StartTime,
+				gameID:  record.GameID,
+				crossed: make([]bool, len(thresholds)),
+				won:     resultSide == side.side,
+			}
+			for i, th := range thresholds {
+				game.crossed[i] = crossingSide[th] == side.side
+			}
+			games[side.name] = append(games[side.name], game)
+		}
+	}
+
+	headers := []string{"user", "bucket", "first_start", "last_start", "first_game_id", "last_game_id", "games"}
+	for _, th := range thresholds {
+		headers = append(headers,
+			fmt.Sprintf("crossings_%d", th),
+			fmt.Sprintf("crossing_rate_%d", th),
+			fmt.Sprintf("win_rate_%d", th),
+			fmt.Sprintf("win_rate_ci_low_%d", th),
+			fmt.Sprintf("win_rate_ci_high_%d", th),
+		)
+	}
+	fmt.Println(strings.Join(headers, ","))
+
+	for _, name := range userOrder {
+		list := games[name]
+		sortGames(list)
+		k := buckets
+		if k > len(list) {
+			k = len(list)
+		}
+		for b := 0; b < k; b++ {
+			bucket := list[b*len(list)/k : (b+1)*len(list)/k]
+			first, last := bucket[0], bucket[len(bucket)-1]
+			row := []string{name, strconv.Itoa(b + 1), first.start, last.start, first.gameID, last.gameID, strconv.Itoa(len(bucket))}
+			for i := range thresholds {
+				crossings, wins := 0, 0
+				for _, game := range bucket {
+					if game.crossed[i] {
+						crossings++
+						if game.won {
+							wins++
+						}
+					}
+				}
+				winRate := 0.0
+				if crossings > 0 {
+					winRate = float64(wins) / float64(crossings)
+				}
+				low, high := wilsonInterval(wins, crossings, z)
+				row = append(row,
+					strconv.Itoa(crossings),
+					fmt.Sprintf("%.6f", float64(crossings)/float64(len(bucket))),
+					fmt.Sprintf("%.6f", winRate),
+					fmt.Sprintf("%.6f", low),
+					fmt.Sprintf("%.6f", high),
+				)
+			}
+			fmt.Println(strings.Join(row, ","))
+		}
+	}
+}
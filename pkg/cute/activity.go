@@ -0,0 +1,123 @@
+package cute
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// packedActivityVersion is the first byte of PackActivity output.
+const packedActivityVersion = 1
+
+// PlyActivity holds cheap piece activity metrics of both sides in the
+// position after a ply (ply 0 is the initial position).
+type PlyActivity struct {
+	Ply int32
+	// Mobility is the number of legal moves of each side, counted for
+	// the side not to move as if it were its turn.
+	SenteMobility, GoteMobility int32
+	// Hand is the number of pieces in hand.
+	SenteHand, GoteHand int32
+	// Promoted is the number of promoted pieces on the board.
+	SentePromoted, GotePromoted int32
+}
+
+// MobilityDiff is SenteMobility - GoteMobility.
+func (a PlyActivity) MobilityDiff() int32 {
+	return a.SenteMobility - a.GoteMobility
+}
+
+// Activity replays the main line and returns the PlyActivity of every
+// position, from the initial position to the one after the last move.
+// Generating the legal moves of both sides makes it cost a few
+// milliseconds for a typical game, nothing next to an engine evaluation.
+func (b *Board) Activity() ([]PlyActivity, error) {
+	var activity []PlyActivity
+	err := b.ForEachPly(func(ply int, pos *Position, _ string) error {
+		activity = append(activity, pos.activity(ply))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+func (p *Position) activity(ply int) PlyActivity {
+	a := PlyActivity{Ply: int32(ply)}
+	other := p.Clone()
+	other.turn ^= 1
+	mobility := map[Color]int32{
+		p.turn:     int32(len(p.LegalMoves())),
+		other.turn: int32(len(other.LegalMoves())),
+	}
+	a.SenteMobility, a.GoteMobility = mobility[Black], mobility[White]
+	for _, n := range p.hands[Black] {
+		a.SenteHand += int32(n)
+	}
+	for _, n := range p.hands[White] {
+		a.GoteHand += int32(n)
+	}
+	for _, row := range p.board {
+		for _, piece := range row {
+			if piece == nil || !piece.promoted {
+				continue
+			}
+			if piece.color == Black {
+				a.SentePromoted++
+			} else {
+				a.GotePromoted++
+			}
+		}
+	}
+	return a
+}
+
+// PackActivity encodes activity, which must hold consecutive plies from
+// 0 as Board.Activity returns, compactly: a version byte, then the six
+// metrics of each ply as uvarints (about 7 bytes per ply). The plies are
+// implied by the order.
+func PackActivity(activity []PlyActivity) []byte {
+	if len(activity) == 0 {
+		return nil
+	}
+	buf := make([]byte, 0, 1+7*len(activity))
+	buf = append(buf, packedActivityVersion)
+	for _, a := range activity {
+		for _, v := range []int32{a.SenteMobility, a.GoteMobility, a.SenteHand, a.GoteHand, a.SentePromoted, a.GotePromoted} {
+			buf = binary.AppendUvarint(buf, uint64(v))
+		}
+	}
+	return buf
+}
+
+// UnpackActivity decodes the output of PackActivity. Empty data yields
+// nil.
+func UnpackActivity(data []byte) ([]PlyActivity, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if data[0] != packedActivityVersion {
+		return nil, fmt.Errorf("packed activity: unknown version %d", data[0])
+	}
+	data = data[1:]
+	var activity []PlyActivity
+	for len(data) > 0 {
+		var v [6]int32
+		for i := range v {
+			u, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errors.New("packed activity: truncated")
+			}
+			v[i] = int32(u)
+			data = data[n:]
+		}
+		activity = append(activity, PlyActivity{
+			Ply:           int32(len(activity)),
+			SenteMobility: v[0], GoteMobility: v[1],
+			SenteHand: v[2], GoteHand: v[3],
+			SentePromoted: v[4], GotePromoted: v[5],
+		})
+	}
+	return activity, nil
+}
@@ -0,0 +1,57 @@
+package cute_test
+
+import (
+	"reflect"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestBoardActivity(t *testing.T) {
+	board, err := cute.BoardFromKIF([]string{
+		"手合割：平手",
+		"手数----指手---------消費時間--",
+		"   1 ７六歩(77)   ( 0:00/00:00:00)",
+		"   2 ３四歩(33)   ( 0:00/00:00:00)",
+		"   3 ２二角成(88)   ( 0:00/00:00:00)",
+		"   4 同　銀(31)   ( 0:00/00:00:00)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	activity, err := board.Activity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activity) != 5 {
+		t.Fatalf("got %d plies, want 5", len(activity))
+	}
+	if a := activity[0]; a.SenteMobility != 30 || a.GoteMobility != 30 || a.MobilityDiff() != 0 {
+		t.Errorf("initial position: %+v", a)
+	}
+	if a := activity[3]; a.SenteHand != 1 || a.SentePromoted != 1 {
+		t.Errorf("after 2二角成: %+v", a)
+	}
+	if a := activity[4]; a.Ply != 4 || a.SenteHand != 1 || a.GoteHand != 1 || a.SentePromoted != 0 {
+		t.Errorf("after 同銀: %+v", a)
+	}
+
+	packed := cute.PackActivity(activity)
+	got, err := cute.UnpackActivity(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, activity) {
+		t.Errorf("round trip: got %+v, want %+v", got, activity)
+	}
+	record := cute.GameRecord{ActivityPacked: string(packed)}
+	if got, err := record.Activity(); err != nil || len(got) != 5 {
+		t.Errorf("record activity: %v %v", got, err)
+	}
+	if got, err := (cute.GameRecord{}).Activity(); err != nil || got != nil {
+		t.Errorf("empty record: %v %v", got, err)
+	}
+	if _, err := cute.UnpackActivity(packed[:len(packed)-1]); err == nil {
+		t.Error("truncated data: no error")
+	}
+}
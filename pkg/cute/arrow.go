@@ -0,0 +1,253 @@
+package cute
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+)
+
+// arrowBatchRows is the number of rows per Arrow record batch.
+const arrowBatchRows = 4096
+
+// ArrowWriter writes rows of a parquet-tagged struct to an Arrow IPC file
+// (Feather v2), readable by pyarrow.feather, polars and duckdb without the
+// nested list encoding quirks of parquet-go. Columns are named and typed
+// after the parquet tags: string, int32, int64, float64 and bool fields,
+// pointers to them (nullable), nested structs and slices of structs.
+type ArrowWriter struct {
+	file     *os.File
+	rowType  reflect.Type
+	builder  *array.RecordBuilder
+	writer   *ipc.FileWriter
+	buffered int
+	// indexes caches arrowFieldIndex per struct type.
+	indexes map[reflect.Type][]int
+}
+
+// NewArrowWriter creates path for rows of the struct type of sample.
+// metadata, if non-empty, is stored as schema metadata.
+func NewArrowWriter(path string, sample any, metadata map[string]string) (*ArrowWriter, error) {
+	rowType := reflect.TypeOf(sample)
+	if rowType.Kind() == reflect.Pointer {
+		rowType = rowType.Elem()
+	}
+	fields, err := arrowFields(rowType)
+	if err != nil {
+		return nil, err
+	}
+	var md *arrow.Metadata
+	if len(metadata) > 0 {
+		var keys, values []string
+		for k, v := range metadata {
+			keys = append(keys, k)
+			values = append(values, v)
+		}
+		m := arrow.NewMetadata(keys, values)
+		md = &m
+	}
+	schema := arrow.NewSchema(fields, md)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	mem := memory.NewGoAllocator()
+	writer, err := ipc.NewFileWriter(file, ipc.WithSchema(schema), ipc.WithAllocator(mem))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &ArrowWriter{
+		file:    file,
+		rowType: rowType,
+		builder: array.NewRecordBuilder(mem, schema),
+		writer:  writer,
+		indexes: make(map[reflect.Type][]int),
+	}, nil
+}
+
+// Write appends one row (a struct of the type passed to NewArrowWriter).
+func (w *ArrowWriter) Write(row any) error {
+	v := reflect.ValueOf(row)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Type() != w.rowType {
+		return fmt.Errorf("arrow: row is %s, want %s", v.Type(), w.rowType)
+	}
+	index := w.fieldIndex(w.rowType)
+	for i, b := range w.builder.Fields() {
+		w.append(b, v.Field(index[i]))
+	}
+	w.buffered++
+	if w.buffered >= arrowBatchRows {
+		return w.flush()
+	}
+	return nil
+}
+
+// Close writes the buffered rows and the file footer.
+func (w *ArrowWriter) Close() error {
+	err := w.flush()
+	if cerr := w.writer.Close(); err == nil {
+		err = cerr
+	}
+	w.builder.Release()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *ArrowWriter) flush() error {
+	if w.buffered == 0 {
+		return nil
+	}
+	record := w.builder.NewRecord()
+	defer record.Release()
+	w.buffered = 0
+	return w.writer.Write(record)
+}
+
+// WriteArrowIPC writes records to path as an Arrow IPC file with the
+// GameRecord columns of WriteParquet.
+func WriteArrowIPC(path string, records <-chan GameRecord) error {
+	return WriteArrowIPCMeta(path, records, nil)
+}
+
+// WriteArrowIPCMeta is WriteArrowIPC that also stores meta as the
+// "cute.meta" schema metadata, like WriteParquetMeta does in the parquet
+// footer. Unlike there, meta is called before the first record is
+// written, since the schema comes first in an Arrow file.
+func WriteArrowIPCMeta(path string, records <-chan GameRecord, meta func() ParquetMeta) error {
+	fmt.Printf("writing arrow to %s\n", path)
+	var metadata map[string]string
+	if meta != nil {
+		data, err := json.Marshal(meta())
+		if err != nil {
+			return err
+		}
+		metadata = map[string]string{parquetMetaKey: string(data)}
+	}
+	w, err := NewArrowWriter(path, GameRecord{}, metadata)
+	if err != nil {
+		return err
+	}
+	for record := range records {
+		if err := w.Write(record); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// arrowFieldIndex returns the indexes of the fields of t that have a
+// parquet column, in order.
+func arrowFieldIndex(t reflect.Type) []int {
+	var index []int
+	for i := 0; i < t.NumField(); i++ {
+		if parseParquetName(t.Field(i).Tag.Get("parquet")) != "" {
+			index = append(index, i)
+		}
+	}
+	return index
+}
+
+func arrowFields(t reflect.Type) ([]arrow.Field, error) {
+	var fields []arrow.Field
+	for _, i := range arrowFieldIndex(t) {
+		f := t.Field(i)
+		ft := f.Type
+		nullable := false
+		if ft.Kind() == reflect.Pointer {
+			ft, nullable = ft.Elem(), true
+		}
+		dt, err := arrowType(ft)
+		if err != nil {
+			return nil, fmt.Errorf("arrow: field %s: %w", f.Name, err)
+		}
+		fields = append(fields, arrow.Field{
+			Name:     parseParquetName(f.Tag.Get("parquet")),
+			Type:     dt,
+			Nullable: nullable,
+		})
+	}
+	return fields, nil
+}
+
+func arrowType(t reflect.Type) (arrow.DataType, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return arrow.BinaryTypes.String, nil
+	case reflect.Int32:
+		return arrow.PrimitiveTypes.Int32, nil
+	case reflect.Int64:
+		return arrow.PrimitiveTypes.Int64, nil
+	case reflect.Float64:
+		return arrow.PrimitiveTypes.Float64, nil
+	case reflect.Bool:
+		return arrow.FixedWidthTypes.Boolean, nil
+	case reflect.Struct:
+		fields, err := arrowFields(t)
+		if err != nil {
+			return nil, err
+		}
+		return arrow.StructOf(fields...), nil
+	case reflect.Slice:
+		elem, err := arrowType(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return arrow.ListOf(elem), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+func (w *ArrowWriter) fieldIndex(t reflect.Type) []int {
+	index, ok := w.indexes[t]
+	if !ok {
+		index = arrowFieldIndex(t)
+		w.indexes[t] = index
+	}
+	return index
+}
+
+func (w *ArrowWriter) append(b array.Builder, v reflect.Value) {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			b.AppendNull()
+			return
+		}
+		v = v.Elem()
+	}
+	switch b := b.(type) {
+	case *array.StringBuilder:
+		b.Append(v.String())
+	case *array.Int32Builder:
+		b.Append(int32(v.Int()))
+	case *array.Int64Builder:
+		b.Append(v.Int())
+	case *array.Float64Builder:
+		b.Append(v.Float())
+	case *array.BooleanBuilder:
+		b.Append(v.Bool())
+	case *array.StructBuilder:
+		b.Append(true)
+		for i, field := range w.fieldIndex(v.Type()) {
+			w.append(b.FieldBuilder(i), v.Field(field))
+		}
+	case *array.ListBuilder:
+		b.Append(true)
+		values := b.ValueBuilder()
+		for i := 0; i < v.Len(); i++ {
+			w.append(values, v.Index(i))
+		}
+	}
+}
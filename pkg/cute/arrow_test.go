@@ -0,0 +1,126 @@
+package cute_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cute "cute/pkg/cute"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+)
+
+func TestWriteArrowIPC(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "games.arrow")
+	records := make(chan cute.GameRecord, 2)
+	records <- cute.GameRecord{
+		GameID: "1.kif", SenteName: "a", SenteRating: 1500, Result: "sente_win",
+		MoveEvals: []cute.MoveEval{{Ply: 1, ScoreType: "cp", ScoreValue: 30}, {Ply: 2, ScoreType: "mate", ScoreValue: -3}},
+	}
+	records <- cute.GameRecord{GameID: "2.kif", Result: "draw"}
+	close(records)
+	meta := cute.ParquetMeta{Engines: []cute.EngineInfo{{Binary: "engine"}}}
+	if err := cute.WriteArrowIPCMeta(path, records, func() cute.ParquetMeta { return meta }); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := ipc.NewFileReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	schema := r.Schema()
+	if got := schema.Field(0).Name; got != "game_id" {
+		t.Fatalf("first column %q", got)
+	}
+	wantEvals := arrow.ListOf(arrow.StructOf(
+		arrow.Field{Name: "ply", Type: arrow.PrimitiveTypes.Int32},
+		arrow.Field{Name: "score_type", Type: arrow.BinaryTypes.String},
+		arrow.Field{Name: "score_value", Type: arrow.PrimitiveTypes.Int32},
+		arrow.Field{Name: "depth", Type: arrow.PrimitiveTypes.Int32},
+	))
+	if got := schema.Field(8); got.Name != "move_evals" || !arrow.TypeEqual(got.Type, wantEvals) {
+		t.Fatalf("move_evals field %+v", got)
+	}
+	idx := schema.Metadata().FindKey("cute.meta")
+	if idx < 0 {
+		t.Fatal("missing cute.meta")
+	}
+	var gotMeta cute.ParquetMeta
+	if err := json.Unmarshal([]byte(schema.Metadata().Values()[idx]), &gotMeta); err != nil || gotMeta.Engines[0].Binary != "engine" {
+		t.Fatalf("meta %+v (%v)", gotMeta, err)
+	}
+
+	rec, err := r.Record(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.NumRows() != 2 {
+		t.Fatalf("rows %d", rec.NumRows())
+	}
+	ids := rec.Column(0).(*array.String)
+	if ids.Value(0) != "1.kif" || ids.Value(1) != "2.kif" {
+		t.Fatalf("game ids %q %q", ids.Value(0), ids.Value(1))
+	}
+	evals := rec.Column(8).(*array.List)
+	offsets := evals.Offsets()
+	if offsets[1]-offsets[0] != 2 || offsets[2]-offsets[1] != 0 {
+		t.Fatalf("move_evals offsets %v", offsets)
+	}
+	values := evals.ListValues().(*array.Struct)
+	if got := values.Field(2).(*array.Int32).Value(1); got != -3 {
+		t.Fatalf("second score_value %d", got)
+	}
+}
+
+func TestArrowWriterNullable(t *testing.T) {
+	type row struct {
+		Name  *string `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+		Score float64 `parquet:"name=score, type=DOUBLE"`
+	}
+	path := filepath.Join(t.TempDir(), "rows.arrow")
+	w, err := cute.NewArrowWriter(path, row{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "x"
+	for _, r := range []row{{Name: &name, Score: 1.5}, {Score: 2}} {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := ipc.NewFileReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	rec, err := r.Record(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := rec.Column(0).(*array.String)
+	if !names.IsValid(0) || names.Value(0) != "x" || names.IsValid(1) {
+		t.Fatalf("names valid=%v,%v", names.IsValid(0), names.IsValid(1))
+	}
+	if got := rec.Column(1).(*array.Float64).Value(1); got != 2 {
+		t.Fatalf("score %v", got)
+	}
+}
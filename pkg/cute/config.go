@@ -2,26 +2,113 @@ package cute
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// Config is config.json, shared by all commands. Engine and Millis
+// configure the engine of graph, book -evaluate and enginebench; the other
+// settings are defaults for the flags of every command that has them (see
+// FlagDefaults), and flags given on the command line win. Relative paths
+// are relative to the directory of the config file.
+//
+//	{
+//	  "engine": "YaneuraOu/source/YaneuraOu-by-gcc",
+//	  "millis": 1000,
+//	  "engine_options": {"USI_Hash": "1024"},
+//	  "eval_dir": "eval", "eval_file": "nn.bin", "fv_scale": 16,
+//	  "kif_dir": "kif",
+//	  "parquet": "out/evals.parquet",
+//	  "opening_db": "out/kif_tags.parquet",
+//	  "thresholds": [300, 500, 1000],
+//	  "workers": 16,
+//	  "parallel": 8,
+//	  "flags": {"*": {"ignore-first-moves": "10"}, "analyze": {"group-by": "rating"}}
+//	}
 type Config struct {
 	Engine string `json:"engine"`
 	Millis int    `json:"millis"`
+	// EngineOptions are sent with setoption at the handshake, overriding
+	// the defaults (see Session.SetOptions).
+	EngineOptions map[string]string `json:"engine_options,omitempty"`
+	// EvalDir, EvalFile and FVScale select the engine's evaluation
+	// function (see EvalOptions); they are the defaults of the -eval-dir,
+	// -eval-file and -fv-scale flags of the commands that run an engine
+	// and override EngineOptions.
+	EvalDir  string `json:"eval_dir,omitempty"`
+	EvalFile string `json:"eval_file,omitempty"`
+	FVScale  int    `json:"fv_scale,omitempty"`
+
+	// KIFDir is the KIF input of graph, annotate, book, classify and
+	// kifcheck (-input) and movequality and report -kif-dir.
+	KIFDir string `json:"kif_dir,omitempty"`
+	// Parquet is the GameRecord parquet read by the analysis commands
+	// (-input, stats -parquet).
+	Parquet string `json:"parquet,omitempty"`
+	// OpeningDB is the opening tag parquet (-opening-db).
+	OpeningDB string `json:"opening_db,omitempty"`
+	// Thresholds are the eval thresholds (-thresholds); the first one is
+	// the -threshold of logreg and stats.
+	Thresholds []int `json:"thresholds,omitempty"`
+	// Workers is the number of engines or CPU workers (graph and annotate
+	// -process-num, -workers).
+	Workers int `json:"workers,omitempty"`
+	// Parallel is the parquet reader/writer parallelism (-parallel).
+	Parallel int `json:"parallel,omitempty"`
+	// Flags sets any flag by command name (the directory under cmd/);
+	// "*" applies to every command that has the flag.
+	Flags map[string]map[string]string `json:"flags,omitempty"`
+
+	// dir is the directory of the file the config was loaded from.
+	dir string
 }
 
+// ConfigFileName is the name FindConfigPath looks for.
+const ConfigFileName = "config.json"
+
+// ConfigEnv names an environment variable with the config path, which
+// takes precedence over searching.
+const ConfigEnv = "CUTE_CONFIG"
+
+// ErrConfigNotFound is returned (wrapped, with the searched locations)
+// when FindConfigPath finds no config.
+var ErrConfigNotFound = errors.New(ConfigFileName + " not found")
+
+// FindConfigPath returns the path and directory of the config: the file
+// named by $CUTE_CONFIG if set, else config.json in the working directory
+// or the nearest parent, else cute/config.json in $XDG_CONFIG_HOME (or,
+// when it is unset, the user config directory: ~/.config on Linux).
 func FindConfigPath() (string, string, error) {
+	if env := os.Getenv(ConfigEnv); env != "" {
+		abs, err := filepath.Abs(env)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return "", "", fmt.Errorf("$%s: %v", ConfigEnv, err)
+		}
+		return abs, filepath.Dir(abs), nil
+	}
+	var searched []string
+	try := func(path string) bool {
+		searched = append(searched, path)
+		_, err := os.Stat(path)
+		return err == nil
+	}
 	cwd, err := os.Getwd()
 	if err != nil {
 		return "", "", err
 	}
 	dir := cwd
 	for {
-		path := filepath.Join(dir, "config.json")
-		if _, err := os.Stat(path); err == nil {
-			return path, filepath.Dir(path), nil
+		if path := filepath.Join(dir, ConfigFileName); try(path) {
+			return path, dir, nil
 		}
 		parent := filepath.Dir(dir)
 		if parent == dir {
@@ -29,7 +116,16 @@ func FindConfigPath() (string, string, error) {
 		}
 		dir = parent
 	}
-	return "", "", fmt.Errorf("config.json not found from %s", cwd)
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome, _ = os.UserConfigDir()
+	}
+	if configHome != "" {
+		if path := filepath.Join(configHome, "cute", ConfigFileName); try(path) {
+			return path, filepath.Dir(path), nil
+		}
+	}
+	return "", "", fmt.Errorf("%w ($%s is unset); searched:\n  %s", ErrConfigNotFound, ConfigEnv, strings.Join(searched, "\n  "))
 }
 
 func LoadConfig(path string) (Config, error) {
@@ -39,7 +135,138 @@ func LoadConfig(path string) (Config, error) {
 	}
 	var cfg Config
 	if err := json.Unmarshal(data, &cfg); err != nil {
-		return Config{}, err
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		cfg.dir = filepath.Dir(abs)
 	}
 	return cfg, nil
 }
+
+// LoadCommandConfig loads the config of a command that does not need an
+// engine: path, or when path is "" the config FindConfigPath finds, whose
+// absence is not an error. The config is applied to the flags of
+// fs with ApplyFlags.
+func LoadCommandConfig(command string, fs *flag.FlagSet, path string) (Config, error) {
+	if path == "" {
+		found, _, err := FindConfigPath()
+		if errors.Is(err, ErrConfigNotFound) {
+			return Config{}, nil
+		}
+		if err != nil {
+			return Config{}, err
+		}
+		path = found
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return cfg, cfg.ApplyFlags(command, fs)
+}
+
+// path resolves a path setting against the config directory.
+func (cfg Config) path(p string) string {
+	if p == "" || filepath.IsAbs(p) || cfg.dir == "" {
+		return p
+	}
+	return filepath.Join(cfg.dir, p)
+}
+
+// EvalOptions returns the eval_dir, eval_file and fv_scale settings, with
+// eval_dir resolved against the config directory, for commands that have
+// no flags for them.
+func (cfg Config) EvalOptions() EvalOptions {
+	return EvalOptions{Dir: cfg.path(cfg.EvalDir), File: cfg.EvalFile, FVScale: cfg.FVScale}
+}
+
+// FlagDefaults returns the flag values cfg sets for command, by flag name.
+// Values from Flags win over the typed settings, and Flags[command] over
+// Flags["*"].
+func (cfg Config) FlagDefaults(command string) map[string]string {
+	values := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" && value != "0" {
+			values[name] = value
+		}
+	}
+	switch command {
+	case "annotate", "graph", "book", "classify", "kifcheck":
+		set("input", cfg.path(cfg.KIFDir))
+	case "movequality", "report":
+		set("kif-dir", cfg.path(cfg.KIFDir))
+	}
+	switch command {
+	case "stats":
+		set("parquet", cfg.path(cfg.Parquet))
+	case "analyze", "evalcluster", "evalcurve", "export-sqlite", "glm", "logreg", "movequality", "parquet-check", "report", "rerate", "sample", "serve", "user_threshold_stats":
+		set("input", cfg.path(cfg.Parquet))
+	}
+	switch command {
+	case "analyze", "evalcurve", "graph", "sample", "stats":
+		set("opening-db", cfg.path(cfg.OpeningDB))
+	}
+	if len(cfg.Thresholds) > 0 {
+		switch command {
+		case "analyze", "report", "user_threshold_stats":
+			parts := make([]string, len(cfg.Thresholds))
+			for i, t := range cfg.Thresholds {
+				parts[i] = strconv.Itoa(t)
+			}
+			set("thresholds", strings.Join(parts, ","))
+		case "glm", "logreg", "stats":
+			set("threshold", strconv.Itoa(cfg.Thresholds[0]))
+		}
+	}
+	switch command {
+	case "annotate", "graph":
+		set("process-num", strconv.Itoa(cfg.Workers))
+	case "book", "classify", "kifcheck", "logreg":
+		set("workers", strconv.Itoa(cfg.Workers))
+	}
+	switch command {
+	case "annotate", "enginebench", "graph":
+		set("eval-dir", cfg.path(cfg.EvalDir))
+		set("eval-file", cfg.EvalFile)
+		set("fv-scale", strconv.Itoa(cfg.FVScale))
+	}
+	set("parallel", strconv.Itoa(cfg.Parallel))
+	for _, section := range []string{"*", command} {
+		for name, value := range cfg.Flags[section] {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// ApplyFlags sets the flags of fs that were not given on the command line
+// to the values cfg has for command. Settings for flags fs does not have
+// are ignored, except in Flags[command], where they are errors.
+func (cfg Config) ApplyFlags(command string, fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	values := cfg.FlagDefaults(command)
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil {
+			if _, ok := cfg.Flags[command][name]; ok {
+				return fmt.Errorf("config: %s has no flag -%s", command, name)
+			}
+			continue
+		}
+		if given[name] {
+			continue
+		}
+		// Setting the Value directly keeps the flag unset for fs.Visit,
+		// like a default.
+		if err := f.Value.Set(values[name]); err != nil {
+			return fmt.Errorf("config: %s -%s: %w", command, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package cute_test
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestConfigApplyFlags(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	data := `{
+  "engine": "engine",
+  "parquet": "out/evals.parquet",
+  "thresholds": [500, 1000],
+  "parallel": 8,
+  "eval_dir": "eval", "eval_file": "nn.bin",
+  "flags": {"*": {"ignore-first-moves": "10", "no-such-flag": "1"}, "analyze": {"group-by": "rating"}}
+}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	input := fs.String("input", "output.parquet", "")
+	thresholds := fs.String("thresholds", "300", "")
+	parallel := fs.Int64("parallel", 4, "")
+	ignore := fs.Int("ignore-first-moves", 0, "")
+	groupBy := fs.String("group-by", "", "")
+	if err := fs.Parse([]string{"-parallel", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cute.LoadCommandConfig("analyze", fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if *input != filepath.Join(dir, "out/evals.parquet") || *thresholds != "500,1000" || *ignore != 10 || *groupBy != "rating" {
+		t.Fatalf("input=%q thresholds=%q ignore=%d group-by=%q", *input, *thresholds, *ignore, *groupBy)
+	}
+	// The command line wins, and config values stay defaults for Visit.
+	if *parallel != 2 {
+		t.Fatalf("parallel = %d, want the command line's 2", *parallel)
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "parallel" {
+			t.Errorf("-%s is reported as given", f.Name)
+		}
+	})
+
+	// stats reads the first threshold as -threshold; a flag the command
+	// does not have is an error only in its own section.
+	fs = flag.NewFlagSet("stats", flag.ContinueOnError)
+	threshold := fs.Int("threshold", 300, "")
+	fs.Parse(nil)
+	cfg, err := cute.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ApplyFlags("stats", fs); err != nil || *threshold != 500 {
+		t.Fatalf("stats: threshold=%d, %v", *threshold, err)
+	}
+	want := cute.EvalOptions{Dir: filepath.Join(dir, "eval"), File: "nn.bin"}
+	if got := cfg.EvalOptions(); got != want {
+		t.Fatalf("eval options = %+v, want %+v", got, want)
+	}
+	fs = flag.NewFlagSet("graph", flag.ContinueOnError)
+	evalDir := fs.String("eval-dir", "", "")
+	fvScale := fs.Int("fv-scale", 0, "")
+	fs.Parse(nil)
+	if err := cfg.ApplyFlags("graph", fs); err != nil || *evalDir != want.Dir || *fvScale != 0 {
+		t.Fatalf("graph: eval-dir=%q fv-scale=%d, %v", *evalDir, *fvScale, err)
+	}
+	if err := cfg.ApplyFlags("analyze", flag.NewFlagSet("analyze", flag.ContinueOnError)); err == nil {
+		t.Fatal("expected an error for -group-by")
+	}
+}
+
+func TestFindConfigPath(t *testing.T) {
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work", "sub")
+	xdg := filepath.Join(dir, "xdg")
+	for _, d := range []string{work, filepath.Join(xdg, "cute")} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	t.Setenv(cute.ConfigEnv, "")
+
+	_, _, err = cute.FindConfigPath()
+	if !errors.Is(err, cute.ErrConfigNotFound) || !strings.Contains(err.Error(), filepath.Join(xdg, "cute", "config.json")) || !strings.Contains(err.Error(), filepath.Join(work, "config.json")) {
+		t.Fatalf("err = %v", err)
+	}
+
+	write := func(path string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(`{}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inXDG := filepath.Join(xdg, "cute", "config.json")
+	write(inXDG)
+	if path, _, err := cute.FindConfigPath(); err != nil || path != inXDG {
+		t.Fatalf("xdg: %q, %v", path, err)
+	}
+	// A config in a parent of the working directory wins over XDG.
+	inParent := filepath.Join(dir, "work", "config.json")
+	write(inParent)
+	if path, root, err := cute.FindConfigPath(); err != nil || path != inParent || root != filepath.Dir(inParent) {
+		t.Fatalf("parent: %q %q, %v", path, root, err)
+	}
+	// $CUTE_CONFIG wins over both, and must exist.
+	t.Setenv(cute.ConfigEnv, inXDG)
+	if path, _, err := cute.FindConfigPath(); err != nil || path != inXDG {
+		t.Fatalf("env: %q, %v", path, err)
+	}
+	t.Setenv(cute.ConfigEnv, filepath.Join(dir, "missing.json"))
+	if _, _, err := cute.FindConfigPath(); err == nil || errors.Is(err, cute.ErrConfigNotFound) {
+		t.Fatalf("missing $CUTE_CONFIG: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package cute
+
+// CrossingOptions configures FirstCrossing.
+type CrossingOptions struct {
+	// Threshold is the centipawn eval (from sente's perspective) a side
+	// must reach, +Threshold for sente and -Threshold for gote.
+	Threshold int
+	// IgnorePlies skips evals up to this ply (0 = none).
+	IgnorePlies int
+	// MateCountsAsCross makes a mate score a crossing for the mating side
+	// whatever the threshold. Otherwise mate scores are skipped: they
+	// neither cross nor interrupt a run counted by RequireStability.
+	MateCountsAsCross bool
+	// RequireStability requires the crossing side to stay crossed for this
+	// many following evals (0 = the first crossing eval is enough). A run
+	// cut short by the end of the game still counts.
+	RequireStability int
+}
+
+// Crossing is the first threshold crossing of a game.
+type Crossing struct {
+	Side string // "sente", "gote" or "none"
+	Ply  int    // 0 for "none"
+	// Eval is the crossing eval, from sente's perspective; zero for "none".
+	Eval MoveEval
+}
+
+// FirstCrossing returns which side first crosses the eval threshold, with
+// the ply and eval at which it did. With RequireStability it is the start
+// of the first stable run.
+func FirstCrossing(evals []MoveEval, opts CrossingOptions) Crossing {
+	none := Crossing{Side: "none"}
+	cross, held := none, 0
+	for _, eval := range evals {
+		if opts.IgnorePlies > 0 && int(eval.Ply) <= opts.IgnorePlies {
+			continue
+		}
+		side, ok := crossedSide(eval, opts)
+		if !ok {
+			continue
+		}
+		switch {
+		case side == "none":
+			cross, held = none, 0
+			continue
+		case side == cross.Side:
+			held++
+		default:
+			cross, held = Crossing{Side: side, Ply: int(eval.Ply), Eval: eval}, 0
+		}
+		if held >= opts.RequireStability {
+			return cross
+		}
+	}
+	return cross
+}
+
+// FirstCrossings is FirstCrossing for each of thresholds (opts.Threshold is
+// ignored) in a single pass over evals, for sweeping many thresholds.
+func FirstCrossings(evals []MoveEval, opts CrossingOptions, thresholds []int) []Crossing {
+	type state struct {
+		cross Crossing
+		held  int
+		done  bool
+	}
+	states := make([]state, len(thresholds))
+	for i := range states {
+		states[i].cross = Crossing{Side: "none"}
+	}
+	pending := len(thresholds)
+	for _, eval := range evals {
+		if pending == 0 {
+			break
+		}
+		if opts.IgnorePlies > 0 && int(eval.Ply) <= opts.IgnorePlies {
+			continue
+		}
+		for i, threshold := range thresholds {
+			st := &states[i]
+			if st.done {
+				continue
+			}
+			opts.Threshold = threshold
+			side, ok := crossedSide(eval, opts)
+			if !ok {
+				continue
+			}
+			switch {
+			case side == "none":
+				st.cross, st.held = Crossing{Side: "none"}, 0
+				continue
+			case side == st.cross.Side:
+				st.held++
+			default:
+				st.cross, st.held = Crossing{Side: side, Ply: int(eval.Ply), Eval: eval}, 0
+			}
+			if st.held >= opts.RequireStability {
+				st.done = true
+				pending--
+			}
+		}
+	}
+	crossings := make([]Crossing, len(thresholds))
+	for i, st := range states {
+		crossings[i] = st.cross
+	}
+	return crossings
+}
+
+// crossedSide returns the side eval is crossed for, or false for a mate
+// score that does not count.
+func crossedSide(eval MoveEval, opts CrossingOptions) (string, bool) {
+	if eval.ScoreType == "mate" && !opts.MateCountsAsCross {
+		return "", false
+	}
+	switch cp := eval.EffectiveCP(); {
+	case cp >= opts.Threshold:
+		return "sente", true
+	case cp <= -opts.Threshold:
+		return "gote", true
+	}
+	return "none", true
+}
+
+// mateSide returns the mating side of a mate score. "mate 0" means the
+// side to move is already mated, and its sign is lost once the score is
+// turned to sente's perspective, so the side to move is taken from the
+// ply: gote moves after odd plies. This assumes sente moved first, which
+// is wrong for handicap games.
+func mateSide(eval MoveEval) string {
+	switch {
+	case eval.ScoreValue > 0:
+		return "sente"
+	case eval.ScoreValue < 0:
+		return "gote"
+	case eval.Ply%2 == 1:
+		return "sente"
+	}
+	return "gote"
+}
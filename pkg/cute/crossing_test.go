@@ -0,0 +1,141 @@
+package cute_test
+
+import (
+	"math/rand"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestFirstCrossing(t *testing.T) {
+	cp := func(ply, value int32) cute.MoveEval {
+		return cute.MoveEval{Ply: ply, ScoreType: "cp", ScoreValue: value}
+	}
+	mate := func(ply, value int32) cute.MoveEval {
+		return cute.MoveEval{Ply: ply, ScoreType: "mate", ScoreValue: value}
+	}
+	tests := []struct {
+		name     string
+		evals    []cute.MoveEval
+		opts     cute.CrossingOptions
+		wantSide string
+		wantPly  int
+	}{
+		{
+			name:     "sente",
+			evals:    []cute.MoveEval{cp(1, 100), cp(2, 299), cp(3, 300), cp(4, -500)},
+			opts:     cute.CrossingOptions{Threshold: 300},
+			wantSide: "sente", wantPly: 3,
+		},
+		{
+			name:     "gote",
+			evals:    []cute.MoveEval{cp(1, -100), cp(2, -300)},
+			opts:     cute.CrossingOptions{Threshold: 300},
+			wantSide: "gote", wantPly: 2,
+		},
+		{
+			name:     "none",
+			evals:    []cute.MoveEval{cp(1, 100), cp(2, -299)},
+			opts:     cute.CrossingOptions{Threshold: 300},
+			wantSide: "none", wantPly: 0,
+		},
+		{
+			name:     "ignore plies",
+			evals:    []cute.MoveEval{cp(1, 500), cp(2, 0), cp(3, -500)},
+			opts:     cute.CrossingOptions{Threshold: 300, IgnorePlies: 2},
+			wantSide: "gote", wantPly: 3,
+		},
+		{
+			name:     "mate counts",
+			evals:    []cute.MoveEval{cp(1, 0), mate(2, -5), cp(3, 1000)},
+			opts:     cute.CrossingOptions{Threshold: 3000, MateCountsAsCross: true},
+			wantSide: "gote", wantPly: 2,
+		},
+		{
+			name:     "mate skipped",
+			evals:    []cute.MoveEval{cp(1, 0), mate(2, -5), cp(3, 1000)},
+			opts:     cute.CrossingOptions{Threshold: 1000},
+			wantSide: "sente", wantPly: 3,
+		},
+		{
+			// Gote is to move and mated after an odd ply.
+			name:     "mate 0 after sente's move",
+			evals:    []cute.MoveEval{cp(1, 0), cp(2, 0), mate(3, 0)},
+			opts:     cute.CrossingOptions{Threshold: 300, MateCountsAsCross: true},
+			wantSide: "sente", wantPly: 3,
+		},
+		{
+			name:     "mate 0 after gote's move",
+			evals:    []cute.MoveEval{cp(1, 0), mate(2, 0)},
+			opts:     cute.CrossingOptions{Threshold: 300, MateCountsAsCross: true},
+			wantSide: "gote", wantPly: 2,
+		},
+		{
+			name:     "stability skips a spike",
+			evals:    []cute.MoveEval{cp(1, 400), cp(2, 100), cp(3, -400), cp(4, -350), cp(5, -500), cp(6, 0)},
+			opts:     cute.CrossingOptions{Threshold: 300, RequireStability: 2},
+			wantSide: "gote", wantPly: 3,
+		},
+		{
+			name:     "stability through a skipped mate",
+			evals:    []cute.MoveEval{cp(1, 400), mate(2, -9), cp(3, 500)},
+			opts:     cute.CrossingOptions{Threshold: 300, RequireStability: 1},
+			wantSide: "sente", wantPly: 1,
+		},
+		{
+			name:     "stability broken by the other side",
+			evals:    []cute.MoveEval{cp(1, 400), cp(2, -400), cp(3, 100)},
+			opts:     cute.CrossingOptions{Threshold: 300, RequireStability: 1},
+			wantSide: "none", wantPly: 0,
+		},
+		{
+			name:     "stability cut short by the end",
+			evals:    []cute.MoveEval{cp(1, 0), cp(2, 600)},
+			opts:     cute.CrossingOptions{Threshold: 300, RequireStability: 3},
+			wantSide: "sente", wantPly: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cute.FirstCrossing(tt.evals, tt.opts)
+			if got.Side != tt.wantSide || got.Ply != tt.wantPly {
+				t.Fatalf("FirstCrossing = %s, %d; want %s, %d", got.Side, got.Ply, tt.wantSide, tt.wantPly)
+			}
+			if got.Side != "none" && int(got.Eval.Ply) != got.Ply {
+				t.Fatalf("Eval = %+v, want the eval at ply %d", got.Eval, got.Ply)
+			}
+		})
+	}
+}
+
+func TestFirstCrossingsMatchesFirstCrossing(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	thresholds := []int{100, 300, 500, 800, 1500}
+	for game := 0; game < 200; game++ {
+		var evals []cute.MoveEval
+		value := 0
+		for ply := int32(1); ply <= 80; ply++ {
+			if rng.Intn(10) == 0 {
+				continue // a skipped eval
+			}
+			if rng.Intn(40) == 0 {
+				evals = append(evals, cute.MoveEval{Ply: ply, ScoreType: "mate", ScoreValue: int32(rng.Intn(11) - 5)})
+				continue
+			}
+			value += rng.Intn(401) - 200
+			evals = append(evals, cute.MoveEval{Ply: ply, ScoreType: "cp", ScoreValue: int32(value)})
+		}
+		opts := cute.CrossingOptions{
+			IgnorePlies:       rng.Intn(3) * 10,
+			MateCountsAsCross: rng.Intn(2) == 0,
+			RequireStability:  rng.Intn(3),
+		}
+		got := cute.FirstCrossings(evals, opts, thresholds)
+		for i, threshold := range thresholds {
+			opts.Threshold = threshold
+			if want := cute.FirstCrossing(evals, opts); got[i] != want {
+				t.Fatalf("game %d threshold %d (%+v): got %+v, want %+v", game, threshold, opts, got[i], want)
+			}
+		}
+	}
+}
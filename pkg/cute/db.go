@@ -16,6 +16,9 @@ type MoveEval struct {
 	Ply        int32  `parquet:"name=ply, type=INT32"`
 	ScoreType  string `parquet:"name=score_type, type=BYTE_ARRAY, convertedtype=UTF8"`
 	ScoreValue int32  `parquet:"name=score_value, type=INT32"`
+	// Depth is the search depth reached (0 when unknown: cached evals
+	// and files written before the column was added).
+	Depth int32 `parquet:"name=depth, type=INT32"`
 }
 
 type GameRecord struct {
@@ -28,6 +31,68 @@ type GameRecord struct {
 	WinReason   string     `parquet:"name=win_reason, type=BYTE_ARRAY, convertedtype=UTF8"`
 	MoveCount   int32      `parquet:"name=move_count, type=INT32"`
 	MoveEvals   []MoveEval `parquet:"name=move_evals, type=LIST"`
+
+	// Opening tags (comma-separated, as in the opening DB), filled when
+	// graph runs with -opening-db or -classify. Empty otherwise and in
+	// files written before these columns existed.
+	SenteAttackTags  string `parquet:"name=sente_attack_tags, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SenteDefenseTags string `parquet:"name=sente_defense_tags, type=BYTE_ARRAY, convertedtype=UTF8"`
+	GoteAttackTags   string `parquet:"name=gote_attack_tags, type=BYTE_ARRAY, convertedtype=UTF8"`
+	GoteDefenseTags  string `parquet:"name=gote_defense_tags, type=BYTE_ARRAY, convertedtype=UTF8"`
+
+	// SenteCastle and GoteCastle are the first castle each player
+	// completed (美濃囲い, 矢倉囲い, 穴熊, ...; see opening.Tags.Castle) and
+	// the *CastlePly columns the ply that completed it. graph fills them
+	// with the built-in classifier for every game, with or without an
+	// opening DB. "" and 0 when the player never castled and in files
+	// written before these columns existed.
+	SenteCastle    string `parquet:"name=sente_castle, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SenteCastlePly int32  `parquet:"name=sente_castle_ply, type=INT32"`
+	GoteCastle     string `parquet:"name=gote_castle, type=BYTE_ARRAY, convertedtype=UTF8"`
+	GoteCastlePly  int32  `parquet:"name=gote_castle_ply, type=INT32"`
+
+	// StartTime and EndTime are the 開始日時/終了日時 headers in
+	// RecordTimeLayout ("" when the KIF has none). TimeControl is the
+	// 持ち時間 header as written (see KIFTimeControl).
+	StartTime   string `parquet:"name=start_time, type=BYTE_ARRAY, convertedtype=UTF8"`
+	EndTime     string `parquet:"name=end_time, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TimeControl string `parquet:"name=time_control, type=BYTE_ARRAY, convertedtype=UTF8"`
+
+	// MovesHash is Board.MovesHash of the game ("" in files written
+	// before the column was added).
+	MovesHash string `parquet:"name=moves_hash, type=BYTE_ARRAY, convertedtype=UTF8"`
+
+	// EvalRetries counts the engine evaluations of the game that were
+	// retried after failing (see EvalRetry); 0 in files written before the
+	// column was added.
+	EvalRetries int32 `parquet:"name=eval_retries, type=INT32"`
+
+	// EvalComplete is false for a record whose evaluation failed partway:
+	// MoveEvals stops at the failure (see BuildGameRecordWith and graph
+	// -keep-partial). Files written before the column was added read as
+	// complete (see GameRecordReader).
+	EvalComplete bool `parquet:"name=eval_complete, type=BOOLEAN"`
+
+	// ActivityPacked is the per-ply piece activity of the game packed by
+	// PackActivity (see Activity), filled by graph -activity and ""
+	// otherwise.
+	ActivityPacked string `parquet:"name=activity_packed, type=BYTE_ARRAY"`
+}
+
+// Activity returns the per-ply piece activity stored in the record, or
+// nil when it was not computed.
+func (r GameRecord) Activity() ([]PlyActivity, error) {
+	return UnpackActivity([]byte(r.ActivityPacked))
+}
+
+// DedupKey identifies the game by its players and moves, so that the same
+// game stored under different IDs (online dumps often have several) can
+// be found. It is "" when MovesHash is unknown.
+func (r GameRecord) DedupKey() string {
+	if r.MovesHash == "" {
+		return ""
+	}
+	return r.SenteName + "\x00" + r.GoteName + "\x00" + r.MovesHash
 }
 
 type ParquetSchema struct {
@@ -44,6 +109,35 @@ type ParquetField struct {
 const schemaPath = "schema/parquet_schema.json"
 
 func WriteParquet(path string, records <-chan GameRecord, parallel int64) error {
+	return WriteParquetMeta(path, records, parallel, nil)
+}
+
+// WriteParquetMeta is WriteParquet that also stores the ParquetMeta
+// returned by meta in the footer. meta is called once records is closed,
+// so it may describe engines started while writing.
+func WriteParquetMeta(path string, records <-chan GameRecord, parallel int64, meta func() ParquetMeta) error {
+	return WriteParquetWith(path, records, ParquetWriteOptions{Parallel: parallel, Meta: meta})
+}
+
+// ParquetWriteOptions configures WriteParquetWith.
+type ParquetWriteOptions struct {
+	Parallel int64
+	// Meta, when set, returns the ParquetMeta stored in the footer (see
+	// WriteParquetMeta).
+	Meta func() ParquetMeta
+	// CompactEvals stores MoveEvals in a move_evals_packed byte array
+	// column (see PackMoveEvals) instead of the move_evals LIST, which is
+	// much smaller. GameRecordReader reads both layouts; other parquet
+	// readers see the packed bytes.
+	CompactEvals bool
+	// PlayerIndex, when set, is the path the PlayerIndex of the file is
+	// written to (usually PlayerIndexPath of the final output, which may
+	// be renamed into place afterwards).
+	PlayerIndex string
+}
+
+// WriteParquetWith is WriteParquet with options.
+func WriteParquetWith(path string, records <-chan GameRecord, opts ParquetWriteOptions) error {
 	fmt.Printf("writing parquet to %s\n", path)
 
 	schema, err := loadParquetSchema(schemaPath)
@@ -60,21 +154,51 @@ func WriteParquet(path string, records <-chan GameRecord, parallel int64) error
 	}
 	defer fileWriter.Close()
 
-	parquetWriter, err := writer.NewParquetWriter(fileWriter, new(GameRecord), parallel)
+	var obj any = new(GameRecord)
+	if opts.CompactEvals {
+		obj = reflect.New(compactRecordType).Interface()
+	}
+	parquetWriter, err := writer.NewParquetWriter(fileWriter, obj, max(opts.Parallel, 1))
 	if err != nil {
 		return err
 	}
 	parquetWriter.CompressionType = parquet.CompressionCodec_SNAPPY
 
+	var index *playerIndexBuilder
+	if opts.PlayerIndex != "" {
+		index = newPlayerIndexBuilder()
+	}
 	for record := range records {
-		if err := parquetWriter.Write(record); err != nil {
+		if index != nil {
+			index.add(record)
+		}
+		var row any = record
+		if opts.CompactEvals {
+			row = compactRecord(record)
+		}
+		if err := parquetWriter.Write(row); err != nil {
+			return err
+		}
+	}
+	if opts.Meta != nil {
+		data, err := json.Marshal(opts.Meta())
+		if err != nil {
 			return err
 		}
+		value := string(data)
+		parquetWriter.Footer.KeyValueMetadata = append(parquetWriter.Footer.KeyValueMetadata,
+			&parquet.KeyValue{Key: parquetMetaKey, Value: &value})
 	}
 	if err := parquetWriter.WriteStop(); err != nil {
 		return err
 	}
-	return fileWriter.Close()
+	if err := fileWriter.Close(); err != nil {
+		return err
+	}
+	if index != nil {
+		return index.write(path, opts.PlayerIndex)
+	}
+	return nil
 }
 
 func loadParquetSchema(path string) (ParquetSchema, error) {
@@ -117,13 +241,18 @@ func structParquetFieldNames(sample any) map[string]struct{} {
 }
 
 func parseParquetName(tag string) string {
+	return parseParquetTag(tag, "name")
+}
+
+// parseParquetTag returns the value of key in a parquet struct tag.
+func parseParquetTag(tag, key string) string {
 	if tag == "" {
 		return ""
 	}
 	parts := strings.Split(tag, ",")
 	for _, part := range parts {
 		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
-		if len(kv) == 2 && kv[0] == "name" {
+		if len(kv) == 2 && kv[0] == key {
 			return kv[1]
 		}
 	}
@@ -0,0 +1,265 @@
+package cute
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// GameRecordReader reads GameRecord rows from a parquet file, including
+// files written before a column was added to GameRecord or to MoveEval:
+// such columns are left at their zero value, except eval_complete, which
+// is true as those files only held complete records. Files written with
+// CompactEvals are decoded transparently. Its methods mirror
+// reader.ParquetReader.
+type GameRecordReader struct {
+	pr *reader.ParquetReader
+	// rowType is the struct read from the file when it lacks some
+	// GameRecord columns or is compact (nil when it has all of them).
+	rowType reflect.Type
+	// compact is set for files with move_evals_packed.
+	compact bool
+	// complete is set for files without eval_complete.
+	complete bool
+}
+
+// NewGameRecordReader opens a GameRecord parquet file. It fails if the
+// file has no game_id column or a column of the wrong type, which usually
+// means it is some other parquet (e.g. a stats or classify output).
+func NewGameRecordReader(file source.ParquetFile, parallel int64) (*GameRecordReader, error) {
+	return NewGameRecordReaderColumns(file, parallel, nil)
+}
+
+// NewGameRecordReaderColumns is NewGameRecordReader that reads only the
+// named columns (e.g. "sente_rating", "result"; "move_evals" for all of
+// MoveEvals) and leaves the other fields at their zero value. Columns not
+// decoded are not read, which makes skipping move_evals much faster.
+// A nil cols reads every column.
+func NewGameRecordReaderColumns(file source.ParquetFile, parallel int64, cols []string) (*GameRecordReader, error) {
+	want, err := gameRecordColumnSet(cols)
+	if err != nil {
+		return nil, err
+	}
+	probeFile, err := file.Open("")
+	if err != nil {
+		return nil, err
+	}
+	defer probeFile.Close()
+	probe, err := reader.NewParquetReader(probeFile, nil, 1)
+	if err != nil {
+		return nil, err
+	}
+	// The reader renames Footer.Schema to Go names; the ex paths keep
+	// the column names as written ("move_evals.list.element.ply").
+	columns := make(map[string]bool)
+	types := make(map[string]string) // physical type of leaf columns
+	for exPath, inPath := range probe.SchemaHandler.ExPathToInPath {
+		_, rest, ok := strings.Cut(exPath, common.PAR_GO_PATH_DELIMITER)
+		if !ok {
+			continue
+		}
+		name := strings.ReplaceAll(rest, common.PAR_GO_PATH_DELIMITER, ".")
+		columns[name] = true
+		if idx, ok := probe.SchemaHandler.MapIndex[inPath]; ok {
+			if typ := probe.SchemaHandler.SchemaElements[idx].Type; typ != nil {
+				types[name] = typ.String()
+			}
+		}
+	}
+	probe.ReadStop()
+	if !columns["game_id"] {
+		return nil, errors.New("not a GameRecord parquet file: no game_id column")
+	}
+	recordType := reflect.TypeOf(GameRecord{})
+	if columns[packedEvalsColumn] {
+		recordType = compactRecordType
+	}
+	if err := checkColumnTypes(recordType, "", types); err != nil {
+		return nil, err
+	}
+	complete := !columns["eval_complete"] && (want == nil || want["eval_complete"])
+	if want != nil {
+		for name := range columns {
+			top, _, _ := strings.Cut(name, ".")
+			if top == packedEvalsColumn {
+				top = "move_evals"
+			}
+			if !want[top] {
+				delete(columns, name)
+			}
+		}
+	}
+	r := &GameRecordReader{compact: columns[packedEvalsColumn], complete: complete}
+
+	var obj any = new(GameRecord)
+	if rowType, projected := projectColumns(recordType, "", columns); projected || r.compact {
+		r.rowType = rowType
+		obj = reflect.New(r.rowType).Interface()
+	}
+	if r.pr, err = reader.NewParquetReader(file, obj, parallel); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetNumRows returns the number of rows in the file.
+func (r *GameRecordReader) GetNumRows() int64 {
+	return r.pr.GetNumRows()
+}
+
+// SkipRows skips the next num rows without decoding them.
+func (r *GameRecordReader) SkipRows(num int64) error {
+	return r.pr.SkipRows(num)
+}
+
+// Read fills *batch with the next len(*batch) rows.
+func (r *GameRecordReader) Read(batch *[]GameRecord) error {
+	if r.rowType == nil {
+		return r.pr.Read(batch)
+	}
+	rows := reflect.New(reflect.SliceOf(r.rowType))
+	rows.Elem().Set(reflect.MakeSlice(rows.Elem().Type(), len(*batch), len(*batch)))
+	if err := r.pr.Read(rows.Interface()); err != nil {
+		return err
+	}
+	rows = rows.Elem()
+	*batch = (*batch)[:rows.Len()]
+	for i := 0; i < rows.Len(); i++ {
+		dst := reflect.ValueOf(&(*batch)[i]).Elem()
+		dst.SetZero()
+		copyColumns(dst, rows.Index(i))
+		if r.complete {
+			(*batch)[i].EvalComplete = true
+		}
+		if r.compact {
+			evals, err := UnpackMoveEvals([]byte(rows.Index(i).FieldByName("MoveEvalsPacked").String()))
+			if err != nil {
+				return fmt.Errorf("game %s: %w", (*batch)[i].GameID, err)
+			}
+			(*batch)[i].MoveEvals = evals
+		}
+	}
+	return nil
+}
+
+// gameRecordColumnSet returns cols as a set, or nil for nil cols. Names
+// must be GameRecord columns.
+func gameRecordColumnSet(cols []string) (map[string]bool, error) {
+	if cols == nil {
+		return nil, nil
+	}
+	if len(cols) == 0 {
+		return nil, errors.New("no GameRecord columns to read")
+	}
+	known := structParquetFieldNames(GameRecord{})
+	set := make(map[string]bool, len(cols))
+	for _, col := range cols {
+		if _, ok := known[col]; !ok {
+			return nil, fmt.Errorf("unknown GameRecord column %q", col)
+		}
+		set[col] = true
+	}
+	return set, nil
+}
+
+// ReadGameRecords reads every record of the GameRecord parquet file path.
+func ReadGameRecords(path string, parallel int64) ([]GameRecord, error) {
+	var records []GameRecord
+	err := readGameRecords(path, parallel, func(_ int, record GameRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// ReadGameRecordsColumns reads every record of the GameRecord parquet file
+// path with only the columns cols filled (see NewGameRecordReaderColumns).
+func ReadGameRecordsColumns(path string, cols []string) ([]GameRecord, error) {
+	var records []GameRecord
+	err := readGameRecordsColumns(path, 4, cols, func(_ int, record GameRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// projectColumns returns t restricted to the fields whose columns exist
+// under prefix, and whether any field was dropped. Slices of structs
+// (LIST columns) are projected recursively.
+func projectColumns(t reflect.Type, prefix string, columns map[string]bool) (reflect.Type, bool) {
+	var fields []reflect.StructField
+	projected := false
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := prefix + parseParquetName(field.Tag.Get("parquet"))
+		if !columns[name] {
+			projected = true
+			continue
+		}
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			if elem, ok := projectColumns(field.Type.Elem(), name+".list.element.", columns); ok {
+				field.Type = reflect.SliceOf(elem)
+				projected = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	if !projected {
+		return t, false
+	}
+	return reflect.StructOf(fields), true
+}
+
+// checkColumnTypes reports the first column of t under prefix whose
+// physical type in the file differs from its struct tag.
+func checkColumnTypes(t reflect.Type, prefix string, types map[string]string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("parquet")
+		name := prefix + parseParquetName(tag)
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			if err := checkColumnTypes(field.Type.Elem(), name+".list.element.", types); err != nil {
+				return err
+			}
+			continue
+		}
+		if have, ok := types[name]; ok && have != parseParquetTag(tag, "type") {
+			return fmt.Errorf("not a GameRecord parquet file: column %s is %s, want %s", name, have, parseParquetTag(tag, "type"))
+		}
+	}
+	return nil
+}
+
+// copyColumns copies the fields of a projected row src into dst.
+func copyColumns(dst, src reflect.Value) {
+	for j := 0; j < src.NumField(); j++ {
+		from := src.Field(j)
+		to := dst.FieldByName(src.Type().Field(j).Name)
+		if !to.IsValid() {
+			// move_evals_packed, decoded by Read.
+			continue
+		}
+		if from.Type() == to.Type() {
+			to.Set(from)
+			continue
+		}
+		// A projected slice of structs.
+		if from.IsNil() {
+			continue
+		}
+		to.Set(reflect.MakeSlice(to.Type(), from.Len(), from.Len()))
+		for k := 0; k < from.Len(); k++ {
+			copyColumns(to.Index(k), from.Index(k))
+		}
+	}
+}
+
+// ReadStop releases the reader.
+func (r *GameRecordReader) ReadStop() {
+	r.pr.ReadStop()
+}
@@ -0,0 +1,284 @@
+package cute_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	cute "cute/pkg/cute"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// legacyGameRecord is GameRecord as written before the opening tag columns
+// were added.
+type legacyGameRecord struct {
+	GameID      string          `parquet:"name=game_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SenteName   string          `parquet:"name=sente_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SenteRating int32           `parquet:"name=sente_rating, type=INT32"`
+	GoteName    string          `parquet:"name=gote_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	GoteRating  int32           `parquet:"name=gote_rating, type=INT32"`
+	Result      string          `parquet:"name=result, type=BYTE_ARRAY, convertedtype=UTF8"`
+	WinReason   string          `parquet:"name=win_reason, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MoveCount   int32           `parquet:"name=move_count, type=INT32"`
+	MoveEvals   []cute.MoveEval `parquet:"name=move_evals, type=LIST"`
+}
+
+func TestGameRecordReaderLegacyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.parquet")
+	fw, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err := writer.NewParquetWriter(fw, new(legacyGameRecord), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []legacyGameRecord{
+		{GameID: "a", SenteRating: 1500, Result: "sente_win", MoveCount: 2,
+			MoveEvals: []cute.MoveEval{{Ply: 1, ScoreType: "cp", ScoreValue: 30}, {Ply: 2, ScoreType: "mate", ScoreValue: -1}}},
+		{GameID: "b", GoteRating: 1400, Result: "gote_win", MoveEvals: []cute.MoveEval{}},
+	}
+	for _, r := range rows {
+		if err := pw.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	fw.Close()
+
+	fr, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	r, err := cute.NewGameRecordReader(fr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.ReadStop()
+	if r.GetNumRows() != 2 {
+		t.Fatalf("GetNumRows = %d", r.GetNumRows())
+	}
+	got := make([]cute.GameRecord, 2)
+	if err := r.Read(&got); err != nil {
+		t.Fatal(err)
+	}
+	// Records of files without eval_complete are complete.
+	want := []cute.GameRecord{
+		{GameID: "a", SenteRating: 1500, Result: "sente_win", MoveCount: 2, MoveEvals: rows[0].MoveEvals, EvalComplete: true},
+		{GameID: "b", GoteRating: 1400, Result: "gote_win", MoveEvals: []cute.MoveEval{}, EvalComplete: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+}
+
+// legacyMoveEval is MoveEval as written before the depth column was added.
+type legacyMoveEval struct {
+	Ply        int32  `parquet:"name=ply, type=INT32"`
+	ScoreType  string `parquet:"name=score_type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ScoreValue int32  `parquet:"name=score_value, type=INT32"`
+}
+
+type legacyEvalRecord struct {
+	GameID    string           `parquet:"name=game_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MoveCount int32            `parquet:"name=move_count, type=INT32"`
+	MoveEvals []legacyMoveEval `parquet:"name=move_evals, type=LIST"`
+}
+
+func TestGameRecordReaderLegacyMoveEvals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.parquet")
+	fw, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err := writer.NewParquetWriter(fw, new(legacyEvalRecord), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []legacyEvalRecord{
+		{GameID: "a", MoveCount: 2, MoveEvals: []legacyMoveEval{{Ply: 1, ScoreType: "cp", ScoreValue: 30}, {Ply: 2, ScoreType: "mate", ScoreValue: -1}}},
+		{GameID: "b", MoveEvals: []legacyMoveEval{}},
+	}
+	for _, r := range rows {
+		if err := pw.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	fw.Close()
+
+	fr, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	r, err := cute.NewGameRecordReader(fr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.ReadStop()
+	got := make([]cute.GameRecord, 2)
+	if err := r.Read(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []cute.GameRecord{
+		{GameID: "a", MoveCount: 2, MoveEvals: []cute.MoveEval{{Ply: 1, ScoreType: "cp", ScoreValue: 30}, {Ply: 2, ScoreType: "mate", ScoreValue: -1}}, EvalComplete: true},
+		{GameID: "b", MoveEvals: []cute.MoveEval{}, EvalComplete: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+}
+
+// compactGameRecord is the layout written with CompactEvals, as another
+// writer would produce it.
+type compactGameRecord struct {
+	GameID          string `parquet:"name=game_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Result          string `parquet:"name=result, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MoveCount       int32  `parquet:"name=move_count, type=INT32"`
+	MoveEvalsPacked string `parquet:"name=move_evals_packed, type=BYTE_ARRAY"`
+}
+
+func TestGameRecordReaderCompactFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compact.parquet")
+	evals := []cute.MoveEval{{Ply: 1, ScoreType: "cp", ScoreValue: 30, Depth: 10}, {Ply: 2, ScoreType: "mate", ScoreValue: -1, Depth: 3}}
+	writeTestParquet(t, path, []compactGameRecord{
+		{GameID: "a", Result: "sente_win", MoveCount: 2, MoveEvalsPacked: string(cute.PackMoveEvals(evals))},
+		{GameID: "b", Result: "abort", MoveEvalsPacked: string(cute.PackMoveEvals(nil))},
+	})
+	fr, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	r, err := cute.NewGameRecordReader(fr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.ReadStop()
+	got := make([]cute.GameRecord, 2)
+	if err := r.Read(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []cute.GameRecord{
+		{GameID: "a", Result: "sente_win", MoveCount: 2, MoveEvals: evals, EvalComplete: true},
+		{GameID: "b", Result: "abort", MoveEvals: []cute.MoveEval{}, EvalComplete: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestReadGameRecordsColumns(t *testing.T) {
+	dir := t.TempDir()
+	evals := []cute.MoveEval{{Ply: 1, ScoreType: "cp", ScoreValue: 30}}
+	plain := filepath.Join(dir, "plain.parquet")
+	writeTestParquet(t, plain, []cute.GameRecord{
+		{GameID: "a", SenteName: "x", SenteRating: 1500, Result: "sente_win", MoveCount: 1, MoveEvals: evals},
+	})
+	compact := filepath.Join(dir, "compact.parquet")
+	writeTestParquet(t, compact, []compactGameRecord{
+		{GameID: "a", Result: "sente_win", MoveCount: 1, MoveEvalsPacked: string(cute.PackMoveEvals(evals))},
+	})
+	for _, tc := range []struct {
+		path string
+		cols []string
+		want cute.GameRecord
+	}{
+		{plain, []string{"sente_rating", "result"}, cute.GameRecord{SenteRating: 1500, Result: "sente_win"}},
+		{plain, []string{"game_id", "move_evals"}, cute.GameRecord{GameID: "a", MoveEvals: evals}},
+		{compact, []string{"move_count"}, cute.GameRecord{MoveCount: 1}},
+		{compact, []string{"move_evals"}, cute.GameRecord{MoveEvals: evals}},
+		// Columns the file lacks are left empty.
+		{compact, []string{"game_id", "sente_rating"}, cute.GameRecord{GameID: "a"}},
+	} {
+		got, err := cute.ReadGameRecordsColumns(tc.path, tc.cols)
+		if err != nil {
+			t.Fatalf("%s %v: %v", filepath.Base(tc.path), tc.cols, err)
+		}
+		if want := []cute.GameRecord{tc.want}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s %v: got %+v, want %+v", filepath.Base(tc.path), tc.cols, got, want)
+		}
+	}
+	if _, err := cute.ReadGameRecordsColumns(plain, []string{"rating"}); err == nil {
+		t.Fatal("expected an error for an unknown column")
+	}
+	got, err := cute.ReadGameRecords(compact, 1)
+	if want := []cute.GameRecord{{GameID: "a", Result: "sente_win", MoveCount: 1, MoveEvals: evals, EvalComplete: true}}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReadGameRecords: got %+v, %v", got, err)
+	}
+}
+
+func TestReadPlayerGames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "games.parquet")
+	// Enough rows for several pages and read batches.
+	var records []cute.GameRecord
+	for i := 0; i < 5000; i++ {
+		record := cute.GameRecord{GameID: fmt.Sprintf("g%04d", i), SenteName: fmt.Sprintf("p%d", i%7), GoteName: "common"}
+		if i%500 == 499 {
+			record.GoteName = "rare"
+		}
+		records = append(records, record)
+	}
+	writeTestParquet(t, path, records)
+
+	read := func(names []string) ([]string, bool) {
+		t.Helper()
+		var ids []string
+		indexed, err := cute.ReadPlayerGames(path, 2, names, []string{"game_id"}, func(record cute.GameRecord) error {
+			ids = append(ids, record.GameID)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids, indexed
+	}
+	names := []string{"rare", "p3"}
+	scanned, indexed := read(names)
+	if indexed {
+		t.Fatal("read through an index that was not written")
+	}
+	if err := cute.BuildPlayerIndex(path); err != nil {
+		t.Fatal(err)
+	}
+	got, indexed := read(names)
+	if !indexed {
+		t.Fatal("index not used")
+	}
+	var want []string
+	for _, record := range records {
+		if record.SenteName == "p3" || record.GoteName == "rare" {
+			want = append(want, record.GameID)
+		}
+	}
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(scanned, want) {
+		t.Fatalf("got %d indexed and %d scanned games, want %d", len(got), len(scanned), len(want))
+	}
+	if got, _ := read([]string{"nobody"}); len(got) != 0 {
+		t.Fatalf("got %v for an unknown player", got)
+	}
+
+	// A file changed after indexing is scanned.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cute.LoadPlayerIndex(path); !errors.Is(err, cute.ErrStalePlayerIndex) {
+		t.Fatalf("got %v, want ErrStalePlayerIndex", err)
+	}
+	if got, indexed := read(names); indexed || !reflect.DeepEqual(got, want) {
+		t.Fatalf("stale index: indexed %v, %d games", indexed, len(got))
+	}
+}
@@ -0,0 +1,108 @@
+package cute
+
+import (
+	"context"
+	"errors"
+)
+
+// EnginePool holds a fixed number of handshaken USI sessions that can be
+// shared by concurrent workers.
+type EnginePool struct {
+	path     string
+	args     []string
+	options  map[string]string
+	sessions chan *Session
+	size     int
+}
+
+// NewEnginePool starts size sessions for the engine at path and performs the
+// USI handshake on each. On error, already started sessions are closed.
+func NewEnginePool(ctx context.Context, size int, path string, args ...string) (*EnginePool, error) {
+	return NewEnginePoolWith(ctx, size, path, nil, args...)
+}
+
+// NewEnginePoolWith is NewEnginePool with USI options for every session
+// (see Session.SetOptions).
+func NewEnginePoolWith(ctx context.Context, size int, path string, options map[string]string, args ...string) (*EnginePool, error) {
+	if size <= 0 {
+		return nil, errors.New("engine pool size must be > 0")
+	}
+	pool := &EnginePool{
+		path:     path,
+		args:     args,
+		options:  options,
+		sessions: make(chan *Session, size),
+		size:     size,
+	}
+	for i := 0; i < size; i++ {
+		session, err := pool.start(ctx)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		pool.sessions <- session
+	}
+	return pool, nil
+}
+
+func (p *EnginePool) start(ctx context.Context) (*Session, error) {
+	session, err := StartSession(ctx, p.path, p.args...)
+	if err != nil {
+		return nil, err
+	}
+	session.SetOptions(p.options)
+	if err := session.Handshake(ctx); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// Size returns the number of sessions managed by the pool.
+func (p *EnginePool) Size() int {
+	return p.size
+}
+
+// Acquire blocks until a session is available or ctx is done. A session
+// whose Health reports it dead (e.g. found by its keepalive while idle) is
+// restarted first; if that fails the pool shrinks as with Restart.
+func (p *EnginePool) Acquire(ctx context.Context) (*Session, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case session := <-p.sessions:
+		if !session.Health().Alive {
+			return p.Restart(ctx, session)
+		}
+		return session, nil
+	}
+}
+
+// Release returns a session obtained from Acquire to the pool.
+func (p *EnginePool) Release(session *Session) {
+	p.sessions <- session
+}
+
+// Restart closes a broken session and replaces it with a fresh one. The
+// returned session is owned by the caller and must be released as usual.
+// If the restart fails, the pool permanently shrinks by one session.
+func (p *EnginePool) Restart(ctx context.Context, session *Session) (*Session, error) {
+	_ = session.Close()
+	return p.start(ctx)
+}
+
+// Close terminates all idle sessions. Sessions currently acquired are not
+// closed; callers should release them before closing the pool.
+func (p *EnginePool) Close() error {
+	var firstErr error
+	for {
+		select {
+		case session := <-p.sessions:
+			if err := session.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		default:
+			return firstErr
+		}
+	}
+}
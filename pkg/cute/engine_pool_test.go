@@ -0,0 +1,96 @@
+package cute_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cute "cute/pkg/cute"
+)
+
+// fakeEngineScript is a minimal USI engine that always reports depth 7,
+// score cp 42 and bestmove 7g7f.
+const fakeEngineScript = `#!/bin/sh
+while read line; do
+  case "$line" in
+    usi) echo "id name fake"; echo "usiok";;
+    isready) echo "readyok";;
+    go*) echo "info depth 7 seldepth 9 score cp 42 nodes 100 pv 7g7f"; echo "bestmove 7g7f";;
+    quit) exit 0;;
+  esac
+done
+`
+
+// writeFakeEngine writes fakeEngineScript to a temp dir and returns its path.
+func writeFakeEngine(t *testing.T) string {
+	t.Helper()
+	return writeEngineScript(t, fakeEngineScript)
+}
+
+// writeEngineScript writes a shell USI engine to a temp dir and returns its path.
+func writeEngineScript(t *testing.T, script string) string {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found in PATH")
+	}
+	path := filepath.Join(t.TempDir(), "fake-engine.sh")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatalf("failed to write fake engine: %v", err)
+	}
+	return path
+}
+
+func TestEnginePoolSearch(t *testing.T) {
+	enginePath := writeFakeEngine(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pool, err := cute.NewEnginePool(ctx, 2, enginePath)
+	if err != nil {
+		t.Fatalf("failed to start pool: %v", err)
+	}
+	defer pool.Close()
+	if pool.Size() != 2 {
+		t.Fatalf("unexpected pool size: %d", pool.Size())
+	}
+
+	a, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	b, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	// Both sessions are in use; a third acquire must wait for ctx.
+	short, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+	if _, err := pool.Acquire(short); err == nil {
+		t.Fatal("expected acquire to block while pool is exhausted")
+	}
+
+	// White to move: the score is flipped to sente's perspective.
+	result, err := a.Search(ctx, "lnsgkgsnl/1r5b1/ppppppppp/9/9/2P6/PP1PPPPPP/1B5R1/LNSGKGSNL w - 2", 1)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if result.Depth != 7 || result.BestMove != "7g7f" || result.Score != (cute.Score{Kind: "cp", Value: -42, Depth: 7, Nodes: 100}) {
+		t.Fatalf("unexpected search result: %+v", result)
+	}
+
+	b, err = pool.Restart(ctx, b)
+	if err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	pool.Release(a)
+	pool.Release(b)
+}
+
+func TestEnginePoolInvalidSize(t *testing.T) {
+	if _, err := cute.NewEnginePool(context.Background(), 0, "engine"); err == nil {
+		t.Fatal("expected error for pool size 0")
+	}
+}
@@ -0,0 +1,191 @@
+package cute
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EvalCache is a persistent position -> Score cache stored in an
+// append-only file. Entries are keyed by the packed position, the engine
+// id, and the search limit, so one file can hold results for several
+// engines and limits.
+//
+// An EvalCache is safe for concurrent use, so all workers of a run can
+// share one. Several processes may also append to the same file: records
+// are small fixed-size writes with O_APPEND, and other processes' records
+// are picked up on a cache miss.
+type EvalCache struct {
+	mu       sync.Mutex
+	f        *os.File
+	engineID string
+	offset   int64 // bytes of the file already loaded
+	refresh  time.Time
+	entries  map[evalCacheKey]Score
+}
+
+type evalCacheKey struct {
+	pos   Packed256
+	limit uint64 // hash of engine id and search limit
+}
+
+// evalCacheRecordSize is the size of one record:
+//
+//	[0:32]  Packed256 (MarshalBinary form)
+//	[32:40] limit hash, little endian
+//	[40:44] score value, little endian int32
+//	[44]    score kind: 'c' (cp) or 'm' (mate)
+//	[45:48] zero
+const evalCacheRecordSize = 48
+
+// evalCacheRefreshInterval bounds how often a miss rereads the file for
+// records appended by other processes.
+const evalCacheRefreshInterval = time.Second
+
+// OpenEvalCache opens (or creates) the cache file at path and loads its
+// entries. engineID identifies the engine whose scores are stored, usually
+// EngineInfo.CacheID; entries from other engines in the same file are
+// ignored on lookup.
+func OpenEvalCache(path, engineID string) (*EvalCache, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	c := &EvalCache{f: f, engineID: engineID, entries: make(map[evalCacheKey]Score)}
+	if err := c.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("eval cache %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// CacheID returns the engine id of e for OpenEvalCache: the binary, the
+// "id name" and every option it was run with, so that runs with another
+// eval file or FV_SCALE do not share scores.
+func (e EngineInfo) CacheID() string {
+	names := make([]string, 0, len(e.Options))
+	for name := range e.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(e.Binary)
+	b.WriteByte(0)
+	b.WriteString(e.Name)
+	for _, name := range names {
+		fmt.Fprintf(&b, "\x00%s=%s", name, e.Options[name])
+	}
+	return b.String()
+}
+
+// Len returns the number of cached entries for all engines.
+func (c *EvalCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Get returns the cached score of pos searched with limit.
+func (c *EvalCache) Get(pos Packed256, limit SearchLimit) (Score, bool) {
+	key := c.key(pos, limit)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if score, ok := c.entries[key]; ok {
+		return score, true
+	}
+	if time.Since(c.refresh) < evalCacheRefreshInterval {
+		return Score{}, false
+	}
+	if err := c.load(); err != nil {
+		return Score{}, false
+	}
+	score, ok := c.entries[key]
+	return score, ok
+}
+
+// Put stores the score of pos searched with limit.
+func (c *EvalCache) Put(pos Packed256, limit SearchLimit, score Score) error {
+	var kind byte
+	switch score.Kind {
+	case "cp":
+		kind = 'c'
+	case "mate":
+		kind = 'm'
+	default:
+		return fmt.Errorf("eval cache: unknown score kind %q", score.Kind)
+	}
+	key := c.key(pos, limit)
+	var rec [evalCacheRecordSize]byte
+	packed, _ := pos.MarshalBinary()
+	copy(rec[:32], packed)
+	binary.LittleEndian.PutUint64(rec[32:], key.limit)
+	binary.LittleEndian.PutUint32(rec[40:], uint32(int32(score.Value)))
+	rec[44] = kind
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return nil
+	}
+	c.entries[key] = score
+	_, err := c.f.Write(rec[:])
+	return err
+}
+
+// Close closes the cache file.
+func (c *EvalCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.f.Close()
+}
+
+// key hashes the limit as the arguments of the "go" command it sends, so
+// searches bounded by nodes rather than time get their own entries and
+// two limits share one only when the engine runs the same search.
+func (c *EvalCache) key(pos Packed256, limit SearchLimit) evalCacheKey {
+	h := fnv.New64a()
+	h.Write([]byte(c.engineID))
+	h.Write([]byte{0})
+	h.Write([]byte(limit.goArgs()))
+	return evalCacheKey{pos: pos, limit: h.Sum64()}
+}
+
+// load reads the records appended since the last load. Our own records
+// are read back too, which is harmless. A trailing partial record (from a
+// writer that is mid-append or crashed) is left for the next load.
+// Callers must hold c.mu, except during OpenEvalCache.
+func (c *EvalCache) load() error {
+	c.refresh = time.Now()
+	info, err := c.f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size() - info.Size()%evalCacheRecordSize
+	if size <= c.offset {
+		return nil
+	}
+	buf := make([]byte, size-c.offset)
+	if _, err := c.f.ReadAt(buf, c.offset); err != nil && err != io.EOF {
+		return err
+	}
+	for rec := buf; len(rec) >= evalCacheRecordSize; rec = rec[evalCacheRecordSize:] {
+		var key evalCacheKey
+		if err := key.pos.UnmarshalBinary(rec[:32]); err != nil {
+			return err
+		}
+		key.limit = binary.LittleEndian.Uint64(rec[32:])
+		score := Score{Kind: "cp", Value: int(int32(binary.LittleEndian.Uint32(rec[40:])))}
+		if rec[44] == 'm' {
+			score.Kind = "mate"
+		}
+		c.entries[key] = score
+	}
+	c.offset = size
+	return nil
+}
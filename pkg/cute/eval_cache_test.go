@@ -0,0 +1,174 @@
+package cute_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestEvalCachePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "evals.cache")
+	pos, err := cute.PositionFromSFEN("lnsgkgsnl/1r5b1/ppppppppp/9/9/2P6/PP1PPPPPP/1B5R1/LNSGKGSNL w - 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := cute.PackPosition256(pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := cute.OpenEvalCache(path, "engine-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put(packed, cute.SearchLimit{MoveTimeMs: 100}, cute.Score{Kind: "cp", Value: -42}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put(packed, cute.SearchLimit{MoveTimeMs: 500}, cute.Score{Kind: "mate", Value: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := cute.OpenEvalCache(path, "engine-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got, ok := reopened.Get(packed, cute.SearchLimit{MoveTimeMs: 100}); !ok || got != (cute.Score{Kind: "cp", Value: -42}) {
+		t.Fatalf("Get(100) = %v, %v", got, ok)
+	}
+	if got, ok := reopened.Get(packed, cute.SearchLimit{MoveTimeMs: 500}); !ok || got != (cute.Score{Kind: "mate", Value: 7}) {
+		t.Fatalf("Get(500) = %v, %v", got, ok)
+	}
+	if _, ok := reopened.Get(packed, cute.SearchLimit{MoveTimeMs: 200}); ok {
+		t.Fatal("hit for a movetime that was never stored")
+	}
+
+	other, err := cute.OpenEvalCache(path, "engine-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, ok := other.Get(packed, cute.SearchLimit{MoveTimeMs: 100}); ok {
+		t.Fatal("hit for another engine")
+	}
+}
+
+func TestEvalCacheKeyedByEvalOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "evals.cache")
+	pos, err := cute.PositionFromSFEN("lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := cute.PackPosition256(pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := cute.EngineInfo{Binary: "engine", Name: "Engine 1.0", Options: map[string]string{"Threads": "1"}}
+	info := func(eval cute.EvalOptions) cute.EngineInfo {
+		e := engine
+		e.Options = eval.Apply(engine.Options)
+		return e
+	}
+
+	cache, err := cute.OpenEvalCache(path, info(cute.EvalOptions{File: "nn-a.bin"}).CacheID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put(packed, cute.SearchLimit{MoveTimeMs: 100}, cute.Score{Kind: "cp", Value: 30}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	drawScore := -50
+	for _, tc := range []struct {
+		eval cute.EvalOptions
+		hit  bool
+	}{
+		{cute.EvalOptions{File: "nn-a.bin"}, true},
+		{cute.EvalOptions{File: "nn-b.bin"}, false},
+		{cute.EvalOptions{File: "nn-a.bin", FVScale: 24}, false},
+		{cute.EvalOptions{Dir: "eval2", File: "nn-a.bin"}, false},
+		{cute.EvalOptions{File: "nn-a.bin", DrawScore: &drawScore}, false},
+	} {
+		c, err := cute.OpenEvalCache(path, info(tc.eval).CacheID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.Get(packed, cute.SearchLimit{MoveTimeMs: 100}); ok != tc.hit {
+			t.Errorf("%+v: hit = %v, want %v", tc.eval, ok, tc.hit)
+		}
+		c.Close()
+	}
+}
+
+func TestEvalCacheKeyedBySearchSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "evals.cache")
+	pos, err := cute.PositionFromSFEN("lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := cute.PackPosition256(pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := cute.EngineInfo{Binary: "engine", Name: "Engine 1.0", Options: map[string]string{"FV_SCALE": "36", "Threads": "1", "USI_Hash": "700"}}
+	with := func(change func(e *cute.EngineInfo)) cute.EngineInfo {
+		e := base
+		e.Options = make(map[string]string, len(base.Options))
+		for name, value := range base.Options {
+			e.Options[name] = value
+		}
+		change(&e)
+		return e
+	}
+	movetime := cute.SearchLimit{MoveTimeMs: 100}
+	nodes := cute.SearchLimit{Nodes: 100000}
+
+	cache, err := cute.OpenEvalCache(path, base.CacheID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put(packed, movetime, cute.Score{Kind: "cp", Value: 30}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put(packed, nodes, cute.Score{Kind: "cp", Value: 40}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		engine cute.EngineInfo
+		limit  cute.SearchLimit
+		hit    bool
+	}{
+		{"same settings", base, movetime, true},
+		{"same nodes", base, nodes, true},
+		// A node limit overrides the movetime, so the search is the same.
+		{"nodes with another movetime", base, cute.SearchLimit{MoveTimeMs: 500, Nodes: 100000}, true},
+		{"other movetime", base, cute.SearchLimit{MoveTimeMs: 200}, false},
+		{"other nodes", base, cute.SearchLimit{Nodes: 200000}, false},
+		{"other binary", with(func(e *cute.EngineInfo) { e.Binary = "engine2" }), movetime, false},
+		{"other id name", with(func(e *cute.EngineInfo) { e.Name = "Engine 1.1" }), movetime, false},
+		{"other Threads", with(func(e *cute.EngineInfo) { e.Options["Threads"] = "4" }), movetime, false},
+		{"other USI_Hash", with(func(e *cute.EngineInfo) { e.Options["USI_Hash"] = "1024" }), movetime, false},
+		{"extra option", with(func(e *cute.EngineInfo) { e.Options["MultiPV"] = "2" }), movetime, false},
+	} {
+		c, err := cute.OpenEvalCache(path, tc.engine.CacheID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.Get(packed, tc.limit); ok != tc.hit {
+			t.Errorf("%s: hit = %v, want %v", tc.name, ok, tc.hit)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,103 @@
+package cute
+
+import "math"
+
+// DefaultFeaturePlies are the plies sampled by EvalFeatures when
+// EvalFeatureOptions.Plies is empty.
+var DefaultFeaturePlies = []int{20, 40, 60}
+
+// EvalFeatureOptions configures EvalFeatures.
+type EvalFeatureOptions struct {
+	Plies []int // plies to sample into EvalAt; empty = DefaultFeaturePlies
+	// MateScore is the cp value used for mate scores (signed by the mating
+	// side). Zero skips mate scores entirely.
+	MateScore int
+}
+
+// EvalTrajectory summarizes the eval curve of one game, from sente's
+// perspective in centipawns.
+type EvalTrajectory struct {
+	Evals   int // number of evals used
+	MaxEval int
+	MinEval int
+	// EvalAt maps each sampled ply to its eval. Plies the game did not
+	// reach (or that had a skipped mate score) are absent.
+	EvalAt map[int]int
+	// SignFlips counts changes between positive and negative evals; zero
+	// evals do not end a run.
+	SignFlips int
+	// Volatility is the standard deviation of the change between
+	// consecutive plies (pairs with a skipped ply in between are ignored).
+	Volatility float64
+}
+
+// EvalFeatures computes the trajectory features of record.
+func EvalFeatures(record GameRecord, opts EvalFeatureOptions) EvalTrajectory {
+	plies := opts.Plies
+	if len(plies) == 0 {
+		plies = DefaultFeaturePlies
+	}
+	want := make(map[int]bool, len(plies))
+	for _, ply := range plies {
+		want[ply] = true
+	}
+
+	t := EvalTrajectory{EvalAt: make(map[int]int)}
+	var deltas []float64
+	prevPly, prevValue, prevSign := 0, 0, 0
+	for _, eval := range record.MoveEvals {
+		value := int(eval.ScoreValue)
+		if eval.ScoreType == "mate" {
+			if opts.MateScore == 0 {
+				prevPly = 0
+				continue
+			}
+			value = opts.MateScore
+			if eval.EffectiveCP() < 0 {
+				value = -opts.MateScore
+			}
+		}
+		ply := int(eval.Ply)
+		if t.Evals == 0 || value > t.MaxEval {
+			t.MaxEval = value
+		}
+		if t.Evals == 0 || value < t.MinEval {
+			t.MinEval = value
+		}
+		t.Evals++
+		if want[ply] {
+			t.EvalAt[ply] = value
+		}
+		if prevPly > 0 && ply == prevPly+1 {
+			deltas = append(deltas, float64(value-prevValue))
+		}
+		sign := 0
+		switch {
+		case value > 0:
+			sign = 1
+		case value < 0:
+			sign = -1
+		}
+		if sign != 0 {
+			if prevSign != 0 && sign != prevSign {
+				t.SignFlips++
+			}
+			prevSign = sign
+		}
+		prevPly, prevValue = ply, value
+	}
+
+	if len(deltas) > 0 {
+		mean := 0.0
+		for _, d := range deltas {
+			mean += d
+		}
+		mean /= float64(len(deltas))
+		variance := 0.0
+		for _, d := range deltas {
+			variance += (d - mean) * (d - mean)
+		}
+		t.Volatility = math.Sqrt(variance / float64(len(deltas)))
+	}
+	return t
+}
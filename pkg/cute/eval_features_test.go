@@ -0,0 +1,43 @@
+package cute_test
+
+import (
+	"math"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestEvalFeatures(t *testing.T) {
+	evals := []cute.MoveEval{
+		{Ply: 1, ScoreType: "cp", ScoreValue: 50},
+		{Ply: 2, ScoreType: "cp", ScoreValue: -50},
+		{Ply: 3, ScoreType: "cp", ScoreValue: 0},
+		{Ply: 4, ScoreType: "cp", ScoreValue: 150},
+		// Ply 5 skipped (e.g. -eval-stride).
+		{Ply: 6, ScoreType: "cp", ScoreValue: -300},
+		{Ply: 7, ScoreType: "mate", ScoreValue: -3},
+	}
+	record := cute.GameRecord{MoveEvals: evals}
+
+	got := cute.EvalFeatures(record, cute.EvalFeatureOptions{Plies: []int{2, 6, 7, 40}})
+	if got.Evals != 5 || got.MaxEval != 150 || got.MinEval != -300 {
+		t.Fatalf("unexpected summary: %+v", got)
+	}
+	if len(got.EvalAt) != 2 || got.EvalAt[2] != -50 || got.EvalAt[6] != -300 {
+		t.Fatalf("EvalAt = %v", got.EvalAt)
+	}
+	// +50 -> -50 -> (0) -> +150 -> -300
+	if got.SignFlips != 3 {
+		t.Fatalf("SignFlips = %d, want 3", got.SignFlips)
+	}
+	// Deltas over consecutive plies only: -100, +50, +150.
+	want := math.Sqrt((100*100+50*50+150*150)/3.0 - math.Pow(100.0/3, 2))
+	if math.Abs(got.Volatility-want) > 1e-9 {
+		t.Fatalf("Volatility = %f, want %f", got.Volatility, want)
+	}
+
+	withMate := cute.EvalFeatures(record, cute.EvalFeatureOptions{MateScore: 3000})
+	if withMate.Evals != 6 || withMate.MinEval != -3000 {
+		t.Fatalf("mate scores not capped: %+v", withMate)
+	}
+}
@@ -0,0 +1,111 @@
+package cute
+
+// EvalPolicy decides how much engine time each ply gets in
+// BuildGameRecordWith. The zero value of every field except MoveTimeMs
+// disables that rule, so EvalPolicy{MoveTimeMs: n} evaluates every ply for
+// n ms like BuildGameRecord.
+type EvalPolicy struct {
+	MoveTimeMs int `json:"movetime_ms"` // movetime for plies no other rule applies to
+
+	// Plies 1..OpeningPlies use OpeningMoveTimeMs. Opening positions are
+	// often shared between games and cached anyway.
+	OpeningPlies      int `json:"opening_plies,omitempty"`
+	OpeningMoveTimeMs int `json:"opening_movetime_ms,omitempty"`
+
+	// Once the material balance (in pawn units, see MaterialBalance)
+	// reaches ImbalanceThreshold in either direction, plies use
+	// ImbalanceMoveTimeMs.
+	ImbalanceThreshold  int `json:"imbalance_threshold,omitempty"`
+	ImbalanceMoveTimeMs int `json:"imbalance_movetime_ms,omitempty"`
+
+	// Stride > 1 evaluates only every Stride-th ply (plus the last one);
+	// the other plies are left out of MoveEvals.
+	Stride int `json:"stride,omitempty"`
+
+	// Cache, when set, is consulted before the engine and receives every
+	// new score, sharing results between workers and runs.
+	Cache *EvalCache `json:"-"`
+
+	// Retry, when set, re-runs evaluations that failed instead of giving
+	// up on the game.
+	Retry *EvalRetry `json:"-"`
+}
+
+// Limits returns p without Cache and Retry, which do not change the evals:
+// the part recorded in EngineInfo.Policy.
+func (p EvalPolicy) Limits() EvalPolicy {
+	p.Cache = nil
+	p.Retry = nil
+	return p
+}
+
+// MoveTime returns the movetime for ply (1-based) of a game with total
+// plies, where pos is the position after the move. ok is false when the
+// ply should not be evaluated.
+func (p EvalPolicy) MoveTime(ply, total int, pos *Position) (ms int, ok bool) {
+	if p.Stride > 1 && ply%p.Stride != 0 && ply != total {
+		return 0, false
+	}
+	ms = p.MoveTimeMs
+	if p.OpeningPlies > 0 && ply <= p.OpeningPlies && p.OpeningMoveTimeMs > 0 {
+		ms = p.OpeningMoveTimeMs
+	}
+	if p.ImbalanceThreshold > 0 && p.ImbalanceMoveTimeMs > 0 {
+		if balance := pos.MaterialBalance(); balance >= p.ImbalanceThreshold || -balance >= p.ImbalanceThreshold {
+			ms = p.ImbalanceMoveTimeMs
+		}
+	}
+	return ms, true
+}
+
+// MaxMoveTime returns the longest movetime the policy can use.
+func (p EvalPolicy) MaxMoveTime() int {
+	ms := p.MoveTimeMs
+	if p.OpeningPlies > 0 && p.OpeningMoveTimeMs > ms {
+		ms = p.OpeningMoveTimeMs
+	}
+	if p.ImbalanceThreshold > 0 && p.ImbalanceMoveTimeMs > ms {
+		ms = p.ImbalanceMoveTimeMs
+	}
+	return ms
+}
+
+// pieceValues are rough material values in pawn units.
+var pieceValues = map[string]int{
+	"P": 1, "L": 3, "N": 4, "S": 5, "G": 6, "B": 8, "R": 10,
+}
+
+var promotedValues = map[string]int{
+	"P": 6, "L": 6, "N": 6, "S": 6, "B": 10, "R": 12,
+}
+
+// MaterialBalance returns sente's material minus gote's in pawn units,
+// counting pieces in hand. Kings are not counted.
+func (p *Position) MaterialBalance() int {
+	balance := 0
+	sign := func(c Color) int {
+		if c == Black {
+			return 1
+		}
+		return -1
+	}
+	for rank := 0; rank < 9; rank++ {
+		for file := 0; file < 9; file++ {
+			piece := p.board[rank][file]
+			if piece == nil {
+				continue
+			}
+			value := pieceValues[piece.kind]
+			if piece.promoted {
+				value = promotedValues[piece.kind]
+			}
+			balance += sign(piece.color) * value
+		}
+	}
+	for color, hand := range p.hands {
+		for kind, n := range hand {
+			balance += sign(color) * pieceValues[kind] * n
+		}
+	}
+	return balance
+}
@@ -0,0 +1,70 @@
+package cute_test
+
+import (
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestMaterialBalance(t *testing.T) {
+	cases := []struct {
+		sfen string
+		want int
+	}{
+		{"lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1", 0},
+		// Sente has captured a bishop.
+		{"lnsgkgsnl/1r7/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b B 1", 16},
+		// Gote has taken sente's rook and promoted.
+		{"lnsgkgsnl/7b1/ppppppppp/9/9/9/PPPPPPPPP/1B5+r1/LNSGKGSNL b r 1", -22},
+	}
+	for _, tc := range cases {
+		pos, err := cute.PositionFromSFEN(tc.sfen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pos.MaterialBalance(); got != tc.want {
+			t.Fatalf("%s: balance = %d, want %d", tc.sfen, got, tc.want)
+		}
+	}
+}
+
+func TestEvalPolicyMoveTime(t *testing.T) {
+	even, err := cute.PositionFromSFEN("lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ahead, err := cute.PositionFromSFEN("lnsgkgsnl/1r7/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b B 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := cute.EvalPolicy{
+		MoveTimeMs:          100,
+		OpeningPlies:        10,
+		OpeningMoveTimeMs:   20,
+		ImbalanceThreshold:  10,
+		ImbalanceMoveTimeMs: 300,
+		Stride:              2,
+	}
+	cases := []struct {
+		ply  int
+		pos  *cute.Position
+		ms   int
+		eval bool
+	}{
+		{1, &even, 0, false},
+		{2, &even, 20, true},
+		{12, &even, 100, true},
+		{12, &ahead, 300, true},
+		{4, &ahead, 300, true},
+		{51, &even, 100, true}, // last ply
+	}
+	for _, tc := range cases {
+		ms, ok := policy.MoveTime(tc.ply, 51, tc.pos)
+		if ms != tc.ms || ok != tc.eval {
+			t.Fatalf("ply %d: got (%d, %v), want (%d, %v)", tc.ply, ms, ok, tc.ms, tc.eval)
+		}
+	}
+	if got := policy.MaxMoveTime(); got != 300 {
+		t.Fatalf("MaxMoveTime = %d, want 300", got)
+	}
+}
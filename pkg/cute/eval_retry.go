@@ -0,0 +1,70 @@
+package cute
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// EvalRetry re-runs failed engine evaluations in BuildGameRecordWith, so a
+// transient failure (a crashed engine, a hung search) costs a retry rather
+// than the whole game. The retries of a game are counted in
+// GameRecord.EvalRetries.
+type EvalRetry struct {
+	// Attempts is the number of retries of one evaluation after it
+	// failed; 0 disables retrying.
+	Attempts int
+	// Backoff is the wait before the first retry, doubled for each
+	// further one up to MaxBackoff when that is positive.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Restart replaces a session before a retry: it should close failed
+	// and return a new, handshaken session, which the rest of the game is
+	// evaluated with. The caller must use that session afterwards too, so
+	// Restart usually stores it. Without Restart only evaluations whose
+	// engine is still alive (see Session.Health) are retried.
+	Restart func(ctx context.Context, failed *Session) (*Session, error)
+	// Fresh restarts the session before every retry, not only when its
+	// engine died or timed out.
+	Fresh bool
+}
+
+// evaluate runs session.Evaluate, retrying failures as r allows (a nil r
+// does not retry). It returns the session to continue with and the number
+// of retries made.
+func (r *EvalRetry) evaluate(ctx context.Context, session *Session, sfen string, moveTimeMs int) (Score, *Session, int, error) {
+	retries := 0
+	var backoff time.Duration
+	if r != nil {
+		backoff = r.Backoff
+	}
+	for {
+		score, _, err := session.Evaluate(ctx, sfen, moveTimeMs)
+		if err == nil || r == nil || retries >= r.Attempts || ctx.Err() != nil {
+			return score, session, retries, err
+		}
+		restart := r.Fresh || !session.Health().Alive
+		if restart && r.Restart == nil {
+			return score, session, retries, err
+		}
+		if backoff > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return score, session, retries, err
+			}
+			backoff *= 2
+			if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+				backoff = r.MaxBackoff
+			}
+		}
+		if restart {
+			fresh, restartErr := r.Restart(ctx, session)
+			if restartErr != nil {
+				return score, session, retries, fmt.Errorf("%w (restart for a retry failed: %v)", err, restartErr)
+			}
+			session = fresh
+		}
+		retries++
+	}
+}
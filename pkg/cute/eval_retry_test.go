@@ -0,0 +1,147 @@
+package cute_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cute "cute/pkg/cute"
+)
+
+func TestBuildGameRecordRetry(t *testing.T) {
+	// The engine crashes on its third search the first time it runs.
+	crashed := filepath.Join(t.TempDir(), "crashed")
+	enginePath := writeEngineScript(t, fmt.Sprintf(`#!/bin/sh
+n=0
+while read line; do
+  case "$line" in
+    usi) echo "usiok";;
+    isready) echo "readyok";;
+    go*)
+      n=$((n+1))
+      if [ $n = 3 ] && [ ! -e %[1]q ]; then touch %[1]q; exit 1; fi
+      echo "info depth 1 score cp 10"; echo "bestmove 7g7f";;
+    quit) exit 0;;
+  esac
+done
+`, crashed))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	start := func() *cute.Session {
+		session, err := cute.StartSession(ctx, enginePath)
+		if err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		if err := session.Handshake(ctx); err != nil {
+			t.Fatalf("handshake: %v", err)
+		}
+		return session
+	}
+	path := filepath.Join("testdata", "36502618.kif")
+
+	// Without Restart a dead engine is not retried.
+	session := start()
+	policy := cute.EvalPolicy{MoveTimeMs: 1, Retry: &cute.EvalRetry{Attempts: 2}}
+	if _, err := cute.BuildGameRecordWith(ctx, path, session, policy, nil); err == nil {
+		t.Fatal("expected the crash to fail the game without Restart")
+	}
+	session.Close()
+
+	restarts := 0
+	session = start()
+	policy.Retry = &cute.EvalRetry{
+		Attempts: 2,
+		Backoff:  time.Millisecond,
+		Restart: func(ctx context.Context, failed *cute.Session) (*cute.Session, error) {
+			restarts++
+			failed.Close()
+			session = start()
+			return session, nil
+		},
+	}
+	if err := os.Remove(crashed); err != nil {
+		t.Fatal(err)
+	}
+	record, err := cute.BuildGameRecordWith(ctx, path, session, policy, nil)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	defer session.Close()
+	if record.EvalRetries != 1 || restarts != 1 {
+		t.Errorf("got %d retries and %d restarts, want 1 and 1", record.EvalRetries, restarts)
+	}
+	if int32(len(record.MoveEvals)) != record.MoveCount {
+		t.Errorf("got %d evals for %d moves", len(record.MoveEvals), record.MoveCount)
+	}
+	if policy.Limits().Retry != nil {
+		t.Error("Limits kept Retry")
+	}
+}
+
+func TestBuildGameRecordPartial(t *testing.T) {
+	// The engine crashes on its third search.
+	crashing := writeEngineScript(t, `#!/bin/sh
+n=0
+while read line; do
+  case "$line" in
+    usi) echo "usiok";;
+    isready) echo "readyok";;
+    go*)
+      n=$((n+1))
+      if [ $n = 3 ]; then exit 1; fi
+      echo "info depth 1 score cp 10"; echo "bestmove 7g7f";;
+    quit) exit 0;;
+  esac
+done
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	start := func(enginePath string) *cute.Session {
+		session, err := cute.StartSession(ctx, enginePath)
+		if err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		if err := session.Handshake(ctx); err != nil {
+			t.Fatalf("handshake: %v", err)
+		}
+		t.Cleanup(func() { session.Close() })
+		return session
+	}
+	path := filepath.Join("testdata", "36502618.kif")
+
+	partial, err := cute.BuildGameRecordWith(ctx, path, start(crashing), cute.EvalPolicy{MoveTimeMs: 1}, nil)
+	var buildErr *cute.BuildError
+	if !errors.As(err, &buildErr) || buildErr.Stage != cute.StageEvaluate {
+		t.Fatalf("expected an evaluate error, got %v", err)
+	}
+	if partial.EvalComplete || len(partial.MoveEvals) != 2 || partial.MoveCount <= 2 {
+		t.Fatalf("partial record: complete=%v, %d evals of %d moves", partial.EvalComplete, len(partial.MoveEvals), partial.MoveCount)
+	}
+
+	// Completing it evaluates only the missing plies.
+	cache, err := cute.ResumeCache(path, partial)
+	if err != nil {
+		t.Fatalf("resume cache: %v", err)
+	}
+	record, err := cute.BuildGameRecordWith(ctx, path, start(writeFakeEngine(t)), cute.EvalPolicy{MoveTimeMs: 1}, cache)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if !record.EvalComplete || int32(len(record.MoveEvals)) != record.MoveCount {
+		t.Fatalf("completed record: complete=%v, %d evals of %d moves", record.EvalComplete, len(record.MoveEvals), record.MoveCount)
+	}
+	for i, eval := range record.MoveEvals {
+		// Scores are from sente's perspective; compare magnitudes.
+		want := int32(42)
+		if i < 2 {
+			want = 10
+		}
+		if eval.ScoreValue != want && eval.ScoreValue != -want {
+			t.Errorf("ply %d: got %d, want %d", eval.Ply, eval.ScoreValue, want)
+		}
+	}
+}
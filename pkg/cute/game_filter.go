@@ -0,0 +1,171 @@
+package cute
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// Game phases of GameFilter.Phase.
+const (
+	PhaseOpening    = "opening"
+	PhaseMiddlegame = "middlegame"
+	PhaseEndgame    = "endgame"
+)
+
+// Phase boundaries. By ply, the opening is plies 1-OpeningEndPly and the
+// endgame starts at EndgameStartPly. By material, the middlegame starts
+// at the ply of the OpeningEndCaptures-th capture and the endgame at the
+// EndgameCaptures-th, so a phase never comes back once left.
+const (
+	OpeningEndPly      = 40
+	EndgameStartPly    = 81
+	OpeningEndCaptures = 4
+	EndgameCaptures    = 12
+)
+
+// Values of GameFilter.PhaseBy.
+const (
+	phaseByPly      = "ply"
+	phaseByMaterial = "material"
+)
+
+// GameFilter holds the move-count and game-phase filters shared by the
+// analysis commands (see AddGameFilterFlags).
+type GameFilter struct {
+	// MinMoves and MaxMoves bound the move count of a game (0 = no
+	// bound).
+	MinMoves, MaxMoves int
+	// Phase, when set, keeps only the evals of that phase of the game, so
+	// that crossings, and anything else computed from the evals, are
+	// found within it.
+	Phase string
+	// PhaseBy is "ply" (fixed ply ranges) or "material" (the number of
+	// captures so far, from the activity graph -activity stores).
+	PhaseBy string
+}
+
+// AddGameFilterFlags registers -min-moves, -max-moves, -phase and
+// -phase-by on fs and returns the filter they fill.
+func AddGameFilterFlags(fs *flag.FlagSet) *GameFilter {
+	f := &GameFilter{}
+	fs.IntVar(&f.MinMoves, "min-moves", 0, "only games with at least this many moves (0=disabled)")
+	fs.IntVar(&f.MaxMoves, "max-moves", 0, "only games with at most this many moves (0=disabled)")
+	fs.StringVar(&f.Phase, "phase", "", "only detect crossings in this game phase: opening, middlegame or endgame (default: the whole game)")
+	fs.StringVar(&f.PhaseBy, "phase-by", phaseByPly, fmt.Sprintf("how -phase is told: ply (opening up to ply %d, endgame from ply %d) or material (middlegame from the %dth capture, endgame from the %dth; needs graph -activity)", OpeningEndPly, EndgameStartPly, OpeningEndCaptures, EndgameCaptures))
+	return f
+}
+
+// Validate checks the flag values.
+func (f GameFilter) Validate() error {
+	if f.MinMoves < 0 || f.MaxMoves < 0 {
+		return errors.New("min-moves and max-moves must be >= 0")
+	}
+	if f.MaxMoves > 0 && f.MaxMoves < f.MinMoves {
+		return errors.New("max-moves must be >= min-moves")
+	}
+	switch f.Phase {
+	case "", PhaseOpening, PhaseMiddlegame, PhaseEndgame:
+	default:
+		return fmt.Errorf("phase must be %s, %s or %s", PhaseOpening, PhaseMiddlegame, PhaseEndgame)
+	}
+	switch f.PhaseBy {
+	case "", phaseByPly, phaseByMaterial:
+	default:
+		return fmt.Errorf("phase-by must be %s or %s", phaseByPly, phaseByMaterial)
+	}
+	return nil
+}
+
+// Active reports whether the filter leaves anything out.
+func (f GameFilter) Active() bool {
+	return f.MinMoves > 0 || f.MaxMoves > 0 || f.Phase != ""
+}
+
+// Apply returns r with MoveEvals cut to the phase, or false when the
+// move count is out of range, the game never reaches the phase, or the
+// phase is told by material and r has no activity.
+func (f GameFilter) Apply(r GameRecord) (GameRecord, bool) {
+	moves := int(r.MoveCount)
+	if (f.MinMoves > 0 && moves < f.MinMoves) || (f.MaxMoves > 0 && moves > f.MaxMoves) {
+		return r, false
+	}
+	if f.Phase == "" {
+		return r, true
+	}
+	from, to, ok := f.phasePlies(r)
+	if !ok {
+		return r, false
+	}
+	evals := make([]MoveEval, 0, len(r.MoveEvals))
+	for _, eval := range r.MoveEvals {
+		if int(eval.Ply) >= from && (to == 0 || int(eval.Ply) <= to) {
+			evals = append(evals, eval)
+		}
+	}
+	r.MoveEvals = evals
+	return r, true
+}
+
+// Filter applies f to records in place and returns the games it keeps.
+func (f GameFilter) Filter(records []GameRecord) []GameRecord {
+	if !f.Active() {
+		return records
+	}
+	kept := records[:0]
+	for _, r := range records {
+		if r, ok := f.Apply(r); ok {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// phasePlies returns the first and last ply of f.Phase in r (to = 0 for
+// the rest of the game).
+func (f GameFilter) phasePlies(r GameRecord) (from, to int, ok bool) {
+	middle, end := OpeningEndPly+1, EndgameStartPly
+	if f.PhaseBy == phaseByMaterial {
+		activity, err := r.Activity()
+		if err != nil || len(activity) == 0 {
+			return 0, 0, false
+		}
+		middle, end = 0, 0
+		captures := 0
+		for i := 1; i < len(activity); i++ {
+			// A ply either captures, adding a piece to a hand, or drops
+			// one, so a growing hand total counts a capture.
+			prev, cur := activity[i-1], activity[i]
+			if cur.SenteHand+cur.GoteHand > prev.SenteHand+prev.GoteHand {
+				captures++
+			}
+			if captures == OpeningEndCaptures && middle == 0 {
+				middle = i
+			}
+			if captures == EndgameCaptures && end == 0 {
+				end = i
+			}
+		}
+	}
+	moves := int(r.MoveCount)
+	switch f.Phase {
+	case PhaseOpening:
+		if middle == 0 {
+			return 0, 0, true
+		}
+		return 0, middle - 1, true
+	case PhaseMiddlegame:
+		if middle == 0 || middle > moves {
+			return 0, 0, false
+		}
+		if end == 0 {
+			return middle, 0, true
+		}
+		return middle, end - 1, true
+	default: // PhaseEndgame
+		if end == 0 || end > moves {
+			return 0, 0, false
+		}
+		return end, 0, true
+	}
+}
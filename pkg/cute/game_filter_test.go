@@ -0,0 +1,97 @@
+package cute_test
+
+import (
+	"flag"
+	"slices"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestGameFilter(t *testing.T) {
+	record := cute.GameRecord{MoveCount: 100}
+	for ply := int32(0); ply <= 100; ply += 10 {
+		record.MoveEvals = append(record.MoveEvals, cute.MoveEval{Ply: ply, ScoreType: "cp"})
+	}
+	plies := func(r cute.GameRecord) []int32 {
+		var plies []int32
+		for _, eval := range r.MoveEvals {
+			plies = append(plies, eval.Ply)
+		}
+		return plies
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := cute.AddGameFilterFlags(fs)
+	if err := fs.Parse([]string{"-min-moves", "50", "-phase", "middlegame"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := f.Apply(record)
+	if want := []int32{50, 60, 70, 80}; !ok || !slices.Equal(plies(got), want) {
+		t.Errorf("middlegame: got %v %v, want %v", plies(got), ok, want)
+	}
+	if len(record.MoveEvals) != 11 {
+		t.Error("Apply changed the evals of its argument")
+	}
+
+	for _, tc := range []struct {
+		filter cute.GameFilter
+		ok     bool
+		want   []int32
+	}{
+		{cute.GameFilter{}, true, plies(record)},
+		{cute.GameFilter{MaxMoves: 99}, false, nil},
+		{cute.GameFilter{Phase: cute.PhaseOpening}, true, []int32{0, 10, 20, 30, 40}},
+		{cute.GameFilter{Phase: cute.PhaseEndgame}, true, []int32{90, 100}},
+	} {
+		got, ok := tc.filter.Apply(record)
+		if ok != tc.ok || (ok && !slices.Equal(plies(got), tc.want)) {
+			t.Errorf("%+v: got %v %v, want %v %v", tc.filter, plies(got), ok, tc.want, tc.ok)
+		}
+	}
+
+	// A 60-move game never reaches the endgame by ply.
+	short := record
+	short.MoveCount = 60
+	if _, ok := (cute.GameFilter{Phase: cute.PhaseEndgame}).Apply(short); ok {
+		t.Error("short game: kept for the endgame")
+	}
+
+	// By material: captures grow the hands at plies 3, 5, 7 and 9 (the
+	// 4th starts the middlegame); the drop at ply 10 is no capture.
+	hands := []int32{0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 3}
+	activity := make([]cute.PlyActivity, len(hands))
+	for i, n := range hands {
+		activity[i] = cute.PlyActivity{Ply: int32(i), SenteHand: n}
+	}
+	material := cute.GameRecord{MoveCount: 10, ActivityPacked: string(cute.PackActivity(activity))}
+	for ply := int32(0); ply <= 10; ply++ {
+		material.MoveEvals = append(material.MoveEvals, cute.MoveEval{Ply: ply, ScoreType: "cp"})
+	}
+	byMaterial := cute.GameFilter{Phase: cute.PhaseOpening, PhaseBy: "material"}
+	if got, ok := byMaterial.Apply(material); !ok || len(got.MoveEvals) != 9 {
+		t.Errorf("material opening: got %v %v, want plies 0-8", plies(got), ok)
+	}
+	byMaterial.Phase = cute.PhaseMiddlegame
+	if got, ok := byMaterial.Apply(material); !ok || !slices.Equal(plies(got), []int32{9, 10}) {
+		t.Errorf("material middlegame: got %v %v", plies(got), ok)
+	}
+	byMaterial.Phase = cute.PhaseEndgame
+	if _, ok := byMaterial.Apply(material); ok {
+		t.Error("material endgame: kept a game with 4 captures")
+	}
+	material.ActivityPacked = ""
+	if _, ok := byMaterial.Apply(material); ok {
+		t.Error("material without activity: kept")
+	}
+
+	if err := (cute.GameFilter{Phase: "late"}).Validate(); err == nil {
+		t.Error("unknown phase: expected an error")
+	}
+	if err := (cute.GameFilter{MinMoves: 80, MaxMoves: 60}).Validate(); err == nil {
+		t.Error("max-moves < min-moves: expected an error")
+	}
+}
@@ -5,14 +5,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 	"unicode/utf8"
 
+	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/japanese"
 	"golang.org/x/text/transform"
 )
@@ -42,9 +45,12 @@ type Position struct {
 }
 
 type Board struct {
-	initial Position
-	moves   []string
-	foulEnd bool
+	initial    Position
+	moves      []string
+	foulEnd    bool
+	comments   [][]string
+	variations []Variation
+	terminal   string
 }
 
 type KIFPlayers struct {
@@ -59,8 +65,14 @@ var terminalLineRe = regexp.MustCompile(`^\s*(\d+)\s+(.+?)\s*$`)
 var fromSquareRe = regexp.MustCompile(`\((\d)(\d)\)`)
 var nameRatingRe = regexp.MustCompile(`^(.+?)\((\d+)\)$`)
 
+// ReadKIFLines reads a KIF file (UTF-8 or Shift-JIS) and returns its lines
+// without trailing carriage returns.
+func ReadKIFLines(path string) ([]string, error) {
+	return readKIFLines(path)
+}
+
 func readKIFLines(path string) ([]string, error) {
-	data, err := os.ReadFile(path)
+	data, err := readKIFFile(path)
 	if err != nil {
 		return nil, err
 	}
@@ -68,11 +80,17 @@ func readKIFLines(path string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	return splitKIFLines(text), nil
+}
+
+// splitKIFLines splits decoded KIF text into lines without trailing
+// carriage returns.
+func splitKIFLines(text string) []string {
 	lines := strings.Split(text, "\n")
 	for i := range lines {
 		lines[i] = strings.TrimRight(lines[i], "\r")
 	}
-	return lines, nil
+	return lines
 }
 
 func decodeKIF(data []byte) (string, error) {
@@ -82,15 +100,62 @@ func decodeKIF(data []byte) (string, error) {
 	if utf8.Valid(data) {
 		return string(data), nil
 	}
-	reader := transform.NewReader(bytes.NewReader(data), japanese.ShiftJIS.NewDecoder())
-	decoded, err := io.ReadAll(reader)
-	if err != nil {
-		return "", err
+	// Legacy files are Shift-JIS (.kif) or occasionally EUC-JP. Many byte
+	// sequences are valid in both, so decode with each and keep the one
+	// that reads most like a KIF file.
+	best, bestScore := "", 0
+	for _, enc := range []encoding.Encoding{japanese.ShiftJIS, japanese.EUCJP} {
+		reader := transform.NewReader(bytes.NewReader(data), enc.NewDecoder())
+		decoded, err := io.ReadAll(reader)
+		if err != nil || !utf8.Valid(decoded) {
+			continue
+		}
+		text := string(decoded)
+		if score := kifTextScore(text); best == "" || score > bestScore {
+			best, bestScore = text, score
+		}
 	}
-	if !utf8.Valid(decoded) {
-		return "", errors.New("failed to decode Shift-JIS KIF")
+	if best == "" {
+		return "", errors.New("failed to decode Shift-JIS/EUC-JP KIF")
+	}
+	return best, nil
+}
+
+// kifMarkers are strings found in virtually every KIF file.
+var kifMarkers = []string{"手数", "指手", "先手", "後手", "手合割", "開始日時", "まで"}
+
+// kifTextScore rates how plausible decoded text is as KIF: markers count
+// for it, replacement characters from undecodable bytes against it.
+func kifTextScore(text string) int {
+	score := 0
+	for _, m := range kifMarkers {
+		score += 10 * strings.Count(text, m)
+	}
+	return score - strings.Count(text, "\uFFFD")
+}
+
+// KIF output encodings accepted by EncodeKIF.
+const (
+	KIFEncodingUTF8     = "utf8"
+	KIFEncodingShiftJIS = "sjis"
+)
+
+// EncodeKIF converts KIF text to the given output encoding. Shift-JIS is
+// what older Windows viewers expect; characters it cannot represent are
+// reported as an error rather than silently replaced.
+func EncodeKIF(text, enc string) ([]byte, error) {
+	switch enc {
+	case KIFEncodingUTF8, "utf-8", "":
+		return []byte(text), nil
+	case KIFEncodingShiftJIS, "shift_jis", "shift-jis", "cp932":
+		out, _, err := transform.Bytes(japanese.ShiftJIS.NewEncoder(), []byte(text))
+		if err != nil {
+			return nil, fmt.Errorf("encode KIF as Shift-JIS: %w", err)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unknown KIF encoding %q (want %s or %s)", enc, KIFEncodingUTF8, KIFEncodingShiftJIS)
 	}
-	return string(decoded), nil
 }
 
 func parseKIFMoves(lines []string) ([]string, []int, error) {
@@ -98,6 +163,10 @@ func parseKIFMoves(lines []string) ([]string, []int, error) {
 	var lineIdx []int
 	var prevDest *square
 	for i, line := range lines {
+		if variationLineRe.MatchString(line) {
+			// The main line ends where the first variation starts.
+			break
+		}
 		match := moveLineRe.FindStringSubmatch(line)
 		if len(match) == 0 {
 			continue
@@ -317,49 +386,107 @@ func parsePiece(text string) (string, bool, bool, error) {
 	return "", false, false, fmt.Errorf("unknown piece in %s", text)
 }
 
-func annotateLines(lines []string, moveLines []int, scores []Score) []string {
-	out := make([]string, 0, len(lines))
-	moveIdx := 0
-	for i, line := range lines {
-		if moveIdx < len(moveLines) && i == moveLines[moveIdx] {
-			line = fmt.Sprintf("%s * eval %s", line, scores[moveIdx].String())
-			moveIdx++
+// AnnotateKIF returns the KIF lines with the evals of record added as a
+// comment after each evaluated main-line move ("*eval cp 30 depth 18",
+// sente's perspective), following the comments the move already has.
+// Everything else, including times and variations, is kept as is.
+func AnnotateKIF(lines []string, record GameRecord) ([]string, error) {
+	_, moveLines, err := parseKIFMoves(lines)
+	if err != nil {
+		return nil, err
+	}
+	comments := make(map[int][]string, len(record.MoveEvals))
+	for _, eval := range record.MoveEvals {
+		ply := int(eval.Ply)
+		if ply < 1 || ply > len(moveLines) {
+			return nil, fmt.Errorf("eval of ply %d out of range (%d moves)", ply, len(moveLines))
+		}
+		at := moveLines[ply-1]
+		for at+1 < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[at+1]), "*") {
+			at++
+		}
+		comment := "*eval " + Score{Kind: eval.ScoreType, Value: int(eval.ScoreValue)}.String()
+		if eval.Depth > 0 {
+			comment += fmt.Sprintf(" depth %d", eval.Depth)
 		}
+		comments[at] = append(comments[at], comment)
+	}
+	out := make([]string, 0, len(lines)+len(record.MoveEvals))
+	for i, line := range lines {
 		out = append(out, line)
+		out = append(out, comments[i]...)
 	}
-	return out
+	return out, nil
+}
+
+// Stages reported in BuildError.
+const (
+	StageRead     = "read"     // reading or decoding the file
+	StageParse    = "parse"    // parsing or replaying the KIF
+	StageEvaluate = "evaluate" // engine evaluation
+)
+
+// BuildError is returned by BuildGameRecord and records the stage that
+// failed.
+type BuildError struct {
+	Stage string
+	Err   error
 }
 
+func (e *BuildError) Error() string { return e.Err.Error() }
+
+func (e *BuildError) Unwrap() error { return e.Err }
+
 func BuildGameRecord(ctx context.Context, path string, session *Session, moveTimeMs int, cache map[string]Score) (GameRecord, error) {
+	return BuildGameRecordWith(ctx, path, session, EvalPolicy{MoveTimeMs: moveTimeMs}, cache)
+}
+
+// BuildGameRecordWith is BuildGameRecord with the engine time per ply
+// decided by policy. Plies the policy skips are left out of MoveEvals.
+//
+// When the evaluation fails partway, the BuildError (StageEvaluate) comes
+// with a record of the evaluations made up to the failure and
+// EvalComplete unset, so the work can be kept and completed later (see
+// ResumeCache). Other failures return an empty record.
+func BuildGameRecordWith(ctx context.Context, path string, session *Session, policy EvalPolicy, cache map[string]Score) (GameRecord, error) {
 	lines, err := readKIFLines(path)
 	if err != nil {
-		return GameRecord{}, err
+		return GameRecord{}, &BuildError{Stage: StageRead, Err: err}
 	}
 	moves, _, err := parseKIFMoves(lines)
 	if err != nil {
-		return GameRecord{}, err
+		return GameRecord{}, &BuildError{Stage: StageParse, Err: err}
 	}
 	if len(moves) == 0 {
-		return GameRecord{}, fmt.Errorf("no moves found in %s", path)
+		return GameRecord{}, &BuildError{Stage: StageParse, Err: fmt.Errorf("no moves found in %s", path)}
 	}
 
 	// When the game ended with a foul (反則), exclude moves that produced
-	// illegal positions that engines cannot evaluate.
+	// illegal positions that engines cannot evaluate: the moves from the
+	// first illegal one (Board.FirstIllegalPly), or all of them when
+	// every move is legal (e.g. 相手番投了). If the board cannot be
+	// replayed, fall back to the usual shape of foul games:
 	//
 	//   反則勝ち: The last move is the illegal move itself (e.g. 二歩).
 	//             Remove 1 move.
 	//   反則負け: The second-to-last move is illegal (e.g. 王手放置) and
 	//             the last move captures the king to prove the foul.
 	//             Remove 2 moves.
+	board, boardErr := BoardFromKIF(lines)
 	foulType := foulEndType(lines)
-	switch foulType {
-	case "反則負け":
+	switch {
+	case foulType == "":
+	case boardErr == nil && board.MoveCount() == len(moves):
+		if ply := board.FirstIllegalPly(); ply > 0 {
+			moves = moves[:ply-1]
+		}
+	case foulType == "反則負け":
 		if len(moves) > 1 {
 			moves = moves[:len(moves)-2]
 		} else {
 			moves = nil
 		}
-	case "反則勝ち":
+	case foulType == "反則勝ち":
 		if len(moves) > 0 {
 			moves = moves[:len(moves)-1]
 		}
@@ -367,18 +494,29 @@ func BuildGameRecord(ctx context.Context, path string, session *Session, moveTim
 
 	pos, err := initialPositionFromKIF(lines)
 	if err != nil {
-		return GameRecord{}, err
+		return GameRecord{}, &BuildError{Stage: StageParse, Err: err}
 	}
 	if cache == nil {
 		cache = make(map[string]Score)
 	}
 	scores := make([]Score, len(moves))
+	evaluated := make([]bool, len(moves))
+	retries := 0
+	// partial is the record of the plies evaluated before ply i failed.
+	partial := func(i int) GameRecord {
+		record := newGameRecord(path, lines, len(moves), scores[:i], evaluated[:i], retries)
+		record.EvalComplete = false
+		if boardErr == nil {
+			record.MovesHash = board.MovesHash()
+		}
+		return record
+	}
 	for i := range moves {
 		if err := ctx.Err(); err != nil {
-			return GameRecord{}, err
+			return partial(i), &BuildError{Stage: StageEvaluate, Err: err}
 		}
 		if err := pos.ApplyMove(moves[i]); err != nil {
-			return GameRecord{}, fmt.Errorf("move %d: %w", i+1, err)
+			return GameRecord{}, &BuildError{Stage: StageParse, Err: fmt.Errorf("move %d: %w", i+1, err)}
 		}
 		// Safety net: stop if the position is illegal (e.g. king left
 		// in check). This prevents sending an invalid SFEN to the
@@ -388,20 +526,47 @@ func BuildGameRecord(ctx context.Context, path string, session *Session, moveTim
 			moves = moves[:i]
 			break
 		}
-		sfen := pos.ToSFEN(i + 1)
-		key := sfen
-		if fields := strings.Fields(sfen); len(fields) >= 3 {
-			key = strings.Join(fields[:3], " ")
+		moveTimeMs, ok := policy.MoveTime(i+1, len(moves), &pos)
+		if !ok {
+			continue
 		}
+		evaluated[i] = true
+		sfen := pos.ToSFEN(i + 1)
+		key := positionCacheKey(sfen)
 		if cached, ok := cache[key]; ok {
 			scores[i] = cached
 			continue
 		}
-		score, _, err := session.Evaluate(ctx, sfen, moveTimeMs)
+		var packed Packed256
+		havePacked := false
+		if policy.Cache != nil {
+			if packed, err = PackPosition256(pos); err == nil {
+				havePacked = true
+				if cached, ok := policy.Cache.Get(packed, SearchLimit{MoveTimeMs: moveTimeMs}); ok {
+					scores[i] = cached
+					continue
+				}
+			}
+		}
+		score, next, retried, err := policy.Retry.evaluate(ctx, session, sfen, moveTimeMs)
+		session = next
+		retries += retried
 		if err != nil {
-			return GameRecord{}, fmt.Errorf("move %d: %w", i+1, err)
+			if retried > 0 {
+				err = fmt.Errorf("move %d (after %d retries): %w", i+1, retried, err)
+			} else {
+				err = fmt.Errorf("move %d: %w", i+1, err)
+			}
+			return partial(i), &BuildError{Stage: StageEvaluate, Err: err}
 		}
+		// Records are from sente's perspective whatever the session's.
+		score = next.ScorePerspective().convert(score, sfen, PerspectiveSente)
 		scores[i] = score
+		if havePacked {
+			if err := policy.Cache.Put(packed, SearchLimit{MoveTimeMs: moveTimeMs}, score); err != nil {
+				return partial(i + 1), &BuildError{Stage: StageEvaluate, Err: err}
+			}
+		}
 
 		// Cache only up to first 30 moves to limit memory usage.
 		if i < 30 {
@@ -409,29 +574,95 @@ func BuildGameRecord(ctx context.Context, path string, session *Session, moveTim
 		}
 	}
 
+	record := newGameRecord(path, lines, len(moves), scores, evaluated, retries)
+	if boardErr == nil {
+		record.MovesHash = board.MovesHash()
+	}
+	return record, nil
+}
+
+// positionCacheKey is the key of a position in the cache of
+// BuildGameRecord: its SFEN without the move number.
+func positionCacheKey(sfen string) string {
+	if fields := strings.Fields(sfen); len(fields) >= 3 {
+		return strings.Join(fields[:3], " ")
+	}
+	return sfen
+}
+
+// ResumeCache returns the evaluations of record, a record of the KIF at
+// path, as a cache for BuildGameRecord. Passing it rebuilds the record
+// evaluating only the plies that record lacks, which completes a record
+// left incomplete (EvalComplete unset) by a failed evaluation.
+func ResumeCache(path string, record GameRecord) (map[string]Score, error) {
+	lines, err := readKIFLines(path)
+	if err != nil {
+		return nil, err
+	}
+	moves, _, err := parseKIFMoves(lines)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := initialPositionFromKIF(lines)
+	if err != nil {
+		return nil, err
+	}
+	evals := make(map[int32]MoveEval, len(record.MoveEvals))
+	for _, eval := range record.MoveEvals {
+		evals[eval.Ply] = eval
+	}
+	cache := make(map[string]Score, len(evals))
+	for i, move := range moves {
+		if len(cache) == len(evals) {
+			break
+		}
+		if err := pos.ApplyMove(move); err != nil {
+			return nil, fmt.Errorf("move %d: %w", i+1, err)
+		}
+		if eval, ok := evals[int32(i+1)]; ok {
+			cache[positionCacheKey(pos.ToSFEN(i+1))] = Score{
+				Kind:  eval.ScoreType,
+				Value: int(eval.ScoreValue),
+				Depth: int(eval.Depth),
+			}
+		}
+	}
+	return cache, nil
+}
+
+// newGameRecord assembles the record of the game in lines from the scores
+// of its evaluated plies.
+func newGameRecord(path string, lines []string, moveCount int, scores []Score, evaluated []bool, retries int) GameRecord {
 	senteName, senteRating, goteName, goteRating := parsePlayers(lines)
 	result, winReason := parseResult(lines)
 	evals := make([]MoveEval, 0, len(scores))
 	for i, score := range scores {
+		if !evaluated[i] {
+			continue
+		}
 		evals = append(evals, MoveEval{
 			Ply:        int32(i + 1),
 			ScoreType:  score.Kind,
 			ScoreValue: int32(score.Value),
+			Depth:      int32(score.Depth),
 		})
 	}
-
-	record := GameRecord{
-		GameID:      filepath.Base(path),
-		SenteName:   senteName,
-		SenteRating: senteRating,
-		GoteName:    goteName,
-		GoteRating:  goteRating,
-		Result:      result,
-		WinReason:   winReason,
-		MoveCount:   int32(len(moves)),
-		MoveEvals:   evals,
+	return GameRecord{
+		GameID:       filepath.Base(path),
+		SenteName:    senteName,
+		SenteRating:  senteRating,
+		GoteName:     goteName,
+		GoteRating:   goteRating,
+		Result:       result,
+		WinReason:    winReason,
+		MoveCount:    int32(moveCount),
+		MoveEvals:    evals,
+		StartTime:    KIFStartTime(lines),
+		EndTime:      KIFEndTime(lines),
+		TimeControl:  KIFTimeControl(lines),
+		EvalRetries:  int32(retries),
+		EvalComplete: true,
 	}
-	return record, nil
 }
 
 func parsePlayers(lines []string) (string, int32, string, int32) {
@@ -460,6 +691,84 @@ func PlayersFromKIFLines(lines []string) KIFPlayers {
 	}
 }
 
+// ResultFromKIFLines returns the game result ("sente_win", "gote_win",
+// "draw", "abort" or "unknown") and the terminal token as win reason.
+func ResultFromKIFLines(lines []string) (string, string) {
+	return parseResult(lines)
+}
+
+// KIFHeaderValue returns the value of a "key：value" header line, or "".
+func KIFHeaderValue(lines []string, key string) string {
+	return headerValue(lines, key)
+}
+
+// RecordTimeLayout is the layout of the time columns of GameRecord. KIF
+// times carry no zone, so neither do the columns; they sort as strings.
+const RecordTimeLayout = "2006-01-02T15:04:05"
+
+// kifTimeLayouts are the 開始日時/終了日時 formats seen in KIF files.
+var kifTimeLayouts = []string{
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"2006/01/02",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// kifWeekday matches a weekday such as "(木)" after the date.
+var kifWeekday = regexp.MustCompile(`[(（][^)）]*[)）]`)
+
+// ParseKIFTime parses a KIF date header value. A weekday after the date is
+// ignored.
+func ParseKIFTime(raw string) (time.Time, bool) {
+	raw = strings.Join(strings.Fields(kifWeekday.ReplaceAllString(raw, " ")), " ")
+	for _, layout := range kifTimeLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// KIFStartTime returns the 開始日時 header in RecordTimeLayout, or "" when
+// it is missing or unparsable.
+func KIFStartTime(lines []string) string {
+	return kifHeaderTime(lines, "開始日時")
+}
+
+// KIFEndTime returns the 終了日時 header like KIFStartTime.
+func KIFEndTime(lines []string) string {
+	return kifHeaderTime(lines, "終了日時")
+}
+
+// KIFTimeControl returns the 持ち時間 header. KIFs without one (81Dojo)
+// carry the time control in 棋戦 after the game type, e.g. "早指し2(猶予1分)"
+// in "R対局 早指し2(猶予1分)"; that part is returned instead.
+func KIFTimeControl(lines []string) string {
+	if value := headerValue(lines, "持ち時間"); value != "" {
+		return value
+	}
+	fields := strings.Fields(headerValue(lines, "棋戦"))
+	if len(fields) < 2 {
+		return ""
+	}
+	last := fields[len(fields)-1]
+	for _, marker := range []string{"持ち時間", "早指し", "秒", "分"} {
+		if strings.Contains(last, marker) {
+			return last
+		}
+	}
+	return ""
+}
+
+func kifHeaderTime(lines []string, key string) string {
+	t, ok := ParseKIFTime(headerValue(lines, key))
+	if !ok {
+		return ""
+	}
+	return t.Format(RecordTimeLayout)
+}
+
 func headerValue(lines []string, key string) string {
 	prefixes := []string{key + "：", key + ":"}
 	for _, line := range lines {
@@ -503,6 +812,9 @@ func parseResult(lines []string) (string, string) {
 func findTerminalMove(lines []string) (string, int) {
 	ply := 0
 	for _, line := range lines {
+		if variationLineRe.MatchString(line) {
+			break
+		}
 		// Try the standard move line pattern first (has clock info).
 		match := moveLineRe.FindStringSubmatch(line)
 		if len(match) == 0 {
@@ -549,8 +861,16 @@ func winnerFromPly(ply int) string {
 // WalkKIF calls fn for each .kif file found under root (in no particular
 // order). Unlike CollectKIF it never builds a full path list, so it works
 // well with directories containing millions of files.
+// .zip and .tar.gz archives are descended into; their members are passed
+// as virtual paths that the Load/Read functions of this package accept.
 // If fn returns a non-nil error, the walk stops and WalkKIF returns that error.
 func WalkKIF(root string, fn func(path string) error) error {
+	return WalkKIFWith(root, KIFWalkOptions{}, fn)
+}
+
+// WalkKIFWith is WalkKIF with a configurable extension set and archive
+// handling.
+func WalkKIFWith(root string, opts KIFWalkOptions, fn func(path string) error) error {
 	return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -558,9 +878,12 @@ func WalkKIF(root string, fn func(path string) error) error {
 		if d.IsDir() {
 			return nil
 		}
-		if strings.EqualFold(filepath.Ext(path), ".kif") {
+		if opts.matches(path) {
 			return fn(path)
 		}
+		if kind := archiveKindOf(path); kind != archiveNone && !opts.SkipArchives {
+			return walkArchive(path, kind, opts, fn)
+		}
 		return nil
 	})
 }
@@ -568,8 +891,13 @@ func WalkKIF(root string, fn func(path string) error) error {
 // CountKIF returns the number of .kif files under root without
 // allocating a list of paths.
 func CountKIF(root string) (int, error) {
+	return CountKIFWith(root, KIFWalkOptions{})
+}
+
+// CountKIFWith counts the files WalkKIFWith would visit.
+func CountKIFWith(root string, opts KIFWalkOptions) (int, error) {
 	n := 0
-	err := WalkKIF(root, func(_ string) error {
+	err := WalkKIFWith(root, opts, func(_ string) error {
 		n++
 		return nil
 	})
@@ -601,11 +929,15 @@ func BoardFromKIF(lines []string) (*Board, error) {
 	if err != nil {
 		return nil, err
 	}
-	moves, _, err := parseKIFMoves(lines)
+	tree, err := parseKIFTree(lines)
 	if err != nil {
 		return nil, err
 	}
-	return &Board{initial: pos, moves: moves, foulEnd: isFoulEnd(lines)}, nil
+	board := &Board{initial: pos, moves: tree.moves, foulEnd: isFoulEnd(lines), comments: tree.comments, terminal: tree.terminal}
+	for _, child := range tree.children {
+		board.variations = append(board.variations, child.toVariation())
+	}
+	return board, nil
 }
 
 func (b *Board) MoveCount() int {
@@ -630,13 +962,29 @@ func (b *Board) InitialPosition() Position {
 	return b.initial.Clone()
 }
 
-// Moves returns the board's move list.
+// Moves returns the board's main-line move list.
 func (b *Board) Moves() []string {
 	out := make([]string, len(b.moves))
 	copy(out, b.moves)
 	return out
 }
 
+// MovesHash returns a hash of the initial position and the main-line
+// moves as 16 hex digits: games with the same moves have the same hash
+// whatever their headers and comments.
+func (b *Board) MovesHash() string {
+	if b == nil {
+		return ""
+	}
+	h := fnv.New64a()
+	h.Write([]byte(b.initial.ToSFEN(1)))
+	for _, move := range b.moves {
+		h.Write([]byte{' '})
+		h.Write([]byte(move))
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
 // NewPosition returns an empty Position (no pieces, Black to move).
 func NewPosition() Position {
 	return Position{
@@ -649,10 +997,26 @@ func NewPosition() Position {
 }
 
 // SetPiece places a piece on the board.  file and rank are 1-indexed.
+// Nothing is checked; PositionBuilder rejects impossible positions.
 func (p *Position) SetPiece(file, rank int, kind string, color Color, promoted bool) {
 	p.setPiece(square{file: file, rank: rank}, &Piece{kind: kind, color: color, promoted: promoted})
 }
 
+// PieceAt returns the piece on file/rank (1-indexed). ok is false for an
+// empty or out-of-range square.
+func (p *Position) PieceAt(file, rank int) (kind string, color Color, promoted bool, ok bool) {
+	piece := p.pieceAt(square{file: file, rank: rank})
+	if piece == nil {
+		return "", Black, false, false
+	}
+	return piece.kind, piece.color, piece.promoted, true
+}
+
+// Turn returns which side is to move.
+func (p *Position) Turn() Color {
+	return p.turn
+}
+
 // SetTurn sets which side is to move.
 func (p *Position) SetTurn(color Color) {
 	p.turn = color
@@ -1295,8 +1659,12 @@ func collectBoardLines(lines []string) []string {
 	var board []string
 	for _, line := range lines {
 		trim := strings.TrimSpace(line)
-		if strings.HasPrefix(trim, "|") && strings.HasSuffix(trim, "|") {
-			board = append(board, trim)
+		if !strings.HasPrefix(trim, "|") {
+			continue
+		}
+		// Standard diagrams label each row after the closing bar ("|一").
+		if end := strings.LastIndex(trim, "|"); end > 0 {
+			board = append(board, trim[:end+1])
 		}
 	}
 	return board
@@ -1370,6 +1738,12 @@ func parseBoardPiece(runes []rune) (string, int, error) {
 		return "+B", 1, nil
 	case '龍', '竜':
 		return "+R", 1, nil
+	case '全':
+		return "+S", 1, nil
+	case '圭':
+		return "+N", 1, nil
+	case '杏':
+		return "+L", 1, nil
 	case '成':
 		if len(runes) < 2 {
 			return "", 0, errors.New("missing promoted piece")
@@ -1458,6 +1832,13 @@ func parseTurn(lines []string) string {
 				return "b"
 			}
 		}
+		// Board diagrams mark the side to move with a bare "後手番" line.
+		switch trim {
+		case "後手番", "上手番":
+			return "w"
+		case "先手番", "下手番":
+			return "b"
+		}
 	}
 	return "b"
 }
@@ -1569,7 +1950,15 @@ func parseCount(runes []rune) (int, int) {
 		if !ok {
 			break
 		}
-		value = value*10 + n
+		if n == 10 {
+			// "十" is a tens marker: 十 = 10, 十八 = 18.
+			if value == 0 {
+				value = 1
+			}
+			value *= 10
+		} else {
+			value += n
+		}
 		consumed++
 	}
 	if value == 0 {
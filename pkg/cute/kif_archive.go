@@ -0,0 +1,313 @@
+package cute
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Game files inside .zip and .tar.gz archives are addressed with a virtual
+// path of the form "<archive>!/<member>", e.g. "dump.zip!/2024/123.kif".
+// filepath.Base of such a path is the member's base name, so game IDs
+// derived from paths are the same as for extracted files.
+const archiveSep = "!/"
+
+// DefaultKIFExtensions is the extension set used by WalkKIF and CountKIF.
+var DefaultKIFExtensions = []string{".kif"}
+
+// KIFWalkOptions configures WalkKIFWith.
+type KIFWalkOptions struct {
+	// Extensions lists the accepted game file extensions (case-insensitive,
+	// with leading dot). Empty means DefaultKIFExtensions. Only KIF is
+	// parsed by this package; other formats such as .csa or .ki2 are
+	// listed for callers that handle them.
+	Extensions []string
+	// SkipArchives disables descending into .zip/.tar.gz/.tgz files.
+	SkipArchives bool
+}
+
+func (o KIFWalkOptions) matches(name string) bool {
+	exts := o.Extensions
+	if len(exts) == 0 {
+		exts = DefaultKIFExtensions
+	}
+	ext := filepath.Ext(name)
+	for _, e := range exts {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseKIFExtensions parses a comma-separated extension list such as
+// "kif,.csa". A missing leading dot is added.
+func ParseKIFExtensions(raw string) []string {
+	var exts []string
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.HasPrefix(part, ".") {
+			part = "." + part
+		}
+		exts = append(exts, part)
+	}
+	return exts
+}
+
+type archiveKind int
+
+const (
+	archiveNone archiveKind = iota
+	archiveZip
+	archiveTarGz
+)
+
+func archiveKindOf(path string) archiveKind {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return archiveTarGz
+	default:
+		return archiveNone
+	}
+}
+
+// splitArchivePath splits a virtual archive path into the archive file and
+// member name. ok is false for plain file paths.
+func splitArchivePath(path string) (archive, member string, ok bool) {
+	idx := strings.Index(path, archiveSep)
+	for idx >= 0 {
+		if archiveKindOf(path[:idx]) != archiveNone {
+			return path[:idx], path[idx+len(archiveSep):], true
+		}
+		next := strings.Index(path[idx+1:], archiveSep)
+		if next < 0 {
+			break
+		}
+		idx += 1 + next
+	}
+	return "", "", false
+}
+
+// walkArchive calls fn with the virtual path of every matching member.
+func walkArchive(path string, kind archiveKind, opts KIFWalkOptions, fn func(path string) error) error {
+	switch kind {
+	case archiveZip:
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() || !opts.matches(f.Name) {
+				continue
+			}
+			if err := fn(path + archiveSep + f.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	case archiveTarGz:
+		return scanTarGz(path, func(hdr *tar.Header, _ io.Reader) error {
+			if hdr.Typeflag != tar.TypeReg || !opts.matches(hdr.Name) {
+				return nil
+			}
+			return fn(path + archiveSep + hdr.Name)
+		})
+	}
+	return nil
+}
+
+func scanTarGz(path string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// ReadKIFFile returns the raw bytes of a game file or archive member (a
+// virtual path from WalkKIF), e.g. to send the game elsewhere.
+func ReadKIFFile(path string) ([]byte, error) {
+	return readKIFFile(path)
+}
+
+// readKIFFile returns the raw bytes of a game file or archive member.
+func readKIFFile(path string) ([]byte, error) {
+	archive, member, ok := splitArchivePath(path)
+	if !ok {
+		return os.ReadFile(path)
+	}
+	switch archiveKindOf(archive) {
+	case archiveZip:
+		return readZipMember(archive, member)
+	default:
+		return readTarGzMember(archive, member)
+	}
+}
+
+// Open archives are cached for the life of the process so that reading
+// millions of members does not reopen (and, for tar.gz, re-decompress)
+// the archive each time.
+var (
+	archiveMu  sync.Mutex
+	zipCache   = map[string]*zipArchive{}
+	tarCursors = map[string]*tarCursor{}
+)
+
+type zipArchive struct {
+	rc    *zip.ReadCloser
+	files map[string]*zip.File
+}
+
+func readZipMember(archive, member string) ([]byte, error) {
+	archiveMu.Lock()
+	za := zipCache[archive]
+	if za == nil {
+		rc, err := zip.OpenReader(archive)
+		if err != nil {
+			archiveMu.Unlock()
+			return nil, err
+		}
+		za = &zipArchive{rc: rc, files: make(map[string]*zip.File, len(rc.File))}
+		for _, f := range rc.File {
+			za.files[f.Name] = f
+		}
+		zipCache[archive] = za
+	}
+	archiveMu.Unlock()
+
+	f, ok := za.files[member]
+	if !ok {
+		return nil, fmt.Errorf("%s: member not found: %s", archive, member)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// tarCursor streams a tar.gz archive forward. Members read past while
+// looking for a requested one are kept until they are requested, so
+// workers consuming paths in walk order only buffer a handful of members.
+// A member requested again after it was consumed restarts the stream.
+type tarCursor struct {
+	mu      sync.Mutex
+	f       *os.File
+	gz      *gzip.Reader
+	tr      *tar.Reader
+	pending map[string][]byte
+}
+
+func readTarGzMember(archive, member string) ([]byte, error) {
+	archiveMu.Lock()
+	c := tarCursors[archive]
+	if c == nil {
+		c = &tarCursor{pending: map[string][]byte{}}
+		tarCursors[archive] = c
+	}
+	archiveMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if data, ok := c.pending[member]; ok {
+		delete(c.pending, member)
+		return data, nil
+	}
+	if c.tr == nil {
+		if err := c.open(archive); err != nil {
+			return nil, err
+		}
+	}
+	rescanning := false
+	for {
+		hdr, err := c.tr.Next()
+		if err == io.EOF {
+			c.close()
+			if rescanning {
+				return nil, fmt.Errorf("%s: member not found: %s", archive, member)
+			}
+			if err := c.open(archive); err != nil {
+				return nil, err
+			}
+			rescanning = true
+			continue
+		}
+		if err != nil {
+			c.close()
+			return nil, fmt.Errorf("%s: %w", archive, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Name == member {
+			return io.ReadAll(c.tr)
+		}
+		if rescanning || !strings.EqualFold(filepath.Ext(hdr.Name), filepath.Ext(member)) {
+			// Members before the target were consumed already on a
+			// rescan, and other file types are never requested.
+			continue
+		}
+		data, err := io.ReadAll(c.tr)
+		if err != nil {
+			c.close()
+			return nil, fmt.Errorf("%s: %w", archive, err)
+		}
+		c.pending[hdr.Name] = data
+	}
+}
+
+func (c *tarCursor) open(archive string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", archive, err)
+	}
+	c.f, c.gz, c.tr = f, gz, tar.NewReader(gz)
+	return nil
+}
+
+func (c *tarCursor) close() {
+	if c.gz != nil {
+		c.gz.Close()
+	}
+	if c.f != nil {
+		c.f.Close()
+	}
+	c.f, c.gz, c.tr = nil, nil, nil
+}
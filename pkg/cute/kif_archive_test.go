@@ -0,0 +1,133 @@
+package cute_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestWalkKIFArchives(t *testing.T) {
+	games := []string{"36502618.kif", "36521330.kif", "36523138.kif", "real.kif"}
+	contents := make(map[string][]byte)
+	for _, name := range games {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		contents[name] = data
+	}
+
+	dir := t.TempDir()
+	writeZip(t, filepath.Join(dir, "dump.zip"), map[string][]byte{
+		"2024/" + games[0]: contents[games[0]],
+		"README.txt":       []byte("not a game"),
+	})
+	writeTarGz(t, filepath.Join(dir, "dump.tar.gz"), []string{games[1], "notes.txt", games[2], games[3]},
+		map[string][]byte{games[1]: contents[games[1]], "notes.txt": []byte("x"), games[2]: contents[games[2]], games[3]: contents[games[3]]})
+	if err := os.WriteFile(filepath.Join(dir, "game.csa"), []byte("V2.2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	if err := cute.WalkKIF(dir, func(path string) error {
+		paths = append(paths, path)
+		return nil
+	}); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	if len(paths) != len(games) {
+		t.Fatalf("unexpected paths: %v", paths)
+	}
+
+	// Read the tar.gz members out of order to exercise buffering and rescans.
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	for _, path := range append(paths, paths...) {
+		board, err := cute.LoadBoardFromKIF(path)
+		if err != nil {
+			t.Fatalf("load %s: %v", path, err)
+		}
+		want, err := cute.LoadBoardFromKIF(filepath.Join("testdata", filepath.Base(path)))
+		if err != nil {
+			t.Fatalf("load original: %v", err)
+		}
+		got, _ := board.SFENAt(board.MoveCount())
+		exp, _ := want.SFENAt(want.MoveCount())
+		if got != exp {
+			t.Fatalf("%s: got %s want %s", path, got, exp)
+		}
+		data, err := cute.ReadKIFFile(path)
+		if err != nil || !bytes.Equal(data, contents[filepath.Base(path)]) {
+			t.Fatalf("%s: ReadKIFFile got %d bytes, %v", path, len(data), err)
+		}
+	}
+
+	n, err := cute.CountKIFWith(dir, cute.KIFWalkOptions{Extensions: cute.ParseKIFExtensions("kif,csa")})
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if n != len(games)+1 {
+		t.Fatalf("unexpected count with .csa: %d", n)
+	}
+	n, err = cute.CountKIFWith(dir, cute.KIFWalkOptions{SkipArchives: true})
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("unexpected count without archives: %d", n)
+	}
+}
+
+func writeZip(t *testing.T, path string, files map[string][]byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGz(t *testing.T, path string, order []string, files map[string][]byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, name := range order {
+		data := files[name]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,83 @@
+package cute
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KIF statuses reported by CheckKIF, from best to worst.
+const (
+	KIFOK            = "ok"
+	KIFFoulEnd       = "foul-end"       // ends with 反則勝ち/反則負け; graph drops the foul
+	KIFFalseMate     = "false-mate"     // ends with 詰み, but the side to move is not checkmated
+	KIFIllegalMove   = "illegal-move"   // a move breaks the rules (see Position.CheckMove)
+	KIFParseError    = "parse-error"    // no moves, or headers or moves that cannot be parsed
+	KIFEncodingError = "encoding-error" // unreadable, or neither UTF-8, Shift-JIS nor EUC-JP
+)
+
+// KIFCheck is the result of CheckKIF.
+type KIFCheck struct {
+	Status string
+	// Moves is the number of main-line moves (0 when not parsed).
+	Moves int
+	// Ply is the illegal move of KIFIllegalMove, the foul of KIFFoulEnd
+	// (0 when every move is legal, e.g. 相手番投了), or the last move of
+	// KIFFalseMate.
+	Ply int
+	// Err describes the problem (nil for KIFOK and KIFFoulEnd).
+	Err error
+}
+
+// CheckKIF reads, parses and replays the KIF at path the way
+// BuildGameRecord does, without an engine, and classifies it. In a game
+// ending with a foul the first illegal move is the foul (BuildGameRecord
+// evaluates the game up to it), so such a game is KIFFoulEnd. A game
+// ending with 詰み is checked to end in checkmate.
+func CheckKIF(path string) KIFCheck {
+	data, err := readKIFFile(path)
+	if err != nil {
+		return KIFCheck{Status: KIFEncodingError, Err: err}
+	}
+	text, err := decodeKIF(data)
+	if err != nil {
+		return KIFCheck{Status: KIFEncodingError, Err: err}
+	}
+	if kifTextScore(text) <= 0 {
+		return KIFCheck{Status: KIFEncodingError, Err: errors.New("no KIF headers found (wrong encoding or not a KIF file)")}
+	}
+	lines := splitKIFLines(text)
+	board, err := BoardFromKIF(lines)
+	if err != nil {
+		return KIFCheck{Status: KIFParseError, Err: err}
+	}
+	moves := board.Moves()
+	if len(moves) == 0 {
+		return KIFCheck{Status: KIFParseError, Err: errors.New("no moves found")}
+	}
+	check := KIFCheck{Status: KIFOK, Moves: len(moves)}
+	ply, err := board.firstIllegalMove()
+	if foulEndType(lines) != "" {
+		check.Status = KIFFoulEnd
+		check.Ply = ply
+		return check
+	}
+	if ply > 0 {
+		return KIFCheck{Status: KIFIllegalMove, Moves: check.Moves, Ply: ply, Err: fmt.Errorf("move %d (%s): %w", ply, moves[ply-1], err)}
+	}
+	if terminal, _ := findTerminalMove(lines); terminal == "詰み" && !board.endsInCheckmate() {
+		return KIFCheck{Status: KIFFalseMate, Moves: check.Moves, Ply: check.Moves, Err: errors.New("ends with 詰み, but the side to move is not checkmated")}
+	}
+	return check
+}
+
+// endsInCheckmate reports whether the side to move is checkmated after the
+// main line (see IsForcedMate).
+func (b *Board) endsInCheckmate() bool {
+	pos := b.initial.Clone()
+	for _, move := range b.moves {
+		if err := pos.ApplyMove(move); err != nil {
+			return false
+		}
+	}
+	return IsForcedMate(pos, 0)
+}
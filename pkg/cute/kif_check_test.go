@@ -0,0 +1,52 @@
+package cute_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestCheckKIF(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join("testdata", "basic_aigakari.kif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	// Move 3 moves the pawn from 2七 instead of 2六.
+	illegal := write("illegal.kif", strings.Replace(string(data), "２五歩(26)", "２五歩(27)", 1))
+	falseMate := write("falsemate.kif", string(data)+"\n  13 詰み\n")
+	binary := write("binary.kif", "\xff\xfe\x00\x01garbage")
+	noMoves := write("nomoves.kif", "手合割：平手\n先手：a\n後手：b\n手数----指手---------消費時間--\n")
+
+	for _, tc := range []struct {
+		path   string
+		status string
+		ply    int
+	}{
+		{filepath.Join("testdata", "basic_aigakari.kif"), cute.KIFOK, 0},
+		{filepath.Join("testdata", "37983487.kif"), cute.KIFFoulEnd, 18},
+		{falseMate, cute.KIFFalseMate, 12},
+		{illegal, cute.KIFIllegalMove, 3},
+		{noMoves, cute.KIFParseError, 0},
+		{binary, cute.KIFEncodingError, 0},
+		{filepath.Join(dir, "missing.kif"), cute.KIFEncodingError, 0},
+	} {
+		got := cute.CheckKIF(tc.path)
+		if got.Status != tc.status || got.Ply != tc.ply {
+			t.Errorf("%s: got %s at ply %d (%v), want %s at ply %d", filepath.Base(tc.path), got.Status, got.Ply, got.Err, tc.status, tc.ply)
+		}
+		if (got.Err == nil) != (tc.status == cute.KIFOK || tc.status == cute.KIFFoulEnd) {
+			t.Errorf("%s: err %v", filepath.Base(tc.path), got.Err)
+		}
+	}
+}
@@ -3,6 +3,9 @@ package cute_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -12,6 +15,9 @@ import (
 	"time"
 
 	cute "cute/pkg/cute"
+
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
 )
 
 func TestKIFToSFENInitial(t *testing.T) {
@@ -232,6 +238,102 @@ func assertPackRoundTrip(t *testing.T, sfen string) {
 	}
 }
 
+func TestPacked256Serialization(t *testing.T) {
+	pos, err := cute.PositionFromSFEN("lnsgkgsnl/1r5b1/ppppppppp/9/9/2P6/PP1PPPPPP/1B5R1/LNSGKGSNL w - 2")
+	if err != nil {
+		t.Fatalf("failed to parse sfen: %v", err)
+	}
+	packed, err := cute.PackPosition256(pos)
+	if err != nil {
+		t.Fatalf("failed to pack sfen: %v", err)
+	}
+
+	data, err := packed.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if len(data) != 32 {
+		t.Fatalf("unexpected length: %d", len(data))
+	}
+	var decoded cute.Packed256
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded != packed {
+		t.Fatalf("binary round trip mismatch: got %v want %v", decoded, packed)
+	}
+
+	encoded := packed.Base64()
+	if len(encoded) != 43 || strings.ContainsAny(encoded, "+/=") {
+		t.Fatalf("not unpadded URL-safe base64: %q", encoded)
+	}
+	parsed, err := cute.ParsePacked256Base64(encoded)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if parsed != packed {
+		t.Fatalf("base64 round trip mismatch: got %v want %v", parsed, packed)
+	}
+
+	keyed, err := json.Marshal(map[cute.Packed256]int{packed: 1})
+	if err != nil {
+		t.Fatalf("json marshal: %v", err)
+	}
+	var back map[cute.Packed256]int
+	if err := json.Unmarshal(keyed, &back); err != nil {
+		t.Fatalf("json unmarshal: %v", err)
+	}
+	if back[packed] != 1 {
+		t.Fatalf("json key round trip failed: %s", keyed)
+	}
+
+	if err := decoded.UnmarshalBinary(data[:31]); err == nil {
+		t.Fatal("expected error for short input")
+	}
+	if _, err := cute.ParsePacked256Base64("not*base64"); err == nil {
+		t.Fatal("expected error for invalid base64")
+	}
+}
+
+func TestPackPositionVar(t *testing.T) {
+	for _, tc := range []struct {
+		sfen     string
+		variable bool
+	}{
+		{"lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1", false},
+		// 香落ち, 二枚落ち and a tsume position with only the white king.
+		{"lnsgkgsn1/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL w - 1", true},
+		{"lnsgkgsnl/9/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL w - 1", true},
+		{"7nl/7k1/6ppp/9/9/9/9/9/9 b RGS2P 1", true},
+	} {
+		pos, err := cute.PositionFromSFEN(tc.sfen)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.sfen, err)
+		}
+		packed, err := cute.PackPosition(pos)
+		if err != nil {
+			t.Fatalf("%s: pack: %v", tc.sfen, err)
+		}
+		if _, ok := packed.(cute.PackedVar); ok != tc.variable {
+			t.Fatalf("%s: got %T", tc.sfen, packed)
+		}
+		unpacked, err := packed.Unpack()
+		if err != nil {
+			t.Fatalf("%s: unpack: %v", tc.sfen, err)
+		}
+		if got := unpacked.ToSFEN(1); got != tc.sfen {
+			t.Fatalf("pack/unpack mismatch: got %s want %s", got, tc.sfen)
+		}
+		again, _ := cute.PackPosition(unpacked)
+		if again != packed {
+			t.Fatalf("%s: packing is not stable", tc.sfen)
+		}
+	}
+	if _, err := cute.UnpackPositionVar("\x10"); err == nil {
+		t.Fatal("expected error for short input")
+	}
+}
+
 func parseMoveNumber(sfen string) int {
 	fields := strings.Fields(sfen)
 	if len(fields) >= 4 {
@@ -320,3 +422,372 @@ func TestBuildGameRecordEvaluatesTestKIFs(t *testing.T) {
 		t.Fatalf("engine stopped after evaluations: %v", err)
 	}
 }
+
+func TestResultFromKIFLines(t *testing.T) {
+	tests := []struct {
+		file       string
+		wantResult string
+		wantReason string
+	}{
+		{file: "36589641.kif", wantResult: "gote_win", wantReason: "反則勝ち"},
+		{file: "37983487.kif", wantResult: "sente_win", wantReason: "反則負け"},
+	}
+	for _, tt := range tests {
+		lines, err := cute.ReadKIFLines(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatalf("%s: read: %v", tt.file, err)
+		}
+		result, reason := cute.ResultFromKIFLines(lines)
+		if result != tt.wantResult || reason != tt.wantReason {
+			t.Fatalf("%s: got (%s, %s) want (%s, %s)", tt.file, result, reason, tt.wantResult, tt.wantReason)
+		}
+		players := cute.PlayersFromKIFLines(lines)
+		if players.SenteName == "" || players.GoteName == "" {
+			t.Fatalf("%s: missing player names: %+v", tt.file, players)
+		}
+	}
+}
+
+func TestParseKIFTime(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{raw: "2025/04/10 13:00:54", want: "2025-04-10T13:00:54"},
+		{raw: "2025/04/10(木) 13:00:54", want: "2025-04-10T13:00:54"},
+		{raw: "2025/04/10（木）13:00", want: "2025-04-10T13:00:00"},
+		{raw: "2025/04/10", want: "2025-04-10T00:00:00"},
+		{raw: "", want: ""},
+		{raw: "unknown", want: ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if parsed, ok := cute.ParseKIFTime(tt.raw); ok {
+			got = parsed.Format(cute.RecordTimeLayout)
+		}
+		if got != tt.want {
+			t.Errorf("ParseKIFTime(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+
+	lines := []string{"開始日時：2025/04/10 13:00:54", "終了日時：2025/04/10 13:20:05", "先手：a", "後手：b"}
+	if got := cute.KIFStartTime(lines); got != "2025-04-10T13:00:54" {
+		t.Errorf("KIFStartTime = %q", got)
+	}
+	if got := cute.KIFEndTime(lines); got != "2025-04-10T13:20:05" {
+		t.Errorf("KIFEndTime = %q", got)
+	}
+}
+
+func TestKIFTimeControl(t *testing.T) {
+	tests := []struct {
+		lines []string
+		want  string
+	}{
+		{lines: []string{"持ち時間：各10分", "棋戦：R対局 早指し2(猶予1分)"}, want: "各10分"},
+		{lines: []string{"棋戦：R対局 早指し2(猶予1分)"}, want: "早指し2(猶予1分)"},
+		{lines: []string{"棋戦：R対局 持ち時間15分"}, want: "持ち時間15分"},
+		{lines: []string{"棋戦：名人戦"}, want: ""},
+		{lines: []string{"棋戦：第1回 大会"}, want: ""},
+	}
+	for _, tt := range tests {
+		if got := cute.KIFTimeControl(tt.lines); got != tt.want {
+			t.Errorf("KIFTimeControl(%q) = %q, want %q", tt.lines, got, tt.want)
+		}
+	}
+}
+
+func TestReadKIFLinesEncodings(t *testing.T) {
+	want, err := cute.ReadKIFLines(filepath.Join("testdata", "real.kif"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	text := strings.Join(want, "\n")
+
+	eucReader := transform.NewReader(strings.NewReader(text), japanese.EUCJP.NewEncoder())
+	euc, err := io.ReadAll(eucReader)
+	if err != nil {
+		t.Fatalf("encode EUC-JP: %v", err)
+	}
+	sjis, err := cute.EncodeKIF(text, cute.KIFEncodingShiftJIS)
+	if err != nil {
+		t.Fatalf("encode Shift-JIS: %v", err)
+	}
+	utf, err := cute.EncodeKIF(text, cute.KIFEncodingUTF8)
+	if err != nil {
+		t.Fatalf("encode UTF-8: %v", err)
+	}
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{"euc.kif": euc, "sjis.kif": sjis, "utf8.kif": utf} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := cute.ReadKIFLines(path)
+		if err != nil {
+			t.Fatalf("%s: read: %v", name, err)
+		}
+		if strings.Join(got, "\n") != text {
+			t.Fatalf("%s: decoded text differs from original", name)
+		}
+	}
+
+	if _, err := cute.EncodeKIF("☺", cute.KIFEncodingShiftJIS); err == nil {
+		t.Fatal("expected error for character outside Shift-JIS")
+	}
+	if _, err := cute.EncodeKIF(text, "latin1"); err == nil {
+		t.Fatal("expected error for unknown encoding")
+	}
+}
+
+func TestBoardCommentsAndVariations(t *testing.T) {
+	board, err := cute.LoadBoardFromKIF(filepath.Join("testdata", "variations.kif"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got, want := strings.Join(board.Moves(), " "), "7g7f 3c3d 2g2f 8c8d"; got != want {
+		t.Fatalf("main line: got %s want %s", got, want)
+	}
+	if got := board.Comments(0); len(got) != 1 || got[0] != "対局前のコメント" {
+		t.Fatalf("comments before first move: %q", got)
+	}
+	if got := board.Comments(1); len(got) != 2 || got[0] != "角道を開ける" || got[1] != "二行目" {
+		t.Fatalf("comments after move 1: %q", got)
+	}
+	if got := board.Comments(2); len(got) != 0 {
+		t.Fatalf("comments after move 2: %q", got)
+	}
+
+	texts, err := board.MoveTexts()
+	if err != nil {
+		t.Fatalf("move texts: %v", err)
+	}
+	if got, want := strings.Join(texts, " "), "７六歩(77) ３四歩(33) ２六歩(27) ８四歩(83)"; got != want {
+		t.Fatalf("move texts: got %s want %s", got, want)
+	}
+
+	vars := board.Variations()
+	if len(vars) != 2 {
+		t.Fatalf("expected 2 variations from the main line, got %d", len(vars))
+	}
+	v := vars[0]
+	if v.Ply != 3 || strings.Join(v.Moves, " ") != "8h2b+ 3a2b B*4e" {
+		t.Fatalf("first variation: %+v", v)
+	}
+	if len(v.Comments) != 3 || len(v.Comments[0]) != 1 || v.Comments[0][0] != "角交換" {
+		t.Fatalf("first variation comments: %q", v.Comments)
+	}
+	if len(v.Variations) != 1 || v.Variations[0].Ply != 4 || strings.Join(v.Variations[0].Moves, " ") != "8b2b" {
+		t.Fatalf("nested variation: %+v", v.Variations)
+	}
+	if vars[1].Ply != 2 || strings.Join(vars[1].Moves, " ") != "8c8d" {
+		t.Fatalf("second variation: %+v", vars[1])
+	}
+
+	var plies []int
+	var last string
+	if err := board.ForEachPly(func(ply int, pos *cute.Position, move string) error {
+		plies = append(plies, ply)
+		if move == "" {
+			last = pos.ToSFEN(ply + 1)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("iterate: %v", err)
+	}
+	if len(plies) != board.MoveCount()+1 {
+		t.Fatalf("unexpected plies: %v", plies)
+	}
+	if want, _ := board.SFENAt(board.MoveCount()); last != want {
+		t.Fatalf("final position: got %s want %s", last, want)
+	}
+}
+
+func TestAnnotateKIF(t *testing.T) {
+	lines, err := cute.ReadKIFLines(filepath.Join("testdata", "variations.kif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := cute.GameRecord{MoveEvals: []cute.MoveEval{
+		{Ply: 1, ScoreType: "cp", ScoreValue: 40, Depth: 12},
+		{Ply: 3, ScoreType: "mate", ScoreValue: -5},
+	}}
+	annotated, err := cute.AnnotateKIF(lines, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	board, err := cute.BoardFromKIF(annotated)
+	if err != nil {
+		t.Fatalf("reparse: %v\n%s", err, strings.Join(annotated, "\n"))
+	}
+	if got, want := strings.Join(board.Moves(), " "), "7g7f 3c3d 2g2f 8c8d"; got != want {
+		t.Fatalf("main line: got %s want %s", got, want)
+	}
+	if got := board.Comments(1); len(got) != 3 || got[0] != "角道を開ける" || got[2] != "eval cp 40 depth 12" {
+		t.Fatalf("comments after move 1: %q", got)
+	}
+	if got := board.Comments(2); len(got) != 0 {
+		t.Fatalf("comments after move 2: %q", got)
+	}
+	if got := board.Comments(3); len(got) != 1 || got[0] != "eval mate -5" {
+		t.Fatalf("comments after move 3: %q", got)
+	}
+	if len(board.Variations()) == 0 {
+		t.Fatal("variations lost")
+	}
+
+	record.MoveEvals = append(record.MoveEvals, cute.MoveEval{Ply: 9, ScoreType: "cp"})
+	if _, err := cute.AnnotateKIF(lines, record); err == nil {
+		t.Fatal("expected an error for an eval past the last move")
+	}
+}
+
+func TestWriteKIFRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.kif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range files {
+		lines, err := cute.ReadKIFLines(path)
+		if err != nil {
+			t.Fatalf("%s: read: %v", path, err)
+		}
+		assertWriteKIFRoundTrip(t, filepath.Base(path), lines)
+	}
+
+	bod := []string{
+		"後手の持駒：なし",
+		"  ９ ８ ７ ６ ５ ４ ３ ２ １",
+		"+---------------------------+",
+		"| ・ ・ ・ ・v玉 ・ ・ ・ ・|一",
+		"| ・ ・ ・ ・ ・ ・ ・ ・ ・|二",
+		"| ・ ・ ・ ・ 全 ・ ・ ・ ・|三",
+		"| ・ ・ ・ ・ ・ ・ ・ ・ ・|四",
+		"| ・ ・ ・ ・ ・ ・ ・ ・ ・|五",
+		"| ・ ・ ・ ・ ・ ・ ・ ・ ・|六",
+		"| ・ ・ ・ ・ ・ ・ ・ ・ ・|七",
+		"| ・ ・ ・ ・ ・ ・ ・ ・ ・|八",
+		"| ・ ・ ・ ・ 玉 ・ ・ ・ ・|九",
+		"+---------------------------+",
+		"先手の持駒：金　歩十八　",
+		"後手番",
+		"手数----指手---------消費時間--",
+		"   1 ４二玉(51)   ( 0:00/00:00:00)",
+		"   2 ５二金打   ( 0:00/00:00:00)",
+		"   3 ３一玉(42)   ( 0:00/00:00:00)",
+	}
+	board := assertWriteKIFRoundTrip(t, "bod", bod)
+	if got, want := board.InitialPosition(), "4k4/9/4+S4/9/9/9/9/9/4K4 w G18P 1"; got.ToSFEN(1) != want {
+		t.Fatalf("bod initial position: got %s want %s", got.ToSFEN(1), want)
+	}
+}
+
+func assertWriteKIFRoundTrip(t *testing.T, name string, lines []string) *cute.Board {
+	t.Helper()
+	board, err := cute.BoardFromKIF(lines)
+	if err != nil {
+		t.Fatalf("%s: parse: %v", name, err)
+	}
+	meta := cute.PlayersFromKIFLines(lines)
+	annotations := []cute.MoveAnnotation{{Ply: board.MoveCount(), Comments: []string{"eval +100"}}}
+	written, err := cute.WriteKIF(board, meta, annotations)
+	if err != nil {
+		t.Fatalf("%s: write: %v", name, err)
+	}
+	again, err := cute.BoardFromKIF(written)
+	if err != nil {
+		t.Fatalf("%s: reparse: %v\n%s", name, err, strings.Join(written, "\n"))
+	}
+	if got, want := again.InitialPosition(), board.InitialPosition(); got.ToSFEN(1) != want.ToSFEN(1) {
+		t.Fatalf("%s: initial position: got %s want %s", name, got.ToSFEN(1), want.ToSFEN(1))
+	}
+	if got, want := strings.Join(again.Moves(), " "), strings.Join(board.Moves(), " "); got != want {
+		t.Fatalf("%s: moves differ:\ngot  %s\nwant %s", name, got, want)
+	}
+	if again.IsFoulEnd() != board.IsFoulEnd() {
+		t.Fatalf("%s: foul end differs", name)
+	}
+	if got := again.Comments(board.MoveCount()); len(got) == 0 || got[len(got)-1] != "eval +100" {
+		t.Fatalf("%s: annotation lost: %q", name, got)
+	}
+	if got, want := fmt.Sprint(again.Variations()), fmt.Sprint(board.Variations()); got != want {
+		t.Fatalf("%s: variations differ:\ngot  %s\nwant %s", name, got, want)
+	}
+	if players := cute.PlayersFromKIFLines(written); players != meta {
+		t.Fatalf("%s: players: got %+v want %+v", name, players, meta)
+	}
+	return board
+}
+
+func TestBuildGameRecordErrorStage(t *testing.T) {
+	dir := t.TempDir()
+	garbage := filepath.Join(dir, "garbage.kif")
+	if err := os.WriteFile(garbage, []byte("garbage\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path  string
+		stage string
+	}{
+		{filepath.Join(dir, "missing.kif"), cute.StageRead},
+		{garbage, cute.StageParse},
+	}
+	for _, tc := range cases {
+		_, err := cute.BuildGameRecord(context.Background(), tc.path, nil, 1, nil)
+		var buildErr *cute.BuildError
+		if !errors.As(err, &buildErr) {
+			t.Fatalf("%s: expected BuildError, got %v", tc.path, err)
+		}
+		if buildErr.Stage != tc.stage {
+			t.Fatalf("%s: stage = %q, want %q", tc.path, buildErr.Stage, tc.stage)
+		}
+	}
+}
+
+func TestBoardMovesHash(t *testing.T) {
+	lines, err := cute.ReadKIFLines(filepath.Join("testdata", "basic_aigakari.kif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	board, err := cute.BoardFromKIF(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := board.MovesHash()
+	if len(hash) != 16 {
+		t.Fatalf("hash %q, want 16 hex digits", hash)
+	}
+
+	// Headers and comments do not change the hash.
+	relabeled := append([]string{"開始日時：2024/01/01 10:00:00"}, lines...)
+	relabeled = append(relabeled, "*comment")
+	for i, line := range relabeled {
+		if strings.HasPrefix(line, "先手：") {
+			relabeled[i] = "先手：someone"
+		}
+	}
+	if other, err := cute.BoardFromKIF(relabeled); err != nil || other.MovesHash() != hash {
+		t.Fatalf("relabeled: hash %q, err %v, want %q", other.MovesHash(), err, hash)
+	}
+	usen, err := board.ToUSEN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, err := cute.BoardFromUSEN(usen); err != nil || other.MovesHash() != hash {
+		t.Fatalf("from USEN: hash %q, err %v, want %q", other.MovesHash(), err, hash)
+	}
+
+	// One move less is another game.
+	moves := board.Moves()
+	var cut []string
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), fmt.Sprintf("%d ", len(moves))) {
+			break
+		}
+		cut = append(cut, line)
+	}
+	if other, err := cute.BoardFromKIF(cut); err != nil || other.MoveCount() != len(moves)-1 || other.MovesHash() == hash {
+		t.Fatalf("truncated: %d moves, err %v, same hash %v", other.MoveCount(), err, other.MovesHash() == hash)
+	}
+}
@@ -0,0 +1,167 @@
+package cute
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Variation is a branch (変化) of a KIF game. Its first move replaces the
+// move at Ply of the line it branches from.
+type Variation struct {
+	Ply   int      // ply number of Moves[0]
+	Moves []string // USI moves
+	// Comments[i] holds the comment lines ("*" lines, without the "*")
+	// that follow Moves[i].
+	Comments [][]string
+	// Variations branching from this variation, in file order.
+	Variations []Variation
+	// Terminal is the terminal token ending the line (e.g. "投了"), if any.
+	Terminal string
+}
+
+var variationLineRe = regexp.MustCompile(`^\s*変化[：:]\s*(\d+)手`)
+
+// kifLine is a main line or variation while it is being parsed.
+type kifLine struct {
+	parent   *kifLine
+	start    int // ply number of the first move
+	moves    []string
+	dests    []square
+	comments [][]string // index 0: before the first move; i+1: after moves[i]
+	children []*kifLine
+	terminal string
+	ended    bool
+}
+
+func newKIFLine(parent *kifLine, start int) *kifLine {
+	return &kifLine{parent: parent, start: start, comments: make([][]string, 1)}
+}
+
+// contains reports whether the line has a move at ply.
+func (l *kifLine) contains(ply int) bool {
+	return ply >= l.start && ply < l.start+len(l.moves)
+}
+
+// destAt returns the destination of the move at ply as seen from this line,
+// following parents for plies before the line starts.
+func (l *kifLine) destAt(ply int) *square {
+	for cur := l; cur != nil; cur = cur.parent {
+		if ply >= cur.start && ply < cur.start+len(cur.moves) {
+			d := cur.dests[ply-cur.start]
+			return &d
+		}
+	}
+	return nil
+}
+
+func (l *kifLine) toVariation() Variation {
+	v := Variation{Ply: l.start, Moves: l.moves, Comments: l.comments[1:], Terminal: l.terminal}
+	for _, c := range l.children {
+		v.Variations = append(v.Variations, c.toVariation())
+	}
+	return v
+}
+
+// parseKIFTree parses the main line, comments and variations of a KIF.
+// A "変化：N手" section branches from the most recent line that has a move
+// at ply N and started before N, which is how KIF writers list nested
+// variations (depth first).
+func parseKIFTree(lines []string) (*kifLine, error) {
+	main := newKIFLine(nil, 1)
+	all := []*kifLine{main}
+	cur := main
+	for i, line := range lines {
+		trim := strings.TrimSpace(line)
+		if m := variationLineRe.FindStringSubmatch(trim); m != nil {
+			ply, _ := strconv.Atoi(m[1])
+			parent := main
+			for j := len(all) - 1; j >= 0; j-- {
+				if all[j].contains(ply) && (all[j].start < ply || all[j] == main) {
+					parent = all[j]
+					break
+				}
+			}
+			if !parent.contains(ply) {
+				return nil, fmt.Errorf("line %d: variation at ply %d has no parent line", i+1, ply)
+			}
+			cur = newKIFLine(parent, ply)
+			parent.children = append(parent.children, cur)
+			all = append(all, cur)
+			continue
+		}
+		if strings.HasPrefix(trim, "*") {
+			idx := len(cur.moves)
+			cur.comments[idx] = append(cur.comments[idx], strings.TrimPrefix(trim, "*"))
+			continue
+		}
+		if cur.ended {
+			continue
+		}
+		match := moveLineRe.FindStringSubmatch(line)
+		if len(match) == 0 {
+			// Terminal markers like "反則勝ち" may have no clock.
+			if m := terminalLineRe.FindStringSubmatch(line); m != nil && isTerminalMove(strings.TrimSpace(m[2])) {
+				cur.ended = true
+				cur.terminal = strings.TrimSpace(m[2])
+			}
+			continue
+		}
+		moveText := strings.TrimSpace(match[2])
+		if moveText == "" {
+			continue
+		}
+		ply := cur.start + len(cur.moves)
+		move, dest, end, err := parseKIFMoveToken(moveText, cur.destAt(ply-1))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if end {
+			cur.ended = true
+			cur.terminal = moveText
+			continue
+		}
+		cur.moves = append(cur.moves, move)
+		cur.dests = append(cur.dests, *dest)
+		cur.comments = append(cur.comments, nil)
+	}
+	return main, nil
+}
+
+// Comments returns the comment lines following move ply (1-based) of the
+// main line; ply 0 returns the comments before the first move.
+func (b *Board) Comments(ply int) []string {
+	if b == nil || ply < 0 || ply >= len(b.comments) {
+		return nil
+	}
+	return b.comments[ply]
+}
+
+// Variations returns the variations branching from the main line.
+func (b *Board) Variations() []Variation {
+	if b == nil {
+		return nil
+	}
+	return b.variations
+}
+
+// ForEachPly replays the main line and calls fn for every ply from 0 to
+// MoveCount. pos is the position at ply (before move), and move is the
+// move played from it, or "" at the final position. pos must not be kept
+// after fn returns. A non-nil error from fn stops the iteration.
+func (b *Board) ForEachPly(fn func(ply int, pos *Position, move string) error) error {
+	if b == nil {
+		return nil
+	}
+	pos := b.initial.Clone()
+	for i, move := range b.moves {
+		if err := fn(i, &pos, move); err != nil {
+			return err
+		}
+		if err := pos.ApplyMove(move); err != nil {
+			return fmt.Errorf("move %d: %w", i+1, err)
+		}
+	}
+	return fn(len(b.moves), &pos, "")
+}
@@ -0,0 +1,268 @@
+package cute
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MoveAnnotation adds comment lines to a main-line move in WriteKIF.
+type MoveAnnotation struct {
+	Ply      int      // 1-based ply of the annotated move; 0 = before the first move
+	Comments []string // written as "*" lines after the move
+}
+
+const kifClock = "( 0:00/00:00:00)"
+
+var (
+	kifFileDigits = []rune("１２３４５６７８９")
+	kifRankDigits = []rune("一二三四五六七八九")
+)
+
+var kifPieceNames = map[string]string{
+	"P": "歩", "L": "香", "N": "桂", "S": "銀", "G": "金", "B": "角", "R": "飛", "K": "玉",
+}
+
+var kifPromotedNames = map[string]string{
+	"P": "と", "L": "成香", "N": "成桂", "S": "成銀", "B": "馬", "R": "龍",
+}
+
+// WriteKIF renders board as KIF lines: header with meta, the main line
+// with its comments, the terminal move, and all variations. Existing
+// comments are kept and annotations are appended after them. Clock fields
+// are written as zero since Board does not keep times.
+//
+// Variations are listed depth first with later branches before earlier
+// ones, which is the order BoardFromKIF (and KIF viewers) use to find the
+// line a "変化" belongs to.
+func WriteKIF(board *Board, meta KIFPlayers, annotations []MoveAnnotation) ([]string, error) {
+	if board == nil {
+		return nil, fmt.Errorf("board is nil")
+	}
+	extra := make(map[int][]string)
+	for _, a := range annotations {
+		if a.Ply < 0 || a.Ply > len(board.moves) {
+			return nil, fmt.Errorf("annotation ply out of range: %d", a.Ply)
+		}
+		extra[a.Ply] = append(extra[a.Ply], a.Comments...)
+	}
+
+	var out []string
+	out = append(out, kifHeader(board.initial)...)
+	out = append(out, "先手："+formatNameRating(meta.SenteName, meta.SenteRating))
+	out = append(out, "後手："+formatNameRating(meta.GoteName, meta.GoteRating))
+	out = append(out, "手数----指手---------消費時間--")
+
+	comments := make([][]string, len(board.moves)+1)
+	for i := range comments {
+		comments[i] = append(append([]string(nil), board.Comments(i)...), extra[i]...)
+	}
+	pos := board.initial.Clone()
+	lines, err := writeKIFLine(&pos, 1, board.moves, comments, nil, board.terminal, board.variations)
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, lines...)
+	return out, nil
+}
+
+// writeKIFLine renders moves starting at ply start from pos (which is
+// advanced to the end of the line), followed by the variations branching
+// from it. comments[0] precedes the first move and comments[i+1] follows
+// moves[i].
+func writeKIFLine(pos *Position, start int, moves []string, comments [][]string, prevDest *square, terminal string, variations []Variation) ([]string, error) {
+	var out []string
+	addComments := func(idx int) {
+		if idx < len(comments) {
+			for _, c := range comments[idx] {
+				out = append(out, "*"+c)
+			}
+		}
+	}
+
+	// Positions and destinations before each move, for the variations.
+	positions := make([]Position, len(moves))
+	dests := make([]*square, len(moves))
+	addComments(0)
+	for i, move := range moves {
+		positions[i] = pos.Clone()
+		dests[i] = prevDest
+		text, dest, err := kifMoveText(pos, move, prevDest)
+		if err != nil {
+			return nil, fmt.Errorf("ply %d: %w", start+i, err)
+		}
+		if err := pos.ApplyMove(move); err != nil {
+			return nil, fmt.Errorf("ply %d: %w", start+i, err)
+		}
+		out = append(out, formatKIFMoveLine(start+i, text))
+		addComments(i + 1)
+		prevDest = &dest
+	}
+	if terminal != "" {
+		out = append(out, formatKIFMoveLine(start+len(moves), terminal))
+	}
+
+	sorted := make([]Variation, len(variations))
+	copy(sorted, variations)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Ply > sorted[j].Ply })
+	for _, v := range sorted {
+		idx := v.Ply - start
+		if idx < 0 || idx >= len(moves) {
+			return nil, fmt.Errorf("variation at ply %d is outside its parent line", v.Ply)
+		}
+		out = append(out, "", fmt.Sprintf("変化：%d手", v.Ply))
+		branch := positions[idx].Clone()
+		// Variation comments have no slot before the first move.
+		lines, err := writeKIFLine(&branch, v.Ply, v.Moves, append([][]string{nil}, v.Comments...), dests[idx], v.Terminal, v.Variations)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, lines...)
+	}
+	return out, nil
+}
+
+// MoveTexts returns the main-line moves in KIF notation, e.g. "７六歩(77)",
+// without the ▲/△ marks.
+func (b *Board) MoveTexts() ([]string, error) {
+	texts := make([]string, 0, len(b.moves))
+	pos := b.initial.Clone()
+	var prevDest *square
+	for i, move := range b.moves {
+		text, dest, err := kifMoveText(&pos, move, prevDest)
+		if err != nil {
+			return nil, fmt.Errorf("ply %d: %w", i+1, err)
+		}
+		if err := pos.ApplyMove(move); err != nil {
+			return nil, fmt.Errorf("ply %d: %w", i+1, err)
+		}
+		texts = append(texts, text)
+		prevDest = &dest
+	}
+	return texts, nil
+}
+
+func formatKIFMoveLine(ply int, text string) string {
+	return fmt.Sprintf("%4d %s   %s", ply, text, kifClock)
+}
+
+// kifMoveText converts a USI move played from pos to KIF notation, e.g.
+// "７六歩(77)", "同　銀(31)", "４五角打", "２二角成(88)".
+func kifMoveText(pos *Position, move string, prevDest *square) (string, square, error) {
+	parsed, err := parseUSIMove(move)
+	if err != nil {
+		return "", square{}, err
+	}
+	var b strings.Builder
+	if prevDest != nil && *prevDest == parsed.to {
+		b.WriteString("同")
+	} else {
+		b.WriteRune(kifFileDigits[parsed.to.file-1])
+		b.WriteRune(kifRankDigits[parsed.to.rank-1])
+	}
+	if parsed.drop {
+		name, ok := kifPieceNames[parsed.piece]
+		if !ok || parsed.piece == "K" {
+			return "", square{}, fmt.Errorf("invalid drop: %s", move)
+		}
+		b.WriteString(name)
+		b.WriteString("打")
+		return b.String(), parsed.to, nil
+	}
+	piece := pos.pieceAt(parsed.from)
+	if piece == nil {
+		return "", square{}, fmt.Errorf("no piece at source square: %s", move)
+	}
+	name := kifPieceNames[piece.kind]
+	if piece.promoted {
+		name = kifPromotedNames[piece.kind]
+	}
+	if strings.HasPrefix(b.String(), "同") && len([]rune(name)) == 1 {
+		b.WriteString("　")
+	}
+	b.WriteString(name)
+	switch {
+	case parsed.promote:
+		b.WriteString("成")
+	case !piece.promoted && canPromote(piece, parsed.from, parsed.to):
+		b.WriteString("不成")
+	}
+	fmt.Fprintf(&b, "(%d%d)", parsed.from.file, parsed.from.rank)
+	return b.String(), parsed.to, nil
+}
+
+func canPromote(piece *Piece, from, to square) bool {
+	if !isPromotable(piece.kind) {
+		return false
+	}
+	inZone := func(s square) bool {
+		if piece.color == Black {
+			return s.rank <= 3
+		}
+		return s.rank >= 7
+	}
+	return inZone(from) || inZone(to)
+}
+
+// kifHeader returns "手合割：平手" for the standard start position and a
+// board diagram (BOD) otherwise.
+func kifHeader(initial Position) []string {
+	pos := initial.Clone()
+	if pos.ToSFEN(1) == standardSFEN() {
+		return []string{"手合割：平手"}
+	}
+	return boardDiagram(&pos)
+}
+
+// boardPromotedName returns the one-character name used in board diagrams.
+func boardPromotedName(kind string) string {
+	switch kind {
+	case "S":
+		return "全"
+	case "N":
+		return "圭"
+	case "L":
+		return "杏"
+	default:
+		return kifPromotedNames[kind]
+	}
+}
+
+func formatKIFHand(hand map[string]int) string {
+	var parts []string
+	for _, kind := range []string{"R", "B", "G", "S", "N", "L", "P"} {
+		n := hand[kind]
+		if n == 0 {
+			continue
+		}
+		part := kifPieceNames[kind]
+		if n > 1 {
+			part += kanjiNumber(n)
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "なし"
+	}
+	return strings.Join(parts, "　") + "　"
+}
+
+// kanjiNumber formats 1..19 as used in KIF hands ("二", "十", "十八").
+func kanjiNumber(n int) string {
+	var b strings.Builder
+	if n >= 10 {
+		b.WriteString("十")
+		n -= 10
+	}
+	if n > 0 {
+		b.WriteRune(kifRankDigits[n-1])
+	}
+	return b.String()
+}
+
+func formatNameRating(name string, rating int32) string {
+	if rating > 0 {
+		return fmt.Sprintf("%s(%d)", name, rating)
+	}
+	return name
+}
@@ -0,0 +1,245 @@
+package cute
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CheckMove reports why the USI move is not legal in p, or nil when it
+// is. Besides what ApplyMove checks, the piece must be able to reach the
+// square, promotion must happen in the promotion zone and is compulsory
+// where the piece could not move again, drops must not be 二歩, on a
+// square the piece could not leave or 打ち歩詰め, and the move must not
+// leave the mover's king in check. Repetition (千日手) is not checked.
+// p is not modified.
+func (p *Position) CheckMove(move string) error {
+	return p.checkMove(move, true)
+}
+
+// LegalMoves returns the legal moves of the side to move in USI form.
+func (p *Position) LegalMoves() []string {
+	var legal []string
+	for _, move := range p.candidateMoves() {
+		if p.checkMove(move, true) == nil {
+			legal = append(legal, move)
+		}
+	}
+	return legal
+}
+
+// MoveInfo describes a move in the position it is played from.
+type MoveInfo struct {
+	Piece    string // kind of the moved or dropped piece ("P", "L", ..., "K")
+	Promoted bool   // the piece was promoted before the move
+	Drop     bool
+	Promote  bool   // the move promotes the piece
+	Captured string // kind of the captured piece, "" without a capture
+}
+
+// DescribeMove returns the piece the USI move moves and what the move does
+// with it. The move is not checked for legality beyond there being a piece
+// of the side to move on its from square; p is not modified.
+func (p *Position) DescribeMove(move string) (MoveInfo, error) {
+	parsed, err := parseUSIMove(move)
+	if err != nil {
+		return MoveInfo{}, err
+	}
+	if parsed.drop {
+		return MoveInfo{Piece: parsed.piece, Drop: true}, nil
+	}
+	piece := p.pieceAt(parsed.from)
+	if piece == nil || piece.color != p.turn {
+		return MoveInfo{}, fmt.Errorf("no piece to move at %s", formatSquare(parsed.from))
+	}
+	// Moves of promoted pieces from KIF (成銀, 成桂) carry a "+" too.
+	info := MoveInfo{Piece: piece.kind, Promoted: piece.promoted, Promote: parsed.promote && !piece.promoted}
+	if captured := p.pieceAt(parsed.to); captured != nil {
+		info.Captured = captured.kind
+	}
+	return info, nil
+}
+
+// FirstIllegalPly returns the first main-line move (1-based ply) that is
+// not legal (see Position.CheckMove), or 0 when every move is legal. For
+// a game ending with a foul it locates the move that broke the rules.
+func (b *Board) FirstIllegalPly() int {
+	ply, _ := b.firstIllegalMove()
+	return ply
+}
+
+// firstIllegalMove is FirstIllegalPly with the reason.
+func (b *Board) firstIllegalMove() (int, error) {
+	if b == nil {
+		return 0, nil
+	}
+	pos := b.initial.Clone()
+	for i, move := range b.moves {
+		if err := pos.CheckMove(move); err != nil {
+			return i + 1, err
+		}
+		if err := pos.ApplyMove(move); err != nil {
+			return i + 1, err
+		}
+	}
+	return 0, nil
+}
+
+// checkMove is CheckMove; pawnDropMate enables the 打ち歩詰め check, which
+// is off when looking for escapes from a pawn drop check so that the
+// search does not recurse.
+func (p *Position) checkMove(move string, pawnDropMate bool) error {
+	parsed, err := parseUSIMove(move)
+	if err != nil {
+		return err
+	}
+	if parsed.drop {
+		if err := p.checkDrop(parsed); err != nil {
+			return err
+		}
+	} else if err := p.checkBoardMove(parsed); err != nil {
+		return err
+	}
+	next := p.Clone()
+	if err := next.ApplyMove(move); err != nil {
+		return err
+	}
+	if next.IsInCheck(p.turn) {
+		return errors.New("leaves the king in check")
+	}
+	if pawnDropMate && parsed.drop && parsed.piece == "P" && next.IsInCheck(next.turn) && !next.hasEscape() {
+		return errors.New("pawn drop mate (打ち歩詰め)")
+	}
+	return nil
+}
+
+func (p *Position) checkBoardMove(move usiMove) error {
+	piece := p.pieceAt(move.from)
+	if piece == nil {
+		return fmt.Errorf("no piece at %d%c", move.from.file, rankToLetter(move.from.rank))
+	}
+	if piece.color != p.turn {
+		return errors.New("moving opponent piece")
+	}
+	if captured := p.pieceAt(move.to); captured != nil && captured.color == p.turn {
+		return errors.New("capturing own piece")
+	}
+	if !p.canAttackSquare(piece, move.from, move.to) {
+		return fmt.Errorf("piece cannot move from %d%c to %d%c", move.from.file, rankToLetter(move.from.rank), move.to.file, rankToLetter(move.to.rank))
+	}
+	// Moves of promoted pieces converted from KIF may carry the marker
+	// too; it is a no-op for them, as in ApplyMove.
+	if move.promote && !piece.promoted {
+		switch {
+		case piece.kind == "K" || piece.kind == "G":
+			return errors.New("cannot promote king or gold")
+		case !inPromotionZone(move.from, p.turn) && !inPromotionZone(move.to, p.turn):
+			return errors.New("promotion outside the promotion zone")
+		}
+	} else if !piece.promoted && deadSquare(piece.kind, move.to, p.turn) {
+		return errors.New("piece must promote")
+	}
+	return nil
+}
+
+func (p *Position) checkDrop(move usiMove) error {
+	switch move.piece {
+	case "P", "L", "N", "S", "G", "B", "R":
+	default:
+		return fmt.Errorf("cannot drop %s", move.piece)
+	}
+	if p.hands[p.turn][move.piece] == 0 {
+		return fmt.Errorf("no %s in hand", move.piece)
+	}
+	if p.pieceAt(move.to) != nil {
+		return errors.New("drop destination occupied")
+	}
+	if deadSquare(move.piece, move.to, p.turn) {
+		return errors.New("dropped piece could not move")
+	}
+	if move.piece == "P" {
+		for rank := 1; rank <= 9; rank++ {
+			piece := p.pieceAt(square{file: move.to.file, rank: rank})
+			if piece != nil && piece.kind == "P" && !piece.promoted && piece.color == p.turn {
+				return errors.New("two pawns on a file (二歩)")
+			}
+		}
+	}
+	return nil
+}
+
+// hasEscape reports whether the side to move has a legal move, without
+// the 打ち歩詰め rule.
+func (p *Position) hasEscape() bool {
+	for _, move := range p.candidateMoves() {
+		if p.checkMove(move, false) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// candidateMoves returns the moves of the side to move that the pieces
+// can make, legal or not: every reachable square (with and, for
+// unpromoted pieces, without promotion) and every drop on an empty square.
+func (p *Position) candidateMoves() []string {
+	var moves []string
+	for rank := 1; rank <= 9; rank++ {
+		for file := 1; file <= 9; file++ {
+			piece := p.board[rank-1][file-1]
+			if piece == nil || piece.color != p.turn {
+				continue
+			}
+			from := square{file: file, rank: rank}
+			for toRank := 1; toRank <= 9; toRank++ {
+				for toFile := 1; toFile <= 9; toFile++ {
+					to := square{file: toFile, rank: toRank}
+					if !p.canAttackSquare(piece, from, to) {
+						continue
+					}
+					move := fmt.Sprintf("%d%c%d%c", file, rankToLetter(rank), toFile, rankToLetter(toRank))
+					moves = append(moves, move)
+					if !piece.promoted {
+						moves = append(moves, move+"+")
+					}
+				}
+			}
+		}
+	}
+	for _, kind := range []string{"P", "L", "N", "S", "G", "B", "R"} {
+		if p.hands[p.turn][kind] == 0 {
+			continue
+		}
+		for rank := 1; rank <= 9; rank++ {
+			for file := 1; file <= 9; file++ {
+				if p.board[rank-1][file-1] == nil {
+					moves = append(moves, fmt.Sprintf("%s*%d%c", kind, file, rankToLetter(rank)))
+				}
+			}
+		}
+	}
+	return moves
+}
+
+// inPromotionZone reports whether s is in the three far ranks of color.
+func inPromotionZone(s square, color Color) bool {
+	if color == Black {
+		return s.rank <= 3
+	}
+	return s.rank >= 7
+}
+
+// deadSquare reports whether an unpromoted piece of kind and color on s
+// could never move again.
+func deadSquare(kind string, s square, color Color) bool {
+	far := s.rank // ranks from the far side, 1-based
+	if color == White {
+		far = 10 - s.rank
+	}
+	switch kind {
+	case "P", "L":
+		return far == 1
+	case "N":
+		return far <= 2
+	}
+	return false
+}
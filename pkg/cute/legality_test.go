@@ -296,3 +296,114 @@ func TestIsLegalPosition01_FoulKIF(t *testing.T) {
 	path := filepath.Join("testdata", "37983487.kif")
 	isLegalPosition_FoulKIF(path, t)
 }
+
+// TestLegalMoves_InitialPosition verifies the 30 legal moves of the
+// initial position.
+func TestLegalMoves_InitialPosition(t *testing.T) {
+	board, err := cute.LoadBoardFromKIF(filepath.Join("testdata", "initial.kif"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	pos := board.InitialPosition()
+	if got := len(pos.LegalMoves()); got != 30 {
+		t.Fatalf("got %d legal moves, want 30", got)
+	}
+}
+
+// TestCheckMove verifies moves that ApplyMove accepts but the rules do not.
+func TestCheckMove(t *testing.T) {
+	board, err := cute.LoadBoardFromKIF(filepath.Join("testdata", "initial.kif"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	pos := board.InitialPosition()
+	// Both sides trade a pawn on the 2 file; White is to move with a
+	// pawn in hand and its own pawn on 8e.
+	for _, move := range []string{"2g2f", "8c8d", "2f2e", "8d8e", "2e2d", "2c2d", "2h2d"} {
+		if err := pos.ApplyMove(move); err != nil {
+			t.Fatalf("%s: %v", move, err)
+		}
+	}
+	for _, tc := range []struct {
+		move  string
+		legal bool
+	}{
+		{"P*2c", true},
+		{"P*8c", false},  // 二歩
+		{"P*1i", false},  // pawn that could not move
+		{"G*5e", false},  // no gold in hand
+		{"8e8g", false},  // pawn cannot move two squares
+		{"8b8c+", false}, // promotion outside the promotion zone
+		{"3a3b", true},
+		{"5a4b", true},
+		{"8e8f", true},
+	} {
+		err := pos.CheckMove(tc.move)
+		if (err == nil) != tc.legal {
+			t.Errorf("%s: got %v, want legal %v", tc.move, err, tc.legal)
+		}
+	}
+}
+
+// TestFirstIllegalPly verifies that the foul of a game is located.
+func TestFirstIllegalPly(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		want int
+	}{
+		{"basic_aigakari.kif", 0},
+		{"real.kif", 0},
+		{"36589641.kif", 101}, // 反則勝ち: the last move is the foul
+		{"37983487.kif", 18},  // 反則負け: the move before the king capture
+	} {
+		board, err := cute.LoadBoardFromKIF(filepath.Join("testdata", tc.file))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+		if got := board.FirstIllegalPly(); got != tc.want {
+			t.Errorf("%s: got ply %d, want %d", tc.file, got, tc.want)
+		}
+	}
+}
+
+// TestDescribeMove verifies the piece, drop, promotion and capture of moves.
+func TestDescribeMove(t *testing.T) {
+	board, err := cute.LoadBoardFromKIF(filepath.Join("testdata", "initial.kif"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	pos := board.InitialPosition()
+	// White is to move with a pawn in hand; Black's rook is on 2d.
+	for _, move := range []string{"2g2f", "8c8d", "2f2e", "8d8e", "2e2d", "2c2d", "2h2d"} {
+		if err := pos.ApplyMove(move); err != nil {
+			t.Fatalf("%s: %v", move, err)
+		}
+	}
+	for _, tc := range []struct {
+		move    string
+		want    cute.MoveInfo
+		wantErr bool
+	}{
+		{move: "P*2c", want: cute.MoveInfo{Piece: "P", Drop: true}},
+		{move: "2d2c+", want: cute.MoveInfo{Piece: "R", Promote: true, Captured: "P"}},
+		{move: "9c9d", want: cute.MoveInfo{Piece: "P"}},
+		{move: "2c2b", want: cute.MoveInfo{Piece: "R", Promoted: true, Captured: "B"}},
+		{move: "9d9e", want: cute.MoveInfo{Piece: "P"}},
+		{move: "2b2a+", want: cute.MoveInfo{Piece: "R", Promoted: true, Captured: "N"}}, // "+" of an already promoted piece
+		{move: "5g5f", wantErr: true},                                                   // Black's pawn with White to move
+	} {
+		got, err := pos.DescribeMove(tc.move)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", tc.move, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s: got %+v, %v, want %+v", tc.move, got, err, tc.want)
+		}
+		if err := pos.ApplyMove(tc.move); err != nil {
+			t.Fatalf("%s: %v", tc.move, err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package cute
+
+// MateCP is the magnitude EffectiveCP gives a mate score ("mate 0"): far
+// beyond any cp eval, so that thresholds and clamps treat a mate as the
+// strongest possible eval without special cases.
+const MateCP = 32000
+
+// EffectiveCP returns the eval as a cp value from sente's perspective. A
+// cp score is its value; a mate score is ±(MateCP - n) for a mate in n
+// plies, signed for the mating side (see mateSide for "mate 0"), so that
+// nearer mates rank higher. Callers clamp it to their own eval range.
+func (e MoveEval) EffectiveCP() int {
+	if e.ScoreType != "mate" {
+		return int(e.ScoreValue)
+	}
+	n := int(e.ScoreValue)
+	cp := MateCP - max(n, -n)
+	if mateSide(e) == "gote" {
+		return -cp
+	}
+	return cp
+}
@@ -0,0 +1,30 @@
+package cute_test
+
+import (
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestEffectiveCP(t *testing.T) {
+	for _, tc := range []struct {
+		eval cute.MoveEval
+		want int
+	}{
+		{cute.MoveEval{Ply: 10, ScoreType: "cp", ScoreValue: -250}, -250},
+		{cute.MoveEval{Ply: 10, ScoreType: "mate", ScoreValue: 5}, cute.MateCP - 5},
+		{cute.MoveEval{Ply: 10, ScoreType: "mate", ScoreValue: -3}, -(cute.MateCP - 3)},
+		{cute.MoveEval{Ply: 11, ScoreType: "mate", ScoreValue: 0}, cute.MateCP}, // gote to move is mated
+		{cute.MoveEval{Ply: 12, ScoreType: "mate", ScoreValue: 0}, -cute.MateCP},
+	} {
+		if got := tc.eval.EffectiveCP(); got != tc.want {
+			t.Errorf("%+v: got %d, want %d", tc.eval, got, tc.want)
+		}
+	}
+	// A nearer mate ranks higher, and any mate beyond any cp eval.
+	near := cute.MoveEval{Ply: 1, ScoreType: "mate", ScoreValue: 1}
+	far := cute.MoveEval{Ply: 1, ScoreType: "mate", ScoreValue: 9}
+	if near.EffectiveCP() <= far.EffectiveCP() || far.EffectiveCP() <= 30000 {
+		t.Errorf("mate 1 = %d, mate 9 = %d", near.EffectiveCP(), far.EffectiveCP())
+	}
+}
@@ -0,0 +1,75 @@
+package cute
+
+// FindMate searches for a forced mate by the side to move within maxPly
+// plies (counting both sides' moves, so a mate in 3 is maxPly 3). Only
+// checking moves are tried for the attacker, and every legal reply for the
+// defender, as in a tsume problem. The shortest mate is returned as its
+// main line in USI form, attacker moves and the defender's longest
+// resistance alternating, ending with the mating move; ok is false when
+// there is no mate within maxPly. Repetition is not considered, and the
+// search grows quickly with maxPly: keep it to a few plies.
+func FindMate(pos Position, maxPly int) (line []string, ok bool) {
+	for depth := 1; depth <= maxPly; depth += 2 {
+		if line, ok := pos.mateAttack(depth); ok {
+			return line, true
+		}
+	}
+	return nil, false
+}
+
+// IsForcedMate reports whether the side to move is checkmated, or is in
+// check and gets mated within maxPly plies whatever it replies (the
+// defender's side of FindMate). IsForcedMate(pos, 0) is a plain checkmate
+// test.
+func IsForcedMate(pos Position, maxPly int) bool {
+	_, ok := pos.mateDefend(maxPly)
+	return ok
+}
+
+// mateAttack returns a mate by the side to move within depth plies.
+func (p *Position) mateAttack(depth int) ([]string, bool) {
+	if depth < 1 {
+		return nil, false
+	}
+	defender := p.turn ^ 1
+	for _, move := range p.LegalMoves() {
+		next := p.Clone()
+		if err := next.ApplyMove(move); err != nil || !next.IsInCheck(defender) {
+			continue
+		}
+		if line, ok := next.mateDefend(depth - 1); ok {
+			return append([]string{move}, line...), true
+		}
+	}
+	return nil, false
+}
+
+// mateDefend reports whether the side to move, in check, is mated within
+// depth plies whatever it plays, with the longest line it can hold out.
+func (p *Position) mateDefend(depth int) ([]string, bool) {
+	if !p.IsInCheck(p.turn) {
+		return nil, false
+	}
+	moves := p.LegalMoves()
+	if len(moves) == 0 {
+		return nil, true
+	}
+	if depth < 2 {
+		return nil, false
+	}
+	var longest []string
+	for _, move := range moves {
+		next := p.Clone()
+		if err := next.ApplyMove(move); err != nil {
+			continue
+		}
+		line, ok := next.mateAttack(depth - 1)
+		if !ok {
+			return nil, false
+		}
+		if longest == nil || len(line)+1 > len(longest) {
+			longest = append([]string{move}, line...)
+		}
+	}
+	return longest, true
+}
@@ -0,0 +1,64 @@
+package cute_test
+
+import (
+	"slices"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestFindMate(t *testing.T) {
+	// 頭金: a gold dropped in front of the king, backed by a pawn.
+	pos, err := cute.PositionFromSFEN("4k4/9/4P4/9/9/9/9/9/4K4 b G 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, ok := cute.FindMate(pos, 1); !ok || !slices.Equal(line, []string{"G*5b"}) {
+		t.Errorf("mate in 1: got %v %v", line, ok)
+	}
+
+	// Mate in 3: the silver drop drives the king to 1b, where the gold mates.
+	pos, err = cute.PositionFromSFEN("8k/9/6G2/6G2/9/9/9/9/K8 b S 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, ok := cute.FindMate(pos, 1); ok {
+		t.Errorf("found a mate in 1: %v", line)
+	}
+	line, ok := cute.FindMate(pos, 3)
+	if !ok || len(line) != 3 {
+		t.Fatalf("mate in 3: got %v %v", line, ok)
+	}
+	end := pos.Clone()
+	for i, move := range line {
+		if err := end.ApplyMove(move); err != nil {
+			t.Fatalf("line %v: %v", line, err)
+		}
+		if i == 0 && !cute.IsForcedMate(end, 2) {
+			t.Errorf("the defender escapes after %s", move)
+		}
+	}
+	if !cute.IsForcedMate(end, 0) {
+		t.Errorf("line %v does not end in checkmate: %s", line, end.ToSFEN(1))
+	}
+	if cute.IsForcedMate(pos, 3) {
+		t.Error("IsForcedMate holds for the attacker to move")
+	}
+
+	// A pawn drop would mate (打ち歩詰め), which is not allowed.
+	pos, err = cute.PositionFromSFEN("8k/6S2/7G1/9/9/9/9/9/K8 b P 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, ok := cute.FindMate(pos, 1); ok {
+		t.Errorf("got mate %v, but P*1b is 打ち歩詰め", line)
+	}
+
+	initial, err := cute.PositionFromSFEN("lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, ok := cute.FindMate(initial, 3); ok {
+		t.Errorf("initial position: got mate %v", line)
+	}
+}
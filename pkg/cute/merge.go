@@ -0,0 +1,230 @@
+package cute
+
+import (
+	"fmt"
+
+	"github.com/xitongsys/parquet-go-source/local"
+)
+
+// MergePolicy decides which record is kept when a game_id is found more
+// than once while merging.
+type MergePolicy string
+
+const (
+	// MergeKeepFirst keeps the first record in source order.
+	MergeKeepFirst MergePolicy = "keep-first"
+	// MergeKeepMostMoves keeps the record with the highest move_count,
+	// then the most move_evals (e.g. a complete re-run over a record cut
+	// short); ties keep the first.
+	MergeKeepMostMoves MergePolicy = "keep-most-moves"
+)
+
+// ParseMergePolicy parses a MergePolicy name; "" is MergeKeepFirst.
+func ParseMergePolicy(s string) (MergePolicy, error) {
+	switch p := MergePolicy(s); p {
+	case "":
+		return MergeKeepFirst, nil
+	case MergeKeepFirst, MergeKeepMostMoves:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown merge policy %q (want %s or %s)", s, MergeKeepFirst, MergeKeepMostMoves)
+}
+
+// MergeOptions configures MergeParquet.
+type MergeOptions struct {
+	Policy   MergePolicy // "" = MergeKeepFirst
+	Format   string      // "parquet" (default) or "arrow"
+	Parallel int64       // reader/writer parallelism; 0 = 1
+	// Skip, when set, leaves out the records of source it returns true
+	// for before the policy sees them (e.g. stale copies of games
+	// evaluated again). They count as dropped.
+	Skip func(source string, record GameRecord) bool
+	// CompactEvals writes a parquet target with packed MoveEvals (see
+	// ParquetWriteOptions).
+	CompactEvals bool
+	// PlayerIndex is passed to ParquetWriteOptions for a parquet target.
+	PlayerIndex string
+	// Dedup also leaves out games whose players and moves equal those of
+	// a game already written (see GameRecord.DedupKey), keeping the first.
+	Dedup bool
+}
+
+// MergeStats counts the games of a merge.
+type MergeStats struct {
+	Kept    int // games written
+	Dropped int // duplicate records left out
+	// Deduplicated counts the games left out by Dedup: the same game
+	// under another game_id.
+	Deduplicated int
+}
+
+// MergeParquet writes the games of the GameRecord parquet files sources to
+// target, keeping one record per game_id as chosen by opts.Policy, and
+// combines their engine metadata. Every source is checked to be a
+// GameRecord file with evals from the same perspective and draw score
+// before anything is written. Records are written in source order.
+func MergeParquet(target string, sources []string, opts MergeOptions) (MergeStats, error) {
+	policy, err := ParseMergePolicy(string(opts.Policy))
+	if err != nil {
+		return MergeStats{}, err
+	}
+	parallel := max(opts.Parallel, 1)
+	var meta ParquetMeta
+	for i, src := range sources {
+		if err := checkGameRecordFile(src); err != nil {
+			return MergeStats{}, fmt.Errorf("%s: %w", src, err)
+		}
+		m, err := ReadParquetMeta(src)
+		if err != nil {
+			return MergeStats{}, err
+		}
+		meta.Engines = MergeEngines(meta.Engines, m.Engines)
+		if p := m.ScorePerspective.normal(); meta.ScorePerspective == "" {
+			meta.ScorePerspective = p
+		} else if p != meta.ScorePerspective {
+			return MergeStats{}, fmt.Errorf("%s: evals are from the %s perspective, those of %s from the %s one", src, p, sources[0], meta.ScorePerspective)
+		}
+		if i == 0 {
+			meta.DrawScore = m.DrawScore
+		} else if err := m.CheckDrawScore(meta.DrawScore); err != nil {
+			return MergeStats{}, fmt.Errorf("%s: %w as those of %s", src, err, sources[0])
+		}
+	}
+
+	// keep reports whether a row is the one to write for its game.
+	type rowRef struct{ source, row int }
+	var keep func(id string, ref rowRef) bool
+	switch policy {
+	case MergeKeepFirst:
+		seen := make(map[string]struct{})
+		keep = func(id string, _ rowRef) bool {
+			if _, ok := seen[id]; ok {
+				return false
+			}
+			seen[id] = struct{}{}
+			return true
+		}
+	case MergeKeepMostMoves:
+		type candidate struct {
+			ref          rowRef
+			moves, evals int
+		}
+		best := make(map[string]candidate)
+		for i, src := range sources {
+			err := readGameRecords(src, parallel, func(row int, record GameRecord) error {
+				if opts.Skip != nil && opts.Skip(src, record) {
+					return nil
+				}
+				c := candidate{rowRef{i, row}, int(record.MoveCount), len(record.MoveEvals)}
+				if have, ok := best[record.GameID]; !ok || c.moves > have.moves || c.moves == have.moves && c.evals > have.evals {
+					best[record.GameID] = c
+				}
+				return nil
+			})
+			if err != nil {
+				return MergeStats{}, fmt.Errorf("%s: %w", src, err)
+			}
+		}
+		keep = func(id string, ref rowRef) bool {
+			return best[id].ref == ref
+		}
+	}
+
+	records := make(chan GameRecord, parallel)
+	writeErr := make(chan error, 1)
+	go func() {
+		metaFunc := func() ParquetMeta { return meta }
+		var err error
+		if opts.Format == "arrow" {
+			err = WriteArrowIPCMeta(target, records, metaFunc)
+		} else {
+			err = WriteParquetWith(target, records, ParquetWriteOptions{Parallel: parallel, Meta: metaFunc, CompactEvals: opts.CompactEvals, PlayerIndex: opts.PlayerIndex})
+		}
+		// Keep the reader unblocked if the writer fails early.
+		for range records {
+		}
+		writeErr <- err
+	}()
+
+	var stats MergeStats
+	var readErr error
+	games := make(map[string]struct{})
+	for i, src := range sources {
+		readErr = readGameRecords(src, parallel, func(row int, record GameRecord) error {
+			if opts.Skip != nil && opts.Skip(src, record) || !keep(record.GameID, rowRef{i, row}) {
+				stats.Dropped++
+				return nil
+			}
+			if key := record.DedupKey(); opts.Dedup && key != "" {
+				if _, ok := games[key]; ok {
+					stats.Deduplicated++
+					return nil
+				}
+				games[key] = struct{}{}
+			}
+			records <- record
+			stats.Kept++
+			return nil
+		})
+		if readErr != nil {
+			readErr = fmt.Errorf("%s: %w", src, readErr)
+			break
+		}
+	}
+	close(records)
+	if err := <-writeErr; err != nil {
+		return stats, err
+	}
+	return stats, readErr
+}
+
+// checkGameRecordFile opens path as a GameRecord parquet file, which
+// validates its schema.
+func checkGameRecordFile(path string) error {
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return err
+	}
+	defer fileReader.Close()
+	r, err := NewGameRecordReader(fileReader, 1)
+	if err != nil {
+		return err
+	}
+	r.ReadStop()
+	return nil
+}
+
+// readGameRecords calls fn with each record of a GameRecord parquet file
+// and its row number.
+func readGameRecords(path string, parallel int64, fn func(row int, record GameRecord) error) error {
+	return readGameRecordsColumns(path, parallel, nil, fn)
+}
+
+// readGameRecordsColumns is readGameRecords reading only cols.
+func readGameRecordsColumns(path string, parallel int64, cols []string, fn func(row int, record GameRecord) error) error {
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return err
+	}
+	defer fileReader.Close()
+	r, err := NewGameRecordReaderColumns(fileReader, parallel, cols)
+	if err != nil {
+		return err
+	}
+	defer r.ReadStop()
+
+	rows := int(r.GetNumRows())
+	batchSize := 1024
+	for offset := 0; offset < rows; offset += batchSize {
+		batch := make([]GameRecord, min(batchSize, rows-offset))
+		if err := r.Read(&batch); err != nil {
+			return err
+		}
+		for i, record := range batch {
+			if err := fn(offset+i, record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package cute_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	cute "cute/pkg/cute"
+
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+func writeTestParquet[T any](t *testing.T, path string, rows []T) {
+	t.Helper()
+	writeTestParquetMeta(t, path, rows, nil)
+}
+
+// writeTestParquetMeta is writeTestParquet that also stores meta (when not
+// nil) in the footer, as graph does.
+func writeTestParquetMeta[T any](t *testing.T, path string, rows []T, meta *cute.ParquetMeta) {
+	t.Helper()
+	fw, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err := writer.NewParquetWriter(fw, new(T), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		if err := pw.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if meta != nil {
+		data, err := json.Marshal(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := string(data)
+		pw.Footer.KeyValueMetadata = append(pw.Footer.KeyValueMetadata, &parquet.KeyValue{Key: "cute.meta", Value: &value})
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	fw.Close()
+}
+
+// readArrowGames returns "game_id:move_count" for each row of an Arrow
+// GameRecord file.
+func readArrowGames(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := ipc.NewFileReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var games []string
+	for i := 0; i < r.NumRecords(); i++ {
+		rec, err := r.Record(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := rec.Column(0).(*array.String)
+		moves := rec.Column(7).(*array.Int32)
+		for j := 0; j < int(rec.NumRows()); j++ {
+			games = append(games, fmt.Sprintf("%s:%d", ids.Value(j), moves.Value(j)))
+		}
+	}
+	return games
+}
+
+func TestMergeParquet(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.parquet")
+	b := filepath.Join(dir, "b.parquet")
+	writeTestParquet(t, a, []cute.GameRecord{
+		{GameID: "1", MoveCount: 1, MoveEvals: []cute.MoveEval{}},
+		{GameID: "2", MoveCount: 3, MoveEvals: []cute.MoveEval{}},
+	})
+	writeTestParquet(t, b, []cute.GameRecord{
+		{GameID: "1", MoveCount: 2, MoveEvals: []cute.MoveEval{}},
+		{GameID: "3", MoveCount: 1, MoveEvals: []cute.MoveEval{}},
+		{GameID: "2", MoveCount: 3, MoveEvals: []cute.MoveEval{{Ply: 1, ScoreType: "cp"}}},
+	})
+
+	tests := []struct {
+		policy cute.MergePolicy
+		want   []string
+	}{
+		{cute.MergeKeepFirst, []string{"1:1", "2:3", "3:1"}},
+		{cute.MergeKeepMostMoves, []string{"1:2", "3:1", "2:3"}},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(dir, string(tt.policy)+".arrow")
+		stats, err := cute.MergeParquet(out, []string{a, b}, cute.MergeOptions{Policy: tt.policy, Format: "arrow"})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.policy, err)
+		}
+		if stats != (cute.MergeStats{Kept: 3, Dropped: 2}) {
+			t.Fatalf("%s: stats %+v", tt.policy, stats)
+		}
+		if got := readArrowGames(t, out); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: got %v, want %v", tt.policy, got, tt.want)
+		}
+	}
+
+	// Skipped records do not take part in the policy.
+	out := filepath.Join(dir, "skip.arrow")
+	skip := func(source string, record cute.GameRecord) bool { return source == a && record.GameID == "1" }
+	stats, err := cute.MergeParquet(out, []string{a, b}, cute.MergeOptions{Format: "arrow", Skip: skip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"2:3", "1:2", "3:1"}; stats != (cute.MergeStats{Kept: 3, Dropped: 2}) || !reflect.DeepEqual(readArrowGames(t, out), want) {
+		t.Fatalf("skip: stats %+v, got %v, want %v", stats, readArrowGames(t, out), want)
+	}
+
+	// Dedup drops the same game under other IDs; unknown hashes never match.
+	c := filepath.Join(dir, "c.parquet")
+	writeTestParquet(t, c, []cute.GameRecord{
+		{GameID: "4", SenteName: "x", GoteName: "y", MovesHash: "h", MoveCount: 1, MoveEvals: []cute.MoveEval{}},
+		{GameID: "5", SenteName: "x", GoteName: "y", MovesHash: "h", MoveCount: 2, MoveEvals: []cute.MoveEval{}},
+		{GameID: "6", SenteName: "y", GoteName: "x", MovesHash: "h", MoveCount: 1, MoveEvals: []cute.MoveEval{}},
+	})
+	out = filepath.Join(dir, "dedup.arrow")
+	stats, err = cute.MergeParquet(out, []string{a, c}, cute.MergeOptions{Format: "arrow", Dedup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1:1", "2:3", "4:1", "6:1"}; stats != (cute.MergeStats{Kept: 4, Deduplicated: 1}) || !reflect.DeepEqual(readArrowGames(t, out), want) {
+		t.Fatalf("dedup: stats %+v, got %v, want %v", stats, readArrowGames(t, out), want)
+	}
+
+	// A parquet that is not a GameRecord file is rejected up front.
+	type statsRow struct {
+		Name string `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	}
+	other := filepath.Join(dir, "stats.parquet")
+	writeTestParquet(t, other, []statsRow{{Name: "x"}})
+	out = filepath.Join(dir, "bad.arrow")
+	if _, err := cute.MergeParquet(out, []string{a, other}, cute.MergeOptions{Format: "arrow"}); err == nil || !strings.Contains(err.Error(), "no game_id column") {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("output written despite invalid input: %v", err)
+	}
+
+	type badType struct {
+		GameID    int32 `parquet:"name=game_id, type=INT32"`
+		MoveCount int32 `parquet:"name=move_count, type=INT32"`
+	}
+	writeTestParquet(t, other, []badType{{GameID: 1}})
+	if _, err := cute.MergeParquet(out, []string{other}, cute.MergeOptions{Format: "arrow"}); err == nil || !strings.Contains(err.Error(), "column game_id is INT32") {
+		t.Fatalf("err = %v", err)
+	}
+
+	// Evals oriented otherwise are not mixed in; files that predate the
+	// setting are sente's.
+	turned := filepath.Join(dir, "turned.parquet")
+	writeTestParquetMeta(t, turned, []cute.GameRecord{{GameID: "7", MoveEvals: []cute.MoveEval{}}}, &cute.ParquetMeta{ScorePerspective: cute.PerspectiveSideToMove})
+	if _, err := cute.MergeParquet(out, []string{a, turned}, cute.MergeOptions{Format: "arrow"}); err == nil || !strings.Contains(err.Error(), "side_to_move perspective") {
+		t.Fatalf("err = %v", err)
+	}
+	sente := filepath.Join(dir, "sente.parquet")
+	writeTestParquetMeta(t, sente, []cute.GameRecord{{GameID: "7", MoveEvals: []cute.MoveEval{}}}, &cute.ParquetMeta{ScorePerspective: cute.PerspectiveSente})
+	if _, err := cute.MergeParquet(out, []string{a, sente}, cute.MergeOptions{Format: "arrow"}); err != nil {
+		t.Fatalf("sente: %v", err)
+	}
+
+	// Nor are evals made with another draw score.
+	drawScore := 0
+	drawn := filepath.Join(dir, "drawn.parquet")
+	writeTestParquetMeta(t, drawn, []cute.GameRecord{{GameID: "8", MoveEvals: []cute.MoveEval{}}}, &cute.ParquetMeta{DrawScore: &drawScore})
+	if _, err := cute.MergeParquet(out, []string{a, drawn}, cute.MergeOptions{Format: "arrow"}); err == nil || !strings.Contains(err.Error(), "draw score 0") {
+		t.Fatalf("err = %v", err)
+	}
+}
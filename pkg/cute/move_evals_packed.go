@@ -0,0 +1,171 @@
+package cute
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// packedEvalsColumn holds the MoveEvals of files written with
+// ParquetWriteOptions.CompactEvals, in place of the move_evals LIST.
+const packedEvalsColumn = "move_evals_packed"
+
+// packedEvalsVersion is the first byte of PackMoveEvals output.
+const packedEvalsVersion = 1
+
+// Score kinds of PackMoveEvals; any other ScoreType is stored as text.
+const (
+	packedKindCP = iota
+	packedKindMate
+	packedKindOther
+)
+
+// PackMoveEvals encodes evals compactly: a version byte, then per eval
+// the ply delta and score kind in one uvarint (delta<<2 | kind), the
+// score as a zigzag varint (for cp, the change from the previous cp
+// score), and the depth as a zigzag varint change from the previous
+// depth. A ScoreType other than cp or mate follows the kind as a
+// length-prefixed string. A typical game packs in 3-4 bytes per ply.
+func PackMoveEvals(evals []MoveEval) []byte {
+	buf := make([]byte, 0, 1+4*len(evals))
+	buf = append(buf, packedEvalsVersion)
+	var ply, cp, depth int64
+	for _, eval := range evals {
+		kind := packedKindOther
+		switch eval.ScoreType {
+		case "cp":
+			kind = packedKindCP
+		case "mate":
+			kind = packedKindMate
+		}
+		// Plies are ascending in records; a decrease is stored as a
+		// zigzag delta to keep any order exact.
+		buf = binary.AppendUvarint(buf, zigzag(int64(eval.Ply)-ply)<<2|uint64(kind))
+		ply = int64(eval.Ply)
+		if kind == packedKindOther {
+			buf = binary.AppendUvarint(buf, uint64(len(eval.ScoreType)))
+			buf = append(buf, eval.ScoreType...)
+		}
+		if kind == packedKindCP {
+			buf = binary.AppendVarint(buf, int64(eval.ScoreValue)-cp)
+			cp = int64(eval.ScoreValue)
+		} else {
+			buf = binary.AppendVarint(buf, int64(eval.ScoreValue))
+		}
+		buf = binary.AppendVarint(buf, int64(eval.Depth)-depth)
+		depth = int64(eval.Depth)
+	}
+	return buf
+}
+
+// UnpackMoveEvals decodes the output of PackMoveEvals. Empty data (no
+// evals) yields nil.
+func UnpackMoveEvals(data []byte) ([]MoveEval, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if data[0] != packedEvalsVersion {
+		return nil, fmt.Errorf("packed move evals: unknown version %d", data[0])
+	}
+	data = data[1:]
+	uvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, errors.New("packed move evals: truncated")
+		}
+		data = data[n:]
+		return v, nil
+	}
+	varint := func() (int64, error) {
+		v, n := binary.Varint(data)
+		if n <= 0 {
+			return 0, errors.New("packed move evals: truncated")
+		}
+		data = data[n:]
+		return v, nil
+	}
+	evals := []MoveEval{}
+	var ply, cp, depth int64
+	for len(data) > 0 {
+		head, err := uvarint()
+		if err != nil {
+			return nil, err
+		}
+		ply += unzigzag(head >> 2)
+		eval := MoveEval{Ply: int32(ply)}
+		switch head & 3 {
+		case packedKindCP:
+			eval.ScoreType = "cp"
+		case packedKindMate:
+			eval.ScoreType = "mate"
+		case packedKindOther:
+			n, err := uvarint()
+			if err != nil {
+				return nil, err
+			}
+			if n > uint64(len(data)) {
+				return nil, errors.New("packed move evals: truncated")
+			}
+			eval.ScoreType = string(data[:n])
+			data = data[n:]
+		default:
+			return nil, fmt.Errorf("packed move evals: unknown score kind %d", head&3)
+		}
+		value, err := varint()
+		if err != nil {
+			return nil, err
+		}
+		if eval.ScoreType == "cp" {
+			cp += value
+			value = cp
+		}
+		eval.ScoreValue = int32(value)
+		delta, err := varint()
+		if err != nil {
+			return nil, err
+		}
+		depth += delta
+		eval.Depth = int32(depth)
+		evals = append(evals, eval)
+	}
+	return evals, nil
+}
+
+func zigzag(v int64) uint64 { return uint64(v<<1) ^ uint64(v>>63) }
+
+func unzigzag(u uint64) int64 { return int64(u>>1) ^ -int64(u&1) }
+
+// compactRecordType is GameRecord with MoveEvals replaced by the
+// move_evals_packed column, the row type of compact files.
+var compactRecordType = func() reflect.Type {
+	t := reflect.TypeOf(GameRecord{})
+	fields := make([]reflect.StructField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Name == "MoveEvals" {
+			field = reflect.StructField{
+				Name: "MoveEvalsPacked",
+				Type: reflect.TypeOf(""),
+				Tag:  `parquet:"name=` + packedEvalsColumn + `, type=BYTE_ARRAY"`,
+			}
+		}
+		fields = append(fields, field)
+	}
+	return reflect.StructOf(fields)
+}()
+
+// compactRecord converts record to a compactRecordType row.
+func compactRecord(record GameRecord) any {
+	row := reflect.New(compactRecordType).Elem()
+	src := reflect.ValueOf(record)
+	for i := 0; i < row.NumField(); i++ {
+		field := row.Type().Field(i)
+		if field.Name == "MoveEvalsPacked" {
+			row.Field(i).SetString(string(PackMoveEvals(record.MoveEvals)))
+			continue
+		}
+		row.Field(i).Set(src.FieldByName(field.Name))
+	}
+	return row.Interface()
+}
@@ -0,0 +1,35 @@
+package cute_test
+
+import (
+	"reflect"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestPackMoveEvals(t *testing.T) {
+	for _, evals := range [][]cute.MoveEval{
+		{},
+		{{Ply: 1, ScoreType: "cp", ScoreValue: 30, Depth: 12}, {Ply: 2, ScoreType: "cp", ScoreValue: -45, Depth: 14},
+			{Ply: 5, ScoreType: "mate", ScoreValue: -3, Depth: 9}, {Ply: 6, ScoreType: "cp", ScoreValue: 31999},
+			{Ply: 7, ScoreType: "", ScoreValue: 0}, {Ply: 8, ScoreType: "unknown", ScoreValue: 7}},
+		{{Ply: 3, ScoreType: "cp"}, {Ply: 2, ScoreType: "cp", ScoreValue: -2147483648, Depth: 2147483647}},
+	} {
+		packed := cute.PackMoveEvals(evals)
+		got, err := cute.UnpackMoveEvals(packed)
+		if err != nil {
+			t.Fatalf("%v: %v", evals, err)
+		}
+		if !reflect.DeepEqual(got, evals) {
+			t.Fatalf("got %v, want %v", got, evals)
+		}
+		if len(packed) > 1 {
+			if _, err := cute.UnpackMoveEvals(packed[:len(packed)-1]); err == nil {
+				t.Fatalf("%v: expected an error for truncated data", evals)
+			}
+		}
+	}
+	if _, err := cute.UnpackMoveEvals([]byte{9}); err == nil {
+		t.Fatal("expected an error for an unknown version")
+	}
+}
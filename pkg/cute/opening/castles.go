@@ -0,0 +1,49 @@
+package opening
+
+// placement is one required piece of a formation, from the owner's side
+// (file 1..9 from its right, rank 9 its back rank).
+type placement struct {
+	file, rank int
+	kind       string
+}
+
+// formation is a castle: every placement must hold an unpromoted piece of
+// the player. names are the tags it adds (the name plus broader aliases).
+// A partial formation is a stage on the way to a castle and does not
+// complete castling.
+type formation struct {
+	names   []string
+	pieces  []placement
+	partial bool
+}
+
+func (f formation) matches(v view) bool {
+	for _, p := range f.pieces {
+		if !v.own(p.file, p.rank, p.kind) {
+			return false
+		}
+	}
+	return true
+}
+
+func king(file, rank int) placement   { return placement{file, rank, "K"} }
+func gold(file, rank int) placement   { return placement{file, rank, "G"} }
+func silver(file, rank int) placement { return placement{file, rank, "S"} }
+func lance(file, rank int) placement  { return placement{file, rank, "L"} }
+
+// castles are checked after every move of the player. A formation that is
+// built up in stages (片美濃囲い -> 美濃囲い) gets a tag for each stage.
+var castles = []formation{
+	{names: []string{"片美濃囲い"}, pieces: []placement{king(2, 8), silver(3, 8), gold(4, 9)}, partial: true},
+	{names: []string{"美濃囲い"}, pieces: []placement{king(2, 8), silver(3, 8), gold(4, 9), gold(5, 8)}},
+	{names: []string{"高美濃囲い"}, pieces: []placement{king(2, 8), silver(3, 8), gold(4, 9), gold(4, 7)}},
+	{names: []string{"銀冠"}, pieces: []placement{king(2, 8), silver(2, 7), gold(3, 8)}},
+	{names: []string{"振り飛車穴熊", "穴熊"}, pieces: []placement{king(1, 9), lance(1, 8), silver(2, 8)}},
+	{names: []string{"居飛車穴熊", "穴熊"}, pieces: []placement{king(9, 9), lance(9, 8), silver(8, 8)}},
+	{names: []string{"左美濃"}, pieces: []placement{king(8, 8), silver(7, 8), gold(6, 9)}},
+	{names: []string{"舟囲い"}, pieces: []placement{king(7, 8), gold(6, 9), gold(5, 8)}},
+	{names: []string{"矢倉囲い"}, pieces: []placement{king(8, 8), silver(7, 7), gold(7, 8), gold(6, 7)}},
+	{names: []string{"雁木囲い"}, pieces: []placement{king(6, 9), silver(6, 7), silver(5, 7), gold(7, 8)}},
+	{names: []string{"雁木囲い"}, pieces: []placement{king(7, 9), silver(6, 7), silver(5, 7), gold(7, 8)}},
+	{names: []string{"中住まい"}, pieces: []placement{king(5, 8), gold(7, 8), gold(3, 8)}},
+}
@@ -0,0 +1,190 @@
+// Package opening tags the strategies of both players of a game by
+// replaying it: the rook's file in the opening (四間飛車, 中飛車, ...), the
+// castles built around the king (美濃囲い, 穴熊, 矢倉囲い, ...), and whether
+// the game is 居飛車 or 振り飛車. The tag categories follow the opening DB
+// written by tools/classify_kif_to_db.rb so both can be used with
+// analyze -opening-db.
+package opening
+
+import (
+	"fmt"
+
+	cute "cute/pkg/cute"
+)
+
+// DefaultOpeningPlies is the number of plies in which the rook's moves
+// decide the attack tag when Options.OpeningPlies is 0.
+const DefaultOpeningPlies = 40
+
+// Options configures Classify.
+type Options struct {
+	// OpeningPlies bounds the plies in which the rook settles on its
+	// strategy file; a rook swinging in the middlegame is not one.
+	OpeningPlies int
+	// MaxPlies bounds the plies scanned for castles (0 = whole game).
+	MaxPlies int
+}
+
+// Tags are the tags of one player, in the order they were first detected.
+// Technique tags are not detected yet and are always empty.
+type Tags struct {
+	Attack    []string
+	Defense   []string
+	Technique []string
+	Note      []string
+	// Castle is the first castle the player completed (a stage such as
+	// 片美濃囲い does not count) and CastlePly the ply of the move that
+	// completed it; "" and 0 when the player never castled. Castles
+	// built up later still add Defense tags but do not change Castle.
+	Castle    string
+	CastlePly int
+}
+
+// Result holds the tags of both players.
+type Result struct {
+	Sente Tags
+	Gote  Tags
+}
+
+// Classify replays the main line of board.
+func Classify(board *cute.Board, opts Options) (Result, error) {
+	return ClassifyMoves(board.InitialPosition(), board.Moves(), opts)
+}
+
+// ClassifyMoves replays USI moves from initial and tags both players.
+func ClassifyMoves(initial cute.Position, moves []string, opts Options) (Result, error) {
+	openingPlies := opts.OpeningPlies
+	if openingPlies <= 0 {
+		openingPlies = DefaultOpeningPlies
+	}
+	pos := initial.Clone()
+	tags := map[cute.Color]*Tags{cute.Black: {}, cute.White: {}}
+	rookFile := map[cute.Color]int{}
+	for i, move := range moves {
+		ply := i + 1
+		if opts.MaxPlies > 0 && ply > opts.MaxPlies {
+			break
+		}
+		color := pos.Turn()
+		if err := pos.ApplyMove(move); err != nil {
+			return Result{}, fmt.Errorf("ply %d (%s): %w", ply, move, err)
+		}
+		v := view{pos: &pos, color: color}
+		t := tags[color]
+		if ply <= openingPlies {
+			if file := rookMove(v, move); file != 0 {
+				rookFile[color] = file
+			}
+		}
+		for _, f := range castles {
+			if f.matches(v) {
+				t.Defense = appendUnique(t.Defense, f.names...)
+				if t.Castle == "" && !f.partial {
+					t.Castle, t.CastlePly = f.names[0], ply
+				}
+			}
+		}
+	}
+
+	sente, gote := tags[cute.Black], tags[cute.White]
+	for color, t := range tags {
+		if name := rookFiles[rookFile[color]]; name != "" {
+			t.Attack = append(t.Attack, name)
+		}
+	}
+	senteFuri, goteFuri := isFuribisha(sente), isFuribisha(gote)
+	for _, side := range []struct {
+		t    *Tags
+		furi bool
+	}{{sente, senteFuri}, {gote, goteFuri}} {
+		if side.furi {
+			side.t.Note = appendUnique(side.t.Note, "振り飛車")
+		} else {
+			side.t.Note = appendUnique(side.t.Note, "居飛車")
+		}
+		switch {
+		case senteFuri && goteFuri:
+			side.t.Note = appendUnique(side.t.Note, "相振り飛車")
+		case senteFuri || goteFuri:
+			side.t.Note = appendUnique(side.t.Note, "対抗形")
+		default:
+			side.t.Note = appendUnique(side.t.Note, "相居飛車")
+		}
+	}
+	return Result{Sente: *sente, Gote: *gote}, nil
+}
+
+// view looks at a position from one player's side: file 1..9 from that
+// player's right, rank 9 its back rank.
+type view struct {
+	pos   *cute.Position
+	color cute.Color
+}
+
+// own reports whether the player has an unpromoted kind on file/rank.
+func (v view) own(file, rank int, kind string) bool {
+	if v.color == cute.White {
+		file, rank = 10-file, 10-rank
+	}
+	k, c, promoted, ok := v.pos.PieceAt(file, rank)
+	return ok && c == v.color && !promoted && k == kind
+}
+
+// rookFiles maps the rook's file (from its owner's side) to the attack tag.
+var rookFiles = map[int]string{
+	8: "向かい飛車",
+	7: "三間飛車",
+	6: "四間飛車",
+	5: "中飛車",
+	4: "右四間飛車",
+	3: "袖飛車",
+}
+
+// furibisha are the attack tags that make a player 振り飛車.
+var furibisha = map[string]bool{
+	"向かい飛車": true, "三間飛車": true, "四間飛車": true, "中飛車": true,
+}
+
+// rookMove returns the file (from the player's side) when move put the
+// player's rook on its own ranks 7-9, or 0. The last such move in the
+// opening is where the rook settles and decides the attack tag, so a rook
+// that swings twice, or floats up the board, is tagged once. Drops do not
+// count.
+func rookMove(v view, move string) int {
+	if len(move) < 4 || move[1] == '*' {
+		return 0
+	}
+	file, rank := int(move[2]-'0'), int(move[3]-'a')+1
+	if v.color == cute.White {
+		file, rank = 10-file, 10-rank
+	}
+	if rank < 7 || !v.own(file, rank, "R") {
+		return 0
+	}
+	return file
+}
+
+func isFuribisha(t *Tags) bool {
+	for _, name := range t.Attack {
+		if furibisha[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(list []string, names ...string) []string {
+	for _, name := range names {
+		found := false
+		for _, have := range list {
+			if have == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, name)
+		}
+	}
+	return list
+}
@@ -0,0 +1,91 @@
+package opening_test
+
+import (
+	"reflect"
+	"testing"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/opening"
+)
+
+func initialPosition(t *testing.T) cute.Position {
+	t.Helper()
+	board, err := cute.BoardFromKIF([]string{"手合割：平手", "手数----指手---------消費時間--"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return board.InitialPosition()
+}
+
+func TestClassifyShikenbishaMinoVsFunagakoi(t *testing.T) {
+	moves := []string{
+		"7g7f", "3c3d", "6g6f", "8c8d",
+		"2h6h", // 四間飛車
+		"8d8e", "8h7g", "5a4b", "5i4h", "4b3b", "4h3h",
+		"6a5b", // gote 舟囲い
+		"3h2h", "7a6b",
+		"3i3h", // 片美濃囲い
+		"1c1d",
+		"6i5h", // 美濃囲い
+	}
+	got, err := opening.ClassifyMoves(initialPosition(t), moves, opening.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := opening.Result{
+		Sente: opening.Tags{
+			Attack:    []string{"四間飛車"},
+			Defense:   []string{"片美濃囲い", "美濃囲い"},
+			Note:      []string{"振り飛車", "対抗形"},
+			Castle:    "美濃囲い",
+			CastlePly: 17,
+		},
+		Gote: opening.Tags{
+			Defense:   []string{"舟囲い"},
+			Note:      []string{"居飛車", "対抗形"},
+			Castle:    "舟囲い",
+			CastlePly: 12,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestClassifyOpeningPlies(t *testing.T) {
+	// The rook swings to the 5th file only at ply 5; with a 4-ply opening
+	// window that is not a strategy.
+	moves := []string{"7g7f", "3c3d", "2g2f", "8c8d", "2h5h"}
+	got, err := opening.ClassifyMoves(initialPosition(t), moves, opening.Options{OpeningPlies: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Sente.Attack) != 0 || !reflect.DeepEqual(got.Sente.Note, []string{"居飛車", "相居飛車"}) {
+		t.Fatalf("unexpected sente tags: %+v", got.Sente)
+	}
+
+	if _, err := opening.ClassifyMoves(initialPosition(t), []string{"5e5d"}, opening.Options{}); err == nil {
+		t.Fatal("expected an error for a move from an empty square")
+	}
+}
+
+func TestClassifyRookSettles(t *testing.T) {
+	// The rook swings to the 6th file and on to the 7th, where it
+	// settles, then floats up to 7六 and across to 6六; only 三間飛車 is
+	// tagged.
+	moves := []string{
+		"7g7f", "3c3d",
+		"2h6h", "8c8d",
+		"6h7h", "8d8e",
+		"7f7e", "5a4b",
+		"7h7f", "4b3b",
+		"7f6f",
+	}
+	got, err := opening.ClassifyMoves(initialPosition(t), moves, opening.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Sente.Attack, []string{"三間飛車"}) {
+		t.Fatalf("sente attack = %v, want [三間飛車]", got.Sente.Attack)
+	}
+}
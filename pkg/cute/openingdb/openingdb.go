@@ -0,0 +1,330 @@
+// Package openingdb reads the opening DB, the strategy classification
+// parquet written by cmd/classify and tools/classify_kif_to_db.rb, and
+// evaluates expr filters such as
+//
+//	has(sente.attack, "四間飛車") && has(gote.note, "居飛車")
+//
+// on the tags of a game, whether they come from the opening DB or from the
+// tag columns graph embeds in GameRecord.
+package openingdb
+
+import (
+	"fmt"
+	"strings"
+
+	cute "cute/pkg/cute"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// Record is one row of the opening DB. All columns are OPTIONAL because
+// the Ruby parquet gem writes nullable columns; both the kif_tags.parquet
+// (11 columns) and the 6_senkei.parquet (15 columns) layouts can be read.
+type Record struct {
+	GameID             *string `parquet:"name=game_id, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	GameType           *string `parquet:"name=game_type, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SenteName          *string `parquet:"name=sente_name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SenteRating        *int32  `parquet:"name=sente_rating, type=INT32, repetitiontype=OPTIONAL"`
+	GoteName           *string `parquet:"name=gote_name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	GoteRating         *int32  `parquet:"name=gote_rating, type=INT32, repetitiontype=OPTIONAL"`
+	TurnMax            *int32  `parquet:"name=turn_max, type=INT32, repetitiontype=OPTIONAL"`
+	SenteAttackTags    *string `parquet:"name=sente_attack_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SenteDefenseTags   *string `parquet:"name=sente_defense_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SenteTechniqueTags *string `parquet:"name=sente_technique_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SenteNoteTags      *string `parquet:"name=sente_note_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	GoteAttackTags     *string `parquet:"name=gote_attack_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	GoteDefenseTags    *string `parquet:"name=gote_defense_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	GoteTechniqueTags  *string `parquet:"name=gote_technique_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	GoteNoteTags       *string `parquet:"name=gote_note_tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+}
+
+// Tags are the tag lists of one player, the environment of per-player
+// filters (see CompilePlayer).
+type Tags struct {
+	Attack    []string `expr:"attack"`
+	Defense   []string `expr:"defense"`
+	Technique []string `expr:"technique"`
+	Note      []string `expr:"note"`
+}
+
+// Empty reports whether t has no tags.
+func (t Tags) Empty() bool {
+	return len(t.Attack)+len(t.Defense)+len(t.Technique)+len(t.Note) == 0
+}
+
+// Game is the tags of a game, the environment of game filters (see
+// Compile).
+//
+// Available fields:
+//
+//	game_id        string
+//	sente.attack   []string    sente.defense  []string
+//	sente.technique []string   sente.note     []string
+//	gote.attack    []string    gote.defense   []string
+//	gote.technique []string    gote.note      []string
+//
+// Built-in function:
+//
+//	has(tags, "タグ名") bool  — tags にタグが含まれるか判定
+//
+// Examples:
+//
+//	has(sente.attack, "四間飛車") && has(gote.attack, "居飛車")
+//	has(sente.attack, "中飛車") || has(gote.attack, "中飛車")
+//	has(sente.defense, "美濃囲い") && !has(gote.defense, "穴熊")
+type Game struct {
+	GameID string `expr:"game_id"`
+	Sente  Tags   `expr:"sente"`
+	Gote   Tags   `expr:"gote"`
+}
+
+// Side returns the tags of "sente" or "gote".
+func (g Game) Side(side string) Tags {
+	if side == "gote" {
+		return g.Gote
+	}
+	return g.Sente
+}
+
+// Game returns the tags of r.
+func (r Record) Game() Game {
+	return Game{
+		GameID: deref(r.GameID),
+		Sente: Tags{
+			Attack:    SplitTags(deref(r.SenteAttackTags)),
+			Defense:   SplitTags(deref(r.SenteDefenseTags)),
+			Technique: SplitTags(deref(r.SenteTechniqueTags)),
+			Note:      SplitTags(deref(r.SenteNoteTags)),
+		},
+		Gote: Tags{
+			Attack:    SplitTags(deref(r.GoteAttackTags)),
+			Defense:   SplitTags(deref(r.GoteDefenseTags)),
+			Technique: SplitTags(deref(r.GoteTechniqueTags)),
+			Note:      SplitTags(deref(r.GoteNoteTags)),
+		},
+	}
+}
+
+// FromGameRecord returns the tags graph embedded in r (-opening-db or
+// -classify). Only attack and defense tags are embedded, so a game without
+// them has empty Tags.
+func FromGameRecord(r cute.GameRecord) Game {
+	return Game{
+		GameID: r.GameID,
+		Sente: Tags{
+			Attack:  SplitTags(r.SenteAttackTags),
+			Defense: SplitTags(r.SenteDefenseTags),
+		},
+		Gote: Tags{
+			Attack:  SplitTags(r.GoteAttackTags),
+			Defense: SplitTags(r.GoteDefenseTags),
+		},
+	}
+}
+
+// Read calls fn with each row of the opening DB at path.
+func Read(path string, parallel int64, fn func(Record) error) error {
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return err
+	}
+	defer fileReader.Close()
+
+	parquetReader, err := reader.NewParquetReader(fileReader, new(Record), parallel)
+	if err != nil {
+		return err
+	}
+	defer parquetReader.ReadStop()
+
+	num := int(parquetReader.GetNumRows())
+	batchSize := 1024
+	for offset := 0; offset < num; offset += batchSize {
+		batch := make([]Record, min(batchSize, num-offset))
+		if err := parquetReader.Read(&batch); err != nil {
+			return err
+		}
+		for _, rec := range batch {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Load reads the opening DB at path into a map keyed by NormalizeGameID.
+func Load(path string, parallel int64) (map[string]Game, error) {
+	games := make(map[string]Game)
+	err := Read(path, parallel, func(rec Record) error {
+		game := rec.Game()
+		games[NormalizeGameID(game.GameID)] = game
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return games, nil
+}
+
+// NormalizeGameID strips the .kif extension, so that game IDs of the
+// opening DB and of GameRecord match.
+func NormalizeGameID(id string) string {
+	return strings.TrimSuffix(id, ".kif")
+}
+
+// SplitTags splits a comma-separated tag string into trimmed non-empty
+// strings.
+func SplitTags(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	result := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func deref(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+// Has implements has(tags, tag) for expr: whether tags contains tag.
+func Has(params ...any) (any, error) {
+	tags, ok1 := params[0].([]string)
+	tag, ok2 := params[1].(string)
+	if !ok1 || !ok2 {
+		return false, fmt.Errorf("has() expects ([]string, string), got (%T, %T)", params[0], params[1])
+	}
+	for _, t := range tags {
+		if t == tag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Compile compiles a boolean game filter with Game as its environment.
+func Compile(filter string) (*vm.Program, error) {
+	return compile(filter, Game{})
+}
+
+// CompilePlayer compiles a boolean per-player filter with Tags as its
+// environment (e.g. 'has(attack, "四間飛車")').
+func CompilePlayer(filter string) (*vm.Program, error) {
+	return compile(filter, Tags{})
+}
+
+func compile(filter string, env any) (*vm.Program, error) {
+	return expr.Compile(filter,
+		expr.Env(env),
+		expr.AsBool(),
+		expr.Function("has", Has,
+			new(func([]string, string) bool),
+		),
+	)
+}
+
+// Match runs a program of Compile or CompilePlayer on env. Errors count as
+// no match.
+func Match(program *vm.Program, env any) bool {
+	out, err := expr.Run(program, env)
+	if err != nil {
+		return false
+	}
+	matched, ok := out.(bool)
+	return ok && matched
+}
+
+// Filter collects the games matching a game filter and, with a crossing
+// side filter, which of their players match it.
+type Filter struct {
+	program         *vm.Program
+	crossingProgram *vm.Program
+	// AllowedIDs holds the normalized IDs of the matching games.
+	AllowedIDs map[string]bool
+	// CrossingSides maps the matching games to the players that match the
+	// crossing side filter: "sente", "gote" or "both". It is empty without
+	// one, meaning both sides count. Games where neither player matches
+	// are left out of AllowedIDs.
+	CrossingSides map[string]string
+	// Tagged holds the normalized IDs of all games added with opening
+	// tags, matching or not, so that games missing from the opening DB
+	// can be told from games the filter rejects.
+	Tagged map[string]bool
+}
+
+// NewFilter compiles the game filter and the optional per-player crossing
+// side filter.
+func NewFilter(filter, crossingSide string) (*Filter, error) {
+	program, err := Compile(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter expression: %w", err)
+	}
+	var crossingProgram *vm.Program
+	if crossingSide != "" {
+		if crossingProgram, err = CompilePlayer(crossingSide); err != nil {
+			return nil, fmt.Errorf("invalid crossing-side-filter expression: %w", err)
+		}
+	}
+	return &Filter{
+		program:         program,
+		crossingProgram: crossingProgram,
+		AllowedIDs:      make(map[string]bool),
+		CrossingSides:   make(map[string]string),
+		Tagged:          make(map[string]bool),
+	}, nil
+}
+
+// Add records game when it matches.
+func (f *Filter) Add(game Game) {
+	gid := NormalizeGameID(game.GameID)
+	if !game.Sente.Empty() || !game.Gote.Empty() {
+		f.Tagged[gid] = true
+	}
+	if !Match(f.program, game) {
+		return
+	}
+	f.AllowedIDs[gid] = true
+	if f.crossingProgram == nil {
+		return
+	}
+	senteMatch := Match(f.crossingProgram, game.Sente)
+	goteMatch := Match(f.crossingProgram, game.Gote)
+	switch {
+	case senteMatch && goteMatch:
+		f.CrossingSides[gid] = "both"
+	case senteMatch:
+		f.CrossingSides[gid] = "sente"
+	case goteMatch:
+		f.CrossingSides[gid] = "gote"
+	default:
+		delete(f.AllowedIDs, gid)
+	}
+}
+
+// LoadFilter runs NewFilter over the opening DB at path.
+func LoadFilter(path, filter, crossingSide string, parallel int64) (*Filter, error) {
+	f, err := NewFilter(filter, crossingSide)
+	if err != nil {
+		return nil, err
+	}
+	err = Read(path, parallel, func(rec Record) error {
+		f.Add(rec.Game())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,124 @@
+package openingdb_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"cute/pkg/cute/openingdb"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+func str(s string) *string { return &s }
+
+func writeDB(t *testing.T, rows []openingdb.Record) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tags.parquet")
+	fw, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err := writer.NewParquetWriter(fw, new(openingdb.Record), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := pw.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeDB(t, []openingdb.Record{
+		{GameID: str("1.kif"), SenteAttackTags: str("四間飛車, 振り飛車"), GoteDefenseTags: str("舟囲い"), GoteNoteTags: str("居飛車,")},
+		{GameID: str("2"), SenteAttackTags: str("")},
+	})
+	games, err := openingdb.Load(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := openingdb.Game{
+		GameID: "1.kif",
+		Sente:  openingdb.Tags{Attack: []string{"四間飛車", "振り飛車"}},
+		Gote:   openingdb.Tags{Defense: []string{"舟囲い"}, Note: []string{"居飛車"}},
+	}
+	if got := games["1"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("game 1: got %+v, want %+v", got, want)
+	}
+	if got, ok := games["2"]; !ok || !got.Sente.Empty() || !got.Gote.Empty() {
+		t.Errorf("game 2: got %+v, %v", got, ok)
+	}
+}
+
+func TestLoadFilter(t *testing.T) {
+	path := writeDB(t, []openingdb.Record{
+		{GameID: str("1.kif"), SenteAttackTags: str("四間飛車"), GoteNoteTags: str("居飛車")},
+		{GameID: str("2.kif"), SenteNoteTags: str("居飛車"), GoteAttackTags: str("四間飛車")},
+		{GameID: str("3.kif"), SenteAttackTags: str("四間飛車"), GoteAttackTags: str("四間飛車")},
+		{GameID: str("4.kif"), SenteAttackTags: str("中飛車"), GoteNoteTags: str("居飛車")},
+		{GameID: str("5.kif")},
+	})
+	for _, tc := range []struct {
+		name, filter, crossingSide string
+		wantIDs                    []string
+		wantSides                  map[string]string
+	}{
+		{
+			name:      "game filter",
+			filter:    `has(sente.attack, "四間飛車") || has(gote.attack, "四間飛車")`,
+			wantIDs:   []string{"1", "2", "3"},
+			wantSides: map[string]string{},
+		},
+		{
+			name:         "crossing side",
+			filter:       `has(sente.attack, "四間飛車") || has(gote.attack, "四間飛車")`,
+			crossingSide: `has(attack, "四間飛車")`,
+			wantIDs:      []string{"1", "2", "3"},
+			wantSides:    map[string]string{"1": "sente", "2": "gote", "3": "both"},
+		},
+		{
+			name:         "no side matches",
+			filter:       `has(gote.note, "居飛車")`,
+			crossingSide: `has(note, "居飛車")`,
+			wantIDs:      []string{"1", "4"},
+			wantSides:    map[string]string{"1": "gote", "4": "gote"},
+		},
+		{
+			name:         "side filter drops game",
+			filter:       `true`,
+			crossingSide: `has(attack, "中飛車")`,
+			wantIDs:      []string{"4"},
+			wantSides:    map[string]string{"4": "sente"},
+		},
+	} {
+		f, err := openingdb.LoadFilter(path, tc.filter, tc.crossingSide, 1)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var ids []string
+		for id := range f.AllowedIDs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, tc.wantIDs) || !reflect.DeepEqual(f.CrossingSides, tc.wantSides) {
+			t.Errorf("%s: got %v %v, want %v %v", tc.name, ids, f.CrossingSides, tc.wantIDs, tc.wantSides)
+		}
+		if len(f.Tagged) != 4 || f.Tagged["5"] {
+			t.Errorf("%s: tagged %v, want games 1-4", tc.name, f.Tagged)
+		}
+	}
+	if _, err := openingdb.NewFilter(`has(sente.castle, "穴熊")`, ""); err == nil {
+		t.Error("unknown field: expected a compile error")
+	}
+}
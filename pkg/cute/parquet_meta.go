@@ -0,0 +1,143 @@
+package cute
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// EngineInfo identifies the engine and limits that produced the evals in a
+// parquet file.
+type EngineInfo struct {
+	Binary  string            `json:"binary"`           // base name of the engine executable
+	Name    string            `json:"name,omitempty"`   // "id name" (usually includes the version)
+	Author  string            `json:"author,omitempty"` // "id author"
+	Options map[string]string `json:"options,omitempty"`
+	// Policy holds the eval limits. Cache is not recorded.
+	Policy EvalPolicy `json:"policy"`
+}
+
+// FVScale returns the FV_SCALE option the engine was run with, or "".
+func (e EngineInfo) FVScale() string {
+	return e.Options["FV_SCALE"]
+}
+
+// EvalFile returns the EvalDir and EvalFile options the engine was run
+// with joined as a path ("" when neither was set), which tells datasets
+// made with different networks apart.
+func (e EngineInfo) EvalFile() string {
+	dir, file := e.Options["EvalDir"], e.Options["EvalFile"]
+	switch {
+	case dir == "":
+		return file
+	case file == "":
+		return dir + "/"
+	}
+	return dir + "/" + file
+}
+
+// ParquetMeta is stored as key-value metadata in the parquet footer, so
+// files written before it existed keep the same columns and stay readable.
+type ParquetMeta struct {
+	// Engines lists the distinct engine setups whose evals are in the
+	// file; more than one means the file mixes engines (e.g. after a merge).
+	Engines []EngineInfo `json:"engines"`
+	// ScorePerspective is the perspective of the move_evals scores, so
+	// that data oriented otherwise is not taken for sente's. It is "" in
+	// files written before it was recorded, which are sente's.
+	ScorePerspective ScorePerspective `json:"score_perspective,omitempty"`
+	// DrawScore is the draw score the engines were run with (see
+	// EvalOptions.DrawScore), nil when they used their own.
+	DrawScore *int `json:"draw_score,omitempty"`
+}
+
+// CheckScorePerspective returns an error unless the evals of the file are
+// from sente's perspective, as the analysis commands assume.
+func (m ParquetMeta) CheckScorePerspective() error {
+	if p := m.ScorePerspective.normal(); p != PerspectiveSente {
+		return fmt.Errorf("evals are from the %s perspective, not %s", p, PerspectiveSente)
+	}
+	return nil
+}
+
+// CheckDrawScore returns an error unless the evals of the file were made
+// with the draw score score (nil = the engines' own), so that files scored
+// otherwise are not mixed.
+func (m ParquetMeta) CheckDrawScore(score *int) error {
+	if formatDrawScore(m.DrawScore) != formatDrawScore(score) {
+		return fmt.Errorf("evals were made with draw score %s, not %s", formatDrawScore(m.DrawScore), formatDrawScore(score))
+	}
+	return nil
+}
+
+func formatDrawScore(score *int) string {
+	if score == nil {
+		return "(engine default)"
+	}
+	return strconv.Itoa(*score)
+}
+
+// CheckParquetPerspective reads the metadata of the GameRecord file path
+// and returns an error unless its evals are from sente's perspective (see
+// CheckScorePerspective). The analysis commands call it on their inputs.
+func CheckParquetPerspective(path string) error {
+	meta, err := ReadParquetMeta(path)
+	if err != nil {
+		return err
+	}
+	if err := meta.CheckScorePerspective(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+const parquetMetaKey = "cute.meta"
+
+// MergeEngines returns a with the entries of b that it does not already
+// contain.
+func MergeEngines(a, b []EngineInfo) []EngineInfo {
+	out := append([]EngineInfo(nil), a...)
+	for _, e := range b {
+		found := false
+		for _, have := range out {
+			if reflect.DeepEqual(have, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// ReadParquetMeta returns the metadata of a GameRecord parquet file. Files
+// without metadata yield an empty ParquetMeta.
+func ReadParquetMeta(path string) (ParquetMeta, error) {
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return ParquetMeta{}, err
+	}
+	defer fileReader.Close()
+	parquetReader, err := reader.NewParquetReader(fileReader, nil, 1)
+	if err != nil {
+		return ParquetMeta{}, err
+	}
+	defer parquetReader.ReadStop()
+
+	var meta ParquetMeta
+	for _, kv := range parquetReader.Footer.KeyValueMetadata {
+		if kv.Key != parquetMetaKey || kv.Value == nil {
+			continue
+		}
+		if err := json.Unmarshal([]byte(*kv.Value), &meta); err != nil {
+			return ParquetMeta{}, fmt.Errorf("%s: invalid %s metadata: %w", path, parquetMetaKey, err)
+		}
+	}
+	return meta, nil
+}
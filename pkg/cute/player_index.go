@@ -0,0 +1,198 @@
+package cute
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/xitongsys/parquet-go-source/local"
+)
+
+// ErrStalePlayerIndex is returned by LoadPlayerIndex when the parquet file
+// changed after its index was written.
+var ErrStalePlayerIndex = errors.New("player index is stale")
+
+// PlayerIndex maps player names to the rows of a GameRecord parquet file
+// where they play either side, so that per-player queries read only those
+// rows. It is stored next to the file (see PlayerIndexPath) and records
+// the size and modification time of the file it describes.
+type PlayerIndex struct {
+	Rows    int64                 `json:"rows"`
+	Size    int64                 `json:"size"`
+	ModTime int64                 `json:"mod_time"` // Unix nanoseconds
+	Players map[string][]RowRange `json:"players"`
+}
+
+// RowRange is the rows [start, end) of a parquet file.
+type RowRange [2]int64
+
+// PlayerIndexPath returns the path of the index of the parquet file path.
+func PlayerIndexPath(path string) string {
+	return path + ".players.json"
+}
+
+type playerIndexBuilder struct {
+	rows    int64
+	players map[string][]RowRange
+}
+
+func newPlayerIndexBuilder() *playerIndexBuilder {
+	return &playerIndexBuilder{players: make(map[string][]RowRange)}
+}
+
+// add indexes record as the next row.
+func (b *playerIndexBuilder) add(record GameRecord) {
+	row := b.rows
+	b.rows++
+	for i, name := range []string{record.SenteName, record.GoteName} {
+		if name == "" || (i == 1 && name == record.SenteName) {
+			continue
+		}
+		ranges := b.players[name]
+		if n := len(ranges); n > 0 && ranges[n-1][1] == row {
+			ranges[n-1][1] = row + 1
+			continue
+		}
+		b.players[name] = append(ranges, RowRange{row, row + 1})
+	}
+}
+
+// write stores the index of the finished parquet file path at indexPath.
+func (b *playerIndexBuilder) write(path, indexPath string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(PlayerIndex{
+		Rows:    b.rows,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Players: b.players,
+	})
+	if err != nil {
+		return err
+	}
+	tmp := indexPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, indexPath)
+}
+
+// BuildPlayerIndex writes the index of an existing GameRecord parquet
+// file, reading only the player name columns.
+func BuildPlayerIndex(path string) error {
+	b := newPlayerIndexBuilder()
+	err := readGameRecordsColumns(path, 4, []string{"sente_name", "gote_name"}, func(_ int, record GameRecord) error {
+		b.add(record)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return b.write(path, PlayerIndexPath(path))
+}
+
+// LoadPlayerIndex reads the index of the parquet file path. The error
+// wraps os.ErrNotExist when there is none and is ErrStalePlayerIndex when
+// the file changed since.
+func LoadPlayerIndex(path string) (PlayerIndex, error) {
+	data, err := os.ReadFile(PlayerIndexPath(path))
+	if err != nil {
+		return PlayerIndex{}, err
+	}
+	var idx PlayerIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return PlayerIndex{}, fmt.Errorf("%s: %w", PlayerIndexPath(path), err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return PlayerIndex{}, err
+	}
+	if info.Size() != idx.Size || info.ModTime().UnixNano() != idx.ModTime {
+		return PlayerIndex{}, ErrStalePlayerIndex
+	}
+	return idx, nil
+}
+
+// Ranges returns the rows of the games of names, sorted and merged.
+func (idx PlayerIndex) Ranges(names []string) []RowRange {
+	var ranges []RowRange
+	for _, name := range names {
+		ranges = append(ranges, idx.Players[name]...)
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1] {
+			merged[n-1][1] = max(merged[n-1][1], r[1])
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// ReadPlayerGames calls fn with each record of the GameRecord parquet file
+// path where one of names plays either side, reading only cols (nil for
+// all; see NewGameRecordReaderColumns). With a current player index only
+// the rows of those games are read; otherwise the whole file is scanned.
+// It reports whether the index was used.
+func ReadPlayerGames(path string, parallel int64, names, cols []string, fn func(GameRecord) error) (bool, error) {
+	idx, err := LoadPlayerIndex(path)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrStalePlayerIndex) {
+		want := make(map[string]bool, len(names))
+		for _, name := range names {
+			want[name] = true
+		}
+		if cols != nil {
+			cols = append(cols[:len(cols):len(cols)], "sente_name", "gote_name")
+		}
+		return false, readGameRecordsColumns(path, parallel, cols, func(_ int, record GameRecord) error {
+			if want[record.SenteName] || want[record.GoteName] {
+				return fn(record)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return false, err
+	}
+
+	fileReader, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return true, err
+	}
+	defer fileReader.Close()
+	r, err := NewGameRecordReaderColumns(fileReader, parallel, cols)
+	if err != nil {
+		return true, err
+	}
+	defer r.ReadStop()
+	if r.GetNumRows() != idx.Rows {
+		return true, fmt.Errorf("%s: index has %d rows, file %d", PlayerIndexPath(path), idx.Rows, r.GetNumRows())
+	}
+
+	const batchSize = 1024
+	var pos int64
+	for _, rows := range idx.Ranges(names) {
+		if err := r.SkipRows(rows[0] - pos); err != nil {
+			return true, err
+		}
+		for start := rows[0]; start < rows[1]; start += batchSize {
+			batch := make([]GameRecord, min(batchSize, rows[1]-start))
+			if err := r.Read(&batch); err != nil {
+				return true, err
+			}
+			for _, record := range batch {
+				if err := fn(record); err != nil {
+					return true, err
+				}
+			}
+		}
+		pos = rows[1]
+	}
+	return true, nil
+}
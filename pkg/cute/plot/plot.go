@@ -0,0 +1,192 @@
+// Package plot renders the eval curve of a game as SVG or PNG.
+package plot
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+
+	cute "cute/pkg/cute"
+)
+
+// Output formats of RenderEvalCurve.
+const (
+	FormatSVG = "svg"
+	FormatPNG = "png"
+)
+
+// Options configures RenderEvalCurve. Zero values select the defaults.
+type Options struct {
+	Format  string // FormatSVG (default) or FormatPNG
+	Width   int    // default 800
+	Height  int    // default 260
+	MaxEval int    // eval range in cp (default 2000); larger evals and mates are clipped
+	// Thresholds are drawn as dashed lines at +t and -t.
+	Thresholds []int
+	// Markers highlight plies on the curve (e.g. blunders); plies without
+	// an eval are skipped.
+	Markers []Marker
+}
+
+// Marker is a highlighted ply. Label is the tooltip in SVG output.
+type Marker struct {
+	Ply   int
+	Label string
+}
+
+const (
+	marginLeft   = 48
+	marginRight  = 12
+	marginTop    = 12
+	marginBottom = 16
+)
+
+// curve is the layout shared by the SVG and PNG renderers.
+type curve struct {
+	width, height int
+	maxEval       int
+	plies         int
+	points        []point
+	// values maps ply to the clipped eval.
+	values map[int]int
+}
+
+type point struct {
+	x, y float64
+}
+
+// RenderEvalCurve draws the eval curve of record (sente's perspective,
+// ply on the x axis) with threshold lines and markers.
+func RenderEvalCurve(record cute.GameRecord, opts Options) ([]byte, error) {
+	if opts.Width == 0 {
+		opts.Width = 800
+	}
+	if opts.Height == 0 {
+		opts.Height = 260
+	}
+	if opts.MaxEval == 0 {
+		opts.MaxEval = 2000
+	}
+	if opts.Width <= marginLeft+marginRight || opts.Height <= marginTop+marginBottom {
+		return nil, fmt.Errorf("plot: size %dx%d is too small", opts.Width, opts.Height)
+	}
+	if opts.MaxEval < 0 {
+		return nil, fmt.Errorf("plot: MaxEval must be > 0")
+	}
+	c := newCurve(record, opts)
+	switch opts.Format {
+	case "", FormatSVG:
+		return c.svg(opts), nil
+	case FormatPNG:
+		return c.png(opts)
+	}
+	return nil, fmt.Errorf("plot: unknown format %q", opts.Format)
+}
+
+func newCurve(record cute.GameRecord, opts Options) *curve {
+	c := &curve{
+		width:   opts.Width,
+		height:  opts.Height,
+		maxEval: opts.MaxEval,
+		plies:   int(record.MoveCount),
+		values:  make(map[int]int, len(record.MoveEvals)),
+	}
+	for _, eval := range record.MoveEvals {
+		ply := int(eval.Ply)
+		c.values[ply] = min(max(eval.EffectiveCP(), -c.maxEval), c.maxEval)
+		c.plies = max(c.plies, ply)
+	}
+	for _, eval := range record.MoveEvals {
+		ply := int(eval.Ply)
+		c.points = append(c.points, point{x: c.x(ply), y: c.y(c.values[ply])})
+	}
+	return c
+}
+
+func (c *curve) x(ply int) float64 {
+	return round1(marginLeft + float64(ply)/float64(max(c.plies, 1))*float64(c.width-marginLeft-marginRight))
+}
+
+func (c *curve) y(v int) float64 {
+	mid := float64(marginTop+c.height-marginBottom) / 2
+	half := float64(c.height-marginTop-marginBottom) / 2
+	return round1(mid - float64(v)/float64(c.maxEval)*half)
+}
+
+// yTicks are the labelled eval levels.
+func (c *curve) yTicks() []int {
+	return []int{c.maxEval, c.maxEval / 2, 0, -c.maxEval / 2, -c.maxEval}
+}
+
+// xTicks are the labelled plies.
+func (c *curve) xTicks() []int {
+	step := 20
+	if c.plies > 200 {
+		step = 50
+	}
+	var ticks []int
+	for ply := 0; ply <= c.plies; ply += step {
+		ticks = append(ticks, ply)
+	}
+	return ticks
+}
+
+func (c *curve) svg(opts Options) []byte {
+	var b bytes.Buffer
+	left, right := float64(marginLeft), float64(c.width-marginRight)
+	fmt.Fprintf(&b, `<svg width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg" font-family="sans-serif" font-size="11">`+"\n",
+		c.width, c.height, c.width, c.height)
+	for _, v := range c.yTicks() {
+		y, stroke := c.y(v), "#eee"
+		if v == 0 {
+			stroke = "#999"
+		}
+		fmt.Fprintf(&b, `<line x1="%g" x2="%g" y1="%g" y2="%g" stroke="%s"/>`+"\n", left, right, y, y, stroke)
+		fmt.Fprintf(&b, `<text x="%g" y="%g" dx="-4" dy="4" text-anchor="end" fill="#666">%s</text>`+"\n", left, y, evalLabel(v))
+	}
+	for _, ply := range c.xTicks() {
+		fmt.Fprintf(&b, `<text x="%g" y="%d" dy="-2" text-anchor="middle" fill="#666">%d</text>`+"\n", c.x(ply), c.height, ply)
+	}
+	for _, t := range opts.Thresholds {
+		if t <= 0 || t > c.maxEval {
+			continue
+		}
+		for _, v := range []int{t, -t} {
+			y := c.y(v)
+			fmt.Fprintf(&b, `<line x1="%g" x2="%g" y1="%g" y2="%g" stroke="#f59e0b" stroke-dasharray="4 3"><title>%s</title></line>`+"\n", left, right, y, y, evalLabel(v))
+		}
+	}
+	if len(c.points) > 0 {
+		b.WriteString(`<polyline fill="none" stroke="#2563eb" stroke-width="1.5" points="`)
+		for i, p := range c.points {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprintf(&b, "%g,%g", p.x, p.y)
+		}
+		b.WriteString(`"/>` + "\n")
+	}
+	for _, m := range opts.Markers {
+		v, ok := c.values[m.Ply]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, `<circle cx="%g" cy="%g" r="4" fill="#dc2626">`, c.x(m.Ply), c.y(v))
+		if m.Label != "" {
+			fmt.Fprintf(&b, `<title>%s</title>`, html.EscapeString(m.Label))
+		}
+		b.WriteString("</circle>\n")
+	}
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
+
+func evalLabel(v int) string {
+	if v == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%+d", v)
+}
+
+func round1(v float64) float64 { return math.Round(v*10) / 10 }
@@ -0,0 +1,75 @@
+package plot_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/plot"
+)
+
+func testRecord() cute.GameRecord {
+	return cute.GameRecord{
+		GameID:    "1.kif",
+		MoveCount: 4,
+		MoveEvals: []cute.MoveEval{
+			{Ply: 1, ScoreType: "cp", ScoreValue: 50},
+			{Ply: 2, ScoreType: "cp", ScoreValue: 3000},
+			{Ply: 3, ScoreType: "cp", ScoreValue: -400},
+			{Ply: 4, ScoreType: "mate", ScoreValue: -1},
+		},
+	}
+}
+
+func TestRenderEvalCurveSVG(t *testing.T) {
+	out, err := plot.RenderEvalCurve(testRecord(), plot.Options{
+		Thresholds: []int{500},
+		Markers:    []plot.Marker{{Ply: 3, Label: "3 ▲<blunder>"}, {Ply: 9}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The output must be well-formed XML.
+	d := xml.NewDecoder(bytes.NewReader(out))
+	for {
+		if _, err := d.Token(); err != nil {
+			if err != io.EOF {
+				t.Fatalf("invalid svg: %v\n%s", err, out)
+			}
+			break
+		}
+	}
+	s := string(out)
+	// Height 260 with margins 12/16: +2000 at y=12, -2000 at y=244.
+	if !strings.Contains(s, `points="233,125.1 418,12 603,151.2 788,244"`) {
+		t.Fatalf("unexpected curve:\n%s", s)
+	}
+	if got := strings.Count(s, `stroke-dasharray`); got != 2 {
+		t.Fatalf("threshold lines = %d, want 2", got)
+	}
+	if got := strings.Count(s, `<circle`); got != 1 || !strings.Contains(s, "3 ▲&lt;blunder&gt;") {
+		t.Fatalf("unexpected markers:\n%s", s)
+	}
+}
+
+func TestRenderEvalCurvePNG(t *testing.T) {
+	out, err := plot.RenderEvalCurve(testRecord(), plot.Options{Format: plot.FormatPNG, Width: 400, Height: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 400 || b.Dy() != 200 {
+		t.Fatalf("size = %v", b)
+	}
+
+	if _, err := plot.RenderEvalCurve(testRecord(), plot.Options{Format: "gif"}); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
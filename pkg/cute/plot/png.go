@@ -0,0 +1,108 @@
+package plot
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strconv"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+var (
+	colorGrid      = color.RGBA{0xee, 0xee, 0xee, 0xff}
+	colorAxis      = color.RGBA{0x99, 0x99, 0x99, 0xff}
+	colorLabel     = color.RGBA{0x66, 0x66, 0x66, 0xff}
+	colorThreshold = color.RGBA{0xf5, 0x9e, 0x0b, 0xff}
+	colorCurve     = color.RGBA{0x25, 0x63, 0xeb, 0xff}
+	colorMarker    = color.RGBA{0xdc, 0x26, 0x26, 0xff}
+)
+
+// png rasterizes the same layout as svg. Marker labels are not drawn.
+func (c *curve) png(opts Options) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, c.width, c.height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	left, right := marginLeft, c.width-marginRight
+	face := basicfont.Face7x13
+	text := func(s string, x, y int) {
+		d := font.Drawer{Dst: img, Src: image.NewUniform(colorLabel), Face: face, Dot: fixed.P(x, y)}
+		d.DrawString(s)
+	}
+
+	for _, v := range c.yTicks() {
+		y := int(math.Round(c.y(v)))
+		col := colorGrid
+		if v == 0 {
+			col = colorAxis
+		}
+		hline(img, left, right, y, col, 0)
+		label := evalLabel(v)
+		text(label, left-4-font.MeasureString(face, label).Round(), y+4)
+	}
+	for _, ply := range c.xTicks() {
+		label := strconv.Itoa(ply)
+		text(label, int(math.Round(c.x(ply)))-font.MeasureString(face, label).Round()/2, c.height-3)
+	}
+	for _, t := range opts.Thresholds {
+		if t <= 0 || t > c.maxEval {
+			continue
+		}
+		hline(img, left, right, int(math.Round(c.y(t))), colorThreshold, 4)
+		hline(img, left, right, int(math.Round(c.y(-t))), colorThreshold, 4)
+	}
+	for i := 1; i < len(c.points); i++ {
+		a, b := c.points[i-1], c.points[i]
+		line(img, a.x, a.y, b.x, b.y, colorCurve)
+		line(img, a.x, a.y+1, b.x, b.y+1, colorCurve)
+	}
+	for _, m := range opts.Markers {
+		if v, ok := c.values[m.Ply]; ok {
+			disc(img, c.x(m.Ply), c.y(v), 4, colorMarker)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// hline draws a horizontal line, dashed with dash-pixel segments when
+// dash > 0.
+func hline(img *image.RGBA, x0, x1, y int, col color.RGBA, dash int) {
+	for x := x0; x <= x1; x++ {
+		if dash > 0 && (x-x0)/dash%2 == 1 {
+			continue
+		}
+		img.SetRGBA(x, y, col)
+	}
+}
+
+// line draws a one pixel line by stepping along its longer axis.
+func line(img *image.RGBA, x0, y0, x1, y1 float64, col color.RGBA) {
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	for i := 0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(i) / float64(steps)
+		}
+		img.SetRGBA(int(math.Round(x0+(x1-x0)*t)), int(math.Round(y0+(y1-y0)*t)), col)
+	}
+}
+
+func disc(img *image.RGBA, cx, cy, r float64, col color.RGBA) {
+	for y := int(cy - r); y <= int(cy+r)+1; y++ {
+		for x := int(cx - r); x <= int(cx+r)+1; x++ {
+			if dx, dy := float64(x)-cx, float64(y)-cy; dx*dx+dy*dy <= r*r {
+				img.SetRGBA(x, y, col)
+			}
+		}
+	}
+}
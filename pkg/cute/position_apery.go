@@ -0,0 +1,166 @@
+package cute
+
+import "fmt"
+
+// Apery book support.
+//
+// Apery-family engines read a binary book of 16-byte little-endian entries
+// sorted by key:
+//
+//	key        uint64  Zobrist hash of the position (see AperyKey)
+//	fromToPro  uint16  move encoded as to | from<<7 | promote<<14
+//	count      uint16  frequency
+//	score      int32   evaluation from the side to move
+//
+// The Zobrist tables are generated from std::mt19937_64 with its default
+// seed, in the same order as Apery's Book::init.
+
+const (
+	aperySquareNum    = 81
+	aperyPieceNone    = 31 // Apery's PieceNone; tables cover pieces [0, 31)
+	aperyHandPieceNum = 7
+	aperyMaxHandCount = 19
+)
+
+var (
+	aperyZobPiece [aperyPieceNone][aperySquareNum]uint64
+	aperyZobHand  [aperyHandPieceNum][aperyMaxHandCount]uint64
+	aperyZobTurn  uint64
+)
+
+func init() {
+	mt := newMT19937_64(5489)
+	for p := 0; p < aperyPieceNone; p++ {
+		for sq := 0; sq < aperySquareNum; sq++ {
+			aperyZobPiece[p][sq] = mt.next()
+		}
+	}
+	for hp := 0; hp < aperyHandPieceNum; hp++ {
+		for n := 0; n < aperyMaxHandCount; n++ {
+			aperyZobHand[hp][n] = mt.next()
+		}
+	}
+	aperyZobTurn = mt.next()
+}
+
+// aperyPieceType maps piece letters to Apery's PieceType
+// (Pawn=1 ... Rook=6, Gold=7, King=8).
+var aperyPieceType = map[string]int{
+	"P": 1, "L": 2, "N": 3, "S": 4, "B": 5, "R": 6, "G": 7, "K": 8,
+}
+
+// aperyHandOrder is Apery's HandPiece order.
+var aperyHandOrder = []string{"P", "L", "N", "S", "G", "B", "R"}
+
+// AperyKey returns the Apery book key of pos. Only the hand of the side to
+// move is hashed, matching Apery's Book::bookKey.
+func AperyKey(pos Position) uint64 {
+	var key uint64
+	for rank := 1; rank <= 9; rank++ {
+		for file := 1; file <= 9; file++ {
+			piece := pos.board[rank-1][file-1]
+			if piece == nil {
+				continue
+			}
+			key ^= aperyZobPiece[aperyPiece(piece)][aperySquare(square{file: file, rank: rank})]
+		}
+	}
+	hand := pos.hands[pos.turn]
+	for hp, kind := range aperyHandOrder {
+		count := hand[kind]
+		if count >= aperyMaxHandCount {
+			count = aperyMaxHandCount - 1
+		}
+		key ^= aperyZobHand[hp][count]
+	}
+	if pos.turn == White {
+		key ^= aperyZobTurn
+	}
+	return key
+}
+
+// AperyMove encodes a USI move in Apery's 16-bit fromToPro form. Drops use
+// from = 81 + pieceType - 1.
+func AperyMove(move string) (uint16, error) {
+	parsed, err := parseUSIMove(move)
+	if err != nil {
+		return 0, err
+	}
+	to := aperySquare(parsed.to)
+	if parsed.drop {
+		pt, ok := aperyPieceType[parsed.piece]
+		if !ok || parsed.piece == "K" {
+			return 0, fmt.Errorf("invalid drop piece: %s", move)
+		}
+		from := aperySquareNum + pt - 1
+		return uint16(to | from<<7), nil
+	}
+	from := aperySquare(parsed.from)
+	value := to | from<<7
+	if parsed.promote {
+		value |= 1 << 14
+	}
+	return uint16(value), nil
+}
+
+// aperySquare returns Apery's square index (SQ11=0, SQ12=1, ..., SQ99=80).
+func aperySquare(s square) int {
+	return (s.file-1)*9 + (s.rank - 1)
+}
+
+// aperyPiece returns Apery's Piece value: pieceType, +8 when promoted,
+// +16 for White.
+func aperyPiece(p *Piece) int {
+	value := aperyPieceType[p.kind]
+	if p.promoted {
+		value += 8
+	}
+	if p.color == White {
+		value += 16
+	}
+	return value
+}
+
+// mt19937_64 is the 64-bit Mersenne Twister (std::mt19937_64).
+type mt19937_64 struct {
+	state [312]uint64
+	index int
+}
+
+func newMT19937_64(seed uint64) *mt19937_64 {
+	mt := &mt19937_64{index: 312}
+	mt.state[0] = seed
+	for i := 1; i < 312; i++ {
+		prev := mt.state[i-1]
+		mt.state[i] = 6364136223846793005*(prev^(prev>>62)) + uint64(i)
+	}
+	return mt
+}
+
+func (mt *mt19937_64) next() uint64 {
+	const (
+		n         = 312
+		m         = 156
+		matrixA   = 0xB5026F5AA96619E9
+		upperMask = 0xFFFFFFFF80000000
+		lowerMask = 0x7FFFFFFF
+	)
+	if mt.index >= n {
+		for i := 0; i < n; i++ {
+			x := (mt.state[i] & upperMask) | (mt.state[(i+1)%n] & lowerMask)
+			xA := x >> 1
+			if x&1 != 0 {
+				xA ^= matrixA
+			}
+			mt.state[i] = mt.state[(i+m)%n] ^ xA
+		}
+		mt.index = 0
+	}
+	y := mt.state[mt.index]
+	mt.index++
+	y ^= (y >> 29) & 0x5555555555555555
+	y ^= (y << 17) & 0x71D67FFFEDA60000
+	y ^= (y << 37) & 0xFFF7EEE000000000
+	y ^= y >> 43
+	return y
+}
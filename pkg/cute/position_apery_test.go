@@ -0,0 +1,67 @@
+package cute_test
+
+import (
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestAperyMove(t *testing.T) {
+	tests := []struct {
+		move string
+		want uint16
+	}{
+		{move: "7g7f", want: 59 | 60<<7},
+		{move: "P*5e", want: 40 | 81<<7},
+		{move: "8h2b+", want: 10 | 70<<7 | 1<<14},
+		{move: "G*1a", want: 0 | 87<<7},
+	}
+	for _, tt := range tests {
+		got, err := cute.AperyMove(tt.move)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.move, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s: got %d want %d", tt.move, got, tt.want)
+		}
+	}
+	if _, err := cute.AperyMove("K*5e"); err == nil {
+		t.Fatal("expected error for king drop")
+	}
+}
+
+func TestAperyKeyTranspositions(t *testing.T) {
+	start := "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1"
+	play := func(moves ...string) cute.Position {
+		t.Helper()
+		pos, err := cute.PositionFromSFEN(start)
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		for _, m := range moves {
+			if err := pos.ApplyMove(m); err != nil {
+				t.Fatalf("apply %s: %v", m, err)
+			}
+		}
+		return pos
+	}
+	a := cute.AperyKey(play("7g7f", "3c3d", "2g2f"))
+	b := cute.AperyKey(play("2g2f", "3c3d", "7g7f"))
+	if a != b {
+		t.Fatalf("transposed positions have different keys: %x %x", a, b)
+	}
+	if c := cute.AperyKey(play("7g7f", "3c3d")); c == a {
+		t.Fatal("different positions share a key")
+	}
+
+	// Only the hand of the side to move is part of the key.
+	black, _ := cute.PositionFromSFEN("4k4/9/9/9/9/9/9/9/4K4 b P 1")
+	blackWithWhiteHand, _ := cute.PositionFromSFEN("4k4/9/9/9/9/9/9/9/4K4 b Pp 1")
+	if cute.AperyKey(black) != cute.AperyKey(blackWithWhiteHand) {
+		t.Fatal("opponent hand should not affect the key")
+	}
+	white, _ := cute.PositionFromSFEN("4k4/9/9/9/9/9/9/9/4K4 w P 1")
+	if cute.AperyKey(black) == cute.AperyKey(white) {
+		t.Fatal("side to move should affect the key")
+	}
+}
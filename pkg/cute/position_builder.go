@@ -0,0 +1,150 @@
+package cute
+
+import (
+	"errors"
+	"fmt"
+)
+
+// pieceLimits is the number of pieces of each kind in a shogi set.
+var pieceLimits = map[string]int{
+	"K": 2, "R": 2, "B": 2, "G": 4, "S": 4, "N": 4, "L": 4, "P": 18,
+}
+
+// PositionBuilder assembles a Position piece by piece. Unlike SetPiece,
+// which places whatever it is given, the builder rejects impossible
+// placements as they are made and Build checks the finished position (see
+// Position.Validate), so positions set up by hand for tests or engine
+// matches cannot silently be illegal.
+type PositionBuilder struct {
+	pos Position
+	err error // first placement error, reported by Build
+}
+
+// NewPositionBuilder returns a builder for an empty board with empty hands
+// and sente (Black) to move.
+func NewPositionBuilder() *PositionBuilder {
+	return &PositionBuilder{pos: NewPosition()}
+}
+
+// Piece places a piece on file/rank (1-indexed). Placing a piece off the
+// board, on an occupied square, of an unknown kind or promoting a king or
+// gold is an error.
+func (b *PositionBuilder) Piece(file, rank int, kind string, color Color, promoted bool) *PositionBuilder {
+	if b.err != nil {
+		return b
+	}
+	s := square{file: file, rank: rank}
+	switch {
+	case file < 1 || file > 9 || rank < 1 || rank > 9:
+		b.err = fmt.Errorf("square %d%d is off the board", file, rank)
+	case pieceLimits[kind] == 0:
+		b.err = fmt.Errorf("%d%d: unknown piece %q", file, rank, kind)
+	case promoted && (kind == "K" || kind == "G"):
+		b.err = fmt.Errorf("%d%d: %s cannot be promoted", file, rank, kind)
+	case b.pos.pieceAt(s) != nil:
+		b.err = fmt.Errorf("square %d%d is already occupied", file, rank)
+	default:
+		b.pos.setPiece(s, &Piece{kind: kind, color: color, promoted: promoted})
+	}
+	return b
+}
+
+// Hand sets the number of pieces of kind in color's hand. Kings cannot be
+// held.
+func (b *PositionBuilder) Hand(color Color, kind string, n int) *PositionBuilder {
+	if b.err != nil {
+		return b
+	}
+	switch {
+	case kind == "K" || pieceLimits[kind] == 0:
+		b.err = fmt.Errorf("%q cannot be held in hand", kind)
+	case n < 0:
+		b.err = fmt.Errorf("negative count %d of %s in hand", n, kind)
+	case n == 0:
+		delete(b.pos.hands[color], kind)
+	default:
+		b.pos.hands[color][kind] = n
+	}
+	return b
+}
+
+// Turn sets the side to move.
+func (b *PositionBuilder) Turn(color Color) *PositionBuilder {
+	b.pos.turn = color
+	return b
+}
+
+// Build returns the position, or the first placement error or the reason
+// it is not a legal position. The builder can be used again afterwards:
+// Build returns a copy.
+func (b *PositionBuilder) Build() (Position, error) {
+	if b.err != nil {
+		return Position{}, b.err
+	}
+	if err := b.pos.Validate(); err != nil {
+		return Position{}, err
+	}
+	return b.pos.Clone(), nil
+}
+
+// Validate reports why p could not occur in a game, or nil: each side
+// needs exactly one king, no kind may exceed its number in a set (board
+// and hands together), no side may have two unpromoted pawns on a file
+// (二歩) or an unpromoted pawn, lance or knight that could never move,
+// and the side that is not to move must not be in check.
+//
+// Positions read from KIF and SFEN are not validated, since a KIF may
+// start from any set-up; call Validate when that matters.
+func (p *Position) Validate() error {
+	counts := make(map[string]int)
+	kings := map[Color]int{}
+	var pawnFiles [2][10]bool
+	for rank := 1; rank <= 9; rank++ {
+		for file := 1; file <= 9; file++ {
+			piece := p.board[rank-1][file-1]
+			if piece == nil {
+				continue
+			}
+			counts[piece.kind]++
+			if piece.kind == "K" {
+				kings[piece.color]++
+			}
+			if piece.promoted {
+				continue
+			}
+			if deadSquare(piece.kind, square{file: file, rank: rank}, piece.color) {
+				return fmt.Errorf("%s at %d%d could never move", piece.kind, file, rank)
+			}
+			if piece.kind == "P" {
+				if pawnFiles[piece.color][file] {
+					return fmt.Errorf("two pawns of %s on file %d (二歩)", colorName(piece.color), file)
+				}
+				pawnFiles[piece.color][file] = true
+			}
+		}
+	}
+	for _, color := range []Color{Black, White} {
+		for kind, n := range p.hands[color] {
+			counts[kind] += n
+		}
+		if kings[color] != 1 {
+			return fmt.Errorf("%s has %d kings, want 1", colorName(color), kings[color])
+		}
+	}
+	for _, kind := range []string{"K", "R", "B", "G", "S", "N", "L", "P"} {
+		if counts[kind] > pieceLimits[kind] {
+			return fmt.Errorf("%d pieces of %s, at most %d exist", counts[kind], kind, pieceLimits[kind])
+		}
+	}
+	if !p.IsLegalPosition() {
+		return errors.New("the side not to move is in check")
+	}
+	return nil
+}
+
+func colorName(color Color) string {
+	if color == White {
+		return "gote"
+	}
+	return "sente"
+}
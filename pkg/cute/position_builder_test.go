@@ -0,0 +1,64 @@
+package cute_test
+
+import (
+	"strings"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestPositionBuilder(t *testing.T) {
+	pos, err := cute.NewPositionBuilder().
+		Piece(5, 9, "K", cute.Black, false).
+		Piece(5, 1, "K", cute.White, false).
+		Piece(2, 8, "R", cute.Black, false).
+		Piece(7, 7, "P", cute.Black, false).
+		Hand(cute.Black, "G", 2).
+		Turn(cute.White).
+		Build()
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if got, want := pos.ToSFEN(1), "4k4/9/9/9/9/9/2P6/7R1/4K4 w 2G 1"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	kings := func() *cute.PositionBuilder {
+		return cute.NewPositionBuilder().Piece(5, 9, "K", cute.Black, false).Piece(5, 1, "K", cute.White, false)
+	}
+	for _, tc := range []struct {
+		name    string
+		builder *cute.PositionBuilder
+		want    string
+	}{
+		{"off board", kings().Piece(0, 5, "P", cute.Black, false), "off the board"},
+		{"occupied", kings().Piece(5, 9, "G", cute.Black, false), "occupied"},
+		{"unknown kind", kings().Piece(3, 3, "X", cute.Black, false), "unknown piece"},
+		{"promoted gold", kings().Piece(3, 3, "G", cute.Black, true), "cannot be promoted"},
+		{"king in hand", kings().Hand(cute.Black, "K", 1), "cannot be held"},
+		{"missing king", cute.NewPositionBuilder().Piece(5, 9, "K", cute.Black, false), "gote has 0 kings"},
+		{"nifu", kings().Piece(3, 7, "P", cute.Black, false).Piece(3, 5, "P", cute.Black, false), "二歩"},
+		{"dead knight", kings().Piece(3, 2, "N", cute.Black, false), "could never move"},
+		{"too many rooks", kings().Piece(2, 8, "R", cute.Black, false).Hand(cute.White, "R", 2), "at most 2"},
+		{"opponent in check", kings().Piece(5, 5, "R", cute.Black, false), "in check"},
+	} {
+		if _, err := tc.builder.Build(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.name, err, tc.want)
+		}
+	}
+
+	// Promoted pawns do not count for 二歩 and may stand anywhere.
+	if _, err := kings().Piece(3, 7, "P", cute.Black, false).Piece(3, 1, "P", cute.Black, true).Build(); err != nil {
+		t.Errorf("promoted pawn: %v", err)
+	}
+}
+
+func TestPositionValidateInitial(t *testing.T) {
+	pos, err := cute.PositionFromSFEN("lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pos.Validate(); err != nil {
+		t.Errorf("initial position: %v", err)
+	}
+}
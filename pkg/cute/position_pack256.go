@@ -1,19 +1,88 @@
 package cute
 
-import "fmt"
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
 
 type Packed256 struct {
 	Words [4]uint64
 }
 
-type bitWriter256 struct {
-	words [4]uint64
+// packed256Size is the length of the serialized form in bytes.
+const packed256Size = 32
+
+// MarshalBinary returns the 32-byte serialized form: the four words in
+// little-endian order, so bit i of the packed stream is bit i%8 of byte i/8.
+// The encoding is stable across runs and platforms.
+func (p Packed256) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, packed256Size)
+	for i, w := range p.Words {
+		binary.LittleEndian.PutUint64(buf[i*8:], w)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes the form produced by MarshalBinary.
+func (p *Packed256) UnmarshalBinary(data []byte) error {
+	if len(data) != packed256Size {
+		return fmt.Errorf("packed256: invalid length %d, expected %d", len(data), packed256Size)
+	}
+	for i := range p.Words {
+		p.Words[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return nil
+}
+
+// Base64 returns the serialized form as unpadded URL-safe Base64 (43 chars).
+func (p Packed256) Base64() string {
+	buf, _ := p.MarshalBinary()
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// ParsePacked256Base64 decodes a string produced by Packed256.Base64.
+func ParsePacked256Base64(s string) (Packed256, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Packed256{}, fmt.Errorf("packed256: %w", err)
+	}
+	var p Packed256
+	if err := p.UnmarshalBinary(buf); err != nil {
+		return Packed256{}, err
+	}
+	return p, nil
+}
+
+// MarshalText implements encoding.TextMarshaler using the Base64 form, so
+// Packed256 can be used directly as a JSON object key.
+func (p Packed256) MarshalText() ([]byte, error) {
+	return []byte(p.Base64()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *Packed256) UnmarshalText(text []byte) error {
+	parsed, err := ParsePacked256Base64(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// bitWriter writes a bit stream of at most limit bits, growing words as
+// needed; limit 0 means no limit.
+type bitWriter struct {
+	words []uint64
 	pos   int
+	limit int
 }
 
-type bitReader256 struct {
-	words [4]uint64
+// bitReader reads the first limit bits of words.
+type bitReader struct {
+	words []uint64
 	pos   int
+	limit int
 }
 
 type codeSpec struct {
@@ -53,25 +122,51 @@ var boardCodeBook = buildCodeBook(boardCodes)
 var handCodeBook = buildCodeBook(handCodes)
 
 func PackPosition256(pos Position) (Packed256, error) {
-	writer := &bitWriter256{}
+	writer := &bitWriter{words: make([]uint64, 4), limit: 256}
+	if err := writePackedPosition(writer, pos, false); err != nil {
+		return Packed256{}, err
+	}
+	if writer.pos != 256 {
+		return Packed256{}, fmt.Errorf("packed length is %d bits, expected 256", writer.pos)
+	}
+	var p Packed256
+	copy(p.Words[:], writer.words)
+	return p, nil
+}
+
+func UnpackPosition256(p Packed256) (Position, error) {
+	return readPackedPosition(&bitReader{words: p.Words[:], limit: 256}, false)
+}
+
+// Unpack is UnpackPosition256, for PackedPosition.
+func (p Packed256) Unpack() (Position, error) {
+	return UnpackPosition256(p)
+}
+
+// noKing is the king square written by PackPositionVar for a missing king.
+const noKing = 81
 
+// writePackedPosition writes the side to move, the king squares, the board
+// and the hands. With missingKings, a king may be absent (noKing);
+// otherwise both are required.
+func writePackedPosition(writer *bitWriter, pos Position, missingKings bool) error {
 	turnBit := uint64(0)
 	if pos.turn == White {
 		turnBit = 1
 	}
 	if err := writer.writeBit(turnBit); err != nil {
-		return Packed256{}, err
+		return err
 	}
 
-	blackKing, whiteKing, err := kingSquares(pos)
+	blackKing, whiteKing, err := kingSquares(pos, missingKings)
 	if err != nil {
-		return Packed256{}, err
+		return err
 	}
 	if err := writer.writeBits(uint64(blackKing), 7); err != nil {
-		return Packed256{}, err
+		return err
 	}
 	if err := writer.writeBits(uint64(whiteKing), 7); err != nil {
-		return Packed256{}, err
+		return err
 	}
 
 	for sq := 0; sq < 81; sq++ {
@@ -81,18 +176,18 @@ func PackPosition256(pos Position) (Packed256, error) {
 		piece := pieceAtIndex(pos, sq)
 		if piece == nil {
 			if err := writer.writeCode(boardCodeBook, "", false); err != nil {
-				return Packed256{}, err
+				return err
 			}
 			continue
 		}
 		if piece.kind == "K" {
-			return Packed256{}, fmt.Errorf("unexpected king at square %d", sq)
+			return fmt.Errorf("unexpected king at square %d", sq)
 		}
 		if err := writer.writeCode(boardCodeBook, piece.kind, false); err != nil {
-			return Packed256{}, err
+			return err
 		}
 		if err := writer.writeColor(piece.color); err != nil {
-			return Packed256{}, err
+			return err
 		}
 		if isPromotable(piece.kind) {
 			promoBit := uint64(0)
@@ -100,7 +195,7 @@ func PackPosition256(pos Position) (Packed256, error) {
 				promoBit = 1
 			}
 			if err := writer.writeBit(promoBit); err != nil {
-				return Packed256{}, err
+				return err
 			}
 		}
 	}
@@ -110,30 +205,25 @@ func PackPosition256(pos Position) (Packed256, error) {
 			count := pos.hands[color][kind]
 			for i := 0; i < count; i++ {
 				if err := writer.writeCode(handCodeBook, kind, true); err != nil {
-					return Packed256{}, err
+					return err
 				}
 				if err := writer.writeColor(color); err != nil {
-					return Packed256{}, err
+					return err
 				}
 				if isPromotable(kind) {
 					if err := writer.writeBit(0); err != nil {
-						return Packed256{}, err
+						return err
 					}
 				}
 			}
 		}
 	}
-
-	if writer.pos != 256 {
-		return Packed256{}, fmt.Errorf("packed length is %d bits, expected 256", writer.pos)
-	}
-
-	return Packed256{Words: writer.words}, nil
+	return nil
 }
 
-func UnpackPosition256(p Packed256) (Position, error) {
-	reader := &bitReader256{words: p.Words}
-
+// readPackedPosition reads what writePackedPosition wrote, taking hand
+// pieces up to the limit of reader.
+func readPackedPosition(reader *bitReader, missingKings bool) (Position, error) {
 	turnBit, err := reader.readBit()
 	if err != nil {
 		return Position{}, err
@@ -151,7 +241,12 @@ func UnpackPosition256(p Packed256) (Position, error) {
 	if err != nil {
 		return Position{}, err
 	}
-	if blackKing == whiteKing {
+	for _, king := range []uint64{blackKing, whiteKing} {
+		if king > noKing || king == noKing && !missingKings {
+			return Position{}, fmt.Errorf("invalid king square %d", king)
+		}
+	}
+	if blackKing == whiteKing && blackKing != noKing {
 		return Position{}, fmt.Errorf("kings share square %d", blackKing)
 	}
 
@@ -163,8 +258,12 @@ func UnpackPosition256(p Packed256) (Position, error) {
 		},
 		turn: turn,
 	}
-	setPieceAtIndex(&pos, int(blackKing), &Piece{kind: "K", color: Black})
-	setPieceAtIndex(&pos, int(whiteKing), &Piece{kind: "K", color: White})
+	if blackKing != noKing {
+		setPieceAtIndex(&pos, int(blackKing), &Piece{kind: "K", color: Black})
+	}
+	if whiteKing != noKing {
+		setPieceAtIndex(&pos, int(whiteKing), &Piece{kind: "K", color: White})
+	}
 
 	for sq := 0; sq < 81; sq++ {
 		if sq == int(blackKing) || sq == int(whiteKing) {
@@ -192,7 +291,7 @@ func UnpackPosition256(p Packed256) (Position, error) {
 		setPieceAtIndex(&pos, sq, &Piece{kind: code.kind, color: color, promoted: promoted})
 	}
 
-	for reader.pos < 256 {
+	for reader.pos < reader.limit {
 		code, err := reader.readCode(handCodeBook)
 		if err != nil {
 			return Position{}, err
@@ -230,11 +329,14 @@ func buildCodeBook(codes []codeSpec) codeBook {
 	return book
 }
 
-func (w *bitWriter256) writeBit(bit uint64) error {
-	if w.pos >= 256 {
+func (w *bitWriter) writeBit(bit uint64) error {
+	if w.limit > 0 && w.pos >= w.limit {
 		return fmt.Errorf("bitstream overflow")
 	}
 	word := w.pos / 64
+	if word == len(w.words) {
+		w.words = append(w.words, 0)
+	}
 	offset := uint(w.pos % 64)
 	if bit != 0 {
 		w.words[word] |= 1 << offset
@@ -243,7 +345,7 @@ func (w *bitWriter256) writeBit(bit uint64) error {
 	return nil
 }
 
-func (w *bitWriter256) writeBits(value uint64, bitLen int) error {
+func (w *bitWriter) writeBits(value uint64, bitLen int) error {
 	for i := 0; i < bitLen; i++ {
 		bit := (value >> i) & 1
 		if err := w.writeBit(bit); err != nil {
@@ -253,7 +355,7 @@ func (w *bitWriter256) writeBits(value uint64, bitLen int) error {
 	return nil
 }
 
-func (w *bitWriter256) writeCode(book codeBook, kind string, isHand bool) error {
+func (w *bitWriter) writeCode(book codeBook, kind string, isHand bool) error {
 	code, ok := findCode(book, kind, isHand)
 	if !ok {
 		return fmt.Errorf("unknown piece code: %s", kind)
@@ -261,7 +363,7 @@ func (w *bitWriter256) writeCode(book codeBook, kind string, isHand bool) error
 	return w.writeBits(code.bits, code.bitLen)
 }
 
-func (w *bitWriter256) writeColor(color Color) error {
+func (w *bitWriter) writeColor(color Color) error {
 	bit := uint64(0)
 	if color == White {
 		bit = 1
@@ -269,8 +371,8 @@ func (w *bitWriter256) writeColor(color Color) error {
 	return w.writeBit(bit)
 }
 
-func (r *bitReader256) readBit() (uint64, error) {
-	if r.pos >= 256 {
+func (r *bitReader) readBit() (uint64, error) {
+	if r.pos >= r.limit {
 		return 0, fmt.Errorf("bitstream underflow")
 	}
 	word := r.pos / 64
@@ -280,7 +382,7 @@ func (r *bitReader256) readBit() (uint64, error) {
 	return bit, nil
 }
 
-func (r *bitReader256) readBits(bitLen int) (uint64, error) {
+func (r *bitReader) readBits(bitLen int) (uint64, error) {
 	var value uint64
 	for i := 0; i < bitLen; i++ {
 		bit, err := r.readBit()
@@ -292,7 +394,7 @@ func (r *bitReader256) readBits(bitLen int) (uint64, error) {
 	return value, nil
 }
 
-func (r *bitReader256) readCode(book codeBook) (codeSpec, error) {
+func (r *bitReader) readCode(book codeBook) (codeSpec, error) {
 	var value uint64
 	for length := 1; length <= book.maxLen; length++ {
 		bit, err := r.readBit()
@@ -307,7 +409,7 @@ func (r *bitReader256) readCode(book codeBook) (codeSpec, error) {
 	return codeSpec{}, fmt.Errorf("invalid code")
 }
 
-func (r *bitReader256) readColor() (Color, error) {
+func (r *bitReader) readColor() (Color, error) {
 	bit, err := r.readBit()
 	if err != nil {
 		return Black, err
@@ -332,7 +434,9 @@ func findCode(book codeBook, kind string, isHand bool) (codeSpec, bool) {
 	return codeSpec{}, false
 }
 
-func kingSquares(pos Position) (int, int, error) {
+// kingSquares returns the squares of the black and white kings; a missing
+// king is an error unless allowMissing, when its square is noKing.
+func kingSquares(pos Position, allowMissing bool) (int, int, error) {
 	black := -1
 	white := -1
 	for r := 0; r < 9; r++ {
@@ -355,9 +459,15 @@ func kingSquares(pos Position) (int, int, error) {
 			}
 		}
 	}
-	if black == -1 || white == -1 {
+	if (black == -1 || white == -1) && !allowMissing {
 		return 0, 0, fmt.Errorf("missing king")
 	}
+	if black == -1 {
+		black = noKing
+	}
+	if white == -1 {
+		white = noKing
+	}
 	return black, white, nil
 }
 
@@ -0,0 +1,85 @@
+package cute
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// PackedPosition is a packed position that can be used as a map key.
+// PackPosition returns a Packed256 for positions with the standard set of
+// pieces and a PackedVar for the others, so every position has exactly one
+// packed form.
+type PackedPosition interface {
+	MarshalBinary() ([]byte, error)
+	Base64() string
+	Unpack() (Position, error)
+}
+
+// PackPosition packs pos as a Packed256 when it fits in 256 bits and as a
+// PackedVar otherwise: positions with pieces missing (handicap games,
+// tsume) or without one of the kings.
+func PackPosition(pos Position) (PackedPosition, error) {
+	if packed, err := PackPosition256(pos); err == nil {
+		return packed, nil
+	}
+	packed, err := PackPositionVar(pos)
+	if err != nil {
+		return nil, err
+	}
+	return packed, nil
+}
+
+// PackedVar is the variable-length packing of a position: the Packed256
+// code stream without its length requirement, with 81 as the square of a
+// missing king. The first two bytes are the stream length in bits
+// (little-endian), followed by the stream in the bit order of Packed256.
+// It is a string so that it is comparable.
+type PackedVar string
+
+// PackPositionVar packs any position with at most one king per side.
+func PackPositionVar(pos Position) (PackedVar, error) {
+	writer := &bitWriter{}
+	if err := writePackedPosition(writer, pos, true); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 2+(writer.pos+7)/8)
+	binary.LittleEndian.PutUint16(buf, uint16(writer.pos))
+	for i := 2; i < len(buf); i++ {
+		bit := (i - 2) * 8
+		buf[i] = byte(writer.words[bit/64] >> (bit % 64))
+	}
+	return PackedVar(buf), nil
+}
+
+// UnpackPositionVar decodes a PackedVar.
+func UnpackPositionVar(p PackedVar) (Position, error) {
+	if len(p) < 2 {
+		return Position{}, fmt.Errorf("packedvar: invalid length %d", len(p))
+	}
+	bits := int(binary.LittleEndian.Uint16([]byte(p[:2])))
+	if want := 2 + (bits+7)/8; len(p) != want {
+		return Position{}, fmt.Errorf("packedvar: invalid length %d, expected %d", len(p), want)
+	}
+	words := make([]uint64, (bits+63)/64)
+	for i := 2; i < len(p); i++ {
+		bit := (i - 2) * 8
+		words[bit/64] |= uint64(p[i]) << (bit % 64)
+	}
+	return readPackedPosition(&bitReader{words: words, limit: bits}, true)
+}
+
+// MarshalBinary returns the bytes of p.
+func (p PackedVar) MarshalBinary() ([]byte, error) {
+	return []byte(p), nil
+}
+
+// Base64 returns p as unpadded URL-safe Base64, like Packed256.Base64.
+func (p PackedVar) Base64() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(p))
+}
+
+// Unpack is UnpackPositionVar, for PackedPosition.
+func (p PackedVar) Unpack() (Position, error) {
+	return UnpackPositionVar(p)
+}
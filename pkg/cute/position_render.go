@@ -0,0 +1,148 @@
+package cute
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// RenderText returns the position as a KIF board diagram (BOD): gote's
+// hand, the board with gote's pieces marked "v", sente's hand, and
+// "後手番" when gote is to move. The lines can be pasted into a KIF header.
+func (p *Position) RenderText() string {
+	return strings.Join(boardDiagram(p), "\n") + "\n"
+}
+
+func boardDiagram(p *Position) []string {
+	out := []string{
+		"後手の持駒：" + formatKIFHand(p.hands[White]),
+		"  ９ ８ ７ ６ ５ ４ ３ ２ １",
+		"+---------------------------+",
+	}
+	for rank := 1; rank <= 9; rank++ {
+		var b strings.Builder
+		b.WriteString("|")
+		for file := 9; file >= 1; file-- {
+			piece := p.pieceAt(square{file: file, rank: rank})
+			if piece == nil {
+				b.WriteString(" ・")
+				continue
+			}
+			if piece.color == White {
+				b.WriteString("v")
+			} else {
+				b.WriteString(" ")
+			}
+			b.WriteString(boardPieceName(piece))
+		}
+		b.WriteString("|")
+		b.WriteRune(kifRankDigits[rank-1])
+		out = append(out, b.String())
+	}
+	out = append(out, "+---------------------------+")
+	out = append(out, "先手の持駒："+formatKIFHand(p.hands[Black]))
+	if p.turn == White {
+		out = append(out, "後手番")
+	}
+	return out
+}
+
+func boardPieceName(piece *Piece) string {
+	if piece.promoted {
+		return boardPromotedName(piece.kind)
+	}
+	return kifPieceNames[piece.kind]
+}
+
+// BoardSVGOptions configures RenderSVGWith.
+type BoardSVGOptions struct {
+	// LastMove is a USI move whose squares are highlighted, e.g. the move
+	// that led to the position.
+	LastMove string
+	// Cell is the square size in pixels (default 40).
+	Cell int
+}
+
+// RenderSVG returns the position as an SVG board diagram.
+func (p *Position) RenderSVG() string {
+	return p.RenderSVGWith(BoardSVGOptions{})
+}
+
+// RenderSVGWith is RenderSVG with options. Gote's pieces are drawn upside
+// down and promoted pieces in red; the hands are listed above (gote) and
+// below (sente) the board.
+func (p *Position) RenderSVGWith(opts BoardSVGOptions) string {
+	cell := opts.Cell
+	if cell <= 0 {
+		cell = 40
+	}
+	label := cell / 2
+	left, top := cell/4, label+label
+	width := left + 9*cell + label + cell/4
+	height := top + 9*cell + label + label
+	font := cell * 3 / 5
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg" font-family="serif">`+"\n",
+		width, height, width, height)
+	turn := func(c Color) string {
+		if p.turn == c {
+			return " (手番)"
+		}
+		return ""
+	}
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="%d">☖ %s%s</text>`+"\n",
+		left, label*3/4, label*3/4, html.EscapeString(formatKIFHand(p.hands[White])), turn(White))
+	fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="#f3d9a4" stroke="#333" stroke-width="2"/>`+"\n",
+		left, top, 9*cell, 9*cell)
+
+	squareXY := func(s square) (int, int) { return left + (9-s.file)*cell, top + (s.rank-1)*cell }
+	if opts.LastMove != "" {
+		if move, err := parseUSIMove(opts.LastMove); err == nil {
+			squares := []square{move.to}
+			if !move.drop {
+				squares = append(squares, move.from)
+			}
+			for _, s := range squares {
+				x, y := squareXY(s)
+				fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="#f59e0b" fill-opacity="0.35"/>`+"\n", x, y, cell, cell)
+			}
+		}
+	}
+	for i := 1; i < 9; i++ {
+		fmt.Fprintf(&b, `<line x1="%d" x2="%d" y1="%d" y2="%d" stroke="#333"/>`+"\n", left+i*cell, left+i*cell, top, top+9*cell)
+		fmt.Fprintf(&b, `<line x1="%d" x2="%d" y1="%d" y2="%d" stroke="#333"/>`+"\n", left, left+9*cell, top+i*cell, top+i*cell)
+	}
+	for i := 0; i < 9; i++ {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="%d" text-anchor="middle">%c</text>`+"\n",
+			left+i*cell+cell/2, top-label/4, label*3/4, kifFileDigits[8-i])
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="%d" text-anchor="middle" dominant-baseline="central">%c</text>`+"\n",
+			left+9*cell+label/2+2, top+i*cell+cell/2, label*3/4, kifRankDigits[i])
+	}
+
+	for rank := 1; rank <= 9; rank++ {
+		for file := 1; file <= 9; file++ {
+			s := square{file: file, rank: rank}
+			piece := p.pieceAt(s)
+			if piece == nil {
+				continue
+			}
+			x, y := squareXY(s)
+			cx, cy := x+cell/2, y+cell/2
+			fill := "#000"
+			if piece.promoted {
+				fill = "#b91c1c"
+			}
+			transform := ""
+			if piece.color == White {
+				transform = fmt.Sprintf(` transform="rotate(180 %d %d)"`, cx, cy)
+			}
+			fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="%d" fill="%s" text-anchor="middle" dominant-baseline="central"%s>%s</text>`+"\n",
+				cx, cy, font, fill, transform, boardPieceName(piece))
+		}
+	}
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="%d">☗ %s%s</text>`+"\n",
+		left, top+9*cell+label+label/4, label*3/4, html.EscapeString(formatKIFHand(p.hands[Black])), turn(Black))
+	b.WriteString("</svg>\n")
+	return b.String()
+}
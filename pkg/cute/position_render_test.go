@@ -0,0 +1,74 @@
+package cute_test
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func renderTestPosition(t *testing.T) cute.Position {
+	t.Helper()
+	board, err := cute.BoardFromKIF([]string{"手合割：平手"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := board.InitialPosition()
+	for _, move := range []string{"7g7f", "3c3d", "8h2b+", "3a2b", "B*4e"} {
+		if err := pos.ApplyMove(move); err != nil {
+			t.Fatalf("%s: %v", move, err)
+		}
+	}
+	return pos
+}
+
+func TestPositionRenderText(t *testing.T) {
+	pos := renderTestPosition(t)
+	text := pos.RenderText()
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if lines[0] != "後手の持駒：角　" || lines[len(lines)-1] != "後手番" {
+		t.Fatalf("unexpected diagram:\n%s", text)
+	}
+	if got := lines[3]; got != "|v香v桂v銀v金v玉v金 ・v桂v香|一" {
+		t.Fatalf("rank 1 = %q", got)
+	}
+
+	// The diagram is a valid KIF header for the same position.
+	board, err := cute.BoardFromKIF(append(lines, "手数----指手---------消費時間--"))
+	if err != nil {
+		t.Fatalf("reparse: %v\n%s", err, text)
+	}
+	got := board.InitialPosition()
+	if got.ToSFEN(1) != pos.ToSFEN(1) {
+		t.Fatalf("round trip: got %s want %s", got.ToSFEN(1), pos.ToSFEN(1))
+	}
+}
+
+func TestPositionRenderSVG(t *testing.T) {
+	pos := renderTestPosition(t)
+	svg := pos.RenderSVGWith(cute.BoardSVGOptions{LastMove: "B*4e"})
+	d := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		if _, err := d.Token(); err != nil {
+			if err != io.EOF {
+				t.Fatalf("invalid svg: %v\n%s", err, svg)
+			}
+			break
+		}
+	}
+	// 40 pieces minus the bishop in gote's hand, drawn at 3/5 of the cell.
+	if got := strings.Count(svg, `font-size="24"`); got != 39 {
+		t.Fatalf("pieces = %d, want 39", got)
+	}
+	if got := strings.Count(svg, `rotate(180`); got != 19 {
+		t.Fatalf("gote pieces = %d, want 19", got)
+	}
+	if got := strings.Count(svg, `fill-opacity`); got != 1 {
+		t.Fatalf("highlighted squares = %d, want 1 (a drop has no source)", got)
+	}
+	if !strings.Contains(pos.RenderSVG(), "☖ 角　 (手番)") {
+		t.Fatalf("gote hand missing:\n%s", svg)
+	}
+}
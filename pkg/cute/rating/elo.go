@@ -0,0 +1,58 @@
+package rating
+
+import "math"
+
+// DefaultK is the Elo K-factor used when EloOptions.K is 0.
+const DefaultK = 32
+
+// EloOptions configures NewElo.
+type EloOptions struct {
+	K       float64 // 0 = DefaultK
+	Initial float64 // 0 = DefaultInitial
+}
+
+// Elo is the Elo rating system.
+type Elo struct {
+	k, initial float64
+	players    map[string]*Rating
+}
+
+// NewElo returns an Elo system with no rated players.
+func NewElo(opts EloOptions) *Elo {
+	e := &Elo{k: opts.K, initial: opts.Initial, players: make(map[string]*Rating)}
+	if e.k == 0 {
+		e.k = DefaultK
+	}
+	if e.initial == 0 {
+		e.initial = DefaultInitial
+	}
+	return e
+}
+
+// Rating implements System.
+func (e *Elo) Rating(name string) Rating {
+	if r, ok := e.players[name]; ok {
+		return *r
+	}
+	return Rating{Rating: e.initial}
+}
+
+// Update implements System.
+func (e *Elo) Update(sente, gote string, score float64) {
+	a, b := e.player(sente), e.player(gote)
+	expected := 1 / (1 + math.Pow(10, (b.Rating-a.Rating)/400))
+	delta := e.k * (score - expected)
+	a.Rating += delta
+	b.Rating -= delta
+	a.Games++
+	b.Games++
+}
+
+func (e *Elo) player(name string) *Rating {
+	r, ok := e.players[name]
+	if !ok {
+		r = &Rating{Rating: e.initial}
+		e.players[name] = r
+	}
+	return r
+}
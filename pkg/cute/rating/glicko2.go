@@ -0,0 +1,138 @@
+package rating
+
+import "math"
+
+// Glicko-2 defaults, as suggested by Glickman.
+const (
+	DefaultDeviation  = 350
+	DefaultVolatility = 0.06
+	DefaultTau        = 0.5
+)
+
+// glickoScale converts between the Glicko and Glicko-2 scales.
+const glickoScale = 173.7178
+
+// Glicko2Options configures NewGlicko2. Zero fields use the defaults.
+type Glicko2Options struct {
+	Initial    float64
+	Deviation  float64 // initial rating deviation
+	Volatility float64 // initial volatility
+	Tau        float64 // constrains the change of volatility over time
+}
+
+// Glicko2 is the Glicko-2 rating system with each game as its own rating
+// period, so ratings move after every game as in Elo.
+type Glicko2 struct {
+	opts    Glicko2Options
+	players map[string]*Rating
+}
+
+// NewGlicko2 returns a Glicko-2 system with no rated players.
+func NewGlicko2(opts Glicko2Options) *Glicko2 {
+	if opts.Initial == 0 {
+		opts.Initial = DefaultInitial
+	}
+	if opts.Deviation == 0 {
+		opts.Deviation = DefaultDeviation
+	}
+	if opts.Volatility == 0 {
+		opts.Volatility = DefaultVolatility
+	}
+	if opts.Tau == 0 {
+		opts.Tau = DefaultTau
+	}
+	return &Glicko2{opts: opts, players: make(map[string]*Rating)}
+}
+
+// Rating implements System.
+func (g *Glicko2) Rating(name string) Rating {
+	if r, ok := g.players[name]; ok {
+		return *r
+	}
+	return g.initial()
+}
+
+// Update implements System. Both players are updated from their ratings
+// before the game.
+func (g *Glicko2) Update(sente, gote string, score float64) {
+	a, b := g.player(sente), g.player(gote)
+	newA := g.rate(*a, *b, score)
+	newB := g.rate(*b, *a, 1-score)
+	*a, *b = newA, newB
+}
+
+func (g *Glicko2) initial() Rating {
+	return Rating{Rating: g.opts.Initial, Deviation: g.opts.Deviation, Volatility: g.opts.Volatility}
+}
+
+func (g *Glicko2) player(name string) *Rating {
+	r, ok := g.players[name]
+	if !ok {
+		initial := g.initial()
+		r = &initial
+		g.players[name] = r
+	}
+	return r
+}
+
+// rate returns the rating of p after one game against opponent with the
+// given score (steps 2-8 of Glickman's "Example of the Glicko-2 system").
+func (g *Glicko2) rate(p, opponent Rating, score float64) Rating {
+	mu := (p.Rating - g.opts.Initial) / glickoScale
+	phi := p.Deviation / glickoScale
+	muJ := (opponent.Rating - g.opts.Initial) / glickoScale
+	phiJ := opponent.Deviation / glickoScale
+
+	gJ := 1 / math.Sqrt(1+3*phiJ*phiJ/(math.Pi*math.Pi))
+	expected := 1 / (1 + math.Exp(-gJ*(mu-muJ)))
+	v := 1 / (gJ * gJ * expected * (1 - expected))
+	delta := v * gJ * (score - expected)
+
+	sigma := g.volatility(phi, p.Volatility, v, delta)
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	newPhi := 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	newMu := mu + newPhi*newPhi*gJ*(score-expected)
+
+	return Rating{
+		Rating:     newMu*glickoScale + g.opts.Initial,
+		Deviation:  newPhi * glickoScale,
+		Volatility: sigma,
+		Games:      p.Games + 1,
+	}
+}
+
+// volatility solves for the new volatility with the Illinois algorithm.
+func (g *Glicko2) volatility(phi, sigma, v, delta float64) float64 {
+	const epsilon = 1e-6
+	tau := g.opts.Tau
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(tau*tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*tau) < 0 {
+			k++
+		}
+		B = a - k*tau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > epsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
@@ -0,0 +1,99 @@
+// Package rating recomputes player ratings by replaying games in
+// chronological order, with Elo or Glicko-2. Site-reported ratings mix
+// rating systems and lag behind a player's current strength; recomputed
+// ratings from the games at hand are consistent across the whole data set.
+package rating
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+)
+
+// DefaultInitial is the rating of a player's first game.
+const DefaultInitial = 1500
+
+// Rating is a player's rating. Deviation and Volatility are only set by
+// Glicko-2.
+type Rating struct {
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+	Games      int
+}
+
+// System updates ratings one game at a time.
+type System interface {
+	// Rating returns the current rating of name (the initial rating for
+	// an unknown player).
+	Rating(name string) Rating
+	// Update rates a game; score is sente's score (1 win, 0.5 draw, 0 loss).
+	Update(sente, gote string, score float64)
+}
+
+// Game is one rated game.
+type Game struct {
+	ID        string
+	StartTime string // GameRecord.StartTime; "" when unknown
+	Sente     string
+	Gote      string
+	Score     float64 // sente's score
+}
+
+// GameFromRecord returns the rated game of record. ok is false for games
+// without a winner or a draw (aborted, unknown) or without both names.
+func GameFromRecord(record cute.GameRecord) (Game, bool) {
+	game := Game{ID: record.GameID, StartTime: record.StartTime, Sente: record.SenteName, Gote: record.GoteName}
+	if game.Sente == "" || game.Gote == "" {
+		return Game{}, false
+	}
+	switch record.Result {
+	case "sente_win":
+		game.Score = 1
+	case "gote_win":
+		game.Score = 0
+	case "draw":
+		game.Score = 0.5
+	default:
+		return Game{}, false
+	}
+	return game, true
+}
+
+// SortGames orders games chronologically: by start time, then by game ID
+// (numerically when both are numbers, as site game IDs increase over
+// time). Games without a start time come first.
+func SortGames(games []Game) {
+	sort.SliceStable(games, func(i, j int) bool {
+		a, b := games[i], games[j]
+		if a.StartTime != b.StartTime {
+			return a.StartTime < b.StartTime
+		}
+		an, aok := gameNumber(a.ID)
+		bn, bok := gameNumber(b.ID)
+		if aok && bok {
+			return an < bn
+		}
+		return a.ID < b.ID
+	})
+}
+
+func gameNumber(id string) (int64, bool) {
+	n, err := strconv.ParseInt(strings.TrimSuffix(id, filepath.Ext(id)), 10, 64)
+	return n, err == nil
+}
+
+// Replay rates games in order. fn, when non-nil, gets each game with both
+// players' ratings before and after it.
+func Replay(games []Game, system System, fn func(game Game, before, after [2]Rating)) {
+	for _, game := range games {
+		before := [2]Rating{system.Rating(game.Sente), system.Rating(game.Gote)}
+		system.Update(game.Sente, game.Gote, game.Score)
+		if fn != nil {
+			fn(game, before, [2]Rating{system.Rating(game.Sente), system.Rating(game.Gote)})
+		}
+	}
+}
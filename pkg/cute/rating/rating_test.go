@@ -0,0 +1,102 @@
+package rating_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/rating"
+)
+
+func TestEloUpdate(t *testing.T) {
+	elo := rating.NewElo(rating.EloOptions{})
+	elo.Update("a", "b", 1)
+	if got := elo.Rating("a"); got.Rating != 1516 || got.Games != 1 {
+		t.Fatalf("winner: %+v", got)
+	}
+	if got := elo.Rating("b"); got.Rating != 1484 {
+		t.Fatalf("loser: %+v", got)
+	}
+	if got := elo.Rating("unknown"); got.Rating != rating.DefaultInitial || got.Games != 0 {
+		t.Fatalf("unknown player: %+v", got)
+	}
+
+	// A draw against a weaker player costs rating.
+	elo.Update("a", "b", 0.5)
+	if got := elo.Rating("a"); got.Rating >= 1516 {
+		t.Fatalf("draw against weaker player: %+v", got)
+	}
+}
+
+func TestGlicko2Update(t *testing.T) {
+	g := rating.NewGlicko2(rating.Glicko2Options{})
+	g.Update("a", "b", 1)
+	a, b := g.Rating("a"), g.Rating("b")
+	// Between two new players the update is symmetric.
+	if math.Abs((a.Rating-1500)-(1500-b.Rating)) > 1e-9 {
+		t.Fatalf("asymmetric update: %+v %+v", a, b)
+	}
+	if a.Rating <= 1500 || a.Deviation >= rating.DefaultDeviation || a.Games != 1 {
+		t.Fatalf("winner: %+v", a)
+	}
+	// Glicko-2 moves new players far more than Elo with K=32.
+	if a.Rating-1500 < 100 {
+		t.Fatalf("winner moved only %.1f", a.Rating-1500)
+	}
+
+	// An established player beating a new one gains less than the new
+	// player would have.
+	for i := 0; i < 20; i++ {
+		g.Update("a", "c", 0.5)
+	}
+	before := g.Rating("a")
+	g.Update("a", "d", 1)
+	after := g.Rating("a")
+	if gain := after.Rating - before.Rating; gain <= 0 || gain >= a.Rating-1500 {
+		t.Fatalf("established player gained %.1f", gain)
+	}
+}
+
+func TestGameFromRecordAndSortGames(t *testing.T) {
+	records := []cute.GameRecord{
+		{GameID: "20.kif", SenteName: "a", GoteName: "b", Result: "gote_win"},
+		{GameID: "3.kif", SenteName: "a", GoteName: "b", Result: "sente_win"},
+		{GameID: "1.kif", SenteName: "a", GoteName: "b", Result: "draw", StartTime: "2025-01-01T00:00:00"},
+		{GameID: "2.kif", SenteName: "a", GoteName: "b", Result: "abort"},
+		{GameID: "4.kif", SenteName: "a", Result: "sente_win"},
+	}
+	var games []rating.Game
+	for _, record := range records {
+		if game, ok := rating.GameFromRecord(record); ok {
+			games = append(games, game)
+		}
+	}
+	rating.SortGames(games)
+	var ids []string
+	var scores []float64
+	for _, game := range games {
+		ids = append(ids, game.ID)
+		scores = append(scores, game.Score)
+	}
+	if want := []string{"3.kif", "20.kif", "1.kif"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("order %v, want %v", ids, want)
+	}
+	if want := []float64{1, 0, 0.5}; !reflect.DeepEqual(scores, want) {
+		t.Fatalf("scores %v, want %v", scores, want)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	games := []rating.Game{
+		{ID: "1", Sente: "a", Gote: "b", Score: 1},
+		{ID: "2", Sente: "b", Gote: "a", Score: 1},
+	}
+	var befores []float64
+	rating.Replay(games, rating.NewElo(rating.EloOptions{}), func(game rating.Game, before, after [2]rating.Rating) {
+		befores = append(befores, before[0].Rating)
+	})
+	if want := []float64{1500, 1484}; !reflect.DeepEqual(befores, want) {
+		t.Fatalf("sente ratings before games: %v, want %v", befores, want)
+	}
+}
@@ -0,0 +1,77 @@
+package cute
+
+// Problems reported by CheckGameRecord, in report order. Duplicate
+// game_ids need the whole file and are left to the caller.
+const (
+	ProblemEmptyGameID       = "empty_game_id"
+	ProblemUnknownResult     = "unknown_result"
+	ProblemRatingOutOfRange  = "rating_out_of_range"
+	ProblemEvalCountMismatch = "eval_count_mismatch"
+	ProblemEvalIncomplete    = "eval_incomplete"
+	ProblemEvalPlyOrder      = "eval_ply_order"
+	ProblemUnknownScoreType  = "unknown_score_type"
+)
+
+// knownResults are the GameRecord.Result values ResultFromKIFLines returns.
+var knownResults = map[string]bool{
+	"sente_win": true,
+	"gote_win":  true,
+	"draw":      true,
+	"abort":     true,
+	"unknown":   true,
+}
+
+// RecordCheckOptions configures CheckGameRecord.
+type RecordCheckOptions struct {
+	// Ratings outside RatingMin..RatingMax are implausible; 0 (the KIF
+	// had no rating) is always accepted. RatingMax <= 0 has no upper
+	// bound.
+	RatingMin, RatingMax int
+	// SparseEvals accepts fewer move_evals than move_count, as written
+	// with EvalPolicy.Stride > 1.
+	SparseEvals bool
+}
+
+// CheckGameRecord returns the problems of record (Problem* names), or nil
+// when it looks like a complete record written by graph.
+func CheckGameRecord(record GameRecord, opts RecordCheckOptions) []string {
+	var problems []string
+	if record.GameID == "" {
+		problems = append(problems, ProblemEmptyGameID)
+	}
+	if !knownResults[record.Result] {
+		problems = append(problems, ProblemUnknownResult)
+	}
+	for _, rating := range []int{int(record.SenteRating), int(record.GoteRating)} {
+		if rating != 0 && (rating < opts.RatingMin || opts.RatingMax > 0 && rating > opts.RatingMax) {
+			problems = append(problems, ProblemRatingOutOfRange)
+			break
+		}
+	}
+	switch {
+	case !record.EvalComplete:
+		// A partial record is short by design; report that instead.
+		problems = append(problems, ProblemEvalIncomplete)
+	case !opts.SparseEvals && len(record.MoveEvals) != int(record.MoveCount):
+		problems = append(problems, ProblemEvalCountMismatch)
+	}
+	prev := 0
+	orderOK, typesOK := true, true
+	for _, eval := range record.MoveEvals {
+		ply := int(eval.Ply)
+		if ply <= prev || ply > int(record.MoveCount) {
+			orderOK = false
+		}
+		prev = ply
+		if eval.ScoreType != "cp" && eval.ScoreType != "mate" {
+			typesOK = false
+		}
+	}
+	if !orderOK {
+		problems = append(problems, ProblemEvalPlyOrder)
+	}
+	if !typesOK {
+		problems = append(problems, ProblemUnknownScoreType)
+	}
+	return problems
+}
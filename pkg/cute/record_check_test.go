@@ -0,0 +1,35 @@
+package cute_test
+
+import (
+	"slices"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestCheckGameRecord(t *testing.T) {
+	evals := []cute.MoveEval{{Ply: 1, ScoreType: "cp"}, {Ply: 2, ScoreType: "mate", ScoreValue: 1}}
+	opts := cute.RecordCheckOptions{RatingMin: 1, RatingMax: 4000}
+	for _, tc := range []struct {
+		name   string
+		record cute.GameRecord
+		opts   cute.RecordCheckOptions
+		want   []string
+	}{
+		{"ok", cute.GameRecord{GameID: "a", Result: "sente_win", SenteRating: 1500, MoveCount: 2, MoveEvals: evals, EvalComplete: true}, opts, nil},
+		{"unrated", cute.GameRecord{GameID: "a", Result: "draw", MoveCount: 2, MoveEvals: evals, EvalComplete: true}, opts, nil},
+		{"empty id and result", cute.GameRecord{MoveCount: 2, MoveEvals: evals, EvalComplete: true}, opts, []string{cute.ProblemEmptyGameID, cute.ProblemUnknownResult}},
+		{"rating", cute.GameRecord{GameID: "a", Result: "abort", GoteRating: 9999, MoveCount: 2, MoveEvals: evals, EvalComplete: true}, opts, []string{cute.ProblemRatingOutOfRange}},
+		{"no upper bound", cute.GameRecord{GameID: "a", Result: "abort", GoteRating: 9999, MoveCount: 2, MoveEvals: evals, EvalComplete: true}, cute.RecordCheckOptions{}, nil},
+		{"short", cute.GameRecord{GameID: "a", Result: "unknown", MoveCount: 3, MoveEvals: evals, EvalComplete: true}, opts, []string{cute.ProblemEvalCountMismatch}},
+		{"incomplete", cute.GameRecord{GameID: "a", Result: "unknown", MoveCount: 3, MoveEvals: evals}, opts, []string{cute.ProblemEvalIncomplete}},
+		{"sparse", cute.GameRecord{GameID: "a", Result: "unknown", MoveCount: 3, MoveEvals: evals, EvalComplete: true}, cute.RecordCheckOptions{SparseEvals: true}, nil},
+		{"order", cute.GameRecord{GameID: "a", Result: "gote_win", MoveCount: 2, MoveEvals: []cute.MoveEval{evals[1], evals[0]}, EvalComplete: true}, opts, []string{cute.ProblemEvalPlyOrder}},
+		{"past the end", cute.GameRecord{GameID: "a", Result: "gote_win", MoveCount: 1, MoveEvals: evals[1:], EvalComplete: true}, opts, []string{cute.ProblemEvalPlyOrder}},
+		{"score type", cute.GameRecord{GameID: "a", Result: "gote_win", MoveCount: 1, MoveEvals: []cute.MoveEval{{Ply: 1}}, EvalComplete: true}, opts, []string{cute.ProblemUnknownScoreType}},
+	} {
+		if got := cute.CheckGameRecord(tc.record, tc.opts); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
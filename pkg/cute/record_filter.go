@@ -0,0 +1,144 @@
+package cute
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// RecordEnv is the environment of record filter expressions (analyze
+// -record-filter, the filter parameter of serve /analyze).
+//
+// Available fields:
+//
+//	game_id      string
+//	sente_name   string   sente_rating int
+//	gote_name    string   gote_rating  int
+//	rating_diff  int      (sente_rating - gote_rating)
+//	result       string   ("sente_win", "gote_win", "draw", ...)
+//	win_reason   string   (e.g. "投了", "詰み", "時間切れ")
+//	move_count   int
+//	max_eval     int      highest cp eval (sente's perspective, 0 if none)
+//	min_eval     int      lowest cp eval
+//	max_abs_eval int
+//	has_mate     bool     whether any eval is a mate score
+//	sign_flips   int      times the cp eval changed sign
+//	volatility   float    stddev of the eval change between consecutive plies
+//	eval_at_20   int      cp eval at ply 20 (also eval_at_40, eval_at_60;
+//	                      0 when the game is shorter or the score is a mate)
+//	start_time   string   開始日時 as "2006-01-02T15:04:05" ("" if unknown;
+//	                      also end_time)
+//	start_hour   int      hour of start_time (-1 if unknown)
+//	time_control string   持ち時間 (e.g. "早指し2(猶予1分)")
+//	sente_castle string   first castle sente completed ("" if none; also
+//	                      gote_castle)
+//	sente_castle_ply int  ply that completed it (0 if none; also
+//	                      gote_castle_ply)
+//	eval_complete bool    false for a record kept partially evaluated
+//	                      (graph -keep-partial)
+//
+// Examples:
+//
+//	move_count > 80 && win_reason == "投了"
+//	sente_rating >= 1500 && abs(rating_diff) <= 100
+//	max_abs_eval < 2000 && !has_mate
+//	sign_flips >= 3 && volatility > 200
+//	start_hour >= 22 && time_control contains "早指し"
+//	sente_castle == "美濃囲い" && sente_castle_ply <= 30
+type RecordEnv struct {
+	GameID       string  `expr:"game_id"`
+	SenteName    string  `expr:"sente_name"`
+	SenteRating  int     `expr:"sente_rating"`
+	GoteName     string  `expr:"gote_name"`
+	GoteRating   int     `expr:"gote_rating"`
+	RatingDiff   int     `expr:"rating_diff"`
+	Result       string  `expr:"result"`
+	WinReason    string  `expr:"win_reason"`
+	MoveCount    int     `expr:"move_count"`
+	MaxEval      int     `expr:"max_eval"`
+	MinEval      int     `expr:"min_eval"`
+	MaxAbsEval   int     `expr:"max_abs_eval"`
+	HasMate      bool    `expr:"has_mate"`
+	SignFlips    int     `expr:"sign_flips"`
+	Volatility   float64 `expr:"volatility"`
+	EvalAt20     int     `expr:"eval_at_20"`
+	EvalAt40     int     `expr:"eval_at_40"`
+	EvalAt60     int     `expr:"eval_at_60"`
+	StartTime    string  `expr:"start_time"`
+	EndTime      string  `expr:"end_time"`
+	StartHour    int     `expr:"start_hour"`
+	TimeControl  string  `expr:"time_control"`
+	EvalComplete bool    `expr:"eval_complete"`
+
+	SenteCastle    string `expr:"sente_castle"`
+	SenteCastlePly int    `expr:"sente_castle_ply"`
+	GoteCastle     string `expr:"gote_castle"`
+	GoteCastlePly  int    `expr:"gote_castle_ply"`
+}
+
+// NewRecordEnv returns the filter environment of r.
+func NewRecordEnv(r GameRecord) RecordEnv {
+	env := RecordEnv{
+		GameID:       r.GameID,
+		SenteName:    r.SenteName,
+		SenteRating:  int(r.SenteRating),
+		GoteName:     r.GoteName,
+		GoteRating:   int(r.GoteRating),
+		RatingDiff:   int(r.SenteRating - r.GoteRating),
+		Result:       r.Result,
+		WinReason:    r.WinReason,
+		MoveCount:    int(r.MoveCount),
+		StartTime:    r.StartTime,
+		EndTime:      r.EndTime,
+		StartHour:    -1,
+		TimeControl:  r.TimeControl,
+		EvalComplete: r.EvalComplete,
+
+		SenteCastle:    r.SenteCastle,
+		SenteCastlePly: int(r.SenteCastlePly),
+		GoteCastle:     r.GoteCastle,
+		GoteCastlePly:  int(r.GoteCastlePly),
+	}
+	if start, err := time.Parse(RecordTimeLayout, r.StartTime); err == nil {
+		env.StartHour = start.Hour()
+	}
+	for _, eval := range r.MoveEvals {
+		if eval.ScoreType == "mate" {
+			env.HasMate = true
+			break
+		}
+	}
+	t := EvalFeatures(r, EvalFeatureOptions{})
+	env.MaxEval, env.MinEval = t.MaxEval, t.MinEval
+	env.SignFlips, env.Volatility = t.SignFlips, t.Volatility
+	env.EvalAt20, env.EvalAt40, env.EvalAt60 = t.EvalAt[20], t.EvalAt[40], t.EvalAt[60]
+	env.MaxAbsEval = max(env.MaxEval, -env.MinEval)
+	return env
+}
+
+// CompileRecordFilter compiles a record filter expression.
+func CompileRecordFilter(source string) (*vm.Program, error) {
+	program, err := expr.Compile(source, expr.Env(RecordEnv{}), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("invalid record-filter expression: %w", err)
+	}
+	return program, nil
+}
+
+// FilterRecords keeps the records for which program evaluates to true,
+// reusing the backing array of records.
+func FilterRecords(records []GameRecord, program *vm.Program) ([]GameRecord, error) {
+	filtered := records[:0]
+	for _, r := range records {
+		out, err := expr.Run(program, NewRecordEnv(r))
+		if err != nil {
+			return nil, fmt.Errorf("record-filter on %s: %w", r.GameID, err)
+		}
+		if matched, ok := out.(bool); ok && matched {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,45 @@
+package cute_test
+
+import (
+	"slices"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestFilterRecords(t *testing.T) {
+	records := []cute.GameRecord{
+		{GameID: "a", SenteRating: 1500, GoteRating: 1450, MoveCount: 120, WinReason: "投了", StartTime: "2024-01-02T23:10:00"},
+		{GameID: "b", SenteRating: 1500, GoteRating: 1200, MoveCount: 90, WinReason: "投了"},
+		{GameID: "c", MoveCount: 40, MoveEvals: []cute.MoveEval{{Ply: 1, ScoreType: "mate", ScoreValue: 1}}},
+	}
+	for _, tc := range []struct {
+		filter string
+		want   []string
+	}{
+		{`move_count > 80 && abs(rating_diff) <= 100`, []string{"a"}},
+		{`start_hour == 23`, []string{"a"}},
+		{`has_mate`, []string{"c"}},
+		{`win_reason == "投了"`, []string{"a", "b"}},
+	} {
+		program, err := cute.CompileRecordFilter(tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := cute.FilterRecords(append([]cute.GameRecord(nil), records...), program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, r := range got {
+			ids = append(ids, r.GameID)
+		}
+		if !slices.Equal(ids, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.filter, ids, tc.want)
+		}
+	}
+
+	if _, err := cute.CompileRecordFilter(`move_count + "x"`); err == nil {
+		t.Fatal("expected a compile error")
+	}
+}
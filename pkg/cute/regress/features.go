@@ -0,0 +1,270 @@
+// Package regress holds the feature extraction shared by the regression
+// commands (logreg, glm): per-game quantities from sente's perspective and
+// the -features list that turns them into a design matrix row.
+package regress
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	cute "cute/pkg/cute"
+)
+
+// Game holds the per-game quantities that features are computed from,
+// always from sente's perspective.
+type Game struct {
+	RatingDiff     float64 // (sente_rating - gote_rating) / ratingScale
+	RatingCentered float64 // (sente_rating - mean_rating) / ratingScale
+	FirstCrossed   float64 // 1 if sente crossed the threshold first, else 0
+	// NoCrossing marks a game where neither side crossed the threshold;
+	// first_crossed and rating_x_first are unavailable for it.
+	NoCrossing  bool
+	CrossingPly int // ply of the first crossing (0 = unknown)
+	MoveCount   int
+	Evals       []cute.MoveEval // nil when no eval data is available
+	// WinProbScaling > 0 turns eval features into sente win probabilities
+	// (see cute.ScoreToWinProb) instead of units of 100cp.
+	WinProbScaling float64
+}
+
+// NewGame returns the quantities of record with the first crossing cross
+// (see cute.FirstCrossing). Ratings are in units of ratingScale and
+// centered on meanRating, so that the intercept and first_crossed
+// coefficients describe a game at the mean rating rather than at rating 0.
+func NewGame(record cute.GameRecord, cross cute.Crossing, ratingScale, meanRating, winProbScaling float64) Game {
+	g := Game{
+		RatingDiff:     float64(record.SenteRating-record.GoteRating) / ratingScale,
+		RatingCentered: (float64(record.SenteRating) - meanRating) / ratingScale,
+		NoCrossing:     cross.Side == "none",
+		CrossingPly:    cross.Ply,
+		MoveCount:      int(record.MoveCount),
+		Evals:          record.MoveEvals,
+		WinProbScaling: winProbScaling,
+	}
+	if cross.Side == "sente" {
+		g.FirstCrossed = 1
+	}
+	return g
+}
+
+// evalUnit returns a sente-perspective cp eval in feature units.
+func (g Game) evalUnit(cp int) float64 {
+	if g.WinProbScaling > 0 {
+		return cute.ScoreToWinProb(cute.Score{Kind: "cp", Value: cp}, 0, g.WinProbScaling)
+	}
+	return float64(cp) / 100
+}
+
+// Func extracts one feature value. ok=false means the feature is not
+// available for this game (e.g. the game ended before the requested ply),
+// and the game is skipped.
+type Func func(g Game) (float64, bool)
+
+// Feature is a named, compiled feature column.
+type Feature struct {
+	Name string
+	Fn   Func
+}
+
+// baseFeatures are the named quantities accepted by -features. Products of
+// them can be written as "a*b". eval_at_ply_N is handled separately.
+var baseFeatures = map[string]Func{
+	// Rating difference in units of -rating-scale.
+	"rating_diff": func(g Game) (float64, bool) { return g.RatingDiff, true },
+	// Sente rating relative to the dataset mean in units of -rating-scale.
+	"rating_centered": func(g Game) (float64, bool) { return g.RatingCentered, true },
+	"first_crossed":   func(g Game) (float64, bool) { return g.FirstCrossed, !g.NoCrossing },
+	// Interaction of centered rating and first_crossed (the original model term).
+	"rating_x_first": func(g Game) (float64, bool) { return g.RatingCentered * g.FirstCrossed, !g.NoCrossing },
+	// Ply of the first threshold crossing, in units of 100 plies.
+	"crossing_ply": func(g Game) (float64, bool) {
+		if g.CrossingPly <= 0 {
+			return 0, false
+		}
+		return float64(g.CrossingPly) / 100, true
+	},
+	// Total move count, in units of 100 plies.
+	"move_count": func(g Game) (float64, bool) {
+		if g.Evals == nil {
+			return 0, false
+		}
+		return float64(g.MoveCount) / 100, true
+	},
+	// Eval trajectory features (see cute.EvalFeatures); evals in units of
+	// 100cp, or win probabilities with -win-prob.
+	"max_eval": trajectoryFeature(func(t cute.EvalTrajectory, g Game) float64 { return g.evalUnit(t.MaxEval) }),
+	"min_eval": trajectoryFeature(func(t cute.EvalTrajectory, g Game) float64 { return g.evalUnit(t.MinEval) }),
+	// Number of times the eval changed sign.
+	"sign_flips": trajectoryFeature(func(t cute.EvalTrajectory, _ Game) float64 { return float64(t.SignFlips) }),
+	// Standard deviation of the eval change between consecutive plies.
+	"eval_volatility": trajectoryFeature(func(t cute.EvalTrajectory, g Game) float64 {
+		if g.WinProbScaling > 0 {
+			return winProbVolatility(g.Evals, g.WinProbScaling)
+		}
+		return t.Volatility / 100
+	}),
+}
+
+// trajectoryFeature adapts a cute.EvalTrajectory value; games without cp
+// evals are unavailable.
+func trajectoryFeature(value func(t cute.EvalTrajectory, g Game) float64) Func {
+	return func(g Game) (float64, bool) {
+		t := cute.EvalFeatures(cute.GameRecord{MoveEvals: g.Evals}, cute.EvalFeatureOptions{})
+		if t.Evals == 0 {
+			return 0, false
+		}
+		return value(t, g), true
+	}
+}
+
+// winProbVolatility is EvalTrajectory.Volatility on sente win
+// probabilities: the standard deviation of their change between
+// consecutive plies, skipping mate scores.
+func winProbVolatility(evals []cute.MoveEval, scaling float64) float64 {
+	var deltas []float64
+	prevPly, prev := 0, 0.0
+	for _, eval := range evals {
+		if eval.ScoreType != "cp" {
+			prevPly = 0
+			continue
+		}
+		p := eval.WinProb(scaling)
+		if prevPly > 0 && int(eval.Ply) == prevPly+1 {
+			deltas = append(deltas, p-prev)
+		}
+		prevPly, prev = int(eval.Ply), p
+	}
+	if len(deltas) == 0 {
+		return 0
+	}
+	mean := 0.0
+	for _, d := range deltas {
+		mean += d
+	}
+	mean /= float64(len(deltas))
+	variance := 0.0
+	for _, d := range deltas {
+		variance += (d - mean) * (d - mean)
+	}
+	return math.Sqrt(variance / float64(len(deltas)))
+}
+
+// ParseFeatures compiles a comma-separated feature list such as
+// "rating_diff, first_crossed, crossing_ply, eval_at_ply_30".
+func ParseFeatures(spec string) ([]Feature, error) {
+	var features []Feature
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		name := strings.TrimSpace(part)
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate feature: %s", name)
+		}
+		seen[name] = true
+		fn, err := compileFeature(name)
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, Feature{Name: name, Fn: fn})
+	}
+	if len(features) == 0 {
+		return nil, fmt.Errorf("features must be non-empty")
+	}
+	return features, nil
+}
+
+func compileFeature(name string) (Func, error) {
+	if strings.Contains(name, "*") {
+		var factors []Func
+		for _, term := range strings.Split(name, "*") {
+			fn, err := compileFeature(strings.TrimSpace(term))
+			if err != nil {
+				return nil, err
+			}
+			factors = append(factors, fn)
+		}
+		return func(g Game) (float64, bool) {
+			product := 1.0
+			for _, fn := range factors {
+				v, ok := fn(g)
+				if !ok {
+					return 0, false
+				}
+				product *= v
+			}
+			return product, true
+		}, nil
+	}
+	if fn, ok := baseFeatures[name]; ok {
+		return fn, nil
+	}
+	if rest, ok := strings.CutPrefix(name, "eval_at_ply_"); ok {
+		ply, err := strconv.Atoi(rest)
+		if err != nil || ply <= 0 {
+			return nil, fmt.Errorf("invalid ply in feature %s", name)
+		}
+		return evalAtPly(ply), nil
+	}
+	return nil, fmt.Errorf("unknown feature: %s (available: %s, eval_at_ply_N, a*b)", name, strings.Join(Names(), ", "))
+}
+
+// evalAtPly returns the sente-perspective cp eval at ply in units of 100cp.
+// Games shorter than ply or with a mate score there are unavailable. With
+// -win-prob it is sente's win probability, and a mate score is 1 or 0.
+func evalAtPly(ply int) Func {
+	return func(g Game) (float64, bool) {
+		for _, eval := range g.Evals {
+			if int(eval.Ply) != ply {
+				continue
+			}
+			if g.WinProbScaling > 0 && (eval.ScoreType == "cp" || eval.ScoreType == "mate") {
+				return eval.WinProb(g.WinProbScaling), true
+			}
+			if eval.ScoreType != "cp" {
+				return 0, false
+			}
+			return float64(eval.ScoreValue) / 100, true
+		}
+		return 0, false
+	}
+}
+
+// Names returns the base feature names, sorted.
+func Names() []string {
+	names := make([]string, 0, len(baseFeatures))
+	for name := range baseFeatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Vector returns [1 (intercept), f1, f2, ...] or ok=false when any
+// feature is unavailable.
+func Vector(features []Feature, g Game) ([]float64, bool) {
+	x := make([]float64, 0, len(features)+1)
+	x = append(x, 1.0)
+	for _, f := range features {
+		v, ok := f.Fn(g)
+		if !ok {
+			return nil, false
+		}
+		x = append(x, v)
+	}
+	return x, true
+}
+
+// Labels returns the names of the entries of Vector: "intercept" and the
+// feature names.
+func Labels(features []Feature) []string {
+	labels := []string{"intercept"}
+	for _, f := range features {
+		labels = append(labels, f.Name)
+	}
+	return labels
+}
@@ -0,0 +1,83 @@
+package regress_test
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	cute "cute/pkg/cute"
+	"cute/pkg/cute/regress"
+)
+
+func TestVector(t *testing.T) {
+	record := cute.GameRecord{
+		SenteRating: 1600,
+		GoteRating:  1400,
+		MoveCount:   90,
+		MoveEvals: []cute.MoveEval{
+			{Ply: 1, ScoreType: "cp", ScoreValue: 50},
+			{Ply: 2, ScoreType: "cp", ScoreValue: -150},
+			{Ply: 3, ScoreType: "mate", ScoreValue: 5},
+		},
+	}
+	crossed := regress.NewGame(record, cute.Crossing{Side: "sente", Ply: 30}, 100, 1500, 0)
+	uncrossed := regress.NewGame(record, cute.Crossing{Side: "none"}, 100, 1500, 0)
+
+	for _, tc := range []struct {
+		spec string
+		game regress.Game
+		want []float64 // nil = unavailable
+	}{
+		{"rating_diff, rating_centered", crossed, []float64{1, 2, 1}},
+		{"first_crossed,rating_x_first,crossing_ply", crossed, []float64{1, 1, 1, 0.3}},
+		{"rating_diff*move_count", crossed, []float64{1, 1.8}},
+		{"eval_at_ply_2", crossed, []float64{1, -1.5}},
+		// A mate score or a ply past the end has no cp eval.
+		{"eval_at_ply_3", crossed, nil},
+		{"eval_at_ply_4", crossed, nil},
+		{"rating_diff,first_crossed", uncrossed, nil},
+		{"rating_diff", uncrossed, []float64{1, 2}},
+	} {
+		features, err := regress.ParseFeatures(tc.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.spec, err)
+		}
+		got, ok := regress.Vector(features, tc.game)
+		if tc.want == nil {
+			if ok {
+				t.Errorf("%s: got %v, want unavailable", tc.spec, got)
+			}
+			continue
+		}
+		if !ok || !slices.EqualFunc(got, tc.want, func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }) {
+			t.Errorf("%s: got %v (ok=%t), want %v", tc.spec, got, ok, tc.want)
+		}
+		if labels := regress.Labels(features); len(labels) != len(tc.want) || labels[0] != "intercept" {
+			t.Errorf("%s: labels %v", tc.spec, labels)
+		}
+	}
+
+	for _, spec := range []string{"", "rating_diff,rating_diff", "no_such_feature", "eval_at_ply_0", "rating_diff*nope"} {
+		if _, err := regress.ParseFeatures(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestInvertMatrix(t *testing.T) {
+	inv, ok := regress.InvertMatrix([][]float64{{0, 2}, {4, 2}})
+	if !ok {
+		t.Fatal("matrix reported singular")
+	}
+	want := [][]float64{{-0.25, 0.25}, {0.5, 0}}
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(inv[i][j]-want[i][j]) > 1e-12 {
+				t.Fatalf("got %v, want %v", inv, want)
+			}
+		}
+	}
+	if _, ok := regress.InvertMatrix([][]float64{{1, 2}, {2, 4}}); ok {
+		t.Error("singular matrix reported invertible")
+	}
+}
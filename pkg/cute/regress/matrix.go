@@ -0,0 +1,96 @@
+package regress
+
+import "math"
+
+// InvertMatrix inverts a square matrix with Gauss-Jordan elimination and
+// partial pivoting. ok=false when the matrix is (numerically) singular.
+func InvertMatrix(m [][]float64) ([][]float64, bool) {
+	n := len(m)
+	a := make([][]float64, n)
+	for i := range m {
+		a[i] = make([]float64, 2*n)
+		copy(a[i], m[i])
+		a[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		div := a[col][col]
+		for j := range a[col] {
+			a[col][j] /= div
+		}
+		for r := 0; r < n; r++ {
+			if r == col || a[r][col] == 0 {
+				continue
+			}
+			factor := a[r][col]
+			for j := range a[r] {
+				a[r][j] -= factor * a[col][j]
+			}
+		}
+	}
+	inv := make([][]float64, n)
+	for i := range a {
+		inv[i] = a[i][n:]
+	}
+	return inv, true
+}
+
+// SymmetricEigenvalues returns the eigenvalues of a symmetric matrix, by
+// cyclic Jacobi rotations.
+func SymmetricEigenvalues(m [][]float64) []float64 {
+	n := len(m)
+	a := make([][]float64, n)
+	for i := range m {
+		a[i] = append([]float64(nil), m[i]...)
+	}
+	for sweep := 0; sweep < 100; sweep++ {
+		var off float64
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				off += a[p][q] * a[p][q]
+			}
+		}
+		if off < 1e-24 {
+			break
+		}
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				// Rotate rows and columns p and q so that a[p][q] becomes 0.
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < n; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = c*akp - s*akq
+					a[k][q] = s*akp + c*akq
+				}
+				for k := 0; k < n; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = c*apk - s*aqk
+					a[q][k] = s*apk + c*aqk
+				}
+			}
+		}
+	}
+	eigen := make([]float64, n)
+	for i := range eigen {
+		eigen[i] = a[i][i]
+	}
+	return eigen
+}
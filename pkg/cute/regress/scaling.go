@@ -0,0 +1,139 @@
+package regress
+
+import "math"
+
+// Scaling standardizes the feature columns of design matrix rows (as built
+// by Vector) to mean 0 and standard deviation 1. Column 0, the intercept,
+// and constant columns are left as they are (mean 0, scale 1).
+type Scaling struct {
+	Mean  []float64
+	Scale []float64
+}
+
+// Standardize returns the Scaling of rows.
+func Standardize(rows [][]float64) Scaling {
+	k := len(rows[0])
+	s := Scaling{Mean: make([]float64, k), Scale: make([]float64, k)}
+	s.Scale[0] = 1
+	n := float64(len(rows))
+	for j := 1; j < k; j++ {
+		var sum float64
+		for _, x := range rows {
+			sum += x[j]
+		}
+		mean := sum / n
+		var variance float64
+		for _, x := range rows {
+			variance += (x[j] - mean) * (x[j] - mean)
+		}
+		sd := math.Sqrt(variance / n)
+		if sd < 1e-12 {
+			s.Scale[j] = 1
+			continue
+		}
+		s.Mean[j], s.Scale[j] = mean, sd
+	}
+	return s
+}
+
+// Apply returns the standardized copy of row x.
+func (s Scaling) Apply(x []float64) []float64 {
+	out := make([]float64, len(x))
+	for j, v := range x {
+		out[j] = (v - s.Mean[j]) / s.Scale[j]
+	}
+	return out
+}
+
+// Unscale turns coefficients fit on standardized rows into coefficients on
+// the original rows, with the same predictions:
+//
+//	w_j = b_j / scale_j,  w_0 = b_0 - sum_j b_j * mean_j / scale_j
+func (s Scaling) Unscale(b []float64) []float64 {
+	w := make([]float64, len(b))
+	w[0] = b[0]
+	for j := 1; j < len(b); j++ {
+		w[j] = b[j] / s.Scale[j]
+		w[0] -= w[j] * s.Mean[j]
+	}
+	return w
+}
+
+// Rescale is the inverse of Unscale: it turns coefficients on the original
+// rows into coefficients on standardized rows, e.g. to start a
+// standardized fit from a saved model.
+//
+//	b_j = w_j * scale_j,  b_0 = w_0 + sum_j w_j * mean_j
+func (s Scaling) Rescale(w []float64) []float64 {
+	b := make([]float64, len(w))
+	b[0] = w[0]
+	for j := 1; j < len(w); j++ {
+		b[j] = w[j] * s.Scale[j]
+		b[0] += w[j] * s.Mean[j]
+	}
+	return b
+}
+
+// Collinearity describes how close the feature columns are to being linear
+// combinations of each other, which inflates the variance of their
+// coefficients (e.g. an interaction term next to its factors).
+type Collinearity struct {
+	// ConditionNumber is sqrt(largest/smallest eigenvalue) of the feature
+	// correlation matrix: 1 for uncorrelated features, above about 30 for
+	// serious collinearity, +Inf for an exact linear dependency.
+	ConditionNumber float64
+	// VIF[j] is the variance inflation factor of feature column j+1,
+	// 1/(1-R²) of regressing it on the other features; above about 10 its
+	// coefficient is poorly determined. All are +Inf when a column is
+	// constant or an exact linear combination of others.
+	VIF []float64
+}
+
+// Diagnose returns the collinearity of the feature columns of rows (column 0
+// is the intercept and is skipped).
+func Diagnose(rows [][]float64) Collinearity {
+	k := len(rows[0]) - 1
+	c := Collinearity{VIF: make([]float64, k)}
+	if k == 0 {
+		c.ConditionNumber = 1
+		return c
+	}
+	corr := make([][]float64, k)
+	for i := range corr {
+		corr[i] = make([]float64, k)
+	}
+	scaling := Standardize(rows)
+	for _, x := range rows {
+		z := scaling.Apply(x)
+		for i := 0; i < k; i++ {
+			for j := i; j < k; j++ {
+				corr[i][j] += z[i+1] * z[j+1]
+			}
+		}
+	}
+	n := float64(len(rows))
+	for i := 0; i < k; i++ {
+		for j := i; j < k; j++ {
+			corr[i][j] /= n
+			corr[j][i] = corr[i][j]
+		}
+	}
+
+	eigen := SymmetricEigenvalues(corr)
+	lo, hi := math.Inf(1), 0.0
+	for _, e := range eigen {
+		lo, hi = math.Min(lo, e), math.Max(hi, e)
+	}
+	c.ConditionNumber = math.Inf(1)
+	if lo > 1e-12*hi {
+		c.ConditionNumber = math.Sqrt(hi / lo)
+	}
+	inv, ok := InvertMatrix(corr)
+	for j := range c.VIF {
+		c.VIF[j] = math.Inf(1)
+		if ok {
+			c.VIF[j] = inv[j][j]
+		}
+	}
+	return c
+}
@@ -0,0 +1,78 @@
+package regress_test
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"cute/pkg/cute/regress"
+)
+
+func TestStandardize(t *testing.T) {
+	rows := [][]float64{{1, 10, 5}, {1, 20, 5}, {1, 30, 5}, {1, 40, 5}}
+	s := regress.Standardize(rows)
+	sd := math.Sqrt(125)
+	if !slices.Equal(s.Mean, []float64{0, 25, 0}) || math.Abs(s.Scale[1]-sd) > 1e-12 || s.Scale[0] != 1 || s.Scale[2] != 1 {
+		t.Fatalf("got %+v", s)
+	}
+	if got := s.Apply(rows[0]); math.Abs(got[1]+15/sd) > 1e-12 || got[0] != 1 || got[2] != 5 {
+		t.Errorf("Apply: got %v", got)
+	}
+
+	// Unscaled coefficients predict the same as standardized ones.
+	b := []float64{0.5, -2, 0.25}
+	w := s.Unscale(b)
+	for _, x := range rows {
+		var scaled, original float64
+		z := s.Apply(x)
+		for j := range b {
+			scaled += b[j] * z[j]
+			original += w[j] * x[j]
+		}
+		if math.Abs(scaled-original) > 1e-12 {
+			t.Errorf("row %v: standardized prediction %g, original %g", x, scaled, original)
+		}
+	}
+	if got := s.Rescale(w); !slices.EqualFunc(got, b, func(x, y float64) bool { return math.Abs(x-y) < 1e-12 }) {
+		t.Errorf("Rescale(Unscale(b)): got %v, want %v", got, b)
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	// x2 is x1 plus a little noise, x3 is unrelated.
+	var rows [][]float64
+	for i := 0; i < 40; i++ {
+		x1 := float64(i)
+		noise := float64(i%3) - 1
+		x3 := float64((i * 7) % 5)
+		rows = append(rows, []float64{1, x1, x1 + noise, x3})
+	}
+	c := regress.Diagnose(rows)
+	if c.VIF[0] < 10 || c.VIF[1] < 10 {
+		t.Errorf("VIF of the collinear pair: got %v, want > 10", c.VIF)
+	}
+	if c.VIF[2] > 1.5 {
+		t.Errorf("VIF of the unrelated feature: got %g, want about 1", c.VIF[2])
+	}
+	if c.ConditionNumber < 10 {
+		t.Errorf("condition number: got %g, want > 10", c.ConditionNumber)
+	}
+
+	for i := range rows {
+		rows[i][2] = 2 * rows[i][1]
+	}
+	if c := regress.Diagnose(rows); !math.IsInf(c.ConditionNumber, 1) || !math.IsInf(c.VIF[0], 1) {
+		t.Errorf("exact dependency: got %+v", c)
+	}
+}
+
+func TestSymmetricEigenvalues(t *testing.T) {
+	got := regress.SymmetricEigenvalues([][]float64{{2, 1, 0}, {1, 2, 0}, {0, 0, 5}})
+	slices.Sort(got)
+	want := []float64{1, 3, 5}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
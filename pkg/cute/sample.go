@@ -0,0 +1,119 @@
+package cute
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// SampleOptions configures SampleRecords. Exactly one of N, Fraction and
+// PerStratum must be set.
+type SampleOptions struct {
+	// Stratum returns the stratum of a record (e.g. its rating bucket and
+	// result). Nil puts every record in one stratum.
+	Stratum func(GameRecord) string
+	// N draws this many records in total, allocated to the strata in
+	// proportion to their sizes (largest remainder first).
+	N int
+	// Fraction draws this share (0-1] of every stratum, rounded.
+	Fraction float64
+	// PerStratum draws this many records from every stratum (all of a
+	// smaller one), for a sample balanced across strata.
+	PerStratum int
+	Seed       int64
+}
+
+// SampleStratum is the size of a stratum and how many of its records were
+// drawn.
+type SampleStratum struct {
+	Key       string
+	Available int
+	Drawn     int
+}
+
+// SampleRecords draws a stratified random sample of records. The same
+// records and options always give the same sample, which keeps the input
+// order; the strata are returned sorted by key.
+func SampleRecords(records []GameRecord, opts SampleOptions) ([]GameRecord, []SampleStratum, error) {
+	set := 0
+	for _, on := range []bool{opts.N > 0, opts.Fraction > 0, opts.PerStratum > 0} {
+		if on {
+			set++
+		}
+	}
+	if set != 1 || opts.N < 0 || opts.Fraction < 0 || opts.Fraction > 1 || opts.PerStratum < 0 {
+		return nil, nil, errors.New("sample: set exactly one of N, Fraction (0-1] and PerStratum")
+	}
+
+	members := make(map[string][]int)
+	for i, r := range records {
+		key := ""
+		if opts.Stratum != nil {
+			key = opts.Stratum(r)
+		}
+		members[key] = append(members[key], i)
+	}
+	strata := make([]SampleStratum, 0, len(members))
+	for key, idx := range members {
+		strata = append(strata, SampleStratum{Key: key, Available: len(idx)})
+	}
+	sort.Slice(strata, func(i, j int) bool { return strata[i].Key < strata[j].Key })
+
+	switch {
+	case opts.N > 0:
+		allocateProportional(strata, min(opts.N, len(records)))
+	case opts.Fraction > 0:
+		for i := range strata {
+			strata[i].Drawn = int(math.Round(opts.Fraction * float64(strata[i].Available)))
+		}
+	default:
+		for i := range strata {
+			strata[i].Drawn = min(opts.PerStratum, strata[i].Available)
+		}
+	}
+
+	// One generator over the sorted strata keeps the draw reproducible.
+	rng := rand.New(rand.NewSource(opts.Seed))
+	var picked []int
+	for _, st := range strata {
+		idx := members[st.Key]
+		for i := 0; i < st.Drawn; i++ {
+			j := i + rng.Intn(len(idx)-i)
+			idx[i], idx[j] = idx[j], idx[i]
+		}
+		picked = append(picked, idx[:st.Drawn]...)
+	}
+	sort.Ints(picked)
+	sample := make([]GameRecord, len(picked))
+	for i, idx := range picked {
+		sample[i] = records[idx]
+	}
+	return sample, strata, nil
+}
+
+// allocateProportional sets Drawn of the strata to shares of n in
+// proportion to Available: the floor of each quota, then one more for the
+// largest remainders.
+func allocateProportional(strata []SampleStratum, n int) {
+	total := 0
+	for _, st := range strata {
+		total += st.Available
+	}
+	remainders := make([]float64, len(strata))
+	left := n
+	for i := range strata {
+		quota := float64(n) * float64(strata[i].Available) / float64(total)
+		strata[i].Drawn = int(quota)
+		remainders[i] = quota - float64(strata[i].Drawn)
+		left -= strata[i].Drawn
+	}
+	order := make([]int, len(strata))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for _, i := range order[:left] {
+		strata[i].Drawn++
+	}
+}
@@ -0,0 +1,82 @@
+package cute_test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestSampleRecords(t *testing.T) {
+	// 60 sente wins and 30 gote wins.
+	var records []cute.GameRecord
+	for i := 0; i < 90; i++ {
+		result := "sente_win"
+		if i%3 == 2 {
+			result = "gote_win"
+		}
+		records = append(records, cute.GameRecord{GameID: fmt.Sprint(i), Result: result})
+	}
+	byResult := func(r cute.GameRecord) string { return r.Result }
+
+	for _, tc := range []struct {
+		name string
+		opts cute.SampleOptions
+		want map[string]int // drawn games by result
+	}{
+		{"proportional", cute.SampleOptions{Stratum: byResult, N: 10}, map[string]int{"sente_win": 7, "gote_win": 3}},
+		{"fraction", cute.SampleOptions{Stratum: byResult, Fraction: 0.1}, map[string]int{"sente_win": 6, "gote_win": 3}},
+		{"per stratum", cute.SampleOptions{Stratum: byResult, PerStratum: 40}, map[string]int{"sente_win": 40, "gote_win": 30}},
+		{"one stratum", cute.SampleOptions{N: 200}, map[string]int{"sente_win": 60, "gote_win": 30}},
+	} {
+		got, strata, err := cute.SampleRecords(records, tc.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		counts := make(map[string]int)
+		for _, r := range got {
+			counts[r.Result]++
+		}
+		if fmt.Sprint(counts) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, counts, tc.want)
+		}
+		drawn := 0
+		for _, st := range strata {
+			drawn += st.Drawn
+		}
+		if drawn != len(got) {
+			t.Errorf("%s: strata draw %d, sample has %d", tc.name, drawn, len(got))
+		}
+		if !slices.IsSortedFunc(got, func(a, b cute.GameRecord) int {
+			var x, y int
+			fmt.Sscan(a.GameID, &x)
+			fmt.Sscan(b.GameID, &y)
+			return x - y
+		}) {
+			t.Errorf("%s: sample is not in input order", tc.name)
+		}
+	}
+
+	ids := func(seed int64) []string {
+		got, _, err := cute.SampleRecords(records, cute.SampleOptions{Stratum: byResult, N: 10, Seed: seed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, r := range got {
+			out = append(out, r.GameID)
+		}
+		return out
+	}
+	if !slices.Equal(ids(1), ids(1)) {
+		t.Error("same seed gave different samples")
+	}
+	if slices.Equal(ids(1), ids(2)) {
+		t.Error("different seeds gave the same sample")
+	}
+
+	if _, _, err := cute.SampleRecords(records, cute.SampleOptions{N: 10, Fraction: 0.5}); err == nil {
+		t.Error("expected an error with both N and Fraction")
+	}
+}
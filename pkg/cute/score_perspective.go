@@ -0,0 +1,62 @@
+package cute
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ScorePerspective is the side a score is seen from.
+type ScorePerspective string
+
+const (
+	// PerspectiveSente gives every score from sente's point of view: a
+	// positive score favours sente whoever is to move. It is the default
+	// of Session and the convention of GameRecord.MoveEvals.
+	PerspectiveSente ScorePerspective = "sente"
+	// PerspectiveSideToMove gives scores as USI engines report them, from
+	// the point of view of the side to move.
+	PerspectiveSideToMove ScorePerspective = "side_to_move"
+)
+
+// ParseScorePerspective parses a ScorePerspective name; "" is
+// PerspectiveSente.
+func ParseScorePerspective(s string) (ScorePerspective, error) {
+	switch p := ScorePerspective(s); p {
+	case "":
+		return PerspectiveSente, nil
+	case PerspectiveSente, PerspectiveSideToMove:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown score perspective %q (want %s or %s)", s, PerspectiveSente, PerspectiveSideToMove)
+}
+
+// convert turns score, seen from p in the position sfen, into one seen
+// from to.
+func (p ScorePerspective) convert(score Score, sfen string, to ScorePerspective) Score {
+	if p.normal() == to.normal() || sfenTurn(sfen) != "w" {
+		return score
+	}
+	return flipScore(score)
+}
+
+// normal maps "" (files and sessions that predate the setting) to
+// PerspectiveSente.
+func (p ScorePerspective) normal() ScorePerspective {
+	if p == "" {
+		return PerspectiveSente
+	}
+	return p
+}
+
+// sfenTurn returns the side to move of sfen, "b" or "w".
+func sfenTurn(sfen string) string {
+	if fields := strings.Fields(sfen); len(fields) >= 2 {
+		return fields[1]
+	}
+	return "b"
+}
+
+func flipScore(score Score) Score {
+	score.Value = -score.Value
+	return score
+}
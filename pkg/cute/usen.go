@@ -0,0 +1,163 @@
+package cute
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ToUSI returns the game as a USI position command:
+// "position startpos moves ..." for the standard start position, and
+// "position sfen <sfen> moves ..." otherwise.
+func (b *Board) ToUSI() string {
+	if b == nil {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("position ")
+	initial := b.initial.ToSFEN(1)
+	if initial == standardSFEN() {
+		sb.WriteString("startpos")
+	} else {
+		sb.WriteString("sfen ")
+		sb.WriteString(initial)
+	}
+	if len(b.moves) > 0 {
+		sb.WriteString(" moves ")
+		sb.WriteString(strings.Join(b.moves, " "))
+	}
+	return sb.String()
+}
+
+// USEN is a URL-safe game encoding used by web shogi boards:
+//
+//	~<position>.<moves>
+//
+// <position> is "0" for the standard start position, otherwise the SFEN
+// with "/" -> "_", " " -> "." and "+" -> "z". <moves> is the concatenation
+// of one 3-digit base-36 code per move:
+//
+//	code = (from*81 + to)*2 + promote
+//
+// where squares are numbered (rank-1)*9 + (9-file) and a drop uses
+// from = 81 + index of the piece in P, L, N, S, G, B, R.
+
+var usenDropPieces = []string{"P", "L", "N", "S", "G", "B", "R"}
+
+// ToUSEN returns the game in USEN form.
+func (b *Board) ToUSEN() (string, error) {
+	if b == nil {
+		return "", fmt.Errorf("board is nil")
+	}
+	var sb strings.Builder
+	sb.WriteString("~")
+	initial := b.initial.ToSFEN(1)
+	if initial == standardSFEN() {
+		sb.WriteString("0")
+	} else {
+		sb.WriteString(strings.NewReplacer("/", "_", " ", ".", "+", "z").Replace(initial))
+	}
+	sb.WriteString(".")
+	for i, move := range b.moves {
+		code, err := usenMoveCode(move)
+		if err != nil {
+			return "", fmt.Errorf("move %d: %w", i+1, err)
+		}
+		sb.WriteString(code)
+	}
+	return sb.String(), nil
+}
+
+// BoardFromUSEN decodes a USEN string produced by Board.ToUSEN. The moves
+// are replayed to check that they are playable.
+func BoardFromUSEN(usen string) (*Board, error) {
+	body, ok := strings.CutPrefix(usen, "~")
+	if !ok {
+		return nil, fmt.Errorf("usen: missing ~ prefix")
+	}
+	fields := strings.Split(body, ".")
+	var sfen, moveText string
+	switch {
+	case len(fields) >= 2 && fields[0] == "0":
+		sfen, moveText = standardSFEN(), fields[1]
+	case len(fields) >= 5:
+		sfen = strings.NewReplacer("_", "/", "z", "+").Replace(strings.Join(fields[:4], " "))
+		moveText = fields[4]
+	default:
+		return nil, fmt.Errorf("usen: invalid format: %s", usen)
+	}
+	pos, err := parseSFENPosition(sfen)
+	if err != nil {
+		return nil, fmt.Errorf("usen: %w", err)
+	}
+	if len(moveText)%3 != 0 {
+		return nil, fmt.Errorf("usen: move list length %d is not a multiple of 3", len(moveText))
+	}
+	board := &Board{initial: pos.Clone()}
+	for i := 0; i < len(moveText); i += 3 {
+		move, err := usenDecodeMove(moveText[i : i+3])
+		if err != nil {
+			return nil, fmt.Errorf("usen: move %d: %w", i/3+1, err)
+		}
+		if err := pos.ApplyMove(move); err != nil {
+			return nil, fmt.Errorf("usen: move %d: %w", i/3+1, err)
+		}
+		board.moves = append(board.moves, move)
+	}
+	return board, nil
+}
+
+func usenSquare(s square) int {
+	return (s.rank-1)*9 + (9 - s.file)
+}
+
+func usenMoveCode(move string) (string, error) {
+	parsed, err := parseUSIMove(move)
+	if err != nil {
+		return "", err
+	}
+	var from int
+	if parsed.drop {
+		idx := -1
+		for i, p := range usenDropPieces {
+			if p == parsed.piece {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			return "", fmt.Errorf("invalid drop piece: %s", move)
+		}
+		from = 81 + idx
+	} else {
+		from = usenSquare(parsed.from)
+	}
+	code := (from*81 + usenSquare(parsed.to)) * 2
+	if parsed.promote {
+		code++
+	}
+	text := strconv.FormatInt(int64(code), 36)
+	return strings.Repeat("0", 3-len(text)) + text, nil
+}
+
+func usenDecodeMove(text string) (string, error) {
+	code, err := strconv.ParseInt(text, 36, 32)
+	if err != nil {
+		return "", err
+	}
+	promote := code%2 == 1
+	code /= 2
+	from, to := int(code/81), int(code%81)
+	toSq := square{file: 9 - to%9, rank: to/9 + 1}
+	if from >= 81 {
+		if from-81 >= len(usenDropPieces) || promote {
+			return "", fmt.Errorf("invalid drop code: %s", text)
+		}
+		return usenDropPieces[from-81] + "*" + formatSquare(toSq), nil
+	}
+	fromSq := square{file: 9 - from%9, rank: from/9 + 1}
+	move := formatSquare(fromSq) + formatSquare(toSq)
+	if promote {
+		move += "+"
+	}
+	return move, nil
+}
@@ -0,0 +1,84 @@
+package cute_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestBoardToUSI(t *testing.T) {
+	board, err := cute.LoadBoardFromKIF(filepath.Join("testdata", "basic_aigakari.kif"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	usi := board.ToUSI()
+	if !strings.HasPrefix(usi, "position startpos moves 2g2f 8c8d 2f2e") {
+		t.Fatalf("unexpected usi: %s", usi)
+	}
+	if got := len(strings.Fields(usi)) - 3; got != board.MoveCount() {
+		t.Fatalf("unexpected move count in usi: %d", got)
+	}
+
+	empty, err := cute.BoardFromUSEN("~0.")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got := empty.ToUSI(); got != "position startpos" {
+		t.Fatalf("unexpected usi for empty game: %s", got)
+	}
+}
+
+func TestUSENRoundTrip(t *testing.T) {
+	// 7g7f: from (7-1)*9+(9-7)=56, to 47 -> (56*81+47)*2 = 9166 = "72m".
+	board, err := cute.BoardFromUSEN("~0.72m")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got := strings.Join(board.Moves(), " "); got != "7g7f" {
+		t.Fatalf("unexpected moves: %s", got)
+	}
+
+	files, err := filepath.Glob(filepath.Join("testdata", "*.kif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range files {
+		board, err := cute.LoadBoardFromKIF(path)
+		if err != nil {
+			t.Fatalf("%s: load: %v", path, err)
+		}
+		if board.IsFoulEnd() {
+			// The final illegal move cannot be replayed by BoardFromUSEN.
+			continue
+		}
+		usen, err := board.ToUSEN()
+		if err != nil {
+			t.Fatalf("%s: encode: %v", path, err)
+		}
+		if strings.ContainsAny(usen, "/+ ") {
+			t.Fatalf("%s: not URL safe: %s", path, usen)
+		}
+		decoded, err := cute.BoardFromUSEN(usen)
+		if err != nil {
+			t.Fatalf("%s: decode: %v", path, err)
+		}
+		if decoded.ToUSI() != board.ToUSI() {
+			t.Fatalf("%s: round trip mismatch:\ngot  %s\nwant %s", path, decoded.ToUSI(), board.ToUSI())
+		}
+	}
+
+	handicap := "~lnsgkgsnl_1r5b1_ppppppppp_9_9_9_PPPPPPPPP_1B5R1_LNSGKGSN1.w.-.1.31u"
+	board, err = cute.BoardFromUSEN(handicap)
+	if err != nil {
+		t.Fatalf("parse handicap: %v", err)
+	}
+	if got := board.ToUSI(); !strings.HasPrefix(got, "position sfen lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSN1 w - 1 moves ") {
+		t.Fatalf("unexpected handicap usi: %s", got)
+	}
+	again, err := board.ToUSEN()
+	if err != nil || again != handicap {
+		t.Fatalf("handicap round trip: got %s (%v) want %s", again, err, handicap)
+	}
+}
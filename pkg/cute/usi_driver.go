@@ -8,18 +8,16 @@ import (
 	"io"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
-// Engine manages a USI engine process.
+// Engine sends USI commands to an engine over an EngineTransport.
 type Engine struct {
-	cmd    *exec.Cmd
-	stdin  io.WriteCloser
-	stdout io.ReadCloser
-	stderr io.ReadCloser
+	transport EngineTransport
 
 	mu     sync.Mutex
 	closed bool
@@ -32,32 +30,27 @@ func Start(ctx context.Context, path string, args ...string) (*Engine, error) {
 	}
 	cmd := exec.CommandContext(ctx, path, args...)
 	cmd.Dir = filepath.Dir(path)
-	stdin, err := cmd.StdinPipe()
+	transport, err := startProcess(cmd)
 	if err != nil {
 		return nil, err
 	}
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, err
-	}
-	if err := cmd.Start(); err != nil {
-		return nil, err
-	}
-	return &Engine{cmd: cmd, stdin: stdin, stdout: stdout, stderr: stderr}, nil
+	return NewEngine(transport), nil
+}
+
+// NewEngine returns an Engine speaking USI over transport.
+func NewEngine(transport EngineTransport) *Engine {
+	return &Engine{transport: transport}
 }
 
 // Reader returns a protocol reader for engine stdout.
 func (e *Engine) Reader() *Reader {
-	return NewReader(e.stdout)
+	return NewReader(e.transport)
 }
 
-// Stderr returns the stderr stream for the engine process.
+// Stderr returns the stderr stream for the engine process, or nil when
+// the transport has none.
 func (e *Engine) Stderr() io.Reader {
-	return e.stderr
+	return e.transport.Stderr()
 }
 
 // Send sends a single command line to the engine.
@@ -70,11 +63,11 @@ func (e *Engine) Send(line string) error {
 	if !strings.HasSuffix(line, "\n") {
 		line += "\n"
 	}
-	_, err := io.WriteString(e.stdin, line)
+	_, err := io.WriteString(e.transport, line)
 	return err
 }
 
-// Close terminates the engine process.
+// Close sends "quit" and closes the transport.
 func (e *Engine) Close() error {
 	e.mu.Lock()
 	if e.closed {
@@ -87,15 +80,7 @@ func (e *Engine) Close() error {
 	e.mu.Lock()
 	e.closed = true
 	e.mu.Unlock()
-	done := make(chan error, 1)
-	go func() { done <- e.cmd.Wait() }()
-	select {
-	case err := <-done:
-		return err
-	case <-time.After(3 * time.Second):
-		_ = e.cmd.Process.Kill()
-		return errors.New("engine did not exit in time")
-	}
+	return e.transport.Close()
 }
 
 // Reader reads and parses USI protocol lines from the engine.
@@ -135,7 +120,9 @@ func ParseLine(line string) (Event, error) {
 		}
 		return e, nil
 	case "info":
-		return Event{Type: EventInfo, Raw: line}, nil
+		// Malformed values are skipped; the rest of the line is kept.
+		info, _ := ParseInfo(line)
+		return Event{Type: EventInfo, Raw: line, Info: &info}, nil
 	default:
 		return Event{Type: EventUnknown, Raw: line}, nil
 	}
@@ -172,12 +159,17 @@ type Event struct {
 	Move   string
 	Ponder string
 	Raw    string
+	Info   *InfoEvent // parsed info line (EventInfo only)
 }
 
 // Score represents a USI evaluation score.
 type Score struct {
 	Kind  string
 	Value int
+	// Depth and Nodes are the search depth and node count reached for
+	// the score, 0 when unknown (e.g. scores read from an EvalCache).
+	Depth int
+	Nodes int
 }
 
 // String returns a stable text representation for comments/logging.
@@ -193,22 +185,148 @@ func (s Score) String() string {
 
 // Session manages a USI engine session and event stream.
 type Session struct {
-	engine *Engine
-	reader *Reader
-	events chan Event
-	errCh  chan error
+	engine        *Engine
+	reader        *Reader
+	events        chan Event
+	errCh         chan error
+	searchTimeout time.Duration
+	searchHook    func(elapsed time.Duration, err error)
+	perspective   ScorePerspective
+	options       map[string]string
+	info          EngineInfo
+	// readerDone is closed when engine stdout is closed.
+	readerDone chan struct{}
+
+	// busy serializes exchanges with the engine: searches, pondering and
+	// keepalive pings.
+	busy sync.Mutex
+	// ponderSFEN is the position being pondered, "" when not pondering.
+	ponderSFEN   string
+	ponderOption bool
+
+	health        healthState
+	keepaliveMu   sync.Mutex
+	keepaliveStop chan struct{}
+
+	// queue serves QueueSearch.
+	queue searchQueue
+}
+
+// ErrPondering is returned by Search and Ping while the session is
+// pondering; call PonderHit or StopPonder first.
+var ErrPondering = errors.New("engine is pondering")
+
+// ErrSearchTimeout is returned by Search when the engine does not answer
+// with bestmove within the session's search timeout. The engine may still
+// be searching, so the session should be closed and restarted.
+var ErrSearchTimeout = errors.New("engine search timed out")
+
+// SetSearchTimeout bounds how long Search waits for bestmove, in addition
+// to the caller's context. Zero or negative disables the watchdog.
+func (s *Session) SetSearchTimeout(d time.Duration) {
+	s.searchTimeout = d
+}
+
+// SetSearchHook sets fn to be called after every Search and SearchWith
+// with how long it took and its error, e.g. for latency metrics. nil
+// removes the hook. Set it before searching.
+func (s *Session) SetSearchHook(fn func(elapsed time.Duration, err error)) {
+	s.searchHook = fn
+}
+
+// SetScorePerspective sets the perspective of the scores Search reports:
+// PerspectiveSente (the default) turns the engine's side-to-move scores
+// to sente's, PerspectiveSideToMove leaves them as reported. Set it
+// before searching.
+func (s *Session) SetScorePerspective(p ScorePerspective) {
+	s.perspective = p
+}
+
+// ScorePerspective returns the perspective of the scores Search reports.
+func (s *Session) ScorePerspective() ScorePerspective {
+	return s.perspective.normal()
+}
+
+// defaultOptions are the USI options Handshake sets unless SetOptions
+// overrides them.
+var defaultOptions = map[string]string{
+	"FV_SCALE": "36",
+	"Threads":  "1",
+	"USI_Hash": "700",
+}
+
+// SetOptions sets USI options for Handshake to send, overriding the
+// defaults (FV_SCALE 36, Threads 1, USI_Hash 700). Call it before
+// Handshake.
+func (s *Session) SetOptions(options map[string]string) {
+	s.options = options
+}
+
+// EvalOptions select the evaluation function of a YaneuraOu-style engine,
+// so that one binary can be run with different NNUE networks. Empty
+// fields leave the engine's (or the configured) option alone.
+type EvalOptions struct {
+	Dir     string // EvalDir: directory of the evaluation files
+	File    string // EvalFile: file name of the network within Dir
+	FVScale int    // FV_SCALE: scale of the evaluation to centipawns
+	// DrawScore, when set, is what a draw (千日手) is worth in cp from
+	// sente's perspective, the perspective of GameRecord.MoveEvals. It sets
+	// DrawValueBlack to it and DrawValueWhite to its negation, so that the
+	// engine, which scores a draw for the side to move at the root, gives
+	// it the same sente score on either side's move.
+	DrawScore *int
+}
+
+// Apply returns a copy of options with the EvalOptions set over them.
+func (e EvalOptions) Apply(options map[string]string) map[string]string {
+	merged := make(map[string]string, len(options)+5)
+	for name, value := range options {
+		merged[name] = value
+	}
+	if e.Dir != "" {
+		merged["EvalDir"] = e.Dir
+	}
+	if e.File != "" {
+		merged["EvalFile"] = e.File
+	}
+	if e.FVScale > 0 {
+		merged["FV_SCALE"] = strconv.Itoa(e.FVScale)
+	}
+	if e.DrawScore != nil {
+		merged["DrawValueBlack"] = strconv.Itoa(*e.DrawScore)
+		merged["DrawValueWhite"] = strconv.Itoa(-*e.DrawScore)
+	}
+	return merged
 }
 
-// StartSession launches a USI engine and starts a reader goroutine.
+// ErrNotReady is returned (wrapped, with the engine's messages) by
+// Handshake when the engine reports an error while getting ready, such
+// as an evaluation file it cannot load, or exits before readyok.
+var ErrNotReady = errors.New("engine failed isready")
+
+// StartSession launches a USI engine and starts a reader goroutine. path
+// is a local executable, or a remote engine URL (see OpenEngine).
 func StartSession(ctx context.Context, path string, args ...string) (*Session, error) {
-	engine, err := Start(ctx, path, args...)
+	engine, err := OpenEngine(ctx, path, args...)
 	if err != nil {
 		return nil, err
 	}
+	return newSession(engine, engineBinary(path)), nil
+}
+
+// NewSession starts a session speaking USI over transport, e.g. a custom
+// connection to a remote engine. binary names the engine in Info.
+func NewSession(transport EngineTransport, binary string) *Session {
+	return newSession(NewEngine(transport), binary)
+}
+
+func newSession(engine *Engine, binary string) *Session {
 	reader := engine.Reader()
 	events := make(chan Event, 64)
 	errCh := make(chan error, 1)
+	readerDone := make(chan struct{})
 	go func() {
+		defer close(readerDone)
 		defer close(events)
 		for {
 			event, err := reader.Next()
@@ -222,7 +340,21 @@ func StartSession(ctx context.Context, path string, args ...string) (*Session, e
 			events <- event
 		}
 	}()
-	return &Session{engine: engine, reader: reader, events: events, errCh: errCh}, nil
+	info := EngineInfo{Binary: binary}
+	session := &Session{engine: engine, reader: reader, events: events, errCh: errCh, info: info, readerDone: readerDone}
+	session.health.touch()
+	return session
+}
+
+// Info returns what is known about the engine: the binary, the "id"
+// lines reported during Handshake, and the options set by it.
+func (s *Session) Info() EngineInfo {
+	info := s.info
+	info.Options = make(map[string]string, len(s.info.Options))
+	for k, v := range s.info.Options {
+		info.Options[k] = v
+	}
+	return info
 }
 
 // Close terminates the engine process.
@@ -230,10 +362,14 @@ func (s *Session) Close() error {
 	if s == nil || s.engine == nil {
 		return nil
 	}
+	s.StopKeepalive()
+	s.closeQueue()
+	s.health.fail(errSessionClosed)
 	return s.engine.Close()
 }
 
-// Stderr returns the engine's stderr reader for diagnostics.
+// Stderr returns the engine's stderr reader for diagnostics, nil when the
+// transport has none (e.g. TCP).
 func (s *Session) Stderr() io.Reader {
 	if s == nil || s.engine == nil {
 		return nil
@@ -246,72 +382,289 @@ func (s *Session) Handshake(ctx context.Context) error {
 	if err := s.engine.Send("usi"); err != nil {
 		return err
 	}
-	if _, err := s.waitForEvent(ctx, EventUSIOK); err != nil {
-		return err
+	for {
+		event, err := s.nextEvent(ctx)
+		if err != nil {
+			return err
+		}
+		if event.Type == EventID {
+			switch event.Key {
+			case "name":
+				s.info.Name = event.Value
+			case "author":
+				s.info.Author = event.Value
+			}
+		}
+		if event.Type == EventUSIOK {
+			break
+		}
 	}
-	if err := s.engine.Send("setoption name FV_SCALE value 36"); err != nil {
-		return err
+	options := make(map[string]string, len(defaultOptions)+len(s.options))
+	for name, value := range defaultOptions {
+		options[name] = value
 	}
-	if err := s.engine.Send("setoption name Threads value 1"); err != nil {
-		return err
+	for name, value := range s.options {
+		options[name] = value
 	}
-	if err := s.engine.Send("setoption name USI_Hash value 700"); err != nil {
-		return err
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := s.setOption(name, options[name]); err != nil {
+			return err
+		}
+	}
+	if s.ponderOption {
+		if err := s.setOption("USI_Ponder", "true"); err != nil {
+			return err
+		}
 	}
 	if err := s.engine.Send("isready"); err != nil {
 		return err
 	}
-	_, err := s.waitForEvent(ctx, EventReadyOK)
-	return err
+	return s.waitReady(ctx)
+}
+
+// waitReady waits for readyok after isready. Engines report a failure to
+// load their evaluation files with "info string Error! ..." (YaneuraOu)
+// or a bare "Error ..." line and then exit or go on with a broken
+// evaluation; either way the session must not be used.
+func (s *Session) waitReady(ctx context.Context) error {
+	var messages []string
+	for {
+		event, err := s.nextEvent(ctx)
+		if err != nil {
+			if len(messages) > 0 {
+				return fmt.Errorf("%w: %s: %w", ErrNotReady, strings.Join(messages, "; "), err)
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, errStdoutClosed) {
+				return fmt.Errorf("%w: %w", ErrNotReady, err)
+			}
+			return err
+		}
+		text := strings.TrimSpace(event.Raw)
+		if event.Type == EventInfo && event.Info != nil {
+			text = event.Info.String
+		}
+		if (event.Type == EventInfo || event.Type == EventUnknown) && strings.HasPrefix(strings.ToLower(text), "error") {
+			messages = append(messages, text)
+		}
+		if event.Type == EventReadyOK {
+			if len(messages) > 0 {
+				return fmt.Errorf("%w: %s", ErrNotReady, strings.Join(messages, "; "))
+			}
+			return nil
+		}
+	}
+}
+
+func (s *Session) setOption(name, value string) error {
+	if err := s.engine.Send("setoption name " + name + " value " + value); err != nil {
+		return err
+	}
+	if s.info.Options == nil {
+		s.info.Options = make(map[string]string)
+	}
+	s.info.Options[name] = value
+	return nil
+}
+
+// SearchResult is the outcome of a bounded search.
+type SearchResult struct {
+	Score    Score  // last reported score, in the session's ScorePerspective
+	BestMove string // bestmove reported by the engine
+	Depth    int    // last reported search depth (0 if not reported)
+	Nodes    int    // last reported node count (0 if not reported)
+	NPS      int    // last reported nodes per second (0 if not reported)
+	Ponder   string // move the engine expects in reply ("bestmove ... ponder")
+}
+
+// SearchLimit bounds a search by time ("go movetime") or, when Nodes is
+// positive, by node count ("go nodes").
+type SearchLimit struct {
+	MoveTimeMs int
+	Nodes      int
 }
 
 // Evaluate runs a bounded search for the given SFEN position and returns the last score.
 func (s *Session) Evaluate(ctx context.Context, sfen string, moveTimeMs int) (Score, string, error) {
-	cmd := "position sfen " + sfen
-	if err := s.engine.Send(cmd); err != nil {
-		return Score{}, "", err
+	result, err := s.Search(ctx, sfen, moveTimeMs)
+	return result.Score, result.BestMove, err
+}
+
+// Search runs a bounded search for the given SFEN position and returns the
+// last reported score and depth together with the best move.
+func (s *Session) Search(ctx context.Context, sfen string, moveTimeMs int) (SearchResult, error) {
+	return s.SearchWith(ctx, sfen, SearchLimit{MoveTimeMs: moveTimeMs})
+}
+
+// SearchWith is Search with a time or node limit.
+func (s *Session) SearchWith(ctx context.Context, sfen string, limit SearchLimit) (SearchResult, error) {
+	s.busy.Lock()
+	defer s.busy.Unlock()
+	if s.ponderSFEN != "" {
+		return SearchResult{}, ErrPondering
+	}
+	start := time.Now()
+	result, err := s.search(ctx, sfen, limit)
+	if s.searchHook != nil {
+		s.searchHook(time.Since(start), err)
+	}
+	return result, err
+}
+
+func (s *Session) search(ctx context.Context, sfen string, limit SearchLimit) (SearchResult, error) {
+	if err := s.send("position sfen " + sfen); err != nil {
+		return SearchResult{}, err
+	}
+	if err := s.send("go " + limit.goArgs()); err != nil {
+		return SearchResult{}, err
+	}
+	return s.readSearch(ctx, sfen)
+}
+
+func (l SearchLimit) goArgs() string {
+	if l.Nodes > 0 {
+		return fmt.Sprintf("nodes %d", l.Nodes)
+	}
+	return fmt.Sprintf("movetime %d", max(l.MoveTimeMs, 1))
+}
+
+// EnablePonder sets USI_Ponder during Handshake, for engines that only
+// accept "go ponder" with it. It must be called before Handshake.
+func (s *Session) EnablePonder() {
+	s.ponderOption = true
+}
+
+// StartPonder starts a ponder search ("go ponder") on sfen, typically the
+// position after the move predicted by SearchResult.Ponder. The engine
+// keeps searching until PonderHit or StopPonder; limit applies from
+// PonderHit on.
+func (s *Session) StartPonder(sfen string, limit SearchLimit) error {
+	s.busy.Lock()
+	defer s.busy.Unlock()
+	if s.ponderSFEN != "" {
+		return ErrPondering
+	}
+	if err := s.send("position sfen " + sfen); err != nil {
+		return err
 	}
-	if moveTimeMs <= 0 {
-		moveTimeMs = 1
+	if err := s.send("go ponder " + limit.goArgs()); err != nil {
+		return err
+	}
+	s.ponderSFEN = sfen
+	return nil
+}
+
+// PonderHit tells the engine the predicted move was played: the ponder
+// search continues as a normal search and its result is returned.
+func (s *Session) PonderHit(ctx context.Context) (SearchResult, error) {
+	s.busy.Lock()
+	defer s.busy.Unlock()
+	if s.ponderSFEN == "" {
+		return SearchResult{}, errors.New("engine is not pondering")
+	}
+	sfen := s.ponderSFEN
+	s.ponderSFEN = ""
+	if err := s.send("ponderhit"); err != nil {
+		return SearchResult{}, err
+	}
+	return s.readSearch(ctx, sfen)
+}
+
+// StopPonder aborts the ponder search ("stop") and discards the bestmove
+// the engine answers with. It does nothing when not pondering.
+func (s *Session) StopPonder(ctx context.Context) error {
+	s.busy.Lock()
+	defer s.busy.Unlock()
+	if s.ponderSFEN == "" {
+		return nil
 	}
-	if err := s.engine.Send(fmt.Sprintf("go movetime %d", moveTimeMs)); err != nil {
-		return Score{}, "", err
+	s.ponderSFEN = ""
+	if err := s.send("stop"); err != nil {
+		return err
+	}
+	if s.searchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.searchTimeout)
+		defer cancel()
 	}
-	turn := "b"
-	if fields := strings.Fields(sfen); len(fields) >= 2 {
-		turn = fields[1]
+	if _, err := s.waitForEvent(ctx, EventBestMove); err != nil {
+		s.health.fail(err)
+		return err
 	}
+	s.health.touch()
+	return nil
+}
 
-	var score Score
+// send writes a command; a failed write means the engine is gone.
+func (s *Session) send(line string) error {
+	if err := s.engine.Send(line); err != nil {
+		s.health.fail(err)
+		return err
+	}
+	return nil
+}
+
+// readSearch reads the engine output of a search on sfen up to bestmove.
+func (s *Session) readSearch(ctx context.Context, sfen string) (SearchResult, error) {
+	parent := ctx
+	if s.searchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.searchTimeout)
+		defer cancel()
+	}
+
+	var result SearchResult
 	haveScore := false
 	for {
 		event, err := s.nextEvent(ctx)
 		if err != nil {
-			return Score{}, "", err
+			if parent.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("%w after %s", ErrSearchTimeout, s.searchTimeout)
+			}
+			// The engine is dead or still searching; either way it
+			// cannot take the next command.
+			s.health.fail(err)
+			return SearchResult{}, err
 		}
 		switch event.Type {
 		case EventInfo:
-			if parsed, ok := parseInfoScore(event.Raw); ok {
-				score = parsed
+			info := event.Info
+			if info == nil {
+				continue
+			}
+			// With MultiPV only the best line carries the score.
+			if info.Score.Kind != "" && info.MultiPV <= 1 {
+				result.Score = info.Score
 				haveScore = true
 			}
+			if info.Depth > 0 {
+				result.Depth = info.Depth
+			}
+			if info.Nodes > 0 {
+				result.Nodes = info.Nodes
+			}
+			if info.NPS > 0 {
+				result.NPS = info.NPS
+			}
 		case EventBestMove:
+			s.health.touch()
+			result.BestMove = event.Move
+			result.Ponder = event.Ponder
 			if !haveScore {
-				return Score{}, event.Move, errors.New("no score in engine output")
-			}
-			if turn == "w" {
-				score = flipScore(score)
+				return SearchResult{BestMove: event.Move}, errors.New("no score in engine output")
 			}
-			return score, event.Move, nil
+			result.Score = PerspectiveSideToMove.convert(result.Score, sfen, s.perspective)
+			result.Score.Depth = result.Depth
+			result.Score.Nodes = result.Nodes
+			return result, nil
 		}
 	}
 }
 
-func flipScore(score Score) Score {
-	score.Value = -score.Value
-	return score
-}
-
 func (s *Session) waitForEvent(ctx context.Context, want EventType) (Event, error) {
 	for {
 		event, err := s.nextEvent(ctx)
@@ -330,32 +683,13 @@ func (s *Session) nextEvent(ctx context.Context) (Event, error) {
 		return Event{}, ctx.Err()
 	case err := <-s.errCh:
 		if err == nil {
-			return Event{}, errors.New("engine stdout closed")
+			return Event{}, errStdoutClosed
 		}
 		return Event{}, err
 	case event, ok := <-s.events:
 		if !ok {
-			return Event{}, errors.New("engine stdout closed")
+			return Event{}, errStdoutClosed
 		}
 		return event, nil
 	}
 }
-
-func parseInfoScore(line string) (Score, bool) {
-	fields := strings.Fields(line)
-	for i := 0; i+2 < len(fields); i++ {
-		if fields[i] != "score" {
-			continue
-		}
-		kind := fields[i+1]
-		value, err := strconv.Atoi(fields[i+2])
-		if err != nil {
-			return Score{}, false
-		}
-		if kind != "cp" && kind != "mate" {
-			return Score{}, false
-		}
-		return Score{Kind: kind, Value: value}, true
-	}
-	return Score{}, false
-}
@@ -136,3 +136,189 @@ func waitForEvent(ctx context.Context, events <-chan usi.Event, errCh <-chan err
 		}
 	}
 }
+
+func TestSessionSearchTimeout(t *testing.T) {
+	// An engine that acknowledges the handshake but never answers "go".
+	enginePath := writeEngineScript(t, `#!/bin/sh
+while read line; do
+  case "$line" in
+    usi) echo "usiok";;
+    isready) echo "readyok";;
+    quit) exit 0;;
+  esac
+done
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := usi.StartSession(ctx, enginePath)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer session.Close()
+	if err := session.Handshake(ctx); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+
+	session.SetSearchTimeout(100 * time.Millisecond)
+	var hookErrs []error
+	session.SetSearchHook(func(elapsed time.Duration, err error) {
+		if elapsed < 100*time.Millisecond {
+			t.Errorf("hook elapsed = %s", elapsed)
+		}
+		hookErrs = append(hookErrs, err)
+	})
+	start := time.Now()
+	_, err = session.Search(ctx, "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1", 1)
+	if !errors.Is(err, usi.ErrSearchTimeout) {
+		t.Fatalf("expected ErrSearchTimeout, got %v", err)
+	}
+	if len(hookErrs) != 1 || !errors.Is(hookErrs[0], usi.ErrSearchTimeout) {
+		t.Fatalf("search hook got %v", hookErrs)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("search timeout must be distinguishable from the caller's deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("timeout took too long: %s", elapsed)
+	}
+}
+
+func TestSessionInfo(t *testing.T) {
+	enginePath := writeEngineScript(t, `#!/bin/sh
+while read line; do
+  case "$line" in
+    usi) echo "id name FakeEngine 1.2.3"; echo "id author Someone"; echo "usiok";;
+    isready) echo "readyok";;
+    quit) exit 0;;
+  esac
+done
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := usi.StartSession(ctx, enginePath)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer session.Close()
+	if err := session.Handshake(ctx); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+
+	info := session.Info()
+	if info.Binary != "fake-engine.sh" || info.Name != "FakeEngine 1.2.3" || info.Author != "Someone" {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	if info.FVScale() != "36" {
+		t.Fatalf("FV_SCALE = %q, want 36", info.FVScale())
+	}
+
+	// Configured options override the defaults.
+	session, err = usi.StartSession(ctx, enginePath)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer session.Close()
+	session.SetOptions(map[string]string{"FV_SCALE": "24", "MultiPV": "2"})
+	if err := session.Handshake(ctx); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	info = session.Info()
+	if info.FVScale() != "24" || info.Options["MultiPV"] != "2" || info.Options["USI_Hash"] != "700" {
+		t.Fatalf("options = %v", info.Options)
+	}
+
+	// EvalOptions select the network over the configured options.
+	session, err = usi.StartSession(ctx, enginePath)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer session.Close()
+	configured := map[string]string{"EvalFile": "old.bin", "FV_SCALE": "24"}
+	session.SetOptions(usi.EvalOptions{Dir: "eval", File: "nn.bin", FVScale: 16}.Apply(configured))
+	if err := session.Handshake(ctx); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	info = session.Info()
+	if info.EvalFile() != "eval/nn.bin" || info.FVScale() != "16" || configured["EvalFile"] != "old.bin" {
+		t.Fatalf("options = %v (configured %v)", info.Options, configured)
+	}
+}
+
+func TestHandshakeNotReady(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, tc := range []struct {
+		name, isready, want string
+	}{
+		// YaneuraOu reports an unreadable network and still answers.
+		{"eval error", `echo "info string Error! : failed to read eval/nn.bin"; echo "readyok"`, "nn.bin"},
+		{"exit", `echo "Error! open evaluation file failed."; exit 1`, "open evaluation file failed"},
+		{"silent exit", `exit 1`, ""},
+	} {
+		enginePath := writeEngineScript(t, `#!/bin/sh
+while read line; do
+  case "$line" in
+    usi) echo "usiok";;
+    isready) `+tc.isready+`;;
+    quit) exit 0;;
+  esac
+done
+`)
+		session, err := usi.StartSession(ctx, enginePath)
+		if err != nil {
+			t.Fatalf("%s: start: %v", tc.name, err)
+		}
+		err = session.Handshake(ctx)
+		session.Close()
+		if !errors.Is(err, usi.ErrNotReady) || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want ErrNotReady with %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestSessionSearchWithNodes(t *testing.T) {
+	// An engine that echoes the go command's limit back as the node count.
+	enginePath := writeEngineScript(t, `#!/bin/sh
+while read line; do
+  case "$line" in
+    usi) echo "usiok";;
+    isready) echo "readyok";;
+    "go nodes "*) echo "info depth 5 score cp -30 nodes ${line#go nodes } nps 250000"; echo "bestmove 7g7f";;
+    go*) echo "info depth 3 score cp -30 nodes 1"; echo "bestmove 7g7f";;
+    quit) exit 0;;
+  esac
+done
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := usi.StartSession(ctx, enginePath)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer session.Close()
+	if err := session.Handshake(ctx); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+
+	sfen := "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL w - 1"
+	result, err := session.SearchWith(ctx, sfen, usi.SearchLimit{Nodes: 5000})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if result.Nodes != 5000 || result.NPS != 250000 || result.Depth != 5 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	// Scores are reported from sente's perspective.
+	if result.Score.Value != 30 {
+		t.Fatalf("score = %v, want cp 30", result.Score)
+	}
+	session.SetScorePerspective(usi.PerspectiveSideToMove)
+	if result, err := session.SearchWith(ctx, sfen, usi.SearchLimit{Nodes: 1}); err != nil || result.Score.Value != -30 {
+		t.Fatalf("side-to-move score = %v, %v; want cp -30", result.Score, err)
+	}
+	session.SetScorePerspective(usi.PerspectiveSente)
+	result, err = session.SearchWith(ctx, sfen, usi.SearchLimit{MoveTimeMs: 10})
+	if err != nil || result.Nodes != 1 || result.NPS != 0 {
+		t.Fatalf("movetime search: %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,107 @@
+package cute
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// InfoEvent is a parsed USI "info" line. Fields the engine did not report
+// are zero; Score.Kind is "" without a score.
+type InfoEvent struct {
+	Depth    int
+	SelDepth int
+	Time     int // ms
+	Nodes    int
+	NPS      int
+	MultiPV  int
+	HashFull int // per mille
+	CurrMove string
+	Score    Score // from the side to move, as reported
+	// Bound is "lowerbound" or "upperbound" when the score is a bound.
+	Bound string
+	PV    []string
+	// String is the free text of "info string".
+	String string
+}
+
+// infoIntKeys are the integer-valued info keys.
+var infoIntKeys = map[string]func(*InfoEvent) *int{
+	"depth":    func(e *InfoEvent) *int { return &e.Depth },
+	"seldepth": func(e *InfoEvent) *int { return &e.SelDepth },
+	"time":     func(e *InfoEvent) *int { return &e.Time },
+	"nodes":    func(e *InfoEvent) *int { return &e.Nodes },
+	"nps":      func(e *InfoEvent) *int { return &e.NPS },
+	"multipv":  func(e *InfoEvent) *int { return &e.MultiPV },
+	"hashfull": func(e *InfoEvent) *int { return &e.HashFull },
+}
+
+// ParseInfo parses an "info" line. Malformed values are skipped and the
+// rest of the line is still parsed; the first problem is returned as the
+// error together with what could be parsed. "pv" and "string" take the
+// rest of the line.
+func ParseInfo(line string) (InfoEvent, error) {
+	var info InfoEvent
+	var firstErr error
+	bad := func(format string, args ...any) {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("info: "+format, args...)
+		}
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "info" {
+		return info, fmt.Errorf("not an info line: %q", line)
+	}
+	for i := 1; i < len(fields); i++ {
+		key := fields[i]
+		if field, ok := infoIntKeys[key]; ok {
+			if i+1 >= len(fields) {
+				bad("%s without value", key)
+				break
+			}
+			i++
+			v, err := strconv.Atoi(fields[i])
+			if err != nil {
+				bad("%s %q", key, fields[i])
+				continue
+			}
+			*field(&info) = v
+			continue
+		}
+		switch key {
+		case "currmove":
+			if i+1 < len(fields) {
+				i++
+				info.CurrMove = fields[i]
+			}
+		case "score":
+			if i+2 >= len(fields) {
+				bad("incomplete score")
+				i = len(fields)
+				break
+			}
+			kind, value := fields[i+1], fields[i+2]
+			i += 2
+			v, err := strconv.Atoi(value)
+			if (kind != "cp" && kind != "mate") || err != nil {
+				bad("score %s %s", kind, value)
+				continue
+			}
+			info.Score = Score{Kind: kind, Value: v}
+			if i+1 < len(fields) && (fields[i+1] == "lowerbound" || fields[i+1] == "upperbound") {
+				i++
+				info.Bound = fields[i]
+			}
+		case "pv":
+			info.PV = append([]string(nil), fields[i+1:]...)
+			i = len(fields)
+		case "string":
+			// Keep the original spacing of the text.
+			if idx := strings.Index(line, " string "); idx >= 0 {
+				info.String = line[idx+len(" string "):]
+			}
+			i = len(fields)
+		}
+	}
+	return info, firstErr
+}
@@ -0,0 +1,50 @@
+package cute_test
+
+import (
+	"reflect"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestParseInfo(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    cute.InfoEvent
+		wantErr bool
+	}{
+		{
+			line: "info depth 12 seldepth 18 score cp -35 upperbound nodes 123456 nps 987654 time 125 hashfull 12 multipv 1 pv 7g7f 3c3d 2g2f",
+			want: cute.InfoEvent{Depth: 12, SelDepth: 18, Nodes: 123456, NPS: 987654, Time: 125, HashFull: 12, MultiPV: 1,
+				Score: cute.Score{Kind: "cp", Value: -35}, Bound: "upperbound", PV: []string{"7g7f", "3c3d", "2g2f"}},
+		},
+		{
+			line: "info depth 3 currmove 2g2f score mate -5",
+			want: cute.InfoEvent{Depth: 3, CurrMove: "2g2f", Score: cute.Score{Kind: "mate", Value: -5}},
+		},
+		{
+			line: "info string  eval file loaded",
+			want: cute.InfoEvent{String: " eval file loaded"},
+		},
+		{
+			// The bad depth is reported but the score is kept.
+			line:    "info depth x score cp 10 nodes",
+			want:    cute.InfoEvent{Score: cute.Score{Kind: "cp", Value: 10}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := cute.ParseInfo(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v", tt.line, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q:\ngot  %+v\nwant %+v", tt.line, got, tt.want)
+		}
+	}
+
+	event, err := cute.ParseLine("info depth 7 score cp 42 pv 7g7f")
+	if err != nil || event.Type != cute.EventInfo || event.Info == nil || event.Info.Depth != 7 {
+		t.Fatalf("ParseLine: %+v, %v", event, err)
+	}
+}
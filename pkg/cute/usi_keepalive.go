@@ -0,0 +1,139 @@
+package cute
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errSessionClosed = errors.New("session is closed")
+	errStdoutClosed  = errors.New("engine stdout closed")
+)
+
+// SessionHealth is a snapshot of a session's liveness.
+type SessionHealth struct {
+	// Alive is false once the engine exited, failed a ping or a search
+	// timed out (it may still be searching). Such a session should be
+	// closed and replaced.
+	Alive bool
+	// Err is why the session is not alive.
+	Err error
+	// LastActive is the last time the engine answered a search or ping.
+	LastActive time.Time
+	// PingLatency is the round trip of the last successful Ping (0 before
+	// the first).
+	PingLatency time.Duration
+}
+
+// healthState is the mutable part of SessionHealth.
+type healthState struct {
+	mu          sync.Mutex
+	err         error
+	lastActive  time.Time
+	pingLatency time.Duration
+}
+
+func (h *healthState) touch() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastActive = time.Now()
+}
+
+// fail records the first error that made the session unusable.
+func (h *healthState) fail(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err == nil {
+		h.err = err
+	}
+}
+
+// Health reports whether the session can still be used. It does not talk
+// to the engine; see Ping and StartKeepalive.
+func (s *Session) Health() SessionHealth {
+	select {
+	case <-s.readerDone:
+		s.health.fail(errStdoutClosed)
+	default:
+	}
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	return SessionHealth{
+		Alive:       s.health.err == nil,
+		Err:         s.health.err,
+		LastActive:  s.health.lastActive,
+		PingLatency: s.health.pingLatency,
+	}
+}
+
+// Ping sends "isready" and waits for "readyok". A failure marks the
+// session dead. It returns ErrPondering while pondering.
+func (s *Session) Ping(ctx context.Context) error {
+	s.busy.Lock()
+	defer s.busy.Unlock()
+	return s.ping(ctx)
+}
+
+func (s *Session) ping(ctx context.Context) error {
+	if s.ponderSFEN != "" {
+		return ErrPondering
+	}
+	start := time.Now()
+	if err := s.send("isready"); err != nil {
+		return err
+	}
+	if _, err := s.waitForEvent(ctx, EventReadyOK); err != nil {
+		s.health.fail(err)
+		return err
+	}
+	s.health.mu.Lock()
+	s.health.lastActive = time.Now()
+	s.health.pingLatency = time.Since(start)
+	s.health.mu.Unlock()
+	return nil
+}
+
+// StartKeepalive pings the engine in the background whenever it has been
+// idle for interval, so an engine that died silently between evaluations
+// shows up in Health before the next search. Each ping waits at most
+// timeout. Pings never interrupt a search or a ponder. Close stops it.
+func (s *Session) StartKeepalive(interval, timeout time.Duration) {
+	s.StopKeepalive()
+	stop := make(chan struct{})
+	s.keepaliveMu.Lock()
+	s.keepaliveStop = stop
+	s.keepaliveMu.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if !s.busy.TryLock() {
+				continue
+			}
+			health := s.Health()
+			if health.Alive && s.ponderSFEN == "" && time.Since(health.LastActive) >= interval {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				_ = s.ping(ctx)
+				cancel()
+			}
+			s.busy.Unlock()
+		}
+	}()
+}
+
+// StopKeepalive stops the pings started by StartKeepalive.
+func (s *Session) StopKeepalive() {
+	s.keepaliveMu.Lock()
+	defer s.keepaliveMu.Unlock()
+	if s.keepaliveStop != nil {
+		close(s.keepaliveStop)
+		s.keepaliveStop = nil
+	}
+}
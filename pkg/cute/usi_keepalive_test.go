@@ -0,0 +1,156 @@
+package cute_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	cute "cute/pkg/cute"
+)
+
+func TestSessionPonder(t *testing.T) {
+	// The ponder search only ends on ponderhit or stop.
+	enginePath := writeEngineScript(t, `#!/bin/sh
+while read line; do
+  case "$line" in
+    usi) echo "usiok";;
+    isready) echo "readyok";;
+    "setoption name USI_Ponder value true") echo "info string ponder on";;
+    "go ponder"*) echo "info depth 1 score cp 10";;
+    ponderhit) echo "info depth 9 score cp 120 nodes 900"; echo "bestmove 3c3d ponder 2g2f";;
+    stop) echo "bestmove resign";;
+    go*) echo "info depth 7 score cp 42"; echo "bestmove 7g7f ponder 3c3d";;
+    quit) exit 0;;
+  esac
+done
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := cute.StartSession(ctx, enginePath)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer session.Close()
+	session.EnablePonder()
+	if err := session.Handshake(ctx); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	if session.Info().Options["USI_Ponder"] != "true" {
+		t.Fatalf("USI_Ponder not set: %+v", session.Info().Options)
+	}
+
+	startpos := "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1"
+	result, err := session.Search(ctx, startpos, 10)
+	if err != nil || result.Ponder != "3c3d" {
+		t.Fatalf("search: %+v, %v", result, err)
+	}
+
+	after := "lnsgkgsnl/1r5b1/pppppp1pp/6p2/9/2P6/PP1PPPPPP/1B5R1/LNSGKGSNL b - 3"
+	if err := session.StartPonder(after, cute.SearchLimit{MoveTimeMs: 10}); err != nil {
+		t.Fatalf("ponder: %v", err)
+	}
+	if _, err := session.Search(ctx, startpos, 10); !errors.Is(err, cute.ErrPondering) {
+		t.Fatalf("search while pondering: %v", err)
+	}
+	result, err = session.PonderHit(ctx)
+	if err != nil || result.BestMove != "3c3d" || result.Score.Value != 120 || result.Depth != 9 {
+		t.Fatalf("ponderhit: %+v, %v", result, err)
+	}
+
+	if err := session.StartPonder(after, cute.SearchLimit{}); err != nil {
+		t.Fatalf("ponder: %v", err)
+	}
+	if err := session.StopPonder(ctx); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	// The discarded bestmove must not leak into the next search.
+	if result, err := session.Search(ctx, startpos, 10); err != nil || result.BestMove != "7g7f" {
+		t.Fatalf("search after stop: %+v, %v", result, err)
+	}
+	if !session.Health().Alive {
+		t.Fatalf("unexpected health: %+v", session.Health())
+	}
+}
+
+func TestSessionKeepalive(t *testing.T) {
+	// Answers the handshake and one search, then hangs without exiting.
+	enginePath := writeEngineScript(t, `#!/bin/sh
+ready=0
+while read line; do
+  case "$line" in
+    usi) echo "usiok";;
+    isready) if [ $ready = 0 ]; then echo "readyok"; ready=1; fi;;
+    go*) echo "info depth 7 score cp 42"; echo "bestmove 7g7f";;
+    quit) exit 0;;
+  esac
+done
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := cute.StartSession(ctx, enginePath)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer session.Close()
+	if err := session.Handshake(ctx); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	if _, err := session.Search(ctx, "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1", 1); err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if !session.Health().Alive {
+		t.Fatal("session should be alive after a search")
+	}
+
+	session.StartKeepalive(20*time.Millisecond, 50*time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for session.Health().Alive {
+		if time.Now().After(deadline) {
+			t.Fatal("keepalive did not detect the hung engine")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if h := session.Health(); !errors.Is(h.Err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected health: %+v", h)
+	}
+}
+
+func TestSessionHealthAfterExit(t *testing.T) {
+	enginePath := writeEngineScript(t, `#!/bin/sh
+while read line; do
+  case "$line" in
+    usi) echo "usiok";;
+    isready) echo "readyok"; exit 0;;
+  esac
+done
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pool, err := cute.NewEnginePool(ctx, 1, enginePath)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	defer pool.Close()
+	session, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for session.Health().Alive {
+		if time.Now().After(deadline) {
+			t.Fatal("exit not detected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	pool.Release(session)
+	// The pool replaces the dead session on the next Acquire.
+	fresh, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if fresh == session {
+		t.Fatal("dead session was handed out again")
+	}
+	pool.Release(fresh)
+}
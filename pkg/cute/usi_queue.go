@@ -0,0 +1,161 @@
+package cute
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrQueueFull is returned by QueueSearch when the session's queue already
+// holds the limit set by SetQueueLimit.
+var ErrQueueFull = errors.New("engine search queue is full")
+
+// queueKey identifies searches that can share one engine search.
+type queueKey struct {
+	sfen  string
+	limit SearchLimit
+}
+
+// queuedSearch is a search waiting in or taken from the queue.
+type queuedSearch struct {
+	key     queueKey
+	waiters int // callers still waiting; guarded by searchQueue.mu
+	done    chan struct{}
+	result  SearchResult
+	err     error
+}
+
+// searchQueue feeds QueueSearch callers to one worker goroutine.
+type searchQueue struct {
+	mu      sync.Mutex
+	pending []*queuedSearch
+	byKey   map[queueKey]*queuedSearch // pending searches, for batching
+	limit   int
+	closed  bool
+	wake    chan struct{}
+	cancel  context.CancelFunc // cancels the worker's search on Close
+	started bool
+}
+
+// SetQueueLimit bounds the number of distinct searches waiting in the
+// queue of QueueSearch; beyond it QueueSearch fails fast with ErrQueueFull,
+// e.g. for an HTTP server to answer 503 instead of piling up requests.
+// Zero or negative means no limit.
+func (s *Session) SetQueueLimit(n int) {
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+	s.queue.limit = n
+}
+
+// QueueLen returns the number of distinct searches waiting in the queue,
+// not counting the one being searched.
+func (s *Session) QueueLen() int {
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+	return len(s.queue.pending)
+}
+
+// QueueSearch is SearchWith for callers on many goroutines sharing one
+// session: searches go through an internal queue and run one at a time in
+// arrival order. Callers asking for the same position and limit while a
+// search for it is still waiting are batched into that search and all get
+// its result.
+//
+// ctx only bounds the wait: a caller whose ctx ends gets ctx.Err(), and a
+// queued search nobody waits for any more is dropped, but a search that
+// already started runs to bestmove (within the search timeout) so the
+// engine stays usable.
+func (s *Session) QueueSearch(ctx context.Context, sfen string, limit SearchLimit) (SearchResult, error) {
+	q := &s.queue
+	key := queueKey{sfen: sfen, limit: limit}
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return SearchResult{}, errSessionClosed
+	}
+	if !q.started {
+		q.started = true
+		q.wake = make(chan struct{}, 1)
+		q.byKey = make(map[queueKey]*queuedSearch)
+		var workerCtx context.Context
+		workerCtx, q.cancel = context.WithCancel(context.Background())
+		go s.runQueue(workerCtx)
+	}
+	search := q.byKey[key]
+	if search == nil {
+		if q.limit > 0 && len(q.pending) >= q.limit {
+			q.mu.Unlock()
+			return SearchResult{}, ErrQueueFull
+		}
+		search = &queuedSearch{key: key, done: make(chan struct{})}
+		q.pending = append(q.pending, search)
+		q.byKey[key] = search
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	search.waiters++
+	q.mu.Unlock()
+
+	select {
+	case <-search.done:
+		return search.result, search.err
+	case <-ctx.Done():
+		q.mu.Lock()
+		search.waiters--
+		q.mu.Unlock()
+		return SearchResult{}, ctx.Err()
+	}
+}
+
+// runQueue runs the queued searches until closeQueue.
+func (s *Session) runQueue(ctx context.Context) {
+	q := &s.queue
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 && !q.closed {
+			q.mu.Unlock()
+			select {
+			case <-q.wake:
+			case <-ctx.Done():
+			}
+			q.mu.Lock()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		search := q.pending[0]
+		q.pending = q.pending[1:]
+		delete(q.byKey, search.key)
+		abandoned := search.waiters == 0
+		q.mu.Unlock()
+
+		if abandoned {
+			search.err = context.Canceled
+		} else {
+			search.result, search.err = s.SearchWith(ctx, search.key.sfen, search.key.limit)
+		}
+		close(search.done)
+	}
+}
+
+// closeQueue fails the waiting searches and stops the worker.
+func (s *Session) closeQueue() {
+	q := &s.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	for _, search := range q.pending {
+		search.err = errSessionClosed
+		close(search.done)
+	}
+	q.pending, q.byKey = nil, nil
+	if q.cancel != nil {
+		q.cancel()
+	}
+}
@@ -0,0 +1,138 @@
+package cute_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	cute "cute/pkg/cute"
+)
+
+func TestSessionQueueSearch(t *testing.T) {
+	// Every search takes a while and reports how many searches ran so far
+	// as its score. A search of "slow" touches started and waits for
+	// release.
+	dir := t.TempDir()
+	started, release := filepath.Join(dir, "started"), filepath.Join(dir, "release")
+	enginePath := writeEngineScript(t, fmt.Sprintf(`#!/bin/sh
+n=0
+slow=0
+while read line; do
+  case "$line" in
+    usi) echo "usiok";;
+    isready) echo "readyok";;
+    "position sfen slow"*) slow=1;;
+    position*) slow=0;;
+    go*)
+      n=$((n+1))
+      if [ $slow = 1 ]; then
+        touch %[1]q
+        while [ ! -e %[2]q ]; do sleep 0.01; done
+      else
+        sleep 0.3
+      fi
+      echo "info depth 1 score cp $n"; echo "bestmove 7g7f";;
+    quit) exit 0;;
+  esac
+done
+`, started, release))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	session, err := cute.StartSession(ctx, enginePath)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer session.Close()
+	if err := session.Handshake(ctx); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+
+	limit := cute.SearchLimit{MoveTimeMs: 10}
+	first := make(chan cute.SearchResult, 1)
+	go func() {
+		result, _ := session.QueueSearch(ctx, "first b - 1", limit)
+		first <- result
+	}()
+	// While the first search runs, many callers ask for two positions.
+	time.Sleep(50 * time.Millisecond)
+	var wg sync.WaitGroup
+	scores := make(map[string][]int)
+	var mu sync.Mutex
+	for i := 0; i < 10; i++ {
+		sfen := []string{"second b - 1", "third b - 1"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := session.QueueSearch(ctx, sfen, limit)
+			if err != nil {
+				t.Errorf("%s: %v", sfen, err)
+				return
+			}
+			mu.Lock()
+			scores[sfen] = append(scores[sfen], result.Score.Value)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if result := <-first; result.Score.Value != 1 {
+		t.Errorf("first search: got score %d, want 1", result.Score.Value)
+	}
+	// Each position was searched once for all its callers.
+	seen := make(map[int]bool)
+	for sfen, values := range scores {
+		if len(values) != 5 {
+			t.Fatalf("%s: %d results, want 5", sfen, len(values))
+		}
+		for _, v := range values[1:] {
+			if v != values[0] {
+				t.Errorf("%s: callers got different searches %v", sfen, values)
+			}
+		}
+		seen[values[0]] = true
+	}
+	if len(seen) != 2 || !seen[2] || !seen[3] {
+		t.Errorf("got searches %v, want 2 and 3", seen)
+	}
+
+	// A caller whose context ends stops waiting.
+	canceled, cancelWait := context.WithCancel(ctx)
+	cancelWait()
+	if _, err := session.QueueSearch(canceled, "fourth b - 1", limit); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller: got %v", err)
+	}
+
+	// With the engine held in a search, one more search fills the queue.
+	go session.QueueSearch(ctx, "slow b - 1", limit)
+	waitFor(t, func() bool { _, err := os.Stat(started); return err == nil })
+	session.SetQueueLimit(1)
+	go session.QueueSearch(ctx, "fifth b - 1", limit)
+	waitFor(t, func() bool { return session.QueueLen() == 1 })
+	if _, err := session.QueueSearch(ctx, "sixth b - 1", limit); !errors.Is(err, cute.ErrQueueFull) {
+		t.Errorf("full queue: got %v", err)
+	}
+	if err := os.WriteFile(release, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	session.Close()
+	if _, err := session.QueueSearch(ctx, "first b - 1", limit); err == nil {
+		t.Error("search after Close succeeded")
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after 5 seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,192 @@
+package cute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EngineTransport is the byte stream an Engine speaks USI over: a local
+// engine process, a TCP connection to an engine server, or an engine run
+// on another host over ssh (see OpenEngine).
+type EngineTransport interface {
+	io.Reader // engine output
+	io.Writer // engine input
+	// Stderr returns the engine's diagnostic output, or nil when the
+	// transport has none.
+	Stderr() io.Reader
+	// Close releases the transport after "quit" was sent, giving the
+	// engine a moment to exit.
+	Close() error
+}
+
+// engineExitTimeout is how long Close waits for an engine to exit after
+// "quit" before killing it.
+const engineExitTimeout = 3 * time.Second
+
+// OpenEngine starts the engine at path. Besides a local executable, path
+// may be a remote engine URL:
+//
+//	tcp://host:port            an engine server that speaks USI on the
+//	                           socket (e.g. socat TCP-LISTEN:4000,fork
+//	                           EXEC:/path/to/engine); args are not allowed
+//	ssh://[user@]host[:port]/path/to/engine
+//	                           the engine run with args over ssh, in its
+//	                           own directory as with a local engine
+//
+// ssh runs non-interactively (BatchMode), so it needs key authentication.
+func OpenEngine(ctx context.Context, path string, args ...string) (*Engine, error) {
+	if !IsRemoteEngine(path) {
+		return Start(ctx, path, args...)
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("engine %s: %w", path, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("engine %s: no host", path)
+	}
+	switch u.Scheme {
+	case "tcp":
+		if len(args) > 0 {
+			return nil, fmt.Errorf("engine %s: a tcp engine takes no arguments", path)
+		}
+		transport, err := dialTCP(ctx, u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return NewEngine(transport), nil
+	default: // ssh
+		if u.Path == "" || u.Path == "/" {
+			return nil, fmt.Errorf("engine %s: no engine path", path)
+		}
+		transport, err := startProcess(sshCommand(ctx, u, args))
+		if err != nil {
+			return nil, err
+		}
+		return NewEngine(transport), nil
+	}
+}
+
+// IsRemoteEngine reports whether an engine path is a tcp:// or ssh:// URL
+// rather than a local executable.
+func IsRemoteEngine(path string) bool {
+	return strings.HasPrefix(path, "tcp://") || strings.HasPrefix(path, "ssh://")
+}
+
+// engineBinary names the engine at path in EngineInfo: the executable's
+// base name, or the address of a tcp engine.
+func engineBinary(enginePath string) string {
+	if strings.HasPrefix(enginePath, "tcp://") {
+		return strings.TrimPrefix(enginePath, "tcp://")
+	}
+	if u, err := url.Parse(enginePath); err == nil && u.Scheme == "ssh" {
+		return path.Base(u.Path)
+	}
+	return filepath.Base(enginePath)
+}
+
+// sshCommand runs the engine at u's path on u's host, from the engine's
+// directory.
+func sshCommand(ctx context.Context, u *url.URL, args []string) *exec.Cmd {
+	sshArgs := []string{"-T", "-o", "BatchMode=yes"}
+	if port := u.Port(); port != "" {
+		sshArgs = append(sshArgs, "-p", port)
+	}
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	remote := []string{"cd", shellQuote(path.Dir(u.Path)), "&&", "exec", shellQuote(u.Path)}
+	for _, arg := range args {
+		remote = append(remote, shellQuote(arg))
+	}
+	sshArgs = append(sshArgs, host, "--", strings.Join(remote, " "))
+	return exec.CommandContext(ctx, "ssh", sshArgs...)
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// processTransport talks to an engine process over its standard streams.
+type processTransport struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr io.ReadCloser
+}
+
+func startProcess(cmd *exec.Cmd) (*processTransport, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &processTransport{cmd: cmd, stdin: stdin, stdout: stdout, stderr: stderr}, nil
+}
+
+func (p *processTransport) Read(b []byte) (int, error)  { return p.stdout.Read(b) }
+func (p *processTransport) Write(b []byte) (int, error) { return p.stdin.Write(b) }
+func (p *processTransport) Stderr() io.Reader           { return p.stderr }
+
+// Close waits for the process to exit and kills it if it does not.
+func (p *processTransport) Close() error {
+	done := make(chan error, 1)
+	go func() { done <- p.cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(engineExitTimeout):
+		_ = p.cmd.Process.Kill()
+		return errors.New("engine did not exit in time")
+	}
+}
+
+// tcpTransport talks to an engine server over a TCP connection.
+type tcpTransport struct {
+	net.Conn
+	stop func() bool // stops closing the connection when ctx ends
+}
+
+// dialTCP connects to addr. The connection is closed when ctx ends, as an
+// engine process started with ctx is killed.
+func dialTCP(ctx context.Context, addr string) (*tcpTransport, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return &tcpTransport{Conn: conn, stop: stop}, nil
+}
+
+func (t *tcpTransport) Stderr() io.Reader { return nil }
+
+// Close lets the server read "quit" and closes the connection.
+func (t *tcpTransport) Close() error {
+	t.stop()
+	if tcp, ok := t.Conn.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	}
+	return t.Conn.Close()
+}
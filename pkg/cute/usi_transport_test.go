@@ -0,0 +1,116 @@
+package cute_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cute "cute/pkg/cute"
+)
+
+func TestSessionOverTCP(t *testing.T) {
+	enginePath := writeFakeEngine(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("listen: %v", err)
+	}
+	defer listener.Close()
+	// Serve the engine on the socket, as socat EXEC would.
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		cmd := exec.Command(enginePath)
+		cmd.Stdin, cmd.Stdout = conn, conn
+		_ = cmd.Run()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	url := "tcp://" + listener.Addr().String()
+	if _, err := cute.StartSession(ctx, url, "arg"); err == nil {
+		t.Fatal("expected an error for tcp engine arguments")
+	}
+	session, err := cute.StartSession(ctx, url)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if err := session.Handshake(ctx); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	result, err := session.Search(ctx, "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1", 10)
+	if err != nil || result.Score.Value != 42 || result.BestMove != "7g7f" {
+		t.Fatalf("search: %+v, %v", result, err)
+	}
+	if info := session.Info(); info.Name != "fake" || info.Binary != listener.Addr().String() {
+		t.Errorf("info: %+v", info)
+	}
+	if session.Stderr() != nil {
+		t.Error("tcp session has a stderr stream")
+	}
+	if err := session.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+}
+
+func TestSessionOverSSH(t *testing.T) {
+	// The engine reports its first argument as its name.
+	enginePath := writeEngineScript(t, `#!/bin/sh
+while read line; do
+  case "$line" in
+    usi) echo "id name $1"; echo "usiok";;
+    isready) echo "readyok";;
+    go*) echo "info depth 7 score cp 42"; echo "bestmove 7g7f";;
+    quit) exit 0;;
+  esac
+done
+`)
+	// A fake ssh records its arguments and runs the remote command locally.
+	bin := t.TempDir()
+	sshScript := `#!/bin/sh
+echo "$@" > "$(dirname "$0")/args"
+while [ "$1" != "--" ]; do shift; done
+exec sh -c "$2"
+`
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte(sshScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := cute.StartSession(ctx, "ssh://alice@gpu.example:2222"+enginePath, "it's fake")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer session.Close()
+	if err := session.Handshake(ctx); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	if info := session.Info(); info.Name != "it's fake" || info.Binary != "fake-engine.sh" {
+		t.Errorf("info: %+v", info)
+	}
+	if _, err := session.Search(ctx, "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1", 10); err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	args, err := os.ReadFile(filepath.Join(bin, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(args), "-p 2222 alice@gpu.example --") {
+		t.Errorf("ssh arguments: %s", args)
+	}
+
+	for _, bad := range []string{"ssh://gpu.example", "tcp:///engine"} {
+		if _, err := cute.StartSession(ctx, bad); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
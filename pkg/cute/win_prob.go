@@ -0,0 +1,52 @@
+package cute
+
+import "math"
+
+// DefaultWinProbScaling is the cp scale of ScoreToWinProb commonly used for
+// shogi engines: a 600cp lead is a win probability of about 73%.
+const DefaultWinProbScaling = 600.0
+
+// ScoreToWinProb converts a score to the win probability of the side the
+// score is for (sente for MoveEval scores) with the logistic curve
+// 1/(1+exp(-cp/scaling)). A mate score is 1 or 0 for the mating side; "mate
+// 0" has no sign, so, as in crossings, the side to move after ply is taken
+// as mated (sente moves after even plies). A scaling <= 0 means
+// DefaultWinProbScaling. Other score kinds are 0.5.
+func ScoreToWinProb(score Score, ply int, scaling float64) float64 {
+	if scaling <= 0 {
+		scaling = DefaultWinProbScaling
+	}
+	switch score.Kind {
+	case "cp":
+		return 1 / (1 + math.Exp(-float64(score.Value)/scaling))
+	case "mate":
+		if mateSide(MoveEval{Ply: int32(ply), ScoreValue: int32(score.Value)}) == "sente" {
+			return 1
+		}
+		return 0
+	}
+	return 0.5
+}
+
+// WinProb returns ScoreToWinProb of the eval: sente's win probability.
+func (e MoveEval) WinProb(scaling float64) float64 {
+	return ScoreToWinProb(Score{Kind: e.ScoreType, Value: int(e.ScoreValue)}, int(e.Ply), scaling)
+}
+
+// WinProbToCP is the inverse of ScoreToWinProb for cp scores: the smallest
+// whole cp score whose win probability is at least p (0 < p < 1), so that a
+// win-probability threshold can be used wherever a cp threshold is.
+func WinProbToCP(p, scaling float64) int {
+	if scaling <= 0 {
+		scaling = DefaultWinProbScaling
+	}
+	cp := int(math.Ceil(scaling * math.Log(p/(1-p))))
+	// Rounding in Log can put the boundary one cp off.
+	for ScoreToWinProb(Score{Kind: "cp", Value: cp - 1}, 0, scaling) >= p {
+		cp--
+	}
+	for ScoreToWinProb(Score{Kind: "cp", Value: cp}, 0, scaling) < p {
+		cp++
+	}
+	return cp
+}
@@ -0,0 +1,49 @@
+package cute_test
+
+import (
+	"math"
+	"testing"
+
+	cute "cute/pkg/cute"
+)
+
+func TestScoreToWinProb(t *testing.T) {
+	for _, tc := range []struct {
+		score   cute.Score
+		ply     int
+		scaling float64
+		want    float64
+	}{
+		{cute.Score{Kind: "cp", Value: 0}, 10, 600, 0.5},
+		{cute.Score{Kind: "cp", Value: 600}, 10, 600, 1 / (1 + math.Exp(-1))},
+		{cute.Score{Kind: "cp", Value: -600}, 10, 0, 1 / (1 + math.Exp(1))}, // default scaling
+		{cute.Score{Kind: "cp", Value: 300}, 10, 300, 1 / (1 + math.Exp(-1))},
+		{cute.Score{Kind: "mate", Value: 5}, 10, 600, 1},
+		{cute.Score{Kind: "mate", Value: -3}, 10, 600, 0},
+		{cute.Score{Kind: "mate", Value: 0}, 11, 600, 1}, // gote to move is mated
+		{cute.Score{Kind: "mate", Value: 0}, 12, 600, 0},
+		{cute.Score{Kind: "none"}, 10, 600, 0.5},
+	} {
+		if got := cute.ScoreToWinProb(tc.score, tc.ply, tc.scaling); math.Abs(got-tc.want) > 1e-12 {
+			t.Errorf("%v at ply %d (scaling %g): got %g, want %g", tc.score, tc.ply, tc.scaling, got, tc.want)
+		}
+	}
+	eval := cute.MoveEval{Ply: 3, ScoreType: "cp", ScoreValue: 600}
+	if got, want := eval.WinProb(600), 1/(1+math.Exp(-1)); math.Abs(got-want) > 1e-12 {
+		t.Errorf("MoveEval.WinProb: got %g, want %g", got, want)
+	}
+}
+
+func TestWinProbToCP(t *testing.T) {
+	for _, p := range []float64{0.51, 0.6, 0.7, 0.75, 0.8, 0.9, 0.99} {
+		for _, scaling := range []float64{300, 600, 1000} {
+			cp := cute.WinProbToCP(p, scaling)
+			if got := cute.ScoreToWinProb(cute.Score{Kind: "cp", Value: cp}, 0, scaling); got < p {
+				t.Errorf("p=%g scaling=%g: %dcp is %g", p, scaling, cp, got)
+			}
+			if got := cute.ScoreToWinProb(cute.Score{Kind: "cp", Value: cp - 1}, 0, scaling); got >= p {
+				t.Errorf("p=%g scaling=%g: %dcp is already %g", p, scaling, cp-1, got)
+			}
+		}
+	}
+}